@@ -0,0 +1,175 @@
+package ddd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrAPIKeyNotFound is returned by every APIKeyRepository for an unknown or expired key, or another account's
+var ErrAPIKeyNotFound = errors.New("api key doesn't exist or expired")
+
+const (
+	// APIKeyPrefix starts every key, secret scanners and the auth middleware tell keys from jwts by it
+	APIKeyPrefix = "ddd_"
+	// MaxAPIKeyNameLength is in characters
+	MaxAPIKeyNameLength = 100
+)
+
+// the scopes of an api key, a key without APIKeyScopeWrite can only read
+// APIKeyScopeAdmin is only granted to an admin's keys, admin routes need it on top of the account being an admin
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+	APIKeyScopeAdmin = "admin"
+)
+
+// APIKey is a named, long lived credential of an account for scripts and other machine clients
+type APIKey struct {
+	// ID is the key's public prefix, APIKeyPrefix and 16 hex characters, the key is looked up by it
+	ID           string
+	Organization string
+	Email        string
+	Name         string
+	Scopes       []string
+	CreatedAt    time.Time
+	// ExpiresAt is nil for a key that doesn't expire
+	ExpiresAt *time.Time
+	// LastUsedAt and LastUsedIP are empty until the key authenticated a request
+	LastUsedAt *time.Time
+	LastUsedIP string
+	// Key is only set on the key CreateAPIKey returns, the repository keeps its hash
+	Key string
+}
+
+// HasScope is true when the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// every method must give up and return ctx.Err() once the context is cancelled or past its deadline
+// GetAPIKey isn't scoped to the context's tenant, a request only has the key, ListAPIKeys and DeleteAPIKey are
+// deleting the account deletes its keys
+type APIKeyRepository interface {
+	// CreateAPIKey returns ErrUserNotFound without the account
+	CreateAPIKey(context.Context, APIKeyCreate) (*APIKey, error)
+	// GetAPIKey returns the key's account and scopes, ErrAPIKeyNotFound when the key is unknown, wrong or expired
+	GetAPIKey(ctx context.Context, key string) (*APIKey, error)
+	// ListAPIKeys returns the account's keys ordered by creation, expired keys included
+	ListAPIKeys(ctx context.Context, email string) ([]*APIKey, error)
+	// DeleteAPIKey returns ErrAPIKeyNotFound when the account doesn't have the key
+	DeleteAPIKey(ctx context.Context, email string, id string) error
+	// TouchAPIKey records that the key authenticated a request from ip now
+	TouchAPIKey(ctx context.Context, id string, ip string) error
+}
+
+type APIKeyCreate struct {
+	Organization string
+	Email        string
+	Name         string
+	Scopes       []string
+	// ExpiresAt is nil for a key that doesn't expire
+	ExpiresAt *time.Time
+}
+
+func (kc APIKeyCreate) Validate() error {
+	if err := ValidateOrganizationID(kc.Organization); err != nil {
+		return err
+	}
+
+	if err := ValidateEmail(kc.Email); err != nil {
+		return err
+	}
+
+	if kc.Name == "" {
+		return errors.New("name was empty")
+	}
+
+	if !utf8.ValidString(kc.Name) || utf8.RuneCountInString(kc.Name) > MaxAPIKeyNameLength {
+		return fmt.Errorf("name isn't utf-8 of at most %d characters", MaxAPIKeyNameLength)
+	}
+
+	if len(kc.Scopes) == 0 {
+		return errors.New("scopes was empty")
+	}
+
+	seen := map[string]bool{}
+	for _, scope := range kc.Scopes {
+		if scope != APIKeyScopeRead && scope != APIKeyScopeWrite && scope != APIKeyScopeAdmin {
+			return fmt.Errorf("scope isn't read, write or admin: %s", scope)
+		}
+
+		if seen[scope] {
+			return fmt.Errorf("scope was repeated: %s", scope)
+		}
+		seen[scope] = true
+	}
+
+	if kc.ExpiresAt != nil && !kc.ExpiresAt.After(time.Now()) {
+		return errors.New("expiresAt isn't in the future")
+	}
+
+	return nil
+}
+
+// NewAPIKey generates the key, the id is its first 20 characters
+func (kc APIKeyCreate) NewAPIKey(now time.Time) (*APIKey, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	secret, err := newOAuthSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		ID:           APIKeyPrefix + hex.EncodeToString(id),
+		Organization: kc.Organization,
+		Email:        kc.Email,
+		Name:         kc.Name,
+		Scopes:       append([]string{}, kc.Scopes...),
+		CreatedAt:    now.UTC().Truncate(time.Microsecond),
+	}
+	key.Key = key.ID + "_" + secret
+
+	if kc.ExpiresAt != nil {
+		expiresAt := kc.ExpiresAt.UTC().Truncate(time.Microsecond)
+		key.ExpiresAt = &expiresAt
+	}
+
+	return key, nil
+}
+
+// apiKeyIDLength is APIKeyPrefix and 8 hex encoded bytes
+var apiKeyIDLength = len(APIKeyPrefix) + 16
+
+// APIKeyID returns the id of a key, empty when it isn't formatted like one
+func APIKeyID(key string) string {
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) <= apiKeyIDLength+1 || key[apiKeyIDLength] != '_' {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(key[len(APIKeyPrefix):apiKeyIDLength]); err != nil {
+		return ""
+	}
+
+	if _, err := base64.RawURLEncoding.DecodeString(key[apiKeyIDLength+1:]); err != nil {
+		return ""
+	}
+
+	return key[:apiKeyIDLength]
+}
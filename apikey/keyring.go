@@ -0,0 +1,169 @@
+// Package apikey creates the personal api keys of accounts and authenticates the requests of machine clients with them
+package apikey
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// UsageInterval is how often a key's last use is written, a script making many requests doesn't write on each one
+const UsageInterval = time.Minute
+
+var (
+	// ErrInvalidKey doesn't say whether the key is unknown, wrong, expired or deleted
+	ErrInvalidKey = errors.New("api key is invalid or expired")
+	ErrAdminScope = errors.New("only admins can create api keys with the admin scope")
+)
+
+type KeyringOpts struct {
+	Repository ddd.APIKeyRepository
+	// Users looks up the key's account, it has to share the keys' storage
+	// it mustn't be a cache (cache.NewUserRepository), a disabled or demoted account's keys would keep working until its entry expires
+	Users ddd.UserRepository
+	// Now is time.Now when nil
+	Now func() time.Time
+}
+
+// Keyring creates, lists and deletes an account's keys and authenticates requests with them
+type Keyring struct {
+	repo  ddd.APIKeyRepository
+	users ddd.UserRepository
+	now   func() time.Time
+}
+
+func NewKeyring(
+	opts KeyringOpts,
+) (
+	*Keyring,
+	error,
+) {
+	if opts.Repository == nil {
+		return nil, errors.New("repository was nil")
+	}
+
+	if opts.Users == nil {
+		return nil, errors.New("users was nil")
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &Keyring{
+		repo:  opts.Repository,
+		users: opts.Users,
+		now:   opts.Now,
+	}, nil
+}
+
+// Create returns the new key with its Key set, it's only shown once
+// the admin scope is only granted to admins
+func (k *Keyring) Create(
+	ctx context.Context,
+	opts ddd.APIKeyCreate,
+) (
+	*ddd.APIKey,
+	error,
+) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	user, err := k.users.Get(ddd.WithTenant(ctx, opts.Organization), opts.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Admin {
+		for _, scope := range opts.Scopes {
+			if scope == ddd.APIKeyScopeAdmin {
+				return nil, ErrAdminScope
+			}
+		}
+	}
+
+	return k.repo.CreateAPIKey(ctx, opts)
+}
+
+// List returns the context's account's keys, without their Key
+func (k *Keyring) List(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.APIKey,
+	error,
+) {
+	return k.repo.ListAPIKeys(ctx, email)
+}
+
+func (k *Keyring) Delete(
+	ctx context.Context,
+	email string,
+	id string,
+) error {
+	return k.repo.DeleteAPIKey(ctx, email, id)
+}
+
+// Authenticate returns the key of a request and records its use from ip
+// it returns ErrInvalidKey for a key that doesn't authenticate and ddd.ErrUserDisabled when its account was disabled
+func (k *Keyring) Authenticate(
+	ctx context.Context,
+	key string,
+	ip string,
+) (
+	*ddd.APIKey,
+	error,
+) {
+	if ddd.APIKeyID(key) == "" {
+		return nil, ErrInvalidKey
+	}
+
+	got, err := k.repo.GetAPIKey(ctx, key)
+	if errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidKey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := k.users.Get(ddd.WithTenant(ctx, got.Organization), got.Email)
+	if errors.Is(err, ddd.ErrUserNotFound) {
+		return nil, ErrInvalidKey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, ddd.ErrUserDisabled
+	}
+
+	// the admin scope doesn't outlive the account's admin role
+	if !user.Admin && got.HasScope(ddd.APIKeyScopeAdmin) {
+		scopes := []string{}
+		for _, scope := range got.Scopes {
+			if scope != ddd.APIKeyScopeAdmin {
+				scopes = append(scopes, scope)
+			}
+		}
+		got.Scopes = scopes
+	}
+
+	now := k.now()
+	if got.LastUsedAt == nil || now.Sub(*got.LastUsedAt) >= UsageInterval || got.LastUsedIP != ip {
+		if err := k.repo.TouchAPIKey(ctx, got.ID, ip); err != nil {
+			return nil, err
+		}
+
+		lastUsedAt := now.UTC().Truncate(time.Microsecond)
+		got.LastUsedAt = &lastUsedAt
+		got.LastUsedIP = ip
+	}
+
+	return got, nil
+}
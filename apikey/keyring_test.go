@@ -0,0 +1,139 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+func newKeyring(t *testing.T, now *time.Time) (*mock.UserRepository, *Keyring) {
+	t.Helper()
+
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{Email: "jackson@juandefu.ca", Password: ddd.HashPassword("pass")},
+		ddd.User{Email: "admin@juandefu.ca", Password: ddd.HashPassword("pass"), Admin: true},
+	)
+
+	k, err := NewKeyring(KeyringOpts{
+		Repository: mockUsers,
+		Users:      mockUsers,
+		Now: func() time.Time {
+			return *now
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create keyring: %s", err)
+	}
+
+	return mockUsers, k
+}
+
+func newAPIKeyCreate(email string, scopes ...string) ddd.APIKeyCreate {
+	return ddd.APIKeyCreate{
+		Organization: ddd.DefaultOrganization,
+		Email:        email,
+		Name:         "Deploys",
+		Scopes:       scopes,
+	}
+}
+
+func TestCreate_AdminScope(t *testing.T) {
+	now := time.Now()
+	_, k := newKeyring(t, &now)
+
+	if _, err := k.Create(context.Background(), newAPIKeyCreate("jackson@juandefu.ca", ddd.APIKeyScopeRead, ddd.APIKeyScopeAdmin)); !errors.Is(err, ErrAdminScope) {
+		t.Errorf("expected ErrAdminScope, got: %v", err)
+	}
+
+	if _, err := k.Create(context.Background(), newAPIKeyCreate("admin@juandefu.ca", ddd.APIKeyScopeRead, ddd.APIKeyScopeAdmin)); err != nil {
+		t.Errorf("failed to create admin api key: %s", err)
+	}
+
+	if _, err := k.Create(context.Background(), newAPIKeyCreate("jackson@sabey.co", ddd.APIKeyScopeRead)); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Now()
+	mockUsers, k := newKeyring(t, &now)
+	ctx := context.Background()
+
+	key, err := k.Create(ctx, newAPIKeyCreate("jackson@juandefu.ca", ddd.APIKeyScopeRead))
+	if err != nil {
+		t.Fatalf("failed to create api key: %s", err)
+	}
+
+	got, err := k.Authenticate(ctx, key.Key, "203.0.113.7")
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+
+	if got.ID != key.ID || got.Email != "jackson@juandefu.ca" || got.LastUsedIP != "203.0.113.7" || got.LastUsedAt == nil {
+		t.Errorf("unknown api key: %+v", got)
+	}
+
+	// the use is only written once per interval from the same ip
+	if _, err := k.Authenticate(ctx, key.Key, "203.0.113.7"); err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodTouchAPIKey); len(calls) != 1 {
+		t.Errorf("expected one touch, got: %v", calls)
+	}
+
+	if _, err := k.Authenticate(ctx, key.Key, "198.51.100.2"); err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+
+	now = now.Add(UsageInterval + time.Second)
+
+	if _, err := k.Authenticate(ctx, key.Key, "198.51.100.2"); err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodTouchAPIKey); len(calls) != 3 {
+		t.Errorf("expected three touches, got: %v", calls)
+	}
+
+	for _, invalid := range []string{"", ddd.SignJWTClaims("jackson@juandefu.ca"), key.ID, key.Key[:len(key.Key)-1] + "A"} {
+		if _, err := k.Authenticate(ctx, invalid, "203.0.113.7"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey for %q, got: %v", invalid, err)
+		}
+	}
+
+	if _, err := mockUsers.Update(ctx, ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(true)}); err != nil {
+		t.Fatalf("failed to disable user: %s", err)
+	}
+
+	if _, err := k.Authenticate(ctx, key.Key, "203.0.113.7"); !errors.Is(err, ddd.ErrUserDisabled) {
+		t.Errorf("expected ErrUserDisabled, got: %v", err)
+	}
+}
+
+func TestAuthenticate_AdminRevoked(t *testing.T) {
+	now := time.Now()
+	mockUsers, k := newKeyring(t, &now)
+	ctx := context.Background()
+
+	key, err := k.Create(ctx, newAPIKeyCreate("admin@juandefu.ca", ddd.APIKeyScopeRead, ddd.APIKeyScopeAdmin))
+	if err != nil {
+		t.Fatalf("failed to create api key: %s", err)
+	}
+
+	mockUsers.Seed(ddd.User{Email: "admin@juandefu.ca", Password: ddd.HashPassword("pass")})
+
+	got, err := k.Authenticate(ctx, key.Key, "203.0.113.7")
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+
+	if got.HasScope(ddd.APIKeyScopeAdmin) || !got.HasScope(ddd.APIKeyScopeRead) {
+		t.Errorf("unknown scopes: %v", got.Scopes)
+	}
+}
@@ -0,0 +1,214 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+
+	// the formats accepted on upload, everything is re-encoded as png
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/sabey/ddd"
+)
+
+// Sizes are the square sizes, in pixels, every avatar is stored at
+var Sizes = []int{64, 128, 256}
+
+const (
+	DefaultSize = 256
+	// MaxBytes is the largest upload accepted
+	MaxBytes = 5 << 20
+	// MaxDimension bounds the width and height, it's checked before decoding so a small file can't decompress into gigabytes
+	MaxDimension = 4096
+)
+
+const contentType = "image/png"
+
+var (
+	ErrInvalidImage = errors.New("avatar isn't a valid image")
+	ErrUnsupported  = errors.New("avatar must be a png, jpeg or gif")
+)
+
+// ContentTypes are the accepted upload content types
+var ContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// Avatar is an uploaded image processed into every size
+type Avatar struct {
+	// Hash identifies the processed images, it's stored on the user and changes with every new image
+	Hash string
+	// Images are png encoded, keyed by size
+	Images map[int][]byte
+}
+
+// ValidSize reports whether size is one of Sizes
+func ValidSize(size int) bool {
+	for _, s := range Sizes {
+		if s == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Key is where the avatar's image at size is stored
+func Key(hash string, size int) string {
+	return fmt.Sprintf("avatars/%s/%d.png", hash, size)
+}
+
+// Process decodes an uploaded png, jpeg or gif, crops it to a centered square and resizes it to every size
+// only the pixels are re-encoded, so EXIF, text chunks and any other metadata are stripped
+func Process(data []byte) (*Avatar, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == image.ErrFormat {
+		return nil, ErrUnsupported
+	}
+
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	if !ContentTypes["image/"+format] {
+		return nil, ErrUnsupported
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, fmt.Errorf("avatar is larger than %dx%d pixels", MaxDimension, MaxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	square := crop(img)
+
+	avatar := &Avatar{
+		Images: make(map[int][]byte, len(Sizes)),
+	}
+
+	h := sha256.New()
+
+	for _, size := range Sizes {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, resize(square, size)); err != nil {
+			return nil, err
+		}
+
+		avatar.Images[size] = buf.Bytes()
+		h.Write(buf.Bytes())
+	}
+
+	avatar.Hash = hex.EncodeToString(h.Sum(nil)[:16])
+
+	return avatar, nil
+}
+
+// crop copies the centered square of img into an RGBA image
+func crop(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	origin := image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+
+	return square
+}
+
+// resize scales a square with a box filter, every destination pixel is the average of the source pixels it covers
+// the pixels are premultiplied, so transparent pixels don't darken the edges
+func resize(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, size, side)
+
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, size, side)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			p := dst.Pix[y*dst.Stride+x*4:]
+			p[0] = uint8((r + n/2) / n)
+			p[1] = uint8((g + n/2) / n)
+			p[2] = uint8((b + n/2) / n)
+			p[3] = uint8((a + n/2) / n)
+		}
+	}
+
+	return dst
+}
+
+// span is the range of source pixels destination pixel i covers, at least one when upscaling
+func span(i, size, side int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+
+	if end <= start {
+		end = start + 1
+	}
+
+	return start, end
+}
+
+// Put stores every size of avatar
+func Put(ctx context.Context, store ddd.BlobStore, avatar *Avatar) error {
+	for _, size := range Sizes {
+		err := store.Put(ctx, Key(avatar.Hash, size), ddd.Blob{
+			Data:        avatar.Images[size],
+			ContentType: contentType,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes every size of the avatar identified by hash
+func Delete(ctx context.Context, store ddd.BlobStore, hash string) error {
+	for _, size := range Sizes {
+		if err := store.Delete(ctx, Key(hash, size)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/blob"
+)
+
+func newPNG(t *testing.T, width, height int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("failed to encode png: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	data := newPNG(t, 300, 200, color.RGBA{R: 255, A: 255})
+
+	avatar, err := Process(data)
+	if err != nil {
+		t.Fatalf("failed to process: %s", err)
+	}
+
+	if len(avatar.Hash) != 32 {
+		t.Errorf("unknown hash: %s", avatar.Hash)
+	}
+
+	for _, size := range Sizes {
+		img, err := png.Decode(bytes.NewReader(avatar.Images[size]))
+		if err != nil {
+			t.Fatalf("size %d isn't a png: %s", size, err)
+		}
+
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("size %d is %dx%d", size, b.Dx(), b.Dy())
+		}
+
+		if r, g, b, a := img.At(size/2, size/2).RGBA(); r>>8 != 255 || g != 0 || b != 0 || a>>8 != 255 {
+			t.Errorf("size %d isn't red: %d %d %d %d", size, r, g, b, a)
+		}
+	}
+
+	// the same image is stored once
+	again, _ := Process(data)
+	if again.Hash != avatar.Hash {
+		t.Errorf("hash isn't deterministic: %s %s", again.Hash, avatar.Hash)
+	}
+}
+
+func TestProcess_Crop(t *testing.T) {
+	// a wide image, blue on the left and right thirds, red in the middle
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{B: 255, A: 255}
+			if x >= 100 && x < 200 {
+				c = color.RGBA{R: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, img)
+
+	avatar, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatalf("failed to process: %s", err)
+	}
+
+	out, _ := png.Decode(bytes.NewReader(avatar.Images[64]))
+	for _, pt := range []image.Point{{0, 0}, {63, 63}, {32, 32}} {
+		if r, _, b, _ := out.At(pt.X, pt.Y).RGBA(); r>>8 != 255 || b != 0 {
+			t.Errorf("the center wasn't kept at %v: %d %d", pt, r, b)
+		}
+	}
+}
+
+func TestProcess_StripsMetadata(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %s", err)
+	}
+
+	// an APP1 EXIF segment right after the start of image marker
+	exif := append([]byte("Exif\x00\x00"), []byte("GPS 49.2827,-123.1207")...)
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	data := append(append(append([]byte{}, buf.Bytes()[:2]...), segment...), buf.Bytes()[2:]...)
+
+	avatar, err := Process(data)
+	if err != nil {
+		t.Fatalf("failed to process: %s", err)
+	}
+
+	for size, image := range avatar.Images {
+		if bytes.Contains(image, []byte("Exif")) || bytes.Contains(image, []byte("GPS")) {
+			t.Errorf("size %d kept the metadata", size)
+		}
+	}
+}
+
+func TestProcess_Invalid(t *testing.T) {
+	if _, err := Process([]byte("not an image")); err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got: %v", err)
+	}
+
+	data := newPNG(t, 10, 10, color.White)
+	if _, err := Process(data[:len(data)/2]); err != ErrInvalidImage {
+		t.Errorf("expected ErrInvalidImage, got: %v", err)
+	}
+
+	if _, err := Process(newPNG(t, MaxDimension+1, 1, color.White)); err == nil {
+		t.Errorf("processed an image larger than MaxDimension?")
+	}
+}
+
+func TestPutDelete(t *testing.T) {
+	store := blob.NewMemoryStore()
+
+	avatar, err := Process(newPNG(t, 32, 32, color.White))
+	if err != nil {
+		t.Fatalf("failed to process: %s", err)
+	}
+
+	if err := Put(context.Background(), store, avatar); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	for _, size := range Sizes {
+		b, err := store.Get(context.Background(), Key(avatar.Hash, size))
+		if err != nil || b.ContentType != "image/png" || !bytes.Equal(b.Data, avatar.Images[size]) {
+			t.Errorf("size %d wasn't stored: %v", size, err)
+		}
+	}
+
+	if err := Delete(context.Background(), store, avatar.Hash); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := store.Get(context.Background(), Key(avatar.Hash, DefaultSize)); err != ddd.ErrBlobNotFound {
+		t.Errorf("expected ErrBlobNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package ddd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob doesn't exist")
+
+type Blob struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore keeps opaque files by key, keys are slash separated paths like `avatars/abc/256.png`
+type BlobStore interface {
+	Put(ctx context.Context, key string, blob Blob) error
+	// Get returns ErrBlobNotFound when nothing is stored at key
+	Get(ctx context.Context, key string) (*Blob, error)
+	// Delete doesn't fail when nothing is stored at key
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,27 @@
+package blob
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateKey rejects keys that could escape the store's root, `a/../../etc/passwd`
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("blob key was empty")
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob key: %s", key)
+		}
+
+		for _, r := range segment {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+				return fmt.Errorf("invalid blob key: %s", key)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sabey/ddd"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err)
+	}
+
+	testStore(t, fs)
+}
+
+func testStore(t *testing.T, store ddd.BlobStore) {
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, "avatars/abc/64.png"); err != ddd.ErrBlobNotFound {
+		t.Errorf("expected ErrBlobNotFound, got: %v", err)
+	}
+
+	data := []byte("\x89PNG")
+	if err := store.Put(ctx, "avatars/abc/64.png", ddd.Blob{Data: data, ContentType: "image/png"}); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	// the store keeps its own copy
+	data[0] = 0
+
+	blob, err := store.Get(ctx, "avatars/abc/64.png")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if !bytes.Equal(blob.Data, []byte("\x89PNG")) || blob.ContentType != "image/png" || blob.ModTime.IsZero() {
+		t.Errorf("unknown blob: %+v", blob)
+	}
+
+	if err := store.Put(ctx, "avatars/abc/64.png", ddd.Blob{Data: []byte("replaced"), ContentType: "image/png"}); err != nil {
+		t.Fatalf("failed to replace: %s", err)
+	}
+
+	if blob, _ := store.Get(ctx, "avatars/abc/64.png"); string(blob.Data) != "replaced" {
+		t.Errorf("blob wasn't replaced: %s", blob.Data)
+	}
+
+	if err := store.Delete(ctx, "avatars/abc/64.png"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := store.Get(ctx, "avatars/abc/64.png"); err != ddd.ErrBlobNotFound {
+		t.Errorf("expected ErrBlobNotFound after delete, got: %v", err)
+	}
+
+	// deleting a missing blob isn't an error
+	if err := store.Delete(ctx, "avatars/abc/64.png"); err != nil {
+		t.Errorf("failed to delete a missing blob: %s", err)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../secret", "avatars/../../secret", "avatars//64.png", "avatars/a b.png"} {
+		if err := store.Put(ctx, key, ddd.Blob{Data: data}); err == nil {
+			t.Errorf("put accepted an invalid key: %q", key)
+		}
+	}
+}
+
+func TestFileStore_Layout(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err)
+	}
+
+	if err := fs.Put(context.Background(), "avatars/abc/64.png", ddd.Blob{Data: []byte("png")}); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "avatars", "abc", "64.png"))
+	if err != nil || string(data) != "png" {
+		t.Errorf("blob wasn't written under dir: %s %v", data, err)
+	}
+
+	// no temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Join(dir, "avatars", "abc"))
+	if len(entries) != 1 {
+		t.Errorf("unknown files: %v", entries)
+	}
+}
@@ -0,0 +1,124 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sabey/ddd"
+)
+
+// NewFileStore stores blobs as files under dir, which is created if it doesn't exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("dir was empty")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		dir: dir,
+	}, nil
+}
+
+// FileStore keeps every blob in its own file, the content type is derived from the key's extension
+type FileStore struct {
+	dir string
+}
+
+func (fs *FileStore) path(key string) string {
+	return filepath.Join(fs.dir, filepath.FromSlash(key))
+}
+
+// Put writes a temporary file and renames it over the key, readers never see a partial blob
+func (fs *FileStore) Put(ctx context.Context, key string, blob ddd.Blob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	p := fs.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(blob.Data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+func (fs *FileStore) Get(ctx context.Context, key string) (*ddd.Blob, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	p := fs.path(key)
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ddd.ErrBlobNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &ddd.Blob{
+		Data:        data,
+		ContentType: contentType,
+		ModTime:     info.ModTime().UTC(),
+	}, nil
+}
+
+func (fs *FileStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(fs.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blobs: make(map[string]ddd.Blob),
+	}
+}
+
+// MemoryStore keeps blobs in memory, it's meant for tests and single instance development
+type MemoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string]ddd.Blob
+}
+
+func (ms *MemoryStore) Put(ctx context.Context, key string, blob ddd.Blob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.blobs[key] = ddd.Blob{
+		Data:        append([]byte{}, blob.Data...),
+		ContentType: blob.ContentType,
+		ModTime:     time.Now().UTC(),
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) Get(ctx context.Context, key string) (*ddd.Blob, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	blob, ok := ms.blobs[key]
+	if !ok {
+		return nil, ddd.ErrBlobNotFound
+	}
+
+	blob.Data = append([]byte{}, blob.Data...)
+
+	return &blob, nil
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.blobs, key)
+
+	return nil
+}
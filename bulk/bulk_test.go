@@ -0,0 +1,187 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+func runImport(t *testing.T, userRepo ddd.UserRepository, data string, opts ImportOpts) (string, Summary) {
+	t.Helper()
+
+	report := &bytes.Buffer{}
+	rw := NewReportWriter(report, opts.Format)
+
+	summary, err := Import(context.Background(), userRepo, strings.NewReader(data), opts, rw.Write)
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if err := rw.Flush(); err != nil {
+		t.Fatalf("failed to flush report: %s", err)
+	}
+
+	return report.String(), summary
+}
+
+func TestImport_CSV(t *testing.T) {
+	userRepo := mock.NewUserRepository()
+	userRepo.Seed(ddd.User{Email: "d@juandefu.ca", FirstName: "Jackson", LastName: "Sabey", Password: ddd.HashPassword("pass")})
+
+	hash := ddd.HashPassword("hashed")
+
+	// the columns can be in any order, a spreadsheet's byte order mark is ignored
+	data := "\ufefflastName,email,firstName,password,passwordHash,admin,version\n" +
+		"Sabey,a@juandefu.ca,Jackson,pass,,true,7\n" +
+		"Sabey,b@juandefu.ca,Jackson,," + hash + ",,\n" +
+		"Sabey,c@juandefu.ca,,pass,,,\n" +
+		"Sabey,a@juandefu.ca,Jackson,pass,,,\n" +
+		"Sabey,d@juandefu.ca,Jackson,pass,,,\n" +
+		"Sabey,e@juandefu.ca,Jackson,pass,,maybe,\n" +
+		"Sabey,f@juandefu.ca,Jackson,,invalid,,\n" +
+		"Sabey,g@juandefu.ca,Jackson,pass,,false,\n"
+
+	// a batch of 2 splits the repeat of a@ from its first line
+	report, summary := runImport(t, userRepo, data, ImportOpts{Format: CSV, BatchSize: 2})
+
+	expected := "line,email,status,error\n" +
+		"2,a@juandefu.ca,created,\n" +
+		"3,b@juandefu.ca,created,\n" +
+		"4,c@juandefu.ca,failed,firstName was empty\n" +
+		"5,a@juandefu.ca,failed,\"email was repeated, it's on line 2\"\n" +
+		"6,d@juandefu.ca,failed,user account already exists\n" +
+		"7,e@juandefu.ca,failed,admin isn't true or false\n" +
+		"8,f@juandefu.ca,failed,passwordHash isn't a ddd.HashPassword hash\n" +
+		"9,g@juandefu.ca,created,\n"
+	if report != expected {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+
+	if summary != (Summary{Created: 3, Failed: 5}) {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	if user, _ := userRepo.User("a@juandefu.ca"); !user.Admin || user.Password != ddd.HashPassword("pass") || user.Version != 1 {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	if user, _ := userRepo.User("b@juandefu.ca"); user.Password != hash {
+		t.Errorf("the password hash wasn't imported as it is")
+	}
+}
+
+func TestImport_JSONL(t *testing.T) {
+	userRepo := mock.NewUserRepository()
+	userRepo.Seed(ddd.User{Email: "a@juandefu.ca", FirstName: "Jackson", LastName: "Sabey", Password: ddd.HashPassword("pass")})
+
+	data := `{"email":"a@juandefu.ca","firstName":"JACKSON","lastName":"Sabey","password":"changed"}` + "\n" +
+		"\n" +
+		`{"email":"b@juandefu.ca","firstName":"Jackson","lastName":"Sabey","password":"pass","disabled":true}` + "\n" +
+		`not json` + "\n" +
+		`{"email":"c@juandefu.ca","firstName":"Jackson","lastName":"Sabey","password":"pass","passwordHash":"` + ddd.HashPassword("pass") + `"}`
+
+	report, summary := runImport(t, userRepo, data, ImportOpts{Format: JSONL, Mode: ddd.ImportUpsert})
+
+	expected := `{"line":1,"email":"a@juandefu.ca","status":"updated"}` + "\n" +
+		`{"line":3,"email":"b@juandefu.ca","status":"created"}` + "\n" +
+		`{"line":4,"email":"","status":"failed","error":"line isn't a json object"}` + "\n" +
+		`{"line":5,"email":"c@juandefu.ca","status":"failed","error":"password and passwordHash were both set"}` + "\n"
+	if report != expected {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+
+	if summary != (Summary{Created: 1, Updated: 1, Failed: 2}) {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	if user, _ := userRepo.User("a@juandefu.ca"); user.FirstName != "JACKSON" || user.Password != ddd.HashPassword("changed") {
+		t.Errorf("user wasn't upserted: %+v", user)
+	}
+}
+
+func TestImport_DryRun(t *testing.T) {
+	userRepo := mock.NewUserRepository()
+
+	data := "email,firstName,lastName,password\n" +
+		"a@juandefu.ca,Jackson,Sabey,pass\n" +
+		"a@juandefu.ca,Jackson,Sabey,pass\n"
+
+	report, _ := runImport(t, userRepo, data, ImportOpts{Format: CSV, DryRun: true, BatchSize: 1})
+
+	// the repeat is found without the first line being written
+	expected := "line,email,status,error\n" +
+		"2,a@juandefu.ca,created,\n" +
+		"3,a@juandefu.ca,failed,\"email was repeated, it's on line 2\"\n"
+	if report != expected {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+
+	if _, ok := userRepo.User("a@juandefu.ca"); ok {
+		t.Errorf("a dry run created a user")
+	}
+}
+
+func TestImport_Invalid(t *testing.T) {
+	userRepo := mock.NewUserRepository()
+
+	for _, tt := range []struct {
+		data string
+		opts ImportOpts
+	}{
+		{"email\n", ImportOpts{Format: "xml"}},
+		{"email\n", ImportOpts{Format: CSV, Mode: "replace"}},
+		{"firstName,lastName\n", ImportOpts{Format: CSV}},
+	} {
+		if _, err := Import(context.Background(), userRepo, strings.NewReader(tt.data), tt.opts, func(Result) error { return nil }); err == nil {
+			t.Errorf("imported %q with %+v?", tt.data, tt.opts)
+		}
+	}
+
+	// an empty file has no rows
+	if summary, err := Import(context.Background(), userRepo, strings.NewReader(""), ImportOpts{Format: CSV}, func(Result) error { return nil }); err != nil || summary != (Summary{}) {
+		t.Errorf("unexpected empty import: %s %v", summary, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	if err := ddd.Attributes.Register("team", ddd.AttributeString); err != nil {
+		t.Fatalf("failed to register attribute: %s", err)
+	}
+
+	userRepo := mock.NewUserRepository()
+	userRepo.Seed(
+		ddd.User{Email: "b@juandefu.ca", FirstName: "Jackson", LastName: "Sabey", Password: ddd.HashPassword("pass"), Disabled: true},
+		ddd.User{Email: "a@juandefu.ca", FirstName: "Jackson", LastName: "Sabey", Password: ddd.HashPassword("pass"), Admin: true, Attributes: map[string]interface{}{"team": "core"}},
+	)
+
+	out := &bytes.Buffer{}
+	if err := Export(context.Background(), userRepo, out, ExportOpts{Format: CSV}); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+
+	expected := "email,firstName,lastName,password,passwordHash,displayName,locale,timezone,attributes,admin,disabled,version\n" +
+		"a@juandefu.ca,Jackson,Sabey,,,,,,\"{\"\"team\"\":\"\"core\"\"}\",true,false,1\n" +
+		"b@juandefu.ca,Jackson,Sabey,,,,,,,false,true,1\n"
+	if out.String() != expected {
+		t.Errorf("unexpected csv:\n%s", out)
+	}
+
+	out.Reset()
+	if err := Export(context.Background(), userRepo, out, ExportOpts{Format: JSONL, PasswordHashes: true}); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+
+	// an export with the hashes is imported with the same passwords
+	imported := mock.NewUserRepository()
+	if _, summary := runImport(t, imported, out.String(), ImportOpts{Format: JSONL}); summary != (Summary{Created: 2}) {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+
+	if user, _ := imported.User("a@juandefu.ca"); user.Password != ddd.HashPassword("pass") || !user.Admin || user.Attributes["team"] != "core" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+}
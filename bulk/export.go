@@ -0,0 +1,125 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/sabey/ddd"
+)
+
+// exportPageSize is the amount of users per UserRepository.List call
+const exportPageSize = 1000
+
+type ExportOpts struct {
+	Format Format
+	// PasswordHashes exports the hashes, so the accounts can be imported elsewhere with their passwords
+	PasswordHashes bool
+}
+
+// Export writes every account ordered by email, a page at a time
+func Export(
+	ctx context.Context,
+	userRepo ddd.UserRepository,
+	w io.Writer,
+	opts ExportOpts,
+) error {
+	if err := opts.Format.Validate(); err != nil {
+		return err
+	}
+
+	var cw *csv.Writer
+	if opts.Format == CSV {
+		cw = csv.NewWriter(w)
+
+		if err := cw.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+
+	e := json.NewEncoder(w)
+
+	list := ddd.UserList{Limit: exportPageSize}
+	for {
+		users, err := userRepo.List(ctx, list)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			r := newRow(user, opts.PasswordHashes)
+
+			if cw == nil {
+				if err := e.Encode(r); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			record, err := r.record()
+			if err != nil {
+				return err
+			}
+
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+
+		if cw != nil {
+			// every page reaches the writer before the next query
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < exportPageSize {
+			return nil
+		}
+
+		list.After = users[len(users)-1].Email
+	}
+}
+
+// ReportWriter writes an import's results in the format of the import
+// a csv report has the columns line, email, status and error
+type ReportWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func NewReportWriter(w io.Writer, format Format) *ReportWriter {
+	if format == CSV {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"line", "email", "status", "error"})
+
+		return &ReportWriter{csv: cw}
+	}
+
+	return &ReportWriter{json: json.NewEncoder(w)}
+}
+
+func (rw *ReportWriter) Write(result Result) error {
+	if rw.json != nil {
+		return rw.json.Encode(result)
+	}
+
+	return rw.csv.Write([]string{
+		strconv.Itoa(result.Line), result.Email, string(result.Status), result.Error,
+	})
+}
+
+// Flush writes the buffered csv records
+func (rw *ReportWriter) Flush() error {
+	if rw.csv == nil {
+		return nil
+	}
+
+	rw.csv.Flush()
+
+	return rw.csv.Error()
+}
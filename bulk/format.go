@@ -0,0 +1,176 @@
+package bulk
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/sabey/ddd"
+)
+
+type Format string
+
+const (
+	// CSV has a header, its columns can be in any order and only email is required
+	CSV Format = "csv"
+	// JSONL is a json object per line
+	JSONL Format = "jsonl"
+)
+
+func (f Format) Validate() error {
+	if f != CSV && f != JSONL {
+		return fmt.Errorf("format was invalid: %s", f)
+	}
+
+	return nil
+}
+
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+
+	return "application/x-ndjson"
+}
+
+// FormatOf is the format of a content type, false when it isn't csv or jsonl
+func FormatOf(mediaType string) (Format, bool) {
+	switch mediaType {
+	case "text/csv":
+		return CSV, true
+	case "application/x-ndjson", "application/jsonl":
+		return JSONL, true
+	}
+
+	return "", false
+}
+
+// csvColumns are written by Export in this order
+var csvColumns = []string{"email", "firstName", "lastName", "password", "passwordHash", "displayName", "locale", "timezone", "attributes", "admin", "disabled", "version"}
+
+// row is a user of an import or an export, unknown fields are ignored so an export can be imported again
+type row struct {
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	// Password is hashed on import, PasswordHash is imported as it is, a row sets one of them
+	Password     string                 `json:"password,omitempty"`
+	PasswordHash string                 `json:"passwordHash,omitempty"`
+	DisplayName  string                 `json:"displayName,omitempty"`
+	Locale       string                 `json:"locale,omitempty"`
+	Timezone     string                 `json:"timezone,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Admin        bool                   `json:"admin"`
+	// Disabled and Version are exported, they're ignored on import
+	Disabled bool  `json:"disabled"`
+	Version  int64 `json:"version"`
+}
+
+func newRow(user *ddd.User, passwordHashes bool) row {
+	r := row{
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Attributes:  user.Attributes,
+		Admin:       user.Admin,
+		Disabled:    user.Disabled,
+		Version:     user.Version,
+	}
+
+	if passwordHashes {
+		r.PasswordHash = user.Password
+	}
+
+	return r
+}
+
+// userCreate is validated by the repository, only the password is checked here
+func (r row) userCreate() (ddd.UserCreate, error) {
+	password := r.PasswordHash
+
+	switch {
+	case r.Password != "" && r.PasswordHash != "":
+		return ddd.UserCreate{}, errors.New("password and passwordHash were both set")
+	case r.Password != "":
+		password = ddd.HashPassword(r.Password)
+	case r.PasswordHash != "" && !validPasswordHash(r.PasswordHash):
+		return ddd.UserCreate{}, errors.New("passwordHash isn't a ddd.HashPassword hash")
+	}
+
+	return ddd.UserCreate{
+		Email:       r.Email,
+		FirstName:   r.FirstName,
+		LastName:    r.LastName,
+		Password:    password,
+		DisplayName: r.DisplayName,
+		Locale:      r.Locale,
+		Timezone:    r.Timezone,
+		Attributes:  r.Attributes,
+		Admin:       r.Admin,
+	}, nil
+}
+
+// validPasswordHash is the base64 of a sha256 sum
+func validPasswordHash(hash string) bool {
+	b, err := base64.StdEncoding.DecodeString(hash)
+
+	return err == nil && len(b) == 32
+}
+
+// record is the row's csv record, in the order of csvColumns
+func (r row) record() ([]string, error) {
+	attributes := []byte{}
+	if len(r.Attributes) > 0 {
+		var err error
+		if attributes, err = json.Marshal(r.Attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	return []string{
+		r.Email, r.FirstName, r.LastName, r.Password, r.PasswordHash, r.DisplayName, r.Locale, r.Timezone,
+		string(attributes), strconv.FormatBool(r.Admin), strconv.FormatBool(r.Disabled), strconv.FormatInt(r.Version, 10),
+	}, nil
+}
+
+// parseRecord reads a csv record, columns maps the header's names to their index
+func parseRecord(columns map[string]int, record []string) (row, error) {
+	value := func(column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return record[i]
+		}
+
+		return ""
+	}
+
+	r := row{
+		Email:        value("email"),
+		FirstName:    value("firstName"),
+		LastName:     value("lastName"),
+		Password:     value("password"),
+		PasswordHash: value("passwordHash"),
+		DisplayName:  value("displayName"),
+		Locale:       value("locale"),
+		Timezone:     value("timezone"),
+	}
+
+	if attributes := value("attributes"); attributes != "" {
+		if err := json.Unmarshal([]byte(attributes), &r.Attributes); err != nil {
+			return r, errors.New("attributes isn't a json object")
+		}
+	}
+
+	if admin := value("admin"); admin != "" {
+		var err error
+		if r.Admin, err = strconv.ParseBool(admin); err != nil {
+			return r, errors.New("admin isn't true or false")
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,335 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sabey/ddd"
+)
+
+// maxLine bounds a jsonl line
+const maxLine = 1 << 20
+
+type ImportOpts struct {
+	Format Format
+	Mode   ddd.ImportMode
+	DryRun bool
+	// BatchSize is the amount of users per UserRepository.Import call, ddd.MaxImportBatch when zero
+	BatchSize int
+}
+
+// Result is the outcome of a row, Import reports one for every row in order
+type Result struct {
+	// Line is the row's line in the file, a csv file's header is line 1
+	Line   int              `json:"line"`
+	Email  string           `json:"email"`
+	Status ddd.ImportStatus `json:"status"`
+	Error  string           `json:"error,omitempty"`
+}
+
+type Summary struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+func (s *Summary) add(status ddd.ImportStatus) {
+	switch status {
+	case ddd.ImportCreated:
+		s.Created++
+	case ddd.ImportUpdated:
+		s.Updated++
+	case ddd.ImportSkipped:
+		s.Skipped++
+	case ddd.ImportFailed:
+		s.Failed++
+	}
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("created %d, updated %d, skipped %d, failed %d", s.Created, s.Updated, s.Skipped, s.Failed)
+}
+
+// Import streams the rows of r into the repository a batch at a time, report is called with every row's result in order
+// an error stops the import, the batches before it were imported unless it's a dry run
+func Import(
+	ctx context.Context,
+	userRepo ddd.UserRepository,
+	r io.Reader,
+	opts ImportOpts,
+	report func(Result) error,
+) (
+	Summary,
+	error,
+) {
+	summary := Summary{}
+
+	if err := opts.Format.Validate(); err != nil {
+		return summary, err
+	}
+
+	// the repository validates the mode too, but only once the first batch is full
+	if err := (ddd.UserImport{Mode: opts.Mode}).Validate(); err != nil {
+		return summary, err
+	}
+
+	if opts.BatchSize <= 0 || opts.BatchSize > ddd.MaxImportBatch {
+		opts.BatchSize = ddd.MaxImportBatch
+	}
+
+	rows, err := newRowReader(r, opts.Format)
+	if err != nil {
+		return summary, err
+	}
+
+	b := &batch{
+		userRepo: userRepo,
+		opts:     opts,
+		seen:     map[string]int{},
+		report: func(result Result) error {
+			summary.add(result.Status)
+
+			return report(result)
+		},
+	}
+
+	for {
+		line, row, rowErr, err := rows.read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return summary, err
+		}
+
+		if rowErr == nil {
+			b.add(line, row)
+		} else {
+			b.fail(line, row.Email, rowErr)
+		}
+
+		if len(b.users) >= opts.BatchSize {
+			if err := b.flush(ctx); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	return summary, b.flush(ctx)
+}
+
+// batch holds the rows until they're imported, the failed rows wait so the report stays in order
+type batch struct {
+	userRepo ddd.UserRepository
+	opts     ImportOpts
+	report   func(Result) error
+
+	users   []ddd.UserCreate
+	entries []batchEntry
+	// seen is the line of every email an earlier batch didn't fail, a batch only finds the repeats within itself
+	seen map[string]int
+}
+
+type batchEntry struct {
+	line int
+	// user is the index in users, or -1 for a row that already failed
+	user   int
+	result Result
+}
+
+func (b *batch) fail(line int, email string, err error) {
+	b.entries = append(b.entries, batchEntry{
+		line: line,
+		user: -1,
+		result: Result{
+			Line:   line,
+			Email:  email,
+			Status: ddd.ImportFailed,
+			Error:  err.Error(),
+		},
+	})
+}
+
+func (b *batch) add(line int, r row) {
+	if previous, ok := b.seen[r.Email]; ok {
+		b.fail(line, r.Email, fmt.Errorf("email was repeated, it's on line %d", previous))
+
+		return
+	}
+
+	user, err := r.userCreate()
+	if err != nil {
+		b.fail(line, r.Email, err)
+
+		return
+	}
+
+	b.entries = append(b.entries, batchEntry{
+		line: line,
+		user: len(b.users),
+	})
+	b.users = append(b.users, user)
+}
+
+func (b *batch) flush(ctx context.Context) error {
+	var results []ddd.ImportResult
+
+	if len(b.users) > 0 {
+		var err error
+		results, err = b.userRepo.Import(ctx, ddd.UserImport{
+			Users:  b.users,
+			Mode:   b.opts.Mode,
+			DryRun: b.opts.DryRun,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range b.entries {
+		result := entry.result
+
+		if entry.user >= 0 {
+			imported := results[entry.user]
+
+			result = Result{
+				Line:   entry.line,
+				Email:  imported.Email,
+				Status: imported.Status,
+			}
+
+			if imported.Err != nil {
+				result.Error = imported.Err.Error()
+			}
+
+			if imported.Status != ddd.ImportFailed {
+				if _, ok := b.seen[imported.Email]; !ok {
+					b.seen[imported.Email] = entry.line
+				}
+			}
+		}
+
+		if err := b.report(result); err != nil {
+			return err
+		}
+	}
+
+	b.users = nil
+	b.entries = nil
+
+	return nil
+}
+
+// rowReader reads the rows of a file, a row that can't be parsed is returned with rowErr, err is io.EOF after the last row
+type rowReader interface {
+	read() (line int, r row, rowErr error, err error)
+}
+
+func newRowReader(r io.Reader, format Format) (rowReader, error) {
+	if format == JSONL {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+
+		return &jsonlReader{scanner: scanner}, nil
+	}
+
+	cr := csv.NewReader(r)
+	// a short record is read as empty columns
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return emptyReader{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the csv header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		// spreadsheets start the file with a byte order mark
+		if i == 0 {
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+
+		columns[strings.TrimSpace(column)] = i
+	}
+
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("the csv header doesn't have an email column")
+	}
+
+	return &csvReader{
+		reader:  cr,
+		columns: columns,
+	}, nil
+}
+
+type emptyReader struct{}
+
+func (emptyReader) read() (int, row, error, error) {
+	return 0, row{}, nil, io.EOF
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func (cr *csvReader) read() (int, row, error, error) {
+	record, err := cr.reader.Read()
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, row{}, parseErr.Err, nil
+	}
+
+	if err != nil {
+		return 0, row{}, nil, err
+	}
+
+	line, _ := cr.reader.FieldPos(0)
+
+	r, rowErr := parseRecord(cr.columns, record)
+
+	return line, r, rowErr, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (jr *jsonlReader) read() (int, row, error, error) {
+	for jr.scanner.Scan() {
+		jr.line++
+
+		data := bytes.TrimSpace(jr.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		r := row{}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return jr.line, row{}, errors.New("line isn't a json object"), nil
+		}
+
+		return jr.line, r, nil, nil
+	}
+
+	if err := jr.scanner.Err(); err != nil {
+		return 0, row{}, nil, err
+	}
+
+	return 0, row{}, nil, io.EOF
+}
@@ -0,0 +1,110 @@
+// Package cache is a read-through in-memory cache for ddd.UserRepository
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"time"
+)
+
+// lru is a size bounded least recently used cache whose entries expire after ttl
+// it isn't safe for concurrent use, UserRepository guards it with its mutex
+type lru struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+	// front is the most recently used entry
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRU(size int, ttl time.Duration, now func() time.Time) *lru {
+	return &lru{
+		size:    size,
+		ttl:     ttl,
+		now:     now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the key's value, expired entries are removed and reported as a miss
+func (c *lru) get(key string) (interface{}, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if c.ttl > 0 && !c.now().Before(e.expires) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return e.value, true
+}
+
+// set stores the key's value and returns how many entries were evicted to make room
+func (c *lru) set(key string, value interface{}) int {
+	expires := c.now().Add(c.ttl)
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expires = expires
+		c.order.MoveToFront(el)
+
+		return 0
+	}
+
+	c.entries[key] = c.order.PushFront(&entry{
+		key:     key,
+		value:   value,
+		expires: expires,
+	})
+
+	evicted := 0
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		evicted++
+	}
+
+	return evicted
+}
+
+func (c *lru) remove(key string) {
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// removePrefix removes every key starting with prefix
+func (c *lru) removePrefix(prefix string) {
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+func (c *lru) clear() {
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *lru) len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/metrics"
+)
+
+const (
+	DefaultSize = 1024
+	DefaultTTL  = 30 * time.Second
+)
+
+// listPrefix starts the key of every cached page, any write drops them all
+const listPrefix = "list:"
+
+type Opts struct {
+	// Size is the maximum amount of cached users and lists, defaults to DefaultSize
+	Size int
+	// TTL bounds how long an entry is served, defaults to DefaultTTL
+	// it's the staleness limit for writes made by other instances
+	TTL time.Duration
+	// Metrics is optional, hits, misses and evictions are exported to it
+	Metrics *metrics.Registry
+}
+
+// NewUserRepository wraps a ddd.UserRepository and serves Get and List from an in-memory LRU
+// Create, Update and Delete invalidate the cache, Login is never cached so credentials are always checked against the backend
+func NewUserRepository(
+	userRepo ddd.UserRepository,
+	opts Opts,
+) *UserRepository {
+	if opts.Size <= 0 {
+		opts.Size = DefaultSize
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+
+	ur := &UserRepository{
+		userRepo: userRepo,
+		lru:      newLRU(opts.Size, opts.TTL, time.Now),
+	}
+
+	if opts.Metrics != nil {
+		ur.requests = opts.Metrics.NewCounterVec(
+			"ddd_cache_requests_total",
+			"Total number of UserRepository cache lookups by result.",
+			"result",
+		)
+		ur.evictions = opts.Metrics.NewCounterVec(
+			"ddd_cache_evictions_total",
+			"Total number of UserRepository cache entries evicted to make room.",
+		)
+	}
+
+	return ur
+}
+
+type UserRepository struct {
+	userRepo ddd.UserRepository
+
+	mu  sync.Mutex
+	lru *lru
+	// generation is bumped by every invalidation, a lookup that started before it doesn't fill the cache
+	// otherwise a slow read racing a write would cache the old row
+	generation uint64
+	stats      Stats
+
+	requests  *metrics.CounterVec
+	evictions *metrics.CounterVec
+}
+
+// Stats are the cache's counters since it was created
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Entries is the amount of cached users and lists
+	Entries int
+}
+
+func (ur *UserRepository) Stats() Stats {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stats := ur.stats
+	stats.Entries = ur.lru.len()
+
+	return stats
+}
+
+// Invalidate drops the cached user and every cached list of its tenant, it's called for writes this instance didn't make
+func (ur *UserRepository) Invalidate(tenant string, email string) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.generation++
+	ur.lru.remove(userKey(tenant, email))
+	ur.lru.removePrefix(tenantListPrefix(tenant))
+}
+
+// InvalidateAll drops every entry
+func (ur *UserRepository) InvalidateAll() {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.generation++
+	ur.lru.clear()
+}
+
+// userKey separates the tenant and email with NUL, the same email can be an account of several organizations
+func userKey(tenant string, email string) string {
+	return "user:" + tenant + "\x00" + email
+}
+
+func tenantListPrefix(tenant string) string {
+	return listPrefix + tenant + "\x00"
+}
+
+// listKey separates the options with NUL, which can't be part of an email or a search
+func listKey(tenant string, opts ddd.UserList) string {
+	admin := ""
+	if opts.Admin != nil {
+		admin = strconv.FormatBool(*opts.Admin)
+	}
+
+	fields := []string{strconv.Itoa(opts.Limit), opts.After, opts.Search, admin, strconv.Itoa(len(opts.Emails))}
+
+	return tenantListPrefix(tenant) + strings.Join(append(fields, opts.Emails...), "\x00")
+}
+
+// lookup returns the cached value, or the current generation to pass to fill on a miss
+func (ur *UserRepository) lookup(key string) (interface{}, uint64, bool) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	value, ok := ur.lru.get(key)
+	if ok {
+		ur.stats.Hits++
+		ur.observe("hit")
+	} else {
+		ur.stats.Misses++
+		ur.observe("miss")
+	}
+
+	return value, ur.generation, ok
+}
+
+// fill caches value unless an invalidation happened since the lookup
+func (ur *UserRepository) fill(key string, value interface{}, generation uint64) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if generation != ur.generation {
+		return
+	}
+
+	if evicted := ur.lru.set(key, value); evicted > 0 {
+		ur.stats.Evictions += uint64(evicted)
+		if ur.evictions != nil {
+			ur.evictions.With().Add(float64(evicted))
+		}
+	}
+}
+
+func (ur *UserRepository) observe(result string) {
+	if ur.requests != nil {
+		ur.requests.With(result).Inc()
+	}
+}
+
+func (ur *UserRepository) Create(
+	ctx context.Context,
+	opts ddd.UserCreate,
+) (
+	*ddd.User,
+	error,
+) {
+	user, err := ur.userRepo.Create(ctx, opts)
+	// a failed write may still have been committed (a timeout after the query), always invalidate
+	ur.Invalidate(ddd.TenantFrom(ctx), opts.Email)
+
+	return user, err
+}
+
+// Login always calls the backend, a changed password or deleted account must never log in from the cache
+func (ur *UserRepository) Login(
+	ctx context.Context,
+	opts ddd.UserLogin,
+) (
+	*ddd.User,
+	error,
+) {
+	return ur.userRepo.Login(ctx, opts)
+}
+
+func (ur *UserRepository) Get(
+	ctx context.Context,
+	email string,
+) (
+	*ddd.User,
+	error,
+) {
+	key := userKey(ddd.TenantFrom(ctx), email)
+
+	value, generation, ok := ur.lookup(key)
+	if ok {
+		user := value.(ddd.User).Clone()
+		return &user, nil
+	}
+
+	user, err := ur.userRepo.Get(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cached copy can't be changed through the returned pointer
+	ur.fill(key, user.Clone(), generation)
+
+	return user, nil
+}
+
+func (ur *UserRepository) List(
+	ctx context.Context,
+	opts ddd.UserList,
+) (
+	[]*ddd.User,
+	error,
+) {
+	key := listKey(ddd.TenantFrom(ctx), opts)
+
+	value, generation, ok := ur.lookup(key)
+	if ok {
+		return newUserList(value.([]ddd.User)), nil
+	}
+
+	users, err := ur.userRepo.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make([]ddd.User, 0, len(users))
+	for _, user := range users {
+		cached = append(cached, user.Clone())
+	}
+
+	ur.fill(key, cached, generation)
+
+	return users, nil
+}
+
+func newUserList(cached []ddd.User) []*ddd.User {
+	users := make([]*ddd.User, 0, len(cached))
+	for _, user := range cached {
+		user := user.Clone()
+		users = append(users, &user)
+	}
+
+	return users
+}
+
+func (ur *UserRepository) Update(
+	ctx context.Context,
+	opts ddd.UserUpdate,
+) (
+	*ddd.User,
+	error,
+) {
+	user, err := ur.userRepo.Update(ctx, opts)
+	ur.Invalidate(ddd.TenantFrom(ctx), opts.Email)
+
+	return user, err
+}
+
+func (ur *UserRepository) Delete(
+	ctx context.Context,
+	email string,
+) error {
+	err := ur.userRepo.Delete(ctx, email)
+	ur.Invalidate(ddd.TenantFrom(ctx), email)
+
+	return err
+}
+
+// Import drops the whole cache, a batch invalidates more accounts than are worth dropping one by one
+func (ur *UserRepository) Import(
+	ctx context.Context,
+	opts ddd.UserImport,
+) (
+	[]ddd.ImportResult,
+	error,
+) {
+	results, err := ur.userRepo.Import(ctx, opts)
+	if !opts.DryRun {
+		ur.InvalidateAll()
+	}
+
+	return results, err
+}
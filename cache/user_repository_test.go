@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/conformance"
+	"github.com/sabey/ddd/metrics"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestUserRepository_Conformance(t *testing.T) {
+	conformance.RunUserRepository(t, func(t *testing.T) ddd.UserRepository {
+		return NewUserRepository(mock.NewUserRepository(), Opts{})
+	})
+}
+
+func newSeeded() (*mock.UserRepository, *UserRepository) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
+
+	return mockUsers, NewUserRepository(mockUsers, Opts{})
+}
+
+func TestUserRepository_Get(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	for i := 0; i < 3; i++ {
+		user, err := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+		if err != nil {
+			t.Fatalf("failed to get: %s", err)
+		}
+
+		// mutating a returned user doesn't change the cache
+		user.FirstName = "mutated"
+	}
+
+	if calls := mockUsers.Calls(mock.MethodGet); len(calls) != 1 {
+		t.Errorf("expected a single backend call: %d", len(calls))
+	}
+
+	user, _ := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if user.FirstName != "Jackson" {
+		t.Errorf("cached user was mutated: %+v", user)
+	}
+
+	if stats := userRepo.Stats(); stats.Hits != 3 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("unknown stats: %+v", stats)
+	}
+}
+
+func TestUserRepository_GetNotFound(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	for i := 0; i < 2; i++ {
+		if _, err := userRepo.Get(context.Background(), "jackson@sabey.co"); err != ddd.ErrUserNotFound {
+			t.Errorf("expected ErrUserNotFound, got: %v", err)
+		}
+	}
+
+	// errors aren't cached, the account may be created by another instance
+	if calls := mockUsers.Calls(mock.MethodGet); len(calls) != 2 {
+		t.Errorf("expected every lookup to reach the backend: %d", len(calls))
+	}
+}
+
+func TestUserRepository_Invalidation(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil || len(users) != 1 {
+		t.Fatalf("failed to list: %v %v", users, err)
+	}
+
+	_, err = userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+		LastName:  ddd.String("SABEY"),
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	users, _ = userRepo.List(context.Background(), ddd.UserList{})
+	if users[0].FirstName != "JACKSON" {
+		t.Errorf("stale list after update: %+v", users[0])
+	}
+
+	_, err = userRepo.Create(context.Background(), ddd.UserCreate{
+		Email:     "jackson@sabey.co",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+
+	if users, _ = userRepo.List(context.Background(), ddd.UserList{}); len(users) != 2 {
+		t.Errorf("stale list after create: %d", len(users))
+	}
+
+	userRepo.Get(context.Background(), "jackson@sabey.co")
+
+	if err := userRepo.Delete(context.Background(), "jackson@sabey.co"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := userRepo.Get(context.Background(), "jackson@sabey.co"); err != ddd.ErrUserNotFound {
+		t.Errorf("deleted user was served from the cache: %v", err)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodList); len(calls) != 3 {
+		t.Errorf("expected every write to invalidate the list: %d", len(calls))
+	}
+}
+
+func TestUserRepository_Pages(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+	mockUsers.Seed(ddd.User{Email: "jackson@sabey.co"})
+
+	first, _ := userRepo.List(context.Background(), ddd.UserList{Limit: 1})
+	second, _ := userRepo.List(context.Background(), ddd.UserList{Limit: 1, After: first[0].Email})
+
+	if len(first) != 1 || len(second) != 1 || first[0].Email == second[0].Email {
+		t.Fatalf("pages were cached under the same key: %v %v", first, second)
+	}
+
+	userRepo.List(context.Background(), ddd.UserList{Limit: 1})
+	userRepo.List(context.Background(), ddd.UserList{Limit: 1, After: first[0].Email})
+
+	if calls := mockUsers.Calls(mock.MethodList); len(calls) != 2 {
+		t.Errorf("expected a backend call per page: %d", len(calls))
+	}
+
+	// a write drops every page
+	userRepo.Invalidate(ddd.DefaultOrganization, "jackson@sabey.co")
+
+	if stats := userRepo.Stats(); stats.Entries != 0 {
+		t.Errorf("pages weren't invalidated: %+v", stats)
+	}
+}
+
+func TestUserRepository_InvalidateExternal(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	userRepo.Get(context.Background(), "jackson@juandefu.ca")
+
+	// another instance updated the row
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "JACKSON",
+		LastName:  "SABEY",
+	})
+
+	userRepo.Invalidate(ddd.DefaultOrganization, "jackson@juandefu.ca")
+
+	user, _ := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if user.FirstName != "JACKSON" {
+		t.Errorf("stale user after invalidation: %+v", user)
+	}
+}
+
+func TestUserRepository_Tenants(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+	mockUsers.Seed(ddd.User{
+		Organization: "acme",
+		Email:        "jackson@juandefu.ca",
+		FirstName:    "ACME",
+		LastName:     "Sabey",
+	})
+
+	acmeCtx := ddd.WithTenant(context.Background(), "acme")
+
+	// the same email is cached once per organization
+	for i := 0; i < 2; i++ {
+		if user, err := userRepo.Get(context.Background(), "jackson@juandefu.ca"); err != nil || user.FirstName != "Jackson" {
+			t.Errorf("unexpected user: %+v %v", user, err)
+		}
+
+		if user, err := userRepo.Get(acmeCtx, "jackson@juandefu.ca"); err != nil || user.FirstName != "ACME" {
+			t.Errorf("unexpected user in acme: %+v %v", user, err)
+		}
+
+		if users, err := userRepo.List(acmeCtx, ddd.UserList{}); err != nil || len(users) != 1 {
+			t.Errorf("unexpected users in acme: %v %v", users, err)
+		}
+	}
+
+	if calls := mockUsers.Calls(mock.MethodGet, mock.MethodList); len(calls) != 3 {
+		t.Errorf("expected a backend call per organization: %d", len(calls))
+	}
+
+	// a write drops its organization's entries
+	userRepo.Invalidate("acme", "jackson@juandefu.ca")
+
+	if stats := userRepo.Stats(); stats.Entries != 1 {
+		t.Errorf("unexpected entries after invalidation: %+v", stats)
+	}
+}
+
+func TestUserRepository_Login(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	userRepo.Get(context.Background(), "jackson@juandefu.ca")
+
+	// the password changed behind the cache's back
+	mockUsers.Seed(ddd.User{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("changed"),
+	})
+
+	_, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	})
+	if err != ddd.ErrInvalidPassword {
+		t.Errorf("expected ErrInvalidPassword, got: %v", err)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodLogin); len(calls) != 1 {
+		t.Errorf("login didn't reach the backend: %d", len(calls))
+	}
+}
+
+func TestUserRepository_TTL(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	now := time.Now()
+	userRepo.lru.now = func() time.Time { return now }
+
+	userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	userRepo.Get(context.Background(), "jackson@juandefu.ca")
+
+	now = now.Add(DefaultTTL)
+
+	userRepo.Get(context.Background(), "jackson@juandefu.ca")
+
+	if calls := mockUsers.Calls(mock.MethodGet); len(calls) != 2 {
+		t.Errorf("expired entry was served: %d", len(calls))
+	}
+}
+
+func TestUserRepository_Evictions(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{Email: "a@sabey.co"},
+		ddd.User{Email: "b@sabey.co"},
+		ddd.User{Email: "c@sabey.co"},
+	)
+
+	userRepo := NewUserRepository(mockUsers, Opts{
+		Size:    2,
+		Metrics: reg,
+	})
+
+	for _, email := range []string{"a@sabey.co", "b@sabey.co", "a@sabey.co", "c@sabey.co", "a@sabey.co", "b@sabey.co"} {
+		if _, err := userRepo.Get(context.Background(), email); err != nil {
+			t.Fatalf("failed to get %s: %s", email, err)
+		}
+	}
+
+	// b was the least recently used when c was added, then c when b was added again
+	if stats := userRepo.Stats(); stats.Hits != 2 || stats.Misses != 4 || stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("unknown stats: %+v", stats)
+	}
+
+	buf := &bytes.Buffer{}
+	reg.WriteTo(buf)
+
+	for _, line := range []string{
+		`ddd_cache_requests_total{result="hit"} 2`,
+		`ddd_cache_requests_total{result="miss"} 4`,
+		`ddd_cache_evictions_total 2`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("`%s` wasn't exported: `%s`", line, buf.String())
+		}
+	}
+}
+
+func TestUserRepository_StaleFill(t *testing.T) {
+	mockUsers, userRepo := newSeeded()
+
+	// the lookup started before the invalidation, so its result isn't cached
+	_, generation, _ := userRepo.lookup(userKey(ddd.DefaultOrganization, "jackson@juandefu.ca"))
+	userRepo.Invalidate(ddd.DefaultOrganization, "jackson@juandefu.ca")
+	userRepo.fill(userKey(ddd.DefaultOrganization, "jackson@juandefu.ca"), ddd.User{Email: "jackson@juandefu.ca", FirstName: "stale"}, generation)
+
+	user, _ := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if user.FirstName != "Jackson" {
+		t.Errorf("stale fill was cached: %+v", user)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodGet); len(calls) != 1 {
+		t.Errorf("expected a backend call: %d", len(calls))
+	}
+}
@@ -0,0 +1,303 @@
+// Package client calls the ddd HTTP API, it only depends on the standard library
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 100 * time.Millisecond
+	// maxBackoff bounds the wait before a retry, including a server's Retry-After
+	maxBackoff = 5 * time.Second
+)
+
+type Opts struct {
+	// BaseURL is the service's address, `http://localhost:8080`
+	BaseURL string
+	// HTTPClient sends every request, http.DefaultClient is used when nil
+	HTTPClient *http.Client
+	// Token authenticates requests until Signup or Login replace it
+	Token string
+	// Organization is the organization Login authenticates in, the default organization when empty
+	Organization string
+	// Retries is how many times an idempotent call is retried, DefaultRetries when zero, negative never retries
+	Retries int
+	// Backoff is the first retry's wait, it doubles with every retry, DefaultBackoff when zero
+	Backoff time.Duration
+}
+
+func New(opts Opts) (*Client, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(opts.BaseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base url: %s", opts.BaseURL)
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	} else if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: opts.HTTPClient,
+		retries:    opts.Retries,
+		backoff:    opts.Backoff,
+		token:      opts.Token,
+
+		organization: opts.Organization,
+	}, nil
+}
+
+// Client is safe for concurrent use
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+
+	organization string
+
+	mu    sync.Mutex
+	token string
+	// the credentials of the last Signup or Login, a rejected token is refreshed by logging in again
+	email    string
+	password string
+	// mfaToken is the challenge of a Login that needs a second factor, LoginMFA sends it
+	mfaToken string
+}
+
+// Token is the token requests are authenticated with
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.token
+}
+
+// SetToken replaces the token, the credentials of a previous Login are kept to refresh it
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = token
+}
+
+func (c *Client) setCredentials(token, email, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = token
+	c.email = email
+	c.password = password
+}
+
+func (c *Client) setChallenge(mfaToken, email, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mfaToken = mfaToken
+	c.email = email
+	c.password = password
+}
+
+func (c *Client) challenge() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.mfaToken
+}
+
+func (c *Client) credentials() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.email, c.password
+}
+
+// request is one API call, it's sent again for every retry
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	// body is encoded as json with contentType, application/json when empty
+	body        interface{}
+	contentType string
+	// auth sends the token, a rejected token is refreshed once
+	auth bool
+	// idempotent calls are retried on network errors, 429 and 5xx gateway responses
+	idempotent bool
+}
+
+// do sends req and decodes a successful response's body into out, the response's headers are returned
+func (c *Client) do(ctx context.Context, req request, out interface{}) (http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshed := false
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, body)
+		if err == nil && resp.StatusCode < 300 {
+			defer resp.Body.Close()
+
+			if out != nil {
+				if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+					return nil, fmt.Errorf("failed to decode response: %w", err)
+				}
+			}
+
+			return resp.Header, nil
+		}
+
+		var wait time.Duration
+
+		if err != nil {
+			// the context's error is more useful than the transport's
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		} else {
+			apiErr := decodeError(resp)
+			err = apiErr
+
+			// the token was rejected, logging in again doesn't count as a retry
+			if req.auth && !refreshed && errors.Is(apiErr, ErrUnauthorized) {
+				if email, password := c.credentials(); email != "" {
+					refreshed = true
+					attempt--
+
+					if err := c.Login(ctx, email, password); err != nil {
+						return nil, err
+					}
+
+					continue
+				}
+			}
+
+			if !retryable(resp.StatusCode) {
+				return nil, err
+			}
+
+			wait = retryAfter(resp.Header)
+		}
+
+		if !req.idempotent || attempt >= c.retries {
+			return nil, err
+		}
+
+		if wait == 0 {
+			wait = c.wait(attempt)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path += req.path
+	u.RawQuery = req.query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.header {
+		r.Header[key] = values
+	}
+
+	if body != nil {
+		contentType := req.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+
+		r.Header.Set("Content-Type", contentType)
+	}
+
+	if req.auth {
+		r.Header.Set("X-Authentication-Token", c.Token())
+	}
+
+	return c.httpClient.Do(r)
+}
+
+// wait is the exponential backoff before retry attempt+1, with full jitter so clients don't retry in lockstep
+func (c *Client) wait(attempt int) time.Duration {
+	backoff := c.backoff << attempt
+	if backoff <= 0 || backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff))) + 1
+}
+
+// retryable statuses are the ones a gateway or an overloaded service respond with
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryAfter is a Retry-After in seconds, zero when there isn't one
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	return wait
+}
@@ -0,0 +1,477 @@
+package client
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	net_http "net/http"
+	"net/http/httptest"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/http"
+	"github.com/sabey/ddd/mock"
+	"github.com/sabey/ddd/totp"
+)
+
+func newServer(t *testing.T) (*mock.UserRepository, *httptest.Server) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{
+			Email:     "jackson@juandefu.ca",
+			FirstName: "Jackson",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+		},
+		ddd.User{
+			Email:     "admin@sabey.co",
+			FirstName: "Admin",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+			Admin:     true,
+		},
+	)
+
+	ts := httptest.NewServer(
+		http.NewHTTPService(
+			mockUsers,
+		),
+	)
+	t.Cleanup(ts.Close)
+
+	return mockUsers, ts
+}
+
+func newClient(t *testing.T, baseURL string) *Client {
+	c, err := New(Opts{
+		BaseURL: baseURL,
+		Backoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+
+	return c
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:8080", "://"} {
+		if _, err := New(Opts{BaseURL: baseURL}); err == nil {
+			t.Errorf("accepted an invalid base url: %q", baseURL)
+		}
+	}
+}
+
+func TestSignup(t *testing.T) {
+	mockUsers, ts := newServer(t)
+	c := newClient(t, ts.URL)
+
+	err := c.Signup(context.Background(), Signup{
+		Email:     "jackson@sabey.co",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	if err != nil {
+		t.Fatalf("failed to signup: %s", err)
+	}
+
+	if _, ok := mockUsers.User("jackson@sabey.co"); !ok {
+		t.Errorf("account wasn't created")
+	}
+
+	user, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get me: %s", err)
+	}
+
+	if user.Email != "jackson@sabey.co" || user.Version != 1 {
+		t.Errorf("unknown user: %+v", user)
+	}
+
+	err = c.Signup(context.Background(), Signup{
+		Email:     "jackson@sabey.co",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	if !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got: %v", err)
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 || apiErr.Message != "user account already exists" {
+		t.Errorf("unknown error: %#v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	_, ts := newServer(t)
+	c := newClient(t, ts.URL)
+
+	if _, err := c.Me(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got: %v", err)
+	}
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "wrong"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got: %v", err)
+	}
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if ddd.ParseJWTClaims(c.Token()) != "jackson@juandefu.ca" {
+		t.Errorf("unknown token: %s", c.Token())
+	}
+}
+
+func TestLogin_Organization(t *testing.T) {
+	mockUsers, ts := newServer(t)
+	mockUsers.Seed(ddd.User{
+		Organization: "acme",
+		Email:        "jackson@acme.co",
+		FirstName:    "Jackson",
+		LastName:     "Sabey",
+		Password:     ddd.HashPassword("pass"),
+	})
+
+	c := newClient(t, ts.URL)
+
+	// the account isn't in the default organization
+	if err := c.Login(context.Background(), "jackson@acme.co", "pass"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	c, err := New(Opts{
+		BaseURL:      ts.URL,
+		Organization: "acme",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+
+	if err := c.Login(context.Background(), "jackson@acme.co", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if tenant, email := ddd.ParseTenantJWTClaims(c.Token()); tenant != "acme" || email != "jackson@acme.co" {
+		t.Errorf("unknown token: %s", c.Token())
+	}
+}
+
+func TestLogin_MFA(t *testing.T) {
+	_, ts := newServer(t)
+	c := newClient(t, ts.URL)
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	enrollment, err := c.EnrollTOTP(context.Background())
+	if err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %s", err)
+	}
+
+	recoveryCodes, err := c.ConfirmTOTP(context.Background(), totp.Code(secret, totp.Step(time.Now())))
+	if err != nil {
+		t.Fatalf("failed to confirm: %s", err)
+	}
+
+	c = newClient(t, ts.URL)
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("expected ErrMFARequired, got: %v", err)
+	}
+
+	if c.Token() != "" {
+		t.Errorf("a token was set before the second factor: %s", c.Token())
+	}
+
+	if err := c.LoginMFA(context.Background(), totp.Code(secret, totp.Step(time.Now())-5)); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("expected ErrInvalidTOTPCode, got: %v", err)
+	}
+
+	// the challenge was checked
+	if err := c.LoginRecoveryCode(context.Background(), recoveryCodes[0]); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got: %v", err)
+	}
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("expected ErrMFARequired, got: %v", err)
+	}
+
+	if err := c.LoginRecoveryCode(context.Background(), recoveryCodes[0]); err != nil {
+		t.Fatalf("failed to login with a recovery code: %s", err)
+	}
+
+	if ddd.ParseJWTClaims(c.Token()) != "jackson@juandefu.ca" {
+		t.Errorf("unknown token: %s", c.Token())
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	_, ts := newServer(t)
+	c := newClient(t, ts.URL)
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	// the service stopped accepting the token, the client logs in again
+	c.SetToken("expired")
+
+	user, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatalf("token wasn't refreshed: %s", err)
+	}
+
+	if user.Email != "jackson@juandefu.ca" || c.Token() == "expired" {
+		t.Errorf("unknown user or token: %+v %s", user, c.Token())
+	}
+
+	// without credentials the rejected token is returned as is
+	c, _ = New(Opts{BaseURL: ts.URL, Token: "expired"})
+	if _, err := c.Me(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got: %v", err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	mockUsers, ts := newServer(t)
+	c := newClient(t, ts.URL)
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	user, err := c.UpdateUser(context.Background(), UserUpdate{
+		LastName:    String("SABEY"),
+		DisplayName: String("Jack"),
+		Version:     1,
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	if user.LastName != "SABEY" || user.DisplayName != "Jack" || user.FirstName != "Jackson" || user.Version != 2 {
+		t.Errorf("unknown user: %+v", user)
+	}
+
+	// the version read before the update is stale
+	_, err = c.UpdateUser(context.Background(), UserUpdate{
+		LastName: String("Sabey"),
+		Version:  1,
+	})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch, got: %v", err)
+	}
+
+	// another account needs an admin
+	_, err = c.UpdateUser(context.Background(), UserUpdate{
+		Email:    "admin@sabey.co",
+		LastName: String("SABEY"),
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got: %v", err)
+	}
+
+	if err := c.Login(context.Background(), "admin@sabey.co", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if _, err := c.UpdateUser(context.Background(), UserUpdate{
+		Email:       "jackson@juandefu.ca",
+		DisplayName: String(""),
+	}); err != nil {
+		t.Fatalf("admin failed to update: %s", err)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.DisplayName != "" || user.Version != 3 {
+		t.Errorf("update wasn't stored: %+v", user)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	mockUsers, ts := newServer(t)
+	for i := 0; i < 7; i++ {
+		mockUsers.Seed(ddd.User{
+			Email:     fmt.Sprintf("user%02d@sabey.co", i),
+			FirstName: "User",
+			LastName:  "Sabey",
+		})
+	}
+
+	c := newClient(t, ts.URL)
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	emails := []string{}
+
+	it := c.ListUsers(ListUsers{PageSize: 3})
+	for it.Next(context.Background()) {
+		emails = append(emails, it.User().Email)
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	if len(emails) != 9 || emails[0] != "admin@sabey.co" || emails[8] != "user06@sabey.co" {
+		t.Errorf("unknown users: %v", emails)
+	}
+
+	// 9 users in pages of 3, the last full page is followed by an empty one
+	if calls := mockUsers.Calls(mock.MethodList); len(calls) != 4 {
+		t.Errorf("unknown amount of pages: %d", len(calls))
+	}
+
+	page, err := c.ListUsersPage(context.Background(), ListUsers{PageSize: 5})
+	if err != nil || len(page.Users) != 5 || page.Next == "" {
+		t.Fatalf("unknown page: %+v %v", page, err)
+	}
+
+	page, err = c.ListUsersPage(context.Background(), ListUsers{PageSize: 5, Cursor: page.Next})
+	if err != nil || len(page.Users) != 4 || page.Users[0].Email != "user03@sabey.co" {
+		t.Errorf("unknown second page: %+v %v", page, err)
+	}
+}
+
+// flaky fails the first n requests with 503
+func flaky(next net_http.Handler, n int32) (net_http.Handler, *int32) {
+	var requests int32
+
+	return net_http.HandlerFunc(func(w net_http.ResponseWriter, r *net_http.Request) {
+		if atomic.AddInt32(&requests, 1) <= n {
+			w.WriteHeader(net_http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}), &requests
+}
+
+func TestRetries(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+	})
+
+	handler, requests := flaky(http.NewHTTPService(mockUsers), 2)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	c, _ := New(Opts{
+		BaseURL: ts.URL,
+		Token:   ddd.SignJWTClaims("jackson@juandefu.ca"),
+		Backoff: time.Millisecond,
+	})
+
+	if _, err := c.Me(context.Background()); err != nil {
+		t.Fatalf("read wasn't retried: %s", err)
+	}
+
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("expected 3 requests: %d", n)
+	}
+
+	// a write with If-Match isn't retried
+	atomic.StoreInt32(requests, 0)
+
+	_, err := c.UpdateUser(context.Background(), UserUpdate{LastName: String("SABEY"), Version: 1})
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Errorf("expected a 503, got: %v", err)
+	}
+
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected a single request: %d", n)
+	}
+
+	// retries run out
+	handler, requests = flaky(http.NewHTTPService(mockUsers), 100)
+	ts2 := httptest.NewServer(handler)
+	defer ts2.Close()
+
+	c, _ = New(Opts{
+		BaseURL: ts2.URL,
+		Retries: 2,
+		Backoff: time.Millisecond,
+	})
+
+	if _, err := c.Me(context.Background()); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("expected 3 requests: %d", n)
+	}
+}
+
+func TestRetries_Context(t *testing.T) {
+	handler, _ := flaky(nil, 1000)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	c, _ := New(Opts{
+		BaseURL: ts.URL,
+		Retries: 1000,
+		Backoff: time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.Me(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("backoff didn't stop with the context")
+	}
+}
+
+func TestCustomHTTPClient(t *testing.T) {
+	_, ts := newServer(t)
+
+	var requests int32
+	httpClient := &net_http.Client{
+		Transport: roundTripper(func(r *net_http.Request) (*net_http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			return net_http.DefaultTransport.RoundTrip(r)
+		}),
+	}
+
+	c, _ := New(Opts{
+		BaseURL:    ts.URL,
+		HTTPClient: httpClient,
+	})
+
+	if err := c.Login(context.Background(), "jackson@juandefu.ca", "pass"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("the custom client wasn't used")
+	}
+}
+
+type roundTripper func(*net_http.Request) (*net_http.Response, error)
+
+func (rt roundTripper) RoundTrip(r *net_http.Request) (*net_http.Response, error) {
+	return rt(r)
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// the service's errors, match them with errors.Is
+var (
+	// ErrUnauthorized is a missing or rejected token
+	ErrUnauthorized = errors.New("token is missing or invalid")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	// ErrVersionMismatch is a failed If-Match, the account changed since its version was read
+	ErrVersionMismatch = errors.New("user account was modified")
+	ErrUserExists      = errors.New("user account already exists")
+	ErrUserNotFound    = errors.New("user account doesn't exist")
+	ErrInvalidPassword = errors.New("password is invalid")
+	ErrUserDisabled    = errors.New("user account is disabled")
+	// ErrMFARequired is returned by Login for an account with two-factor authentication, LoginMFA finishes it
+	ErrMFARequired     = errors.New("two-factor code is required")
+	ErrInvalidTOTPCode = errors.New("two-factor code is invalid")
+	// ErrTOTPLocked is too many wrong codes in a row, the account's codes are refused for a while
+	ErrTOTPLocked = errors.New("too many invalid two-factor codes, try again later")
+	// ErrTimeout is the service running out of time, not the client's context
+	ErrTimeout = errors.New("request timed out")
+)
+
+// Error is a response the service rejected
+type Error struct {
+	StatusCode int
+	// Message is the response's `{"error":"..."}`, or its status text
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel errors, by status or by the service's message
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.Message == "invalid jwt" || e.Message == "jwt not found"
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrVersionMismatch:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrTimeout:
+		return e.StatusCode == http.StatusGatewayTimeout
+	case ErrUserExists, ErrUserNotFound, ErrInvalidPassword, ErrUserDisabled, ErrInvalidTOTPCode, ErrTOTPLocked:
+		return e.Message == target.Error()
+	}
+
+	return false
+}
+
+func decodeError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+
+	e := &Error{
+		StatusCode: resp.StatusCode,
+	}
+
+	response := struct {
+		Error string `json:"error"`
+	}{}
+
+	bs, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err := json.Unmarshal(bs, &response); err == nil && response.Error != "" {
+		e.Message = response.Error
+	} else {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return e
+}
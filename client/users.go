@@ -0,0 +1,375 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultPageSize is how many users ListUsers requests at a time
+const DefaultPageSize = 100
+
+type User struct {
+	Email       string                 `json:"email"`
+	FirstName   string                 `json:"firstName"`
+	LastName    string                 `json:"lastName"`
+	DisplayName string                 `json:"displayName,omitempty"`
+	Locale      string                 `json:"locale,omitempty"`
+	Timezone    string                 `json:"timezone,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	// AvatarURL is relative to the service
+	AvatarURL string `json:"avatarUrl,omitempty"`
+	// Version is read from the response's ETag, it's zero for listed users
+	Version int64 `json:"-"`
+}
+
+type Signup struct {
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Password  string `json:"password"`
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+	// MFAToken replaces Token when the login needs a second factor
+	MFAToken string `json:"mfaToken"`
+}
+
+// Signup creates an account, the client is then authenticated as it
+func (c *Client) Signup(ctx context.Context, signup Signup) error {
+	response := tokenResponse{}
+
+	_, err := c.do(ctx, request{
+		method: "POST",
+		path:   "/signup",
+		body:   signup,
+	}, &response)
+	if err != nil {
+		return err
+	}
+
+	c.setCredentials(response.Token, signup.Email, signup.Password)
+
+	return nil
+}
+
+// Login authenticates the client, the credentials are kept to get a new token when the service rejects it
+// it returns ErrMFARequired for an account with two-factor authentication, LoginMFA or LoginRecoveryCode finish the login
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	response := tokenResponse{}
+
+	// logging in twice is harmless, it's retried like a read
+	_, err := c.do(ctx, request{
+		method: "POST",
+		path:   "/login",
+		body: map[string]string{
+			"organization": c.organization,
+			"email":        email,
+			"password":     password,
+		},
+		idempotent: true,
+	}, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.MFAToken != "" {
+		c.setChallenge(response.MFAToken, email, password)
+
+		return ErrMFARequired
+	}
+
+	c.setCredentials(response.Token, email, password)
+
+	return nil
+}
+
+// LoginMFA finishes a Login that returned ErrMFARequired with the authenticator app's code
+// the challenge is only checked once, Login is called again after a wrong code
+func (c *Client) LoginMFA(ctx context.Context, code string) error {
+	return c.loginMFA(ctx, map[string]string{
+		"mfaToken": c.challenge(),
+		"code":     code,
+	})
+}
+
+// LoginRecoveryCode finishes a Login that returned ErrMFARequired with a recovery code, the code can't be used again
+func (c *Client) LoginRecoveryCode(ctx context.Context, recoveryCode string) error {
+	return c.loginMFA(ctx, map[string]string{
+		"mfaToken":     c.challenge(),
+		"recoveryCode": recoveryCode,
+	})
+}
+
+func (c *Client) loginMFA(ctx context.Context, body map[string]string) error {
+	response := tokenResponse{}
+
+	// a code is only accepted once, it isn't retried
+	_, err := c.do(ctx, request{
+		method: "POST",
+		path:   "/login/mfa",
+		body:   body,
+	}, &response)
+	if err != nil {
+		return err
+	}
+
+	email, password := c.credentials()
+	c.setCredentials(response.Token, email, password)
+
+	return nil
+}
+
+// TOTPEnrollment is only returned once, it's what an authenticator app is set up with
+type TOTPEnrollment struct {
+	// Secret is base32, for apps that can't scan QRCode
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	// QRCode is a PNG of URI
+	QRCode []byte `json:"qrCode"`
+}
+
+// EnrollTOTP creates a new secret for the authenticated account, Login only asks for a code once ConfirmTOTP accepted one
+func (c *Client) EnrollTOTP(ctx context.Context) (*TOTPEnrollment, error) {
+	enrollment := &TOTPEnrollment{}
+
+	_, err := c.do(ctx, request{
+		method: "POST",
+		path:   "/users/me/2fa/totp",
+		auth:   true,
+	}, enrollment)
+	if err != nil {
+		return nil, err
+	}
+
+	return enrollment, nil
+}
+
+// ConfirmTOTP enables two-factor authentication with a code of the enrolled secret, it returns the recovery codes
+func (c *Client) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	response := struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{}
+
+	_, err := c.do(ctx, request{
+		method: "POST",
+		path:   "/users/me/2fa/totp/confirm",
+		body: map[string]string{
+			"code": code,
+		},
+		auth: true,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.RecoveryCodes, nil
+}
+
+// Me gets the authenticated account
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	user := &User{}
+
+	header, err := c.do(ctx, request{
+		method:     "GET",
+		path:       "/users/me",
+		auth:       true,
+		idempotent: true,
+	}, user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Version = parseETag(header)
+
+	return user, nil
+}
+
+// UserUpdate is sent as a JSON Merge Patch, nil fields are left as they are
+type UserUpdate struct {
+	// Email is the account to update, the authenticated one when empty, any other requires an admin token
+	Email     string
+	FirstName *string
+	LastName  *string
+	// an empty display name, locale or timezone clears it
+	DisplayName *string
+	Locale      *string
+	Timezone    *string
+	// Attributes are merged into the stored attributes, a nil value removes the key
+	Attributes map[string]interface{}
+	// Version is sent as If-Match when it's set, the update fails with ErrVersionMismatch when the account changed
+	Version int64
+}
+
+// String returns a pointer to v, for UserUpdate's optional fields
+func String(v string) *string {
+	return &v
+}
+
+// UpdateUser applies update and returns the updated account
+func (c *Client) UpdateUser(ctx context.Context, update UserUpdate) (*User, error) {
+	patch := map[string]interface{}{}
+	for field, value := range map[string]*string{
+		"firstName":   update.FirstName,
+		"lastName":    update.LastName,
+		"displayName": update.DisplayName,
+		"locale":      update.Locale,
+		"timezone":    update.Timezone,
+	} {
+		if value != nil {
+			patch[field] = *value
+		}
+	}
+
+	if len(update.Attributes) > 0 {
+		patch["attributes"] = update.Attributes
+	}
+
+	path := "/users"
+	if update.Email != "" {
+		path = "/users/" + url.PathEscape(update.Email)
+	}
+
+	header := http.Header{}
+	if update.Version > 0 {
+		header.Set("If-Match", `"`+strconv.FormatInt(update.Version, 10)+`"`)
+	}
+
+	user := &User{}
+
+	respHeader, err := c.do(ctx, request{
+		method:      "PATCH",
+		path:        path,
+		header:      header,
+		body:        patch,
+		contentType: "application/merge-patch+json",
+		auth:        true,
+		// a merge patch applied twice has the same result, but a retried If-Match would fail against the first attempt's version
+		idempotent: update.Version == 0,
+	}, user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Version = parseETag(respHeader)
+
+	return user, nil
+}
+
+// parseETag reads the version out of `"3"`, zero when there isn't one
+func parseETag(header http.Header) int64 {
+	version, _ := strconv.ParseInt(strings.Trim(header.Get("ETag"), `"`), 10, 64)
+
+	return version
+}
+
+type ListUsers struct {
+	// PageSize is how many users are requested at a time, DefaultPageSize when zero
+	PageSize int
+	// Cursor is the Next of a previous page, the first page when empty
+	Cursor string
+}
+
+type UsersPage struct {
+	Users []User `json:"users"`
+	// Next is the cursor of the following page, empty on the last one
+	Next string `json:"next"`
+}
+
+// ListUsersPage gets one page of users ordered by email
+func (c *Client) ListUsersPage(ctx context.Context, opts ListUsers) (*UsersPage, error) {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(opts.PageSize))
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+
+	page := &UsersPage{}
+
+	_, err := c.do(ctx, request{
+		method:     "GET",
+		path:       "/users",
+		query:      query,
+		auth:       true,
+		idempotent: true,
+	}, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// ListUsers iterates over every user ordered by email, pages are requested as they're reached
+//
+//	it := c.ListUsers(client.ListUsers{})
+//	for it.Next(ctx) {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+func (c *Client) ListUsers(opts ListUsers) *UserIterator {
+	return &UserIterator{
+		client: c,
+		opts:   opts,
+	}
+}
+
+// UserIterator isn't safe for concurrent use
+type UserIterator struct {
+	client *Client
+	opts   ListUsers
+
+	users []User
+	i     int
+	done  bool
+	err   error
+}
+
+// Next advances to the next user, false is returned after the last one or an error
+func (it *UserIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+
+	if it.i+1 < len(it.users) {
+		it.i++
+		return true
+	}
+
+	for !it.done {
+		page, err := it.client.ListUsersPage(ctx, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.users = page.Users
+		it.i = 0
+		it.opts.Cursor = page.Next
+		it.done = page.Next == ""
+
+		if len(it.users) > 0 {
+			return true
+		}
+	}
+
+	it.users = nil
+
+	return false
+}
+
+// User is the current user, only valid after Next returned true
+func (it *UserIterator) User() User {
+	return it.users[it.i]
+}
+
+func (it *UserIterator) Err() error {
+	return it.err
+}
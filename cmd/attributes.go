@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sabey/ddd"
+)
+
+// registerAttributes declares the custom user attributes of `team:string,seats:number,newsletter:boolean`
+func registerAttributes(schema string) error {
+	if schema == "" {
+		return nil
+	}
+
+	for _, attr := range strings.Split(schema, ",") {
+		key, typ, ok := strings.Cut(strings.TrimSpace(attr), ":")
+		if !ok {
+			return fmt.Errorf("attribute isn't key:type: %s", attr)
+		}
+
+		if err := ddd.Attributes.Register(key, ddd.AttributeType(typ)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
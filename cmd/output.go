@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/sabey/ddd"
+)
+
+// userWriter prints the users of a command, single is true for the commands about one account
+type userWriter func(w io.Writer, users []*ddd.User, single bool) error
+
+var userWriters = map[string]userWriter{
+	"table": writeUserTable,
+	"json":  writeUserJSON,
+	"csv":   writeUserCSV,
+}
+
+// userOutput is a user without its password
+type userOutput struct {
+	Email       string                 `json:"email"`
+	FirstName   string                 `json:"firstName"`
+	LastName    string                 `json:"lastName"`
+	DisplayName string                 `json:"displayName"`
+	Locale      string                 `json:"locale"`
+	Timezone    string                 `json:"timezone"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	Avatar      string                 `json:"avatar"`
+	Admin       bool                   `json:"admin"`
+	Disabled    bool                   `json:"disabled"`
+	Version     int64                  `json:"version"`
+}
+
+func newUserOutput(user *ddd.User) userOutput {
+	attributes := user.Attributes
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+
+	return userOutput{
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Attributes:  attributes,
+		Avatar:      user.Avatar,
+		Admin:       user.Admin,
+		Disabled:    user.Disabled,
+		Version:     user.Version,
+	}
+}
+
+func writeUserJSON(w io.Writer, users []*ddd.User, single bool) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	if single && len(users) == 1 {
+		return e.Encode(newUserOutput(users[0]))
+	}
+
+	outputs := make([]userOutput, 0, len(users))
+	for _, user := range users {
+		outputs = append(outputs, newUserOutput(user))
+	}
+
+	return e.Encode(outputs)
+}
+
+// writeUserCSV has every field, the attributes are a json object
+func writeUserCSV(w io.Writer, users []*ddd.User, _ bool) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"email", "firstName", "lastName", "displayName", "locale", "timezone", "attributes", "avatar", "admin", "disabled", "version"})
+
+	for _, user := range users {
+		output := newUserOutput(user)
+
+		attributes, err := json.Marshal(output.Attributes)
+		if err != nil {
+			return err
+		}
+
+		cw.Write([]string{
+			output.Email, output.FirstName, output.LastName, output.DisplayName, output.Locale, output.Timezone,
+			string(attributes), output.Avatar,
+			strconv.FormatBool(output.Admin), strconv.FormatBool(output.Disabled), strconv.FormatInt(output.Version, 10),
+		})
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeUserTable only has the columns that fit a terminal, json and csv have the rest
+func writeUserTable(w io.Writer, users []*ddd.User, _ bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "EMAIL\tFIRST NAME\tLAST NAME\tDISPLAY NAME\tADMIN\tDISABLED\tVERSION")
+
+	for _, user := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%t\t%d\n",
+			user.Email, user.FirstName, user.LastName, user.DisplayName, user.Admin, user.Disabled, user.Version,
+		)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+)
+
+func runUserCommand(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	stdout := &bytes.Buffer{}
+	err := runUser(args, strings.NewReader(stdin), stdout)
+
+	return stdout.String(), err
+}
+
+func TestUserCommands(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "ddd.db")
+
+	if _, err := runUserCommand(t, "pass\n", "create", "-dsn", dsn, "-first-name", "Jackson", "-last-name", "Sabey", "-admin", "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+
+	if _, err := runUserCommand(t, "", "create", "-dsn", dsn, "-first-name", "Jackson", "-last-name", "Sabey", "-password", "pass", "jackson+2@juandefu.ca"); err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+
+	// the same validation as the api
+	if _, err := runUserCommand(t, "pass\n", "create", "-dsn", dsn, "-last-name", "Sabey", "jackson+3@juandefu.ca"); err == nil || err.Error() != "firstName was empty" {
+		t.Errorf("expected firstName was empty, got: %v", err)
+	}
+
+	if _, err := runUserCommand(t, "pass\n", "create", "-dsn", dsn, "-first-name", "Jackson", "-last-name", "Sabey", "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got: %v", err)
+	}
+
+	out, err := runUserCommand(t, "", "list", "-dsn", dsn, "-admin", "true", "-output", "json")
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	users := []userOutput{}
+	if err := json.Unmarshal([]byte(out), &users); err != nil {
+		t.Fatalf("failed to decode %s: %s", out, err)
+	}
+
+	if len(users) != 1 || users[0].Email != "jackson@juandefu.ca" || !users[0].Admin {
+		t.Errorf("unexpected users: %+v", users)
+	}
+
+	out, err = runUserCommand(t, "", "list", "-dsn", dsn, "-output", "csv")
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	expected := "email,firstName,lastName,displayName,locale,timezone,attributes,avatar,admin,disabled,version\n" +
+		"jackson+2@juandefu.ca,Jackson,Sabey,,,,{},,false,false,1\n" +
+		"jackson@juandefu.ca,Jackson,Sabey,,,,{},,true,false,1\n"
+	if out != expected {
+		t.Errorf("unexpected csv:\n%s", out)
+	}
+
+	if _, err := runUserCommand(t, "changed\n", "set-password", "-dsn", dsn, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to set password: %s", err)
+	}
+
+	if _, err := runUserCommand(t, "", "disable", "-dsn", dsn, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to disable: %s", err)
+	}
+
+	out, err = runUserCommand(t, "", "show", "-dsn", dsn, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to show: %s", err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "true   true      3") {
+		t.Errorf("unexpected table:\n%s", out)
+	}
+
+	r, err := openRepository(repositoryOpts{DSN: dsn})
+	if err != nil {
+		t.Fatalf("failed to open repository: %s", err)
+	}
+	defer r.Close()
+
+	if _, err := r.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("changed"),
+	}); !errors.Is(err, ddd.ErrUserDisabled) {
+		t.Errorf("expected ErrUserDisabled, got: %v", err)
+	}
+
+	if _, err := runUserCommand(t, "", "delete", "-dsn", dsn, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := runUserCommand(t, "", "show", "-dsn", dsn, "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func TestUserCommands_Invalid(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "ddd.db")
+
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"show", "-dsn", dsn},
+		{"show", "-dsn", dsn, "a@b", "c@d"},
+		{"list", "-dsn", dsn, "a@b"},
+		{"list", "-dsn", dsn, "-output", "xml"},
+		{"list", "-dsn", dsn, "-admin", "maybe"},
+		{"set-password", "-dsn", dsn, "jackson@juandefu.ca"},
+	} {
+		if _, err := runUserCommand(t, "", args...); err == nil {
+			t.Errorf("%v succeeded?", args)
+		}
+	}
+}
+
+func TestUserCommands_Organization(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "ddd.db")
+
+	if _, err := runUserCommand(t, "", "create", "-dsn", dsn, "-org", "acme", "-first-name", "Jackson", "-last-name", "Sabey", "-password", "pass", "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrOrganizationNotFound) {
+		t.Errorf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+
+	if _, err := runUserCommand(t, "", "list", "-dsn", dsn, "-org", "Acme"); err == nil {
+		t.Errorf("accepted an invalid organization")
+	}
+
+	r, err := openRepository(repositoryOpts{DSN: dsn})
+	if err != nil {
+		t.Fatalf("failed to open repository: %s", err)
+	}
+	defer r.Close()
+
+	if _, err := r.(ddd.OrganizationRepository).CreateOrganization(context.Background(), ddd.OrganizationCreate{ID: "acme", Name: "Acme Inc."}); err != nil {
+		t.Fatalf("failed to create organization: %s", err)
+	}
+
+	if _, err := runUserCommand(t, "", "create", "-dsn", dsn, "-org", "acme", "-first-name", "Jackson", "-last-name", "Sabey", "-password", "pass", "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+
+	if _, err := runUserCommand(t, "", "show", "-dsn", dsn, "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	out, err := runUserCommand(t, "", "show", "-dsn", dsn, "-org", "acme", "-output", "json", "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to show: %s", err)
+	}
+
+	user := userOutput{}
+	if err := json.Unmarshal([]byte(out), &user); err != nil {
+		t.Fatalf("failed to decode %s: %s", out, err)
+	}
+
+	if user.Email != "jackson@juandefu.ca" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+}
+
+func TestUserImportExport(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "ddd.db")
+
+	data := "email,firstName,lastName,password\n" +
+		"a@juandefu.ca,Jackson,Sabey,pass\n" +
+		"b@juandefu.ca,,Sabey,pass\n"
+
+	// a dry run writes nothing
+	out, err := runUserCommand(t, data, "import", "-dsn", dsn, "-dry-run")
+	if err == nil || out != "line 3: b@juandefu.ca: firstName was empty\ndry run: created 1, updated 0, skipped 0, failed 1\n" {
+		t.Errorf("unexpected dry run: %v\n%s", err, out)
+	}
+
+	out, err = runUserCommand(t, "", "export", "-dsn", dsn)
+	if err != nil || out != "email,firstName,lastName,password,passwordHash,displayName,locale,timezone,attributes,admin,disabled,version\n" {
+		t.Errorf("unexpected export: %v\n%s", err, out)
+	}
+
+	report := filepath.Join(t.TempDir(), "report.csv")
+	if _, err := runUserCommand(t, data, "import", "-dsn", dsn, "-report", report); err == nil || err.Error() != "1 rows failed" {
+		t.Errorf("expected 1 rows failed, got: %v", err)
+	}
+
+	bs, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("failed to read the report: %s", err)
+	}
+
+	if string(bs) != "line,email,status,error\n2,a@juandefu.ca,created,\n3,b@juandefu.ca,failed,firstName was empty\n" {
+		t.Errorf("unexpected report:\n%s", bs)
+	}
+
+	// the format is taken from the extension
+	jsonl := filepath.Join(t.TempDir(), "users.jsonl")
+	if err := os.WriteFile(jsonl, []byte(`{"email":"a@juandefu.ca","firstName":"JACKSON","lastName":"Sabey","password":"pass"}`+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %s", jsonl, err)
+	}
+
+	if out, err := runUserCommand(t, "", "import", "-dsn", dsn, "-mode", "upsert", jsonl); err != nil || out != "created 0, updated 1, skipped 0, failed 0\n" {
+		t.Errorf("unexpected import: %v\n%s", err, out)
+	}
+
+	out, err = runUserCommand(t, "", "export", "-dsn", dsn, "-format", "jsonl", "-password-hashes")
+	if err != nil || !strings.Contains(out, `"firstName":"JACKSON"`) || !strings.Contains(out, `"passwordHash":"`+ddd.HashPassword("pass")+`"`) {
+		t.Errorf("unexpected export: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,274 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// APIKeyRepositoryFactory returns an empty repository, it's called once per subtest
+// the user repository has to share the keys' storage, the accounts are created through it
+type APIKeyRepositoryFactory func(t *testing.T) (ddd.APIKeyRepository, ddd.UserRepository)
+
+// RunAPIKeyRepository runs the api key suite as subtests of t
+func RunAPIKeyRepository(t *testing.T, factory APIKeyRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.APIKeyRepository, ddd.UserRepository)
+	}{
+		{"CreateAPIKey", testCreateAPIKey},
+		{"CreateAPIKey_NotFound", testCreateAPIKeyNotFound},
+		{"GetAPIKey_Expired", testGetAPIKeyExpired},
+		{"ListAPIKeys", testListAPIKeys},
+		{"DeleteAPIKey", testDeleteAPIKey},
+		{"TouchAPIKey", testTouchAPIKey},
+		{"DeleteUser", testDeleteUserAPIKeys},
+		{"Tenant", testTenantAPIKeys},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			apiKeyRepo, userRepo := factory(t)
+			tt.test(t, apiKeyRepo, userRepo)
+		})
+	}
+}
+
+func newAPIKeyCreate(email string, name string) ddd.APIKeyCreate {
+	return ddd.APIKeyCreate{
+		Organization: ddd.DefaultOrganization,
+		Email:        email,
+		Name:         name,
+		Scopes:       []string{ddd.APIKeyScopeRead, ddd.APIKeyScopeWrite},
+	}
+}
+
+func mustCreateAPIKey(t *testing.T, apiKeyRepo ddd.APIKeyRepository, opts ddd.APIKeyCreate) *ddd.APIKey {
+	t.Helper()
+
+	key, err := apiKeyRepo.CreateAPIKey(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to create api key: %s", err)
+	}
+
+	return key
+}
+
+func testCreateAPIKey(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+
+	opts := newAPIKeyCreate("jackson@juandefu.ca", "Deploys")
+	opts.ExpiresAt = &expiresAt
+
+	created := mustCreateAPIKey(t, apiKeyRepo, opts)
+
+	if ddd.APIKeyID(created.Key) != created.ID || created.Organization != ddd.DefaultOrganization || created.Email != "jackson@juandefu.ca" ||
+		created.Name != "Deploys" || len(created.Scopes) != 2 || created.CreatedAt.Before(before) ||
+		created.ExpiresAt == nil || !created.ExpiresAt.Equal(expiresAt) || created.LastUsedAt != nil || created.LastUsedIP != "" {
+		t.Errorf("unknown api key: %+v", created)
+	}
+
+	key, err := apiKeyRepo.GetAPIKey(ctx, created.Key)
+	if err != nil {
+		t.Fatalf("failed to get api key: %s", err)
+	}
+
+	// the key itself isn't stored
+	if key.Key != "" || key.ID != created.ID || key.Email != created.Email || key.Name != created.Name ||
+		!key.HasScope(ddd.APIKeyScopeRead) || !key.HasScope(ddd.APIKeyScopeWrite) || key.HasScope(ddd.APIKeyScopeAdmin) ||
+		!key.CreatedAt.Equal(created.CreatedAt) || key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected %+v, got: %+v", created, key)
+	}
+
+	// the id is public, the rest of the key isn't
+	for _, wrong := range []string{created.ID, created.Key + "x", created.ID + "_" + created.Key[len(created.ID)+1:len(created.Key)-1] + "x", ""} {
+		if _, err := apiKeyRepo.GetAPIKey(ctx, wrong); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+			t.Errorf("expected ErrAPIKeyNotFound for %q, got: %v", wrong, err)
+		}
+	}
+
+	invalid := newAPIKeyCreate("jackson@juandefu.ca", "Deploys")
+	invalid.Scopes = []string{"delete"}
+	if _, err := apiKeyRepo.CreateAPIKey(ctx, invalid); err == nil {
+		t.Errorf("an api key with an invalid scope was created")
+	}
+}
+
+func testCreateAPIKeyNotFound(t *testing.T, apiKeyRepo ddd.APIKeyRepository, _ ddd.UserRepository) {
+	if _, err := apiKeyRepo.CreateAPIKey(context.Background(), newAPIKeyCreate("jackson@juandefu.ca", "Deploys")); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func testGetAPIKeyExpired(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	expiresAt := time.Now().Add(100 * time.Millisecond)
+
+	opts := newAPIKeyCreate("jackson@juandefu.ca", "Deploys")
+	opts.ExpiresAt = &expiresAt
+
+	key := mustCreateAPIKey(t, apiKeyRepo, opts)
+
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := apiKeyRepo.GetAPIKey(context.Background(), key.Key); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+
+	// an expired key is still listed, its account can see why it stopped working
+	keys, err := apiKeyRepo.ListAPIKeys(context.Background(), "jackson@juandefu.ca")
+	if err != nil || len(keys) != 1 {
+		t.Errorf("unknown api keys: %v %v", keys, err)
+	}
+}
+
+func testListAPIKeys(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca", "jackson@sabey.co")
+
+	keys, err := apiKeyRepo.ListAPIKeys(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to list api keys: %s", err)
+	}
+
+	if keys == nil || len(keys) != 0 {
+		t.Errorf("expected no api keys, got: %v", keys)
+	}
+
+	first := mustCreateAPIKey(t, apiKeyRepo, newAPIKeyCreate("jackson@juandefu.ca", "Deploys"))
+	mustCreateAPIKey(t, apiKeyRepo, newAPIKeyCreate("jackson@sabey.co", "Backups"))
+	second := mustCreateAPIKey(t, apiKeyRepo, newAPIKeyCreate("jackson@juandefu.ca", "Reports"))
+
+	keys, err = apiKeyRepo.ListAPIKeys(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to list api keys: %s", err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != first.ID || keys[1].ID != second.ID || keys[0].Key != "" || keys[1].Name != "Reports" {
+		t.Errorf("unknown api keys: %v", keys)
+	}
+}
+
+func testDeleteAPIKey(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca", "jackson@sabey.co")
+
+	key := mustCreateAPIKey(t, apiKeyRepo, newAPIKeyCreate("jackson@juandefu.ca", "Deploys"))
+
+	// another account can't delete it
+	if err := apiKeyRepo.DeleteAPIKey(ctx, "jackson@sabey.co", key.ID); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+
+	if err := apiKeyRepo.DeleteAPIKey(ctx, "jackson@juandefu.ca", key.ID); err != nil {
+		t.Fatalf("failed to delete api key: %s", err)
+	}
+
+	if _, err := apiKeyRepo.GetAPIKey(ctx, key.Key); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+
+	if err := apiKeyRepo.DeleteAPIKey(ctx, "jackson@juandefu.ca", key.ID); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+}
+
+func testTouchAPIKey(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	key := mustCreateAPIKey(t, apiKeyRepo, newAPIKeyCreate("jackson@juandefu.ca", "Deploys"))
+
+	if err := apiKeyRepo.TouchAPIKey(ctx, key.ID, "203.0.113.7"); err != nil {
+		t.Fatalf("failed to touch api key: %s", err)
+	}
+
+	got, err := apiKeyRepo.GetAPIKey(ctx, key.Key)
+	if err != nil {
+		t.Fatalf("failed to get api key: %s", err)
+	}
+
+	if got.LastUsedAt == nil || got.LastUsedAt.Before(before) || got.LastUsedIP != "203.0.113.7" {
+		t.Errorf("unknown last use: %v %s", got.LastUsedAt, got.LastUsedIP)
+	}
+
+	if err := apiKeyRepo.TouchAPIKey(ctx, "ddd_0000000000000000", "203.0.113.7"); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+}
+
+func testDeleteUserAPIKeys(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	key := mustCreateAPIKey(t, apiKeyRepo, newAPIKeyCreate("jackson@juandefu.ca", "Deploys"))
+
+	if err := userRepo.Delete(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete user: %s", err)
+	}
+
+	if _, err := apiKeyRepo.GetAPIKey(ctx, key.Key); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+
+	// a new account with the email doesn't get the keys back
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	keys, err := apiKeyRepo.ListAPIKeys(ctx, "jackson@juandefu.ca")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("unknown api keys: %v %v", keys, err)
+	}
+}
+
+func testTenantAPIKeys(t *testing.T, apiKeyRepo ddd.APIKeyRepository, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	if _, err := userRepo.Create(acmeCtx, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user in %s: %s", acme, err)
+	}
+
+	opts := newAPIKeyCreate("jackson@juandefu.ca", "Deploys")
+
+	// the default organization doesn't have the account
+	if _, err := apiKeyRepo.CreateAPIKey(context.Background(), opts); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	opts.Organization = acme
+	key := mustCreateAPIKey(t, apiKeyRepo, opts)
+
+	// a request only has the key
+	got, err := apiKeyRepo.GetAPIKey(context.Background(), key.Key)
+	if err != nil || got.Organization != acme {
+		t.Errorf("unknown api key: %+v %v", got, err)
+	}
+
+	keys, err := apiKeyRepo.ListAPIKeys(context.Background(), "jackson@juandefu.ca")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("listed another organization's api keys: %v %v", keys, err)
+	}
+
+	if err := apiKeyRepo.DeleteAPIKey(context.Background(), "jackson@juandefu.ca", key.ID); !errors.Is(err, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got: %v", err)
+	}
+
+	keys, err = apiKeyRepo.ListAPIKeys(acmeCtx, "jackson@juandefu.ca")
+	if err != nil || len(keys) != 1 {
+		t.Errorf("unknown api keys: %v %v", keys, err)
+	}
+}
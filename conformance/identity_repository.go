@@ -0,0 +1,205 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// IdentityRepositoryFactory returns an empty repository, it's called once per subtest
+// the user repository has to share the identities' storage, the accounts are created through it
+type IdentityRepositoryFactory func(t *testing.T) (ddd.IdentityRepository, ddd.UserRepository)
+
+// RunIdentityRepository runs the identity suite as subtests of t
+func RunIdentityRepository(t *testing.T, factory IdentityRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.IdentityRepository, ddd.UserRepository)
+	}{
+		{"CreateIdentity", testCreateIdentity},
+		{"CreateIdentity_Exists", testCreateIdentityExists},
+		{"CreateIdentity_NotFound", testCreateIdentityNotFound},
+		{"ListIdentities", testListIdentities},
+		{"DeleteUser", testDeleteUserIdentities},
+		{"Tenant", testTenantIdentities},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			identityRepo, userRepo := factory(t)
+			tt.test(t, identityRepo, userRepo)
+		})
+	}
+}
+
+func newIdentityCreate(provider string, subject string, email string) ddd.IdentityCreate {
+	return ddd.IdentityCreate{
+		Provider:     provider,
+		Subject:      subject,
+		Organization: ddd.DefaultOrganization,
+		Email:        email,
+	}
+}
+
+func testCreateIdentity(t *testing.T, identityRepo ddd.IdentityRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	created, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("acme-sso", "248289761001", "jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+
+	if created.Provider != "acme-sso" || created.Subject != "248289761001" || created.Organization != ddd.DefaultOrganization ||
+		created.Email != "jackson@juandefu.ca" || created.CreatedAt.Before(before) {
+		t.Errorf("unknown identity: %+v", created)
+	}
+
+	identity, err := identityRepo.GetIdentity(ctx, "acme-sso", "248289761001")
+	if err != nil {
+		t.Fatalf("failed to get identity: %s", err)
+	}
+
+	if *identity != *created {
+		t.Errorf("expected %+v, got: %+v", created, identity)
+	}
+
+	// the subject is the provider's, another provider's subject is another identity
+	if _, err := identityRepo.GetIdentity(ctx, "other-sso", "248289761001"); !errors.Is(err, ddd.ErrIdentityNotFound) {
+		t.Errorf("expected ErrIdentityNotFound, got: %v", err)
+	}
+
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("Acme SSO", "248289761001", "jackson@juandefu.ca")); err == nil {
+		t.Errorf("an identity with an invalid provider was created")
+	}
+}
+
+func testCreateIdentityExists(t *testing.T, identityRepo ddd.IdentityRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca", "jackson@sabey.co")
+
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("acme-sso", "248289761001", "jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("acme-sso", "248289761001", "jackson@sabey.co")); !errors.Is(err, ddd.ErrIdentityExists) {
+		t.Errorf("expected ErrIdentityExists, got: %v", err)
+	}
+
+	// an account can have an identity at every provider, and several at one
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("acme-sso", "248289761002", "jackson@juandefu.ca")); err != nil {
+		t.Errorf("failed to create identity: %s", err)
+	}
+
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("other-sso", "248289761001", "jackson@sabey.co")); err != nil {
+		t.Errorf("failed to create identity: %s", err)
+	}
+}
+
+func testCreateIdentityNotFound(t *testing.T, identityRepo ddd.IdentityRepository, _ ddd.UserRepository) {
+	if _, err := identityRepo.CreateIdentity(context.Background(), newIdentityCreate("acme-sso", "248289761001", "jackson@juandefu.ca")); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func testListIdentities(t *testing.T, identityRepo ddd.IdentityRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca", "jackson@sabey.co")
+
+	identities, err := identityRepo.ListIdentities(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to list identities: %s", err)
+	}
+
+	if identities == nil || len(identities) != 0 {
+		t.Errorf("expected no identities, got: %v", identities)
+	}
+
+	for _, create := range []ddd.IdentityCreate{
+		newIdentityCreate("other-sso", "2", "jackson@juandefu.ca"),
+		newIdentityCreate("acme-sso", "3", "jackson@sabey.co"),
+		newIdentityCreate("acme-sso", "1", "jackson@juandefu.ca"),
+	} {
+		if _, err := identityRepo.CreateIdentity(ctx, create); err != nil {
+			t.Fatalf("failed to create identity: %s", err)
+		}
+	}
+
+	identities, err = identityRepo.ListIdentities(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to list identities: %s", err)
+	}
+
+	if len(identities) != 2 || identities[0].Provider != "other-sso" || identities[1].Provider != "acme-sso" || identities[1].Subject != "1" {
+		t.Errorf("unknown identities: %v", identities)
+	}
+}
+
+func testDeleteUserIdentities(t *testing.T, identityRepo ddd.IdentityRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("acme-sso", "248289761001", "jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+
+	if err := userRepo.Delete(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete user: %s", err)
+	}
+
+	if _, err := identityRepo.GetIdentity(ctx, "acme-sso", "248289761001"); !errors.Is(err, ddd.ErrIdentityNotFound) {
+		t.Errorf("expected ErrIdentityNotFound, got: %v", err)
+	}
+
+	// the subject can be linked to a new account
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	if _, err := identityRepo.CreateIdentity(ctx, newIdentityCreate("acme-sso", "248289761001", "jackson@juandefu.ca")); err != nil {
+		t.Errorf("failed to create identity: %s", err)
+	}
+}
+
+func testTenantIdentities(t *testing.T, identityRepo ddd.IdentityRepository, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	if _, err := userRepo.Create(acmeCtx, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user in %s: %s", acme, err)
+	}
+
+	create := newIdentityCreate("acme-sso", "248289761001", "jackson@juandefu.ca")
+
+	// the default organization doesn't have the account
+	if _, err := identityRepo.CreateIdentity(context.Background(), create); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	create.Organization = acme
+	if _, err := identityRepo.CreateIdentity(context.Background(), create); err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+
+	// a login doesn't know the organization yet
+	identity, err := identityRepo.GetIdentity(context.Background(), "acme-sso", "248289761001")
+	if err != nil || identity.Organization != acme {
+		t.Errorf("unknown identity: %+v %v", identity, err)
+	}
+
+	identities, err := identityRepo.ListIdentities(context.Background(), "jackson@juandefu.ca")
+	if err != nil || len(identities) != 0 {
+		t.Errorf("listed another organization's identities: %v %v", identities, err)
+	}
+
+	identities, err = identityRepo.ListIdentities(acmeCtx, "jackson@juandefu.ca")
+	if err != nil || len(identities) != 1 {
+		t.Errorf("unknown identities: %v %v", identities, err)
+	}
+}
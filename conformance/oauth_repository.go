@@ -0,0 +1,404 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// OAuthRepositoryFactory returns an empty repository, it's called once per subtest
+// the user repository has to share the codes' and tokens' storage, the accounts are created through it
+type OAuthRepositoryFactory func(t *testing.T) (ddd.OAuthRepository, ddd.UserRepository)
+
+// RunOAuthRepository runs the oauth suite as subtests of t
+func RunOAuthRepository(t *testing.T, factory OAuthRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.OAuthRepository, ddd.UserRepository)
+	}{
+		{"CreateOAuthClient", testCreateOAuthClient},
+		{"CreateOAuthClient_Public", testCreateOAuthClientPublic},
+		{"ListOAuthClients", testListOAuthClients},
+		{"DeleteOAuthClient", testDeleteOAuthClient},
+		{"ConsumeOAuthCode", testConsumeOAuthCode},
+		{"ConsumeOAuthCode_Expired", testConsumeOAuthCodeExpired},
+		{"CreateOAuthCode_NotFound", testCreateOAuthCodeNotFound},
+		{"GetOAuthToken", testGetOAuthToken},
+		{"GetOAuthToken_Expired", testGetOAuthTokenExpired},
+		{"RevokeOAuthToken", testRevokeOAuthToken},
+		{"DeleteUser", testDeleteUserOAuth},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			oauthRepo, userRepo := factory(t)
+			tt.test(t, oauthRepo, userRepo)
+		})
+	}
+}
+
+func newOAuthClientCreate(name string) ddd.OAuthClientCreate {
+	return ddd.OAuthClientCreate{
+		Name:         name,
+		RedirectURIs: []string{"https://app.example/callback", "http://localhost:3000/callback"},
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+func mustCreateOAuthClient(t *testing.T, oauthRepo ddd.OAuthRepository, name string) *ddd.OAuthClient {
+	t.Helper()
+
+	client, err := oauthRepo.CreateOAuthClient(context.Background(), newOAuthClientCreate(name))
+	if err != nil {
+		t.Fatalf("failed to create oauth client: %s", err)
+	}
+
+	return client
+}
+
+func newOAuthCodeCreate(clientID string, email string) ddd.OAuthCodeCreate {
+	return ddd.OAuthCodeCreate{
+		ClientID:      clientID,
+		Organization:  ddd.DefaultOrganization,
+		Email:         email,
+		RedirectURI:   "https://app.example/callback",
+		Scopes:        []string{"openid", "email"},
+		CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		Nonce:         "nonce",
+	}
+}
+
+func newOAuthTokenCreate(clientID string, email string) ddd.OAuthTokenCreate {
+	tc := ddd.OAuthTokenCreate{
+		ClientID: clientID,
+		Email:    email,
+		Scopes:   []string{"openid", "email"},
+	}
+
+	if email != "" {
+		tc.Organization = ddd.DefaultOrganization
+	}
+
+	return tc
+}
+
+func testCreateOAuthClient(t *testing.T, oauthRepo ddd.OAuthRepository, _ ddd.UserRepository) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	opts := newOAuthClientCreate("Example")
+
+	created, err := oauthRepo.CreateOAuthClient(ctx, opts)
+	if err != nil {
+		t.Fatalf("failed to create oauth client: %s", err)
+	}
+
+	if created.ID == "" || created.Secret == "" || created.SecretHash != ddd.HashToken(created.Secret) || created.CreatedAt.Before(before) {
+		t.Errorf("unknown client: %+v", created)
+	}
+
+	client, err := oauthRepo.GetOAuthClient(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("failed to get oauth client: %s", err)
+	}
+
+	// the secret isn't kept
+	if client.ID != created.ID || client.Name != opts.Name || client.Public || client.Secret != "" || client.SecretHash != created.SecretHash ||
+		!reflect.DeepEqual(client.RedirectURIs, opts.RedirectURIs) || !reflect.DeepEqual(client.Scopes, opts.Scopes) ||
+		!client.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("unknown client: %+v", client)
+	}
+
+	if _, err := oauthRepo.GetOAuthClient(ctx, "unknown"); !errors.Is(err, ddd.ErrOAuthClientNotFound) {
+		t.Errorf("expected ErrOAuthClientNotFound, got: %v", err)
+	}
+
+	invalid := opts
+	invalid.RedirectURIs = []string{"http://app.example/callback"}
+	if _, err := oauthRepo.CreateOAuthClient(ctx, invalid); err == nil {
+		t.Errorf("a client with an http redirect uri was created")
+	}
+}
+
+func testCreateOAuthClientPublic(t *testing.T, oauthRepo ddd.OAuthRepository, _ ddd.UserRepository) {
+	opts := newOAuthClientCreate("Example")
+	opts.Public = true
+
+	created, err := oauthRepo.CreateOAuthClient(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to create oauth client: %s", err)
+	}
+
+	if created.Secret != "" || created.SecretHash != "" {
+		t.Errorf("a public client has a secret: %+v", created)
+	}
+
+	client, err := oauthRepo.GetOAuthClient(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("failed to get oauth client: %s", err)
+	}
+
+	if !client.Public || client.SecretHash != "" {
+		t.Errorf("unknown client: %+v", client)
+	}
+}
+
+func testListOAuthClients(t *testing.T, oauthRepo ddd.OAuthRepository, _ ddd.UserRepository) {
+	clients, err := oauthRepo.ListOAuthClients(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list oauth clients: %s", err)
+	}
+
+	if clients == nil || len(clients) != 0 {
+		t.Errorf("expected no clients, got: %v", clients)
+	}
+
+	first := mustCreateOAuthClient(t, oauthRepo, "First")
+	second := mustCreateOAuthClient(t, oauthRepo, "Second")
+
+	clients, err = oauthRepo.ListOAuthClients(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list oauth clients: %s", err)
+	}
+
+	if len(clients) != 2 || clients[0].ID != first.ID || clients[1].ID != second.ID || clients[0].Secret != "" {
+		t.Errorf("unknown clients: %v", clients)
+	}
+}
+
+func testDeleteOAuthClient(t *testing.T, oauthRepo ddd.OAuthRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+
+	code, err := oauthRepo.CreateOAuthCode(ctx, newOAuthCodeCreate(client.ID, "jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create oauth code: %s", err)
+	}
+
+	token, err := oauthRepo.CreateOAuthToken(ctx, newOAuthTokenCreate(client.ID, "jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create oauth token: %s", err)
+	}
+
+	if err := oauthRepo.DeleteOAuthClient(ctx, client.ID); err != nil {
+		t.Fatalf("failed to delete oauth client: %s", err)
+	}
+
+	if err := oauthRepo.DeleteOAuthClient(ctx, client.ID); !errors.Is(err, ddd.ErrOAuthClientNotFound) {
+		t.Errorf("expected ErrOAuthClientNotFound, got: %v", err)
+	}
+
+	if _, err := oauthRepo.ConsumeOAuthCode(ctx, code.Code); !errors.Is(err, ddd.ErrOAuthCodeNotFound) {
+		t.Errorf("expected ErrOAuthCodeNotFound, got: %v", err)
+	}
+
+	if _, err := oauthRepo.GetOAuthToken(ctx, token.Token); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+}
+
+func testConsumeOAuthCode(t *testing.T, oauthRepo ddd.OAuthRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+	opts := newOAuthCodeCreate(client.ID, "jackson@juandefu.ca")
+
+	created, err := oauthRepo.CreateOAuthCode(ctx, opts)
+	if err != nil {
+		t.Fatalf("failed to create oauth code: %s", err)
+	}
+
+	if created.Code == "" || !created.ExpiresAt.After(time.Now()) {
+		t.Errorf("unknown code: %+v", created)
+	}
+
+	code, err := oauthRepo.ConsumeOAuthCode(ctx, created.Code)
+	if err != nil {
+		t.Fatalf("failed to consume oauth code: %s", err)
+	}
+
+	if code.ClientID != client.ID || code.Organization != ddd.DefaultOrganization || code.Email != opts.Email || code.RedirectURI != opts.RedirectURI ||
+		!reflect.DeepEqual(code.Scopes, opts.Scopes) || code.CodeChallenge != opts.CodeChallenge || code.Nonce != opts.Nonce ||
+		!code.ExpiresAt.Equal(created.ExpiresAt) || code.Code != "" {
+		t.Errorf("unknown code: %+v", code)
+	}
+
+	// a code is exchanged once
+	if _, err := oauthRepo.ConsumeOAuthCode(ctx, created.Code); !errors.Is(err, ddd.ErrOAuthCodeNotFound) {
+		t.Errorf("expected ErrOAuthCodeNotFound, got: %v", err)
+	}
+}
+
+func testConsumeOAuthCodeExpired(t *testing.T, oauthRepo ddd.OAuthRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+	opts := newOAuthCodeCreate(client.ID, "jackson@juandefu.ca")
+	opts.TTL = time.Millisecond
+
+	code, err := oauthRepo.CreateOAuthCode(ctx, opts)
+	if err != nil {
+		t.Fatalf("failed to create oauth code: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := oauthRepo.ConsumeOAuthCode(ctx, code.Code); !errors.Is(err, ddd.ErrOAuthCodeNotFound) {
+		t.Errorf("expected ErrOAuthCodeNotFound, got: %v", err)
+	}
+}
+
+func testCreateOAuthCodeNotFound(t *testing.T, oauthRepo ddd.OAuthRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+
+	if _, err := oauthRepo.CreateOAuthCode(ctx, newOAuthCodeCreate(client.ID, "jackson@juandefu.ca")); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if _, err := oauthRepo.CreateOAuthToken(ctx, newOAuthTokenCreate(client.ID, "jackson@juandefu.ca")); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	if _, err := oauthRepo.CreateOAuthCode(ctx, newOAuthCodeCreate("unknown", "jackson@juandefu.ca")); !errors.Is(err, ddd.ErrOAuthClientNotFound) {
+		t.Errorf("expected ErrOAuthClientNotFound, got: %v", err)
+	}
+
+	if _, err := oauthRepo.CreateOAuthToken(ctx, newOAuthTokenCreate("unknown", "")); !errors.Is(err, ddd.ErrOAuthClientNotFound) {
+		t.Errorf("expected ErrOAuthClientNotFound, got: %v", err)
+	}
+}
+
+func testGetOAuthToken(t *testing.T, oauthRepo ddd.OAuthRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+
+	for _, email := range []string{"jackson@juandefu.ca", ""} {
+		opts := newOAuthTokenCreate(client.ID, email)
+
+		created, err := oauthRepo.CreateOAuthToken(ctx, opts)
+		if err != nil {
+			t.Fatalf("failed to create oauth token: %s", err)
+		}
+
+		token, err := oauthRepo.GetOAuthToken(ctx, created.Token)
+		if err != nil {
+			t.Fatalf("failed to get oauth token: %s", err)
+		}
+
+		if token.ClientID != client.ID || token.Organization != opts.Organization || token.Email != email ||
+			!reflect.DeepEqual(token.Scopes, opts.Scopes) || !token.ExpiresAt.Equal(created.ExpiresAt) || token.Token != "" {
+			t.Errorf("unknown token: %+v", token)
+		}
+	}
+
+	if _, err := oauthRepo.GetOAuthToken(ctx, "unknown"); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+}
+
+func testGetOAuthTokenExpired(t *testing.T, oauthRepo ddd.OAuthRepository, _ ddd.UserRepository) {
+	ctx := context.Background()
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+	opts := newOAuthTokenCreate(client.ID, "")
+	opts.TTL = time.Millisecond
+
+	token, err := oauthRepo.CreateOAuthToken(ctx, opts)
+	if err != nil {
+		t.Fatalf("failed to create oauth token: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := oauthRepo.GetOAuthToken(ctx, token.Token); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+}
+
+func testRevokeOAuthToken(t *testing.T, oauthRepo ddd.OAuthRepository, _ ddd.UserRepository) {
+	ctx := context.Background()
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+	other := mustCreateOAuthClient(t, oauthRepo, "Other")
+
+	token, err := oauthRepo.CreateOAuthToken(ctx, newOAuthTokenCreate(client.ID, ""))
+	if err != nil {
+		t.Fatalf("failed to create oauth token: %s", err)
+	}
+
+	// a client can't revoke another client's token
+	if err := oauthRepo.RevokeOAuthToken(ctx, other.ID, token.Token); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+
+	if err := oauthRepo.RevokeOAuthToken(ctx, client.ID, token.Token); err != nil {
+		t.Fatalf("failed to revoke oauth token: %s", err)
+	}
+
+	if _, err := oauthRepo.GetOAuthToken(ctx, token.Token); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+
+	if err := oauthRepo.RevokeOAuthToken(ctx, client.ID, token.Token); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+}
+
+func testDeleteUserOAuth(t *testing.T, oauthRepo ddd.OAuthRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	client := mustCreateOAuthClient(t, oauthRepo, "Example")
+
+	code, err := oauthRepo.CreateOAuthCode(ctx, newOAuthCodeCreate(client.ID, "jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create oauth code: %s", err)
+	}
+
+	token, err := oauthRepo.CreateOAuthToken(ctx, newOAuthTokenCreate(client.ID, "jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create oauth token: %s", err)
+	}
+
+	// the client's own token isn't the account's
+	own, err := oauthRepo.CreateOAuthToken(ctx, newOAuthTokenCreate(client.ID, ""))
+	if err != nil {
+		t.Fatalf("failed to create oauth token: %s", err)
+	}
+
+	if err := userRepo.Delete(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete user: %s", err)
+	}
+
+	if _, err := oauthRepo.ConsumeOAuthCode(ctx, code.Code); !errors.Is(err, ddd.ErrOAuthCodeNotFound) {
+		t.Errorf("expected ErrOAuthCodeNotFound, got: %v", err)
+	}
+
+	if _, err := oauthRepo.GetOAuthToken(ctx, token.Token); !errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		t.Errorf("expected ErrOAuthTokenNotFound, got: %v", err)
+	}
+
+	if _, err := oauthRepo.GetOAuthToken(ctx, own.Token); err != nil {
+		t.Errorf("failed to get oauth token: %s", err)
+	}
+}
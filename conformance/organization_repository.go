@@ -0,0 +1,240 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// OrganizationRepositoryFactory returns an empty repository, it's called once per subtest
+// the user repository has to share the organizations' storage, it's how the accepted invitations are checked
+type OrganizationRepositoryFactory func(t *testing.T) (ddd.OrganizationRepository, ddd.UserRepository)
+
+// RunOrganizationRepository runs the organization suite as subtests of t
+func RunOrganizationRepository(t *testing.T, factory OrganizationRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.OrganizationRepository, ddd.UserRepository)
+	}{
+		{"CreateOrganization", testCreateOrganization},
+		{"CreateOrganization_Invalid", testCreateOrganizationInvalid},
+		{"GetOrganization_NotFound", testGetOrganizationNotFound},
+		{"ListOrganizations", testListOrganizations},
+		{"CreateInvitation_NotFound", testCreateInvitationNotFound},
+		{"AcceptInvitation", testAcceptInvitation},
+		{"AcceptInvitation_Invalid", testAcceptInvitationInvalid},
+		{"AcceptInvitation_Expired", testAcceptInvitationExpired},
+		{"AcceptInvitation_Exists", testAcceptInvitationExists},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			orgRepo, userRepo := factory(t)
+			tt.test(t, orgRepo, userRepo)
+		})
+	}
+}
+
+func mustCreateOrganization(t *testing.T, orgRepo ddd.OrganizationRepository, id string) {
+	t.Helper()
+
+	if _, err := orgRepo.CreateOrganization(context.Background(), ddd.OrganizationCreate{ID: id, Name: "Acme Inc."}); err != nil {
+		t.Fatalf("failed to create organization %s: %s", id, err)
+	}
+}
+
+func mustInvite(t *testing.T, orgRepo ddd.OrganizationRepository, opts ddd.InvitationCreate) *ddd.Invitation {
+	t.Helper()
+
+	invitation, err := orgRepo.CreateInvitation(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to invite %s: %s", opts.Email, err)
+	}
+
+	return invitation
+}
+
+func testCreateOrganization(t *testing.T, orgRepo ddd.OrganizationRepository, _ ddd.UserRepository) {
+	before := time.Now().Add(-time.Second)
+
+	organization, err := orgRepo.CreateOrganization(context.Background(), ddd.OrganizationCreate{ID: acme, Name: "Acme Inc."})
+	if err != nil {
+		t.Fatalf("failed to create organization: %s", err)
+	}
+
+	if organization.ID != acme || organization.Name != "Acme Inc." || organization.CreatedAt.Before(before) {
+		t.Errorf("unknown organization created: %+v", organization)
+	}
+
+	found, err := orgRepo.GetOrganization(context.Background(), acme)
+	if err != nil {
+		t.Fatalf("failed to get organization: %s", err)
+	}
+
+	if found.ID != acme || found.Name != "Acme Inc." || !found.CreatedAt.Equal(organization.CreatedAt) {
+		t.Errorf("unknown organization found: %+v, created %+v", found, organization)
+	}
+
+	if _, err := orgRepo.CreateOrganization(context.Background(), ddd.OrganizationCreate{ID: acme, Name: "Acme"}); !errors.Is(err, ddd.ErrOrganizationExists) {
+		t.Errorf("expected ErrOrganizationExists, got: %v", err)
+	}
+}
+
+func testCreateOrganizationInvalid(t *testing.T, orgRepo ddd.OrganizationRepository, _ ddd.UserRepository) {
+	for _, opts := range []ddd.OrganizationCreate{
+		{Name: "Acme Inc."},
+		{ID: "Acme", Name: "Acme Inc."},
+		{ID: "-acme", Name: "Acme Inc."},
+		{ID: "acme/inc", Name: "Acme Inc."},
+		{ID: acme},
+	} {
+		if _, err := orgRepo.CreateOrganization(context.Background(), opts); err == nil {
+			t.Errorf("created an invalid organization: %+v", opts)
+		}
+	}
+}
+
+func testGetOrganizationNotFound(t *testing.T, orgRepo ddd.OrganizationRepository, _ ddd.UserRepository) {
+	if _, err := orgRepo.GetOrganization(context.Background(), acme); !errors.Is(err, ddd.ErrOrganizationNotFound) {
+		t.Errorf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+}
+
+func testListOrganizations(t *testing.T, orgRepo ddd.OrganizationRepository, _ ddd.UserRepository) {
+	mustCreateOrganization(t, orgRepo, "zeta")
+	mustCreateOrganization(t, orgRepo, acme)
+
+	organizations, err := orgRepo.ListOrganizations(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list organizations: %s", err)
+	}
+
+	// the default organization always exists
+	ids := []string{}
+	for _, organization := range organizations {
+		ids = append(ids, organization.ID)
+	}
+
+	if len(ids) != 3 || ids[0] != acme || ids[1] != ddd.DefaultOrganization || ids[2] != "zeta" {
+		t.Errorf("unexpected organizations: %v", ids)
+	}
+}
+
+func testCreateInvitationNotFound(t *testing.T, orgRepo ddd.OrganizationRepository, _ ddd.UserRepository) {
+	if _, err := orgRepo.CreateInvitation(context.Background(), ddd.InvitationCreate{
+		Organization: acme,
+		Email:        "jackson@juandefu.ca",
+	}); !errors.Is(err, ddd.ErrOrganizationNotFound) {
+		t.Errorf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+}
+
+func testAcceptInvitation(t *testing.T, orgRepo ddd.OrganizationRepository, userRepo ddd.UserRepository) {
+	mustCreateOrganization(t, orgRepo, acme)
+
+	invitation := mustInvite(t, orgRepo, ddd.InvitationCreate{
+		Organization: acme,
+		Email:        "jackson@juandefu.ca",
+		Admin:        true,
+	})
+
+	if invitation.Token == "" || invitation.Organization != acme || !invitation.Admin {
+		t.Fatalf("unknown invitation created: %+v", invitation)
+	}
+
+	if expires := time.Until(invitation.ExpiresAt); expires < ddd.DefaultInvitationTTL-time.Minute || expires > ddd.DefaultInvitationTTL {
+		t.Errorf("the invitation expires in %s", expires)
+	}
+
+	user, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, newUserCreate("jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to accept invitation: %s", err)
+	}
+
+	if user.Organization != acme || user.Email != "jackson@juandefu.ca" || !user.Admin || user.Version != 1 {
+		t.Errorf("unknown user created: %+v", user)
+	}
+
+	// the account is the organization's, not the default organization's
+	if _, err := userRepo.Get(context.Background(), "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if _, err := userRepo.Login(ddd.WithTenant(context.Background(), acme), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	}); err != nil {
+		t.Errorf("failed to login: %s", err)
+	}
+
+	// an invitation is accepted once
+	if _, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, newUserCreate("jackson@juandefu.ca")); !errors.Is(err, ddd.ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got: %v", err)
+	}
+}
+
+func testAcceptInvitationInvalid(t *testing.T, orgRepo ddd.OrganizationRepository, userRepo ddd.UserRepository) {
+	mustCreateOrganization(t, orgRepo, acme)
+
+	invitation := mustInvite(t, orgRepo, ddd.InvitationCreate{
+		Organization: acme,
+		Email:        "jackson@juandefu.ca",
+	})
+
+	if _, err := orgRepo.AcceptInvitation(context.Background(), "unknown", newUserCreate("jackson@juandefu.ca")); !errors.Is(err, ddd.ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got: %v", err)
+	}
+
+	// the invitation is for one email
+	if _, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, newUserCreate("jackson@sabey.co")); !errors.Is(err, ddd.ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got: %v", err)
+	}
+
+	opts := newUserCreate("jackson@juandefu.ca")
+	opts.FirstName = ""
+	if _, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, opts); err == nil || err.Error() != "firstName was empty" {
+		t.Errorf("expected firstName was empty, got: %v", err)
+	}
+
+	// a failed accept leaves the invitation
+	if _, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Errorf("failed to accept invitation: %s", err)
+	}
+}
+
+func testAcceptInvitationExpired(t *testing.T, orgRepo ddd.OrganizationRepository, _ ddd.UserRepository) {
+	mustCreateOrganization(t, orgRepo, acme)
+
+	invitation := mustInvite(t, orgRepo, ddd.InvitationCreate{
+		Organization: acme,
+		Email:        "jackson@juandefu.ca",
+		TTL:          time.Millisecond,
+	})
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, newUserCreate("jackson@juandefu.ca")); !errors.Is(err, ddd.ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got: %v", err)
+	}
+}
+
+func testAcceptInvitationExists(t *testing.T, orgRepo ddd.OrganizationRepository, userRepo ddd.UserRepository) {
+	mustCreateOrganization(t, orgRepo, acme)
+
+	if _, err := userRepo.Create(ddd.WithTenant(context.Background(), acme), newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	invitation := mustInvite(t, orgRepo, ddd.InvitationCreate{
+		Organization: acme,
+		Email:        "jackson@juandefu.ca",
+	})
+
+	if _, err := orgRepo.AcceptInvitation(context.Background(), invitation.Token, newUserCreate("jackson@juandefu.ca")); !errors.Is(err, ddd.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// PasskeyRepositoryFactory returns an empty repository, it's called once per subtest
+// the user repository has to share the passkeys' storage, the accounts are created through it
+type PasskeyRepositoryFactory func(t *testing.T) (ddd.PasskeyRepository, ddd.UserRepository)
+
+// RunPasskeyRepository runs the passkey suite as subtests of t
+func RunPasskeyRepository(t *testing.T, factory PasskeyRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.PasskeyRepository, ddd.UserRepository)
+	}{
+		{"CreatePasskey", testCreatePasskey},
+		{"CreatePasskey_Exists", testCreatePasskeyExists},
+		{"CreatePasskey_NotFound", testCreatePasskeyNotFound},
+		{"ListPasskeys", testListPasskeys},
+		{"UsePasskey", testUsePasskey},
+		{"UsePasskey_NoCounter", testUsePasskeyNoCounter},
+		{"UsePasskeyChallenge", testUsePasskeyChallenge},
+		{"DeletePasskey", testDeletePasskey},
+		{"DeleteUser", testDeleteUserPasskeys},
+		{"Tenant", testTenantPasskeys},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			passkeyRepo, userRepo := factory(t)
+			tt.test(t, passkeyRepo, userRepo)
+		})
+	}
+}
+
+func newPasskeyCreate(email string, id string) ddd.PasskeyCreate {
+	return ddd.PasskeyCreate{
+		ID:              []byte(id),
+		Email:           email,
+		UserHandle:      []byte("handle-" + email),
+		Name:            "YubiKey",
+		PublicKey:       []byte("cose-" + id),
+		AttestationType: "packed",
+		AAGUID:          bytes.Repeat([]byte{1}, 16),
+		SignCount:       5,
+		Transports:      []string{"usb", "nfc"},
+		BackupEligible:  true,
+	}
+}
+
+// mustCreatePasskey creates the account and its passkey
+func mustCreatePasskey(t *testing.T, ctx context.Context, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository, email string, id string) {
+	t.Helper()
+
+	if _, err := userRepo.Create(ctx, newUserCreate(email)); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if _, err := passkeyRepo.CreatePasskey(ctx, newPasskeyCreate(email, id)); err != nil {
+		t.Fatalf("failed to create passkey: %s", err)
+	}
+}
+
+func testCreatePasskey(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	opts := newPasskeyCreate("jackson@juandefu.ca", "credential")
+
+	created, err := passkeyRepo.CreatePasskey(ctx, opts)
+	if err != nil {
+		t.Fatalf("failed to create passkey: %s", err)
+	}
+
+	if created.CreatedAt.IsZero() || created.LastUsedAt != nil {
+		t.Errorf("unknown timestamps: %+v", created)
+	}
+
+	passkey, err := passkeyRepo.GetPasskey(ctx, []byte("credential"))
+	if err != nil {
+		t.Fatalf("failed to get passkey: %s", err)
+	}
+
+	if !bytes.Equal(passkey.ID, opts.ID) || passkey.Email != opts.Email || !bytes.Equal(passkey.UserHandle, opts.UserHandle) ||
+		passkey.Name != opts.Name || !bytes.Equal(passkey.PublicKey, opts.PublicKey) || passkey.AttestationType != opts.AttestationType ||
+		!bytes.Equal(passkey.AAGUID, opts.AAGUID) || passkey.SignCount != opts.SignCount || !reflect.DeepEqual(passkey.Transports, opts.Transports) ||
+		!passkey.BackupEligible || !passkey.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("unknown passkey: %+v", passkey)
+	}
+
+	if _, err := passkeyRepo.GetPasskey(ctx, []byte("other")); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	invalid := opts
+	invalid.ID = nil
+	if _, err := passkeyRepo.CreatePasskey(ctx, invalid); err == nil {
+		t.Errorf("a passkey without an id was created")
+	}
+}
+
+func testCreatePasskeyExists(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreatePasskey(t, ctx, passkeyRepo, userRepo, "jackson@juandefu.ca", "credential")
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@sabey.co")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	// a credential id can't be claimed by another account
+	if _, err := passkeyRepo.CreatePasskey(ctx, newPasskeyCreate("jackson@sabey.co", "credential")); !errors.Is(err, ddd.ErrPasskeyExists) {
+		t.Errorf("expected ErrPasskeyExists, got: %v", err)
+	}
+}
+
+func testCreatePasskeyNotFound(t *testing.T, passkeyRepo ddd.PasskeyRepository, _ ddd.UserRepository) {
+	if _, err := passkeyRepo.CreatePasskey(context.Background(), newPasskeyCreate("jackson@juandefu.ca", "credential")); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func testListPasskeys(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreatePasskey(t, ctx, passkeyRepo, userRepo, "jackson@juandefu.ca", "first")
+
+	if _, err := passkeyRepo.CreatePasskey(ctx, newPasskeyCreate("jackson@juandefu.ca", "second")); err != nil {
+		t.Fatalf("failed to create passkey: %s", err)
+	}
+
+	mustCreatePasskey(t, ctx, passkeyRepo, userRepo, "jackson@sabey.co", "third")
+
+	passkeys, err := passkeyRepo.ListPasskeys(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to list passkeys: %s", err)
+	}
+
+	if len(passkeys) != 2 || string(passkeys[0].ID) != "first" || string(passkeys[1].ID) != "second" {
+		t.Errorf("unexpected passkeys: %+v", passkeys)
+	}
+
+	passkeys, err = passkeyRepo.ListPasskeys(ctx, "jackson@example.com")
+	if err != nil || passkeys == nil || len(passkeys) != 0 {
+		t.Errorf("expected no passkeys, got: %v %v", passkeys, err)
+	}
+}
+
+func testUsePasskey(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreatePasskey(t, ctx, passkeyRepo, userRepo, "jackson@juandefu.ca", "credential")
+
+	for _, count := range []uint32{5, 4, 0} {
+		if err := passkeyRepo.UsePasskey(ctx, []byte("credential"), count); !errors.Is(err, ddd.ErrPasskeyCloned) {
+			t.Errorf("%d: expected ErrPasskeyCloned, got: %v", count, err)
+		}
+	}
+
+	if err := passkeyRepo.UsePasskey(ctx, []byte("credential"), 6); err != nil {
+		t.Fatalf("failed to use passkey: %s", err)
+	}
+
+	passkey, err := passkeyRepo.GetPasskey(ctx, []byte("credential"))
+	if err != nil {
+		t.Fatalf("failed to get passkey: %s", err)
+	}
+
+	if passkey.SignCount != 6 || passkey.LastUsedAt == nil {
+		t.Errorf("unknown passkey: %+v", passkey)
+	}
+
+	if err := passkeyRepo.UsePasskey(ctx, []byte("credential"), 6); !errors.Is(err, ddd.ErrPasskeyCloned) {
+		t.Errorf("a sign count was replayed: %v", err)
+	}
+
+	if err := passkeyRepo.UsePasskey(ctx, []byte("other"), 7); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+}
+
+func testUsePasskeyNoCounter(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	opts := newPasskeyCreate("jackson@juandefu.ca", "credential")
+	opts.SignCount = 0
+
+	if _, err := passkeyRepo.CreatePasskey(ctx, opts); err != nil {
+		t.Fatalf("failed to create passkey: %s", err)
+	}
+
+	// synced passkeys don't count
+	for i := 0; i < 2; i++ {
+		if err := passkeyRepo.UsePasskey(ctx, []byte("credential"), 0); err != nil {
+			t.Errorf("failed to use passkey: %s", err)
+		}
+	}
+}
+
+func testUsePasskeyChallenge(t *testing.T, passkeyRepo ddd.PasskeyRepository, _ ddd.UserRepository) {
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(ddd.PasskeyCeremonyTTL)
+
+	if err := passkeyRepo.UsePasskeyChallenge(ctx, "challenge", expiresAt); err != nil {
+		t.Fatalf("failed to use challenge: %s", err)
+	}
+
+	if err := passkeyRepo.UsePasskeyChallenge(ctx, "challenge", expiresAt); !errors.Is(err, ddd.ErrPasskeyChallengeUsed) {
+		t.Errorf("expected ErrPasskeyChallengeUsed, got: %v", err)
+	}
+
+	// a challenge is used once whatever the organization
+	if err := passkeyRepo.UsePasskeyChallenge(ddd.WithTenant(ctx, "acme"), "challenge", expiresAt); !errors.Is(err, ddd.ErrPasskeyChallengeUsed) {
+		t.Errorf("expected ErrPasskeyChallengeUsed, got: %v", err)
+	}
+
+	// an expired challenge is forgotten, the ceremony it was in has expired too
+	if err := passkeyRepo.UsePasskeyChallenge(ctx, "expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("failed to use challenge: %s", err)
+	}
+
+	if err := passkeyRepo.UsePasskeyChallenge(ctx, "expired", expiresAt); err != nil {
+		t.Errorf("an expired challenge was kept: %v", err)
+	}
+
+	if err := passkeyRepo.UsePasskeyChallenge(ctx, "", expiresAt); err == nil {
+		t.Errorf("an empty challenge was accepted")
+	}
+}
+
+func testDeletePasskey(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreatePasskey(t, ctx, passkeyRepo, userRepo, "jackson@juandefu.ca", "credential")
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@sabey.co")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	// another account's passkey isn't deleted
+	if err := passkeyRepo.DeletePasskey(ctx, "jackson@sabey.co", []byte("credential")); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	if err := passkeyRepo.DeletePasskey(ctx, "jackson@juandefu.ca", []byte("credential")); err != nil {
+		t.Fatalf("failed to delete passkey: %s", err)
+	}
+
+	if _, err := passkeyRepo.GetPasskey(ctx, []byte("credential")); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	if err := passkeyRepo.DeletePasskey(ctx, "jackson@juandefu.ca", []byte("credential")); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+}
+
+func testDeleteUserPasskeys(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustCreatePasskey(t, ctx, passkeyRepo, userRepo, "jackson@juandefu.ca", "credential")
+
+	if err := userRepo.Delete(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete user: %s", err)
+	}
+
+	if _, err := passkeyRepo.GetPasskey(ctx, []byte("credential")); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+}
+
+func testTenantPasskeys(t *testing.T, passkeyRepo ddd.PasskeyRepository, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	mustCreatePasskey(t, acmeCtx, passkeyRepo, userRepo, "jackson@juandefu.ca", "credential")
+
+	if _, err := passkeyRepo.GetPasskey(context.Background(), []byte("credential")); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	if err := passkeyRepo.UsePasskey(context.Background(), []byte("credential"), 6); !errors.Is(err, ddd.ErrPasskeyNotFound) {
+		t.Errorf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	// the same credential id in another organization is another passkey
+	mustCreatePasskey(t, context.Background(), passkeyRepo, userRepo, "jackson@juandefu.ca", "credential")
+
+	if err := passkeyRepo.DeletePasskey(context.Background(), "jackson@juandefu.ca", []byte("credential")); err != nil {
+		t.Fatalf("failed to delete passkey: %s", err)
+	}
+
+	if _, err := passkeyRepo.GetPasskey(acmeCtx, []byte("credential")); err != nil {
+		t.Errorf("failed to get passkey: %s", err)
+	}
+}
@@ -0,0 +1,411 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// TOTPRepositoryFactory returns an empty repository, it's called once per subtest
+// the user repository has to share the enrollments' storage, the accounts are created through it
+type TOTPRepositoryFactory func(t *testing.T) (ddd.TOTPRepository, ddd.UserRepository)
+
+// RunTOTPRepository runs the two-factor suite as subtests of t
+func RunTOTPRepository(t *testing.T, factory TOTPRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.TOTPRepository, ddd.UserRepository)
+	}{
+		{"EnrollTOTP", testEnrollTOTP},
+		{"EnrollTOTP_NotFound", testEnrollTOTPNotFound},
+		{"EnableTOTP", testEnableTOTP},
+		{"UseTOTPStep", testUseTOTPStep},
+		{"UseRecoveryCode", testUseRecoveryCode},
+		{"FailTOTP", testFailTOTP},
+		{"LockTOTP", testLockTOTP},
+		{"UseMFAChallenge", testUseMFAChallenge},
+		{"DeleteTOTP", testDeleteTOTP},
+		{"DeleteUser", testDeleteUserTOTP},
+		{"Tenant", testTenantTOTP},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			totpRepo, userRepo := factory(t)
+			tt.test(t, totpRepo, userRepo)
+		})
+	}
+}
+
+// mustEnableTOTP creates the account and enables two-factor authentication at step 100, with the recovery codes "a" and "b"
+func mustEnableTOTP(t *testing.T, ctx context.Context, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository, email string) {
+	t.Helper()
+
+	if _, err := userRepo.Create(ctx, newUserCreate(email)); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if err := totpRepo.EnrollTOTP(ctx, email, []byte("sealed")); err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	if err := totpRepo.EnableTOTP(ctx, ddd.TOTPEnable{
+		Email:         email,
+		Step:          100,
+		RecoveryCodes: []string{ddd.HashToken("a"), ddd.HashToken("b")},
+	}); err != nil {
+		t.Fatalf("failed to enable: %s", err)
+	}
+}
+
+func testEnrollTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if _, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	if err := totpRepo.EnrollTOTP(ctx, "jackson@juandefu.ca", []byte("first")); err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	// an unconfirmed secret is replaced
+	if err := totpRepo.EnrollTOTP(ctx, "jackson@juandefu.ca", []byte("second")); err != nil {
+		t.Fatalf("failed to enroll again: %s", err)
+	}
+
+	enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get enrollment: %s", err)
+	}
+
+	if enrollment.Email != "jackson@juandefu.ca" || !bytes.Equal(enrollment.Secret, []byte("second")) || enrollment.Enabled || enrollment.LastStep != 0 || enrollment.RecoveryCodes != 0 {
+		t.Errorf("unknown enrollment: %+v", enrollment)
+	}
+}
+
+func testEnrollTOTPNotFound(t *testing.T, totpRepo ddd.TOTPRepository, _ ddd.UserRepository) {
+	if err := totpRepo.EnrollTOTP(context.Background(), "jackson@juandefu.ca", []byte("sealed")); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if err := totpRepo.EnableTOTP(context.Background(), ddd.TOTPEnable{
+		Email:         "jackson@juandefu.ca",
+		Step:          100,
+		RecoveryCodes: []string{ddd.HashToken("a")},
+	}); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+}
+
+func testEnableTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get enrollment: %s", err)
+	}
+
+	if !bytes.Equal(enrollment.Secret, []byte("sealed")) || !enrollment.Enabled || enrollment.LastStep != 100 || enrollment.RecoveryCodes != 2 {
+		t.Errorf("unknown enrollment: %+v", enrollment)
+	}
+
+	if err := totpRepo.EnableTOTP(ctx, ddd.TOTPEnable{
+		Email:         "jackson@juandefu.ca",
+		Step:          101,
+		RecoveryCodes: []string{ddd.HashToken("c")},
+	}); !errors.Is(err, ddd.ErrTOTPEnabled) {
+		t.Errorf("expected ErrTOTPEnabled, got: %v", err)
+	}
+
+	// an enabled secret can't be replaced without resetting it
+	if err := totpRepo.EnrollTOTP(ctx, "jackson@juandefu.ca", []byte("other")); !errors.Is(err, ddd.ErrTOTPEnabled) {
+		t.Errorf("expected ErrTOTPEnabled, got: %v", err)
+	}
+
+	if err := totpRepo.EnableTOTP(ctx, ddd.TOTPEnable{Email: "jackson@juandefu.ca", Step: 101}); err == nil || err.Error() != "recoveryCodes was empty" {
+		t.Errorf("expected recoveryCodes was empty, got: %v", err)
+	}
+}
+
+func testUseTOTPStep(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@sabey.co")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if err := totpRepo.EnrollTOTP(ctx, "jackson@sabey.co", []byte("sealed")); err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	// an unconfirmed secret doesn't log in
+	if err := totpRepo.UseTOTPStep(ctx, "jackson@sabey.co", 101); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	// the confirming code's step is used
+	for _, step := range []int64{99, 100} {
+		if err := totpRepo.UseTOTPStep(ctx, "jackson@juandefu.ca", step); !errors.Is(err, ddd.ErrInvalidTOTPCode) {
+			t.Errorf("%d: expected ErrInvalidTOTPCode, got: %v", step, err)
+		}
+	}
+
+	if err := totpRepo.UseTOTPStep(ctx, "jackson@juandefu.ca", 101); err != nil {
+		t.Fatalf("failed to use step: %s", err)
+	}
+
+	if err := totpRepo.UseTOTPStep(ctx, "jackson@juandefu.ca", 101); !errors.Is(err, ddd.ErrInvalidTOTPCode) {
+		t.Errorf("a code was used twice: %v", err)
+	}
+
+	enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get enrollment: %s", err)
+	}
+
+	if enrollment.LastStep != 101 {
+		t.Errorf("unexpected last step: %d", enrollment.LastStep)
+	}
+}
+
+func testUseRecoveryCode(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	if err := totpRepo.UseRecoveryCode(ctx, "jackson@juandefu.ca", ddd.HashToken("unknown")); !errors.Is(err, ddd.ErrInvalidTOTPCode) {
+		t.Errorf("expected ErrInvalidTOTPCode, got: %v", err)
+	}
+
+	if err := totpRepo.UseRecoveryCode(ctx, "jackson@juandefu.ca", ddd.HashToken("a")); err != nil {
+		t.Fatalf("failed to use recovery code: %s", err)
+	}
+
+	if err := totpRepo.UseRecoveryCode(ctx, "jackson@juandefu.ca", ddd.HashToken("a")); !errors.Is(err, ddd.ErrInvalidTOTPCode) {
+		t.Errorf("a recovery code was used twice: %v", err)
+	}
+
+	enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get enrollment: %s", err)
+	}
+
+	if enrollment.RecoveryCodes != 1 {
+		t.Errorf("expected 1 recovery code, got %d", enrollment.RecoveryCodes)
+	}
+}
+
+func testFailTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@sabey.co")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if err := totpRepo.EnrollTOTP(ctx, "jackson@sabey.co", []byte("sealed")); err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	// an unconfirmed secret doesn't count
+	if _, err := totpRepo.FailTOTP(ctx, "jackson@sabey.co"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	for i := 1; i <= 3; i++ {
+		failures, err := totpRepo.FailTOTP(ctx, "jackson@juandefu.ca")
+		if err != nil {
+			t.Fatalf("failed to count a rejected code: %s", err)
+		}
+
+		if failures != i {
+			t.Errorf("expected %d failures, got %d", i, failures)
+		}
+	}
+
+	if enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); err != nil || enrollment.Failures != 3 {
+		t.Errorf("unexpected enrollment: %+v %v", enrollment, err)
+	}
+
+	// an accepted code or recovery code starts the count over
+	if err := totpRepo.UseTOTPStep(ctx, "jackson@juandefu.ca", 101); err != nil {
+		t.Fatalf("failed to use step: %s", err)
+	}
+
+	if enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); err != nil || enrollment.Failures != 0 {
+		t.Errorf("unexpected enrollment: %+v %v", enrollment, err)
+	}
+
+	if _, err := totpRepo.FailTOTP(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to count a rejected code: %s", err)
+	}
+
+	if err := totpRepo.UseRecoveryCode(ctx, "jackson@juandefu.ca", ddd.HashToken("a")); err != nil {
+		t.Fatalf("failed to use recovery code: %s", err)
+	}
+
+	if enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); err != nil || enrollment.Failures != 0 {
+		t.Errorf("unexpected enrollment: %+v %v", enrollment, err)
+	}
+}
+
+func testLockTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	if err := totpRepo.LockTOTP(ctx, "jackson@juandefu.ca", time.Now()); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	if enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); err != nil || !enrollment.LockedUntil.IsZero() {
+		t.Errorf("unexpected enrollment: %+v %v", enrollment, err)
+	}
+
+	if _, err := totpRepo.FailTOTP(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to count a rejected code: %s", err)
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+
+	if err := totpRepo.LockTOTP(ctx, "jackson@juandefu.ca", until); err != nil {
+		t.Fatalf("failed to lock: %s", err)
+	}
+
+	// locking starts the count over
+	enrollment, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get enrollment: %s", err)
+	}
+
+	if !enrollment.LockedUntil.Equal(until) || enrollment.Failures != 0 {
+		t.Errorf("unexpected enrollment: %+v", enrollment)
+	}
+}
+
+func testUseMFAChallenge(t *testing.T, totpRepo ddd.TOTPRepository, _ ddd.UserRepository) {
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(ddd.MFAChallengeTTL)
+
+	if err := totpRepo.UseMFAChallenge(ctx, "challenge", expiresAt); err != nil {
+		t.Fatalf("failed to use challenge: %s", err)
+	}
+
+	if err := totpRepo.UseMFAChallenge(ctx, "challenge", expiresAt); !errors.Is(err, ddd.ErrMFAChallengeUsed) {
+		t.Errorf("expected ErrMFAChallengeUsed, got: %v", err)
+	}
+
+	// a challenge is used once whatever the organization
+	if err := totpRepo.UseMFAChallenge(ddd.WithTenant(ctx, "acme"), "challenge", expiresAt); !errors.Is(err, ddd.ErrMFAChallengeUsed) {
+		t.Errorf("expected ErrMFAChallengeUsed, got: %v", err)
+	}
+
+	// an expired challenge is forgotten, the token it was in has expired too
+	if err := totpRepo.UseMFAChallenge(ctx, "expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("failed to use challenge: %s", err)
+	}
+
+	if err := totpRepo.UseMFAChallenge(ctx, "expired", expiresAt); err != nil {
+		t.Errorf("an expired challenge was kept: %v", err)
+	}
+
+	if err := totpRepo.UseMFAChallenge(ctx, "", expiresAt); err == nil {
+		t.Errorf("an empty id was accepted")
+	}
+}
+
+func testDeleteTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	if err := totpRepo.DeleteTOTP(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	if err := totpRepo.DeleteTOTP(ctx, "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	// the account enrolls again from scratch, the old recovery codes are gone
+	if err := totpRepo.EnrollTOTP(ctx, "jackson@juandefu.ca", []byte("again")); err != nil {
+		t.Fatalf("failed to enroll again: %s", err)
+	}
+
+	if err := totpRepo.EnableTOTP(ctx, ddd.TOTPEnable{
+		Email:         "jackson@juandefu.ca",
+		Step:          200,
+		RecoveryCodes: []string{ddd.HashToken("c")},
+	}); err != nil {
+		t.Fatalf("failed to enable again: %s", err)
+	}
+
+	if err := totpRepo.UseRecoveryCode(ctx, "jackson@juandefu.ca", ddd.HashToken("a")); !errors.Is(err, ddd.ErrInvalidTOTPCode) {
+		t.Errorf("expected ErrInvalidTOTPCode, got: %v", err)
+	}
+}
+
+func testDeleteUserTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	ctx := context.Background()
+
+	mustEnableTOTP(t, ctx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	if err := userRepo.Delete(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete user: %s", err)
+	}
+
+	// a new account with the same email starts without two-factor authentication
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if _, err := totpRepo.GetTOTP(ctx, "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+}
+
+func testTenantTOTP(t *testing.T, totpRepo ddd.TOTPRepository, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	mustEnableTOTP(t, acmeCtx, totpRepo, userRepo, "jackson@juandefu.ca")
+
+	if _, err := userRepo.Create(context.Background(), newUserCreate("jackson@juandefu.ca")); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if _, err := totpRepo.GetTOTP(context.Background(), "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	if err := totpRepo.UseRecoveryCode(context.Background(), "jackson@juandefu.ca", ddd.HashToken("a")); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	if err := totpRepo.DeleteTOTP(context.Background(), "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrTOTPNotFound) {
+		t.Errorf("expected ErrTOTPNotFound, got: %v", err)
+	}
+
+	if _, err := totpRepo.GetTOTP(acmeCtx, "jackson@juandefu.ca"); err != nil {
+		t.Errorf("failed to get enrollment: %s", err)
+	}
+}
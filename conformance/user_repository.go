@@ -0,0 +1,1316 @@
+// Package conformance is a test suite every ddd.UserRepository, ddd.OrganizationRepository, ddd.TOTPRepository, ddd.PasskeyRepository, ddd.OAuthRepository, ddd.IdentityRepository and ddd.APIKeyRepository backend must pass,
+// so the mock, postgres and future backends can't drift apart.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sabey/ddd"
+)
+
+// UserRepositoryFactory returns an empty repository, it's called once per subtest
+// use t.Cleanup to close the repository
+type UserRepositoryFactory func(t *testing.T) ddd.UserRepository
+
+// RunUserRepository runs the complete suite as subtests of t
+func RunUserRepository(t *testing.T, factory UserRepositoryFactory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ddd.UserRepository)
+	}{
+		{"Create", testCreate},
+		{"Create_Admin", testCreateAdmin},
+		{"Create_Profile", testCreateProfile},
+		{"Create_Invalid", testCreateInvalid},
+		{"Create_Duplicate", testCreateDuplicate},
+		{"Login", testLogin},
+		{"Login_InvalidPassword", testLoginInvalidPassword},
+		{"Login_NotFound", testLoginNotFound},
+		{"Login_Disabled", testLoginDisabled},
+		{"Get", testGet},
+		{"Get_NotFound", testGetNotFound},
+		{"List_Empty", testListEmpty},
+		{"List_Order", testListOrder},
+		{"List_Pages", testListPages},
+		{"List_Emails", testListEmails},
+		{"List_Search", testListSearch},
+		{"List_Admin", testListAdmin},
+		{"Update", testUpdate},
+		{"Update_Invalid", testUpdateInvalid},
+		{"Update_NotFound", testUpdateNotFound},
+		{"Update_Partial", testUpdatePartial},
+		{"Update_Profile", testUpdateProfile},
+		{"Update_Avatar", testUpdateAvatar},
+		{"Update_Password", testUpdatePassword},
+		{"Update_Version", testUpdateVersion},
+		{"Update_VersionNotFound", testUpdateVersionNotFound},
+		{"Delete", testDelete},
+		{"Delete_NotFound", testDeleteNotFound},
+		{"Import", testImport},
+		{"Import_Conflict", testImportConflict},
+		{"Import_Upsert", testImportUpsert},
+		{"Import_UpsertAdmin", testImportUpsertAdmin},
+		{"Import_Invalid", testImportInvalid},
+		{"Import_DryRun", testImportDryRun},
+		{"Tenant_Create", testTenantCreate},
+		{"Tenant_Isolation", testTenantIsolation},
+		{"Tenant_Import", testTenantImport},
+		{"Concurrent_Create", testConcurrentCreate},
+		{"Concurrent_Duplicate", testConcurrentDuplicate},
+		{"Concurrent_Update", testConcurrentUpdate},
+		{"Concurrent_UpdateVersion", testConcurrentUpdateVersion},
+		{"Cancelled", testCancelled},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+func newUserCreate(email string) ddd.UserCreate {
+	return ddd.UserCreate{
+		Email:     email,
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	}
+}
+
+func mustCreate(t *testing.T, userRepo ddd.UserRepository, emails ...string) {
+	t.Helper()
+
+	for _, email := range emails {
+		if _, err := userRepo.Create(context.Background(), newUserCreate(email)); err != nil {
+			t.Fatalf("failed to create user %s: %s", email, err)
+		}
+	}
+}
+
+func testCreate(t *testing.T, userRepo ddd.UserRepository) {
+	user, err := userRepo.Create(context.Background(), newUserCreate("jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if user.Email != "jackson@juandefu.ca" || user.FirstName != "Jackson" || user.LastName != "Sabey" {
+		t.Errorf("unknown user created: %+v", user)
+	}
+
+	if user.Password != ddd.HashPassword("pass") {
+		t.Errorf("password wasn't stored as given")
+	}
+
+	if user.Version != 1 {
+		t.Errorf("new accounts start at version 1: %d", user.Version)
+	}
+
+	if user.Admin {
+		t.Errorf("accounts aren't admins unless asked")
+	}
+}
+
+func testCreateAdmin(t *testing.T, userRepo ddd.UserRepository) {
+	opts := newUserCreate("jackson@juandefu.ca")
+	opts.Admin = true
+
+	if _, err := userRepo.Create(context.Background(), opts); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	user, err := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if !user.Admin {
+		t.Errorf("admin wasn't stored: %+v", user)
+	}
+}
+
+// registerAttributes declares the custom attributes the profile tests use, registering is idempotent
+func registerAttributes(t *testing.T) {
+	t.Helper()
+
+	for key, typ := range map[string]ddd.AttributeType{
+		"team":       ddd.AttributeString,
+		"seats":      ddd.AttributeNumber,
+		"newsletter": ddd.AttributeBoolean,
+	} {
+		if err := ddd.Attributes.Register(key, typ); err != nil {
+			t.Fatalf("failed to register attribute: %s", err)
+		}
+	}
+}
+
+func testCreateProfile(t *testing.T, userRepo ddd.UserRepository) {
+	registerAttributes(t)
+
+	opts := newUserCreate("jackson@juandefu.ca")
+	opts.DisplayName = "Jack"
+	opts.Locale = "en-CA"
+	opts.Timezone = "America/Vancouver"
+	opts.Attributes = map[string]interface{}{
+		"team":       "core",
+		"seats":      float64(3),
+		"newsletter": true,
+	}
+
+	if _, err := userRepo.Create(context.Background(), opts); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	user, err := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if user.DisplayName != "Jack" || user.Locale != "en-CA" || user.Timezone != "America/Vancouver" {
+		t.Errorf("profile wasn't stored: %+v", user)
+	}
+
+	if len(user.Attributes) != 3 || user.Attributes["team"] != "core" || user.Attributes["seats"] != float64(3) || user.Attributes["newsletter"] != true {
+		t.Errorf("attributes weren't stored: %v", user.Attributes)
+	}
+
+	// accounts without attributes have an empty map, not nil
+	mustCreate(t, userRepo, "jackson@sabey.co")
+
+	user, err = userRepo.Get(context.Background(), "jackson@sabey.co")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if user.Attributes == nil || len(user.Attributes) != 0 {
+		t.Errorf("expected empty attributes: %v", user.Attributes)
+	}
+}
+
+func testCreateInvalid(t *testing.T, userRepo ddd.UserRepository) {
+	for _, opts := range []ddd.UserCreate{
+		{},
+		{Email: "jackson@juandefu.ca"},
+		{Email: "jackson@juandefu.ca", FirstName: "Jackson"},
+		{Email: "jackson@juandefu.ca", FirstName: "Jackson", LastName: "Sabey"},
+	} {
+		if _, err := userRepo.Create(context.Background(), opts); err == nil || err.Error() != opts.Validate().Error() {
+			t.Errorf("%+v: expected the validation error, got: %v", opts, err)
+		}
+	}
+
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	if len(users) != 0 {
+		t.Errorf("invalid users were created: %d", len(users))
+	}
+}
+
+func testCreateDuplicate(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@sabey.co")
+
+	_, err := userRepo.Create(context.Background(), newUserCreate("jackson@sabey.co"))
+	if !errors.Is(err, ddd.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got: %v", err)
+	}
+}
+
+func testLogin(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	user, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	})
+	if err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if user.Email != "jackson@juandefu.ca" {
+		t.Errorf("unknown user found: %s", user.Email)
+	}
+}
+
+func testLoginInvalidPassword(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	_, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("invalid"),
+	})
+	if !errors.Is(err, ddd.ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got: %v", err)
+	}
+}
+
+func testLoginNotFound(t *testing.T, userRepo ddd.UserRepository) {
+	_, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson+notfound@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	})
+	if !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func testLoginDisabled(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:    "jackson@juandefu.ca",
+		Disabled: ddd.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("failed to disable: %s", err)
+	}
+
+	if !user.Disabled || !mustGet(t, userRepo, "jackson@juandefu.ca").Disabled {
+		t.Errorf("user wasn't disabled: %+v", user)
+	}
+
+	login := ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	}
+
+	if _, err := userRepo.Login(context.Background(), login); !errors.Is(err, ddd.ErrUserDisabled) {
+		t.Errorf("expected ErrUserDisabled, got: %v", err)
+	}
+
+	// the password is checked first, a disabled account doesn't confirm a guess
+	if _, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("invalid"),
+	}); !errors.Is(err, ddd.ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got: %v", err)
+	}
+
+	if _, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:    "jackson@juandefu.ca",
+		Disabled: ddd.Bool(false),
+	}); err != nil {
+		t.Fatalf("failed to enable: %s", err)
+	}
+
+	if _, err := userRepo.Login(context.Background(), login); err != nil {
+		t.Errorf("failed to login once enabled: %s", err)
+	}
+}
+
+func testGet(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	user, err := userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if user.Email != "jackson@juandefu.ca" || user.FirstName != "Jackson" || user.LastName != "Sabey" {
+		t.Errorf("unknown user found: %+v", user)
+	}
+}
+
+func testGetNotFound(t *testing.T, userRepo ddd.UserRepository) {
+	if _, err := userRepo.Get(context.Background(), "jackson+notfound@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if _, err := userRepo.Get(context.Background(), ""); err == nil || err.Error() != "email was empty" {
+		t.Errorf("expected the validation error, got: %v", err)
+	}
+}
+
+func testListEmpty(t *testing.T, userRepo ddd.UserRepository) {
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	// an empty list is encoded as `[]`, never `null`
+	if users == nil || len(users) != 0 {
+		t.Errorf("expected an empty non-nil list: %v", users)
+	}
+}
+
+func testListOrder(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@sabey.co", "a@sabey.co", "jackson@juandefu.ca")
+
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	if len(users) != 3 {
+		t.Fatalf("invalid amount of users: %d", len(users))
+	}
+
+	// ORDER BY email ASC
+	for i, email := range []string{"a@sabey.co", "jackson@juandefu.ca", "jackson@sabey.co"} {
+		if users[i].Email != email {
+			t.Errorf("%d. unknown email / user sort order: %s", i, users[i].Email)
+		}
+	}
+}
+
+func testListPages(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "e@sabey.co", "c@sabey.co", "a@sabey.co", "d@sabey.co", "b@sabey.co")
+
+	var pages [][]string
+
+	opts := ddd.UserList{Limit: 2}
+	for {
+		users, err := userRepo.List(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("failed to list: %s", err)
+		}
+
+		if len(users) == 0 {
+			break
+		}
+
+		page := []string{}
+		for _, user := range users {
+			page = append(page, user.Email)
+		}
+		pages = append(pages, page)
+
+		opts.After = users[len(users)-1].Email
+	}
+
+	if fmt.Sprint(pages) != "[[a@sabey.co b@sabey.co] [c@sabey.co d@sabey.co] [e@sabey.co]]" {
+		t.Errorf("unknown pages: %v", pages)
+	}
+
+	// After doesn't have to be an existing account
+	users, err := userRepo.List(context.Background(), ddd.UserList{After: "bb"})
+	if err != nil || len(users) != 3 || users[0].Email != "c@sabey.co" {
+		t.Errorf("unknown page after a missing email: %v %v", users, err)
+	}
+
+	if _, err := userRepo.List(context.Background(), ddd.UserList{Limit: -1}); err == nil {
+		t.Errorf("listed with a negative limit?")
+	}
+}
+
+func listedEmails(t *testing.T, userRepo ddd.UserRepository, opts ddd.UserList) string {
+	t.Helper()
+
+	users, err := userRepo.List(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	emails := []string{}
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+
+	return fmt.Sprint(emails)
+}
+
+func testListEmails(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "a@sabey.co", "b@sabey.co", "c@sabey.co", "d@sabey.co")
+
+	// missing accounts aren't an error, and the order is still by email
+	emails := listedEmails(t, userRepo, ddd.UserList{Emails: []string{"d@sabey.co", "missing@sabey.co", "b@sabey.co"}})
+	if emails != "[b@sabey.co d@sabey.co]" {
+		t.Errorf("unknown accounts listed by email: %s", emails)
+	}
+
+	emails = listedEmails(t, userRepo, ddd.UserList{Emails: []string{"a@sabey.co", "b@sabey.co", "d@sabey.co"}, After: "a@sabey.co", Limit: 1})
+	if emails != "[b@sabey.co]" {
+		t.Errorf("unknown page of accounts listed by email: %s", emails)
+	}
+}
+
+func testListSearch(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca", "admin@sabey.co", "100%@sabey.co")
+
+	opts := newUserCreate("someone@else.com")
+	opts.FirstName = "Someone"
+	opts.LastName = "Else"
+	opts.DisplayName = "Juan"
+	if _, err := userRepo.Create(context.Background(), opts); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	for search, expected := range map[string]string{
+		"juan":   "[jackson@juandefu.ca someone@else.com]",
+		"SABEY":  "[100%@sabey.co admin@sabey.co jackson@juandefu.ca]",
+		"else":   "[someone@else.com]",
+		"nobody": "[]",
+		// wildcards are matched literally
+		"%":  "[100%@sabey.co]",
+		"_@": "[]",
+	} {
+		if emails := listedEmails(t, userRepo, ddd.UserList{Search: search}); emails != expected {
+			t.Errorf("unknown accounts found by %q: %s", search, emails)
+		}
+	}
+
+	if _, err := userRepo.List(context.Background(), ddd.UserList{Search: strings.Repeat("a", 101)}); err == nil {
+		t.Errorf("listed with a long search?")
+	}
+}
+
+func testListAdmin(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "a@sabey.co", "c@sabey.co")
+
+	opts := newUserCreate("b@sabey.co")
+	opts.Admin = true
+	if _, err := userRepo.Create(context.Background(), opts); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{Admin: ddd.Bool(true)}); emails != "[b@sabey.co]" {
+		t.Errorf("unknown admins listed: %s", emails)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{Admin: ddd.Bool(false)}); emails != "[a@sabey.co c@sabey.co]" {
+		t.Errorf("unknown accounts listed: %s", emails)
+	}
+}
+
+func testUpdate(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+		LastName:  ddd.String("SABEY"),
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	if user.Email != "jackson@juandefu.ca" || user.FirstName != "JACKSON" || user.LastName != "SABEY" {
+		t.Errorf("unknown user returned: %+v", user)
+	}
+
+	if user.Version != 2 {
+		t.Errorf("update didn't increment the version: %d", user.Version)
+	}
+
+	// the update is visible to later reads, and the password is untouched
+	user, err = userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	})
+	if err != nil {
+		t.Fatalf("failed to login after update: %s", err)
+	}
+
+	if user.FirstName != "JACKSON" || user.LastName != "SABEY" {
+		t.Errorf("update wasn't stored: %+v", user)
+	}
+}
+
+func testUpdateInvalid(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	for _, opts := range []ddd.UserUpdate{
+		{Email: "jackson@juandefu.ca"},
+		{Email: "jackson@juandefu.ca", FirstName: ddd.String("")},
+		{Email: "jackson@juandefu.ca", FirstName: ddd.String("JACKSON"), LastName: ddd.String("")},
+	} {
+		if _, err := userRepo.Update(context.Background(), opts); err == nil || err.Error() != opts.Validate().Error() {
+			t.Errorf("%+v: expected the validation error, got: %v", opts, err)
+		}
+	}
+}
+
+func testUpdatePartial(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	// fields that aren't set are left as they are
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:    "jackson@juandefu.ca",
+		LastName: ddd.String("SABEY"),
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	if user.FirstName != "Jackson" || user.LastName != "SABEY" || user.Version != 2 {
+		t.Errorf("unknown user returned: %+v", user)
+	}
+
+	user, err = userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if user.FirstName != "Jackson" || user.LastName != "SABEY" {
+		t.Errorf("partial update wasn't stored: %+v", user)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, userRepo ddd.UserRepository) {
+	_, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson+notfound@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+		LastName:  ddd.String("SABEY"),
+	})
+	if !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func testUpdateProfile(t *testing.T, userRepo ddd.UserRepository) {
+	registerAttributes(t)
+
+	opts := newUserCreate("jackson@juandefu.ca")
+	opts.DisplayName = "Jack"
+	opts.Locale = "en-CA"
+	opts.Attributes = map[string]interface{}{
+		"team":       "core",
+		"newsletter": true,
+	}
+
+	if _, err := userRepo.Create(context.Background(), opts); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	// attributes are merged, nil removes a key, and an empty display name clears it
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:       "jackson@juandefu.ca",
+		DisplayName: ddd.String(""),
+		Timezone:    ddd.String("Europe/Paris"),
+		Attributes: map[string]interface{}{
+			"seats":      float64(4),
+			"newsletter": nil,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	for _, user := range []*ddd.User{user, mustGet(t, userRepo, "jackson@juandefu.ca")} {
+		if user.FirstName != "Jackson" || user.DisplayName != "" || user.Locale != "en-CA" || user.Timezone != "Europe/Paris" {
+			t.Errorf("unknown profile: %+v", user)
+		}
+
+		if len(user.Attributes) != 2 || user.Attributes["team"] != "core" || user.Attributes["seats"] != float64(4) {
+			t.Errorf("attributes weren't merged: %v", user.Attributes)
+		}
+	}
+
+	for _, opts := range []ddd.UserUpdate{
+		{Email: "jackson@juandefu.ca", Locale: ddd.String("not a locale")},
+		{Email: "jackson@juandefu.ca", Timezone: ddd.String("Mars/Olympus_Mons")},
+		{Email: "jackson@juandefu.ca", Attributes: map[string]interface{}{"unknown": "value"}},
+		{Email: "jackson@juandefu.ca", Attributes: map[string]interface{}{"seats": "four"}},
+	} {
+		if _, err := userRepo.Update(context.Background(), opts); err == nil || err.Error() != opts.Validate().Error() {
+			t.Errorf("%+v: expected the validation error, got: %v", opts, err)
+		}
+	}
+}
+
+func testUpdateAvatar(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	if user := mustGet(t, userRepo, "jackson@juandefu.ca"); user.Avatar != "" {
+		t.Errorf("a new user has an avatar: %s", user.Avatar)
+	}
+
+	hash := "0123456789abcdef0123456789abcdef"
+
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:  "jackson@juandefu.ca",
+		Avatar: ddd.String(hash),
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	for _, user := range []*ddd.User{user, mustGet(t, userRepo, "jackson@juandefu.ca")} {
+		if user.Avatar != hash || user.FirstName != "Jackson" {
+			t.Errorf("avatar wasn't set: %+v", user)
+		}
+	}
+
+	// other updates keep it
+	if user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+	}); err != nil || user.Avatar != hash {
+		t.Errorf("avatar wasn't kept: %+v %v", user, err)
+	}
+
+	if user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:  "jackson@juandefu.ca",
+		Avatar: ddd.String(""),
+	}); err != nil || user.Avatar != "" {
+		t.Errorf("avatar wasn't removed: %+v %v", user, err)
+	}
+
+	if _, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:  "jackson@juandefu.ca",
+		Avatar: ddd.String("../../etc/passwd"),
+	}); err == nil {
+		t.Errorf("updated an invalid avatar?")
+	}
+}
+
+func testUpdatePassword(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.String(ddd.HashPassword("changed")),
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	if user.FirstName != "Jackson" || user.Version != 2 {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	if _, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	}); !errors.Is(err, ddd.ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword for the old password, got: %v", err)
+	}
+
+	if _, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.HashPassword("changed"),
+	}); err != nil {
+		t.Errorf("failed to login with the new password: %s", err)
+	}
+
+	if _, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:    "jackson@juandefu.ca",
+		Password: ddd.String(""),
+	}); err == nil {
+		t.Errorf("updated an empty password?")
+	}
+}
+
+func mustGet(t *testing.T, userRepo ddd.UserRepository, email string) *ddd.User {
+	t.Helper()
+
+	user, err := userRepo.Get(context.Background(), email)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", email, err)
+	}
+
+	return user
+}
+
+func testUpdateVersion(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	user, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+		LastName:  ddd.String("SABEY"),
+		Version:   1,
+	})
+	if err != nil {
+		t.Fatalf("failed to update the expected version: %s", err)
+	}
+
+	if user.Version != 2 {
+		t.Errorf("update didn't increment the version: %d", user.Version)
+	}
+
+	// a client still holding version 1
+	_, err = userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("Stale"),
+		LastName:  ddd.String("Stale"),
+		Version:   1,
+	})
+	if !errors.Is(err, ddd.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch, got: %v", err)
+	}
+
+	user, err = userRepo.Get(context.Background(), "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	if user.FirstName != "JACKSON" || user.Version != 2 {
+		t.Errorf("a mismatched update was stored: %+v", user)
+	}
+}
+
+func testUpdateVersionNotFound(t *testing.T, userRepo ddd.UserRepository) {
+	_, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson+notfound@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+		LastName:  ddd.String("SABEY"),
+		Version:   1,
+	})
+	if !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func testDelete(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca", "jackson@sabey.co")
+
+	if err := userRepo.Delete(context.Background(), "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := userRepo.Get(context.Background(), "jackson@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("deleted user was found: %v", err)
+	}
+
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	if len(users) != 1 || users[0].Email != "jackson@sabey.co" {
+		t.Errorf("unknown users left: %v", users)
+	}
+
+	// the email can be signed up again
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+}
+
+func testDeleteNotFound(t *testing.T, userRepo ddd.UserRepository) {
+	if err := userRepo.Delete(context.Background(), "jackson+notfound@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+const concurrency = 20
+
+// importStatuses is every result's `email:status`, in order
+func importStatuses(results []ddd.ImportResult) string {
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Email+":"+string(result.Status))
+	}
+
+	return strings.Join(statuses, ",")
+}
+
+func testImport(t *testing.T, userRepo ddd.UserRepository) {
+	admin := newUserCreate("b@juandefu.ca")
+	admin.Admin = true
+	admin.DisplayName = "Jack"
+	admin.Attributes = map[string]interface{}{}
+
+	results, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: []ddd.UserCreate{newUserCreate("c@juandefu.ca"), admin, newUserCreate("a@juandefu.ca")},
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "c@juandefu.ca:created,b@juandefu.ca:created,a@juandefu.ca:created" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{}); emails != "[a@juandefu.ca b@juandefu.ca c@juandefu.ca]" {
+		t.Errorf("unexpected users: %s", emails)
+	}
+
+	user := mustGet(t, userRepo, "b@juandefu.ca")
+	if !user.Admin || user.DisplayName != "Jack" || user.Version != 1 || user.FirstName != "Jackson" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	// the password is stored as it was imported, already hashed
+	if _, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "a@juandefu.ca",
+		Password: ddd.HashPassword("pass"),
+	}); err != nil {
+		t.Errorf("failed to login: %s", err)
+	}
+
+	if results, err := userRepo.Import(context.Background(), ddd.UserImport{}); err != nil || len(results) != 0 {
+		t.Errorf("unexpected empty import: %v %v", results, err)
+	}
+}
+
+func testImportConflict(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "a@juandefu.ca")
+
+	users := []ddd.UserCreate{newUserCreate("a@juandefu.ca"), newUserCreate("b@juandefu.ca")}
+	users[0].FirstName = "JACKSON"
+
+	results, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: users,
+		Mode:  ddd.ImportFail,
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:failed,b@juandefu.ca:created" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	if !errors.Is(results[0].Err, ddd.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got: %v", results[0].Err)
+	}
+
+	users[1].FirstName = "JACKSON"
+
+	results, err = userRepo.Import(context.Background(), ddd.UserImport{
+		Users: users,
+		Mode:  ddd.ImportSkip,
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:skipped,b@juandefu.ca:skipped" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	for _, email := range []string{"a@juandefu.ca", "b@juandefu.ca"} {
+		if user := mustGet(t, userRepo, email); user.FirstName != "Jackson" || user.Version != 1 {
+			t.Errorf("a conflicting user was changed: %+v", user)
+		}
+	}
+}
+
+func testImportUpsert(t *testing.T, userRepo ddd.UserRepository) {
+	registerAttributes(t)
+
+	create := newUserCreate("a@juandefu.ca")
+	create.DisplayName = "Jack"
+	create.Attributes = map[string]interface{}{"team": "core"}
+
+	if _, err := userRepo.Create(context.Background(), create); err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+
+	if _, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+		Email:    "a@juandefu.ca",
+		Avatar:   ddd.String("0123456789abcdef0123456789abcdef"),
+		Disabled: ddd.Bool(true),
+	}); err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	upsert := newUserCreate("a@juandefu.ca")
+	upsert.FirstName = "JACKSON"
+	upsert.Password = ddd.HashPassword("changed")
+	upsert.Admin = true
+	upsert.Attributes = map[string]interface{}{"seats": float64(3)}
+
+	results, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: []ddd.UserCreate{upsert, newUserCreate("b@juandefu.ca")},
+		Mode:  ddd.ImportUpsert,
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:updated,b@juandefu.ca:created" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	// the profile and attributes are replaced, the avatar, disabled and admin are kept
+	user := mustGet(t, userRepo, "a@juandefu.ca")
+	if user.FirstName != "JACKSON" || user.DisplayName != "" || user.Admin || user.Version != 3 {
+		t.Errorf("user wasn't upserted: %+v", user)
+	}
+
+	if len(user.Attributes) != 1 || user.Attributes["seats"] != float64(3) {
+		t.Errorf("attributes weren't replaced: %v", user.Attributes)
+	}
+
+	if user.Avatar != "0123456789abcdef0123456789abcdef" || !user.Disabled {
+		t.Errorf("avatar or disabled weren't kept: %+v", user)
+	}
+
+	if user.Password != ddd.HashPassword("changed") {
+		t.Errorf("password wasn't replaced")
+	}
+}
+
+func testImportUpsertAdmin(t *testing.T, userRepo ddd.UserRepository) {
+	admin := newUserCreate("a@juandefu.ca")
+	admin.Admin = true
+
+	if _, err := userRepo.Create(context.Background(), admin); err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+
+	// a created account is an admin when its row says so
+	created := newUserCreate("b@juandefu.ca")
+	created.Admin = true
+
+	results, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: []ddd.UserCreate{newUserCreate("a@juandefu.ca"), created},
+		Mode:  ddd.ImportUpsert,
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:updated,b@juandefu.ca:created" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	// an upsert doesn't revoke admin, and doesn't grant it either (testImportUpsert)
+	if user := mustGet(t, userRepo, "a@juandefu.ca"); !user.Admin {
+		t.Errorf("the upsert revoked admin: %+v", user)
+	}
+
+	if user := mustGet(t, userRepo, "b@juandefu.ca"); !user.Admin {
+		t.Errorf("the created account isn't an admin: %+v", user)
+	}
+}
+
+func testImportInvalid(t *testing.T, userRepo ddd.UserRepository) {
+	invalid := newUserCreate("b@juandefu.ca")
+	invalid.FirstName = ""
+
+	results, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: []ddd.UserCreate{newUserCreate("a@juandefu.ca"), invalid, newUserCreate("c@juandefu.ca"), newUserCreate("a@juandefu.ca")},
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:created,b@juandefu.ca:failed,c@juandefu.ca:created,a@juandefu.ca:failed" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	if results[1].Err == nil || results[1].Err.Error() != "firstName was empty" {
+		t.Errorf("expected the validation error, got: %v", results[1].Err)
+	}
+
+	if results[3].Err == nil {
+		t.Errorf("a repeated email didn't fail")
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{}); emails != "[a@juandefu.ca c@juandefu.ca]" {
+		t.Errorf("unexpected users: %s", emails)
+	}
+
+	if _, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: []ddd.UserCreate{newUserCreate("d@juandefu.ca")},
+		Mode:  "replace",
+	}); err == nil {
+		t.Errorf("imported with an invalid mode?")
+	}
+
+	if _, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users: make([]ddd.UserCreate, ddd.MaxImportBatch+1),
+	}); err == nil {
+		t.Errorf("imported too many users?")
+	}
+}
+
+func testImportDryRun(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "a@juandefu.ca")
+
+	upsert := newUserCreate("a@juandefu.ca")
+	upsert.FirstName = "JACKSON"
+
+	results, err := userRepo.Import(context.Background(), ddd.UserImport{
+		Users:  []ddd.UserCreate{upsert, newUserCreate("b@juandefu.ca")},
+		Mode:   ddd.ImportUpsert,
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:updated,b@juandefu.ca:created" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{}); emails != "[a@juandefu.ca]" {
+		t.Errorf("a dry run created users: %s", emails)
+	}
+
+	if user := mustGet(t, userRepo, "a@juandefu.ca"); user.FirstName != "Jackson" || user.Version != 1 {
+		t.Errorf("a dry run updated a user: %+v", user)
+	}
+}
+
+// acme is the tenant of the tenant tests, the repositories don't check that its organization exists
+const acme = "acme"
+
+func testTenantCreate(t *testing.T, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	user, err := userRepo.Create(context.Background(), newUserCreate("jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	if user.Organization != ddd.DefaultOrganization {
+		t.Errorf("a context without a tenant is the default organization's: %s", user.Organization)
+	}
+
+	// an email is only unique within its organization
+	user, err = userRepo.Create(acmeCtx, newUserCreate("jackson@juandefu.ca"))
+	if err != nil {
+		t.Fatalf("failed to create user in %s: %s", acme, err)
+	}
+
+	if user.Organization != acme {
+		t.Errorf("user wasn't created in %s: %s", acme, user.Organization)
+	}
+
+	if _, err := userRepo.Create(acmeCtx, newUserCreate("jackson@juandefu.ca")); !errors.Is(err, ddd.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got: %v", err)
+	}
+
+	if user, err := userRepo.Get(acmeCtx, "jackson@juandefu.ca"); err != nil || user.Organization != acme {
+		t.Errorf("unexpected user: %+v %v", user, err)
+	}
+}
+
+func testTenantIsolation(t *testing.T, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	mustCreate(t, userRepo, "a@juandefu.ca", "b@juandefu.ca")
+
+	opts := newUserCreate("c@juandefu.ca")
+	opts.Password = ddd.HashPassword("acme")
+	if _, err := userRepo.Create(acmeCtx, opts); err != nil {
+		t.Fatalf("failed to create user in %s: %s", acme, err)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{}); emails != "[a@juandefu.ca b@juandefu.ca]" {
+		t.Errorf("another organization's accounts were listed: %s", emails)
+	}
+
+	users, err := userRepo.List(acmeCtx, ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	if len(users) != 1 || users[0].Email != "c@juandefu.ca" || users[0].Organization != acme {
+		t.Errorf("unexpected users in %s: %v", acme, users)
+	}
+
+	// another organization's account doesn't exist
+	if _, err := userRepo.Get(acmeCtx, "a@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if _, err := userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "c@juandefu.ca",
+		Password: ddd.HashPassword("acme"),
+	}); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if _, err := userRepo.Login(acmeCtx, ddd.UserLogin{
+		Email:    "c@juandefu.ca",
+		Password: ddd.HashPassword("acme"),
+	}); err != nil {
+		t.Errorf("failed to login to %s: %s", acme, err)
+	}
+
+	if _, err := userRepo.Update(acmeCtx, ddd.UserUpdate{
+		Email:     "a@juandefu.ca",
+		FirstName: ddd.String("ACME"),
+	}); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if err := userRepo.Delete(acmeCtx, "b@juandefu.ca"); !errors.Is(err, ddd.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	if user := mustGet(t, userRepo, "a@juandefu.ca"); user.FirstName != "Jackson" || user.Version != 1 {
+		t.Errorf("another organization changed the user: %+v", user)
+	}
+
+	if err := userRepo.Delete(acmeCtx, "c@juandefu.ca"); err != nil {
+		t.Errorf("failed to delete from %s: %s", acme, err)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{}); emails != "[a@juandefu.ca b@juandefu.ca]" {
+		t.Errorf("unexpected users: %s", emails)
+	}
+}
+
+func testTenantImport(t *testing.T, userRepo ddd.UserRepository) {
+	acmeCtx := ddd.WithTenant(context.Background(), acme)
+
+	mustCreate(t, userRepo, "a@juandefu.ca")
+
+	// the default organization's account isn't a conflict
+	results, err := userRepo.Import(acmeCtx, ddd.UserImport{
+		Users: []ddd.UserCreate{newUserCreate("a@juandefu.ca"), newUserCreate("b@juandefu.ca")},
+	})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:created,b@juandefu.ca:created" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	if emails := listedEmails(t, userRepo, ddd.UserList{}); emails != "[a@juandefu.ca]" {
+		t.Errorf("the import wrote to the default organization: %s", emails)
+	}
+
+	users := []ddd.UserCreate{newUserCreate("a@juandefu.ca")}
+	users[0].FirstName = "ACME"
+
+	results, err = userRepo.Import(acmeCtx, ddd.UserImport{Users: users, Mode: ddd.ImportUpsert})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if statuses := importStatuses(results); statuses != "a@juandefu.ca:updated" {
+		t.Errorf("unexpected results: %s", statuses)
+	}
+
+	if user := mustGet(t, userRepo, "a@juandefu.ca"); user.FirstName != "Jackson" {
+		t.Errorf("the upsert changed the default organization's account: %+v", user)
+	}
+}
+
+func testConcurrentCreate(t *testing.T, userRepo ddd.UserRepository) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if _, err := userRepo.Create(context.Background(), newUserCreate(fmt.Sprintf("jackson+%02d@juandefu.ca", i))); err != nil {
+				t.Errorf("failed to create user: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	if len(users) != concurrency {
+		t.Errorf("invalid amount of users: %d", len(users))
+	}
+}
+
+func testConcurrentDuplicate(t *testing.T, userRepo ddd.UserRepository) {
+	var created, exists int32
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := userRepo.Create(context.Background(), newUserCreate("jackson@juandefu.ca"))
+			switch {
+			case err == nil:
+				atomic.AddInt32(&created, 1)
+			case errors.Is(err, ddd.ErrUserExists):
+				atomic.AddInt32(&exists, 1)
+			default:
+				t.Errorf("unknown error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 || exists != concurrency-1 {
+		t.Errorf("expected exactly one create: %d created, %d already existed", created, exists)
+	}
+}
+
+func testConcurrentUpdate(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+				Email:     "jackson@juandefu.ca",
+				FirstName: ddd.String(fmt.Sprintf("Jackson%02d", i)),
+				LastName:  ddd.String(fmt.Sprintf("Sabey%02d", i)),
+			})
+			if err != nil {
+				t.Errorf("failed to update: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	users, err := userRepo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+
+	// updates are atomic, both names come from the same write
+	if len(users) != 1 || users[0].FirstName[len("Jackson"):] != users[0].LastName[len("Sabey"):] {
+		t.Errorf("torn update: %+v", users[0])
+	}
+}
+
+func testConcurrentUpdateVersion(t *testing.T, userRepo ddd.UserRepository) {
+	mustCreate(t, userRepo, "jackson@juandefu.ca")
+
+	var updated, mismatched int32
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// every client read version 1
+			_, err := userRepo.Update(context.Background(), ddd.UserUpdate{
+				Email:     "jackson@juandefu.ca",
+				FirstName: ddd.String(fmt.Sprintf("Jackson%02d", i)),
+				LastName:  ddd.String(fmt.Sprintf("Sabey%02d", i)),
+				Version:   1,
+			})
+
+			switch {
+			case err == nil:
+				atomic.AddInt32(&updated, 1)
+			case errors.Is(err, ddd.ErrVersionMismatch):
+				atomic.AddInt32(&mismatched, 1)
+			default:
+				t.Errorf("failed to update: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if updated != 1 || mismatched != concurrency-1 {
+		t.Errorf("expected exactly one update to win: %d updated, %d mismatched", updated, mismatched)
+	}
+}
+
+func testCancelled(t *testing.T, userRepo ddd.UserRepository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := userRepo.Create(ctx, newUserCreate("jackson@juandefu.ca")); !errors.Is(err, context.Canceled) {
+		t.Errorf("create: expected context.Canceled, got: %v", err)
+	}
+
+	if _, err := userRepo.List(ctx, ddd.UserList{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("list: expected context.Canceled, got: %v", err)
+	}
+}
@@ -0,0 +1,473 @@
+// Package federation logs accounts in through external OpenID Connect identity providers, "Sign in with <corporate IdP>"
+// it's the authorization code flow with PKCE, a provider's subject is linked to an account the first time it logs in
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// DefaultScopes are asked of a provider without scopes
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// defaultHTTPTimeout bounds every request to a provider, a login waits on them
+const defaultHTTPTimeout = 10 * time.Second
+
+var (
+	// ErrProviderNotFound is returned for a provider that isn't configured
+	ErrProviderNotFound = errors.New("identity provider isn't configured")
+	// ErrInvalidSession is returned for a login session that's invalid, expired or of another provider
+	ErrInvalidSession = errors.New("federated login session is invalid or expired")
+	// ErrInvalidState is returned when the provider's redirect isn't for the session
+	ErrInvalidState = errors.New("state doesn't match the login session")
+	// ErrProviderFailed wraps why the provider's discovery, keys or token endpoint failed
+	ErrProviderFailed = errors.New("identity provider failed")
+	// ErrInvalidCode is returned when the provider's token endpoint rejected the code, it was used or it expired
+	ErrInvalidCode = errors.New("code was rejected by the identity provider")
+	// ErrInvalidIDToken wraps why the provider's id token wasn't accepted
+	ErrInvalidIDToken = errors.New("id token is invalid")
+	// ErrEmailNotVerified is returned when an account would be provisioned or linked with an email the provider didn't verify
+	ErrEmailNotVerified = errors.New("identity provider didn't send a verified email")
+	// ErrDomainNotAllowed is returned for an email outside of the provider's domains
+	ErrDomainNotAllowed = errors.New("email domain isn't allowed for the identity provider")
+	// ErrAccountExists is returned when the email has an account and the provider can't link existing accounts
+	ErrAccountExists = errors.New("account exists and isn't linked to the identity provider")
+	// ErrMissingName is returned when an account would be provisioned without a first and last name
+	ErrMissingName = errors.New("identity provider didn't send a name")
+)
+
+// ProviderConfig is an upstream identity provider, it's read from the -oidc-providers json file
+type ProviderConfig struct {
+	// Name is in the urls and stored with the identities, "acme-sso", it can't change once accounts are linked
+	Name string `json:"name"`
+	// DisplayName is shown on the login button, "Acme", it's Name when empty
+	DisplayName string `json:"displayName,omitempty"`
+	// Issuer is discovered from "/.well-known/openid-configuration", the id tokens' iss has to be it
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// RedirectURL is where the provider sends the browser back with the code, it's registered at the provider
+	RedirectURL string `json:"redirectUrl"`
+	// Scopes are DefaultScopes when empty, openid is always asked
+	Scopes []string `json:"scopes,omitempty"`
+	// Organization holds the provisioned accounts, ddd.DefaultOrganization when empty
+	Organization string `json:"organization,omitempty"`
+	// Domains limit the emails that can log in, every domain is allowed when empty
+	Domains []string `json:"domains,omitempty"`
+	// LinkExistingAccounts links a subject to the account that already has its verified email
+	// without it the account's owner has to be trusted to be the provider's, so the login is refused
+	LinkExistingAccounts bool `json:"linkExistingAccounts,omitempty"`
+	// TrustEmails accepts emails without email_verified, for providers that only have verified emails and don't say so
+	TrustEmails bool `json:"trustEmails,omitempty"`
+}
+
+func (pc ProviderConfig) Validate() error {
+	if err := ddd.ValidateProviderName(pc.Name); err != nil {
+		return err
+	}
+
+	issuer, err := url.Parse(pc.Issuer)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return fmt.Errorf("issuer isn't an http or https url: %s", pc.Issuer)
+	}
+
+	if pc.ClientID == "" {
+		return errors.New("clientId was empty")
+	}
+
+	redirect, err := url.Parse(pc.RedirectURL)
+	if err != nil || (redirect.Scheme != "https" && redirect.Scheme != "http") || redirect.Host == "" || redirect.Fragment != "" {
+		return fmt.Errorf("redirectUrl isn't an http or https url: %s", pc.RedirectURL)
+	}
+
+	if pc.Organization != "" {
+		if err := ddd.ValidateOrganizationID(pc.Organization); err != nil {
+			return err
+		}
+	}
+
+	for _, domain := range pc.Domains {
+		if domain == "" || strings.Contains(domain, "@") {
+			return fmt.Errorf("domain is invalid: %q", domain)
+		}
+	}
+
+	return nil
+}
+
+// ReadProviders decodes a json array of providers
+func ReadProviders(data []byte) ([]ProviderConfig, error) {
+	providers := []ProviderConfig{}
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
+
+type FederationOpts struct {
+	// Repository links the providers' subjects to accounts
+	Repository ddd.IdentityRepository
+	// Users provisions and looks up the accounts
+	Users     ddd.UserRepository
+	Providers []ProviderConfig
+	// HTTPClient calls the providers, it's a client with a 10 second timeout when nil
+	HTTPClient *http.Client
+}
+
+// Federation starts logins at the providers and finishes them with the code the browser brings back
+// the state of a login is a signed token (ddd.SignFederatedLogin) the browser keeps until it's back
+type Federation struct {
+	repo      ddd.IdentityRepository
+	users     ddd.UserRepository
+	providers map[string]*provider
+	// names keeps the configured order for Providers
+	names []string
+}
+
+func NewFederation(
+	opts FederationOpts,
+) (
+	*Federation,
+	error,
+) {
+	if opts.Repository == nil {
+		return nil, errors.New("repository was nil")
+	}
+
+	if opts.Users == nil {
+		return nil, errors.New("users was nil")
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	f := &Federation{
+		repo:      opts.Repository,
+		users:     opts.Users,
+		providers: map[string]*provider{},
+	}
+
+	for _, config := range opts.Providers {
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("provider %q: %w", config.Name, err)
+		}
+
+		if _, ok := f.providers[config.Name]; ok {
+			return nil, fmt.Errorf("provider %q was configured twice", config.Name)
+		}
+
+		f.providers[config.Name] = newProvider(config, opts.HTTPClient)
+		f.names = append(f.names, config.Name)
+	}
+
+	return f, nil
+}
+
+// Provider is what the login page needs of a configured provider
+type Provider struct {
+	Name        string
+	DisplayName string
+}
+
+// Providers returns the configured providers in their configured order
+func (f *Federation) Providers() []Provider {
+	providers := []Provider{}
+	for _, name := range f.names {
+		config := f.providers[name].config
+
+		displayName := config.DisplayName
+		if displayName == "" {
+			displayName = config.Name
+		}
+
+		providers = append(providers, Provider{
+			Name:        config.Name,
+			DisplayName: displayName,
+		})
+	}
+
+	return providers
+}
+
+// LoginRedirect starts a login, the browser goes to URL and keeps Session until the provider sends it back
+type LoginRedirect struct {
+	URL     string
+	Session string
+}
+
+// Begin returns where to send the browser to log in at the provider
+// the provider's discovery document is fetched the first time
+func (f *Federation) Begin(
+	ctx context.Context,
+	name string,
+) (
+	*LoginRedirect,
+	error,
+) {
+	p, ok := f.providers[name]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	login := ddd.FederatedLogin{Provider: name}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		if *value, err = randomString(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LoginRedirect{
+		URL:     p.authorizeURL(metadata, login),
+		Session: ddd.SignFederatedLogin(login),
+	}, nil
+}
+
+// Login is an account that logged in through a provider
+type Login struct {
+	Tenant   string
+	Email    string
+	Provider string
+	Subject  string
+	// Created is true when the account was provisioned by the login
+	Created bool
+}
+
+// Finish exchanges the provider's code and logs the id token's subject in
+// a subject that isn't linked is linked to the account with its email, the account is provisioned when it doesn't exist
+func (f *Federation) Finish(
+	ctx context.Context,
+	session string,
+	code string,
+	state string,
+) (
+	*Login,
+	error,
+) {
+	login := ddd.ParseFederatedLogin(session)
+	if login == nil {
+		return nil, ErrInvalidSession
+	}
+
+	p, ok := f.providers[login.Provider]
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+
+	if state == "" || state != login.State {
+		return nil, ErrInvalidState
+	}
+
+	if code == "" {
+		return nil, errors.New("code was empty")
+	}
+
+	claims, err := p.exchange(ctx, code, *login)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := f.repo.GetIdentity(ctx, p.config.Name, claims.Subject)
+	if errors.Is(err, ddd.ErrIdentityNotFound) {
+		return f.provision(ctx, p.config, claims)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.checkAccount(ctx, identity.Organization, identity.Email); err != nil {
+		return nil, err
+	}
+
+	return &Login{
+		Tenant:   identity.Organization,
+		Email:    identity.Email,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+	}, nil
+}
+
+// checkAccount returns ddd.ErrUserDisabled for a disabled account
+func (f *Federation) checkAccount(ctx context.Context, tenant string, email string) error {
+	user, err := f.users.Get(ddd.WithTenant(ctx, tenant), email)
+	if err != nil {
+		return err
+	}
+
+	if user.Disabled {
+		return ddd.ErrUserDisabled
+	}
+
+	return nil
+}
+
+// provision links the subject to the account of its email, creating the account when it doesn't exist
+func (f *Federation) provision(
+	ctx context.Context,
+	config ProviderConfig,
+	claims *idTokenClaims,
+) (
+	*Login,
+	error,
+) {
+	if !claims.EmailVerified && !config.TrustEmails {
+		return nil, ErrEmailNotVerified
+	}
+
+	email := claims.Email
+	if err := ddd.ValidateEmail(email); err != nil || !strings.Contains(email, "@") {
+		return nil, ErrEmailNotVerified
+	}
+
+	if !allowedDomain(config.Domains, email) {
+		return nil, ErrDomainNotAllowed
+	}
+
+	tenant := config.Organization
+	if tenant == "" {
+		tenant = ddd.DefaultOrganization
+	}
+
+	ctx = ddd.WithTenant(ctx, tenant)
+
+	created := false
+
+	_, err := f.users.Get(ctx, email)
+	switch {
+	case err == nil:
+		if !config.LinkExistingAccounts {
+			return nil, ErrAccountExists
+		}
+
+		if err := f.checkAccount(ctx, tenant, email); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, ddd.ErrUserNotFound):
+		if err := f.createAccount(ctx, email, claims); err != nil {
+			return nil, err
+		}
+
+		created = true
+	default:
+		return nil, err
+	}
+
+	identity, err := f.repo.CreateIdentity(ctx, ddd.IdentityCreate{
+		Provider:     config.Name,
+		Subject:      claims.Subject,
+		Organization: tenant,
+		Email:        email,
+	})
+	if errors.Is(err, ddd.ErrIdentityExists) {
+		// a concurrent login of the subject linked it first
+		identity, err = f.repo.GetIdentity(ctx, config.Name, claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := f.checkAccount(ctx, identity.Organization, identity.Email); err != nil {
+			return nil, err
+		}
+
+		created = false
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Login{
+		Tenant:   identity.Organization,
+		Email:    identity.Email,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Created:  created,
+	}, nil
+}
+
+// createAccount provisions the account with a random password, it logs in through its provider
+// the account can still reset its password to log in without it
+func (f *Federation) createAccount(ctx context.Context, email string, claims *idTokenClaims) error {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" || lastName == "" {
+		// a provider without given_name and family_name may still have a full name
+		if fields := strings.Fields(claims.Name); len(fields) > 1 {
+			firstName = strings.Join(fields[:len(fields)-1], " ")
+			lastName = fields[len(fields)-1]
+		}
+	}
+
+	if firstName == "" || lastName == "" {
+		return ErrMissingName
+	}
+
+	password, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.users.Create(ctx, ddd.UserCreate{
+		Email:     email,
+		FirstName: firstName,
+		LastName:  lastName,
+		Password:  ddd.HashPassword(password),
+	})
+	if errors.Is(err, ddd.ErrUserExists) {
+		// a concurrent login created it, it isn't linked to the subject yet
+		return ErrAccountExists
+	}
+
+	return err
+}
+
+// Identities returns the providers' subjects linked to the context's account
+func (f *Federation) Identities(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.Identity,
+	error,
+) {
+	return f.repo.ListIdentities(ctx, email)
+}
+
+// allowedDomain is true when domains is empty or has the email's domain
+func allowedDomain(domains []string, email string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range domains {
+		if strings.EqualFold(allowed, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// randomString is 256 random bits, base64url, it's a valid PKCE code_verifier
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
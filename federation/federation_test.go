@@ -0,0 +1,419 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/federation/federationtest"
+	"github.com/sabey/ddd/mock"
+)
+
+const testRedirectURL = "https://ddd.example/login/oidc/callback"
+
+func newTestFederation(t *testing.T, configure func(*ProviderConfig)) (*Federation, *federationtest.IdentityProvider, *mock.UserRepository) {
+	t.Helper()
+
+	idp := federationtest.NewIdentityProvider("ddd", "secret", testRedirectURL)
+	t.Cleanup(idp.Close)
+
+	config := ProviderConfig{
+		Name:         "acme-sso",
+		Issuer:       idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  testRedirectURL,
+	}
+
+	if configure != nil {
+		configure(&config)
+	}
+
+	repo := mock.NewUserRepository()
+
+	f, err := NewFederation(FederationOpts{
+		Repository: repo,
+		Users:      repo,
+		Providers:  []ProviderConfig{config},
+	})
+	if err != nil {
+		t.Fatalf("failed to create federation: %s", err)
+	}
+
+	return f, idp, repo
+}
+
+// login runs a login at the provider the way a browser would
+func login(t *testing.T, f *Federation, idp *federationtest.IdentityProvider) (*Login, error) {
+	t.Helper()
+
+	redirect, err := f.Begin(context.Background(), "acme-sso")
+	if err != nil {
+		t.Fatalf("failed to begin login: %s", err)
+	}
+
+	code, state, err := idp.Authorize(redirect.URL)
+	if err != nil {
+		t.Fatalf("provider failed to authorize: %s", err)
+	}
+
+	return f.Finish(context.Background(), redirect.Session, code, state)
+}
+
+func createUser(t *testing.T, repo ddd.UserRepository, email string) {
+	t.Helper()
+
+	if _, err := repo.Create(context.Background(), ddd.UserCreate{
+		Email:     email,
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	}); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+}
+
+func TestFederation(t *testing.T) {
+	for _, secret := range []string{"secret", ""} {
+		name := "Confidential"
+		if secret == "" {
+			name = "Public"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			f, idp, repo := newTestFederation(t, func(config *ProviderConfig) {
+				config.ClientSecret = secret
+			})
+			idp.ClientSecret = secret
+
+			// the first login provisions the account
+			first, err := login(t, f, idp)
+			if err != nil {
+				t.Fatalf("failed to login: %s", err)
+			}
+
+			if !first.Created || first.Tenant != ddd.DefaultOrganization || first.Email != "jackson@juandefu.ca" ||
+				first.Provider != "acme-sso" || first.Subject != "248289761001" {
+				t.Errorf("unknown login: %+v", first)
+			}
+
+			user, err := repo.Get(context.Background(), "jackson@juandefu.ca")
+			if err != nil {
+				t.Fatalf("failed to get user: %s", err)
+			}
+
+			if user.FirstName != "Jackson" || user.LastName != "Sabey" || user.Password == "" {
+				t.Errorf("unknown user: %+v", user)
+			}
+
+			// the subject is linked, its email at the provider may change
+			idp.Claims["email"] = "jackson@sabey.co"
+
+			second, err := login(t, f, idp)
+			if err != nil {
+				t.Fatalf("failed to login: %s", err)
+			}
+
+			if second.Created || second.Email != "jackson@juandefu.ca" {
+				t.Errorf("unknown login: %+v", second)
+			}
+
+			identities, err := f.Identities(context.Background(), "jackson@juandefu.ca")
+			if err != nil || len(identities) != 1 || identities[0].Subject != "248289761001" {
+				t.Errorf("unknown identities: %v %v", identities, err)
+			}
+		})
+	}
+}
+
+func TestFederation_Session(t *testing.T) {
+	f, idp, _ := newTestFederation(t, nil)
+	ctx := context.Background()
+
+	if _, err := f.Begin(ctx, "other-sso"); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("expected ErrProviderNotFound, got: %v", err)
+	}
+
+	redirect, err := f.Begin(ctx, "acme-sso")
+	if err != nil {
+		t.Fatalf("failed to begin login: %s", err)
+	}
+
+	code, state, err := idp.Authorize(redirect.URL)
+	if err != nil {
+		t.Fatalf("provider failed to authorize: %s", err)
+	}
+
+	if _, err := f.Finish(ctx, redirect.Session+"x", code, state); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("expected ErrInvalidSession, got: %v", err)
+	}
+
+	// a token isn't a session
+	if _, err := f.Finish(ctx, ddd.SignTenantJWTClaims(ddd.DefaultOrganization, "jackson@juandefu.ca"), code, state); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("expected ErrInvalidSession, got: %v", err)
+	}
+
+	// the state of another login's redirect
+	other, err := f.Begin(ctx, "acme-sso")
+	if err != nil {
+		t.Fatalf("failed to begin login: %s", err)
+	}
+
+	if _, err := f.Finish(ctx, other.Session, code, state); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState, got: %v", err)
+	}
+
+	if _, err := f.Finish(ctx, redirect.Session, code, state); err != nil {
+		t.Fatalf("failed to finish login: %s", err)
+	}
+
+	// a code is redeemed once
+	if _, err := f.Finish(ctx, redirect.Session, code, state); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode, got: %v", err)
+	}
+}
+
+func TestFederation_IDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"Issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"Audience", func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{"AuthorizedParty", func(c jwt.MapClaims) { c["aud"] = []string{"ddd", "other"}; c["azp"] = "other" }},
+		{"Expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"IssuedInTheFuture", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"Nonce", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"Subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, idp, _ := newTestFederation(t, nil)
+			idp.Modify = tt.modify
+
+			if _, err := login(t, f, idp); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("expected ErrInvalidIDToken, got: %v", err)
+			}
+		})
+	}
+
+	t.Run("Audiences", func(t *testing.T) {
+		f, idp, _ := newTestFederation(t, nil)
+		idp.Modify = func(c jwt.MapClaims) {
+			c["aud"] = []string{"ddd", "other"}
+			c["azp"] = "ddd"
+		}
+
+		if _, err := login(t, f, idp); err != nil {
+			t.Errorf("failed to login: %s", err)
+		}
+	})
+}
+
+func TestFederation_RotateKey(t *testing.T) {
+	f, idp, _ := newTestFederation(t, nil)
+
+	if _, err := login(t, f, idp); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	idp.RotateKey()
+
+	// the keys were just fetched, an unknown kid doesn't fetch them again right away
+	if _, err := login(t, f, idp); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected ErrInvalidIDToken, got: %v", err)
+	}
+
+	if requests := idp.KeyRequests(); requests != 1 {
+		t.Errorf("expected the keys to be fetched once, got: %d", requests)
+	}
+
+	p := f.providers["acme-sso"]
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-keysRefreshInterval)
+	p.mu.Unlock()
+
+	if _, err := login(t, f, idp); err != nil {
+		t.Errorf("failed to login: %s", err)
+	}
+
+	if requests := idp.KeyRequests(); requests != 2 {
+		t.Errorf("expected the keys to be fetched twice, got: %d", requests)
+	}
+}
+
+func TestFederation_Provision(t *testing.T) {
+	t.Run("EmailNotVerified", func(t *testing.T) {
+		f, idp, _ := newTestFederation(t, nil)
+		idp.Claims["email_verified"] = false
+
+		if _, err := login(t, f, idp); !errors.Is(err, ErrEmailNotVerified) {
+			t.Errorf("expected ErrEmailNotVerified, got: %v", err)
+		}
+
+		// some providers send a string
+		idp.Claims["email_verified"] = "true"
+
+		if _, err := login(t, f, idp); err != nil {
+			t.Errorf("failed to login: %s", err)
+		}
+	})
+
+	t.Run("TrustEmails", func(t *testing.T) {
+		f, idp, _ := newTestFederation(t, func(config *ProviderConfig) {
+			config.TrustEmails = true
+		})
+		delete(idp.Claims, "email_verified")
+
+		if _, err := login(t, f, idp); err != nil {
+			t.Errorf("failed to login: %s", err)
+		}
+	})
+
+	t.Run("Domains", func(t *testing.T) {
+		f, idp, _ := newTestFederation(t, func(config *ProviderConfig) {
+			config.Domains = []string{"sabey.co"}
+		})
+
+		if _, err := login(t, f, idp); !errors.Is(err, ErrDomainNotAllowed) {
+			t.Errorf("expected ErrDomainNotAllowed, got: %v", err)
+		}
+
+		idp.Claims["email"] = "jackson@SABEY.co"
+
+		if _, err := login(t, f, idp); err != nil {
+			t.Errorf("failed to login: %s", err)
+		}
+	})
+
+	t.Run("AccountExists", func(t *testing.T) {
+		f, idp, repo := newTestFederation(t, nil)
+		createUser(t, repo, "jackson@juandefu.ca")
+
+		if _, err := login(t, f, idp); !errors.Is(err, ErrAccountExists) {
+			t.Errorf("expected ErrAccountExists, got: %v", err)
+		}
+	})
+
+	t.Run("LinkExistingAccounts", func(t *testing.T) {
+		f, idp, repo := newTestFederation(t, func(config *ProviderConfig) {
+			config.LinkExistingAccounts = true
+		})
+		createUser(t, repo, "jackson@juandefu.ca")
+
+		l, err := login(t, f, idp)
+		if err != nil {
+			t.Fatalf("failed to login: %s", err)
+		}
+
+		if l.Created || l.Email != "jackson@juandefu.ca" {
+			t.Errorf("unknown login: %+v", l)
+		}
+
+		// the account still logs in with its password
+		user, err := repo.Get(context.Background(), "jackson@juandefu.ca")
+		if err != nil || user.Password != ddd.HashPassword("pass") {
+			t.Errorf("the account's password was changed: %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		f, idp, repo := newTestFederation(t, nil)
+
+		if _, err := login(t, f, idp); err != nil {
+			t.Fatalf("failed to login: %s", err)
+		}
+
+		if _, err := repo.Update(context.Background(), ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(true)}); err != nil {
+			t.Fatalf("failed to disable user: %s", err)
+		}
+
+		if _, err := login(t, f, idp); !errors.Is(err, ddd.ErrUserDisabled) {
+			t.Errorf("expected ErrUserDisabled, got: %v", err)
+		}
+	})
+
+	t.Run("Name", func(t *testing.T) {
+		f, idp, repo := newTestFederation(t, nil)
+		delete(idp.Claims, "given_name")
+		delete(idp.Claims, "family_name")
+
+		if _, err := login(t, f, idp); !errors.Is(err, ErrMissingName) {
+			t.Errorf("expected ErrMissingName, got: %v", err)
+		}
+
+		idp.Claims["name"] = "Jackson van Sabey"
+
+		if _, err := login(t, f, idp); err != nil {
+			t.Fatalf("failed to login: %s", err)
+		}
+
+		user, err := repo.Get(context.Background(), "jackson@juandefu.ca")
+		if err != nil || user.FirstName != "Jackson van" || user.LastName != "Sabey" {
+			t.Errorf("unknown user: %+v %v", user, err)
+		}
+	})
+
+	t.Run("Organization", func(t *testing.T) {
+		f, idp, repo := newTestFederation(t, func(config *ProviderConfig) {
+			config.Organization = "acme"
+		})
+
+		if _, err := repo.CreateOrganization(context.Background(), ddd.OrganizationCreate{ID: "acme", Name: "Acme"}); err != nil {
+			t.Fatalf("failed to create organization: %s", err)
+		}
+
+		l, err := login(t, f, idp)
+		if err != nil {
+			t.Fatalf("failed to login: %s", err)
+		}
+
+		if l.Tenant != "acme" {
+			t.Errorf("unknown login: %+v", l)
+		}
+
+		if _, err := repo.Get(ddd.WithTenant(context.Background(), "acme"), "jackson@juandefu.ca"); err != nil {
+			t.Errorf("failed to get user: %s", err)
+		}
+	})
+}
+
+func TestNewFederation(t *testing.T) {
+	repo := mock.NewUserRepository()
+
+	for _, config := range []ProviderConfig{
+		{Name: "Acme SSO", Issuer: "https://acme.example", ClientID: "ddd", RedirectURL: testRedirectURL},
+		{Name: "acme-sso", Issuer: "acme.example", ClientID: "ddd", RedirectURL: testRedirectURL},
+		{Name: "acme-sso", Issuer: "https://acme.example", RedirectURL: testRedirectURL},
+		{Name: "acme-sso", Issuer: "https://acme.example", ClientID: "ddd"},
+		{Name: "acme-sso", Issuer: "https://acme.example", ClientID: "ddd", RedirectURL: testRedirectURL, Domains: []string{"@acme.example"}},
+	} {
+		if _, err := NewFederation(FederationOpts{Repository: repo, Users: repo, Providers: []ProviderConfig{config}}); err == nil {
+			t.Errorf("an invalid provider was configured: %+v", config)
+		}
+	}
+
+	config := ProviderConfig{Name: "acme-sso", Issuer: "https://acme.example", ClientID: "ddd", RedirectURL: testRedirectURL}
+	if _, err := NewFederation(FederationOpts{Repository: repo, Users: repo, Providers: []ProviderConfig{config, config}}); err == nil {
+		t.Errorf("a provider was configured twice")
+	}
+
+	// the discovered issuer has to be the configured one
+	idp := federationtest.NewIdentityProvider("ddd", "", testRedirectURL)
+	defer idp.Close()
+
+	config.Issuer = idp.URL + "/"
+
+	f, err := NewFederation(FederationOpts{Repository: repo, Users: repo, Providers: []ProviderConfig{config}})
+	if err != nil {
+		t.Fatalf("failed to create federation: %s", err)
+	}
+
+	if _, err := f.Begin(context.Background(), "acme-sso"); !errors.Is(err, ErrProviderFailed) {
+		t.Errorf("expected ErrProviderFailed, got: %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Package federationtest is an OpenID Connect identity provider on an httptest server, it logs in whoever Claims says
+package federationtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// authorization is a code that hasn't been redeemed
+type authorization struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	claims      jwt.MapClaims
+}
+
+// IdentityProvider has one client, its authorize endpoint logs in without asking and redirects with a code
+type IdentityProvider struct {
+	// URL is the issuer
+	URL          string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// Claims are the id token's claims of the next authorization, they're copied when it's authorized
+	// iss, aud, exp, iat and nonce are set for it
+	Claims jwt.MapClaims
+	// Modify changes an id token's claims right before it's signed, to test tokens the provider shouldn't issue
+	Modify func(jwt.MapClaims)
+
+	server *httptest.Server
+
+	mu    sync.Mutex
+	kid   string
+	key   *rsa.PrivateKey
+	codes map[string]*authorization
+	// keyRequests counts the requests of the jwks endpoint
+	keyRequests int
+}
+
+// NewIdentityProvider starts the provider, Close stops it
+// the client authenticates with client_secret_basic when clientSecret isn't empty, it's a public client otherwise
+func NewIdentityProvider(clientID string, clientSecret string, redirectURL string) *IdentityProvider {
+	idp := &IdentityProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Claims: jwt.MapClaims{
+			"sub":            "248289761001",
+			"email":          "jackson@juandefu.ca",
+			"email_verified": true,
+			"given_name":     "Jackson",
+			"family_name":    "Sabey",
+		},
+		codes: map[string]*authorization{},
+	}
+
+	idp.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+
+	idp.server = httptest.NewServer(mux)
+	idp.URL = idp.server.URL
+
+	return idp
+}
+
+func (idp *IdentityProvider) Close() {
+	idp.server.Close()
+}
+
+// RotateKey signs the next id tokens with a new key and kid, the jwks endpoint only has the new key
+func (idp *IdentityProvider) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	idp.key = key
+	idp.kid = randomString()
+}
+
+// KeyRequests returns how many times the keys were fetched
+func (idp *IdentityProvider) KeyRequests() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	return idp.keyRequests
+}
+
+// Authorize follows a login's url to the authorize endpoint, as a browser would, and returns the redirect's code and state
+func (idp *IdentityProvider) Authorize(authURL string) (code string, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorize returned %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+
+	if e := location.Query().Get("error"); e != "" {
+		return "", "", errors.New(e)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (idp *IdentityProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                idp.URL,
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *IdentityProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != idp.ClientID || query.Get("redirect_uri") != idp.RedirectURL {
+		http.Error(w, "unknown client or redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	redirect, _ := url.Parse(idp.RedirectURL)
+	values := redirect.Query()
+	values.Set("state", query.Get("state"))
+
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		values.Set("error", "invalid_request")
+	} else {
+		code := randomString()
+
+		idp.mu.Lock()
+		claims := jwt.MapClaims{}
+		for k, v := range idp.Claims {
+			claims[k] = v
+		}
+
+		idp.codes[code] = &authorization{
+			redirectURI: query.Get("redirect_uri"),
+			nonce:       query.Get("nonce"),
+			challenge:   query.Get("code_challenge"),
+			claims:      claims,
+		}
+		idp.mu.Unlock()
+
+		values.Set("code", code)
+	}
+
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *IdentityProvider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	if clientID != idp.ClientID || clientSecret != idp.ClientSecret {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	idp.mu.Lock()
+	code := r.PostForm.Get("code")
+	authorization, ok := idp.codes[code]
+	// a code is redeemed once
+	delete(idp.codes, code)
+	key, kid := idp.key, idp.kid
+	idp.mu.Unlock()
+
+	if !ok || authorization.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+
+	claims := authorization.claims
+	claims["iss"] = idp.URL
+	claims["aud"] = idp.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if authorization.nonce != "" {
+		claims["nonce"] = authorization.nonce
+	}
+
+	if idp.Modify != nil {
+		idp.Modify(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (idp *IdentityProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	key, kid := idp.key, idp.kid
+	idp.keyRequests++
+	idp.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
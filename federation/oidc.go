@@ -0,0 +1,506 @@
+package federation
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/oauth"
+)
+
+const (
+	// maxResponseSize limits what's read of a provider's response
+	maxResponseSize = 1 << 20
+	// clockSkew is allowed between the provider's clock and ours for exp, iat and nbf
+	clockSkew = time.Minute
+	// keysRefreshInterval limits how often an unknown kid refetches the provider's keys, the provider may have rotated them
+	keysRefreshInterval = time.Minute
+)
+
+// idTokenAlgorithms are the asymmetric algorithms accepted, "none" and HMAC with the client secret aren't
+var idTokenAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}
+
+// metadata is what's used of the provider's discovery document
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// provider is a configured provider with its discovery document and keys, both are fetched when they're first needed
+type provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     map[string]crypto.PublicKey
+	// keysFetchedAt is zero until the keys were fetched
+	keysFetchedAt time.Time
+}
+
+func newProvider(config ProviderConfig, client *http.Client) *provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+
+	return &provider{
+		config: config,
+		client: client,
+	}
+}
+
+// get decodes a json response of the provider
+func (p *provider) get(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProviderFailed, err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProviderFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrProviderFailed, endpoint, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s", ErrProviderFailed, err)
+	}
+
+	return nil
+}
+
+// discover returns the provider's discovery document, a failure isn't cached so the next login tries again
+func (p *provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	m := &metadata{}
+	if err := p.get(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", m); err != nil {
+		return nil, err
+	}
+
+	// OpenID Connect Discovery's issuer has to be exactly the configured one, or the document is another provider's
+	if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: discovered issuer %q isn't %q", ErrProviderFailed, m.Issuer, p.config.Issuer)
+	}
+
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing an endpoint", ErrProviderFailed)
+	}
+
+	p.metadata = m
+
+	return m, nil
+}
+
+func (p *provider) authorizeURL(m *metadata, login ddd.FederatedLogin) string {
+	scopes := p.config.Scopes
+	if !containsString(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {oauth.S256Challenge(login.CodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return m.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// tokenResponse is what's used of the token endpoint's response, the access token isn't
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange redeems the code at the provider's token endpoint and verifies its id token
+func (p *provider) exchange(ctx context.Context, code string, login ddd.FederatedLogin) (*idTokenClaims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {login.CodeVerifier},
+	}
+
+	// a public client identifies itself in the form, a confidential one authenticates with client_secret_basic
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProviderFailed, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.config.ClientSecret != "" {
+		// RFC 6749 form-encodes the credentials before they're base64 encoded
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProviderFailed, err)
+	}
+	defer resp.Body.Close()
+
+	token := &tokenResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(token); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProviderFailed, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if token.Error == "invalid_grant" {
+			return nil, ErrInvalidCode
+		}
+
+		return nil, fmt.Errorf("%w: token endpoint returned %d %s %s", ErrProviderFailed, resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: token endpoint didn't return an id token", ErrProviderFailed)
+	}
+
+	return p.verify(ctx, token.IDToken, login.Nonce)
+}
+
+// idTokenClaims are the verified claims of an id token
+type idTokenClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// verify checks the id token's signature with the provider's keys, and its claims with OpenID Connect Core's rules
+func (p *provider) verify(ctx context.Context, idToken string, nonce string) (*idTokenClaims, error) {
+	parser := &jwt.Parser{
+		ValidMethods: idTokenAlgorithms,
+		// the claims are checked below, jwt's checks don't have a leeway
+		SkipClaimsValidation: true,
+	}
+
+	// the keys are fetched outside of the key func, it can't take a context
+	header, err := parseHeader(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := parser.Parse(idToken, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%w: claims aren't an object", ErrInvalidIDToken)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.config.Issuer {
+		return nil, fmt.Errorf("%w: issuer is %q", ErrInvalidIDToken, iss)
+	}
+
+	audience := audienceClaim(claims["aud"])
+	if !containsString(audience, p.config.ClientID) {
+		return nil, fmt.Errorf("%w: audience doesn't have the client", ErrInvalidIDToken)
+	}
+
+	// a token with several audiences is authorized for one of them, it has to be us
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, azp)
+	} else if !ok && len(audience) > 1 {
+		return nil, fmt.Errorf("%w: authorized party is missing", ErrInvalidIDToken)
+	}
+
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%w: token is expired", ErrInvalidIDToken)
+	}
+
+	iat, ok := claims["iat"].(float64)
+	if !ok || now.Add(clockSkew).Before(time.Unix(int64(iat), 0)) {
+		return nil, fmt.Errorf("%w: token was issued in the future", ErrInvalidIDToken)
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: token isn't valid yet", ErrInvalidIDToken)
+	}
+
+	// the nonce ties the id token to the session, a token of another login can't be replayed
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce doesn't match the login session", ErrInvalidIDToken)
+	}
+
+	verified := &idTokenClaims{}
+	verified.Subject, _ = claims["sub"].(string)
+	verified.Email, _ = claims["email"].(string)
+	verified.Name, _ = claims["name"].(string)
+	verified.GivenName, _ = claims["given_name"].(string)
+	verified.FamilyName, _ = claims["family_name"].(string)
+
+	if verified.Subject == "" || len(verified.Subject) > ddd.MaxIdentitySubjectLength {
+		return nil, fmt.Errorf("%w: subject is invalid", ErrInvalidIDToken)
+	}
+
+	// some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		verified.EmailVerified = v
+	case string:
+		verified.EmailVerified = v == "true"
+	}
+
+	return verified, nil
+}
+
+type tokenHeader struct {
+	KeyID string `json:"kid"`
+}
+
+// parseHeader decodes the header of a compact jwt without verifying it
+func parseHeader(token string) (*tokenHeader, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: token isn't a compact jws", ErrInvalidIDToken)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	header := &tokenHeader{}
+	if err := json.Unmarshal(raw, header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	return header, nil
+}
+
+// key returns the provider's key with the kid, the only key when the token doesn't have a kid
+// an unknown kid refetches the keys at most once every keysRefreshInterval
+func (p *provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	if !p.keysFetchedAt.IsZero() && time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	keys, err := p.fetchKeys(ctx, m.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+}
+
+// lookupKey is called with mu held
+func (p *provider) lookupKey(kid string) crypto.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+
+	return p.keys[kid]
+}
+
+// jwk is an RFC 7517 public key, only RSA and EC signing keys are used
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (p *provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := p.get(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		// a key that can't be decoded is skipped, it may be of a type that isn't used
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent is invalid")
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("curve isn't supported: %s", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("ec point isn't on the curve")
+		}
+
+		return key, nil
+	default:
+		return nil, fmt.Errorf("key type isn't supported: %s", k.KeyType)
+	}
+}
+
+// audienceClaim returns aud as a list, it's a string or an array of strings
+func audienceClaim(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		audience := []string{}
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+
+		return audience
+	default:
+		return nil
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
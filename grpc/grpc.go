@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/grpc/pb"
+	"github.com/sabey/ddd/totp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// pb is generated from pb/ddd.proto
+//go:generate protoc -I pb --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative ddd.proto
+
+type ServerOpts struct {
+	UserRepository ddd.UserRepository
+	// UncachedUserRepository is read by the disabled account check of tokens, it's the UserRepository when nil
+	// it mustn't be a cache (cache.NewUserRepository), a disabled account would keep working until its entry expires
+	UncachedUserRepository ddd.UserRepository
+	// TOTP checks the second factor of logins, one with a random key is created when the UserRepository is a ddd.TOTPRepository
+	// it's the HTTP service's, a secret enrolled over HTTP can only be opened with the same key
+	TOTP *totp.Authenticator
+	// Logger writes the access log and handler errors, slog.Default() is used when nil
+	Logger *slog.Logger
+	// ServerOptions are appended after the interceptors, for credentials or message limits
+	ServerOptions []grpc.ServerOption
+}
+
+// NewServer serves the Users service next to the health and reflection services
+// calls are logged, authenticated and their errors mapped to status codes by interceptors
+// deadlines are the client's, they're passed down to the repository
+func NewServer(
+	opts ServerOpts,
+) *grpc.Server {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	if opts.UncachedUserRepository == nil {
+		opts.UncachedUserRepository = opts.UserRepository
+	}
+
+	if opts.TOTP == nil {
+		if totpRepo, ok := opts.UserRepository.(ddd.TOTPRepository); ok {
+			// a random key can only fail to be read from the system
+			a, err := totp.NewAuthenticator(totp.AuthenticatorOpts{Repository: totpRepo})
+			if err != nil {
+				panic(fmt.Sprintf("failed to create totp authenticator: %s", err))
+			}
+			opts.TOTP = a
+		}
+	}
+
+	s := grpc.NewServer(
+		append(
+			[]grpc.ServerOption{
+				// the logger sees the mapped status, and authentication errors are logged too
+				grpc.ChainUnaryInterceptor(
+					logInterceptor(opts.Logger),
+					errorInterceptor,
+					authInterceptor(opts.UncachedUserRepository),
+				),
+			},
+			opts.ServerOptions...,
+		)...,
+	)
+
+	pb.RegisterUsersServer(s, &usersService{
+		userRepo: opts.UserRepository,
+		totp:     opts.TOTP,
+	})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(pb.Users_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	reflection.Register(s)
+
+	return s
+}
@@ -0,0 +1,442 @@
+package grpc
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/grpc/pb"
+	"github.com/sabey/ddd/mock"
+	"github.com/sabey/ddd/totp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newTestServer(t *testing.T) (*mock.UserRepository, *grpc.ClientConn) {
+	t.Helper()
+
+	return newTestServerWithOpts(t, func(*mock.UserRepository) ServerOpts { return ServerOpts{} })
+}
+
+// newTestServerWithOpts serves the seeded mock with the opts, its UserRepository is set to the mock
+func newTestServerWithOpts(t *testing.T, newOpts func(*mock.UserRepository) ServerOpts) (*mock.UserRepository, *grpc.ClientConn) {
+	t.Helper()
+
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{
+			Email:     "jackson@juandefu.ca",
+			FirstName: "Jackson",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+		},
+		ddd.User{
+			Email:     "admin@sabey.co",
+			FirstName: "Admin",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+			Admin:     true,
+		},
+	)
+
+	lis := bufconn.Listen(1 << 20)
+
+	opts := newOpts(mockUsers)
+	opts.UserRepository = mockUsers
+
+	s := NewServer(opts)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return mockUsers, conn
+}
+
+func withToken(email string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), tokenKey, ddd.SignJWTClaims(email))
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if status.Code(err) != code {
+		t.Fatalf("expected %s, got: %v", code, err)
+	}
+}
+
+func TestSignupLogin(t *testing.T) {
+	_, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+	ctx := context.Background()
+
+	resp, err := users.Signup(ctx, &pb.SignupRequest{
+		Email:     "new@sabey.co",
+		FirstName: "New",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	if err != nil {
+		t.Fatalf("failed to signup: %s", err)
+	}
+
+	if email := ddd.ParseJWTClaims(resp.Token); email != "new@sabey.co" {
+		t.Fatalf("unexpected token claim: %q", email)
+	}
+
+	_, err = users.Signup(ctx, &pb.SignupRequest{
+		Email:     "new@sabey.co",
+		FirstName: "New",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	assertCode(t, err, codes.AlreadyExists)
+
+	_, err = users.Signup(ctx, &pb.SignupRequest{Email: "empty@sabey.co"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	resp, err = users.Login(ctx, &pb.LoginRequest{Email: "new@sabey.co", Password: "pass"})
+	if err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if email := ddd.ParseJWTClaims(resp.Token); email != "new@sabey.co" {
+		t.Fatalf("unexpected token claim: %q", email)
+	}
+
+	_, err = users.Login(ctx, &pb.LoginRequest{Email: "new@sabey.co", Password: "wrong"})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = users.Login(ctx, &pb.LoginRequest{Email: "missing@sabey.co", Password: "pass"})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestAuthentication(t *testing.T) {
+	_, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+
+	_, err := users.ListUsers(context.Background(), &pb.ListUsersRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), tokenKey, "invalid")
+	_, err = users.ListUsers(ctx, &pb.ListUsersRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	if _, err := users.ListUsers(withToken("jackson@juandefu.ca"), &pb.ListUsersRequest{}); err != nil {
+		t.Fatalf("failed to list users: %s", err)
+	}
+}
+
+func TestAuthentication_Disabled(t *testing.T) {
+	mockUsers, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+
+	// the token was given before the account was disabled
+	ctx := withToken("jackson@juandefu.ca")
+
+	if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(true)}); err != nil {
+		t.Fatalf("failed to disable user: %s", err)
+	}
+
+	_, err := users.ListUsers(ctx, &pb.ListUsersRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+}
+
+func TestOrganizations(t *testing.T) {
+	mockUsers, conn := newTestServer(t)
+	mockUsers.Seed(ddd.User{
+		Organization: "acme",
+		Email:        "jackson@acme.co",
+		FirstName:    "Jackson",
+		LastName:     "Sabey",
+		Password:     ddd.HashPassword("pass"),
+	})
+
+	users := pb.NewUsersClient(conn)
+
+	_, err := users.Login(context.Background(), &pb.LoginRequest{Email: "jackson@acme.co", Password: "pass"})
+	assertCode(t, err, codes.NotFound)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), organizationKey, "Acme")
+	_, err = users.Login(ctx, &pb.LoginRequest{Email: "jackson@acme.co", Password: "pass"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), organizationKey, "acme")
+	resp, err := users.Login(ctx, &pb.LoginRequest{Email: "jackson@acme.co", Password: "pass"})
+	if err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if tenant, email := ddd.ParseTenantJWTClaims(resp.Token); tenant != "acme" || email != "jackson@acme.co" {
+		t.Fatalf("unknown token: %s", resp.Token)
+	}
+
+	// the token only lists its organization's accounts
+	list, err := users.ListUsers(metadata.AppendToOutgoingContext(context.Background(), tokenKey, resp.Token), &pb.ListUsersRequest{})
+	if err != nil {
+		t.Fatalf("failed to list users: %s", err)
+	}
+
+	if len(list.Users) != 1 || list.Users[0].Email != "jackson@acme.co" {
+		t.Errorf("unexpected users: %v", list)
+	}
+}
+
+func TestLogin_TOTP(t *testing.T) {
+	now := time.Now()
+
+	var a *totp.Authenticator
+	_, conn := newTestServerWithOpts(t, func(mockUsers *mock.UserRepository) ServerOpts {
+		var err error
+		a, err = totp.NewAuthenticator(totp.AuthenticatorOpts{
+			Repository: mockUsers,
+			Now:        func() time.Time { return now },
+		})
+		if err != nil {
+			t.Fatalf("failed to create authenticator: %s", err)
+		}
+
+		return ServerOpts{TOTP: a}
+	})
+
+	ctx := context.Background()
+
+	enrollment, err := a.Enroll(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+
+	recoveryCodes, err := a.Confirm(ctx, "jackson@juandefu.ca", totp.Code(secret, totp.Step(now)))
+	if err != nil {
+		t.Fatalf("failed to confirm: %s", err)
+	}
+
+	users := pb.NewUsersClient(conn)
+	login := &pb.LoginRequest{Email: "jackson@juandefu.ca", Password: "pass"}
+
+	_, err = users.Login(ctx, login)
+	assertCode(t, err, codes.Unauthenticated)
+
+	// the confirming code was used
+	_, err = users.Login(metadata.AppendToOutgoingContext(ctx, mfaCodeKey, totp.Code(secret, totp.Step(now))), login)
+	assertCode(t, err, codes.Unauthenticated)
+
+	now = now.Add(totp.Period)
+
+	if _, err := users.Login(metadata.AppendToOutgoingContext(ctx, mfaCodeKey, totp.Code(secret, totp.Step(now))), login); err != nil {
+		t.Errorf("failed to login with a code: %s", err)
+	}
+
+	if _, err := users.Login(metadata.AppendToOutgoingContext(ctx, recoveryCodeKey, recoveryCodes[0]), login); err != nil {
+		t.Errorf("failed to login with a recovery code: %s", err)
+	}
+
+	_, err = users.Login(metadata.AppendToOutgoingContext(ctx, recoveryCodeKey, recoveryCodes[0]), login)
+	assertCode(t, err, codes.Unauthenticated)
+}
+
+func TestListUsers_Pages(t *testing.T) {
+	_, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+	ctx := withToken("jackson@juandefu.ca")
+
+	resp, err := users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1})
+	if err != nil {
+		t.Fatalf("failed to list users: %s", err)
+	}
+
+	if len(resp.Users) != 1 || resp.Users[0].Email != "admin@sabey.co" || resp.NextPageToken == "" {
+		t.Fatalf("unexpected first page: %v", resp)
+	}
+
+	resp, err = users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1, PageToken: resp.NextPageToken})
+	if err != nil {
+		t.Fatalf("failed to list users: %s", err)
+	}
+
+	if len(resp.Users) != 1 || resp.Users[0].Email != "jackson@juandefu.ca" {
+		t.Fatalf("unexpected second page: %v", resp)
+	}
+
+	resp, err = users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1, PageToken: resp.NextPageToken})
+	if err != nil {
+		t.Fatalf("failed to list users: %s", err)
+	}
+
+	if len(resp.Users) != 0 || resp.NextPageToken != "" {
+		t.Fatalf("unexpected last page: %v", resp)
+	}
+
+	_, err = users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1001})
+	assertCode(t, err, codes.InvalidArgument)
+
+	_, err = users.ListUsers(ctx, &pb.ListUsersRequest{PageToken: "@@@"})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestUpdateUser(t *testing.T) {
+	mockUsers, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+	ctx := withToken("jackson@juandefu.ca")
+
+	user, err := users.UpdateUser(ctx, &pb.UpdateUserRequest{
+		LastName:    ddd.String("SABEY"),
+		DisplayName: ddd.String("Jack"),
+		Version:     1,
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %s", err)
+	}
+
+	if user.LastName != "SABEY" || user.DisplayName != "Jack" || user.FirstName != "Jackson" || user.Version != 2 {
+		t.Fatalf("unexpected user: %v", user)
+	}
+
+	// the version changed
+	_, err = users.UpdateUser(ctx, &pb.UpdateUserRequest{LastName: ddd.String("Sabey"), Version: 1})
+	assertCode(t, err, codes.Aborted)
+
+	_, err = users.UpdateUser(ctx, &pb.UpdateUserRequest{LastName: ddd.String("")})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// attributes aren't registered
+	attrs, _ := structpb.NewStruct(map[string]interface{}{"team": "core"})
+	_, err = users.UpdateUser(ctx, &pb.UpdateUserRequest{Attributes: attrs})
+	assertCode(t, err, codes.InvalidArgument)
+
+	// nothing to update returns the current account
+	user, err = users.UpdateUser(ctx, &pb.UpdateUserRequest{})
+	if err != nil {
+		t.Fatalf("failed to update user: %s", err)
+	}
+
+	if user.Version != 2 {
+		t.Fatalf("unexpected version: %d", user.Version)
+	}
+
+	if stored, _ := mockUsers.User("jackson@juandefu.ca"); stored.LastName != "SABEY" {
+		t.Fatalf("unexpected stored last name: %s", stored.LastName)
+	}
+}
+
+func TestUpdateUser_Admin(t *testing.T) {
+	mockUsers, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+
+	_, err := users.UpdateUser(withToken("jackson@juandefu.ca"), &pb.UpdateUserRequest{
+		Email:     "admin@sabey.co",
+		FirstName: ddd.String("Jackson"),
+	})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = users.UpdateUser(withToken("admin@sabey.co"), &pb.UpdateUserRequest{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %s", err)
+	}
+
+	if stored, _ := mockUsers.User("jackson@juandefu.ca"); stored.FirstName != "JACKSON" {
+		t.Fatalf("unexpected stored first name: %s", stored.FirstName)
+	}
+
+	_, err = users.UpdateUser(withToken("admin@sabey.co"), &pb.UpdateUserRequest{
+		Email:     "missing@sabey.co",
+		FirstName: ddd.String("Missing"),
+	})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestErrors(t *testing.T) {
+	mockUsers, conn := newTestServer(t)
+	users := pb.NewUsersClient(conn)
+	ctx := withToken("jackson@juandefu.ca")
+
+	mockUsers.Inject(mock.MethodList, mock.Fault{Err: errors.New("connection refused"), Times: 1})
+	_, err := users.ListUsers(ctx, &pb.ListUsersRequest{})
+	assertCode(t, err, codes.Internal)
+
+	// the repository's error isn't leaked
+	if status.Convert(err).Message() != "internal error" {
+		t.Fatalf("unexpected message: %s", status.Convert(err).Message())
+	}
+
+	mockUsers.Inject(mock.MethodList, mock.Fault{Latency: time.Second, Times: 1})
+	deadline, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	_, err = users.ListUsers(deadline, &pb.ListUsersRequest{})
+	assertCode(t, err, codes.DeadlineExceeded)
+}
+
+func TestHealth(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{
+		Service: pb.Users_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		t.Fatalf("failed to check health: %s", err)
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+}
+
+func TestReflection(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open reflection stream: %s", err)
+	}
+	defer stream.CloseSend()
+
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatalf("failed to send reflection request: %s", err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive reflection response: %s", err)
+	}
+
+	found := false
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		if service.Name == pb.Users_ServiceDesc.ServiceName {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("users service isn't listed: %v", resp)
+	}
+}
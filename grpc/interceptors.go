@@ -0,0 +1,210 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/grpc/pb"
+	"github.com/sabey/ddd/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// tokenKey is the metadata the jwt is sent in, the same name as the HTTP header
+	tokenKey     = "x-authentication-token"
+	requestIDKey = "x-request-id"
+	// organizationKey is the organization a login is for, there's no field for it in LoginRequest
+	organizationKey = "x-organization"
+	// mfaCodeKey and recoveryCodeKey are a login's second factor, they're only needed when the account has two-factor authentication
+	mfaCodeKey      = "x-mfa-code"
+	recoveryCodeKey = "x-recovery-code"
+)
+
+// publicMethods don't need a token, methods of the other services (health, reflection) never do
+var publicMethods = map[string]bool{
+	pb.Users_Signup_FullMethodName: true,
+	pb.Users_Login_FullMethodName:  true,
+}
+
+// callInfo is filled in while a call is handled, and read back by the access log
+type callInfo struct {
+	// user is the authenticated account, empty for anonymous calls
+	user         string
+	organization string
+}
+
+type callInfoKey struct{}
+
+func callInfoFromContext(ctx context.Context) *callInfo {
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		return info
+	}
+
+	return &callInfo{}
+}
+
+// setUser records the authenticated user for the access log
+func setUser(ctx context.Context, organization string, email string) {
+	ci := callInfoFromContext(ctx)
+	ci.organization = organization
+	ci.user = email
+}
+
+// userFromContext is the email authInterceptor read from the token
+func userFromContext(ctx context.Context) string {
+	return callInfoFromContext(ctx).user
+}
+
+// logInterceptor writes one line per call, with the request id of the caller's x-request-id metadata or a generated one
+func logInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		ci := &callInfo{}
+		ctx = context.WithValue(ctx, callInfoKey{}, ci)
+
+		id := requestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+
+		logger := log.With("request_id", id)
+		ctx = logging.ContextWithLogger(ctx, logger)
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+
+		level := slog.LevelInfo
+		switch code {
+		case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+			level = slog.LevelError
+		}
+
+		var addr string
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+		}
+
+		logger.LogAttrs(ctx, level, "grpc request",
+			slog.String("method", info.FullMethod),
+			slog.String("code", code.String()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", addr),
+			slog.String("user_organization", ci.organization),
+			slog.String("user_email", ci.user),
+		)
+
+		return resp, err
+	}
+}
+
+// requestID propagates the caller's x-request-id, or generates a new one when it's missing or unsafe to log
+func requestID(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(values) > 0 && validRequestID(values[0]) {
+		return values[0]
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		// printable ascii without quotes or spaces
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// authInterceptor checks the token of every Users call except signup and login, and that its account wasn't disabled since
+func authInterceptor(users ddd.UserRepository) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if publicMethods[info.FullMethod] || !strings.HasPrefix(info.FullMethod, "/"+pb.Users_ServiceDesc.ServiceName+"/") {
+			return handler(ctx, req)
+		}
+
+		values := metadata.ValueFromIncomingContext(ctx, tokenKey)
+		if len(values) == 0 || values[0] == "" {
+			return nil, status.Error(codes.Unauthenticated, "jwt not found")
+		}
+
+		tenant, email := ddd.ParseTenantJWTClaims(values[0])
+		if email == "" {
+			return nil, status.Error(codes.Unauthenticated, "invalid jwt")
+		}
+
+		setUser(ctx, tenant, email)
+
+		ctx = ddd.WithTenant(ctx, tenant)
+
+		// tokens don't expire, an account that doesn't exist is left to the handler
+		user, err := users.Get(ctx, email)
+		if err != nil && !errors.Is(err, ddd.ErrUserNotFound) {
+			return nil, err
+		}
+
+		if err == nil && user.Disabled {
+			return nil, ddd.ErrUserDisabled
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// errorInterceptor maps the repository's errors to status codes, errors that are already a status are returned as they are
+// anything else is logged and hidden behind INTERNAL
+func errorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return resp, err
+	}
+
+	return resp, statusError(ctx, err)
+}
+
+func statusError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "request timed out")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "request was cancelled")
+	case errors.Is(err, ddd.ErrUserExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ddd.ErrUserNotFound), errors.Is(err, ddd.ErrOrganizationNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ddd.ErrInvalidPassword), errors.Is(err, ddd.ErrInvalidTOTPCode):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ddd.ErrUserDisabled):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ddd.ErrTOTPLocked):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ddd.ErrVersionMismatch):
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	logging.FromContext(ctx).Error("grpc call failed", "error", err)
+
+	return status.Error(codes.Internal, "internal error")
+}
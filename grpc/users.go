@@ -0,0 +1,252 @@
+package grpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/grpc/pb"
+	"github.com/sabey/ddd/logging"
+	"github.com/sabey/ddd/totp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// maxPageSize bounds a page the same way the HTTP API does
+const maxPageSize = 1000
+
+type usersService struct {
+	pb.UnimplementedUsersServer
+
+	userRepo ddd.UserRepository
+	// totp is nil when logins only need a password
+	totp *totp.Authenticator
+}
+
+func (s *usersService) Signup(ctx context.Context, req *pb.SignupRequest) (*pb.TokenResponse, error) {
+	opts := ddd.UserCreate{
+		Email:     req.Email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Password:  req.Password,
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	opts.Password = ddd.HashPassword(opts.Password)
+
+	// accounts of the other organizations are invited, they don't sign up
+	user, err := s.userRepo.Create(ddd.WithTenant(ctx, ddd.DefaultOrganization), opts)
+	if err != nil {
+		logging.FromContext(ctx).Warn("signup failed", "email", req.Email, "error", err)
+
+		return nil, err
+	}
+
+	setUser(ctx, user.Organization, user.Email)
+
+	return &pb.TokenResponse{
+		Token: ddd.SignTenantJWTClaims(user.Organization, user.Email),
+	}, nil
+}
+
+func (s *usersService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.TokenResponse, error) {
+	opts := ddd.UserLogin{
+		Email:    req.Email,
+		Password: req.Password,
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	tenant := ddd.DefaultOrganization
+	if values := metadata.ValueFromIncomingContext(ctx, organizationKey); len(values) > 0 && values[0] != "" {
+		if err := ddd.ValidateOrganizationID(values[0]); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		tenant = values[0]
+	}
+
+	opts.Password = ddd.HashPassword(opts.Password)
+
+	user, err := s.userRepo.Login(ddd.WithTenant(ctx, tenant), opts)
+	if err != nil {
+		logging.FromContext(ctx).Warn("login failed", "email", req.Email, "error", err)
+
+		return nil, err
+	}
+
+	if err := s.verifyLogin(ddd.WithTenant(ctx, tenant), user.Email); err != nil {
+		logging.FromContext(ctx).Warn("login failed", "email", req.Email, "error", err)
+
+		return nil, err
+	}
+
+	setUser(ctx, user.Organization, user.Email)
+
+	return &pb.TokenResponse{
+		Token: ddd.SignTenantJWTClaims(user.Organization, user.Email),
+	}, nil
+}
+
+// verifyLogin checks the second factor of an account with two-factor authentication, LoginRequest has no field for it so it's sent as metadata
+func (s *usersService) verifyLogin(ctx context.Context, email string) error {
+	if s.totp == nil {
+		return nil
+	}
+
+	required, err := s.totp.Required(ctx, email)
+	if err != nil || !required {
+		return err
+	}
+
+	var code, recoveryCode string
+	if values := metadata.ValueFromIncomingContext(ctx, mfaCodeKey); len(values) > 0 {
+		code = values[0]
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, recoveryCodeKey); len(values) > 0 {
+		recoveryCode = values[0]
+	}
+
+	if code == "" && recoveryCode == "" {
+		return status.Error(codes.Unauthenticated, "two-factor code is required")
+	}
+
+	return s.totp.Verify(ctx, email, code, recoveryCode)
+}
+
+func (s *usersService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	if req.PageSize < 0 || req.PageSize > maxPageSize {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("page_size must be between 1 and %d", maxPageSize))
+	}
+
+	opts := ddd.UserList{
+		Limit: int(req.PageSize),
+	}
+
+	// page tokens are the HTTP API's cursors, they can be used with either
+	if req.PageToken != "" {
+		after, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		if err != nil || len(after) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "page_token was invalid")
+		}
+
+		opts.After = string(after)
+	}
+
+	users, err := s.userRepo.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListUsersResponse{}
+	for _, user := range users {
+		u, err := newUser(user)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Users = append(resp.Users, u)
+	}
+
+	if opts.Limit > 0 && len(users) == opts.Limit {
+		resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(users[len(users)-1].Email))
+	}
+
+	return resp, nil
+}
+
+func (s *usersService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	email := userFromContext(ctx)
+
+	// another account is only updated by an admin
+	if req.Email != "" && req.Email != email {
+		admin, err := s.userRepo.Get(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+
+		if !admin.Admin {
+			return nil, status.Error(codes.PermissionDenied, "admin required")
+		}
+
+		email = req.Email
+	}
+
+	opts := ddd.UserUpdate{
+		Email:       email,
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		DisplayName: req.DisplayName,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+		Attributes:  req.Attributes.AsMap(),
+		Version:     req.Version,
+	}
+
+	if len(opts.Attributes) == 0 {
+		opts.Attributes = nil
+	}
+
+	var user *ddd.User
+	var err error
+
+	if opts.Empty() {
+		// like an empty merge patch, nothing changes and the current account is returned
+		user, err = s.userRepo.Get(ctx, email)
+		if err == nil && opts.Version > 0 && user.Version != opts.Version {
+			err = ddd.ErrVersionMismatch
+		}
+	} else {
+		if err := opts.Validate(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		user, err = s.userRepo.Update(ctx, opts)
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to update user", "email", email, "error", err)
+
+		return nil, err
+	}
+
+	return newUser(user)
+}
+
+func newUser(user *ddd.User) (*pb.User, error) {
+	u := &pb.User{
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Version:     user.Version,
+	}
+
+	if user.Avatar != "" {
+		u.AvatarUrl = "/users/" + url.PathEscape(user.Email) + "/avatar?v=" + user.Avatar
+	}
+
+	if len(user.Attributes) > 0 {
+		attrs, err := structpb.NewStruct(user.Attributes)
+		if err != nil {
+			// attributes are validated before they're stored, they're always json values
+			return nil, errors.New("failed to encode attributes: " + err.Error())
+		}
+
+		u.Attributes = attrs
+	}
+
+	return u, nil
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/apikey"
+)
+
+// apiKeyAuth is the outcome of the request's api key, it's checked once in ServeHTTP so the tenant is known before routing
+type apiKeyAuth struct {
+	key *ddd.APIKey
+	err error
+}
+
+type apiKeyAuthKey struct{}
+
+// apiKeyAuthFrom is nil when the request wasn't sent with an api key
+func apiKeyAuthFrom(ctx context.Context) *apiKeyAuth {
+	auth, _ := ctx.Value(apiKeyAuthKey{}).(*apiKeyAuth)
+
+	return auth
+}
+
+// rejectAPIKey writes a 403 for a request sent with an api key, the routes that change how the account logs in need a login's token
+// it returns true when the request was rejected
+func rejectAPIKey(w http.ResponseWriter, r *http.Request, action string) bool {
+	if apiKeyAuthFrom(r.Context()) == nil {
+		return false
+	}
+
+	// 403
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, `{"error":"api keys can't %s"}`, action)
+
+	return true
+}
+
+// remoteIP is the address of the connection, forwarding headers aren't trusted
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// apiKeyScope is the scope a request needs, reads only need read
+func apiKeyScope(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return ddd.APIKeyScopeRead
+	}
+
+	return ddd.APIKeyScopeWrite
+}
+
+// authenticateAPIKey is authenticate for a request sent with an api key
+func (srv httpService) authenticateAPIKey(w http.ResponseWriter, r *http.Request, auth *apiKeyAuth) string {
+	if errors.Is(auth.err, apikey.ErrInvalidKey) {
+		srv.metrics.tokenFailures.With("invalid").Inc()
+
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid api key"}`)
+
+		return ""
+	}
+
+	if auth.err != nil {
+		srv.logger(r).Warn("failed to authenticate api key", "error", auth.err)
+
+		writeRepositoryError(w, auth.err)
+
+		return ""
+	}
+
+	if scope := apiKeyScope(r.Method); !auth.key.HasScope(scope) {
+		// 403
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error":"api key doesn't have the %s scope"}`, scope)
+
+		return ""
+	}
+
+	srv.setUser(r, auth.key.Organization, auth.key.Email)
+
+	return auth.key.Email
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"name": "Deploys","scopes": ["read","write"],"expiresAt": "2027-01-01T00:00:00Z"}' \
+  http://localhost:8080/users/me/tokens
+*/
+
+// CreateAPIKey responds with a new api key of the account, the key is only sent this once
+// an api key can't create another one, a leaked key can't outlive its own deletion or expiry
+func (srv httpService) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if srv.apiKeys == nil {
+		writeAPIKeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "create api keys") {
+		return
+	}
+
+	request := &APIKeyRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	opts := ddd.APIKeyCreate{
+		Organization: ddd.TenantFrom(r.Context()),
+		Email:        email,
+		Name:         request.Name,
+		Scopes:       request.Scopes,
+		ExpiresAt:    request.ExpiresAt,
+	}
+
+	if err := opts.Validate(); err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	key, err := srv.apiKeys.Create(r.Context(), opts)
+	if err != nil {
+		srv.logger(r).Warn("failed to create api key", "email", email, "error", err)
+
+		writeAPIKeyError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("created api key", "email", email, "id", key.ID)
+
+	bs, _ := json.Marshal(newAPIKeyResponse(key))
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  http://localhost:8080/users/me/tokens
+*/
+
+// ListAPIKeys responds with the account's api keys and when they were last used, without the keys themselves
+func (srv httpService) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if srv.apiKeys == nil {
+		writeAPIKeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	keys, err := srv.apiKeys.List(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to list api keys", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	response := APIKeysResponse{
+		Keys: []APIKeyResponse{},
+	}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, newAPIKeyResponse(key))
+	}
+
+	bs, _ := json.Marshal(response)
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  --request DELETE \
+  http://localhost:8080/users/me/tokens/ddd_0123456789abcdef
+*/
+
+// DeleteAPIKey revokes one of the account's api keys by its id
+func (srv httpService) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	if srv.apiKeys == nil {
+		writeAPIKeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/users/me/tokens/")
+	if id == "" {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid api key id"}`)
+
+		return
+	}
+
+	if err := srv.apiKeys.Delete(r.Context(), email, id); err != nil {
+		srv.logger(r).Warn("failed to delete api key", "email", email, "error", err)
+
+		writeAPIKeyError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("deleted api key", "email", email, "id", id)
+
+	// 204
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newAPIKeyResponse(key *ddd.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		LastUsedIP: key.LastUsedIP,
+		Key:        key.Key,
+	}
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ddd.ErrAPIKeyNotFound):
+		// 404
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, apikey.ErrAdminScope):
+		// 403
+		writeError(w, http.StatusForbidden, err)
+	default:
+		writeRepositoryError(w, err)
+	}
+}
+
+func writeAPIKeysUnavailable(w http.ResponseWriter) {
+	// 501
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, `{"error":"api keys aren't available"}`)
+}
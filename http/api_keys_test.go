@@ -0,0 +1,284 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/apikey"
+	"github.com/sabey/ddd/cache"
+	"github.com/sabey/ddd/mock"
+)
+
+// createAPIKey creates a key with a jwt and returns it
+func createAPIKey(t *testing.T, ts *httptest.Server, email, request string) APIKeyResponse {
+	t.Helper()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/tokens", ddd.SignJWTClaims(email), request)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	key := APIKeyResponse{}
+	if err := json.Unmarshal([]byte(body), &key); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	return key
+}
+
+func TestAPIKeys(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	created := createAPIKey(t, ts, "jackson@juandefu.ca", `{"name":"Deploys","scopes":["read","write"],"expiresAt":"2099-01-01T00:00:00Z"}`)
+	if ddd.APIKeyID(created.Key) != created.ID || created.Name != "Deploys" || created.ExpiresAt == nil || created.LastUsedAt != nil {
+		t.Errorf("unknown api key: %+v", created)
+	}
+
+	// the key authenticates like a jwt
+	resp, body := sendRequest(t, "GET", ts.URL+"/users/me", created.Key, "")
+	if resp.StatusCode != 200 || !strings.Contains(body, `"email":"jackson@juandefu.ca"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "PUT", ts.URL+"/users", created.Key, `{"firstName":"Jackson","lastName":"SABEY"}`)
+	if resp.StatusCode != 204 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me/tokens", ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	keys := APIKeysResponse{}
+	if err := json.Unmarshal([]byte(body), &keys); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	// the key itself is only sent once, its last use is recorded
+	if len(keys.Keys) != 1 || keys.Keys[0].ID != created.ID || keys.Keys[0].Key != "" ||
+		keys.Keys[0].LastUsedAt == nil || keys.Keys[0].LastUsedIP != "127.0.0.1" {
+		t.Errorf("unknown api keys: `%s`", body)
+	}
+
+	// a key can delete itself
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/users/me/tokens/"+created.ID, created.Key, "")
+	if resp.StatusCode != 204 || body != "" {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", created.Key, "")
+	if resp.StatusCode != 400 || body != `{"error":"invalid api key"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/users/me/tokens/"+created.ID, ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 404 || body != fmt.Sprintf(`{"error":"%s"}`, ddd.ErrAPIKeyNotFound) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodTouchAPIKey); len(calls) != 1 {
+		t.Errorf("expected one touch, got: %v", calls)
+	}
+}
+
+func TestAPIKeys_Create(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	jwt := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+	for _, request := range []string{
+		`{"scopes":["read"]}`,
+		`{"name":"Deploys"}`,
+		`{"name":"Deploys","scopes":["delete"]}`,
+		`{"name":"Deploys","scopes":["read","read"]}`,
+		`{"name":"Deploys","scopes":["read"],"expiresAt":"2000-01-01T00:00:00Z"}`,
+		`{"name":"Deploys","scopes":["read"],"expiresAt":"tomorrow"}`,
+	} {
+		resp, body := sendRequest(t, "POST", ts.URL+"/users/me/tokens", jwt, request)
+		if resp.StatusCode != 400 {
+			t.Errorf("%s: unknown response: %d `%s`", request, resp.StatusCode, body)
+		}
+	}
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/tokens", jwt, `{"name":"Deploys","scopes":["admin"]}`)
+	if resp.StatusCode != 403 || body != fmt.Sprintf(`{"error":"%s"}`, apikey.ErrAdminScope) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// a leaked key can't create another one
+	created := createAPIKey(t, ts, "jackson@juandefu.ca", `{"name":"Deploys","scopes":["read","write"]}`)
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/users/me/tokens", created.Key, `{"name":"Deploys","scopes":["read"]}`)
+	if resp.StatusCode != 403 || body != `{"error":"api keys can't create api keys"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestAPIKeys_AccountRoutes(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	// a leaked key can't add a way to log in, or read how the account logs in
+	created := createAPIKey(t, ts, "jackson@juandefu.ca", `{"name":"Deploys","scopes":["read","write"]}`)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+		err    string
+	}{
+		{"POST", "/users/me/passkeys/register/options", "", "api keys can't register passkeys"},
+		{"POST", "/users/me/passkeys/register", `{"session":"session","credential":{}}`, "api keys can't register passkeys"},
+		{"DELETE", "/users/me/passkeys/Y3JlZGVudGlhbA", "", "api keys can't delete passkeys"},
+		{"POST", "/users/me/2fa/totp", "", "api keys can't enroll two-factor authentication"},
+		{"POST", "/users/me/2fa/totp/confirm", `{"code":"123456"}`, "api keys can't enroll two-factor authentication"},
+	} {
+		resp, body := sendRequest(t, tt.method, ts.URL+tt.path, created.Key, tt.body)
+		if resp.StatusCode != 403 || body != fmt.Sprintf(`{"error":"%s"}`, tt.err) {
+			t.Errorf("%s %s: unknown response: %d `%s`", tt.method, tt.path, resp.StatusCode, body)
+		}
+	}
+
+	if calls := mockUsers.Calls(mock.MethodEnrollTOTP); len(calls) != 0 {
+		t.Errorf("an api key enrolled: %v", calls)
+	}
+
+	_, _, fts := newFederationServer(t)
+
+	created = createAPIKey(t, fts, "jackson@juandefu.ca", `{"name":"Deploys","scopes":["read"]}`)
+
+	resp, body := sendRequest(t, "GET", fts.URL+"/users/me/identities", created.Key, "")
+	if resp.StatusCode != 403 || body != `{"error":"api keys can't list identities"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestAPIKeys_Scopes(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	read := createAPIKey(t, ts, "jackson@juandefu.ca", `{"name":"Reports","scopes":["read"]}`)
+
+	resp, body := sendRequest(t, "PUT", ts.URL+"/users", read.Key, `{"firstName":"Jackson","lastName":"SABEY"}`)
+	if resp.StatusCode != 403 || body != `{"error":"api key doesn't have the write scope"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/graphql", read.Key, `{"query":"{ me { email } }"}`)
+	if resp.StatusCode != 200 || body != `{"data":{"me":{"email":"jackson@juandefu.ca"}}}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/graphql", read.Key, `{"query":"mutation { updateProfile(input: {lastName: \"SABEY\"}) { email } }"}`)
+	if resp.StatusCode != 200 || !strings.Contains(body, `"code":"FORBIDDEN"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.LastName != "Sabey" {
+		t.Errorf("a read key updated the profile: %+v", user)
+	}
+
+	// an admin's key needs the admin scope for the admin routes
+	admin := createAPIKey(t, ts, "admin@sabey.co", `{"name":"Backups","scopes":["read"]}`)
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/export", admin.Key, "")
+	if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	admin = createAPIKey(t, ts, "admin@sabey.co", `{"name":"Backups","scopes":["read","admin"]}`)
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/export", admin.Key, "")
+	if resp.StatusCode != 200 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestAPIKeys_Disabled(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	created := createAPIKey(t, ts, "jackson@juandefu.ca", `{"name":"Deploys","scopes":["read"]}`)
+
+	if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(true)}); err != nil {
+		t.Fatalf("failed to disable user: %s", err)
+	}
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/users/me", created.Key, "")
+	if resp.StatusCode != 403 || body != fmt.Sprintf(`{"error":"%s"}`, ddd.ErrUserDisabled) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestAPIKeys_Cached(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{Email: "jackson@juandefu.ca", Password: ddd.HashPassword("pass")},
+		ddd.User{Email: "admin@sabey.co", Password: ddd.HashPassword("pass"), Admin: true},
+	)
+
+	keyring, err := apikey.NewKeyring(apikey.KeyringOpts{Repository: mockUsers, Users: mockUsers})
+	if err != nil {
+		t.Fatalf("failed to create keyring: %s", err)
+	}
+
+	ts := httptest.NewServer(NewHTTPServiceWithOpts(HTTPServiceOpts{
+		UserRepository:         cache.NewUserRepository(mockUsers, cache.Opts{}),
+		UncachedUserRepository: mockUsers,
+		APIKeys:                keyring,
+	}))
+	defer ts.Close()
+
+	admin := createAPIKey(t, ts, "admin@sabey.co", `{"name":"Backups","scopes":["read","admin"]}`)
+	created := createAPIKey(t, ts, "jackson@juandefu.ca", `{"name":"Deploys","scopes":["read"]}`)
+
+	// the accounts are cached, then changed without the cache being told
+	for _, key := range []string{admin.Key, created.Key} {
+		if resp, body := sendRequest(t, "GET", ts.URL+"/users/me", key, ""); resp.StatusCode != 200 {
+			t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+		}
+	}
+
+	mockUsers.Seed(ddd.User{Email: "admin@sabey.co", Password: ddd.HashPassword("pass")})
+
+	if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(true)}); err != nil {
+		t.Fatalf("failed to disable user: %s", err)
+	}
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/users/export", admin.Key, "")
+	if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+		t.Errorf("a demoted admin's key was served from the cache: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", created.Key, "")
+	if resp.StatusCode != 403 || body != fmt.Sprintf(`{"error":"%s"}`, ddd.ErrUserDisabled) {
+		t.Errorf("a disabled account's key was served from the cache: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestAPIKeys_Unavailable(t *testing.T) {
+	mockUsers, _ := newSeededServer()
+
+	// the embedded interface hides the mock's APIKeyRepository methods
+	ts := httptest.NewServer(NewHTTPService(struct{ ddd.UserRepository }{mockUsers}))
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/users/me/tokens", ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 501 || body != `{"error":"api keys aren't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// without a keyring a key is just an invalid jwt
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", "ddd_0123456789abcdef_c2VjcmV0", "")
+	if resp.StatusCode != 400 || body != `{"error":"invalid jwt"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,298 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/avatar"
+)
+
+/*
+curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: image/png" \
+  --request PUT \
+  --data-binary @avatar.png \
+  http://localhost:8080/users/me/avatar
+
+curl --header "X-Authentication-Token: jwt-token" \
+  --request PUT \
+  --form avatar=@avatar.jpg \
+  http://localhost:8080/users/me/avatar
+*/
+
+// avatarField is the multipart form field the image is read from
+const avatarField = "avatar"
+
+var (
+	errAvatarMissing     = errors.New("avatar was empty")
+	errAvatarContentType = errors.New("avatar must be uploaded as image/png, image/jpeg, image/gif or multipart/form-data")
+)
+
+func (srv httpService) PutAvatar(w http.ResponseWriter, r *http.Request) {
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	defer r.Body.Close()
+
+	// If-Match is optional, without it the last write wins
+	version, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		writeRepositoryError(w, ddd.ErrVersionMismatch)
+
+		return
+	}
+
+	// the multipart envelope is allowed a little on top of the image
+	r.Body = http.MaxBytesReader(w, r.Body, avatar.MaxBytes+4096)
+
+	data, err := readAvatar(r)
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || len(data) > avatar.MaxBytes {
+		// 413
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("avatar is larger than %d bytes", avatar.MaxBytes))
+
+		return
+	}
+
+	if err == errAvatarContentType {
+		// 415
+		writeError(w, http.StatusUnsupportedMediaType, err)
+
+		return
+	}
+
+	if err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	span := srv.startSpan(r, "avatar.Process")
+	processed, err := avatar.Process(data)
+	span.End()
+
+	if err == avatar.ErrUnsupported {
+		// 415
+		writeError(w, http.StatusUnsupportedMediaType, err)
+
+		return
+	}
+
+	if err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	// the previous avatar is removed once the new one is saved
+	previous, err := srv.userRepo.Get(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to get user", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	if err := avatar.Put(r.Context(), srv.blobs, processed); err != nil {
+		srv.logger(r).Error("failed to store avatar", "email", email, "error", err)
+
+		// 500
+		writeError(w, http.StatusInternalServerError, errors.New("failed to store avatar"))
+
+		return
+	}
+
+	user, err := srv.userRepo.Update(
+		r.Context(),
+		ddd.UserUpdate{
+			Email:   email,
+			Avatar:  ddd.String(processed.Hash),
+			Version: version,
+		},
+	)
+	if err != nil {
+		srv.logger(r).Warn("failed to update avatar", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	// uploading the same image again produces the same hash
+	if previous.Avatar != "" && previous.Avatar != processed.Hash {
+		if err := avatar.Delete(r.Context(), srv.blobs, previous.Avatar); err != nil {
+			srv.logger(r).Warn("failed to delete previous avatar", "email", email, "avatar", previous.Avatar, "error", err)
+		}
+	}
+
+	bs, _ := json.Marshal(newUserResponse(user))
+
+	w.Header().Set("ETag", etag(user.Version))
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+// readAvatar reads the image from a raw image body, or from the avatar field of a multipart form
+func readAvatar(r *http.Request) ([]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errAvatarContentType
+	}
+
+	if avatar.ContentTypes[mediaType] {
+		return readAvatarData(r.Body)
+	}
+
+	if mediaType != "multipart/form-data" {
+		return nil, errAvatarContentType
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errAvatarMissing
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != avatarField {
+			continue
+		}
+
+		// browsers send the file's type, curl sends application/octet-stream when it can't tell
+		if partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); partType != "" && partType != "application/octet-stream" && !avatar.ContentTypes[partType] {
+			return nil, errAvatarContentType
+		}
+
+		return readAvatarData(part)
+	}
+}
+
+func readAvatarData(r io.Reader) ([]byte, error) {
+	// one byte past the limit tells a too large image apart from one that's exactly MaxBytes
+	data, err := io.ReadAll(io.LimitReader(r, avatar.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errAvatarMissing
+	}
+
+	return data, nil
+}
+
+/*
+curl http://localhost:8080/users/jackson@juandefu.ca/avatar?size=64
+*/
+
+// GetAvatar is public, like any image linked from a page
+func (srv httpService) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/avatar")
+
+	size := avatar.DefaultSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || !avatar.ValidSize(size) {
+			// 400
+			writeError(w, http.StatusBadRequest, fmt.Errorf("size must be one of %v", avatar.Sizes))
+
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if organization := r.URL.Query().Get("organization"); organization != "" {
+		if err := ddd.ValidateOrganizationID(organization); err != nil {
+			// 400
+			writeError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		ctx = ddd.WithTenant(ctx, organization)
+	}
+
+	user, err := srv.userRepo.Get(ctx, email)
+	if errors.Is(err, ddd.ErrUserNotFound) {
+		// 404
+		writeError(w, http.StatusNotFound, errors.New("avatar not found"))
+
+		return
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("failed to get user", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	if user.Avatar == "" {
+		// 404
+		writeError(w, http.StatusNotFound, errors.New("avatar not found"))
+
+		return
+	}
+
+	// every image has its own hash, the avatarUrl's ?v= pins one so it can be cached forever
+	if r.URL.Query().Get("v") == user.Avatar {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+
+	tag := `"` + user.Avatar + "-" + strconv.Itoa(size) + `"`
+	w.Header().Set("ETag", tag)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == "*" || strings.Contains(match, tag)) {
+		// 304
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	blob, err := srv.blobs.Get(r.Context(), avatar.Key(user.Avatar, size))
+	if err != nil {
+		srv.logger(r).Error("failed to get avatar", "email", email, "avatar", user.Avatar, "error", err)
+
+		if err == ddd.ErrBlobNotFound {
+			// 404
+			writeError(w, http.StatusNotFound, errors.New("avatar not found"))
+
+			return
+		}
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(blob.Data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Last-Modified", blob.ModTime.UTC().Format(http.TimeFormat))
+
+	w.Write(blob.Data)
+}
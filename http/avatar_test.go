@@ -0,0 +1,229 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/avatar"
+	"github.com/sabey/ddd/blob"
+	"github.com/sabey/ddd/mock"
+)
+
+func newAvatarServer() (*mock.UserRepository, *blob.MemoryStore, *httptest.Server) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+
+	blobs := blob.NewMemoryStore()
+
+	return mockUsers, blobs, httptest.NewServer(
+		NewHTTPServiceWithOpts(HTTPServiceOpts{
+			UserRepository: mockUsers,
+			BlobStore:      blobs,
+		}),
+	)
+}
+
+func newTestPNG(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("failed to encode png: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func putAvatar(t *testing.T, url, contentType string, body io.Reader) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest("PUT", url+"/users/me/avatar", body)
+	if err != nil {
+		t.Fatalf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Add("X-Authentication-Token", ddd.SignJWTClaims("jackson@juandefu.ca"))
+	req.Header.Add("Content-Type", contentType)
+
+	resp, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %s", err)
+	}
+
+	return resp, string(bs)
+}
+
+func TestPutAvatar_Success(t *testing.T) {
+	mockUsers, blobs, ts := newAvatarServer()
+	defer ts.Close()
+
+	resp, body := putAvatar(t, ts.URL, "image/png", bytes.NewReader(newTestPNG(t, color.White)))
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d %s", resp.StatusCode, body)
+	}
+
+	user, _ := mockUsers.User("jackson@juandefu.ca")
+	if user.Avatar == "" {
+		t.Fatalf("avatar wasn't stored: %+v", user)
+	}
+
+	response := UserResponse{}
+	json.Unmarshal([]byte(body), &response)
+
+	if response.AvatarURL != "/users/jackson@juandefu.ca/avatar?v="+user.Avatar {
+		t.Errorf("unknown avatarUrl: %s", response.AvatarURL)
+	}
+
+	if resp.Header.Get("ETag") != `"2"` {
+		t.Errorf("unknown etag: `%s`", resp.Header.Get("ETag"))
+	}
+
+	previous := user.Avatar
+
+	// a multipart upload replaces it, and the previous images are deleted
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	fw, _ := mw.CreateFormFile("avatar", "avatar.png")
+	fw.Write(newTestPNG(t, color.Black))
+	mw.Close()
+
+	resp, body = putAvatar(t, ts.URL, mw.FormDataContentType(), buf)
+	if resp.StatusCode != 200 {
+		t.Fatalf("multipart upload failed: %d %s", resp.StatusCode, body)
+	}
+
+	user, _ = mockUsers.User("jackson@juandefu.ca")
+	if user.Avatar == previous {
+		t.Errorf("avatar wasn't replaced: %s", user.Avatar)
+	}
+
+	for _, size := range avatar.Sizes {
+		if _, err := blobs.Get(context.Background(), avatar.Key(previous, size)); err != ddd.ErrBlobNotFound {
+			t.Errorf("previous avatar wasn't deleted: %d %v", size, err)
+		}
+
+		if _, err := blobs.Get(context.Background(), avatar.Key(user.Avatar, size)); err != nil {
+			t.Errorf("avatar wasn't stored: %d %s", size, err)
+		}
+	}
+}
+
+func TestPutAvatar_Invalid(t *testing.T) {
+	mockUsers, _, ts := newAvatarServer()
+	defer ts.Close()
+
+	tests := []struct {
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"text/plain", []byte("hello"), 415},
+		{"image/png", []byte("not a png"), 415},
+		{"image/png", newTestPNG(t, color.White)[:100], 400},
+		{"image/png", []byte{}, 400},
+		{"image/png", make([]byte, avatar.MaxBytes+1), 413},
+		{"multipart/form-data; boundary=x", []byte("--x--\r\n"), 400},
+	}
+
+	for _, tt := range tests {
+		resp, body := putAvatar(t, ts.URL, tt.contentType, bytes.NewReader(tt.body))
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %d bytes: expected %d, got %d %s", tt.contentType, len(tt.body), tt.status, resp.StatusCode, body)
+		}
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.Avatar != "" || user.Version != 1 {
+		t.Errorf("an invalid upload was stored: %+v", user)
+	}
+}
+
+func TestGetAvatar(t *testing.T) {
+	mockUsers, _, ts := newAvatarServer()
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/users/jackson@juandefu.ca/avatar")
+	if resp.StatusCode != 404 {
+		t.Errorf("expected 404 without an avatar: %d", resp.StatusCode)
+	}
+
+	putAvatar(t, ts.URL, "image/png", bytes.NewReader(newTestPNG(t, color.White)))
+	user, _ := mockUsers.User("jackson@juandefu.ca")
+
+	resp, err := http.Get(ts.URL + "/users/jackson@juandefu.ca/avatar?size=64&v=" + user.Avatar)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("route failed: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	img, err := png.Decode(resp.Body)
+	if err != nil || img.Bounds().Dx() != 64 {
+		t.Errorf("expected a 64px png: %v", err)
+	}
+
+	if resp.Header.Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("a versioned url isn't immutable: %s", resp.Header.Get("Cache-Control"))
+	}
+
+	tag := resp.Header.Get("ETag")
+	if tag != `"`+user.Avatar+`-64"` {
+		t.Errorf("unknown etag: %s", tag)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/users/jackson@juandefu.ca/avatar?size=64", nil)
+	req.Header.Set("If-None-Match", tag)
+
+	resp, err = new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 304 {
+		t.Errorf("expected 304: %d", resp.StatusCode)
+	}
+
+	if resp.Header.Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("an unversioned url is cached forever: %s", resp.Header.Get("Cache-Control"))
+	}
+
+	for path, status := range map[string]int{
+		"/users/jackson@juandefu.ca/avatar?size=65": 400,
+		"/users/jackson@sabey.co/avatar":            404,
+	} {
+		resp, _ := http.Get(ts.URL + path)
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", path, status, resp.StatusCode)
+		}
+	}
+}
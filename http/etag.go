@@ -0,0 +1,31 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+)
+
+// etag is the strong entity tag of a user's version, `"3"`
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch returns the version an update expects, zero when any version is fine (no header or `*`)
+// ok is false when the header can't match any version, weak tags never match an If-Match
+func parseIfMatch(header string) (version int64, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	if len(header) < 3 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+
+	return version, true
+}
@@ -0,0 +1,242 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/federation"
+)
+
+/*
+curl http://localhost:8080/login/oidc
+*/
+
+// ListFederationProviders responds with the identity providers the login page can show a button for
+func (srv httpService) ListFederationProviders(w http.ResponseWriter, r *http.Request) {
+	if srv.federation == nil {
+		writeFederationUnavailable(w)
+
+		return
+	}
+
+	response := FederationProvidersResponse{
+		Providers: []FederationProviderResponse{},
+	}
+	for _, p := range srv.federation.Providers() {
+		response.Providers = append(response.Providers, FederationProviderResponse{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+		})
+	}
+
+	bs, _ := json.Marshal(response)
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"provider": "acme-sso"}' \
+  http://localhost:8080/login/oidc/options
+*/
+
+// BeginFederationLogin responds with the provider's url to send the browser to and the session to send back with its redirect
+func (srv httpService) BeginFederationLogin(w http.ResponseWriter, r *http.Request) {
+	if srv.federation == nil {
+		writeFederationUnavailable(w)
+
+		return
+	}
+
+	request := &FederationLoginOptionsRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	redirect, err := srv.federation.Begin(r.Context(), request.Provider)
+	if err != nil {
+		srv.logger(r).Warn("failed to begin federated login", "provider", request.Provider, "error", err)
+
+		writeFederationError(w, err)
+
+		return
+	}
+
+	bs, _ := json.Marshal(FederationLoginOptionsResponse{
+		URL:     redirect.URL,
+		Session: redirect.Session,
+	})
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"session": "session","code": "code","state": "state"}' \
+  http://localhost:8080/login/oidc
+*/
+
+// LoginFederation finishes a login with the code and state of the provider's redirect and responds with the same token as /login
+// the provider authenticated the user, two-factor authentication isn't asked for
+func (srv httpService) LoginFederation(w http.ResponseWriter, r *http.Request) {
+	if srv.federation == nil {
+		writeFederationUnavailable(w)
+
+		return
+	}
+
+	request := &FederationLoginRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	login, err := srv.federation.Finish(r.Context(), request.Session, request.Code, request.State)
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		srv.logger(r).Warn("federated login failed", "error", err)
+
+		writeFederationError(w, err)
+
+		return
+	}
+
+	if login.Created {
+		srv.logger(r).Info("provisioned user", "email", login.Email, "provider", login.Provider)
+	}
+
+	srv.metrics.logins.With("success").Inc()
+	srv.setUser(r, login.Tenant, login.Email)
+
+	jwt := ddd.SignTenantJWTClaims(login.Tenant, login.Email)
+
+	fmt.Fprintf(w, `{"token":"%s"}`, jwt)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  http://localhost:8080/users/me/identities
+*/
+
+// ListIdentities responds with the identity providers' subjects linked to the account
+func (srv httpService) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	if srv.federation == nil {
+		writeFederationUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "list identities") {
+		return
+	}
+
+	identities, err := srv.federation.Identities(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to list identities", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	response := IdentitiesResponse{
+		Identities: []IdentityResponse{},
+	}
+	for _, identity := range identities {
+		response.Identities = append(response.Identities, IdentityResponse{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+
+	bs, _ := json.Marshal(response)
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+// writeFederationError writes a failed login, why a provider failed or its id token was rejected is only logged
+func writeFederationError(w http.ResponseWriter, err error) {
+	for _, failure := range []error{
+		federation.ErrInvalidSession,
+		federation.ErrInvalidState,
+		federation.ErrInvalidCode,
+		federation.ErrInvalidIDToken,
+		federation.ErrEmailNotVerified,
+		federation.ErrDomainNotAllowed,
+		federation.ErrMissingName,
+	} {
+		if errors.Is(err, failure) {
+			// 401
+			writeError(w, http.StatusUnauthorized, failure)
+
+			return
+		}
+	}
+
+	switch {
+	case errors.Is(err, federation.ErrProviderNotFound):
+		// 404
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, federation.ErrAccountExists):
+		// 409
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, federation.ErrProviderFailed):
+		// 502
+		writeError(w, http.StatusBadGateway, federation.ErrProviderFailed)
+	default:
+		writeRepositoryError(w, err)
+	}
+}
+
+func writeFederationUnavailable(w http.ResponseWriter) {
+	// 501
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, `{"error":"federated login isn't available"}`)
+}
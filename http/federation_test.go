@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/federation"
+	"github.com/sabey/ddd/federation/federationtest"
+	"github.com/sabey/ddd/mock"
+)
+
+func newFederationServer(t *testing.T) (*mock.UserRepository, *federationtest.IdentityProvider, *httptest.Server) {
+	t.Helper()
+
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
+
+	idp := federationtest.NewIdentityProvider("ddd", "secret", "https://ddd.example/login/oidc/callback")
+	t.Cleanup(idp.Close)
+
+	f, err := federation.NewFederation(federation.FederationOpts{
+		Repository: mockUsers,
+		Users:      mockUsers,
+		Providers: []federation.ProviderConfig{{
+			Name:         "acme-sso",
+			DisplayName:  "Acme",
+			Issuer:       idp.URL,
+			ClientID:     idp.ClientID,
+			ClientSecret: idp.ClientSecret,
+			RedirectURL:  idp.RedirectURL,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create federation: %s", err)
+	}
+
+	ts := httptest.NewServer(NewHTTPServiceWithOpts(HTTPServiceOpts{
+		UserRepository: mockUsers,
+		Federation:     f,
+	}))
+	t.Cleanup(ts.Close)
+
+	return mockUsers, idp, ts
+}
+
+// loginFederation logs in at the provider the way a browser would
+func loginFederation(t *testing.T, ts *httptest.Server, idp *federationtest.IdentityProvider) (int, string) {
+	t.Helper()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/oidc/options", "", `{"provider":"acme-sso"}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	options := FederationLoginOptionsResponse{}
+	if err := json.Unmarshal([]byte(body), &options); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	code, state, err := idp.Authorize(options.URL)
+	if err != nil {
+		t.Fatalf("provider failed to authorize: %s", err)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/oidc", "", fmt.Sprintf(`{"session":"%s","code":"%s","state":"%s"}`, options.Session, code, state))
+
+	return resp.StatusCode, body
+}
+
+func TestFederation(t *testing.T) {
+	mockUsers, idp, ts := newFederationServer(t)
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/login/oidc", "", "")
+	if resp.StatusCode != 200 || body != `{"providers":[{"name":"acme-sso","displayName":"Acme"}]}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the provider's subject is provisioned a new account
+	idp.Claims["email"] = "jackson@sabey.co"
+
+	status, body := loginFederation(t, ts, idp)
+	if status != 200 {
+		t.Fatalf("route failed: %d `%s`", status, body)
+	}
+
+	login := LoginResponse{}
+	if err := json.Unmarshal([]byte(body), &login); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	// the same token as /login
+	if tenant, email := ddd.ParseTenantJWTClaims(login.Token); tenant != ddd.DefaultOrganization || email != "jackson@sabey.co" {
+		t.Errorf("unknown token claims: %s %s", tenant, email)
+	}
+
+	if user, ok := mockUsers.User("jackson@sabey.co"); !ok || user.FirstName != "Jackson" || user.LastName != "Sabey" {
+		t.Errorf("unknown user: %+v", user)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me/identities", login.Token, "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	identities := IdentitiesResponse{}
+	if err := json.Unmarshal([]byte(body), &identities); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if len(identities.Identities) != 1 || identities.Identities[0].Provider != "acme-sso" || identities.Identities[0].Subject != "248289761001" {
+		t.Errorf("unknown identities: `%s`", body)
+	}
+
+	// the linked subject logs in to the same account
+	status, body = loginFederation(t, ts, idp)
+	if status != 200 {
+		t.Fatalf("route failed: %d `%s`", status, body)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodCreate); len(calls) != 1 {
+		t.Errorf("expected the account to be provisioned once, got: %v", calls)
+	}
+}
+
+func TestFederation_Login(t *testing.T) {
+	mockUsers, idp, ts := newFederationServer(t)
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/oidc/options", "", `{"provider":"other-sso"}`)
+	if resp.StatusCode != 404 || body != fmt.Sprintf(`{"error":"%s"}`, federation.ErrProviderNotFound) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/oidc/options", "", `{}`)
+	if resp.StatusCode != 400 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/oidc", "", `{"session":"session","code":"code","state":"state"}`)
+	if resp.StatusCode != 401 || body != fmt.Sprintf(`{"error":"%s"}`, federation.ErrInvalidSession) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/oidc", "", `{"session":"session"}`)
+	if resp.StatusCode != 400 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the seeded account isn't linked to the provider
+	status, body := loginFederation(t, ts, idp)
+	if status != 409 || body != fmt.Sprintf(`{"error":"%s"}`, federation.ErrAccountExists) {
+		t.Errorf("unknown response: %d `%s`", status, body)
+	}
+
+	// why the id token was rejected isn't sent
+	idp.Claims["email"] = "jackson@sabey.co"
+	idp.Claims["email_verified"] = false
+
+	status, body = loginFederation(t, ts, idp)
+	if status != 401 || body != fmt.Sprintf(`{"error":"%s"}`, federation.ErrEmailNotVerified) {
+		t.Errorf("unknown response: %d `%s`", status, body)
+	}
+
+	idp.Claims["email_verified"] = true
+
+	if status, body = loginFederation(t, ts, idp); status != 200 {
+		t.Fatalf("route failed: %d `%s`", status, body)
+	}
+
+	if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: "jackson@sabey.co", Disabled: ddd.Bool(true)}); err != nil {
+		t.Fatalf("failed to disable user: %s", err)
+	}
+
+	status, body = loginFederation(t, ts, idp)
+	if status != 403 || body != fmt.Sprintf(`{"error":"%s"}`, ddd.ErrUserDisabled) {
+		t.Errorf("unknown response: %d `%s`", status, body)
+	}
+}
+
+func TestFederation_ProviderFailed(t *testing.T) {
+	_, idp, ts := newFederationServer(t)
+
+	idp.Close()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/oidc/options", "", `{"provider":"acme-sso"}`)
+	if resp.StatusCode != 502 || body != fmt.Sprintf(`{"error":"%s"}`, federation.ErrProviderFailed) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestFederation_Unavailable(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/login/oidc", "", "")
+	if resp.StatusCode != 501 || body != `{"error":"federated login isn't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me/identities", ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 501 || body != `{"error":"federated login isn't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
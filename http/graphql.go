@@ -0,0 +1,436 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/logging"
+)
+
+const (
+	DefaultGraphQLMaxDepth      = 10
+	DefaultGraphQLMaxComplexity = 10000
+	// maxGraphQLBody bounds the query and its variables
+	maxGraphQLBody = 1 << 20
+)
+
+/*
+curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"query": "{ me { email displayName } users(first: 10) { nodes { email } pageInfo { hasNextPage endCursor } } }"}' \
+  http://localhost:8080/graphql
+*/
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLContext is the state of one request shared by its resolvers
+type graphQLContext struct {
+	r     *http.Request
+	token string
+	// mutation needs an api key with the write scope
+	mutation bool
+	loader   *userLoader
+
+	once  sync.Once
+	email string
+	err   error
+}
+
+type graphQLContextKey struct{}
+
+func graphQLContextFrom(ctx context.Context) *graphQLContext {
+	return ctx.Value(graphQLContextKey{}).(*graphQLContext)
+}
+
+func (srv httpService) GraphQL(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	request := &GraphQLRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(&request)
+	span.End()
+
+	if err != nil || request.Query == "" {
+		// 400
+		writeGraphQLErrors(w, http.StatusBadRequest, gqlerrors.NewFormattedError("invalid request"))
+
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(request.Query),
+			Name: "GraphQL request",
+		}),
+	})
+	if err != nil {
+		// 400
+		writeGraphQLErrors(w, http.StatusBadRequest, gqlerrors.FormatError(err))
+
+		return
+	}
+
+	span = srv.startSpan(r, "graphql.Validate")
+	result := graphql.ValidateDocument(srv.graphQL, doc, nil)
+	span.End()
+
+	if !result.IsValid {
+		// 400
+		writeGraphQLErrors(w, http.StatusBadRequest, result.Errors...)
+
+		return
+	}
+
+	// the limits are checked once the document is known to be valid, fragments can't be cyclic anymore
+	if err := srv.graphQLLimits.check(doc, request.OperationName, request.Variables); err != nil {
+		// 400
+		writeGraphQLErrors(w, http.StatusBadRequest, err.format())
+
+		return
+	}
+
+	operation := graphQLOperation(doc, request.OperationName)
+
+	ctx := context.WithValue(r.Context(), graphQLContextKey{}, &graphQLContext{
+		r:        r,
+		token:    r.Header.Get("X-Authentication-Token"),
+		mutation: operation != nil && operation.Operation == ast.OperationTypeMutation,
+		loader:   newUserLoader(srv.userRepo),
+	})
+
+	span = srv.startSpan(r, "graphql.Execute")
+	executed := graphql.Execute(graphql.ExecuteParams{
+		Schema:        *srv.graphQL,
+		AST:           doc,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+	span.End()
+
+	for i, formatted := range executed.Errors {
+		// errors returned by thunks lose their extensions on the way, they're found again on the wrapped error
+		if formatted.Extensions == nil {
+			if err := findGraphQLError(formatted.OriginalError()); err != nil {
+				executed.Errors[i].Extensions = err.Extensions()
+			}
+		}
+	}
+
+	bs, _ := json.Marshal(executed)
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+func writeGraphQLErrors(w http.ResponseWriter, status int, errs ...gqlerrors.FormattedError) {
+	bs, _ := json.Marshal(&graphql.Result{
+		Errors: errs,
+	})
+
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s", bs)
+}
+
+// graphQLError is a resolver error with a code clients can match, in the error's extensions
+type graphQLError struct {
+	code    string
+	message string
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code": e.code,
+	}
+}
+
+// format keeps the code of an error that's written without running the query
+func (e *graphQLError) format() gqlerrors.FormattedError {
+	formatted := gqlerrors.NewFormattedError(e.message)
+	formatted.Extensions = e.Extensions()
+
+	return formatted
+}
+
+func newGraphQLError(code, format string, args ...interface{}) *graphQLError {
+	return &graphQLError{
+		code:    code,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+// badUserInput is a validation error
+func badUserInput(err error) *graphQLError {
+	return newGraphQLError("BAD_USER_INPUT", "%s", err)
+}
+
+// findGraphQLError unwraps the chain of graphql-go's errors
+func findGraphQLError(err error) *graphQLError {
+	for err != nil {
+		switch e := err.(type) {
+		case *graphQLError:
+			return e
+		case gqlerrors.FormattedError:
+			err = e.OriginalError()
+		case *gqlerrors.Error:
+			err = e.OriginalError
+		default:
+			err = errors.Unwrap(err)
+		}
+	}
+
+	return nil
+}
+
+// repositoryError maps a failed repository call to a coded error, the message of an unexpected error is logged, not returned
+func repositoryError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return newGraphQLError("TIMEOUT", "request timed out")
+	case errors.Is(err, ddd.ErrUserExists):
+		return newGraphQLError("ALREADY_EXISTS", "%s", err)
+	case errors.Is(err, ddd.ErrUserNotFound):
+		return newGraphQLError("NOT_FOUND", "%s", err)
+	case errors.Is(err, ddd.ErrInvalidPassword), errors.Is(err, ddd.ErrInvalidTOTPCode):
+		return newGraphQLError("UNAUTHENTICATED", "%s", err)
+	case errors.Is(err, ddd.ErrUserDisabled):
+		return newGraphQLError("FORBIDDEN", "%s", err)
+	case errors.Is(err, ddd.ErrTOTPLocked):
+		return newGraphQLError("TOO_MANY_REQUESTS", "%s", err)
+	case errors.Is(err, ddd.ErrVersionMismatch):
+		return newGraphQLError("VERSION_MISMATCH", "%s", err)
+	}
+
+	logging.FromContext(ctx).Error("graphql resolver failed", "error", err)
+
+	return newGraphQLError("INTERNAL_SERVER_ERROR", "internal error")
+}
+
+// graphQLLimits reject a query before it runs, the depth counts nested selections and the complexity counts fields
+// a field with a `first` argument multiplies the complexity of its selections, introspection is free
+type graphQLLimits struct {
+	maxDepth      int
+	maxComplexity int
+}
+
+type graphQLCost struct {
+	depth      int
+	complexity int
+}
+
+// graphQLOperation returns the operation graphql.Execute runs, nil when it isn't found
+func graphQLOperation(doc *ast.Document, operationName string) *ast.OperationDefinition {
+	var operation *ast.OperationDefinition
+
+	for _, definition := range doc.Definitions {
+		if definition, ok := definition.(*ast.OperationDefinition); ok {
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+
+	return operation
+}
+
+func (limits graphQLLimits) check(doc *ast.Document, operationName string, variables map[string]interface{}) *graphQLError {
+	operation := graphQLOperation(doc, operationName)
+	fragments := map[string]*ast.FragmentDefinition{}
+
+	for _, definition := range doc.Definitions {
+		if definition, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[definition.Name.Value] = definition
+		}
+	}
+
+	if operation == nil {
+		// graphql.Execute reports it
+		return nil
+	}
+
+	walker := &graphQLCostWalker{
+		fragments: fragments,
+		variables: variables,
+		costs:     map[string]graphQLCost{},
+	}
+
+	cost := walker.selectionSet(operation.SelectionSet)
+
+	if cost.depth > limits.maxDepth {
+		return newGraphQLError("DEPTH_LIMIT", "query depth %d exceeds the limit of %d", cost.depth, limits.maxDepth)
+	}
+
+	if cost.complexity > limits.maxComplexity {
+		return newGraphQLError("COMPLEXITY_LIMIT", "query complexity %d exceeds the limit of %d", cost.complexity, limits.maxComplexity)
+	}
+
+	return nil
+}
+
+type graphQLCostWalker struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// costs are memoized by fragment, a fragment spread many times is only walked once
+	costs map[string]graphQLCost
+}
+
+func (cw *graphQLCostWalker) selectionSet(set *ast.SelectionSet) graphQLCost {
+	total := graphQLCost{}
+	if set == nil {
+		return total
+	}
+
+	for _, selection := range set.Selections {
+		var cost graphQLCost
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+
+			children := cw.selectionSet(selection.SelectionSet)
+			cost = graphQLCost{
+				depth:      children.depth + 1,
+				complexity: 1 + cw.multiplier(selection)*children.complexity,
+			}
+		case *ast.InlineFragment:
+			cost = cw.selectionSet(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+
+			var ok bool
+			if cost, ok = cw.costs[name]; !ok {
+				if fragment, ok := cw.fragments[name]; ok {
+					cost = cw.selectionSet(fragment.SelectionSet)
+				}
+
+				cw.costs[name] = cost
+			}
+		}
+
+		total.complexity += cost.complexity
+		if cost.depth > total.depth {
+			total.depth = cost.depth
+		}
+	}
+
+	return total
+}
+
+// multiplier is a list field's `first` argument, a literal or a variable
+func (cw *graphQLCostWalker) multiplier(field *ast.Field) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "first" {
+			continue
+		}
+
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			// variables are decoded from json
+			if n, ok := cw.variables[value.Name.Value].(float64); ok && n > 0 {
+				return int(n)
+			}
+		}
+
+		return 1
+	}
+
+	if field.Name.Value == "users" {
+		return defaultGraphQLPageSize
+	}
+
+	return 1
+}
+
+// userLoader batches the lookups of one request into a single List call
+// `a: user(email: "a") b: user(email: "b")` is one query, and an account requested twice is only looked up once
+// resolvers return its thunks, graphql-go calls them once every sibling field has been resolved
+type userLoader struct {
+	userRepo ddd.UserRepository
+
+	mu      sync.Mutex
+	pending []string
+	// a nil result is still pending
+	results map[string]*userLoaderResult
+}
+
+type userLoaderResult struct {
+	// user is nil when the account doesn't exist
+	user *ddd.User
+	err  error
+}
+
+func newUserLoader(userRepo ddd.UserRepository) *userLoader {
+	return &userLoader{
+		userRepo: userRepo,
+		results:  map[string]*userLoaderResult{},
+	}
+}
+
+// load returns a thunk of the account, nil when it doesn't exist
+func (l *userLoader) load(ctx context.Context, email string) func() (*ddd.User, error) {
+	l.mu.Lock()
+	if _, ok := l.results[email]; !ok {
+		l.results[email] = nil
+		l.pending = append(l.pending, email)
+	}
+	l.mu.Unlock()
+
+	return func() (*ddd.User, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.results[email] == nil {
+			l.flush(ctx)
+		}
+
+		result := l.results[email]
+
+		return result.user, result.err
+	}
+}
+
+// flush looks up every pending account, it's called with the lock held
+func (l *userLoader) flush(ctx context.Context) {
+	emails := l.pending
+	l.pending = nil
+
+	users, err := l.userRepo.List(ctx, ddd.UserList{Emails: emails})
+
+	found := map[string]*ddd.User{}
+	for _, user := range users {
+		found[user.Email] = user
+	}
+
+	for _, email := range emails {
+		l.results[email] = &userLoaderResult{
+			user: found[email],
+			err:  err,
+		}
+	}
+}
@@ -0,0 +1,677 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/apikey"
+	"github.com/sabey/ddd/logging"
+)
+
+// defaultGraphQLPageSize is the users connection's page without `first`, it's capped at maxListLimit like GET /users
+const defaultGraphQLPageSize = 100
+
+// userConnection is the source of the UserConnection type
+type userConnection struct {
+	users       []*ddd.User
+	hasNextPage bool
+}
+
+// authPayload is the source of the AuthPayload type
+type authPayload struct {
+	token string
+	user  *ddd.User
+}
+
+// jsonScalar holds the attributes, literals are converted the way encoding/json decodes variables
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: parseJSONLiteral,
+})
+
+func parseJSONLiteral(value ast.Value) interface{} {
+	switch value := value.(type) {
+	case *ast.StringValue:
+		return value.Value
+	case *ast.BooleanValue:
+		return value.Value
+	case *ast.IntValue, *ast.FloatValue:
+		n, err := strconv.ParseFloat(value.GetValue().(string), 64)
+		if err != nil {
+			return nil
+		}
+
+		return n
+	case *ast.ListValue:
+		list := []interface{}{}
+		for _, item := range value.Values {
+			list = append(list, parseJSONLiteral(item))
+		}
+
+		return list
+	case *ast.ObjectValue:
+		object := map[string]interface{}{}
+		for _, field := range value.Fields {
+			object[field.Name.Value] = parseJSONLiteral(field.Value)
+		}
+
+		return object
+	}
+
+	return nil
+}
+
+// userField resolves a field of the User type
+func userField(typ graphql.Output, description string, get func(*ddd.User) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type:        typ,
+		Description: description,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*ddd.User)), nil
+		},
+	}
+}
+
+// optionalString is an input field that may be missing
+func optionalString(args map[string]interface{}, key string) *string {
+	if s, ok := args[key].(string); ok {
+		return &s
+	}
+
+	return nil
+}
+
+// newGraphQLSchema builds the schema, its resolvers call the same repository and check the same tokens as the REST handlers
+func newGraphQLSchema(srv httpService) (*graphql.Schema, error) {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"email":       userField(graphql.NewNonNull(graphql.String), "", func(u *ddd.User) interface{} { return u.Email }),
+			"firstName":   userField(graphql.NewNonNull(graphql.String), "", func(u *ddd.User) interface{} { return u.FirstName }),
+			"lastName":    userField(graphql.NewNonNull(graphql.String), "", func(u *ddd.User) interface{} { return u.LastName }),
+			"displayName": userField(graphql.String, "", func(u *ddd.User) interface{} { return nullable(u.DisplayName) }),
+			"locale":      userField(graphql.String, "A BCP 47 language tag", func(u *ddd.User) interface{} { return nullable(u.Locale) }),
+			"timezone":    userField(graphql.String, "An IANA time zone name", func(u *ddd.User) interface{} { return nullable(u.Timezone) }),
+			"attributes":  userField(jsonScalar, "Application specific", func(u *ddd.User) interface{} { return u.Attributes }),
+			"avatarUrl":   userField(graphql.String, "Relative to the service", func(u *ddd.User) interface{} { return nullable(avatarURL(u)) }),
+			"admin":       userField(graphql.NewNonNull(graphql.Boolean), "", func(u *ddd.User) interface{} { return u.Admin }),
+			"version":     userField(graphql.NewNonNull(graphql.Int), "Sent back to updateProfile to fail when the account changed", func(u *ddd.User) interface{} { return u.Version }),
+		},
+	})
+
+	userEdgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return userCursor(p.Source.(*ddd.User)), nil
+				},
+			},
+			"node": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*userConnection).hasNextPage, nil
+				},
+			},
+			"endCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Passed as `after` to get the next page, null on an empty page",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					users := p.Source.(*userConnection).users
+					if len(users) == 0 {
+						return nil, nil
+					}
+
+					return userCursor(users[len(users)-1]), nil
+				},
+			},
+		},
+	})
+
+	userConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userEdgeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*userConnection).users, nil
+				},
+			},
+			"nodes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*userConnection).users, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	userFilterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UserFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"search": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Matches part of the email, names or display name, ignoring case",
+			},
+			"admin": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+		},
+	})
+
+	authPayloadType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuthPayload",
+		Fields: graphql.Fields{
+			"token": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*authPayload).token, nil
+				},
+			},
+			"user": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*authPayload).user, nil
+				},
+			},
+		},
+	})
+
+	signupInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "SignupInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"email":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"firstName": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"lastName":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"password":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	updateProfileInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "UpdateProfileInput",
+		Description: "Missing fields are left as they are, an empty display name, locale or timezone clears it",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"firstName":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"lastName":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"displayName": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"locale":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"timezone":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"attributes": &graphql.InputObjectFieldConfig{
+				Type:        jsonScalar,
+				Description: "Merged into the stored attributes, a null value (sent in the variables) removes the key",
+			},
+			"version": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Int,
+				Description: "The update fails with VERSION_MISMATCH when the account changed since this version",
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:        graphql.NewNonNull(userType),
+				Description: "The authenticated account",
+				Resolve:     srv.resolveMe,
+			},
+			"user": &graphql.Field{
+				Type:        userType,
+				Description: "An account by email, null when it doesn't exist",
+				Args: graphql.FieldConfigArgument{
+					"email": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: srv.resolveUser,
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewNonNull(userConnectionType),
+				Description: "Accounts ordered by email",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: defaultGraphQLPageSize,
+						Description:  fmt.Sprintf("Between 1 and %d", maxListLimit),
+					},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+					"filter": &graphql.ArgumentConfig{Type: userFilterType},
+				},
+				Resolve: srv.resolveUsers,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"signup": &graphql.Field{
+				Type: graphql.NewNonNull(authPayloadType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(signupInputType)},
+				},
+				Resolve: srv.resolveSignup,
+			},
+			"login": &graphql.Field{
+				Type: graphql.NewNonNull(authPayloadType),
+				Args: graphql.FieldConfigArgument{
+					"email":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"organization": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "The account's organization, the default organization when it's omitted",
+					},
+					"code": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "The authenticator app's code, the login fails with MFA_REQUIRED without it or recoveryCode when the account has two-factor authentication",
+					},
+					"recoveryCode": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "A recovery code, sent instead of code",
+					},
+				},
+				Resolve: srv.resolveLogin,
+			},
+			"updateProfile": &graphql.Field{
+				Type:        graphql.NewNonNull(userType),
+				Description: "Updates the authenticated account",
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(updateProfileInputType)},
+				},
+				Resolve: srv.resolveUpdateProfile,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+// nullable is null for an empty optional field
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
+
+// userCursor is the same cursor as GET /users
+func userCursor(user *ddd.User) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(user.Email))
+}
+
+// authenticateGraphQL is the email of the request's token, it's checked the first time a resolver needs it
+func (srv httpService) authenticateGraphQL(ctx context.Context) (string, error) {
+	gc := graphQLContextFrom(ctx)
+
+	gc.once.Do(func() {
+		gc.email, gc.err = srv.authenticateGraphQLToken(ctx, gc)
+		if gc.err != nil {
+			return
+		}
+
+		if err := srv.checkAccount(ctx, gc.email); err != nil {
+			gc.email, gc.err = "", repositoryError(ctx, err)
+		}
+	})
+
+	return gc.email, gc.err
+}
+
+// authenticateGraphQLToken is authenticateGraphQL without the account lookup
+func (srv httpService) authenticateGraphQLToken(ctx context.Context, gc *graphQLContext) (string, error) {
+	if auth := apiKeyAuthFrom(ctx); auth != nil {
+		return srv.authenticateGraphQLAPIKey(ctx, gc, auth)
+	}
+
+	if gc.token == "" {
+		srv.metrics.tokenFailures.With("missing").Inc()
+
+		return "", newGraphQLError("UNAUTHENTICATED", "jwt not found")
+	}
+
+	tenant, email := ddd.ParseTenantJWTClaims(gc.token)
+	if email == "" {
+		srv.metrics.tokenFailures.With("invalid").Inc()
+
+		return "", newGraphQLError("UNAUTHENTICATED", "invalid jwt")
+	}
+
+	srv.setUser(gc.r, tenant, email)
+
+	return email, nil
+}
+
+// authenticateGraphQLAPIKey is authenticateGraphQL for a request sent with an api key, a mutation needs the write scope
+func (srv httpService) authenticateGraphQLAPIKey(ctx context.Context, gc *graphQLContext, auth *apiKeyAuth) (string, error) {
+	if errors.Is(auth.err, apikey.ErrInvalidKey) {
+		srv.metrics.tokenFailures.With("invalid").Inc()
+
+		return "", newGraphQLError("UNAUTHENTICATED", "invalid api key")
+	}
+
+	if auth.err != nil {
+		return "", repositoryError(ctx, auth.err)
+	}
+
+	scope := ddd.APIKeyScopeRead
+	if gc.mutation {
+		scope = ddd.APIKeyScopeWrite
+	}
+
+	if !auth.key.HasScope(scope) {
+		return "", newGraphQLError("FORBIDDEN", "api key doesn't have the %s scope", scope)
+	}
+
+	srv.setUser(gc.r, auth.key.Organization, auth.key.Email)
+
+	return auth.key.Email, nil
+}
+
+func (srv httpService) resolveMe(p graphql.ResolveParams) (interface{}, error) {
+	email, err := srv.authenticateGraphQL(p.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	load := graphQLContextFrom(p.Context).loader.load(p.Context, email)
+
+	return func() (interface{}, error) {
+		user, err := load()
+		if err != nil {
+			return nil, repositoryError(p.Context, err)
+		}
+
+		if user == nil {
+			return nil, repositoryError(p.Context, ddd.ErrUserNotFound)
+		}
+
+		return user, nil
+	}, nil
+}
+
+func (srv httpService) resolveUser(p graphql.ResolveParams) (interface{}, error) {
+	if _, err := srv.authenticateGraphQL(p.Context); err != nil {
+		return nil, err
+	}
+
+	load := graphQLContextFrom(p.Context).loader.load(p.Context, p.Args["email"].(string))
+
+	return func() (interface{}, error) {
+		user, err := load()
+		if err != nil {
+			return nil, repositoryError(p.Context, err)
+		}
+
+		// a nil *ddd.User isn't null to graphql-go
+		if user == nil {
+			return nil, nil
+		}
+
+		return user, nil
+	}, nil
+}
+
+func (srv httpService) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	if _, err := srv.authenticateGraphQL(p.Context); err != nil {
+		return nil, err
+	}
+
+	first, _ := p.Args["first"].(int)
+	if first < 1 || first > maxListLimit {
+		return nil, newGraphQLError("BAD_USER_INPUT", "first must be between 1 and %d", maxListLimit)
+	}
+
+	// one more account tells whether there's a next page
+	opts := ddd.UserList{
+		Limit: first + 1,
+	}
+
+	if after, ok := p.Args["after"].(string); ok {
+		email, err := base64.RawURLEncoding.DecodeString(after)
+		if err != nil || len(email) == 0 {
+			return nil, newGraphQLError("BAD_USER_INPUT", "after was invalid")
+		}
+
+		opts.After = string(email)
+	}
+
+	if filter, ok := p.Args["filter"].(map[string]interface{}); ok {
+		if search, ok := filter["search"].(string); ok {
+			opts.Search = search
+		}
+
+		if admin, ok := filter["admin"].(bool); ok {
+			opts.Admin = ddd.Bool(admin)
+		}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, badUserInput(err)
+	}
+
+	users, err := srv.userRepo.List(p.Context, opts)
+	if err != nil {
+		return nil, repositoryError(p.Context, err)
+	}
+
+	connection := &userConnection{
+		users: users,
+	}
+
+	if len(users) > first {
+		connection.users = users[:first]
+		connection.hasNextPage = true
+	}
+
+	return connection, nil
+}
+
+func (srv httpService) resolveSignup(p graphql.ResolveParams) (interface{}, error) {
+	input := p.Args["input"].(map[string]interface{})
+
+	opts := ddd.UserCreate{
+		Email:     input["email"].(string),
+		FirstName: input["firstName"].(string),
+		LastName:  input["lastName"].(string),
+		Password:  input["password"].(string),
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, badUserInput(err)
+	}
+
+	gc := graphQLContextFrom(p.Context)
+	opts.Password = srv.hashPassword(gc.r, opts.Password)
+
+	// accounts of other organizations are created by accepting an invitation
+	user, err := srv.userRepo.Create(ddd.WithTenant(p.Context, ddd.DefaultOrganization), opts)
+	if err != nil {
+		logging.FromContext(p.Context).Warn("signup failed", "email", opts.Email, "error", err)
+
+		return nil, repositoryError(p.Context, err)
+	}
+
+	srv.metrics.signups.With().Inc()
+	srv.setUser(gc.r, user.Organization, user.Email)
+
+	return &authPayload{
+		token: ddd.SignTenantJWTClaims(user.Organization, user.Email),
+		user:  user,
+	}, nil
+}
+
+func (srv httpService) resolveLogin(p graphql.ResolveParams) (interface{}, error) {
+	opts := ddd.UserLogin{
+		Email:    p.Args["email"].(string),
+		Password: p.Args["password"].(string),
+	}
+
+	if err := opts.Validate(); err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		return nil, badUserInput(err)
+	}
+
+	tenant := ddd.DefaultOrganization
+	if organization, ok := p.Args["organization"].(string); ok {
+		if err := ddd.ValidateOrganizationID(organization); err != nil {
+			srv.metrics.logins.With("failure").Inc()
+
+			return nil, badUserInput(err)
+		}
+
+		tenant = organization
+	}
+
+	gc := graphQLContextFrom(p.Context)
+	opts.Password = srv.hashPassword(gc.r, opts.Password)
+
+	user, err := srv.userRepo.Login(ddd.WithTenant(p.Context, tenant), opts)
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		logging.FromContext(p.Context).Warn("login failed", "email", opts.Email, "error", err)
+
+		return nil, repositoryError(p.Context, err)
+	}
+
+	if err := srv.verifyGraphQLLogin(p, user); err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		logging.FromContext(p.Context).Warn("login failed", "email", opts.Email, "error", err)
+
+		return nil, err
+	}
+
+	srv.metrics.logins.With("success").Inc()
+	srv.setUser(gc.r, user.Organization, user.Email)
+
+	return &authPayload{
+		token: ddd.SignTenantJWTClaims(user.Organization, user.Email),
+		user:  user,
+	}, nil
+}
+
+// verifyGraphQLLogin checks the second factor of an account with two-factor authentication, the mutation has no challenge step
+func (srv httpService) verifyGraphQLLogin(p graphql.ResolveParams, user *ddd.User) error {
+	if srv.totp == nil {
+		return nil
+	}
+
+	ctx := ddd.WithTenant(p.Context, user.Organization)
+
+	required, err := srv.totp.Required(ctx, user.Email)
+	if err != nil {
+		return repositoryError(p.Context, err)
+	}
+
+	if !required {
+		return nil
+	}
+
+	code, _ := p.Args["code"].(string)
+	recoveryCode, _ := p.Args["recoveryCode"].(string)
+
+	if code == "" && recoveryCode == "" {
+		return newGraphQLError("MFA_REQUIRED", "two-factor code is required")
+	}
+
+	if err := srv.totp.Verify(ctx, user.Email, code, recoveryCode); err != nil {
+		return repositoryError(p.Context, err)
+	}
+
+	return nil
+}
+
+func (srv httpService) resolveUpdateProfile(p graphql.ResolveParams) (interface{}, error) {
+	email, err := srv.authenticateGraphQL(p.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	input := p.Args["input"].(map[string]interface{})
+
+	opts := ddd.UserUpdate{
+		Email:       email,
+		FirstName:   optionalString(input, "firstName"),
+		LastName:    optionalString(input, "lastName"),
+		DisplayName: optionalString(input, "displayName"),
+		Locale:      optionalString(input, "locale"),
+		Timezone:    optionalString(input, "timezone"),
+	}
+
+	if version, ok := input["version"].(int); ok {
+		opts.Version = int64(version)
+	}
+
+	if attributes, ok := input["attributes"]; ok && attributes != nil {
+		attrs, ok := attributes.(map[string]interface{})
+		if !ok {
+			return nil, badUserInput(errors.New("attributes must be an object"))
+		}
+
+		opts.Attributes = attrs
+	}
+
+	var user *ddd.User
+	if opts.Empty() {
+		// like an empty merge patch, nothing changes and the current account is returned
+		user, err = srv.userRepo.Get(p.Context, email)
+		if err == nil && opts.Version > 0 && user.Version != opts.Version {
+			err = ddd.ErrVersionMismatch
+		}
+	} else {
+		if err := opts.Validate(); err != nil {
+			return nil, badUserInput(err)
+		}
+
+		user, err = srv.userRepo.Update(p.Context, opts)
+	}
+
+	if err != nil {
+		logging.FromContext(p.Context).Warn("failed to update profile", "email", email, "error", err)
+
+		return nil, repositoryError(p.Context, err)
+	}
+
+	return user, nil
+}
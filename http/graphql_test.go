@@ -0,0 +1,372 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+type graphQLResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func (gr graphQLResponse) code() string {
+	if len(gr.Errors) == 0 {
+		return ""
+	}
+
+	code, _ := gr.Errors[0].Extensions["code"].(string)
+
+	return code
+}
+
+func newGraphQLServer(opts HTTPServiceOpts) (*mock.UserRepository, *httptest.Server) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{
+			Email:     "jackson@juandefu.ca",
+			FirstName: "Jackson",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+		},
+		ddd.User{
+			Email:     "admin@sabey.co",
+			FirstName: "Admin",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+			Admin:     true,
+		},
+		ddd.User{
+			Email:       "someone@else.com",
+			FirstName:   "Someone",
+			LastName:    "Else",
+			DisplayName: "Juan",
+			Password:    ddd.HashPassword("pass"),
+		},
+	)
+
+	opts.UserRepository = mockUsers
+
+	return mockUsers, httptest.NewServer(NewHTTPServiceWithOpts(opts))
+}
+
+func graphQL(t *testing.T, url, email, query string, variables map[string]interface{}) (int, graphQLResponse) {
+	t.Helper()
+
+	bs, _ := json.Marshal(GraphQLRequest{
+		Query:     query,
+		Variables: variables,
+	})
+
+	req, err := http.NewRequest("POST", url+"/graphql", strings.NewReader(string(bs)))
+	if err != nil {
+		t.Fatalf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if email != "" {
+		req.Header.Set("X-Authentication-Token", ddd.SignJWTClaims(email))
+	}
+
+	resp, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	response := graphQLResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+
+	return resp.StatusCode, response
+}
+
+func TestGraphQL_Me(t *testing.T) {
+	_, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	status, resp := graphQL(t, s.URL, "someone@else.com", `{ me { email firstName displayName locale version } }`, nil)
+	if status != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("unexpected response: %d %+v", status, resp)
+	}
+
+	if fmt.Sprint(resp.Data["me"]) != "map[displayName:Juan email:someone@else.com firstName:Someone locale:<nil> version:1]" {
+		t.Fatalf("unexpected me: %v", resp.Data["me"])
+	}
+}
+
+func TestGraphQL_Authentication(t *testing.T) {
+	_, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	for _, query := range []string{
+		`{ me { email } }`,
+		`{ user(email: "admin@sabey.co") { email } }`,
+		`{ users { nodes { email } } }`,
+		`mutation { updateProfile(input: {lastName: "SABEY"}) { email } }`,
+	} {
+		_, resp := graphQL(t, s.URL, "", query, nil)
+		if resp.code() != "UNAUTHENTICATED" || resp.Errors[0].Message != "jwt not found" {
+			t.Errorf("%s was answered without a token: %+v", query, resp)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", s.URL+"/graphql", strings.NewReader(`{"query": "{ me { email } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Authentication-Token", "invalid")
+
+	resp, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	response := graphQLResponse{}
+	json.NewDecoder(resp.Body).Decode(&response)
+
+	if response.code() != "UNAUTHENTICATED" || response.Errors[0].Message != "invalid jwt" {
+		t.Fatalf("unexpected response to an invalid token: %+v", response)
+	}
+}
+
+func TestGraphQL_Batching(t *testing.T) {
+	mockUsers, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	mockUsers.ClearCalls()
+
+	_, resp := graphQL(t, s.URL, "jackson@juandefu.ca", `{
+		me { email }
+		a: user(email: "admin@sabey.co") { email }
+		b: user(email: "someone@else.com") { email }
+		c: user(email: "admin@sabey.co") { firstName }
+		missing: user(email: "missing@sabey.co") { email }
+	}`, nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", resp.Errors)
+	}
+
+	if fmt.Sprint(resp.Data) != "map[a:map[email:admin@sabey.co] b:map[email:someone@else.com] c:map[firstName:Admin] me:map[email:jackson@juandefu.ca] missing:<nil>]" {
+		t.Fatalf("unexpected data: %v", resp.Data)
+	}
+
+	// the token's account is checked with a Get, every account is looked up by one List
+	calls := mockUsers.Calls()
+	if len(calls) != 2 || calls[0].Method != mock.MethodGet || calls[1].Method != mock.MethodList {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+
+	if emails := calls[1].Opts.(ddd.UserList).Emails; len(emails) != 4 {
+		t.Fatalf("unexpected batch: %v", emails)
+	}
+}
+
+func TestGraphQL_Users(t *testing.T) {
+	_, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	query := `query($after: String) {
+		users(first: 2, after: $after) {
+			edges { cursor node { email } }
+			pageInfo { hasNextPage endCursor }
+		}
+	}`
+
+	_, resp := graphQL(t, s.URL, "jackson@juandefu.ca", query, nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", resp.Errors)
+	}
+
+	users := resp.Data["users"].(map[string]interface{})
+	pageInfo := users["pageInfo"].(map[string]interface{})
+
+	if len(users["edges"].([]interface{})) != 2 || pageInfo["hasNextPage"] != true {
+		t.Fatalf("unexpected first page: %v", users)
+	}
+
+	_, resp = graphQL(t, s.URL, "jackson@juandefu.ca", query, map[string]interface{}{"after": pageInfo["endCursor"]})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", resp.Errors)
+	}
+
+	if fmt.Sprint(resp.Data["users"]) != "map[edges:[map[cursor:c29tZW9uZUBlbHNlLmNvbQ node:map[email:someone@else.com]]] pageInfo:map[endCursor:c29tZW9uZUBlbHNlLmNvbQ hasNextPage:false]]" {
+		t.Fatalf("unexpected second page: %v", resp.Data["users"])
+	}
+
+	for filter, expected := range map[string]string{
+		`{search: "JUAN"}`:                "[map[email:jackson@juandefu.ca] map[email:someone@else.com]]",
+		`{admin: true}`:                   "[map[email:admin@sabey.co]]",
+		`{search: "sabey", admin: false}`: "[map[email:jackson@juandefu.ca]]",
+	} {
+		_, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `{ users(filter: `+filter+`) { nodes { email } } }`, nil)
+		if len(resp.Errors) > 0 {
+			t.Fatalf("unexpected errors: %+v", resp.Errors)
+		}
+
+		if nodes := fmt.Sprint(resp.Data["users"].(map[string]interface{})["nodes"]); nodes != expected {
+			t.Errorf("unexpected users for %s: %s", filter, nodes)
+		}
+	}
+
+	for _, args := range []string{`first: 0`, `first: 1001`, `after: "@@@"`} {
+		_, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `{ users(`+args+`) { nodes { email } } }`, nil)
+		if resp.code() != "BAD_USER_INPUT" {
+			t.Errorf("%s wasn't rejected: %+v", args, resp)
+		}
+	}
+}
+
+func TestGraphQL_SignupLogin(t *testing.T) {
+	_, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	_, resp := graphQL(t, s.URL, "", `mutation {
+		signup(input: {email: "new@sabey.co", firstName: "New", lastName: "Sabey", password: "pass"}) { token user { email version } }
+	}`, nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", resp.Errors)
+	}
+
+	signup := resp.Data["signup"].(map[string]interface{})
+	if ddd.ParseJWTClaims(signup["token"].(string)) != "new@sabey.co" {
+		t.Fatalf("unexpected token: %v", signup["token"])
+	}
+
+	_, resp = graphQL(t, s.URL, "", `mutation {
+		signup(input: {email: "new@sabey.co", firstName: "New", lastName: "Sabey", password: "pass"}) { token }
+	}`, nil)
+	if resp.code() != "ALREADY_EXISTS" {
+		t.Fatalf("signed up twice: %+v", resp)
+	}
+
+	_, resp = graphQL(t, s.URL, "", `mutation($password: String!) { login(email: "new@sabey.co", password: $password) { token } }`, map[string]interface{}{"password": "pass"})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", resp.Errors)
+	}
+
+	if token := resp.Data["login"].(map[string]interface{})["token"].(string); ddd.ParseJWTClaims(token) != "new@sabey.co" {
+		t.Fatalf("unexpected token: %s", token)
+	}
+
+	_, resp = graphQL(t, s.URL, "", `mutation { login(email: "new@sabey.co", password: "wrong") { token } }`, nil)
+	if resp.code() != "UNAUTHENTICATED" {
+		t.Fatalf("logged in with a wrong password: %+v", resp)
+	}
+
+	_, resp = graphQL(t, s.URL, "", `mutation { login(email: "new@sabey.co", password: "") { token } }`, nil)
+	if resp.code() != "BAD_USER_INPUT" {
+		t.Fatalf("logged in without a password: %+v", resp)
+	}
+}
+
+func TestGraphQL_UpdateProfile(t *testing.T) {
+	mockUsers, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	_, resp := graphQL(t, s.URL, "jackson@juandefu.ca", `mutation {
+		updateProfile(input: {lastName: "SABEY", displayName: "Jack", version: 1}) { lastName displayName version }
+	}`, nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", resp.Errors)
+	}
+
+	if fmt.Sprint(resp.Data["updateProfile"]) != "map[displayName:Jack lastName:SABEY version:2]" {
+		t.Fatalf("unexpected user: %v", resp.Data["updateProfile"])
+	}
+
+	_, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `mutation { updateProfile(input: {lastName: "Sabey", version: 1}) { version } }`, nil)
+	if resp.code() != "VERSION_MISMATCH" {
+		t.Fatalf("updated a stale version: %+v", resp)
+	}
+
+	_, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `mutation { updateProfile(input: {firstName: ""}) { version } }`, nil)
+	if resp.code() != "BAD_USER_INPUT" {
+		t.Fatalf("updated with an empty first name: %+v", resp)
+	}
+
+	// nothing to update returns the current account
+	_, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `mutation { updateProfile(input: {}) { version } }`, nil)
+	if fmt.Sprint(resp.Data["updateProfile"]) != "map[version:2]" {
+		t.Fatalf("unexpected user: %+v", resp)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.LastName != "SABEY" {
+		t.Fatalf("unexpected stored last name: %s", user.LastName)
+	}
+}
+
+func TestGraphQL_Limits(t *testing.T) {
+	_, s := newGraphQLServer(HTTPServiceOpts{
+		GraphQLMaxDepth:      3,
+		GraphQLMaxComplexity: 50,
+	})
+	defer s.Close()
+
+	status, resp := graphQL(t, s.URL, "jackson@juandefu.ca", `{ users(first: 10) { edges { node { email } } } }`, nil)
+	if status != http.StatusBadRequest || resp.code() != "DEPTH_LIMIT" {
+		t.Fatalf("a query deeper than the limit ran: %d %+v", status, resp)
+	}
+
+	// 1 + 30 * (1 + 1)
+	status, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `query($first: Int) { users(first: $first) { nodes { email } } }`, map[string]interface{}{"first": 30})
+	if status != http.StatusBadRequest || resp.code() != "COMPLEXITY_LIMIT" || resp.Errors[0].Message != "query complexity 61 exceeds the limit of 50" {
+		t.Fatalf("a query more complex than the limit ran: %d %+v", status, resp)
+	}
+
+	// fragments count where they're spread
+	status, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `
+		{ users(first: 10) { nodes { ...names } } }
+		fragment names on User { email firstName lastName displayName locale }
+	`, nil)
+	if status != http.StatusBadRequest || resp.code() != "COMPLEXITY_LIMIT" {
+		t.Fatalf("a query more complex than the limit ran: %d %+v", status, resp)
+	}
+
+	status, resp = graphQL(t, s.URL, "jackson@juandefu.ca", `{ users(first: 10) { nodes { email } } }`, nil)
+	if status != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("unexpected response: %d %+v", status, resp)
+	}
+
+	// introspection isn't counted
+	status, resp = graphQL(t, s.URL, "", `{ __schema { types { name fields { name type { name ofType { name } } } } } }`, nil)
+	if status != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("unexpected introspection response: %d %+v", status, resp)
+	}
+}
+
+func TestGraphQL_InvalidRequest(t *testing.T) {
+	_, s := newGraphQLServer(HTTPServiceOpts{})
+	defer s.Close()
+
+	for _, query := range []string{
+		`{ me { email `,
+		`{ me { password } }`,
+		`{ users(first: "ten") { nodes { email } } }`,
+	} {
+		status, resp := graphQL(t, s.URL, "jackson@juandefu.ca", query, nil)
+		if status != http.StatusBadRequest || len(resp.Errors) == 0 {
+			t.Errorf("%s wasn't rejected: %d %+v", query, status, resp)
+		}
+	}
+
+	resp, err := http.Post(s.URL+"/graphql", "application/json", strings.NewReader(`{"query": 1}`))
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+// newSeededServer serves a mock with jackson@juandefu.ca and the admin admin@sabey.co, both with the password "pass"
+func newSeededServer() (*mock.UserRepository, *httptest.Server) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{
+			Email:     "jackson@juandefu.ca",
+			FirstName: "Jackson",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+		},
+		ddd.User{
+			Email:     "admin@sabey.co",
+			FirstName: "Admin",
+			LastName:  "Sabey",
+			Password:  ddd.HashPassword("pass"),
+			Admin:     true,
+		},
+	)
+
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mockUsers,
+		),
+	)
+
+	return mockUsers, ts
+}
+
+// sendRequest sends body with the token, no token is sent when it's empty
+// headers are name and value pairs, the redirects aren't followed so the tests can read them
+func sendRequest(t *testing.T, method, url, token, body string, headers ...string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create new http request: %s", err)
+	}
+
+	if token != "" {
+		req.Header.Add("X-Authentication-Token", token)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %s", err)
+	}
+
+	return resp, string(bs)
+}
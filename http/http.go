@@ -1,46 +1,654 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/graphql-go/graphql"
 	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/apikey"
+	"github.com/sabey/ddd/blob"
+	"github.com/sabey/ddd/federation"
+	"github.com/sabey/ddd/logging"
+	"github.com/sabey/ddd/metrics"
+	"github.com/sabey/ddd/oauth"
+	"github.com/sabey/ddd/passkey"
+	"github.com/sabey/ddd/totp"
+	"github.com/sabey/ddd/tracing"
 )
 
 func NewHTTPService(
 	userRepo ddd.UserRepository,
 ) http.Handler {
-	return httpService{
-		userRepo: userRepo,
+	return NewHTTPServiceWithOpts(
+		HTTPServiceOpts{
+			UserRepository: userRepo,
+		},
+	)
+}
+
+type HTTPServiceOpts struct {
+	UserRepository ddd.UserRepository
+	// UncachedUserRepository is read by the disabled account checks of tokens, second factors and passkey logins, by the admin checks and by the api keyring
+	// it's the UserRepository when nil
+	// it mustn't be a cache (cache.NewUserRepository), a disabled account would keep working until its entry expires
+	UncachedUserRepository ddd.UserRepository
+	// OrganizationRepository serves /organizations and the invitations, it's the UserRepository when that's one too
+	// without one the organization routes and invited signups respond 501
+	OrganizationRepository ddd.OrganizationRepository
+	// TOTP checks the second factor of logins, one with a random key is created when the UserRepository is a ddd.TOTPRepository
+	// without either the two-factor routes respond 501 and logins only need a password
+	TOTP *totp.Authenticator
+	// Passkeys registers passkeys and logs accounts in with them, one for passkey.DefaultOrigin is created when the UserRepository is a ddd.PasskeyRepository
+	// without either the passkey routes respond 501
+	Passkeys *passkey.RelyingParty
+	// OAuth is the OAuth 2.1 and OpenID Connect provider, one for oauth.DefaultIssuer is created when the UserRepository is a ddd.OAuthRepository
+	// without either the oauth routes and the discovery document respond 501
+	OAuth *oauth.Provider
+	// Federation logs accounts in through external OpenID Connect identity providers, the providers are configured so it isn't created
+	// without it the federated login routes respond 501
+	Federation *federation.Federation
+	// APIKeys authenticates requests sent with an api key instead of a jwt, one is created when the UserRepository is a ddd.APIKeyRepository
+	// without either the /users/me/tokens routes respond 501 and keys are rejected like invalid jwts
+	APIKeys *apikey.Keyring
+	// Metrics is served on /metrics, a private registry is created when nil
+	Metrics *metrics.Registry
+	// Tracer is optional, nothing is traced when nil
+	Tracer *tracing.Tracer
+	// Logger writes the access log and handler errors, slog.Default() is used when nil
+	Logger *slog.Logger
+	// RequestTimeout is the deadline of every request's context, zero means no deadline
+	RequestTimeout time.Duration
+	// BlobStore holds the avatars, an in-memory store is created when nil
+	BlobStore ddd.BlobStore
+	// ValidateRequests rejects requests that don't match the OpenAPI spec served on /openapi.json before they reach a handler
+	ValidateRequests bool
+	// ValidateResponses replaces responses that don't match the spec with a 500, it's meant for tests
+	ValidateResponses bool
+	// GraphQLMaxDepth and GraphQLMaxComplexity reject /graphql queries before they run, DefaultGraphQLMaxDepth and DefaultGraphQLMaxComplexity when zero
+	GraphQLMaxDepth      int
+	GraphQLMaxComplexity int
+}
+
+func NewHTTPServiceWithOpts(
+	opts HTTPServiceOpts,
+) http.Handler {
+	if opts.OrganizationRepository == nil {
+		opts.OrganizationRepository, _ = opts.UserRepository.(ddd.OrganizationRepository)
+	}
+
+	if opts.UncachedUserRepository == nil {
+		opts.UncachedUserRepository = opts.UserRepository
+	}
+
+	if opts.TOTP == nil {
+		if totpRepo, ok := opts.UserRepository.(ddd.TOTPRepository); ok {
+			// a random key can only fail to be read from the system
+			a, err := totp.NewAuthenticator(totp.AuthenticatorOpts{Repository: totpRepo})
+			if err != nil {
+				panic(fmt.Sprintf("failed to create totp authenticator: %s", err))
+			}
+			opts.TOTP = a
+		}
+	}
+
+	if opts.Passkeys == nil {
+		if passkeyRepo, ok := opts.UserRepository.(ddd.PasskeyRepository); ok {
+			// the defaults are valid, it can't fail
+			rp, err := passkey.NewRelyingParty(passkey.RelyingPartyOpts{Repository: passkeyRepo})
+			if err != nil {
+				panic(fmt.Sprintf("failed to create passkey relying party: %s", err))
+			}
+			opts.Passkeys = rp
+		}
+	}
+
+	if opts.OAuth == nil {
+		if oauthRepo, ok := opts.UserRepository.(ddd.OAuthRepository); ok {
+			// the defaults are valid, it can't fail
+			p, err := oauth.NewProvider(oauth.ProviderOpts{Repository: oauthRepo, Users: opts.UserRepository})
+			if err != nil {
+				panic(fmt.Sprintf("failed to create oauth provider: %s", err))
+			}
+			opts.OAuth = p
+		}
+	}
+
+	if opts.APIKeys == nil {
+		if apiKeyRepo, ok := opts.UserRepository.(ddd.APIKeyRepository); ok {
+			// both repositories are set, it can't fail
+			k, err := apikey.NewKeyring(apikey.KeyringOpts{Repository: apiKeyRepo, Users: opts.UncachedUserRepository})
+			if err != nil {
+				panic(fmt.Sprintf("failed to create api keyring: %s", err))
+			}
+			opts.APIKeys = k
+		}
+	}
+
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.NewRegistry()
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	if opts.BlobStore == nil {
+		opts.BlobStore = blob.NewMemoryStore()
 	}
+
+	if opts.GraphQLMaxDepth <= 0 {
+		opts.GraphQLMaxDepth = DefaultGraphQLMaxDepth
+	}
+
+	if opts.GraphQLMaxComplexity <= 0 {
+		opts.GraphQLMaxComplexity = DefaultGraphQLMaxComplexity
+	}
+
+	// the spec is embedded, it can only fail to parse in development
+	openAPI, err := newOpenAPIValidator(openAPISpec)
+	if err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %s", err))
+	}
+
+	srv := httpService{
+		userRepo:         opts.UserRepository,
+		uncachedUserRepo: opts.UncachedUserRepository,
+		orgRepo:          opts.OrganizationRepository,
+		totp:             opts.TOTP,
+		passkeys:         opts.Passkeys,
+		oauth:            opts.OAuth,
+		federation:       opts.Federation,
+		apiKeys:          opts.APIKeys,
+		registry:         opts.Metrics,
+		metrics:          newHTTPMetrics(opts.Metrics),
+		tracer:           opts.Tracer,
+		log:              opts.Logger,
+		timeout:          opts.RequestTimeout,
+		blobs:            opts.BlobStore,
+		graphQLLimits: graphQLLimits{
+			maxDepth:      opts.GraphQLMaxDepth,
+			maxComplexity: opts.GraphQLMaxComplexity,
+		},
+	}
+
+	// the schema is built in code, it can only be invalid in development
+	srv.graphQL, err = newGraphQLSchema(srv)
+	if err != nil {
+		panic(fmt.Sprintf("invalid graphql schema: %s", err))
+	}
+
+	if opts.ValidateRequests {
+		srv.openAPI = openAPI
+	}
+
+	if opts.ValidateResponses || validateAllResponses {
+		return responseValidator{
+			next:    srv,
+			openAPI: openAPI,
+		}
+	}
+
+	return srv
 }
 
 type httpService struct {
 	userRepo ddd.UserRepository
+	// uncachedUserRepo checks that accounts weren't disabled, it's never served from a cache
+	uncachedUserRepo ddd.UserRepository
+	// orgRepo is nil when organizations aren't served
+	orgRepo ddd.OrganizationRepository
+	// totp is nil when two-factor authentication isn't served
+	totp *totp.Authenticator
+	// passkeys is nil when passkeys aren't served
+	passkeys *passkey.RelyingParty
+	// oauth is nil when the oauth provider isn't served
+	oauth *oauth.Provider
+	// federation is nil when federated login isn't served
+	federation *federation.Federation
+	// apiKeys is nil when api keys aren't served
+	apiKeys  *apikey.Keyring
+	registry *metrics.Registry
+	metrics  *httpMetrics
+	tracer   *tracing.Tracer
+	log      *slog.Logger
+	timeout  time.Duration
+	blobs    ddd.BlobStore
+	// openAPI is nil unless requests are validated
+	openAPI       *openAPIValidator
+	graphQL       *graphql.Schema
+	graphQLLimits graphQLLimits
 }
 
 func (srv httpService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("ServeHTTP.route: %s %s\n", r.Method, r.URL.Path)
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+	info := &requestInfo{
+		requestID: requestID(r),
+	}
+	// echoed on every response, including errors
+	w.Header().Set(requestIDHeader, info.requestID)
+
+	ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+
+	// the token's organization scopes every repository call, an invalid token is rejected by authenticate
+	if tenant, _ := ddd.ParseTenantJWTClaims(r.Header.Get("X-Authentication-Token")); tenant != "" {
+		ctx = ddd.WithTenant(ctx, tenant)
+	}
+
+	// the deadline is passed down to the repository, and from there to postgres
+	if srv.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.timeout)
+		defer cancel()
+	}
+
+	// continue the caller's trace when a valid w3c traceparent was sent
+	if sc, err := tracing.ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+		ctx = tracing.ContextWithSpanContext(ctx, sc)
+	}
+
+	ctx, span := srv.tracer.Start(ctx, r.Method, tracing.SpanKindServer)
+
+	logger := srv.log.With("request_id", info.requestID)
+	if sc := span.SpanContext(); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID.String())
+	}
+	ctx = logging.ContextWithLogger(ctx, logger)
+
+	// an api key is looked up once, its organization scopes every repository call like a jwt's, authenticate rejects it when it failed
+	if token := r.Header.Get("X-Authentication-Token"); srv.apiKeys != nil && ddd.APIKeyID(token) != "" {
+		key, err := srv.apiKeys.Authenticate(ctx, token, remoteIP(r))
+		if err == nil {
+			ctx = ddd.WithTenant(ctx, key.Organization)
+		}
+
+		ctx = context.WithValue(ctx, apiKeyAuthKey{}, &apiKeyAuth{key: key, err: err})
+	}
+
+	route := srv.route(sw, r.WithContext(ctx))
+
+	span.SetName(r.Method + " " + route)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.route", route)
+	span.SetAttribute("http.status_code", sw.status)
+	if sw.status >= 500 {
+		span.SetError(fmt.Errorf("%d %s", sw.status, http.StatusText(sw.status)))
+	}
+	span.End()
+
+	duration := time.Since(start)
+
+	srv.metrics.requestDuration.With(route, r.Method, strconv.Itoa(sw.status)).Observe(duration.Seconds())
+
+	level := slog.LevelInfo
+	if sw.status >= 500 {
+		level = slog.LevelError
+	}
+
+	logger.LogAttrs(ctx, level, "http request",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("route", route),
+		slog.Int("status", sw.status),
+		slog.Int64("bytes", sw.bytes),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("user_agent", r.UserAgent()),
+		slog.String("user_email", info.user),
+		slog.String("user_organization", info.organization),
+	)
+}
+
+// route dispatches the request and returns the matched route, this is used as a metric label
+func (srv httpService) route(w http.ResponseWriter, r *http.Request) string {
+	if srv.openAPI != nil {
+		if route, err := srv.openAPI.validateRequest(r); err != nil {
+			status := http.StatusBadRequest
+			if e, ok := err.(*openAPIError); ok {
+				status = e.status
+			}
+
+			writeError(w, status, err)
+
+			return route
+		}
+	}
 
 	if r.URL.Path == "/signup" && r.Method == "POST" {
 		srv.Signup(w, r)
 
-		return
+		return "/signup"
 	} else if r.URL.Path == "/login" && r.Method == "POST" {
 		srv.Login(w, r)
 
-		return
+		return "/login"
+	} else if r.URL.Path == "/login/mfa" && r.Method == "POST" {
+		srv.LoginMFA(w, r)
+
+		return "/login/mfa"
+	} else if r.URL.Path == "/login/passkey/options" && r.Method == "POST" {
+		srv.BeginPasskeyLogin(w, r)
+
+		return "/login/passkey/options"
+	} else if r.URL.Path == "/login/passkey" && r.Method == "POST" {
+		srv.LoginPasskey(w, r)
+
+		return "/login/passkey"
+	} else if r.URL.Path == "/login/oidc" && r.Method == "GET" {
+		srv.ListFederationProviders(w, r)
+
+		return "/login/oidc"
+	} else if r.URL.Path == "/login/oidc/options" && r.Method == "POST" {
+		srv.BeginFederationLogin(w, r)
+
+		return "/login/oidc/options"
+	} else if r.URL.Path == "/login/oidc" && r.Method == "POST" {
+		srv.LoginFederation(w, r)
+
+		return "/login/oidc"
 	} else if r.URL.Path == "/users" && r.Method == "GET" {
 		srv.ListUsers(w, r)
 
-		return
+		return "/users"
+	} else if r.URL.Path == "/users/me" && r.Method == "GET" {
+		srv.GetMe(w, r)
+
+		return "/users/me"
 	} else if r.URL.Path == "/users" && r.Method == "PUT" {
 		srv.UpdateUser(w, r)
 
-		return
+		return "/users"
+	} else if r.URL.Path == "/users" && r.Method == "PATCH" {
+		srv.PatchMe(w, r)
+
+		return "/users"
+	} else if r.URL.Path == "/users/me/avatar" && r.Method == "PUT" {
+		srv.PutAvatar(w, r)
+
+		return "/users/me/avatar"
+	} else if r.URL.Path == "/users/me/2fa/totp" && r.Method == "POST" {
+		srv.EnrollTOTP(w, r)
+
+		return "/users/me/2fa/totp"
+	} else if r.URL.Path == "/users/me/2fa/totp/confirm" && r.Method == "POST" {
+		srv.ConfirmTOTP(w, r)
+
+		return "/users/me/2fa/totp/confirm"
+	} else if r.URL.Path == "/users/me/identities" && r.Method == "GET" {
+		srv.ListIdentities(w, r)
+
+		return "/users/me/identities"
+	} else if r.URL.Path == "/users/me/tokens" && r.Method == "POST" {
+		srv.CreateAPIKey(w, r)
+
+		return "/users/me/tokens"
+	} else if r.URL.Path == "/users/me/tokens" && r.Method == "GET" {
+		srv.ListAPIKeys(w, r)
+
+		return "/users/me/tokens"
+	} else if strings.HasPrefix(r.URL.Path, "/users/me/tokens/") && r.Method == "DELETE" {
+		srv.DeleteAPIKey(w, r)
+
+		return "/users/me/tokens/{id}"
+	} else if r.URL.Path == "/users/me/passkeys" && r.Method == "GET" {
+		srv.ListPasskeys(w, r)
+
+		return "/users/me/passkeys"
+	} else if r.URL.Path == "/users/me/passkeys/register/options" && r.Method == "POST" {
+		srv.BeginPasskeyRegistration(w, r)
+
+		return "/users/me/passkeys/register/options"
+	} else if r.URL.Path == "/users/me/passkeys/register" && r.Method == "POST" {
+		srv.RegisterPasskey(w, r)
+
+		return "/users/me/passkeys/register"
+	} else if strings.HasPrefix(r.URL.Path, "/users/me/passkeys/") && r.Method == "DELETE" {
+		srv.DeletePasskey(w, r)
+
+		return "/users/me/passkeys/{id}"
+	} else if r.URL.Path == "/users/import" && r.Method == "POST" {
+		srv.ImportUsers(w, r)
+
+		return "/users/import"
+	} else if r.URL.Path == "/users/export" && r.Method == "GET" {
+		srv.ExportUsers(w, r)
+
+		return "/users/export"
+	} else if strings.HasPrefix(r.URL.Path, "/users/") && strings.HasSuffix(r.URL.Path, "/avatar") && r.Method == "GET" {
+		srv.GetAvatar(w, r)
+
+		return "/users/{id}/avatar"
+	} else if strings.HasPrefix(r.URL.Path, "/users/") && strings.HasSuffix(r.URL.Path, "/2fa") && r.Method == "DELETE" {
+		srv.ResetTOTP(w, r)
+
+		return "/users/{id}/2fa"
+	} else if strings.HasPrefix(r.URL.Path, "/users/") && r.Method == "PATCH" {
+		srv.PatchUser(w, r)
+
+		return "/users/{id}"
+	} else if r.URL.Path == "/organizations" && r.Method == "POST" {
+		srv.CreateOrganization(w, r)
+
+		return "/organizations"
+	} else if r.URL.Path == "/organizations" && r.Method == "GET" {
+		srv.ListOrganizations(w, r)
+
+		return "/organizations"
+	} else if strings.HasPrefix(r.URL.Path, "/organizations/") && strings.HasSuffix(r.URL.Path, "/invitations") && r.Method == "POST" {
+		srv.CreateInvitation(w, r)
+
+		return "/organizations/{id}/invitations"
+	} else if strings.HasPrefix(r.URL.Path, "/organizations/") && r.Method == "GET" {
+		srv.GetOrganization(w, r)
+
+		return "/organizations/{id}"
+	} else if r.URL.Path == "/.well-known/openid-configuration" && r.Method == "GET" {
+		srv.OpenIDConfiguration(w, r)
+
+		return "/.well-known/openid-configuration"
+	} else if r.URL.Path == "/oauth/authorize" && r.Method == "GET" {
+		srv.Authorize(w, r)
+
+		return "/oauth/authorize"
+	} else if r.URL.Path == "/oauth/authorize" && r.Method == "POST" {
+		srv.Consent(w, r)
+
+		return "/oauth/authorize"
+	} else if r.URL.Path == "/oauth/token" && r.Method == "POST" {
+		srv.Token(w, r)
+
+		return "/oauth/token"
+	} else if r.URL.Path == "/oauth/userinfo" && (r.Method == "GET" || r.Method == "POST") {
+		srv.UserInfo(w, r)
+
+		return "/oauth/userinfo"
+	} else if r.URL.Path == "/oauth/revoke" && r.Method == "POST" {
+		srv.Revoke(w, r)
+
+		return "/oauth/revoke"
+	} else if r.URL.Path == "/oauth/clients" && r.Method == "POST" {
+		srv.CreateOAuthClient(w, r)
+
+		return "/oauth/clients"
+	} else if r.URL.Path == "/oauth/clients" && r.Method == "GET" {
+		srv.ListOAuthClients(w, r)
+
+		return "/oauth/clients"
+	} else if strings.HasPrefix(r.URL.Path, "/oauth/clients/") && r.Method == "DELETE" {
+		srv.DeleteOAuthClient(w, r)
+
+		return "/oauth/clients/{id}"
+	} else if r.URL.Path == "/graphql" && r.Method == "POST" {
+		srv.GraphQL(w, r)
+
+		return "/graphql"
+	} else if r.URL.Path == "/openapi.json" && r.Method == "GET" {
+		srv.OpenAPI(w, r)
+
+		return "/openapi.json"
+	} else if r.URL.Path == "/metrics" && r.Method == "GET" {
+		srv.registry.ServeHTTP(w, r)
+
+		return "/metrics"
 	}
 	// 404
 	w.WriteHeader(http.StatusNotFound)
 	fmt.Fprintf(w, `{"error":"404"}`)
+
+	// unmatched paths share a label so they can't blow up the metric cardinality
+	return "unmatched"
+}
+
+// authenticate returns the email claim of the request's jwt, or the account of its api key, the tenant is already the context's tenant
+// an empty string is returned when the jwt or key is missing or invalid, or its account was disabled, and the error has already been written
+func (srv httpService) authenticate(w http.ResponseWriter, r *http.Request) string {
+	email := srv.authenticateToken(w, r)
+	if email == "" {
+		return ""
+	}
+
+	if err := srv.checkAccount(r.Context(), email); err != nil {
+		srv.logger(r).Warn("rejected token", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return ""
+	}
+
+	return email
+}
+
+// checkAccount returns ddd.ErrUserDisabled when a token's account was disabled after the token was given
+// tokens don't expire, so every request looks the account up, an account that doesn't exist is left to the handler
+func (srv httpService) checkAccount(ctx context.Context, email string) error {
+	user, err := srv.uncachedUserRepo.Get(ctx, email)
+	if errors.Is(err, ddd.ErrUserNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if user.Disabled {
+		return ddd.ErrUserDisabled
+	}
+
+	return nil
+}
+
+// authenticateToken is authenticate without the account lookup
+func (srv httpService) authenticateToken(w http.ResponseWriter, r *http.Request) string {
+	if auth := apiKeyAuthFrom(r.Context()); auth != nil {
+		return srv.authenticateAPIKey(w, r, auth)
+	}
+
+	jwt := r.Header.Get("X-Authentication-Token")
+	if jwt == "" {
+		srv.metrics.tokenFailures.With("missing").Inc()
+
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"jwt not found"}`)
+
+		return ""
+	}
+
+	// validate jwt
+	tenant, email := ddd.ParseTenantJWTClaims(jwt)
+	if email == "" {
+		srv.metrics.tokenFailures.With("invalid").Inc()
+
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid jwt"}`)
+
+		return ""
+	}
+
+	srv.setUser(r, tenant, email)
+
+	return email
+}
+
+// requireAdmin returns true when the authenticated account is an admin of its organization, an api key also needs the admin scope
+// false is returned after the error has been written
+func (srv httpService) requireAdmin(w http.ResponseWriter, r *http.Request, email string) bool {
+	if auth := apiKeyAuthFrom(r.Context()); auth != nil && !auth.key.HasScope(ddd.APIKeyScopeAdmin) {
+		// 403
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error":"forbidden"}`)
+
+		return false
+	}
+
+	// a demoted admin's tokens and keys lose the admin routes at once, the account isn't read from a cache
+	user, err := srv.uncachedUserRepo.Get(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to get user", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return false
+	}
+
+	if !user.Admin {
+		// 403
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error":"forbidden"}`)
+
+		return false
+	}
+
+	return true
+}
+
+// writeError writes `{"error":"..."}`, the message is escaped since it may echo the request
+func writeError(w http.ResponseWriter, status int, err error) {
+	bs, _ := json.Marshal(map[string]string{
+		"error": err.Error(),
+	})
+
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s", bs)
+}
+
+// writeRepositoryError writes a failed repository call, a timed out request isn't the client's fault
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		// 504
+		w.WriteHeader(http.StatusGatewayTimeout)
+		fmt.Fprintf(w, `{"error":"request timed out"}`)
+
+		return
+	}
+
+	if errors.Is(err, ddd.ErrUserDisabled) {
+		// 403
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+
+		return
+	}
+
+	if errors.Is(err, ddd.ErrVersionMismatch) {
+		// 412
+		w.WriteHeader(http.StatusPreconditionFailed)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+
+		return
+	}
+
+	// 400 - we should be able to override this status code but we will use this for now
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `{"error":"%s"}`, err)
 }
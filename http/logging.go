@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/sabey/ddd/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo is filled in while a request is handled, and read back by the access log
+type requestInfo struct {
+	requestID string
+	// user is the authenticated account, empty for anonymous requests
+	user string
+	// organization is the authenticated account's tenant
+	organization string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+
+	return &requestInfo{}
+}
+
+// requestID propagates the caller's X-Request-ID, or generates a new one when it's missing or unsafe to log
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		// printable ascii without quotes or spaces
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setUser records the authenticated user for the access log
+func (srv httpService) setUser(r *http.Request, organization string, email string) {
+	info := requestInfoFromContext(r.Context())
+	info.user = email
+	info.organization = organization
+}
+
+// logger returns the request scoped logger, it carries the request id
+func (srv httpService) logger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/logging"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestLogging_RequestID(t *testing.T) {
+	ts := httptest.NewServer(
+		NewHTTPServiceWithOpts(
+			HTTPServiceOpts{
+				UserRepository: mock.NewUserRepository(),
+				Logger:         logging.Discard(),
+			},
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/404", ts.URL), nil)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	if len(resp.Header.Get("X-Request-ID")) != 32 {
+		t.Errorf("request id wasn't generated: `%s`", resp.Header.Get("X-Request-ID"))
+	}
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/404", ts.URL), nil)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Add("X-Request-ID", "caller-request-id")
+
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Request-ID") != "caller-request-id" {
+		t.Errorf("request id wasn't propagated: `%s`", resp.Header.Get("X-Request-ID"))
+	}
+}
+
+func TestLogging_AccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	logger, err := logging.NewLogger(
+		logging.LoggerOpts{
+			Output: buf,
+		},
+	)
+	if err != nil {
+		t.Errorf("failed to build logger: %s", err)
+	}
+
+	ts := httptest.NewServer(
+		NewHTTPServiceWithOpts(
+			HTTPServiceOpts{
+				UserRepository: mock.NewUserRepository(),
+				Logger:         logger,
+			},
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/users", ts.URL), nil)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	jwt := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+	req.Header.Add("X-Authentication-Token", jwt)
+	req.Header.Add("X-Request-ID", "caller-request-id")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	if strings.Contains(buf.String(), jwt) {
+		t.Errorf("jwt was logged: `%s`", buf.String())
+	}
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Errorf("failed to decode access log: %s", err)
+	}
+
+	if record["msg"] != "http request" {
+		t.Errorf("unknown message: %v", record["msg"])
+	}
+
+	if record["request_id"] != "caller-request-id" {
+		t.Errorf("unknown request id: %v", record["request_id"])
+	}
+
+	if record["route"] != "/users" || record["status"] != float64(200) {
+		t.Errorf("unknown route or status: %v %v", record["route"], record["status"])
+	}
+
+	if record["user_email"] != "j***@juandefu.ca" {
+		t.Errorf("unknown user: %v", record["user_email"])
+	}
+}
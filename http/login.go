@@ -18,7 +18,13 @@ curl --header "Content-Type: application/json" \
 func (srv httpService) Login(w http.ResponseWriter, r *http.Request) {
 	request := &LoginRequest{}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
 		// 400
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, `{"error":"invalid request"}`)
@@ -27,28 +33,64 @@ func (srv httpService) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := request.Validate(); err != nil {
-		// 400
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400, the organization is echoed
+		writeError(w, http.StatusBadRequest, err)
 
 		return
 	}
 
+	password := srv.hashPassword(r, request.Password)
+
+	tenant := request.Organization
+	if tenant == "" {
+		tenant = ddd.DefaultOrganization
+	}
+
 	user, err := srv.userRepo.Login(
+		ddd.WithTenant(r.Context(), tenant),
 		ddd.UserLogin{
 			Email:    request.Email,
-			Password: ddd.HashPassword(request.Password),
+			Password: password,
 		},
 	)
 	if err != nil {
-		// 400 - we should be able to override this status code but we will use this for now
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		srv.metrics.logins.With("failure").Inc()
+
+		srv.logger(r).Warn("login failed", "email", request.Email, "error", err)
+
+		writeRepositoryError(w, err)
 
 		return
 	}
 
-	jwt := ddd.SignJWTClaims(user.Email)
+	if srv.totp != nil {
+		required, err := srv.totp.Required(ddd.WithTenant(r.Context(), tenant), user.Email)
+		if err != nil {
+			srv.metrics.logins.With("failure").Inc()
+
+			srv.logger(r).Warn("login failed", "email", request.Email, "error", err)
+
+			writeRepositoryError(w, err)
+
+			return
+		}
+
+		if required {
+			// the password was accepted, the token is only signed once /login/mfa accepts a code
+			srv.setUser(r, user.Organization, user.Email)
+
+			fmt.Fprintf(w, `{"mfaToken":"%s"}`, ddd.SignMFAChallenge(user.Organization, user.Email))
+
+			return
+		}
+	}
+
+	srv.metrics.logins.With("success").Inc()
+	srv.setUser(r, user.Organization, user.Email)
+
+	jwt := ddd.SignTenantJWTClaims(user.Organization, user.Email)
 
 	fmt.Fprintf(w, `{"token":"%s"}`, jwt)
 }
@@ -158,14 +158,60 @@ func TestLogin_UserNotFound(t *testing.T) {
 	}
 }
 
-func TestLogin_Success(t *testing.T) {
+func TestLogin_Disabled(t *testing.T) {
 	mockUsers := mock.NewUserRepository()
-	mockUsers.Accounts["jackson@juandefu.ca"] = ddd.User{
+	mockUsers.Seed(ddd.User{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  ddd.HashPassword("pass"),
+		Disabled:  true,
+	})
+
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mockUsers,
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	reqBody := strings.NewReader(`{"email":"jackson@juandefu.ca","password":"pass"}`)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/login", ts.URL), reqBody)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
 	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %s", err)
+	}
+
+	if resp.StatusCode != 403 {
+		t.Errorf("route failed?")
+	}
+
+	if string(body) != `{"error":"user account is disabled"}` {
+		t.Errorf("unknown body: `%s`", body)
+	}
+}
+
+func TestLogin_Success(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
 
 	ts := httptest.NewServer(
 		NewHTTPService(
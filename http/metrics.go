@@ -0,0 +1,65 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/sabey/ddd/metrics"
+)
+
+type httpMetrics struct {
+	requestDuration *metrics.HistogramVec
+	signups         *metrics.CounterVec
+	logins          *metrics.CounterVec
+	tokenFailures   *metrics.CounterVec
+}
+
+func newHTTPMetrics(reg *metrics.Registry) *httpMetrics {
+	return &httpMetrics{
+		requestDuration: reg.NewHistogramVec(
+			"ddd_http_request_duration_seconds",
+			"HTTP request latency in seconds by route, method and status.",
+			metrics.DefBuckets,
+			"route", "method", "status",
+		),
+		signups: reg.NewCounterVec(
+			"ddd_signups_total",
+			"Total number of successful signups.",
+		),
+		logins: reg.NewCounterVec(
+			"ddd_logins_total",
+			"Total number of login attempts by result.",
+			"result",
+		),
+		tokenFailures: reg.NewCounterVec(
+			"ddd_token_validation_failures_total",
+			"Total number of rejected authentication tokens by reason.",
+			"reason",
+		),
+	}
+}
+
+// statusWriter records the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd/metrics"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestMetrics(t *testing.T) {
+	ts := httptest.NewServer(
+		NewHTTPServiceWithOpts(
+			HTTPServiceOpts{
+				UserRepository: mock.NewUserRepository(),
+				Metrics:        metrics.NewRegistry(),
+			},
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	// failed login
+	reqBody := strings.NewReader(`{"email":"jackson@juandefu.ca","password":"123"}`)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/login", ts.URL), reqBody)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	// invalid jwt
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/users", ts.URL), nil)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Add("X-Authentication-Token", "jwt-token")
+
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/metrics", ts.URL), nil)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %s", err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed?")
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unknown content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	if !strings.Contains(string(body), `ddd_http_request_duration_seconds_count{route="/login",method="POST",status="400"} 1`) {
+		t.Errorf("login request wasn't recorded: `%s`", body)
+	}
+
+	if !strings.Contains(string(body), `ddd_logins_total{result="failure"} 1`) {
+		t.Errorf("login failure wasn't recorded: `%s`", body)
+	}
+
+	if !strings.Contains(string(body), `ddd_token_validation_failures_total{reason="invalid"} 1`) {
+		t.Errorf("token failure wasn't recorded: `%s`", body)
+	}
+}
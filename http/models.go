@@ -1,10 +1,20 @@
 package http
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sabey/ddd"
+)
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Organization is the account's organization, the default organization when it's empty
+	Organization string `json:"organization"`
 }
 
 func (lr LoginRequest) Validate() error {
@@ -16,11 +26,216 @@ func (lr LoginRequest) Validate() error {
 		return errors.New("password was empty")
 	}
 
+	if lr.Organization != "" {
+		return ddd.ValidateOrganizationID(lr.Organization)
+	}
+
 	return nil
 }
 
+// LoginResponse has an MFAToken instead of a Token when the account has two-factor authentication, it's sent to /login/mfa
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token    string `json:"token,omitempty"`
+	MFAToken string `json:"mfaToken,omitempty"`
+}
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+	// RecoveryCode is sent instead of Code when the authenticator app was lost
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+func (lr LoginMFARequest) Validate() error {
+	if lr.MFAToken == "" {
+		return errors.New("mfaToken was empty")
+	}
+
+	if lr.Code == "" && lr.RecoveryCode == "" {
+		return errors.New("code was empty")
+	}
+
+	return nil
+}
+
+type TOTPEnrollmentResponse struct {
+	// Secret is base32, it's typed into an authenticator app that can't scan QRCode
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	// QRCode is a PNG of URI, base64 encoded
+	QRCode []byte `json:"qrCode"`
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type PasskeyLoginOptionsRequest struct {
+	// Organization is the organization of the account logging in, the default organization when it's empty
+	Organization string `json:"organization"`
+}
+
+func (pr PasskeyLoginOptionsRequest) Validate() error {
+	if pr.Organization != "" {
+		return ddd.ValidateOrganizationID(pr.Organization)
+	}
+
+	return nil
+}
+
+type PasskeyOptionsResponse struct {
+	// Session is sent back with the credential, it expires after 5 minutes
+	Session string `json:"session"`
+	// PublicKey is the publicKey of navigator.credentials.create() or get(), its binary fields are base64url
+	PublicKey interface{} `json:"publicKey"`
+}
+
+type PasskeyRegisterRequest struct {
+	Session string `json:"session"`
+	// Name is a label for the passkey, "YubiKey" or "Laptop"
+	Name string `json:"name"`
+	// Credential is the PublicKeyCredential navigator.credentials.create() resolved with, as its toJSON() encodes it
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (pr PasskeyRegisterRequest) Validate() error {
+	if pr.Session == "" {
+		return errors.New("session was empty")
+	}
+
+	if utf8.RuneCountInString(pr.Name) > ddd.MaxPasskeyNameLength {
+		return fmt.Errorf("name can't be longer than %d characters", ddd.MaxPasskeyNameLength)
+	}
+
+	if len(pr.Credential) == 0 {
+		return errors.New("credential was empty")
+	}
+
+	return nil
+}
+
+type PasskeyLoginRequest struct {
+	Session string `json:"session"`
+	// Credential is the PublicKeyCredential navigator.credentials.get() resolved with, as its toJSON() encodes it
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (pr PasskeyLoginRequest) Validate() error {
+	if pr.Session == "" {
+		return errors.New("session was empty")
+	}
+
+	if len(pr.Credential) == 0 {
+		return errors.New("credential was empty")
+	}
+
+	return nil
+}
+
+type PasskeyResponse struct {
+	// ID is the credential id, base64url as browsers encode it
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	AttestationType string     `json:"attestationType"`
+	Transports      []string   `json:"transports"`
+	BackupEligible  bool       `json:"backupEligible"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	LastUsedAt      *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type PasskeysResponse struct {
+	Passkeys []PasskeyResponse `json:"passkeys"`
+}
+
+type FederationProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type FederationProvidersResponse struct {
+	Providers []FederationProviderResponse `json:"providers"`
+}
+
+type FederationLoginOptionsRequest struct {
+	Provider string `json:"provider"`
+}
+
+func (fr FederationLoginOptionsRequest) Validate() error {
+	if fr.Provider == "" {
+		return errors.New("provider was empty")
+	}
+
+	return nil
+}
+
+type FederationLoginOptionsResponse struct {
+	// URL is the provider's authorization endpoint, the browser is sent there
+	URL string `json:"url"`
+	// Session is sent back with the code and state of the provider's redirect
+	Session string `json:"session"`
+}
+
+type FederationLoginRequest struct {
+	Session string `json:"session"`
+	// Code and State are the query parameters of the provider's redirect
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+func (fr FederationLoginRequest) Validate() error {
+	if fr.Session == "" {
+		return errors.New("session was empty")
+	}
+
+	if fr.Code == "" {
+		return errors.New("code was empty")
+	}
+
+	if fr.State == "" {
+		return errors.New("state was empty")
+	}
+
+	return nil
+}
+
+type IdentityResponse struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type IdentitiesResponse struct {
+	Identities []IdentityResponse `json:"identities"`
+}
+
+type APIKeyRequest struct {
+	// Name is a label for the key, "Deploys" or "Nightly backup"
+	Name string `json:"name"`
+	// Scopes are read, write and admin, only admins can grant admin
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, a key without it doesn't expire
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type APIKeyResponse struct {
+	// ID is the key's public prefix, it's shown in listings and deletes the key
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIp,omitempty"`
+	// Key is only sent once, when the key is created
+	Key string `json:"key,omitempty"`
+}
+
+type APIKeysResponse struct {
+	Keys []APIKeyResponse `json:"keys"`
 }
 
 type SignupRequest struct {
@@ -28,6 +243,8 @@ type SignupRequest struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	Password  string `json:"password"`
+	// Invitation is an invitation's token, the account is created in its organization instead of the default organization
+	Invitation string `json:"invitation"`
 }
 
 func (sr SignupRequest) Validate() error {
@@ -56,17 +273,30 @@ type SignupResponse struct {
 
 type UsersResponse struct {
 	Users []UserResponse `json:"users"`
+	// Next is the cursor of the following page, empty on the last one
+	Next string `json:"next,omitempty"`
 }
 
 type UserResponse struct {
-	Email     string `json:"email"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+	Email       string                 `json:"email"`
+	FirstName   string                 `json:"firstName"`
+	LastName    string                 `json:"lastName"`
+	DisplayName string                 `json:"displayName,omitempty"`
+	Locale      string                 `json:"locale,omitempty"`
+	Timezone    string                 `json:"timezone,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	AvatarURL   string                 `json:"avatarUrl,omitempty"`
 }
 
 type UserRequest struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
+	// the profile fields are optional, they're left as they are when not sent
+	DisplayName *string `json:"displayName"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+	// Attributes are merged into the stored attributes, null removes a key
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
 func (ur UserRequest) Validate() error {
@@ -80,3 +310,89 @@ func (ur UserRequest) Validate() error {
 
 	return nil
 }
+
+type OrganizationRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type OrganizationResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type OrganizationsResponse struct {
+	Organizations []OrganizationResponse `json:"organizations"`
+}
+
+type InvitationRequest struct {
+	Email string `json:"email"`
+	Admin bool   `json:"admin"`
+}
+
+type InvitationResponse struct {
+	Organization string    `json:"organization"`
+	Email        string    `json:"email"`
+	Admin        bool      `json:"admin"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	// Token is sent with the invited account's signup, it isn't shown again
+	Token string `json:"token"`
+}
+
+type OAuthClientRequest struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+}
+
+type OAuthClientResponse struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirectUris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"createdAt"`
+	// Secret is only in the registration's response of a confidential client, it isn't shown again
+	Secret string `json:"secret,omitempty"`
+}
+
+type OAuthClientsResponse struct {
+	Clients []OAuthClientResponse `json:"clients"`
+}
+
+// the token endpoint's responses are RFC 6749's, their names are snake case unlike the rest of the api
+
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OpenIDConfigurationResponse is OpenID Connect Discovery's metadata
+// there's no jwks_uri, id tokens are signed with a shared secret that can't be published
+type OpenIDConfigurationResponse struct {
+	Issuer                                 string   `json:"issuer"`
+	AuthorizationEndpoint                  string   `json:"authorization_endpoint"`
+	TokenEndpoint                          string   `json:"token_endpoint"`
+	UserinfoEndpoint                       string   `json:"userinfo_endpoint"`
+	RevocationEndpoint                     string   `json:"revocation_endpoint"`
+	ScopesSupported                        []string `json:"scopes_supported"`
+	ResponseTypesSupported                 []string `json:"response_types_supported"`
+	GrantTypesSupported                    []string `json:"grant_types_supported"`
+	SubjectTypesSupported                  []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported       []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported      []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported          []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                        []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter      bool     `json:"authorization_response_iss_parameter_supported"`
+}
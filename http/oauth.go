@@ -0,0 +1,737 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/oauth"
+)
+
+/*
+curl http://localhost:8080/.well-known/openid-configuration
+*/
+
+// OpenIDConfiguration serves the provider's discovery document
+func (srv httpService) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	issuer := srv.oauth.Issuer()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OpenIDConfigurationResponse{
+		Issuer:                                 issuer,
+		AuthorizationEndpoint:                  issuer + "/oauth/authorize",
+		TokenEndpoint:                          issuer + "/oauth/token",
+		UserinfoEndpoint:                       issuer + "/oauth/userinfo",
+		RevocationEndpoint:                     issuer + "/oauth/revoke",
+		ScopesSupported:                        []string{oauth.ScopeOpenID, oauth.ScopeProfile, oauth.ScopeEmail},
+		ResponseTypesSupported:                 []string{"code"},
+		GrantTypesSupported:                    []string{"authorization_code", "client_credentials"},
+		SubjectTypesSupported:                  []string{"public"},
+		IDTokenSigningAlgValuesSupported:       []string{"HS256"},
+		TokenEndpointAuthMethodsSupported:      []string{"client_secret_basic", "client_secret_post", "none"},
+		RevocationEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:          []string{"S256"},
+		ClaimsSupported:                        []string{"sub", "email", "name", "given_name", "family_name", "locale", "zoneinfo"},
+		AuthorizationResponseIssParameter:      true,
+	})
+}
+
+/*
+open "http://localhost:8080/oauth/authorize?response_type=code&client_id=client-id&scope=openid%20email&state=state&code_challenge=challenge&code_challenge_method=S256"
+*/
+
+// Authorize shows the consent screen of a valid authorization request
+func (srv httpService) Authorize(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	request := authorizationRequest(r.URL.Query())
+
+	a, err := srv.oauth.Authorize(r.Context(), request)
+	if err != nil {
+		srv.writeAuthorizeError(w, r, a, err)
+
+		return
+	}
+
+	srv.renderConsent(w, r, http.StatusOK, a, request, nil)
+}
+
+// Consent is the consent screen's form, the account is the request's jwt or logs in with the form's password
+func (srv httpService) Consent(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		renderOAuthError(w, http.StatusBadRequest, errors.New("invalid request"))
+
+		return
+	}
+
+	request := authorizationRequest(r.PostForm)
+
+	a, err := srv.oauth.Authorize(r.Context(), request)
+	if err != nil {
+		srv.writeAuthorizeError(w, r, a, err)
+
+		return
+	}
+
+	switch r.PostForm.Get("decision") {
+	case "allow":
+	case "deny":
+		srv.logger(r).Info("oauth consent denied", "client", a.Client.ID)
+
+		oauthRedirect(w, r, srv.oauth.Deny(a))
+
+		return
+	default:
+		// 400
+		srv.renderConsent(w, r, http.StatusBadRequest, a, request, errors.New("decision must be allow or deny"))
+
+		return
+	}
+
+	tenant, email, ok := srv.consentAccount(w, r, a, request)
+	if !ok {
+		return
+	}
+
+	location, err := srv.oauth.Approve(r.Context(), a, tenant, email)
+	if errors.Is(err, ddd.ErrUserDisabled) || errors.Is(err, ddd.ErrUserNotFound) {
+		// 403, the account was disabled or deleted after its jwt was signed
+		srv.renderConsent(w, r, http.StatusForbidden, a, request, err)
+
+		return
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("failed to approve oauth client", "client", a.Client.ID, "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("oauth consent given", "client", a.Client.ID, "email", email)
+
+	oauthRedirect(w, r, location)
+}
+
+// consentAccount returns the account that consents, false is returned after the error has been written
+func (srv httpService) consentAccount(
+	w http.ResponseWriter,
+	r *http.Request,
+	a *oauth.Authorization,
+	request oauth.AuthorizationRequest,
+) (
+	string,
+	string,
+	bool,
+) {
+	if jwt := r.Header.Get("X-Authentication-Token"); jwt != "" {
+		tenant, email := ddd.ParseTenantJWTClaims(jwt)
+		if email == "" {
+			srv.metrics.tokenFailures.With("invalid").Inc()
+
+			// 401
+			srv.renderConsent(w, r, http.StatusUnauthorized, a, request, errors.New("invalid jwt"))
+
+			return "", "", false
+		}
+
+		srv.setUser(r, tenant, email)
+
+		return tenant, email, true
+	}
+
+	login := &LoginRequest{
+		Organization: r.PostForm.Get("organization"),
+		Email:        r.PostForm.Get("email"),
+		Password:     r.PostForm.Get("password"),
+	}
+
+	if err := login.Validate(); err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 401
+		srv.renderConsent(w, r, http.StatusUnauthorized, a, request, err)
+
+		return "", "", false
+	}
+
+	tenant := login.Organization
+	if tenant == "" {
+		tenant = ddd.DefaultOrganization
+	}
+
+	ctx := ddd.WithTenant(r.Context(), tenant)
+
+	user, err := srv.userRepo.Login(ctx, ddd.UserLogin{
+		Email:    login.Email,
+		Password: srv.hashPassword(r, login.Password),
+	})
+	if err == nil && srv.totp != nil {
+		var required bool
+		required, err = srv.totp.Required(ctx, user.Email)
+		if err == nil && required {
+			// the password was accepted, the form carries the code instead of a challenge token
+			err = srv.totp.Verify(ctx, user.Email, r.PostForm.Get("code"), "")
+		}
+	}
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		srv.logger(r).Warn("oauth login failed", "email", login.Email, "error", err)
+
+		switch {
+		case errors.Is(err, ddd.ErrUserDisabled):
+			// 403
+			srv.renderConsent(w, r, http.StatusForbidden, a, request, err)
+		case errors.Is(err, ddd.ErrUserNotFound), errors.Is(err, ddd.ErrInvalidPassword), errors.Is(err, ddd.ErrInvalidTOTPCode):
+			// 401
+			srv.renderConsent(w, r, http.StatusUnauthorized, a, request, err)
+		case errors.Is(err, ddd.ErrTOTPLocked):
+			// 429
+			srv.renderConsent(w, r, http.StatusTooManyRequests, a, request, err)
+		default:
+			writeRepositoryError(w, err)
+		}
+
+		return "", "", false
+	}
+
+	srv.metrics.logins.With("success").Inc()
+	srv.setUser(r, user.Organization, user.Email)
+
+	return user.Organization, user.Email, true
+}
+
+// writeAuthorizeError redirects a failed authorization request back to the client, unless the client or its redirect uri are unknown
+func (srv httpService) writeAuthorizeError(w http.ResponseWriter, r *http.Request, a *oauth.Authorization, err error) {
+	var e *oauth.Error
+	if errors.As(err, &e) {
+		oauthRedirect(w, r, srv.oauth.ErrorRedirect(a, e))
+
+		return
+	}
+
+	if errors.Is(err, ddd.ErrOAuthClientNotFound) || errors.Is(err, oauth.ErrInvalidRedirectURI) {
+		// 400
+		renderOAuthError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	srv.logger(r).Warn("failed to authorize oauth client", "error", err)
+
+	writeRepositoryError(w, err)
+}
+
+// oauthRedirect sends the browser back to the client, a form's post is followed by a get
+func oauthRedirect(w http.ResponseWriter, r *http.Request, location string) {
+	status := http.StatusFound
+	if r.Method == "POST" {
+		status = http.StatusSeeOther
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+}
+
+func authorizationRequest(values url.Values) oauth.AuthorizationRequest {
+	return oauth.AuthorizationRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+// scopeDescriptions are shown on the consent screen, a client's own scopes are shown as they are
+var scopeDescriptions = map[string]string{
+	oauth.ScopeOpenID:  "Know who you are",
+	oauth.ScopeProfile: "See your name, locale and time zone",
+	oauth.ScopeEmail:   "See your email address",
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize {{.Client}}</title>
+</head>
+<body>
+<main>
+<h1>{{.Client}} wants to access your account</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<form method="post" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Organization <input name="organization" value="{{.Organization}}" placeholder="default"></label>
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username"></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>
+<label>Two-factor code <input name="code" inputmode="numeric" autocomplete="one-time-code"></label>
+<button name="decision" value="allow">Allow</button>
+<button name="decision" value="deny">Deny</button>
+</form>
+<p>You'll be sent back to {{.Host}}</p>
+</main>
+</body>
+</html>
+`))
+
+var oauthErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorization failed</title>
+</head>
+<body>
+<main>
+<h1>Authorization failed</h1>
+<p role="alert">{{.}}</p>
+</main>
+</body>
+</html>
+`))
+
+// renderConsent writes the consent screen, the authorization request is posted back with the decision
+func (srv httpService) renderConsent(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	a *oauth.Authorization,
+	request oauth.AuthorizationRequest,
+	err error,
+) {
+	scopes := make([]string, 0, len(a.Scopes))
+	for _, scope := range a.Scopes {
+		if description, ok := scopeDescriptions[scope]; ok {
+			scope = description
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	params := map[string]string{
+		"response_type":         request.ResponseType,
+		"client_id":             request.ClientID,
+		"redirect_uri":          a.RedirectURI,
+		"scope":                 strings.Join(a.Scopes, " "),
+		"state":                 request.State,
+		"code_challenge":        request.CodeChallenge,
+		"code_challenge_method": request.CodeChallengeMethod,
+		"nonce":                 request.Nonce,
+	}
+
+	// a registered redirect uri was validated, it parses
+	redirect, _ := url.Parse(a.RedirectURI)
+
+	data := struct {
+		Client       string
+		Scopes       []string
+		Params       map[string]string
+		Organization string
+		Email        string
+		Host         string
+		Error        string
+	}{
+		Client: a.Client.Name,
+		Scopes: scopes,
+		Params: params,
+		Host:   redirect.Host,
+	}
+
+	if r.Method == "POST" {
+		data.Organization = r.PostForm.Get("organization")
+		data.Email = r.PostForm.Get("email")
+	}
+
+	if err != nil {
+		data.Error = err.Error()
+	}
+
+	writeHTMLHeaders(w)
+	w.WriteHeader(status)
+	consentTemplate.Execute(w, data)
+}
+
+// renderOAuthError writes an error that can't be sent back to the client
+func renderOAuthError(w http.ResponseWriter, status int, err error) {
+	writeHTMLHeaders(w)
+	w.WriteHeader(status)
+	oauthErrorTemplate.Execute(w, err.Error())
+}
+
+// writeHTMLHeaders keeps the consent screen out of frames and caches
+func writeHTMLHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+}
+
+/*
+curl --user client-id:client-secret \
+  --data "grant_type=client_credentials&scope=reports" \
+  http://localhost:8080/oauth/token
+*/
+
+// Token exchanges an authorization code or a client's credentials for an access token
+func (srv httpService) Token(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	form, clientID, clientSecret, err := oauthClientForm(r)
+	if err != nil {
+		writeOAuthError(w, err)
+
+		return
+	}
+
+	token, err := srv.oauth.Token(r.Context(), oauth.TokenRequest{
+		GrantType:    form.Get("grant_type"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Code:         form.Get("code"),
+		RedirectURI:  form.Get("redirect_uri"),
+		CodeVerifier: form.Get("code_verifier"),
+		Scope:        form.Get("scope"),
+	})
+	if err != nil {
+		srv.logger(r).Warn("oauth token request failed", "client", clientID, "grant_type", form.Get("grant_type"), "error", err)
+
+		writeOAuthError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(OAuthTokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(token.ExpiresAt).Round(time.Second).Seconds()),
+		Scope:       strings.Join(token.Scopes, " "),
+		IDToken:     token.IDToken,
+	})
+}
+
+/*
+curl --header "Authorization: Bearer access-token" \
+  http://localhost:8080/oauth/userinfo
+*/
+
+// UserInfo returns the claims of an access token's account
+func (srv httpService) UserInfo(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	accessToken := ""
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		accessToken = strings.TrimSpace(token)
+	}
+
+	claims, err := srv.oauth.UserInfo(r.Context(), accessToken)
+	switch {
+	case errors.Is(err, oauth.ErrInvalidToken):
+		// 401
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthErrorResponse(w, http.StatusUnauthorized, "invalid_token", err.Error())
+
+		return
+	case errors.Is(err, oauth.ErrInsufficientScope):
+		// 403
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		writeOAuthErrorResponse(w, http.StatusForbidden, "insufficient_scope", err.Error())
+
+		return
+	case err != nil:
+		srv.logger(r).Warn("failed to get oauth userinfo", "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(claims)
+}
+
+/*
+curl --user client-id:client-secret \
+  --data "token=access-token" \
+  http://localhost:8080/oauth/revoke
+*/
+
+// Revoke revokes one of the client's access tokens, an unknown token is revoked too
+func (srv httpService) Revoke(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	form, clientID, clientSecret, err := oauthClientForm(r)
+	if err == nil {
+		err = srv.oauth.Revoke(r.Context(), clientID, clientSecret, form.Get("token"))
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("oauth revocation failed", "client", clientID, "error", err)
+
+		writeOAuthError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("oauth token revoked", "client", clientID)
+}
+
+// oauthClientForm parses the form of the token and revocation endpoints
+// the client authenticates with basic authentication or the form's client_id and client_secret, not both
+func oauthClientForm(r *http.Request) (url.Values, string, string, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, "", "", &oauth.Error{Code: "invalid_request", Description: "invalid request", Status: http.StatusBadRequest}
+	}
+
+	form := r.PostForm
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return form, form.Get("client_id"), form.Get("client_secret"), nil
+	}
+
+	if form.Get("client_secret") != "" {
+		return nil, "", "", &oauth.Error{Code: "invalid_request", Description: "the client authenticated more than once", Status: http.StatusBadRequest}
+	}
+
+	// the credentials are form encoded before they're put in the header (RFC 6749 2.3.1)
+	id, idErr := url.QueryUnescape(id)
+	secret, secretErr := url.QueryUnescape(secret)
+	if idErr != nil || secretErr != nil {
+		return nil, "", "", &oauth.Error{Code: "invalid_client", Description: "client authentication failed", Status: http.StatusUnauthorized}
+	}
+
+	if formID := form.Get("client_id"); formID != "" && formID != id {
+		return nil, "", "", &oauth.Error{Code: "invalid_request", Description: "client_id isn't the authenticated client's", Status: http.StatusBadRequest}
+	}
+
+	return form, id, secret, nil
+}
+
+// writeOAuthError writes an *oauth.Error in RFC 6749's format, other errors are the repository's
+func writeOAuthError(w http.ResponseWriter, err error) {
+	var e *oauth.Error
+	if !errors.As(err, &e) {
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	writeOAuthErrorResponse(w, e.Status, e.Code, e.Description)
+}
+
+func writeOAuthErrorResponse(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"name":"Reports","redirectUris":["https://reports.example.com/callback"],"scopes":["openid","email"]}' \
+  http://localhost:8080/oauth/clients
+*/
+
+// CreateOAuthClient registers a client, a confidential client's secret is only in this response
+func (srv httpService) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	if !srv.requireOperator(w, r) {
+		return
+	}
+
+	request := &OAuthClientRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	client, err := srv.oauth.RegisterClient(r.Context(), ddd.OAuthClientCreate{
+		Name:         request.Name,
+		Public:       request.Public,
+		RedirectURIs: request.RedirectURIs,
+		Scopes:       request.Scopes,
+	})
+	if err != nil {
+		srv.logger(r).Warn("failed to create oauth client", "name", request.Name, "error", err)
+
+		if errors.Is(err, ddd.ErrOAuthClientExists) {
+			// 409
+			writeError(w, http.StatusConflict, err)
+
+			return
+		}
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("oauth client created", "client", client.ID, "name", client.Name)
+
+	json.NewEncoder(w).Encode(newOAuthClientResponse(client))
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" \
+  http://localhost:8080/oauth/clients
+*/
+
+// ListOAuthClients returns every client without their secrets
+func (srv httpService) ListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	if !srv.requireOperator(w, r) {
+		return
+	}
+
+	clients, err := srv.oauth.Clients(r.Context())
+	if err != nil {
+		srv.logger(r).Warn("failed to list oauth clients", "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	response := OAuthClientsResponse{
+		Clients: make([]OAuthClientResponse, 0, len(clients)),
+	}
+	for _, client := range clients {
+		response.Clients = append(response.Clients, newOAuthClientResponse(client))
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" \
+  --request DELETE \
+  http://localhost:8080/oauth/clients/client-id
+*/
+
+// DeleteOAuthClient deletes a client, its codes and tokens stop working
+func (srv httpService) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if srv.oauth == nil {
+		writeOAuthUnavailable(w)
+
+		return
+	}
+
+	if !srv.requireOperator(w, r) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/oauth/clients/")
+
+	err := srv.oauth.DeleteClient(r.Context(), id)
+	if errors.Is(err, ddd.ErrOAuthClientNotFound) {
+		// 404
+		writeError(w, http.StatusNotFound, err)
+
+		return
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("failed to delete oauth client", "client", id, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("oauth client deleted", "client", id)
+
+	// 204
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newOAuthClientResponse(client *ddd.OAuthClient) OAuthClientResponse {
+	return OAuthClientResponse{
+		ID:           client.ID,
+		Name:         client.Name,
+		Public:       client.Public,
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		CreatedAt:    client.CreatedAt,
+		Secret:       client.Secret,
+	}
+}
+
+func writeOAuthUnavailable(w http.ResponseWriter) {
+	// 501
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, `{"error":"oauth isn't available"}`)
+}
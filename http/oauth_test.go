@@ -0,0 +1,338 @@
+package http
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/oauth"
+)
+
+const oauthVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+// formContentType is sent with the oauth endpoints' forms
+const formContentType = "application/x-www-form-urlencoded"
+
+// registerOAuthClient registers a client as the seeded admin
+func registerOAuthClient(t *testing.T, ts *httptest.Server, body string) OAuthClientResponse {
+	t.Helper()
+
+	resp, bs := sendRequest(t, "POST", ts.URL+"/oauth/clients", ddd.SignJWTClaims("admin@sabey.co"), body)
+	if resp.StatusCode != 200 {
+		t.Fatalf("failed to register client: %d `%s`", resp.StatusCode, bs)
+	}
+
+	client := OAuthClientResponse{}
+	if err := json.Unmarshal([]byte(bs), &client); err != nil {
+		t.Fatalf("failed to decode client: %s", err)
+	}
+
+	return client
+}
+
+func authorizeParams(clientID string, scope string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {oauth.S256Challenge(oauthVerifier)},
+		"code_challenge_method": {"S256"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/.well-known/openid-configuration", "", "")
+	if resp.StatusCode != 200 || !strings.Contains(body, `"token_endpoint":"http://localhost:8080/oauth/token"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	client := registerOAuthClient(t, ts, `{"name":"Reports","redirectUris":["https://app.example.com/callback"],"scopes":["openid","email","profile"]}`)
+	if client.Secret == "" {
+		t.Fatalf("confidential client didn't get a secret: %+v", client)
+	}
+
+	params := authorizeParams(client.ID, "openid email")
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/authorize?"+params.Encode(), "", "")
+	if resp.StatusCode != 200 || !strings.Contains(body, "Reports wants to access your account") || !strings.Contains(body, "See your email address") {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	if resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("consent screen can be framed: %v", resp.Header)
+	}
+
+	// a wrong password shows the form again
+	form := url.Values{}
+	for name, values := range params {
+		form[name] = values
+	}
+	form.Set("decision", "allow")
+	form.Set("email", "jackson@juandefu.ca")
+	form.Set("password", "wrong")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/authorize", "", form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 401 || !strings.Contains(body, ddd.ErrInvalidPassword.Error()) || !strings.Contains(body, `value="jackson@juandefu.ca"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	form.Set("password", "pass")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/authorize", "", form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 303 {
+		t.Fatalf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Host != "app.example.com" || location.Query().Get("state") != "xyz" || location.Query().Get("iss") != "http://localhost:8080" {
+		t.Fatalf("unknown redirect: %s", resp.Header.Get("Location"))
+	}
+
+	code := location.Query().Get("code")
+
+	// the secret is only shown once
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/clients", ddd.SignJWTClaims("admin@sabey.co"), "")
+	if resp.StatusCode != 200 || strings.Contains(body, client.Secret) || !strings.Contains(body, client.ID) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"code_verifier": {oauthVerifier},
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", exchange.Encode(), "Content-Type", formContentType, "Authorization", "Basic wrong")
+	if resp.StatusCode != 401 || body != `{"error":"invalid_client","error_description":"client authentication failed"}`+"\n" {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.SetBasicAuth(client.ID, client.Secret)
+	basic := req.Header.Get("Authorization")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", exchange.Encode(), "Content-Type", formContentType, "Authorization", basic)
+	if resp.StatusCode != 200 || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	token := OAuthTokenResponse{}
+	if err := json.Unmarshal([]byte(body), &token); err != nil {
+		t.Fatalf("failed to decode token: %s", err)
+	}
+
+	if token.TokenType != "Bearer" || token.Scope != "openid email" || token.ExpiresIn != 3600 {
+		t.Errorf("unknown token: %+v", token)
+	}
+
+	idToken := ddd.ParseIDToken(token.IDToken, client.ID)
+	if idToken == nil || idToken.Subject != "default/jackson@juandefu.ca" || idToken.Nonce != "n-0S6_WzA2Mj" || idToken.Claims["email"] != "jackson@juandefu.ca" {
+		t.Errorf("unknown id token: %+v", idToken)
+	}
+
+	// the id token isn't an api token
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", token.IDToken, "")
+	if resp.StatusCode != 400 || body != `{"error":"invalid jwt"}` {
+		t.Errorf("id token was accepted: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the code can only be exchanged once
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", exchange.Encode(), "Content-Type", formContentType, "Authorization", basic)
+	if resp.StatusCode != 400 || !strings.Contains(body, `"error":"invalid_grant"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	bearer := "Bearer " + token.AccessToken
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/userinfo", "", "", "Authorization", bearer)
+	if resp.StatusCode != 200 || body != `{"email":"jackson@juandefu.ca","sub":"default/jackson@juandefu.ca"}`+"\n" {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/revoke", "", url.Values{"token": {token.AccessToken}}.Encode(), "Content-Type", formContentType, "Authorization", basic)
+	if resp.StatusCode != 200 || body != "" {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/userinfo", "", "", "Authorization", bearer)
+	if resp.StatusCode != 401 || resp.Header.Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+		t.Errorf("revoked token was accepted: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestOAuth_Authorize(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	client := registerOAuthClient(t, ts, `{"name":"SPA","public":true,"redirectUris":["https://app.example.com/callback"],"scopes":["openid","profile"]}`)
+	if client.Secret != "" {
+		t.Errorf("public client got a secret: %+v", client)
+	}
+
+	params := authorizeParams("unknown", "openid")
+
+	// an unknown client or redirect uri isn't redirected
+	resp, body := sendRequest(t, "GET", ts.URL+"/oauth/authorize?"+params.Encode(), "", "")
+	if resp.StatusCode != 400 || !strings.Contains(body, template.HTMLEscapeString(ddd.ErrOAuthClientNotFound.Error())) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	params = authorizeParams(client.ID, "openid")
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/authorize?"+params.Encode(), "", "")
+	if resp.StatusCode != 400 || !strings.Contains(body, template.HTMLEscapeString(oauth.ErrInvalidRedirectURI.Error())) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the other failures are
+	params = authorizeParams(client.ID, "openid email")
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/authorize?"+params.Encode(), "", "")
+	if location, _ := url.Parse(resp.Header.Get("Location")); resp.StatusCode != 302 || location.Query().Get("error") != "invalid_scope" || location.Query().Get("state") != "xyz" {
+		t.Errorf("unknown response: %d %s `%s`", resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+
+	params = authorizeParams(client.ID, "openid")
+	params.Set("code_challenge_method", "plain")
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/authorize?"+params.Encode(), "", "")
+	if location, _ := url.Parse(resp.Header.Get("Location")); resp.StatusCode != 302 || location.Query().Get("error") != "invalid_request" {
+		t.Errorf("unknown response: %d %s `%s`", resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+
+	form := authorizeParams(client.ID, "openid profile")
+	form.Set("decision", "deny")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/authorize", "", form.Encode(), "Content-Type", formContentType)
+	if location, _ := url.Parse(resp.Header.Get("Location")); resp.StatusCode != 303 || location.Query().Get("error") != "access_denied" {
+		t.Errorf("unknown response: %d %s `%s`", resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+
+	// a logged in account consents with its token, a public client exchanges the code without a secret
+	form.Set("decision", "allow")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/authorize", ddd.SignJWTClaims("jackson@juandefu.ca"), form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 303 {
+		t.Fatalf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	location, _ := url.Parse(resp.Header.Get("Location"))
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {client.ID},
+		"code":          {location.Query().Get("code")},
+		"code_verifier": {"wrong-verifier-wrong-verifier-wrong-verifier"},
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", exchange.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 400 || !strings.Contains(body, `"error":"invalid_grant"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the wrong verifier consumed the code
+	exchange.Set("code_verifier", oauthVerifier)
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", exchange.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 400 || !strings.Contains(body, `"error":"invalid_grant"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestOAuth_ClientCredentials(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	client := registerOAuthClient(t, ts, `{"name":"Reports","scopes":["reports","openid"]}`)
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+	}
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/oauth/token", "", form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 200 || !strings.Contains(body, `"scope":"reports"`) || strings.Contains(body, "id_token") {
+		t.Fatalf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	token := OAuthTokenResponse{}
+	json.Unmarshal([]byte(body), &token)
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/clients", ddd.SignJWTClaims("admin@sabey.co"), "")
+	if resp.StatusCode != 200 || !strings.Contains(body, `"redirectUris":[]`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// a token without an account has no userinfo
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/userinfo", "", "", "Authorization", "Bearer "+token.AccessToken)
+	if resp.StatusCode != 403 || !strings.Contains(body, `"error":"insufficient_scope"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	form.Set("scope", "openid")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 400 || !strings.Contains(body, `"error":"invalid_scope"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	form.Set("grant_type", "password")
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 400 || !strings.Contains(body, `"error":"unsupported_grant_type"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the client's tokens stop working once it's deleted
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/oauth/clients/"+client.ID, ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+		t.Errorf("a user deleted a client: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/oauth/clients/"+client.ID, ddd.SignJWTClaims("admin@sabey.co"), "")
+	if resp.StatusCode != 204 || body != "" {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/oauth/clients/"+client.ID, ddd.SignJWTClaims("admin@sabey.co"), "")
+	if resp.StatusCode != 404 || body != `{"error":"oauth client doesn't exist"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/oauth/userinfo", "", "", "Authorization", "Bearer "+token.AccessToken)
+	if resp.StatusCode != 401 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestOAuth_Unavailable(t *testing.T) {
+	mockUsers, _ := newSeededServer()
+
+	// the embedded interface hides the mock's OAuthRepository methods
+	ts := httptest.NewServer(NewHTTPService(struct{ ddd.UserRepository }{mockUsers}))
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/.well-known/openid-configuration", "", "")
+	if resp.StatusCode != 501 || body != `{"error":"oauth isn't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/token", "", url.Values{"grant_type": {"client_credentials"}}.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 501 || body != `{"error":"oauth isn't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,616 @@
+package http
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+curl http://localhost:8080/openapi.json
+*/
+
+// openAPISpec describes every route, it's served as is on /openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// validateAllResponses is turned on by TestMain, so every test checks the responses it gets against the spec
+var validateAllResponses = false
+
+// maxValidatedBody bounds how much of a JSON request body is read to validate it
+const maxValidatedBody = 1 << 20
+
+func (srv httpService) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// the subset of OpenAPI 3.1 the validator understands, anything else in the document is documentation
+type openAPI struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas       map[string]*jsonSchema         `json:"schemas"`
+		Parameters    map[string]*openAPIParameter   `json:"parameters"`
+		RequestBodies map[string]*openAPIRequestBody `json:"requestBodies"`
+		Responses     map[string]*openAPIResponse    `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters  []*openAPIParameter         `json:"parameters"`
+	RequestBody *openAPIRequestBody         `json:"requestBody"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref      string      `json:"$ref"`
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Ref      string                       `json:"$ref"`
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Ref     string                       `json:"$ref"`
+	Content map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+// jsonSchema is the subset of JSON Schema 2020-12 the spec uses
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	// never is the `false` schema, nothing is valid
+	never bool
+}
+
+// UnmarshalJSON accepts the boolean schemas, `true` allows anything and `false` nothing
+func (s *jsonSchema) UnmarshalJSON(b []byte) error {
+	switch string(bytes.TrimSpace(b)) {
+	case "true":
+		*s = jsonSchema{}
+		return nil
+	case "false":
+		*s = jsonSchema{never: true}
+		return nil
+	}
+
+	type plain jsonSchema
+
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+// schemaTypes is `"string"` or `["string", "null"]`
+type schemaTypes []string
+
+func (st *schemaTypes) UnmarshalJSON(b []byte) error {
+	var typ string
+	if err := json.Unmarshal(b, &typ); err == nil {
+		*st = schemaTypes{typ}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(st))
+}
+
+// openAPIRoute is one of the spec's paths, split into segments, `{id}` matches any segment
+type openAPIRoute struct {
+	template   string
+	segments   []string
+	templated  int
+	operations map[string]*openAPIOperation
+}
+
+// openAPIError carries the status a failed validation responds with
+type openAPIError struct {
+	status int
+	err    error
+}
+
+func (e *openAPIError) Error() string {
+	return e.err.Error()
+}
+
+func newOpenAPIError(status int, format string, args ...interface{}) error {
+	return &openAPIError{
+		status: status,
+		err:    fmt.Errorf(format, args...),
+	}
+}
+
+func newOpenAPIValidator(spec []byte) (*openAPIValidator, error) {
+	doc := &openAPI{}
+	if err := json.Unmarshal(spec, doc); err != nil {
+		return nil, err
+	}
+
+	v := &openAPIValidator{
+		doc: doc,
+	}
+
+	for template, operations := range doc.Paths {
+		route := openAPIRoute{
+			template:   template,
+			segments:   strings.Split(strings.TrimPrefix(template, "/"), "/"),
+			operations: make(map[string]*openAPIOperation, len(operations)),
+		}
+
+		for _, segment := range route.segments {
+			if strings.HasPrefix(segment, "{") {
+				route.templated++
+			}
+		}
+
+		for method, op := range operations {
+			route.operations[strings.ToUpper(method)] = op
+		}
+
+		v.routes = append(v.routes, route)
+	}
+
+	// concrete paths take precedence over templated ones, `/users/me/avatar` over `/users/{id}/avatar`
+	sort.Slice(v.routes, func(i, j int) bool {
+		if v.routes[i].templated != v.routes[j].templated {
+			return v.routes[i].templated < v.routes[j].templated
+		}
+
+		return v.routes[i].template < v.routes[j].template
+	})
+
+	return v, nil
+}
+
+// openAPIValidator checks requests and responses against the spec
+type openAPIValidator struct {
+	doc    *openAPI
+	routes []openAPIRoute
+}
+
+// match returns the route template, the operation and the path parameters
+// a nil operation means the spec doesn't describe the request, the router 404s it
+func (v *openAPIValidator) match(method, path string) (string, *openAPIOperation, map[string]string) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	for _, route := range v.routes {
+		if len(route.segments) != len(segments) {
+			continue
+		}
+
+		params := map[string]string{}
+		matched := true
+
+		for i, segment := range route.segments {
+			if strings.HasPrefix(segment, "{") {
+				params[strings.Trim(segment, "{}")] = segments[i]
+				continue
+			}
+
+			if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		op, ok := route.operations[method]
+		if !ok {
+			return route.template, nil, nil
+		}
+
+		return route.template, op, params
+	}
+
+	return "", nil, nil
+}
+
+// validateRequest checks the parameters and the JSON body of r, which is left readable for the handler
+// the matched route template is returned even when the request is invalid
+func (v *openAPIValidator) validateRequest(r *http.Request) (string, error) {
+	template, op, params := v.match(r.Method, r.URL.Path)
+	if op == nil {
+		return template, nil
+	}
+
+	for _, param := range op.Parameters {
+		param = v.parameter(param)
+
+		var value string
+		var ok bool
+
+		switch param.In {
+		case "path":
+			value, ok = params[param.Name]
+		case "query":
+			ok = r.URL.Query().Has(param.Name)
+			value = r.URL.Query().Get(param.Name)
+		case "header":
+			value = r.Header.Get(param.Name)
+			ok = value != ""
+		}
+
+		if !ok {
+			if param.Required {
+				return template, newOpenAPIError(http.StatusBadRequest, "%s parameter %s is required", param.In, param.Name)
+			}
+
+			continue
+		}
+
+		if err := v.validateParameter(param, value); err != nil {
+			return template, newOpenAPIError(http.StatusBadRequest, "%s parameter %s: %s", param.In, param.Name, err)
+		}
+	}
+
+	body := v.requestBody(op.RequestBody)
+	if body == nil {
+		return template, nil
+	}
+
+	if r.Header.Get("Content-Type") == "" && !body.Required {
+		return template, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	content, ok := body.Content[mediaType]
+	if !ok {
+		return template, newOpenAPIError(http.StatusUnsupportedMediaType, "content type must be one of %s", strings.Join(mediaTypes(body.Content), ", "))
+	}
+
+	// images and forms are checked by their handler
+	if !isJSON(mediaType) {
+		return template, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBody+1))
+	if err != nil {
+		return template, newOpenAPIError(http.StatusBadRequest, "failed to read request body")
+	}
+
+	if len(data) > maxValidatedBody {
+		return template, newOpenAPIError(http.StatusRequestEntityTooLarge, "request body is larger than %d bytes", maxValidatedBody)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return template, newOpenAPIError(http.StatusBadRequest, "request body isn't valid json")
+	}
+
+	if err := v.validate(content.Schema, value, "body"); err != nil {
+		return template, newOpenAPIError(http.StatusBadRequest, "%s", err)
+	}
+
+	return template, nil
+}
+
+// validateParameter checks the string schemas and integer enums the spec's parameters use
+func (v *openAPIValidator) validateParameter(param *openAPIParameter, value string) error {
+	if param.Schema == nil {
+		return nil
+	}
+
+	var decoded interface{} = value
+	if param.Schema.Type.has("integer") {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+
+		decoded = float64(n)
+	}
+
+	return v.validate(param.Schema, decoded, "value")
+}
+
+// validateResponse checks a response the handler wrote for r
+func (v *openAPIValidator) validateResponse(r *http.Request, status int, header http.Header, body []byte) error {
+	_, op, _ := v.match(r.Method, r.URL.Path)
+	if op == nil {
+		return nil
+	}
+
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = op.Responses[strconv.Itoa(status/100)+"XX"]
+	}
+	if !ok {
+		response, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("status %d isn't documented", status)
+	}
+
+	response = v.response(response)
+
+	if len(response.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("status %d has no body", status)
+		}
+
+		return nil
+	}
+
+	// handlers don't set the content type of their json
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = "application/json"
+	}
+
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("status %d can't be %s", status, mediaType)
+	}
+
+	if !isJSON(mediaType) {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("status %d body isn't valid json", status)
+	}
+
+	return v.validate(content.Schema, value, "body")
+}
+
+// validate checks value, decoded by encoding/json, against s, at is the value's path in errors
+func (v *openAPIValidator) validate(s *jsonSchema, value interface{}, at string) error {
+	s = v.schema(s)
+	if s == nil {
+		return nil
+	}
+
+	if s.never {
+		return fmt.Errorf("%s isn't allowed", at)
+	}
+
+	if len(s.Type) > 0 && !s.Type.has(jsonType(value)) && !(s.Type.has("integer") && isInteger(value)) {
+		return fmt.Errorf("%s must be %s", at, strings.Join(s.Type, " or "))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%s must be one of %v", at, s.Enum)
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		length := len([]rune(value))
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s is shorter than %d characters", at, *s.MinLength)
+		}
+
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s is longer than %d characters", at, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			return fmt.Errorf("%s is less than %v", at, *s.Minimum)
+		}
+
+		if s.Maximum != nil && value > *s.Maximum {
+			return fmt.Errorf("%s is greater than %v", at, *s.Maximum)
+		}
+	case []interface{}:
+		for i, item := range value {
+			if err := v.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := value[key]; !ok {
+				return fmt.Errorf("%s.%s is required", at, key)
+			}
+		}
+
+		// the first invalid key is reported, the order must not depend on the map
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			property, ok := s.Properties[key]
+			if !ok {
+				property = s.AdditionalProperties
+			}
+
+			if property != nil && property.never {
+				return fmt.Errorf("%s.%s is unknown", at, key)
+			}
+
+			if err := v.validate(property, value[key], at+"."+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (st schemaTypes) has(typ string) bool {
+	for _, t := range st {
+		if t == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonType is the JSON Schema type of a value decoded by encoding/json
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return ""
+}
+
+func isInteger(value interface{}) bool {
+	n, ok := value.(float64)
+
+	return ok && n == math.Trunc(n)
+}
+
+// isJSON is true for application/json and the +json types, application/merge-patch+json
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func mediaTypes(content map[string]*openAPIMediaType) []string {
+	types := make([]string, 0, len(content))
+	for mediaType := range content {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// the $ref resolvers, references are only to #/components
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func (v *openAPIValidator) schema(s *jsonSchema) *jsonSchema {
+	if s != nil && s.Ref != "" {
+		return v.doc.Components.Schemas[refName(s.Ref)]
+	}
+
+	return s
+}
+
+func (v *openAPIValidator) parameter(p *openAPIParameter) *openAPIParameter {
+	if p.Ref != "" {
+		return v.doc.Components.Parameters[refName(p.Ref)]
+	}
+
+	return p
+}
+
+func (v *openAPIValidator) requestBody(rb *openAPIRequestBody) *openAPIRequestBody {
+	if rb != nil && rb.Ref != "" {
+		return v.doc.Components.RequestBodies[refName(rb.Ref)]
+	}
+
+	return rb
+}
+
+func (v *openAPIValidator) response(r *openAPIResponse) *openAPIResponse {
+	if r.Ref != "" {
+		return v.doc.Components.Responses[refName(r.Ref)]
+	}
+
+	return r
+}
+
+// responseValidator buffers every response and replaces one that doesn't match the spec with a 500
+// it's meant for tests, a response that drifted from the spec fails whatever checks its status
+type responseValidator struct {
+	next    http.Handler
+	openAPI *openAPIValidator
+}
+
+func (rv responseValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := &responseBuffer{
+		header: w.Header(),
+		status: http.StatusOK,
+	}
+
+	rv.next.ServeHTTP(buf, r)
+
+	if err := rv.openAPI.validateResponse(r, buf.status, buf.header, buf.body.Bytes()); err != nil {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Length")
+
+		// 500
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("%s %s response doesn't match the openapi spec: %s", r.Method, r.URL.Path, err))
+
+		return
+	}
+
+	w.WriteHeader(buf.status)
+	w.Write(buf.body.Bytes())
+}
+
+// responseBuffer holds a response until it's validated, the headers are written through
+type responseBuffer struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	if !rb.wroteHeader {
+		rb.status = status
+		rb.wroteHeader = true
+	}
+}
+
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	rb.wroteHeader = true
+
+	return rb.body.Write(b)
+}
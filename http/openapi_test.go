@@ -0,0 +1,216 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestMain(m *testing.M) {
+	// every response any test gets is checked against openapi.json, so the spec can't drift from the handlers
+	validateAllResponses = true
+
+	os.Exit(m.Run())
+}
+
+func TestOpenAPI(t *testing.T) {
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mock.NewUserRepository(),
+		),
+	)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("route failed: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	doc := struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("failed to decode spec: %s", err)
+	}
+
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("unknown openapi version: %s", doc.OpenAPI)
+	}
+
+	for _, name := range []string{"SignupRequest", "LoginRequest", "UserRequest", "UserResponse", "UsersResponse", "Error"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s isn't documented", name)
+		}
+	}
+}
+
+// every documented operation has to reach a handler
+func TestOpenAPI_Routes(t *testing.T) {
+	v, err := newOpenAPIValidator(openAPISpec)
+	if err != nil {
+		t.Fatalf("failed to parse spec: %s", err)
+	}
+
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mock.NewUserRepository(),
+		),
+	)
+	defer ts.Close()
+
+	for _, route := range v.routes {
+		for method := range route.operations {
+			path := strings.Replace(route.template, "{id}", "jackson@juandefu.ca", 1)
+
+			req, _ := http.NewRequest(method, ts.URL+path, nil)
+			resp, err := new(http.Client).Do(req)
+			if err != nil {
+				t.Fatalf("failed to make http request: %s", err)
+			}
+
+			bs, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if string(bs) == `{"error":"404"}` {
+				t.Errorf("%s %s isn't routed", method, route.template)
+			}
+		}
+	}
+}
+
+func TestValidateRequests(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
+
+	ts := httptest.NewServer(
+		NewHTTPServiceWithOpts(HTTPServiceOpts{
+			UserRepository:   mockUsers,
+			ValidateRequests: true,
+		}),
+	)
+	defer ts.Close()
+
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		error       string
+	}{
+		{"POST", "/login", "application/json", `{"email":"jackson@juandefu.ca","password":"pass"}`, 200, ""},
+		{"POST", "/login", "text/plain", `{"email":"jackson@juandefu.ca","password":"pass"}`, 415, "content type must be one of application/json"},
+		{"POST", "/login", "application/json", `{"email":"jackson@juandefu.ca"}`, 400, "body.password is required"},
+		{"POST", "/login", "application/json", `{"email":"jackson@juandefu.ca","password":1}`, 400, "body.password must be string"},
+		{"POST", "/signup", "application/json", `{"email":"jackson@sabey.co","firstName":"","lastName":"Sabey","password":"pass"}`, 400, "body.firstName is shorter than 1 characters"},
+		{"POST", "/signup", "application/json", `not json`, 400, "request body isn't valid json"},
+		{"PATCH", "/users", mergePatchContentType, `{"nickname":"Jack"}`, 400, "body.nickname is unknown"},
+		{"PATCH", "/users", mergePatchContentType, `{"displayName":null}`, 200, ""},
+		{"PATCH", "/users", "application/json", `{"displayName":"Jack"}`, 415, "content type must be one of application/merge-patch+json"},
+		{"PUT", "/users", "application/json", `{"firstName":"Jackson","lastName":"Sabey","attributes":{"team":["core"]}}`, 400, "body.attributes.team must be string or number or boolean or null"},
+		{"GET", "/users/jackson@juandefu.ca/avatar?size=65", "", "", 400, "query parameter size: value must be one of [64 128 256]"},
+		{"GET", "/users/jackson@juandefu.ca/avatar?size=big", "", "", 400, "query parameter size: must be an integer"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		req.Header.Set("X-Authentication-Token", ddd.SignJWTClaims("jackson@juandefu.ca"))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+
+		resp, err := new(http.Client).Do(req)
+		if err != nil {
+			t.Fatalf("failed to make http request: %s", err)
+		}
+
+		bs, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s %s: expected %d, got %d %s", tt.method, tt.path, tt.body, tt.status, resp.StatusCode, bs)
+			continue
+		}
+
+		if tt.error != "" {
+			response := map[string]string{}
+			json.Unmarshal(bs, &response)
+
+			if response["error"] != tt.error {
+				t.Errorf("%s %s %s: unknown error: %s", tt.method, tt.path, tt.body, bs)
+			}
+		}
+	}
+}
+
+func TestValidateResponses(t *testing.T) {
+	v, err := newOpenAPIValidator(openAPISpec)
+	if err != nil {
+		t.Fatalf("failed to parse spec: %s", err)
+	}
+
+	tests := []struct {
+		handler http.HandlerFunc
+		status  int
+	}{
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey"}`))
+		}, 200},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"email":"jackson@juandefu.ca","firstName":"Jackson"}`))
+		}, 500},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey","password":"pass"}`))
+		}, 500},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid jwt"}`))
+		}, 400},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"invalid jwt"}`))
+		}, 500},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html></html>`))
+		}, 500},
+	}
+
+	for i, tt := range tests {
+		ts := httptest.NewServer(responseValidator{
+			next:    tt.handler,
+			openAPI: v,
+		})
+
+		resp, err := http.Get(ts.URL + "/users/me")
+		if err != nil {
+			t.Fatalf("failed to make http request: %s", err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%d: expected %d, got %d", i, tt.status, resp.StatusCode)
+		}
+	}
+}
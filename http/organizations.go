@@ -0,0 +1,254 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sabey/ddd"
+)
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"id": "acme","name": "Acme Inc."}' \
+  http://localhost:8080/organizations
+*/
+
+// CreateOrganization is for the admins of the default organization, they manage every organization
+func (srv httpService) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	if !srv.requireOperator(w, r) {
+		return
+	}
+
+	request := &OrganizationRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	create := ddd.OrganizationCreate{
+		ID:   request.ID,
+		Name: request.Name,
+	}
+
+	if err := create.Validate(); err != nil {
+		// 400, the id is echoed
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	organization, err := srv.orgRepo.CreateOrganization(r.Context(), create)
+	if err != nil {
+		srv.logger(r).Warn("failed to create organization", "organization", request.ID, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("created organization", "organization", organization.ID)
+
+	bs, _ := json.Marshal(newOrganizationResponse(organization))
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" \
+  http://localhost:8080/organizations
+*/
+
+func (srv httpService) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	if !srv.requireOperator(w, r) {
+		return
+	}
+
+	organizations, err := srv.orgRepo.ListOrganizations(r.Context())
+	if err != nil {
+		srv.logger(r).Error("failed to list organizations", "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	response := OrganizationsResponse{
+		Organizations: []OrganizationResponse{},
+	}
+
+	for _, organization := range organizations {
+		response.Organizations = append(response.Organizations, newOrganizationResponse(organization))
+	}
+
+	bs, _ := json.Marshal(response)
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" \
+  http://localhost:8080/organizations/acme
+*/
+
+func (srv httpService) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/organizations/")
+
+	if !srv.requireOrganizationAdmin(w, r, id) {
+		return
+	}
+
+	if err := ddd.ValidateOrganizationID(id); err != nil {
+		// 400, the id is echoed
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	organization, err := srv.orgRepo.GetOrganization(r.Context(), id)
+	if errors.Is(err, ddd.ErrOrganizationNotFound) {
+		// 404
+		writeError(w, http.StatusNotFound, err)
+
+		return
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("failed to get organization", "organization", id, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	bs, _ := json.Marshal(newOrganizationResponse(organization))
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"email": "jackson@juandefu.ca","admin": true}' \
+  http://localhost:8080/organizations/acme/invitations
+*/
+
+// CreateInvitation responds with the invitation's token, the invited email signs up with it
+func (srv httpService) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/organizations/"), "/invitations")
+
+	if !srv.requireOrganizationAdmin(w, r, id) {
+		return
+	}
+
+	request := &InvitationRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	create := ddd.InvitationCreate{
+		Organization: id,
+		Email:        request.Email,
+		Admin:        request.Admin,
+	}
+
+	if err := create.Validate(); err != nil {
+		// 400, the id is echoed
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	invitation, err := srv.orgRepo.CreateInvitation(r.Context(), create)
+	if errors.Is(err, ddd.ErrOrganizationNotFound) {
+		// 404
+		writeError(w, http.StatusNotFound, err)
+
+		return
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("failed to create invitation", "organization", id, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	srv.logger(r).Info("invited user", "organization", id, "email", invitation.Email, "admin", invitation.Admin)
+
+	bs, _ := json.Marshal(InvitationResponse{
+		Organization: invitation.Organization,
+		Email:        invitation.Email,
+		Admin:        invitation.Admin,
+		ExpiresAt:    invitation.ExpiresAt,
+		Token:        invitation.Token,
+	})
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+func newOrganizationResponse(organization *ddd.Organization) OrganizationResponse {
+	return OrganizationResponse{
+		ID:        organization.ID,
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt,
+	}
+}
+
+// requireOperator returns true when the authenticated account is an admin of the default organization
+// false is returned after the error has been written
+func (srv httpService) requireOperator(w http.ResponseWriter, r *http.Request) bool {
+	return srv.requireOrganizationAdmin(w, r, ddd.DefaultOrganization)
+}
+
+// requireOrganizationAdmin returns true when the authenticated account is an admin of the organization, or of the default organization
+// false is returned after the error has been written
+func (srv httpService) requireOrganizationAdmin(w http.ResponseWriter, r *http.Request, id string) bool {
+	if srv.orgRepo == nil {
+		writeOrganizationsUnavailable(w)
+
+		return false
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return false
+	}
+
+	if tenant := ddd.TenantFrom(r.Context()); tenant != id && tenant != ddd.DefaultOrganization {
+		// 403
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error":"forbidden"}`)
+
+		return false
+	}
+
+	return srv.requireAdmin(w, r, email)
+}
+
+func writeOrganizationsUnavailable(w http.ResponseWriter) {
+	// 501
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, `{"error":"organizations aren't available"}`)
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+)
+
+func TestOrganizations(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	admin := ddd.SignJWTClaims("admin@sabey.co")
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/organizations", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"id":"acme","name":"Acme Inc."}`)
+	if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+		t.Errorf("a user created an organization: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/organizations", admin, `{"id":"Acme\"","name":"Acme Inc."}`)
+	if resp.StatusCode != 400 || body != `{"error":"id isn't lowercase letters, digits and dashes: Acme\""}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/organizations", admin, `{"id":"acme","name":"Acme Inc."}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	organization := OrganizationResponse{}
+	if err := json.Unmarshal([]byte(body), &organization); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if organization.ID != "acme" || organization.Name != "Acme Inc." || organization.CreatedAt.IsZero() {
+		t.Errorf("unknown organization: %+v", organization)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/organizations", admin, `{"id":"acme","name":"Acme"}`)
+	if resp.StatusCode != 400 || body != `{"error":"organization already exists"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/organizations", admin, "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	organizations := OrganizationsResponse{}
+	if err := json.Unmarshal([]byte(body), &organizations); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if len(organizations.Organizations) != 2 || organizations.Organizations[0].ID != "acme" || organizations.Organizations[1].ID != ddd.DefaultOrganization {
+		t.Errorf("unexpected organizations: %+v", organizations)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/organizations/acme", admin, "")
+	if resp.StatusCode != 200 || !strings.Contains(body, `"name":"Acme Inc."`) {
+		t.Errorf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/organizations/unknown", admin, "")
+	if resp.StatusCode != 404 || body != `{"error":"organization doesn't exist"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, _ = sendRequest(t, "GET", ts.URL+"/organizations", "", "")
+	if resp.StatusCode != 400 {
+		t.Errorf("listed organizations without a token: %d", resp.StatusCode)
+	}
+}
+
+func TestOrganizations_Invitation(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	mockUsers.SeedOrganizations(ddd.Organization{ID: "acme", Name: "Acme Inc."}, ddd.Organization{ID: "zeta", Name: "Zeta"})
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/organizations/acme/invitations", ddd.SignJWTClaims("admin@sabey.co"), `{"email":"jackson@acme.co","admin":true}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	invitation := InvitationResponse{}
+	if err := json.Unmarshal([]byte(body), &invitation); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if invitation.Organization != "acme" || invitation.Email != "jackson@acme.co" || !invitation.Admin || invitation.Token == "" {
+		t.Fatalf("unknown invitation: %+v", invitation)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/signup", "", fmt.Sprintf(`{"email":"jackson@acme.co","firstName":"Jackson","lastName":"Sabey","password":"pass","invitation":"%s"}`, invitation.Token))
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	signup := SignupResponse{}
+	if err := json.Unmarshal([]byte(body), &signup); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if tenant, email := ddd.ParseTenantJWTClaims(signup.Token); tenant != "acme" || email != "jackson@acme.co" {
+		t.Errorf("unknown token: %s", signup.Token)
+	}
+
+	if user, ok := mockUsers.TenantUser("acme", "jackson@acme.co"); !ok || !user.Admin {
+		t.Errorf("account wasn't created: %+v", user)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/signup", "", fmt.Sprintf(`{"email":"jackson@acme.co","firstName":"Jackson","lastName":"Sabey","password":"pass","invitation":"%s"}`, invitation.Token))
+	if resp.StatusCode != 400 || body != `{"error":"invitation doesn't exist or expired"}` {
+		t.Errorf("an invitation was accepted twice: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the organization's admin manages its own organization only
+	resp, body = sendRequest(t, "POST", ts.URL+"/organizations/acme/invitations", signup.Token, `{"email":"jackson@juandefu.ca"}`)
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/organizations/zeta/invitations", `{"email":"jackson@juandefu.ca"}`},
+		{"GET", "/organizations/zeta", ""},
+		{"GET", "/organizations", ""},
+		{"POST", "/organizations", `{"id":"beta","name":"Beta"}`},
+	} {
+		resp, body = sendRequest(t, tt.method, ts.URL+tt.path, signup.Token, tt.body)
+		if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+			t.Errorf("%s %s: unknown response: %d `%s`", tt.method, tt.path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestOrganizations_Login(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	mockUsers.Seed(ddd.User{
+		Organization: "acme",
+		Email:        "jackson@juandefu.ca",
+		FirstName:    "Jackson",
+		LastName:     "Acme",
+		Password:     ddd.HashPassword("acme"),
+	})
+
+	// the same email has an account in both organizations
+	resp, body := sendRequest(t, "POST", ts.URL+"/login", "", `{"email":"jackson@juandefu.ca","password":"acme"}`)
+	if resp.StatusCode != 400 || body != `{"error":"password is invalid"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login", "", `{"organization":"acme","email":"jackson@juandefu.ca","password":"acme"}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	login := LoginResponse{}
+	if err := json.Unmarshal([]byte(body), &login); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", login.Token, "")
+	if resp.StatusCode != 200 || !strings.Contains(body, `"lastName":"Acme"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the listing is the organization's
+	resp, body = sendRequest(t, "GET", ts.URL+"/users", login.Token, "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	users := UsersResponse{}
+	if err := json.Unmarshal([]byte(body), &users); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if len(users.Users) != 1 || users.Users[0].LastName != "Acme" {
+		t.Errorf("unexpected users: %+v", users)
+	}
+}
@@ -0,0 +1,372 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/passkey"
+)
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  --request POST \
+  http://localhost:8080/users/me/passkeys/register/options
+*/
+
+// BeginPasskeyRegistration responds with the options for navigator.credentials.create() and the session to send back with its credential
+func (srv httpService) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if srv.passkeys == nil {
+		writePasskeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "register passkeys") {
+		return
+	}
+
+	session, options, err := srv.passkeys.BeginRegistration(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to begin passkey registration", "email", email, "error", err)
+
+		srv.writePasskeyError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	bs, _ := json.Marshal(PasskeyOptionsResponse{
+		Session:   session,
+		PublicKey: options,
+	})
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"session": "session","name": "Laptop","credential": {...}}' \
+  http://localhost:8080/users/me/passkeys/register
+*/
+
+// RegisterPasskey verifies the credential navigator.credentials.create() resolved with and stores the passkey
+func (srv httpService) RegisterPasskey(w http.ResponseWriter, r *http.Request) {
+	if srv.passkeys == nil {
+		writePasskeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "register passkeys") {
+		return
+	}
+
+	request := &PasskeyRegisterRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	p, err := srv.passkeys.FinishRegistration(r.Context(), email, request.Session, request.Name, request.Credential)
+	if err != nil {
+		srv.logger(r).Warn("failed to register passkey", "email", email, "error", err)
+
+		srv.writePasskeyError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	srv.logger(r).Info("registered passkey", "email", email, "attestation", p.AttestationType)
+
+	bs, _ := json.Marshal(newPasskeyResponse(p))
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  --request GET \
+  http://localhost:8080/users/me/passkeys
+*/
+
+func (srv httpService) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	if srv.passkeys == nil {
+		writePasskeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	passkeys, err := srv.passkeys.List(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to list passkeys", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	response := PasskeysResponse{
+		Passkeys: []PasskeyResponse{},
+	}
+	for _, p := range passkeys {
+		response.Passkeys = append(response.Passkeys, newPasskeyResponse(p))
+	}
+
+	bs, _ := json.Marshal(response)
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  --request DELETE \
+  http://localhost:8080/users/me/passkeys/credential-id
+*/
+
+// DeletePasskey removes one of the account's passkeys, its id is base64url
+func (srv httpService) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	if srv.passkeys == nil {
+		writePasskeysUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "delete passkeys") {
+		return
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/users/me/passkeys/"))
+	if err != nil || len(id) == 0 {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid passkey id"}`)
+
+		return
+	}
+
+	if err := srv.passkeys.Delete(r.Context(), email, id); err != nil {
+		srv.logger(r).Warn("failed to delete passkey", "email", email, "error", err)
+
+		srv.writePasskeyError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	srv.logger(r).Info("deleted passkey", "email", email)
+
+	// 204
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"organization": "acme"}' \
+  http://localhost:8080/login/passkey/options
+*/
+
+// BeginPasskeyLogin responds with the options for navigator.credentials.get(), the body is optional
+func (srv httpService) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if srv.passkeys == nil {
+		writePasskeysUnavailable(w)
+
+		return
+	}
+
+	request := &PasskeyLoginOptionsRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil && err != io.EOF {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		// 400, the organization is echoed
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	tenant := request.Organization
+	if tenant == "" {
+		tenant = ddd.DefaultOrganization
+	}
+
+	session, options, err := srv.passkeys.BeginLogin(ddd.WithTenant(r.Context(), tenant))
+	if err != nil {
+		srv.logger(r).Warn("failed to begin passkey login", "error", err)
+
+		srv.writePasskeyError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	bs, _ := json.Marshal(PasskeyOptionsResponse{
+		Session:   session,
+		PublicKey: options,
+	})
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"session": "session","credential": {...}}' \
+  http://localhost:8080/login/passkey
+*/
+
+// LoginPasskey verifies the credential navigator.credentials.get() resolved with and responds with the same token as /login
+// a passkey verified the user, two-factor authentication isn't asked for
+func (srv httpService) LoginPasskey(w http.ResponseWriter, r *http.Request) {
+	if srv.passkeys == nil {
+		writePasskeysUnavailable(w)
+
+		return
+	}
+
+	request := &PasskeyLoginRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	login, err := srv.passkeys.FinishLogin(r.Context(), request.Session, request.Credential)
+	if err == nil {
+		// a disabled account keeps its passkeys, they just can't log in
+		var user *ddd.User
+		user, err = srv.uncachedUserRepo.Get(ddd.WithTenant(r.Context(), login.Tenant), login.Passkey.Email)
+		if err == nil && user.Disabled {
+			err = ddd.ErrUserDisabled
+		}
+	}
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		srv.logger(r).Warn("passkey login failed", "error", err)
+
+		srv.writePasskeyError(w, err, http.StatusUnauthorized)
+
+		return
+	}
+
+	srv.metrics.logins.With("success").Inc()
+	srv.setUser(r, login.Tenant, login.Passkey.Email)
+
+	jwt := ddd.SignTenantJWTClaims(login.Tenant, login.Passkey.Email)
+
+	fmt.Fprintf(w, `{"token":"%s"}`, jwt)
+}
+
+// writePasskeyError writes a failed ceremony with failureStatus, go-webauthn's reasons are only logged
+func (srv httpService) writePasskeyError(w http.ResponseWriter, err error, failureStatus int) {
+	for _, failure := range []error{passkey.ErrInvalidCeremony, passkey.ErrInvalidCredential, passkey.ErrUnsupportedAttestation, ddd.ErrPasskeyCloned} {
+		if errors.Is(err, failure) {
+			writeError(w, failureStatus, failure)
+
+			return
+		}
+	}
+
+	switch {
+	case errors.Is(err, ddd.ErrPasskeyNotFound), errors.Is(err, ddd.ErrUserNotFound):
+		// 404
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ddd.ErrPasskeyExists):
+		// 409
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeRepositoryError(w, err)
+	}
+}
+
+func writePasskeysUnavailable(w http.ResponseWriter) {
+	// 501
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, `{"error":"passkeys aren't available"}`)
+}
+
+func newPasskeyResponse(p *ddd.Passkey) PasskeyResponse {
+	transports := p.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	return PasskeyResponse{
+		ID:              base64.RawURLEncoding.EncodeToString(p.ID),
+		Name:            p.Name,
+		AttestationType: p.AttestationType,
+		Transports:      transports,
+		BackupEligible:  p.BackupEligible,
+		CreatedAt:       p.CreatedAt,
+		LastUsedAt:      p.LastUsedAt,
+	}
+}
@@ -0,0 +1,273 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/passkey"
+	"github.com/sabey/ddd/passkey/passkeytest"
+)
+
+// passkeyOptions decodes an options response, it returns the session
+func passkeyOptions(t *testing.T, body string, options interface{}) string {
+	t.Helper()
+
+	response := struct {
+		Session   string          `json:"session"`
+		PublicKey json.RawMessage `json:"publicKey"`
+	}{}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if err := json.Unmarshal(response.PublicKey, options); err != nil {
+		t.Fatalf("failed to decode %s: %s", response.PublicKey, err)
+	}
+
+	return response.Session
+}
+
+// registerPasskey registers a passkey of the authenticator for the token's account
+func registerPasskey(t *testing.T, ts *httptest.Server, token string, a *passkeytest.Authenticator) (int, string) {
+	t.Helper()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/passkeys/register/options", token, "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	options := protocol.PublicKeyCredentialCreationOptions{}
+	session := passkeyOptions(t, body, &options)
+
+	credential, err := a.Create(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to create: %s", err)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/users/me/passkeys/register", token, fmt.Sprintf(`{"session":"%s","name":"Laptop","credential":%s}`, session, credential))
+
+	return resp.StatusCode, body
+}
+
+// loginPasskey logs in with the authenticator, organization is sent when it isn't empty
+func loginPasskey(t *testing.T, ts *httptest.Server, organization string, a *passkeytest.Authenticator) (int, string) {
+	t.Helper()
+
+	request := ""
+	if organization != "" {
+		request = fmt.Sprintf(`{"organization":"%s"}`, organization)
+	}
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/passkey/options", "", request)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	options := protocol.PublicKeyCredentialRequestOptions{}
+	session := passkeyOptions(t, body, &options)
+
+	credential, err := a.Get(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to get: %s", err)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/passkey", "", fmt.Sprintf(`{"session":"%s","credential":%s}`, session, credential))
+
+	return resp.StatusCode, body
+}
+
+func TestPasskeys(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		t.Run(format, func(t *testing.T) {
+			_, ts := newSeededServer()
+			defer ts.Close()
+
+			token := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+			a := passkeytest.NewAuthenticator(passkey.DefaultOrigin)
+			a.Format = format
+
+			status, body := registerPasskey(t, ts, token, a)
+			if status != 200 {
+				t.Fatalf("route failed: %d `%s`", status, body)
+			}
+
+			registered := PasskeyResponse{}
+			if err := json.Unmarshal([]byte(body), &registered); err != nil {
+				t.Fatalf("failed to decode %s: %s", body, err)
+			}
+
+			if registered.Name != "Laptop" || registered.AttestationType != format || registered.LastUsedAt != nil {
+				t.Errorf("unknown passkey: `%s`", body)
+			}
+
+			status, body = loginPasskey(t, ts, "", a)
+			if status != 200 {
+				t.Fatalf("route failed: %d `%s`", status, body)
+			}
+
+			login := LoginResponse{}
+			if err := json.Unmarshal([]byte(body), &login); err != nil {
+				t.Fatalf("failed to decode %s: %s", body, err)
+			}
+
+			// the same token as /login
+			if tenant, email := ddd.ParseTenantJWTClaims(login.Token); tenant != ddd.DefaultOrganization || email != "jackson@juandefu.ca" {
+				t.Errorf("unknown token claims: %s %s", tenant, email)
+			}
+
+			resp, body := sendRequest(t, "GET", ts.URL+"/users/me/passkeys", login.Token, "")
+			if resp.StatusCode != 200 {
+				t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+			}
+
+			list := PasskeysResponse{}
+			if err := json.Unmarshal([]byte(body), &list); err != nil {
+				t.Fatalf("failed to decode %s: %s", body, err)
+			}
+
+			if len(list.Passkeys) != 1 || list.Passkeys[0].ID != registered.ID || list.Passkeys[0].LastUsedAt == nil {
+				t.Errorf("unknown passkeys: `%s`", body)
+			}
+
+			resp, body = sendRequest(t, "DELETE", ts.URL+"/users/me/passkeys/"+registered.ID, token, "")
+			if resp.StatusCode != 204 || body != "" {
+				t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+			}
+
+			resp, body = sendRequest(t, "DELETE", ts.URL+"/users/me/passkeys/"+registered.ID, token, "")
+			if resp.StatusCode != 404 || body != `{"error":"passkey doesn't exist"}` {
+				t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+			}
+
+			if status, body := loginPasskey(t, ts, "", a); status != 401 || body != `{"error":"passkey credential is invalid"}` {
+				t.Errorf("a deleted passkey logged in: %d `%s`", status, body)
+			}
+		})
+	}
+}
+
+func TestPasskeys_Registration(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	token := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+	a := passkeytest.NewAuthenticator(passkey.DefaultOrigin)
+	a.Format = "fido-u2f"
+
+	if status, body := registerPasskey(t, ts, token, a); status != 400 || body != `{"error":"attestation format isn't supported"}` {
+		t.Errorf("unknown response: %d `%s`", status, body)
+	}
+
+	a = passkeytest.NewAuthenticator("https://phishing.example")
+
+	if status, body := registerPasskey(t, ts, token, a); status != 400 || body != `{"error":"passkey credential is invalid"}` {
+		t.Errorf("another origin registered: %d `%s`", status, body)
+	}
+
+	// another account's session
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/passkeys/register/options", ddd.SignJWTClaims("admin@sabey.co"), "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	options := protocol.PublicKeyCredentialCreationOptions{}
+	session := passkeyOptions(t, body, &options)
+
+	credential, err := passkeytest.NewAuthenticator(passkey.DefaultOrigin).Create(options)
+	if err != nil {
+		t.Fatalf("authenticator failed to create: %s", err)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/users/me/passkeys/register", token, fmt.Sprintf(`{"session":"%s","credential":%s}`, session, credential))
+	if resp.StatusCode != 400 || body != `{"error":"passkey ceremony is invalid or expired"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// a ceremony's session isn't a token
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", session, "")
+	if resp.StatusCode != 400 || body != `{"error":"invalid jwt"}` {
+		t.Errorf("a session authenticated: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/users/me/passkeys/register", token, `{"session":"session"}`)
+	if resp.StatusCode != 400 || body != `{"error":"credential was empty"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/users/me/passkeys/not*base64", token, "")
+	if resp.StatusCode != 400 || body != `{"error":"invalid passkey id"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestPasskeys_Login(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	a := passkeytest.NewAuthenticator(passkey.DefaultOrigin)
+	if status, body := registerPasskey(t, ts, ddd.SignJWTClaims("jackson@juandefu.ca"), a); status != 200 {
+		t.Fatalf("route failed: %d `%s`", status, body)
+	}
+
+	// a passkey belongs to its organization
+	if status, body := loginPasskey(t, ts, "acme", a); status != 401 || body != `{"error":"passkey credential is invalid"}` {
+		t.Errorf("another organization logged in: %d `%s`", status, body)
+	}
+
+	clone := a.Clone()
+
+	if status, body := loginPasskey(t, ts, ddd.DefaultOrganization, a); status != 200 || !strings.HasPrefix(body, `{"token":`) {
+		t.Errorf("unknown response: %d `%s`", status, body)
+	}
+
+	if status, body := loginPasskey(t, ts, "", clone); status != 401 || body != `{"error":"passkey's sign count went backwards, it may be cloned"}` {
+		t.Errorf("a cloned passkey logged in: %d `%s`", status, body)
+	}
+
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+		Disabled:  true,
+	})
+
+	if status, body := loginPasskey(t, ts, "", a); status != 403 || body != `{"error":"user account is disabled"}` {
+		t.Errorf("a disabled account logged in: %d `%s`", status, body)
+	}
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/passkey", "", `{"session":"session","credential":{"id":"AA"}}`)
+	if resp.StatusCode != 401 || body != `{"error":"passkey ceremony is invalid or expired"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/passkey/options", "", `{"organization":"-"}`)
+	if resp.StatusCode != 400 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestPasskeys_Unavailable(t *testing.T) {
+	mockUsers, _ := newSeededServer()
+
+	// the embedded interface hides the mock's PasskeyRepository methods
+	ts := httptest.NewServer(NewHTTPService(struct{ ddd.UserRepository }{mockUsers}))
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/passkey/options", "", "")
+	if resp.StatusCode != 501 || body != `{"error":"passkeys aren't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me/passkeys", ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 501 || body != `{"error":"passkeys aren't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
@@ -18,7 +18,11 @@ curl --header "Content-Type: application/json" \
 func (srv httpService) Signup(w http.ResponseWriter, r *http.Request) {
 	request := &SignupRequest{}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
 		// 400
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, `{"error":"invalid request"}`)
@@ -34,23 +38,40 @@ func (srv httpService) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := srv.userRepo.Create(
-		ddd.UserCreate{
-			Email:     request.Email,
-			FirstName: request.FirstName,
-			LastName:  request.LastName,
-			Password:  ddd.HashPassword(request.Password),
-		},
-	)
+	password := srv.hashPassword(r, request.Password)
+
+	create := ddd.UserCreate{
+		Email:     request.Email,
+		FirstName: request.FirstName,
+		LastName:  request.LastName,
+		Password:  password,
+	}
+
+	var user *ddd.User
+	if request.Invitation != "" {
+		if srv.orgRepo == nil {
+			writeOrganizationsUnavailable(w)
+
+			return
+		}
+
+		user, err = srv.orgRepo.AcceptInvitation(r.Context(), request.Invitation, create)
+	} else {
+		// without an invitation the account is the default organization's, whatever token was sent
+		user, err = srv.userRepo.Create(ddd.WithTenant(r.Context(), ddd.DefaultOrganization), create)
+	}
 	if err != nil {
-		// 400 - we should be able to override this status code but we will use this for now
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		srv.logger(r).Warn("signup failed", "email", request.Email, "error", err)
+
+		writeRepositoryError(w, err)
 
 		return
 	}
 
-	jwt := ddd.SignJWTClaims(user.Email)
+	srv.metrics.signups.With().Inc()
+	srv.setUser(r, user.Organization, user.Email)
+
+	jwt := ddd.SignTenantJWTClaims(user.Organization, user.Email)
 
 	fmt.Fprintf(w, `{"token":"%s"}`, jwt)
 }
@@ -197,12 +197,12 @@ func TestSignup_InvalidRequest_Password(t *testing.T) {
 
 func TestSignup_UserAlreadyExists(t *testing.T) {
 	mockUsers := mock.NewUserRepository()
-	mockUsers.Accounts["jackson@juandefu.ca"] = ddd.User{
+	mockUsers.Seed(ddd.User{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  ddd.HashPassword("pass"),
-	}
+	})
 
 	ts := httptest.NewServer(
 		NewHTTPService(
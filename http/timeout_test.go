@@ -0,0 +1,58 @@
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Inject(mock.MethodList, mock.Fault{
+		Latency: time.Hour,
+	})
+
+	ts := httptest.NewServer(
+		NewHTTPServiceWithOpts(
+			HTTPServiceOpts{
+				UserRepository: mockUsers,
+				RequestTimeout: 10 * time.Millisecond,
+			},
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/users", ts.URL), nil)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Add("X-Authentication-Token", ddd.SignJWTClaims("jackson@juandefu.ca"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %s", err)
+	}
+
+	if resp.StatusCode != 504 {
+		t.Errorf("request didn't time out: %d", resp.StatusCode)
+	}
+
+	if string(body) != `{"error":"request timed out"}` {
+		t.Errorf("unknown body: `%s`", body)
+	}
+}
@@ -0,0 +1,297 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/totp"
+)
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  --request POST \
+  http://localhost:8080/users/me/2fa/totp
+*/
+
+// EnrollTOTP responds with a new secret, it isn't asked for at login until a code of it is confirmed
+func (srv httpService) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if srv.totp == nil {
+		writeTOTPUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "enroll two-factor authentication") {
+		return
+	}
+
+	enrollment, err := srv.totp.Enroll(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to enroll totp", "email", email, "error", err)
+
+		srv.writeTOTPError(w, r, err)
+
+		return
+	}
+
+	srv.logger(r).Info("enrolled totp", "email", email)
+
+	bs, _ := json.Marshal(TOTPEnrollmentResponse{
+		Secret: enrollment.Secret,
+		URI:    enrollment.URI,
+		QRCode: enrollment.QRCode,
+	})
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"code": "123456"}' \
+  http://localhost:8080/users/me/2fa/totp/confirm
+*/
+
+// ConfirmTOTP enables two-factor authentication, the recovery codes are only ever in this response
+func (srv httpService) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if srv.totp == nil {
+		writeTOTPUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if rejectAPIKey(w, r, "enroll two-factor authentication") {
+		return
+	}
+
+	request := &TOTPConfirmRequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if request.Code == "" {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"code was empty"}`)
+
+		return
+	}
+
+	codes, err := srv.totp.Confirm(r.Context(), email, request.Code)
+	if err != nil {
+		srv.logger(r).Warn("failed to confirm totp", "email", email, "error", err)
+
+		srv.writeTOTPError(w, r, err)
+
+		return
+	}
+
+	srv.logger(r).Info("enabled totp", "email", email)
+
+	bs, _ := json.Marshal(RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" \
+  --request DELETE \
+  http://localhost:8080/users/jackson@juandefu.ca/2fa
+*/
+
+// ResetTOTP disables an account's two-factor authentication, for an account that lost its app and recovery codes
+func (srv httpService) ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	if srv.totp == nil {
+		writeTOTPUnavailable(w)
+
+		return
+	}
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if !srv.requireAdmin(w, r, email) {
+		return
+	}
+
+	target := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/2fa")
+
+	if err := ddd.ValidateEmail(target); err != nil {
+		// 400, the email is echoed
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if err := srv.totp.Reset(r.Context(), target); err != nil {
+		srv.logger(r).Warn("failed to reset totp", "email", target, "error", err)
+
+		srv.writeTOTPError(w, r, err)
+
+		return
+	}
+
+	srv.logger(r).Info("reset totp", "email", target, "admin", email)
+
+	// 204
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"mfaToken": "mfa-token","code": "123456"}' \
+  http://localhost:8080/login/mfa
+*/
+
+// LoginMFA is the second step of a login that responded with an mfaToken, a recovery code can be sent instead of a code
+func (srv httpService) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	if srv.totp == nil {
+		writeTOTPUnavailable(w)
+
+		return
+	}
+
+	request := &LoginMFARequest{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	challenge := ddd.ParseMFAChallenge(request.MFAToken)
+	if challenge == nil {
+		srv.metrics.logins.With("failure").Inc()
+		srv.metrics.tokenFailures.With("invalid").Inc()
+
+		// 401
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"error":"invalid mfa token"}`)
+
+		return
+	}
+
+	tenant, email := challenge.Tenant, challenge.Email
+	ctx := ddd.WithTenant(r.Context(), tenant)
+
+	// a challenge is only checked once, a wrong code means logging in again
+	err = srv.totp.UseChallenge(ctx, challenge)
+	if errors.Is(err, ddd.ErrMFAChallengeUsed) {
+		srv.metrics.logins.With("failure").Inc()
+		srv.metrics.tokenFailures.With("invalid").Inc()
+
+		// 401
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"error":"invalid mfa token"}`)
+
+		return
+	}
+
+	// the account may have been disabled since its password was accepted
+	var user *ddd.User
+	if err == nil {
+		user, err = srv.uncachedUserRepo.Get(ctx, email)
+	}
+
+	if err == nil && user.Disabled {
+		err = ddd.ErrUserDisabled
+	}
+
+	if err == nil {
+		err = srv.totp.Verify(ctx, email, request.Code, request.RecoveryCode)
+	}
+
+	if err != nil {
+		srv.metrics.logins.With("failure").Inc()
+
+		srv.logger(r).Warn("login failed", "email", email, "error", err)
+
+		srv.writeTOTPError(w, r, err)
+
+		return
+	}
+
+	srv.metrics.logins.With("success").Inc()
+	srv.setUser(r, tenant, email)
+
+	jwt := ddd.SignTenantJWTClaims(tenant, email)
+
+	fmt.Fprintf(w, `{"token":"%s"}`, jwt)
+}
+
+// writeTOTPError writes a failed enrollment or second factor
+func (srv httpService) writeTOTPError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ddd.ErrTOTPNotFound), errors.Is(err, ddd.ErrUserNotFound):
+		// 404
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ddd.ErrTOTPEnabled):
+		// 409
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, ddd.ErrInvalidTOTPCode):
+		// 401
+		writeError(w, http.StatusUnauthorized, err)
+	case errors.Is(err, ddd.ErrTOTPLocked):
+		// 429
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, totp.ErrInvalidSecret):
+		// 500, the key changed since the secret was sealed
+		srv.logger(r).Error("failed to open totp secret", "error", err)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error":"internal error"}`)
+	default:
+		writeRepositoryError(w, err)
+	}
+}
+
+func writeTOTPUnavailable(w http.ResponseWriter) {
+	// 501
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, `{"error":"two-factor authentication isn't available"}`)
+}
@@ -0,0 +1,279 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/totp"
+)
+
+// enrollTOTP enables two-factor authentication for the token's account, it returns the secret and the recovery codes
+func enrollTOTP(t *testing.T, ts *httptest.Server, token string) ([]byte, []string) {
+	t.Helper()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/2fa/totp", token, "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	enrollment := TOTPEnrollmentResponse{}
+	if err := json.Unmarshal([]byte(body), &enrollment); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/ddd:") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("unknown uri: %s", enrollment.URI)
+	}
+
+	if _, err := png.Decode(bytes.NewReader(enrollment.QRCode)); err != nil {
+		t.Errorf("qr code isn't a png: %s", err)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret %s: %s", enrollment.Secret, err)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/users/me/2fa/totp/confirm", token, fmt.Sprintf(`{"code":"%s"}`, totp.Code(secret, totp.Step(time.Now())-5)))
+	if resp.StatusCode != 401 || body != `{"error":"two-factor code is invalid"}` {
+		t.Errorf("a wrong code was confirmed: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/users/me/2fa/totp/confirm", token, fmt.Sprintf(`{"code":"%s"}`, totp.Code(secret, totp.Step(time.Now()))))
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	codes := RecoveryCodesResponse{}
+	if err := json.Unmarshal([]byte(body), &codes); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if len(codes.RecoveryCodes) != ddd.RecoveryCodeCount {
+		t.Errorf("unexpected recovery codes: %v", codes.RecoveryCodes)
+	}
+
+	return secret, codes.RecoveryCodes
+}
+
+// loginChallenge logs in with a password and returns the mfaToken
+func loginChallenge(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login", "", `{"email":"jackson@juandefu.ca","password":"pass"}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	login := LoginResponse{}
+	if err := json.Unmarshal([]byte(body), &login); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if login.Token != "" || login.MFAToken == "" {
+		t.Fatalf("login didn't ask for a second factor: `%s`", body)
+	}
+
+	return login.MFAToken
+}
+
+func TestTOTP(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	token := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+	secret, codes := enrollTOTP(t, ts, token)
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/2fa/totp", token, "")
+	if resp.StatusCode != 409 || body != `{"error":"two-factor authentication is already enabled"}` {
+		t.Errorf("an enabled secret was replaced: %d `%s`", resp.StatusCode, body)
+	}
+
+	challenge := loginChallenge(t, ts)
+
+	// a challenge isn't a token
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", challenge, "")
+	if resp.StatusCode != 400 || body != `{"error":"invalid jwt"}` {
+		t.Errorf("a challenge authenticated: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, token, totp.Code(secret, totp.Step(time.Now())+1)))
+	if resp.StatusCode != 401 || body != `{"error":"invalid mfa token"}` {
+		t.Errorf("a token was accepted as a challenge: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the confirming code was used
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, challenge, totp.Code(secret, totp.Step(time.Now()))))
+	if resp.StatusCode != 401 || body != `{"error":"two-factor code is invalid"}` {
+		t.Errorf("a used code was accepted: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the challenge was checked, even with a wrong code
+	code := totp.Code(secret, totp.Step(time.Now())+1)
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, challenge, code))
+	if resp.StatusCode != 401 || body != `{"error":"invalid mfa token"}` {
+		t.Errorf("a challenge was reused: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, loginChallenge(t, ts), code))
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	login := LoginResponse{}
+	if err := json.Unmarshal([]byte(body), &login); err != nil {
+		t.Fatalf("failed to decode %s: %s", body, err)
+	}
+
+	if email := ddd.ParseJWTClaims(login.Token); email != "jackson@juandefu.ca" {
+		t.Errorf("unknown token email: %s", email)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, loginChallenge(t, ts), code))
+	if resp.StatusCode != 401 || body != `{"error":"two-factor code is invalid"}` {
+		t.Errorf("a code was replayed: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","recoveryCode":"%s"}`, loginChallenge(t, ts), codes[0]))
+	if resp.StatusCode != 200 || !strings.HasPrefix(body, `{"token":`) {
+		t.Errorf("a recovery code wasn't accepted: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","recoveryCode":"%s"}`, loginChallenge(t, ts), codes[0]))
+	if resp.StatusCode != 401 || body != `{"error":"two-factor code is invalid"}` {
+		t.Errorf("a recovery code was reused: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s"}`, challenge))
+	if resp.StatusCode != 400 || body != `{"error":"code was empty"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestTOTP_Lockout(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	secret, _ := enrollTOTP(t, ts, ddd.SignJWTClaims("jackson@juandefu.ca"))
+
+	wrong := totp.Code(secret, totp.Step(time.Now())-5)
+
+	for i := 1; i < totp.MaxFailures; i++ {
+		resp, body := sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, loginChallenge(t, ts), wrong))
+		if resp.StatusCode != 401 || body != `{"error":"two-factor code is invalid"}` {
+			t.Fatalf("a wrong code wasn't rejected: %d `%s`", resp.StatusCode, body)
+		}
+	}
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, loginChallenge(t, ts), wrong))
+	if resp.StatusCode != 429 || body != fmt.Sprintf(`{"error":"%s"}`, ddd.ErrTOTPLocked) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the right code is refused too until the lockout is over
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, loginChallenge(t, ts), totp.Code(secret, totp.Step(time.Now())+1)))
+	if resp.StatusCode != 429 || body != fmt.Sprintf(`{"error":"%s"}`, ddd.ErrTOTPLocked) {
+		t.Errorf("a locked account logged in: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/graphql", "", fmt.Sprintf(`{"query":"mutation { login(email: \"jackson@juandefu.ca\", password: \"pass\", code: \"%s\") { token } }"}`, wrong))
+	if resp.StatusCode != 200 || !strings.Contains(body, `"code":"TOO_MANY_REQUESTS"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the consent screen's code is locked out too
+	client := registerOAuthClient(t, ts, `{"name":"Reports","redirectUris":["https://app.example.com/callback"],"scopes":["openid","email"]}`)
+
+	form := authorizeParams(client.ID, "openid email")
+	form.Set("decision", "allow")
+	form.Set("email", "jackson@juandefu.ca")
+	form.Set("password", "pass")
+	form.Set("code", totp.Code(secret, totp.Step(time.Now())+1))
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/oauth/authorize", "", form.Encode(), "Content-Type", formContentType)
+	if resp.StatusCode != 429 || !strings.Contains(body, ddd.ErrTOTPLocked.Error()) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestTOTP_GraphQL(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	_, codes := enrollTOTP(t, ts, ddd.SignJWTClaims("jackson@juandefu.ca"))
+
+	query := `{"query":"mutation { login(email: \"jackson@juandefu.ca\", password: \"pass\"%s) { token } }"}`
+
+	_, body := sendRequest(t, "POST", ts.URL+"/graphql", "", fmt.Sprintf(query, ""))
+	if !strings.Contains(body, `"code":"MFA_REQUIRED"`) {
+		t.Errorf("login didn't ask for a second factor: `%s`", body)
+	}
+
+	_, body = sendRequest(t, "POST", ts.URL+"/graphql", "", fmt.Sprintf(query, `, code: \"000000\"`))
+	if !strings.Contains(body, `"code":"UNAUTHENTICATED"`) {
+		t.Errorf("a wrong code was accepted: `%s`", body)
+	}
+
+	_, body = sendRequest(t, "POST", ts.URL+"/graphql", "", fmt.Sprintf(query, fmt.Sprintf(`, recoveryCode: \"%s\"`, codes[0])))
+	if !strings.Contains(body, `"token":"`) {
+		t.Errorf("a recovery code wasn't accepted: `%s`", body)
+	}
+}
+
+func TestTOTP_Reset(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	enrollTOTP(t, ts, ddd.SignJWTClaims("jackson@juandefu.ca"))
+
+	resp, body := sendRequest(t, "DELETE", ts.URL+"/users/jackson@juandefu.ca/2fa", ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+		t.Errorf("a user reset two-factor authentication: %d `%s`", resp.StatusCode, body)
+	}
+
+	admin := ddd.SignJWTClaims("admin@sabey.co")
+
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/users/jackson@juandefu.ca/2fa", admin, "")
+	if resp.StatusCode != 204 || body != "" {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "DELETE", ts.URL+"/users/jackson@juandefu.ca/2fa", admin, "")
+	if resp.StatusCode != 404 || body != `{"error":"two-factor authentication isn't enrolled"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	// the password is enough again
+	resp, body = sendRequest(t, "POST", ts.URL+"/login", "", `{"email":"jackson@juandefu.ca","password":"pass"}`)
+	if resp.StatusCode != 200 || !strings.HasPrefix(body, `{"token":`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestTOTP_Unavailable(t *testing.T) {
+	mockUsers, _ := newSeededServer()
+
+	// the embedded interface hides the mock's TOTPRepository methods
+	ts := httptest.NewServer(NewHTTPService(struct{ ddd.UserRepository }{mockUsers}))
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "POST", ts.URL+"/users/me/2fa/totp", ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+	if resp.StatusCode != 501 || body != `{"error":"two-factor authentication isn't available"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login", "", `{"email":"jackson@juandefu.ca","password":"pass"}`)
+	if resp.StatusCode != 200 || !strings.HasPrefix(body, `{"token":`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,23 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/tracing"
+)
+
+// startSpan starts an internal span under the request's server span
+func (srv httpService) startSpan(r *http.Request, name string) *tracing.Span {
+	_, span := srv.tracer.Start(r.Context(), name, tracing.SpanKindInternal)
+
+	return span
+}
+
+// hashPassword is traced on its own, hashing is one of the slower steps of signup and login
+func (srv httpService) hashPassword(r *http.Request, password string) string {
+	span := srv.startSpan(r, "ddd.HashPassword")
+	defer span.End()
+
+	return ddd.HashPassword(password)
+}
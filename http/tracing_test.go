@@ -0,0 +1,77 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+	"github.com/sabey/ddd/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
+
+	buf := &bytes.Buffer{}
+
+	tracer := tracing.NewTracer(
+		tracing.TracerOpts{
+			Exporter: tracing.NewWriterExporter(buf),
+		},
+	)
+
+	ts := httptest.NewServer(
+		NewHTTPServiceWithOpts(
+			HTTPServiceOpts{
+				UserRepository: tracing.NewUserRepository(mockUsers, tracer),
+				Tracer:         tracer,
+			},
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	reqBody := strings.NewReader(`{"email":"jackson@juandefu.ca","password":"pass"}`)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/login", ts.URL), reqBody)
+	if err != nil {
+		t.Errorf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Add("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to make http request: %s", err)
+	}
+	resp.Body.Close()
+
+	tracer.Close()
+
+	for _, name := range []string{
+		`"name":"POST /login"`,
+		`"name":"json.Decode"`,
+		`"name":"ddd.HashPassword"`,
+		`"name":"UserRepository.Login"`,
+		`"parentSpanId":"00f067aa0ba902b7"`,
+	} {
+		if !strings.Contains(buf.String(), name) {
+			t.Errorf("%s wasn't exported: `%s`", name, buf.String())
+		}
+	}
+
+	if strings.Count(buf.String(), `"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`) != 4 {
+		t.Errorf("spans weren't part of the caller's trace: `%s`", buf.String())
+	}
+}
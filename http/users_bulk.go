@@ -0,0 +1,180 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/bulk"
+)
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" --header "Content-Type: text/csv" \
+  --request POST \
+  --data-binary @users.csv \
+  http://localhost:8080/users/import?mode=skip&dryRun=true
+*/
+
+// maxImportBody bounds an import, larger files are imported with the cli
+const maxImportBody = 32 << 20
+
+func (srv httpService) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if !srv.requireAdmin(w, r, email) {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	format, ok := bulk.FormatOf(mediaType)
+	if !ok {
+		// 415
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s or %s", bulk.CSV.ContentType(), bulk.JSONL.ContentType()))
+
+		return
+	}
+
+	opts := bulk.ImportOpts{
+		Format: format,
+		Mode:   ddd.ImportMode(r.URL.Query().Get("mode")),
+	}
+
+	if s := r.URL.Query().Get("dryRun"); s != "" {
+		dryRun, err := strconv.ParseBool(s)
+		if err != nil {
+			// 400
+			writeError(w, http.StatusBadRequest, errors.New("dryRun must be true or false"))
+
+			return
+		}
+
+		opts.DryRun = dryRun
+	}
+
+	sw := &streamWriter{
+		w:           w,
+		contentType: format.ContentType(),
+	}
+	report := bulk.NewReportWriter(sw, format)
+
+	summary, err := bulk.Import(r.Context(), srv.userRepo, http.MaxBytesReader(w, r.Body, maxImportBody), opts, report.Write)
+	if err != nil && !sw.started {
+		srv.logger(r).Warn("failed to import users", "error", err)
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			// 413
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", maxImportBody))
+
+			return
+		}
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("users import stopped", "error", err)
+
+		// the status was already sent, the report ends on a row that says why
+		report.Write(bulk.Result{
+			Status: ddd.ImportFailed,
+			Error:  "import stopped: " + err.Error(),
+		})
+	}
+
+	srv.logger(r).Info("imported users",
+		"created", summary.Created, "updated", summary.Updated, "skipped", summary.Skipped, "failed", summary.Failed, "dry_run", opts.DryRun,
+	)
+
+	report.Flush()
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" \
+  http://localhost:8080/users/export?format=jsonl
+*/
+
+func (srv httpService) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if !srv.requireAdmin(w, r, email) {
+		return
+	}
+
+	opts := bulk.ExportOpts{
+		Format: bulk.Format(r.URL.Query().Get("format")),
+	}
+
+	if opts.Format == "" {
+		opts.Format = bulk.CSV
+	}
+
+	if err := opts.Format.Validate(); err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, errors.New("format must be csv or jsonl"))
+
+		return
+	}
+
+	if s := r.URL.Query().Get("passwordHashes"); s != "" {
+		passwordHashes, err := strconv.ParseBool(s)
+		if err != nil {
+			// 400
+			writeError(w, http.StatusBadRequest, errors.New("passwordHashes must be true or false"))
+
+			return
+		}
+
+		opts.PasswordHashes = passwordHashes
+	}
+
+	sw := &streamWriter{
+		w:           w,
+		contentType: opts.Format.ContentType(),
+	}
+
+	err := bulk.Export(r.Context(), srv.userRepo, sw, opts)
+	if err != nil && !sw.started {
+		srv.logger(r).Error("failed to export users", "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	// the client gets a truncated file, there's no way to tell it once the body started
+	if err != nil {
+		srv.logger(r).Error("users export stopped", "error", err)
+	}
+}
+
+// streamWriter sets the content type before the first byte of a streamed body
+// until then nothing was sent, and an error can still be written as json
+type streamWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	if !sw.started {
+		sw.w.Header().Set("Content-Type", sw.contentType)
+		sw.started = true
+	}
+
+	return sw.w.Write(b)
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestImportUsers_CSV(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	data := "email,firstName,lastName,password\n" +
+		"a@juandefu.ca,Jackson,Sabey,pass\n" +
+		"jackson@juandefu.ca,JACKSON,Sabey,pass\n" +
+		"b@juandefu.ca,,Sabey,pass\n"
+
+	resp, body := sendRequest(t, "POST", fmt.Sprintf("%s/users/import?mode=skip", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), data, "Content-Type", "text/csv")
+
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/csv" {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	expected := "line,email,status,error\n" +
+		"2,a@juandefu.ca,created,\n" +
+		"3,jackson@juandefu.ca,skipped,\n" +
+		"4,b@juandefu.ca,failed,firstName was empty\n"
+	if body != expected {
+		t.Errorf("unexpected report:\n%s", body)
+	}
+
+	if user, ok := mockUsers.User("a@juandefu.ca"); !ok || user.Password != ddd.HashPassword("pass") {
+		t.Errorf("user wasn't imported: %+v", user)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.FirstName != "Jackson" {
+		t.Errorf("a skipped user was changed: %+v", user)
+	}
+}
+
+func TestImportUsers_DryRun(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	data := `{"email":"jackson@juandefu.ca","firstName":"JACKSON","lastName":"Sabey","password":"pass"}` + "\n"
+
+	resp, body := sendRequest(t, "POST", fmt.Sprintf("%s/users/import?mode=upsert&dryRun=true", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), data, "Content-Type", "application/x-ndjson")
+
+	if resp.StatusCode != 200 || body != `{"line":1,"email":"jackson@juandefu.ca","status":"updated"}`+"\n" {
+		t.Errorf("unexpected report: %d `%s`", resp.StatusCode, body)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.FirstName != "Jackson" {
+		t.Errorf("a dry run changed a user: %+v", user)
+	}
+}
+
+func TestImportUsers_Invalid(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		query       string
+		email       string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"", "jackson@juandefu.ca", "text/csv", "email\n", 403},
+		{"", "admin@sabey.co", "application/json", "[]", 415},
+		{"?mode=replace", "admin@sabey.co", "text/csv", "email\n", 400},
+		{"?dryRun=maybe", "admin@sabey.co", "text/csv", "email\n", 400},
+		{"", "admin@sabey.co", "text/csv", "firstName\n", 400},
+	} {
+		resp, body := sendRequest(t, "POST", fmt.Sprintf("%s/users/import%s", ts.URL, tt.query), ddd.SignJWTClaims(tt.email), tt.body, "Content-Type", tt.contentType)
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%+v: expected %d, got %d `%s`", tt, tt.status, resp.StatusCode, body)
+		}
+	}
+}
+
+// secondImportFails fails every Import after the first
+type secondImportFails struct {
+	ddd.UserRepository
+	calls int
+}
+
+func (sf *secondImportFails) Import(ctx context.Context, opts ddd.UserImport) ([]ddd.ImportResult, error) {
+	sf.calls++
+	if sf.calls > 1 {
+		return nil, errors.New("connection reset")
+	}
+
+	return sf.UserRepository.Import(ctx, opts)
+}
+
+func TestImportUsers_Stopped(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	// two batches, the first one's report is larger than the csv writer's buffer
+	var data strings.Builder
+	data.WriteString("email,firstName,lastName,password\n")
+	for i := 0; i <= ddd.MaxImportBatch; i++ {
+		fmt.Fprintf(&data, "user%d@juandefu.ca,Jackson,Sabey,pass\n", i)
+	}
+
+	// nothing was sent yet, the error is the response
+	mockUsers.Inject(mock.MethodImport, mock.Fault{Err: errors.New("connection reset"), Times: 1})
+
+	resp, body := sendRequest(t, "POST", fmt.Sprintf("%s/users/import", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), data.String(), "Content-Type", "text/csv")
+	if resp.StatusCode != 400 || body != `{"error":"connection reset"}` {
+		t.Errorf("expected the error as the response: %d `%s`", resp.StatusCode, body)
+	}
+
+	ts2 := httptest.NewServer(NewHTTPService(&secondImportFails{UserRepository: mockUsers}))
+	defer ts2.Close()
+
+	// the status was sent with the first batch's report, the report ends on the error
+	resp, body = sendRequest(t, "POST", fmt.Sprintf("%s/users/import", ts2.URL), ddd.SignJWTClaims("admin@sabey.co"), data.String(), "Content-Type", "text/csv")
+	if resp.StatusCode != 200 || !strings.HasSuffix(body, "\n0,,failed,import stopped: connection reset\n") {
+		t.Errorf("expected the report to end with the error: %d", resp.StatusCode)
+	}
+
+	if _, ok := mockUsers.User("user0@juandefu.ca"); !ok {
+		t.Errorf("the first batch wasn't imported")
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "GET", fmt.Sprintf("%s/users/export", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), "")
+
+	expected := "email,firstName,lastName,password,passwordHash,displayName,locale,timezone,attributes,admin,disabled,version\n" +
+		"admin@sabey.co,Admin,Sabey,,,,,,,true,false,1\n" +
+		"jackson@juandefu.ca,Jackson,Sabey,,,,,,,false,false,1\n"
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/csv" || body != expected {
+		t.Errorf("unexpected export: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", fmt.Sprintf("%s/users/export?format=jsonl&passwordHashes=true", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), "")
+
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/x-ndjson" || !strings.Contains(body, `"passwordHash":"`+ddd.HashPassword("pass")+`"`) {
+		t.Errorf("unexpected export: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", fmt.Sprintf("%s/users/export", ts.URL), ddd.SignJWTClaims("jackson@juandefu.ca"), "")
+
+	if resp.StatusCode != 403 {
+		t.Errorf("expected 403: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", fmt.Sprintf("%s/users/export?format=xml", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), "")
+
+	if resp.StatusCode != 400 {
+		t.Errorf("expected 400: %d `%s`", resp.StatusCode, body)
+	}
+}
@@ -1,62 +1,114 @@
 package http
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/sabey/ddd"
 )
 
 /*
 curl --header "X-Authentication-Token: jwt-token" \
-  http://localhost:8080/users
+  http://localhost:8080/users?limit=100
 */
 
-func (srv httpService) ListUsers(w http.ResponseWriter, r *http.Request) {
-	jwt := r.Header.Get("X-Authentication-Token")
-	if jwt == "" {
-		// 400
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"jwt not found"}`)
+// maxListLimit bounds a page, without a limit every account is listed
+const maxListLimit = 1000
 
+func (srv httpService) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if email := srv.authenticate(w, r); email == "" {
 		return
 	}
 
-	// validate jwt
-	if email := ddd.ParseJWTClaims(jwt); email == "" {
-		// 400
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"invalid jwt"}`)
+	opts := ddd.UserList{}
 
-		return
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			// 400
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxListLimit))
+
+			return
+		}
+
+		opts.Limit = limit
 	}
 
-	users, err := srv.userRepo.List()
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			// 400
+			writeError(w, http.StatusBadRequest, errors.New("cursor was invalid"))
+
+			return
+		}
+
+		opts.After = string(after)
+	}
+
+	users, err := srv.userRepo.List(r.Context(), opts)
+
 	if err != nil {
-		// 400 - we should be able to override this status code but we will use this for now
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		srv.logger(r).Error("failed to list users", "error", err)
+
+		writeRepositoryError(w, err)
 
 		return
 	}
 
-	bs, _ := json.Marshal(UsersResponse{
-		Users: newUserResponse(users),
-	})
+	response := UsersResponse{
+		Users: newUsersResponse(users),
+	}
+
+	// a full page may be followed by another, the cursor is the last email so pages stay stable while accounts are added
+	if opts.Limit > 0 && len(users) == opts.Limit {
+		response.Next = base64.RawURLEncoding.EncodeToString([]byte(users[len(users)-1].Email))
+	}
+
+	bs, _ := json.Marshal(response)
 
 	fmt.Fprintf(w, "%s", bs)
 }
 
-func newUserResponse(users []*ddd.User) []UserResponse {
+func newUserResponse(user *ddd.User) UserResponse {
+	return UserResponse{
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Attributes:  user.Attributes,
+		AvatarURL:   avatarURL(user),
+	}
+}
+
+// avatarURL is relative to the service, it changes with every upload so clients can cache it forever
+func avatarURL(user *ddd.User) string {
+	if user.Avatar == "" {
+		return ""
+	}
+
+	u := "/users/" + url.PathEscape(user.Email) + "/avatar?v=" + user.Avatar
+
+	// the route is public, there's no token to tell the organization
+	if user.Organization != "" && user.Organization != ddd.DefaultOrganization {
+		u += "&organization=" + url.QueryEscape(user.Organization)
+	}
+
+	return u
+}
+
+func newUsersResponse(users []*ddd.User) []UserResponse {
 	ur := []UserResponse{}
 
 	for _, user := range users {
-		ur = append(ur, UserResponse{
-			Email:     user.Email,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-		})
+		ur = append(ur, newUserResponse(user))
 	}
 
 	return ur
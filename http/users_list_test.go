@@ -1,6 +1,7 @@
 package http
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -124,12 +125,12 @@ func TestListUsers_NoUsers(t *testing.T) {
 
 func TestListUsers_Success(t *testing.T) {
 	mockUsers := mock.NewUserRepository()
-	mockUsers.Accounts["jackson@juandefu.ca"] = ddd.User{
+	mockUsers.Seed(ddd.User{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  "pass",
-	}
+	})
 
 	ts := httptest.NewServer(
 		NewHTTPService(
@@ -171,18 +172,18 @@ func TestListUsers_Success(t *testing.T) {
 
 func TestListUsers_SuccessMultiple(t *testing.T) {
 	mockUsers := mock.NewUserRepository()
-	mockUsers.Accounts["jackson@juandefu.ca"] = ddd.User{
+	mockUsers.Seed(ddd.User{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  "pass",
-	}
-	mockUsers.Accounts["jackson@sabey.co"] = ddd.User{
+	})
+	mockUsers.Seed(ddd.User{
 		Email:     "jackson@sabey.co",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  "pass",
-	}
+	})
 
 	ts := httptest.NewServer(
 		NewHTTPService(
@@ -217,7 +218,69 @@ func TestListUsers_SuccessMultiple(t *testing.T) {
 		t.Errorf("route failed?")
 	}
 
-	if string(body) != `{"users":[{"email":"jackson@sabey.co","firstName":"Jackson","lastName":"Sabey"},{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey"}]}` {
+	if string(body) != `{"users":[{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey"},{"email":"jackson@sabey.co","firstName":"Jackson","lastName":"Sabey"}]}` {
 		t.Errorf("unknown body: `%s`", body)
 	}
 }
+
+func TestListUsers_Pages(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(
+		ddd.User{Email: "a@sabey.co", FirstName: "A", LastName: "Sabey"},
+		ddd.User{Email: "b@sabey.co", FirstName: "B", LastName: "Sabey"},
+		ddd.User{Email: "c@sabey.co", FirstName: "C", LastName: "Sabey"},
+	)
+
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mockUsers,
+		),
+	)
+	defer ts.Close()
+
+	get := func(query string) (int, UsersResponse) {
+		req, _ := http.NewRequest("GET", ts.URL+"/users"+query, nil)
+		req.Header.Add("X-Authentication-Token", ddd.SignJWTClaims("a@sabey.co"))
+
+		resp, err := new(http.Client).Do(req)
+		if err != nil {
+			t.Fatalf("failed to make http request: %s", err)
+		}
+		defer resp.Body.Close()
+
+		response := UsersResponse{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	emails := []string{}
+	pages := 0
+
+	for query := "?limit=2"; ; pages++ {
+		status, response := get(query)
+		if status != 200 {
+			t.Fatalf("route failed: %d", status)
+		}
+
+		for _, user := range response.Users {
+			emails = append(emails, user.Email)
+		}
+
+		if response.Next == "" {
+			break
+		}
+
+		query = "?limit=2&cursor=" + response.Next
+	}
+
+	if fmt.Sprint(emails) != "[a@sabey.co b@sabey.co c@sabey.co]" || pages != 1 {
+		t.Errorf("unknown pages: %v %d", emails, pages)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=1001", "?limit=two", "?cursor=@@@"} {
+		if status, _ := get(query); status != 400 {
+			t.Errorf("%s: expected 400, got %d", query, status)
+		}
+	}
+}
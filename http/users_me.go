@@ -0,0 +1,35 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+/*
+curl --header "X-Authentication-Token: jwt-token" \
+  http://localhost:8080/users/me
+*/
+
+func (srv httpService) GetMe(w http.ResponseWriter, r *http.Request) {
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	user, err := srv.userRepo.Get(r.Context(), email)
+	if err != nil {
+		srv.logger(r).Warn("failed to get user", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	bs, _ := json.Marshal(newUserResponse(user))
+
+	// sent back as If-Match to update this version
+	w.Header().Set("ETag", etag(user.Version))
+
+	fmt.Fprintf(w, "%s", bs)
+}
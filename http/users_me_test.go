@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/cache"
+	"github.com/sabey/ddd/mock"
+	"github.com/sabey/ddd/totp"
+)
+
+func TestGetMe_NoJWT(t *testing.T) {
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mock.NewUserRepository(),
+		),
+	)
+	defer ts.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/users/me", ts.URL))
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 400 {
+		t.Errorf("route worked?")
+	}
+}
+
+func TestGetMe_Success(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+		Version:   4,
+	})
+
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mockUsers,
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/users/me", ts.URL), nil)
+	if err != nil {
+		t.Fatalf("failed to create new http request: %s", err)
+	}
+
+	req.Header.Add("X-Authentication-Token", ddd.SignJWTClaims("jackson@juandefu.ca"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read body: %s", err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed?")
+	}
+
+	if string(body) != `{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey"}` {
+		t.Errorf("unknown body: `%s`", body)
+	}
+
+	if resp.Header.Get("ETag") != `"4"` {
+		t.Errorf("unknown etag: `%s`", resp.Header.Get("ETag"))
+	}
+}
+
+func TestGetMe_Disabled(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	// the tokens are given before the accounts are disabled
+	jwt := ddd.SignJWTClaims("jackson@juandefu.ca")
+	admin := ddd.SignJWTClaims("admin@sabey.co")
+
+	for _, email := range []string{"jackson@juandefu.ca", "admin@sabey.co"} {
+		if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: email, Disabled: ddd.Bool(true)}); err != nil {
+			t.Fatalf("failed to disable user: %s", err)
+		}
+	}
+
+	disabled := fmt.Sprintf(`{"error":"%s"}`, ddd.ErrUserDisabled)
+
+	resp, body := sendRequest(t, "GET", ts.URL+"/users/me", jwt, "")
+	if resp.StatusCode != 403 || body != disabled {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/export", admin, "")
+	if resp.StatusCode != 403 || body != disabled {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/graphql", jwt, `{"query":"{ me { email } }"}`)
+	if resp.StatusCode != 200 || !strings.Contains(body, `"code":"FORBIDDEN"`) {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(false)}); err != nil {
+		t.Fatalf("failed to enable user: %s", err)
+	}
+
+	// the token works again once the account is enabled
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", jwt, "")
+	if resp.StatusCode != 200 {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestGetMe_DisabledCached(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+	})
+
+	authenticator, err := totp.NewAuthenticator(totp.AuthenticatorOpts{Repository: mockUsers})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %s", err)
+	}
+
+	ts := httptest.NewServer(NewHTTPServiceWithOpts(HTTPServiceOpts{
+		UserRepository:         cache.NewUserRepository(mockUsers, cache.Opts{}),
+		UncachedUserRepository: mockUsers,
+		TOTP:                   authenticator,
+	}))
+	defer ts.Close()
+
+	jwt := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+	secret, _ := enrollTOTP(t, ts, jwt)
+	challenge := loginChallenge(t, ts)
+
+	// the account is cached, then disabled without the cache being told
+	resp, body := sendRequest(t, "GET", ts.URL+"/users/me", jwt, "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	if _, err := mockUsers.Update(context.Background(), ddd.UserUpdate{Email: "jackson@juandefu.ca", Disabled: ddd.Bool(true)}); err != nil {
+		t.Fatalf("failed to disable user: %s", err)
+	}
+
+	disabled := fmt.Sprintf(`{"error":"%s"}`, ddd.ErrUserDisabled)
+
+	resp, body = sendRequest(t, "GET", ts.URL+"/users/me", jwt, "")
+	if resp.StatusCode != 403 || body != disabled {
+		t.Errorf("a disabled account's token was served from the cache: %d `%s`", resp.StatusCode, body)
+	}
+
+	resp, body = sendRequest(t, "POST", ts.URL+"/login/mfa", "", fmt.Sprintf(`{"mfaToken":"%s","code":"%s"}`, challenge, totp.Code(secret, totp.Step(time.Now())+1)))
+	if resp.StatusCode != 403 || body != disabled {
+		t.Errorf("a disabled account finished its login from the cache: %d `%s`", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,218 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sabey/ddd"
+)
+
+// mergePatchContentType is the only body PATCH accepts, RFC 7396
+const mergePatchContentType = "application/merge-patch+json"
+
+/*
+curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: application/merge-patch+json" \
+  --request PATCH \
+  --data '{"lastName": "SABEY"}' \
+  http://localhost:8080/users
+*/
+
+func (srv httpService) PatchMe(w http.ResponseWriter, r *http.Request) {
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	srv.patchUser(w, r, email)
+}
+
+/*
+curl --header "X-Authentication-Token: admin-jwt-token" --header "Content-Type: application/merge-patch+json" \
+  --request PATCH \
+  --data '{"lastName": "SABEY"}' \
+  http://localhost:8080/users/jackson@juandefu.ca
+*/
+
+func (srv httpService) PatchUser(w http.ResponseWriter, r *http.Request) {
+	email := srv.authenticate(w, r)
+	if email == "" {
+		return
+	}
+
+	if !srv.requireAdmin(w, r, email) {
+		return
+	}
+
+	target := strings.TrimPrefix(r.URL.Path, "/users/")
+
+	if strings.Contains(target, "/") {
+		// 400, /users/{email}/something isn't an account
+		writeError(w, http.StatusBadRequest, errors.New("invalid email"))
+
+		return
+	}
+
+	if err := ddd.ValidateEmail(target); err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	srv.patchUser(w, r, target)
+}
+
+func (srv httpService) patchUser(w http.ResponseWriter, r *http.Request, email string) {
+	defer r.Body.Close()
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mergePatchContentType {
+		// 415
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s", mergePatchContentType))
+
+		return
+	}
+
+	version, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		writeRepositoryError(w, ddd.ErrVersionMismatch)
+
+		return
+	}
+
+	patch := map[string]json.RawMessage{}
+
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	span.End()
+
+	if err != nil {
+		// 400
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"invalid request"}`)
+
+		return
+	}
+
+	opts, err := newUserPatch(email, patch)
+	if err != nil {
+		// 400
+		writeError(w, http.StatusBadRequest, err)
+
+		return
+	}
+	opts.Version = version
+
+	var user *ddd.User
+	if opts.Empty() {
+		// an empty patch changes nothing, the current representation is returned
+		user, err = srv.userRepo.Get(r.Context(), email)
+		if err == nil && version > 0 && user.Version != version {
+			err = ddd.ErrVersionMismatch
+		}
+	} else {
+		user, err = srv.userRepo.Update(r.Context(), opts)
+	}
+
+	if err != nil {
+		srv.logger(r).Warn("failed to patch user", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
+
+		return
+	}
+
+	bs, _ := json.Marshal(newUserResponse(user))
+
+	w.Header().Set("ETag", etag(user.Version))
+
+	fmt.Fprintf(w, "%s", bs)
+}
+
+// newUserPatch validates the members of a merge patch, members that aren't sent are left as they are
+func newUserPatch(email string, patch map[string]json.RawMessage) (ddd.UserUpdate, error) {
+	opts := ddd.UserUpdate{
+		Email: email,
+	}
+
+	// the first invalid member is reported, the order must not depend on the map
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+
+		switch key {
+		case "firstName":
+			opts.FirstName, err = patchString(key, patch[key])
+		case "lastName":
+			opts.LastName, err = patchString(key, patch[key])
+		case "displayName":
+			opts.DisplayName, err = patchOptionalString(key, patch[key])
+		case "locale":
+			opts.Locale, err = patchOptionalString(key, patch[key])
+		case "timezone":
+			opts.Timezone, err = patchOptionalString(key, patch[key])
+		case "attributes":
+			opts.Attributes, err = patchAttributes(patch[key])
+		case "email":
+			err = errors.New("email can't be changed")
+		default:
+			err = fmt.Errorf("unknown field: %s", key)
+		}
+
+		if err != nil {
+			return opts, err
+		}
+	}
+
+	if opts.Empty() {
+		return opts, nil
+	}
+
+	return opts, opts.Validate()
+}
+
+// patchString decodes a required string member, null would remove it
+func patchString(key string, raw json.RawMessage) (*string, error) {
+	if string(raw) == "null" {
+		return nil, fmt.Errorf("%s can't be removed", key)
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("%s must be a string", key)
+	}
+
+	return &s, nil
+}
+
+// patchOptionalString decodes an optional string member, null clears it
+func patchOptionalString(key string, raw json.RawMessage) (*string, error) {
+	if string(raw) == "null" {
+		return ddd.String(""), nil
+	}
+
+	return patchString(key, raw)
+}
+
+// patchAttributes decodes the attributes member, it's merged the same way as the patch itself
+func patchAttributes(raw json.RawMessage) (map[string]interface{}, error) {
+	if string(raw) == "null" {
+		return nil, errors.New("attributes can't be removed, set its keys to null")
+	}
+
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, errors.New("attributes must be an object")
+	}
+
+	return attrs, nil
+}
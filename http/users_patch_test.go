@@ -0,0 +1,213 @@
+package http
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestPatchMe_Success(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"lastName":"SABEY"}`, "Content-Type", mergePatchContentType)
+
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed: %d", resp.StatusCode)
+	}
+
+	if body != `{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"SABEY"}` {
+		t.Errorf("unknown body: `%s`", body)
+	}
+
+	if resp.Header.Get("ETag") != `"2"` {
+		t.Errorf("unknown etag: `%s`", resp.Header.Get("ETag"))
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.FirstName != "Jackson" || user.LastName != "SABEY" {
+		t.Errorf("patch wasn't stored: %+v", user)
+	}
+}
+
+func TestPatchMe_Empty(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{}`, "Content-Type", mergePatchContentType)
+
+	if resp.StatusCode != 200 || body != `{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey"}` {
+		t.Errorf("unknown response: %d `%s`", resp.StatusCode, body)
+	}
+
+	if calls := mockUsers.Calls(mock.MethodUpdate); len(calls) != 0 {
+		t.Errorf("an empty patch was written: %d", len(calls))
+	}
+}
+
+func TestPatchMe_ContentType(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"lastName":"SABEY"}`, "Content-Type", "application/json")
+
+	if resp.StatusCode != 415 {
+		t.Errorf("expected 415, got: %d", resp.StatusCode)
+	}
+
+	if body != `{"error":"content type must be application/merge-patch+json"}` {
+		t.Errorf("unknown body: `%s`", body)
+	}
+}
+
+func TestPatchMe_Invalid(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		body     string
+		expected string
+	}{
+		{`[]`, `{"error":"invalid request"}`},
+		{`{"nickname":"jack"}`, `{"error":"unknown field: nickname"}`},
+		{`{"email":"jackson@sabey.co"}`, `{"error":"email can't be changed"}`},
+		{`{"firstName":null}`, `{"error":"firstName can't be removed"}`},
+		{`{"firstName":1}`, `{"error":"firstName must be a string"}`},
+		{`{"firstName":""}`, `{"error":"firstName was empty"}`},
+		{`{"firstName":"JACKSON","\"quoted\"":1}`, `{"error":"unknown field: \"quoted\""}`},
+	} {
+		resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), tt.body, "Content-Type", mergePatchContentType)
+
+		if resp.StatusCode != 400 {
+			t.Errorf("%s: expected 400, got: %d", tt.body, resp.StatusCode)
+		}
+
+		if body != tt.expected {
+			t.Errorf("%s: unknown body: `%s`", tt.body, body)
+		}
+	}
+
+	if calls := mockUsers.Calls(mock.MethodUpdate); len(calls) != 0 {
+		t.Errorf("an invalid patch was written: %d", len(calls))
+	}
+}
+
+func TestPatchMe_IfMatch(t *testing.T) {
+	_, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, _ := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"lastName":"SABEY"}`, "Content-Type", mergePatchContentType, "If-Match", `"1"`)
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed: %d", resp.StatusCode)
+	}
+
+	resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"lastName":"Sabey"}`, "Content-Type", mergePatchContentType, "If-Match", `"1"`)
+	if resp.StatusCode != 412 || body != `{"error":"user account was modified"}` {
+		t.Errorf("expected 412: %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestPatchUser_Admin(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "PATCH", fmt.Sprintf("%s/users/jackson@juandefu.ca", ts.URL), ddd.SignJWTClaims("admin@sabey.co"), `{"firstName":"JACKSON"}`, "Content-Type", mergePatchContentType)
+
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.FirstName != "JACKSON" {
+		t.Errorf("patch wasn't stored: %+v", user)
+	}
+}
+
+func TestPatchUser_Forbidden(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "PATCH", fmt.Sprintf("%s/users/admin@sabey.co", ts.URL), ddd.SignJWTClaims("jackson@juandefu.ca"), `{"firstName":"JACKSON"}`, "Content-Type", mergePatchContentType)
+
+	if resp.StatusCode != 403 || body != `{"error":"forbidden"}` {
+		t.Errorf("expected 403: %d `%s`", resp.StatusCode, body)
+	}
+
+	if user, _ := mockUsers.User("admin@sabey.co"); user.FirstName != "Admin" {
+		t.Errorf("forbidden patch was stored: %+v", user)
+	}
+}
+
+func TestPatchUser_InvalidPath(t *testing.T) {
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	for path, expected := range map[string]string{
+		"/users/":                         `{"error":"email was empty"}`,
+		"/users/jackson@juandefu.ca/":     `{"error":"invalid email"}`,
+		"/users/jackson@juandefu.ca/name": `{"error":"invalid email"}`,
+	} {
+		resp, body := sendRequest(t, "PATCH", ts.URL+path, ddd.SignJWTClaims("admin@sabey.co"), `{"firstName":"JACKSON"}`, "Content-Type", mergePatchContentType)
+
+		if resp.StatusCode != 400 || body != expected {
+			t.Errorf("%s: expected 400: %d `%s`", path, resp.StatusCode, body)
+		}
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.FirstName != "Jackson" {
+		t.Errorf("invalid patch was stored: %+v", user)
+	}
+}
+
+func TestPatchMe_Profile(t *testing.T) {
+	if err := ddd.Attributes.Register("team", ddd.AttributeString); err != nil {
+		t.Fatalf("failed to register attribute: %s", err)
+	}
+
+	if err := ddd.Attributes.Register("seats", ddd.AttributeNumber); err != nil {
+		t.Fatalf("failed to register attribute: %s", err)
+	}
+
+	mockUsers, ts := newSeededServer()
+	defer ts.Close()
+
+	resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"displayName":"Jack","locale":"en-CA","timezone":"America/Vancouver","attributes":{"team":"core","seats":3}}`, "Content-Type", mergePatchContentType)
+
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	expected := `{"email":"jackson@juandefu.ca","firstName":"Jackson","lastName":"Sabey","displayName":"Jack","locale":"en-CA","timezone":"America/Vancouver","attributes":{"seats":3,"team":"core"}}`
+	if body != expected {
+		t.Errorf("unknown body: `%s`", body)
+	}
+
+	// null clears an optional field and removes an attribute
+	resp, body = sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), `{"timezone":null,"attributes":{"seats":null}}`, "Content-Type", mergePatchContentType)
+
+	if resp.StatusCode != 200 {
+		t.Errorf("route failed: %d `%s`", resp.StatusCode, body)
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.Timezone != "" || user.Locale != "en-CA" || len(user.Attributes) != 1 {
+		t.Errorf("patch wasn't stored: %+v", user)
+	}
+
+	for _, tt := range []struct {
+		body     string
+		expected string
+	}{
+		{`{"locale":"english"}`, `{"error":"locale isn't a BCP 47 language tag: english"}`},
+		{`{"timezone":"Mars/Olympus_Mons"}`, `{"error":"timezone isn't an IANA time zone: Mars/Olympus_Mons"}`},
+		{`{"attributes":null}`, `{"error":"attributes can't be removed, set its keys to null"}`},
+		{`{"attributes":[]}`, `{"error":"attributes must be an object"}`},
+		{`{"attributes":{"unknown":1}}`, `{"error":"unknown attribute: unknown"}`},
+		{`{"attributes":{"seats":"3"}}`, `{"error":"attribute seats must be a number"}`},
+	} {
+		resp, body := sendRequest(t, "PATCH", ts.URL+"/users", ddd.SignJWTClaims("jackson@juandefu.ca"), tt.body, "Content-Type", mergePatchContentType)
+
+		if resp.StatusCode != 400 || body != tt.expected {
+			t.Errorf("%s: unknown response: %d `%s`", tt.body, resp.StatusCode, body)
+		}
+	}
+}
@@ -10,36 +10,35 @@ import (
 
 /*
 curl --header "X-Authentication-Token: jwt-token" --header "Content-Type: application/json" \
+  --header 'If-Match: "1"' \
   --request PUT \
   --data '{"firstName": "JACKSON","lastName": "SABEY"}' \
   http://localhost:8080/users
 */
 
 func (srv httpService) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	jwt := r.Header.Get("X-Authentication-Token")
-	if jwt == "" {
-		// 400
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"jwt not found"}`)
-
+	email := srv.authenticate(w, r)
+	if email == "" {
 		return
 	}
 
-	// validate jwt
-	email := ddd.ParseJWTClaims(jwt)
-	if email == "" {
-		// 400
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"invalid jwt"}`)
+	defer r.Body.Close()
+
+	// If-Match is optional, without it the last write wins
+	version, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		writeRepositoryError(w, ddd.ErrVersionMismatch)
 
 		return
 	}
 
-	defer r.Body.Close()
-
 	request := &UserRequest{}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	span := srv.startSpan(r, "json.Decode")
+	err := json.NewDecoder(r.Body).Decode(&request)
+	span.End()
+
+	if err != nil {
 		// 400
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, `{"error":"invalid request"}`)
@@ -55,20 +54,27 @@ func (srv httpService) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := srv.userRepo.Update(
+	user, err := srv.userRepo.Update(
+		r.Context(),
 		ddd.UserUpdate{
-			Email:     email,
-			FirstName: request.FirstName,
-			LastName:  request.LastName,
+			Email:       email,
+			FirstName:   ddd.String(request.FirstName),
+			LastName:    ddd.String(request.LastName),
+			DisplayName: request.DisplayName,
+			Locale:      request.Locale,
+			Timezone:    request.Timezone,
+			Attributes:  request.Attributes,
+			Version:     version,
 		},
 	)
 	if err != nil {
-		// 400 - we should be able to override this status code but we will use this for now
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		srv.logger(r).Warn("failed to update user", "email", email, "error", err)
+
+		writeRepositoryError(w, err)
 
 		return
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -207,12 +207,12 @@ func TestUpdateUser_InvalidRequest_Lastname(t *testing.T) {
 
 func TestUpdateUser_Success(t *testing.T) {
 	mockUsers := mock.NewUserRepository()
-	mockUsers.Accounts["jackson@juandefu.ca"] = ddd.User{
+	mockUsers.Seed(ddd.User{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  "pass",
-	}
+	})
 
 	ts := httptest.NewServer(
 		NewHTTPService(
@@ -285,3 +285,84 @@ func TestUpdateUser_Success(t *testing.T) {
 		t.Errorf("lastName was not updated")
 	}
 }
+
+func TestUpdateUser_IfMatch(t *testing.T) {
+	mockUsers := mock.NewUserRepository()
+	mockUsers.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+
+	ts := httptest.NewServer(
+		NewHTTPService(
+			mockUsers,
+		),
+	)
+	defer ts.Close()
+
+	client := new(http.Client)
+
+	jwt := ddd.SignJWTClaims("jackson@juandefu.ca")
+
+	update := func(ifMatch string) *http.Response {
+		reqBody := strings.NewReader(`{"firstName":"JACKSON","lastName":"SABEY"}`)
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/users", ts.URL), reqBody)
+		if err != nil {
+			t.Fatalf("failed to create new http request: %s", err)
+		}
+
+		req.Header.Add("X-Authentication-Token", jwt)
+		req.Header.Add("If-Match", ifMatch)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make http request: %s", err)
+		}
+
+		return resp
+	}
+
+	resp := update(`"1"`)
+	resp.Body.Close()
+
+	if resp.StatusCode != 204 {
+		t.Errorf("update of the current version failed: %d", resp.StatusCode)
+	}
+
+	if resp.Header.Get("ETag") != `"2"` {
+		t.Errorf("unknown etag: `%s`", resp.Header.Get("ETag"))
+	}
+
+	// both a stale version and a tag that can't match are rejected
+	for _, ifMatch := range []string{`"1"`, `W/"2"`, `2`} {
+		resp := update(ifMatch)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("failed to read body: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != 412 {
+			t.Errorf("%s: expected 412, got: %d", ifMatch, resp.StatusCode)
+		}
+
+		if string(body) != `{"error":"user account was modified"}` {
+			t.Errorf("%s: unknown body: `%s`", ifMatch, body)
+		}
+	}
+
+	if user, _ := mockUsers.User("jackson@juandefu.ca"); user.Version != 2 {
+		t.Errorf("a rejected update was stored: %+v", user)
+	}
+
+	resp = update(`*`)
+	resp.Body.Close()
+
+	if resp.StatusCode != 204 || resp.Header.Get("ETag") != `"3"` {
+		t.Errorf("If-Match * should update any version: %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
@@ -0,0 +1,83 @@
+package ddd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// every IdentityRepository returns these, callers can match them with errors.Is
+var (
+	ErrIdentityExists   = errors.New("identity is already linked to an account")
+	ErrIdentityNotFound = errors.New("identity isn't linked to an account")
+)
+
+// MaxIdentitySubjectLength is OpenID Connect's limit on a sub claim
+const MaxIdentitySubjectLength = 255
+
+// Identity links an account to its subject at an external identity provider
+type Identity struct {
+	// Provider is the name the provider was configured with, "acme-sso"
+	Provider string
+	// Subject is the provider's sub claim, it's stable while the account's email at the provider may change
+	Subject      string
+	Organization string
+	Email        string
+	CreatedAt    time.Time
+}
+
+// IdentityRepository links the subjects of external identity providers to accounts
+// GetIdentity isn't scoped to the context's tenant, a login only knows the provider's subject, ListIdentities is
+// deleting the account unlinks its identities
+type IdentityRepository interface {
+	// CreateIdentity returns ErrIdentityExists when the provider's subject is linked, and ErrUserNotFound without the account
+	CreateIdentity(context.Context, IdentityCreate) (*Identity, error)
+	// GetIdentity returns ErrIdentityNotFound when the provider's subject isn't linked
+	GetIdentity(ctx context.Context, provider string, subject string) (*Identity, error)
+	// ListIdentities returns the account's identities ordered by creation
+	ListIdentities(ctx context.Context, email string) ([]*Identity, error)
+}
+
+type IdentityCreate struct {
+	Provider     string
+	Subject      string
+	Organization string
+	Email        string
+}
+
+func (ic IdentityCreate) Validate() error {
+	if err := ValidateProviderName(ic.Provider); err != nil {
+		return err
+	}
+
+	if ic.Subject == "" {
+		return errors.New("subject was empty")
+	}
+
+	if len(ic.Subject) > MaxIdentitySubjectLength {
+		return fmt.Errorf("subject can't be longer than %d characters", MaxIdentitySubjectLength)
+	}
+
+	if err := ValidateOrganizationID(ic.Organization); err != nil {
+		return err
+	}
+
+	return ValidateEmail(ic.Email)
+}
+
+var providerNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidateProviderName checks that an identity provider's name is lowercase letters, digits and dashes, it's used in urls
+func ValidateProviderName(name string) error {
+	if name == "" {
+		return errors.New("provider was empty")
+	}
+
+	if !providerNameRegexp.MatchString(name) {
+		return fmt.Errorf("provider isn't lowercase letters, digits and dashes: %s", name)
+	}
+
+	return nil
+}
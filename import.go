@@ -0,0 +1,100 @@
+package ddd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxImportBatch bounds the users of one UserImport, larger imports are split into batches
+const MaxImportBatch = 1000
+
+// ImportMode is what an import does with a user whose account already exists
+type ImportMode string
+
+const (
+	// ImportFail reports the user as failed with ErrUserExists, it's the default
+	ImportFail ImportMode = "fail"
+	// ImportSkip leaves the account as it is
+	ImportSkip ImportMode = "skip"
+	// ImportUpsert overwrites the account's names, password, profile and attributes, its avatar, disabled and admin are kept
+	// an import can't grant or revoke admin, only a created account takes its row's
+	ImportUpsert ImportMode = "upsert"
+)
+
+type ImportStatus string
+
+const (
+	ImportCreated ImportStatus = "created"
+	ImportUpdated ImportStatus = "updated"
+	ImportSkipped ImportStatus = "skipped"
+	ImportFailed  ImportStatus = "failed"
+)
+
+// UserImport creates a batch of accounts, every user succeeds or fails on its own
+type UserImport struct {
+	// Users are created like UserCreate, their passwords are already hashed
+	Users []UserCreate
+	Mode  ImportMode
+	// DryRun reports what the import would do without writing anything
+	DryRun bool
+}
+
+// ImportResult is the outcome of one of UserImport's users, in the same order
+type ImportResult struct {
+	Email  string
+	Status ImportStatus
+	// Err is why the user failed, a validation error or ErrUserExists
+	Err error
+}
+
+func (ui UserImport) Validate() error {
+	switch ui.Mode {
+	case "", ImportFail, ImportSkip, ImportUpsert:
+	default:
+		return fmt.Errorf("mode was invalid: %s", ui.Mode)
+	}
+
+	if len(ui.Users) > MaxImportBatch {
+		return errors.New("too many users")
+	}
+
+	return nil
+}
+
+// Results validates every user, the invalid ones and the repeats of an earlier email are failed
+// the repositories import the users whose result doesn't have a status yet
+func (ui UserImport) Results() []ImportResult {
+	results := make([]ImportResult, len(ui.Users))
+	seen := make(map[string]bool, len(ui.Users))
+
+	for i, user := range ui.Users {
+		results[i].Email = user.Email
+
+		if err := user.Validate(); err != nil {
+			results[i].Status = ImportFailed
+			results[i].Err = err
+
+			continue
+		}
+
+		if seen[user.Email] {
+			results[i].Status = ImportFailed
+			results[i].Err = errors.New("email was repeated")
+
+			continue
+		}
+
+		seen[user.Email] = true
+	}
+
+	return results
+}
+
+// Conflict is the result of a user whose account already exists, in any mode but upsert
+func (ui UserImport) Conflict(email string) ImportResult {
+	if ui.Mode == ImportSkip {
+		return ImportResult{Email: email, Status: ImportSkipped}
+	}
+
+	return ImportResult{Email: email, Status: ImportFailed, Err: ErrUserExists}
+}
@@ -1,6 +1,8 @@
 package ddd
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"time"
 
@@ -9,12 +11,50 @@ import (
 
 var hmacSecret []byte = []byte("abcdefghijklmnopqrstuvwxyz")
 
+// every token is signed with hmacSecret, its typ claim is what it can be used for
+const (
+	tokenTypeAccess          = "access"
+	tokenTypeMFAChallenge    = "mfa"
+	tokenTypePasskeyCeremony = "webauthn"
+	tokenTypeIDToken         = "id"
+	tokenTypeFederatedLogin  = "oidc"
+)
+
+// legacyAccessClaims are the only claims of an access token signed before the typ claim
+var legacyAccessClaims = map[string]bool{
+	"email":  true,
+	"tenant": true,
+	"nbf":    true,
+}
+
+// idTokenClaims are the claims the scopes can add to an id token, any other is dropped
+var idTokenClaims = map[string]bool{
+	"email":          true,
+	"email_verified": true,
+	"name":           true,
+	"given_name":     true,
+	"family_name":    true,
+	"locale":         true,
+	"zoneinfo":       true,
+}
+
+// SignJWTClaims signs a token for an account of DefaultOrganization
 func SignJWTClaims(
 	email string,
+) string {
+	return SignTenantJWTClaims(DefaultOrganization, email)
+}
+
+// SignTenantJWTClaims signs a token for an account of the tenant's organization
+func SignTenantJWTClaims(
+	tenant string,
+	email string,
 ) string {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": email,
-		"nbf":   time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+		"typ":    tokenTypeAccess,
+		"email":  email,
+		"tenant": tenant,
+		"nbf":    time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
 	})
 
 	tokenString, _ := token.SignedString(hmacSecret)
@@ -22,9 +62,346 @@ func SignJWTClaims(
 	return tokenString
 }
 
+// ParseJWTClaims returns the email claim, empty when the token is invalid
 func ParseJWTClaims(
 	tokenString string,
 ) string {
+	_, email := ParseTenantJWTClaims(tokenString)
+
+	return email
+}
+
+// MFAChallengeTTL is how long the second step of a login has after its password was accepted
+const MFAChallengeTTL = 5 * time.Minute
+
+// MFAChallenge is a login whose password was accepted, its second factor is checked once (TOTPRepository.UseMFAChallenge)
+type MFAChallenge struct {
+	Tenant string
+	Email  string
+	// ID is random, it's the jti claim
+	ID        string
+	ExpiresAt time.Time
+}
+
+// SignMFAChallenge signs a token for a login whose password was accepted but still needs its second factor
+// it isn't an authentication token, ParseTenantJWTClaims rejects it
+func SignMFAChallenge(
+	tenant string,
+	email string,
+) string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// without an id the challenge can't be told apart from another, the login has to start over
+		return ""
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":    tokenTypeMFAChallenge,
+		"jti":    base64.RawURLEncoding.EncodeToString(id),
+		"email":  email,
+		"tenant": tenant,
+		"exp":    time.Now().Add(MFAChallengeTTL).Unix(),
+	})
+
+	tokenString, _ := token.SignedString(hmacSecret)
+
+	return tokenString
+}
+
+// ParseMFAChallenge returns the challenge of a token, nil when the token is invalid, expired or isn't a challenge
+func ParseMFAChallenge(
+	tokenString string,
+) *MFAChallenge {
+	claims := parseJWT(tokenString, tokenTypeMFAChallenge)
+	if claims == nil {
+		return nil
+	}
+
+	tenant, email := claimsAccount(claims)
+	if email == "" {
+		return nil
+	}
+
+	id, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if id == "" || exp == 0 {
+		return nil
+	}
+
+	return &MFAChallenge{
+		Tenant:    tenant,
+		Email:     email,
+		ID:        id,
+		ExpiresAt: time.Unix(int64(exp), 0).UTC(),
+	}
+}
+
+// PasskeyCeremonyTTL is how long a browser has to answer the options of a passkey registration or login
+const PasskeyCeremonyTTL = 5 * time.Minute
+
+// PasskeyCeremony is what a WebAuthn ceremony needs to remember between its options and the browser's response
+type PasskeyCeremony struct {
+	Tenant string
+	// Email is the registering account, it's empty for a login since the passkey names its account
+	Email string
+	// Challenge is base64url, as the browser echoes it in its client data
+	Challenge  string
+	UserHandle []byte
+}
+
+// SignPasskeyCeremony signs a ceremony so the server doesn't have to store it
+// it isn't an authentication token, ParseTenantJWTClaims rejects it
+func SignPasskeyCeremony(
+	ceremony PasskeyCeremony,
+) string {
+	claims := jwt.MapClaims{
+		"typ":      tokenTypePasskeyCeremony,
+		"tenant":   ceremony.Tenant,
+		"webauthn": ceremony.Challenge,
+		"exp":      time.Now().Add(PasskeyCeremonyTTL).Unix(),
+	}
+
+	if ceremony.Email != "" {
+		claims["email"] = ceremony.Email
+	}
+
+	if ceremony.UserHandle != nil {
+		claims["userHandle"] = base64.RawURLEncoding.EncodeToString(ceremony.UserHandle)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	tokenString, _ := token.SignedString(hmacSecret)
+
+	return tokenString
+}
+
+// ParsePasskeyCeremony returns the ceremony of a token, nil when the token is invalid, expired or isn't a ceremony
+func ParsePasskeyCeremony(
+	tokenString string,
+) *PasskeyCeremony {
+	claims := parseJWT(tokenString, tokenTypePasskeyCeremony)
+	if claims == nil {
+		return nil
+	}
+
+	challenge, _ := claims["webauthn"].(string)
+	if challenge == "" {
+		return nil
+	}
+
+	tenant, _ := claims["tenant"].(string)
+	if ValidateOrganizationID(tenant) != nil {
+		return nil
+	}
+
+	ceremony := &PasskeyCeremony{
+		Tenant:    tenant,
+		Challenge: challenge,
+	}
+
+	if email, ok := claims["email"]; ok {
+		if ceremony.Email, ok = email.(string); !ok || ceremony.Email == "" {
+			return nil
+		}
+	}
+
+	if userHandle, ok := claims["userHandle"]; ok {
+		s, _ := userHandle.(string)
+
+		var err error
+		if ceremony.UserHandle, err = base64.RawURLEncoding.DecodeString(s); err != nil {
+			return nil
+		}
+	}
+
+	return ceremony
+}
+
+// IDTokenTTL is how long an OpenID Connect id token is valid
+const IDTokenTTL = time.Hour
+
+// IDToken tells an OAuth client which account consented, it's signed with the same key as every other token
+type IDToken struct {
+	Issuer string
+	// Subject is the account, it's stable for the account's organization and email
+	Subject string
+	// Audience is the client's id
+	Audience string
+	// Nonce is the authorization request's, empty when it didn't have one
+	Nonce string
+	// Claims are the ones the scopes asked for, "email" and "name", only the email and profile claims are signed
+	Claims map[string]interface{}
+}
+
+// SignIDToken signs an id token, it expires IDTokenTTL after now
+// it isn't an authentication token, ParseTenantJWTClaims rejects it
+func SignIDToken(
+	idToken IDToken,
+	now time.Time,
+) string {
+	claims := jwt.MapClaims{}
+	for name, value := range idToken.Claims {
+		if idTokenClaims[name] {
+			claims[name] = value
+		}
+	}
+
+	claims["typ"] = tokenTypeIDToken
+	claims["iss"] = idToken.Issuer
+	claims["sub"] = idToken.Subject
+	claims["aud"] = idToken.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(IDTokenTTL).Unix()
+
+	if idToken.Nonce != "" {
+		claims["nonce"] = idToken.Nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	tokenString, _ := token.SignedString(hmacSecret)
+
+	return tokenString
+}
+
+// ParseIDToken returns the id token, nil when it's invalid, expired or for another audience
+func ParseIDToken(
+	tokenString string,
+	audience string,
+) *IDToken {
+	claims := parseJWT(tokenString, tokenTypeIDToken)
+	if claims == nil {
+		return nil
+	}
+
+	if aud, _ := claims["aud"].(string); aud == "" || aud != audience {
+		return nil
+	}
+
+	idToken := &IDToken{
+		Audience: audience,
+		Claims:   map[string]interface{}{},
+	}
+
+	idToken.Issuer, _ = claims["iss"].(string)
+	idToken.Subject, _ = claims["sub"].(string)
+	idToken.Nonce, _ = claims["nonce"].(string)
+
+	if idToken.Issuer == "" || idToken.Subject == "" {
+		return nil
+	}
+
+	for name, value := range claims {
+		if idTokenClaims[name] {
+			idToken.Claims[name] = value
+		}
+	}
+
+	return idToken
+}
+
+// FederatedLoginTTL is how long a browser has to come back from an external identity provider
+const FederatedLoginTTL = 10 * time.Minute
+
+// FederatedLogin is what a login through an external identity provider needs to remember until the provider redirects back
+type FederatedLogin struct {
+	// Provider is the configured provider's name
+	Provider string
+	// State comes back with the provider's redirect, it ties the code to the browser that holds the session
+	State string
+	// Nonce has to be the provider's id token's
+	Nonce string
+	// CodeVerifier is PKCE's, the provider only saw its challenge
+	CodeVerifier string
+}
+
+// SignFederatedLogin signs a login so the server doesn't have to store it
+// it isn't an authentication token, ParseTenantJWTClaims rejects it
+func SignFederatedLogin(
+	login FederatedLogin,
+) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":      tokenTypeFederatedLogin,
+		"oidc":     login.Provider,
+		"state":    login.State,
+		"nonce":    login.Nonce,
+		"verifier": login.CodeVerifier,
+		"exp":      time.Now().Add(FederatedLoginTTL).Unix(),
+	})
+
+	tokenString, _ := token.SignedString(hmacSecret)
+
+	return tokenString
+}
+
+// ParseFederatedLogin returns the login of a token, nil when the token is invalid, expired or isn't a federated login
+func ParseFederatedLogin(
+	tokenString string,
+) *FederatedLogin {
+	claims := parseJWT(tokenString, tokenTypeFederatedLogin)
+	if claims == nil {
+		return nil
+	}
+
+	login := &FederatedLogin{}
+	login.Provider, _ = claims["oidc"].(string)
+	login.State, _ = claims["state"].(string)
+	login.Nonce, _ = claims["nonce"].(string)
+	login.CodeVerifier, _ = claims["verifier"].(string)
+
+	if login.Provider == "" || login.State == "" || login.Nonce == "" || login.CodeVerifier == "" {
+		return nil
+	}
+
+	return login
+}
+
+// ParseTenantJWTClaims returns the tenant and email claims, both are empty when the token is invalid or isn't an access token
+// a token signed before organizations has no tenant claim, it's DefaultOrganization's
+func ParseTenantJWTClaims(
+	tokenString string,
+) (
+	string,
+	string,
+) {
+	claims := parseJWT(tokenString, tokenTypeAccess)
+	if claims == nil {
+		claims = parseLegacyJWT(tokenString)
+	}
+
+	if claims == nil {
+		return "", ""
+	}
+
+	return claimsAccount(claims)
+}
+
+// parseLegacyJWT returns the claims of an access token signed before the typ claim, nil for any other token
+// the other tokens of that time had claims an access token doesn't, a challenge's "mfa" or an id token's "aud"
+func parseLegacyJWT(
+	tokenString string,
+) jwt.MapClaims {
+	claims := parseJWT(tokenString, "")
+	if claims == nil {
+		return nil
+	}
+
+	for name := range claims {
+		if !legacyAccessClaims[name] {
+			return nil
+		}
+	}
+
+	return claims
+}
+
+// parseJWT returns the claims of a valid token of the typ, nil when it's invalid, expired or of another typ
+// an empty typ is a token without one
+func parseJWT(
+	tokenString string,
+	typ string,
+) jwt.MapClaims {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -34,17 +411,45 @@ func ParseJWTClaims(
 	})
 	if err != nil {
 		// invalid token
-		return ""
+		return nil
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if _, ok := claims["email"]; !ok {
-			// email doesn't exist
-			return ""
-		}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil
+	}
 
-		return claims["email"].(string)
+	if got, _ := claims["typ"].(string); got != typ {
+		return nil
+	}
+
+	if _, ok := claims["typ"]; typ == "" && ok {
+		return nil
+	}
+
+	return claims
+}
+
+// claimsAccount returns the tenant and email claims, both are empty when either is invalid
+func claimsAccount(
+	claims jwt.MapClaims,
+) (
+	string,
+	string,
+) {
+	email, ok := claims["email"].(string)
+	if !ok || email == "" {
+		// email doesn't exist
+		return "", ""
+	}
+
+	tenant := DefaultOrganization
+	if _, ok := claims["tenant"]; ok {
+		tenant, ok = claims["tenant"].(string)
+		if !ok || ValidateOrganizationID(tenant) != nil {
+			return "", ""
+		}
 	}
 
-	return ""
+	return tenant, email
 }
@@ -2,9 +2,20 @@ package ddd
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
 )
 
+// signClaims signs claims as they are, like the tokens signed before the typ claim
+func signClaims(claims jwt.MapClaims) string {
+	tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(hmacSecret)
+
+	return tokenString
+}
+
 func TestJWT(t *testing.T) {
 	tokenString := SignJWTClaims("jackson@juandefu.ca")
 	if tokenString == "" {
@@ -24,3 +35,206 @@ func TestJWT(t *testing.T) {
 		t.Errorf("unknown token claim email: %s", email)
 	}
 }
+
+func TestTenantJWT(t *testing.T) {
+	tenant, email := ParseTenantJWTClaims(SignTenantJWTClaims("acme", "jackson@juandefu.ca"))
+	if tenant != "acme" || email != "jackson@juandefu.ca" {
+		t.Errorf("unknown token claims: %s %s", tenant, email)
+	}
+
+	// the default organization's tokens are the ones signed without a tenant
+	if tenant, _ := ParseTenantJWTClaims(SignJWTClaims("jackson@juandefu.ca")); tenant != DefaultOrganization {
+		t.Errorf("unknown token tenant: %s", tenant)
+	}
+
+	if tenant, email := ParseTenantJWTClaims(SignTenantJWTClaims("Not A Tenant", "jackson@juandefu.ca")); tenant != "" || email != "" {
+		t.Errorf("an invalid tenant was accepted: %s %s", tenant, email)
+	}
+}
+
+func TestLegacyJWT(t *testing.T) {
+	nbf := time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix()
+
+	// tokens signed before the typ claim keep authenticating
+	if tenant, email := ParseTenantJWTClaims(signClaims(jwt.MapClaims{"email": "jackson@juandefu.ca", "nbf": nbf})); tenant != DefaultOrganization || email != "jackson@juandefu.ca" {
+		t.Errorf("unknown legacy token claims: %s %s", tenant, email)
+	}
+
+	if tenant, email := ParseTenantJWTClaims(signClaims(jwt.MapClaims{"email": "jackson@juandefu.ca", "tenant": "acme", "nbf": nbf})); tenant != "acme" || email != "jackson@juandefu.ca" {
+		t.Errorf("unknown legacy token claims: %s %s", tenant, email)
+	}
+
+	// the other tokens of that time don't
+	for _, claims := range []jwt.MapClaims{
+		{"email": "jackson@juandefu.ca", "tenant": "acme", "mfa": true, "exp": time.Now().Add(MFAChallengeTTL).Unix()},
+		{"email": "jackson@juandefu.ca", "tenant": "acme", "webauthn": "Y2hhbGxlbmdl", "exp": time.Now().Add(PasskeyCeremonyTTL).Unix()},
+		{"email": "jackson@juandefu.ca", "iss": "http://localhost:8080", "sub": "default/jackson@juandefu.ca", "aud": "client"},
+		{"email": "jackson@juandefu.ca", "typ": "refresh"},
+		{"email": "jackson@juandefu.ca", "typ": true},
+	} {
+		if tenant, email := ParseTenantJWTClaims(signClaims(claims)); tenant != "" || email != "" {
+			t.Errorf("%v was accepted as a token: %s %s", claims, tenant, email)
+		}
+	}
+
+	// and a legacy token isn't any other type
+	legacy := signClaims(jwt.MapClaims{"email": "jackson@juandefu.ca", "tenant": "acme", "mfa": true, "exp": time.Now().Add(MFAChallengeTTL).Unix()})
+	if parsed := ParseMFAChallenge(legacy); parsed != nil {
+		t.Errorf("a legacy challenge was accepted: %+v", parsed)
+	}
+}
+
+func TestMFAChallenge(t *testing.T) {
+	challenge := SignMFAChallenge("acme", "jackson@juandefu.ca")
+
+	parsed := ParseMFAChallenge(challenge)
+	if parsed == nil || parsed.Tenant != "acme" || parsed.Email != "jackson@juandefu.ca" || parsed.ID == "" ||
+		parsed.ExpiresAt.Before(time.Now()) || parsed.ExpiresAt.After(time.Now().Add(MFAChallengeTTL)) {
+		t.Errorf("unknown challenge: %+v", parsed)
+	}
+
+	// every challenge has its own id
+	if other := ParseMFAChallenge(SignMFAChallenge("acme", "jackson@juandefu.ca")); other == nil || other.ID == parsed.ID {
+		t.Errorf("challenges share an id: %+v", other)
+	}
+
+	// a challenge doesn't authenticate
+	if tenant, email := ParseTenantJWTClaims(challenge); tenant != "" || email != "" {
+		t.Errorf("a challenge was accepted as a token: %s %s", tenant, email)
+	}
+
+	// and a token isn't a challenge
+	if parsed := ParseMFAChallenge(SignTenantJWTClaims("acme", "jackson@juandefu.ca")); parsed != nil {
+		t.Errorf("a token was accepted as a challenge: %+v", parsed)
+	}
+}
+
+func TestPasskeyCeremony(t *testing.T) {
+	ceremony := PasskeyCeremony{
+		Tenant:     "acme",
+		Email:      "jackson@juandefu.ca",
+		Challenge:  "Y2hhbGxlbmdl",
+		UserHandle: []byte{1, 2, 3},
+	}
+
+	token := SignPasskeyCeremony(ceremony)
+
+	if parsed := ParsePasskeyCeremony(token); !reflect.DeepEqual(parsed, &ceremony) {
+		t.Errorf("unknown ceremony: %+v", parsed)
+	}
+
+	// a ceremony doesn't authenticate
+	if tenant, email := ParseTenantJWTClaims(token); tenant != "" || email != "" {
+		t.Errorf("a ceremony was accepted as a token: %s %s", tenant, email)
+	}
+
+	if parsed := ParseMFAChallenge(token); parsed != nil {
+		t.Errorf("a ceremony was accepted as a challenge: %+v", parsed)
+	}
+
+	// and neither a token nor a challenge is a ceremony
+	if parsed := ParsePasskeyCeremony(SignTenantJWTClaims("acme", "jackson@juandefu.ca")); parsed != nil {
+		t.Errorf("a token was accepted as a ceremony: %+v", parsed)
+	}
+
+	if parsed := ParsePasskeyCeremony(SignMFAChallenge("acme", "jackson@juandefu.ca")); parsed != nil {
+		t.Errorf("a challenge was accepted as a ceremony: %+v", parsed)
+	}
+
+	// a login's ceremony has neither an email nor a user handle
+	login := PasskeyCeremony{
+		Tenant:    "acme",
+		Challenge: "Y2hhbGxlbmdl",
+	}
+
+	if parsed := ParsePasskeyCeremony(SignPasskeyCeremony(login)); !reflect.DeepEqual(parsed, &login) {
+		t.Errorf("unknown ceremony: %+v", parsed)
+	}
+}
+
+func TestFederatedLogin(t *testing.T) {
+	login := FederatedLogin{
+		Provider:     "acme-sso",
+		State:        "c3RhdGU",
+		Nonce:        "bm9uY2U",
+		CodeVerifier: "dmVyaWZpZXI",
+	}
+
+	token := SignFederatedLogin(login)
+
+	if parsed := ParseFederatedLogin(token); !reflect.DeepEqual(parsed, &login) {
+		t.Errorf("unknown login: %+v", parsed)
+	}
+
+	// a login doesn't authenticate
+	if tenant, email := ParseTenantJWTClaims(token); tenant != "" || email != "" {
+		t.Errorf("a login was accepted as a token: %s %s", tenant, email)
+	}
+
+	if parsed := ParsePasskeyCeremony(token); parsed != nil {
+		t.Errorf("a login was accepted as a ceremony: %+v", parsed)
+	}
+
+	// and neither a token nor a ceremony is a login
+	if parsed := ParseFederatedLogin(SignTenantJWTClaims("acme", "jackson@juandefu.ca")); parsed != nil {
+		t.Errorf("a token was accepted as a login: %+v", parsed)
+	}
+
+	if parsed := ParseFederatedLogin(SignPasskeyCeremony(PasskeyCeremony{Tenant: "acme", Challenge: "Y2hhbGxlbmdl"})); parsed != nil {
+		t.Errorf("a ceremony was accepted as a login: %+v", parsed)
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	idToken := IDToken{
+		Issuer:   "http://localhost:8080",
+		Subject:  "default/jackson@juandefu.ca",
+		Audience: "client",
+		Nonce:    "nonce",
+		Claims: map[string]interface{}{
+			"email": "jackson@juandefu.ca",
+		},
+	}
+
+	token := SignIDToken(idToken, time.Now())
+
+	if parsed := ParseIDToken(token, "client"); !reflect.DeepEqual(parsed, &idToken) {
+		t.Errorf("unknown id token: %+v", parsed)
+	}
+
+	if parsed := ParseIDToken(token, "other"); parsed != nil {
+		t.Errorf("another client's id token was accepted: %+v", parsed)
+	}
+
+	// an id token with an email claim doesn't authenticate
+	if tenant, email := ParseTenantJWTClaims(token); tenant != "" || email != "" {
+		t.Errorf("an id token was accepted as a token: %s %s", tenant, email)
+	}
+
+	if parsed := ParseIDToken(SignIDToken(idToken, time.Now().Add(-2*IDTokenTTL)), "client"); parsed != nil {
+		t.Errorf("an expired id token was accepted: %+v", parsed)
+	}
+
+	if parsed := ParseIDToken(SignJWTClaims("jackson@juandefu.ca"), "client"); parsed != nil {
+		t.Errorf("a token was accepted as an id token: %+v", parsed)
+	}
+
+	// the claims can't make an id token another type of token
+	idToken.Claims = map[string]interface{}{
+		"email":  "jackson@juandefu.ca",
+		"typ":    tokenTypeAccess,
+		"tenant": "acme",
+		"aud":    "other",
+	}
+
+	token = SignIDToken(idToken, time.Now())
+
+	parsed := ParseIDToken(token, "client")
+	if parsed == nil || !reflect.DeepEqual(parsed.Claims, map[string]interface{}{"email": "jackson@juandefu.ca"}) {
+		t.Errorf("unknown id token: %+v", parsed)
+	}
+
+	if tenant, email := ParseTenantJWTClaims(token); tenant != "" || email != "" {
+		t.Errorf("an id token was accepted as a token: %s %s", tenant, email)
+	}
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// DefaultRedactKeys are always redacted unless RedactKeys is set
+var DefaultRedactKeys = []string{
+	"password",
+	"token",
+	"jwt",
+	"authorization",
+	"x-authentication-token",
+}
+
+type EmailMode string
+
+const (
+	// EmailModeMask keeps the first letter and the domain, `j***@juandefu.ca`
+	EmailModeMask EmailMode = "mask"
+	// EmailModeRedact replaces the whole address
+	EmailModeRedact EmailMode = "redact"
+	// EmailModePlain logs addresses as is
+	EmailModePlain EmailMode = "plain"
+)
+
+type LoggerOpts struct {
+	// Output defaults to stderr
+	Output io.Writer
+	// Level is one of debug, info, warn or error, defaults to info
+	Level string
+	// RedactKeys are attribute keys (case insensitive) whose values are replaced, defaults to DefaultRedactKeys
+	RedactKeys []string
+	// EmailMode applies to every attribute key containing "email", defaults to mask
+	EmailMode EmailMode
+}
+
+// NewLogger builds a leveled JSON logger that redacts secrets and emails by attribute key
+func NewLogger(
+	opts LoggerOpts,
+) (
+	*slog.Logger,
+	error,
+) {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	level := slog.LevelInfo
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return nil, fmt.Errorf("unknown log level: %s", opts.Level)
+		}
+	}
+
+	if opts.RedactKeys == nil {
+		opts.RedactKeys = DefaultRedactKeys
+	}
+
+	switch opts.EmailMode {
+	case "":
+		opts.EmailMode = EmailModeMask
+	case EmailModeMask, EmailModeRedact, EmailModePlain:
+	default:
+		return nil, fmt.Errorf("unknown email mode: %s", opts.EmailMode)
+	}
+
+	r := redactor{
+		keys:      make(map[string]bool),
+		emailMode: opts.EmailMode,
+	}
+	for _, key := range opts.RedactKeys {
+		r.keys[strings.ToLower(key)] = true
+	}
+
+	return slog.New(
+		slog.NewJSONHandler(opts.Output, &slog.HandlerOptions{
+			Level:       level,
+			ReplaceAttr: r.replaceAttr,
+		}),
+	), nil
+}
+
+// Discard is a logger that drops every record, this is handy in tests
+func Discard() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{
+		Level: slog.Level(127),
+	}))
+}
+
+type redactor struct {
+	keys      map[string]bool
+	emailMode EmailMode
+}
+
+func (r redactor) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+
+	if r.keys[key] {
+		return slog.String(a.Key, redacted)
+	}
+
+	if strings.Contains(key, "email") && a.Value.Kind() == slog.KindString {
+		switch r.emailMode {
+		case EmailModeRedact:
+			return slog.String(a.Key, redacted)
+		case EmailModeMask:
+			return slog.String(a.Key, MaskEmail(a.Value.String()))
+		}
+	}
+
+	return a
+}
+
+// MaskEmail keeps the first letter of the local part and the domain, `jackson@juandefu.ca` is `j***@juandefu.ca`
+func MaskEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return redacted
+	}
+
+	return email[:1] + "***" + email[at:]
+}
+
+type loggerKey struct{}
+
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the request scoped logger, or slog.Default()
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
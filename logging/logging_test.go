@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestNewLogger_Redaction(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	logger, err := NewLogger(
+		LoggerOpts{
+			Output: buf,
+		},
+	)
+	if err != nil {
+		t.Errorf("failed to build logger: %s", err)
+	}
+
+	logger.Info("login",
+		"email", "jackson@juandefu.ca",
+		"password", "pass",
+		"Token", "jwt-token",
+		"route", "/login",
+	)
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Errorf("failed to decode log record: %s", err)
+	}
+
+	if record["email"] != "j***@juandefu.ca" {
+		t.Errorf("email wasn't masked: %v", record["email"])
+	}
+
+	if record["password"] != "[REDACTED]" {
+		t.Errorf("password wasn't redacted: %v", record["password"])
+	}
+
+	if record["Token"] != "[REDACTED]" {
+		t.Errorf("token wasn't redacted: %v", record["Token"])
+	}
+
+	if record["route"] != "/login" {
+		t.Errorf("route was redacted: %v", record["route"])
+	}
+}
+
+func TestNewLogger_EmailModes(t *testing.T) {
+	for mode, expected := range map[EmailMode]string{
+		EmailModeMask:   "j***@juandefu.ca",
+		EmailModeRedact: "[REDACTED]",
+		EmailModePlain:  "jackson@juandefu.ca",
+	} {
+		buf := &bytes.Buffer{}
+
+		logger, err := NewLogger(
+			LoggerOpts{
+				Output:    buf,
+				EmailMode: mode,
+			},
+		)
+		if err != nil {
+			t.Errorf("failed to build logger: %s", err)
+		}
+
+		logger.Info("request", "user_email", "jackson@juandefu.ca")
+
+		record := map[string]interface{}{}
+		json.Unmarshal(buf.Bytes(), &record)
+
+		if record["user_email"] != expected {
+			t.Errorf("%s: unknown email: %v", mode, record["user_email"])
+		}
+	}
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	logger, err := NewLogger(
+		LoggerOpts{
+			Output: buf,
+			Level:  "warn",
+		},
+	)
+	if err != nil {
+		t.Errorf("failed to build logger: %s", err)
+	}
+
+	logger.Info("dropped")
+
+	if buf.Len() != 0 {
+		t.Errorf("info was logged at warn: `%s`", buf.String())
+	}
+
+	if _, err := NewLogger(LoggerOpts{Level: "loud"}); err == nil {
+		t.Errorf("built a logger with an unknown level?")
+	}
+
+	if _, err := NewLogger(LoggerOpts{EmailMode: "hash"}); err == nil {
+		t.Errorf("built a logger with an unknown email mode?")
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	for email, expected := range map[string]string{
+		"":                    "",
+		"jackson@juandefu.ca": "j***@juandefu.ca",
+		"@juandefu.ca":        "[REDACTED]",
+		"jackson":             "[REDACTED]",
+	} {
+		if masked := MaskEmail(email); masked != expected {
+			t.Errorf("%s: unknown mask: %s", email, masked)
+		}
+	}
+}
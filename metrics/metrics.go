@@ -0,0 +1,349 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds, matching the prometheus client
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+// Registry holds every metric family and writes them in the prometheus text format (version 0.0.4)
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+	names      []string
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+func (reg *Registry) register(name string, c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, ok := reg.collectors[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+
+	reg.collectors[name] = c
+	reg.names = append(reg.names, name)
+	sort.Strings(reg.names)
+}
+
+func (reg *Registry) NewCounterVec(
+	name string,
+	help string,
+	labels ...string,
+) *CounterVec {
+	cv := &CounterVec{
+		family: newFamily(name, help, labels),
+	}
+
+	reg.register(name, cv)
+
+	return cv
+}
+
+func (reg *Registry) NewHistogramVec(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+
+	bs := append([]float64{}, buckets...)
+	sort.Float64s(bs)
+
+	hv := &HistogramVec{
+		family:  newFamily(name, help, labels),
+		buckets: bs,
+	}
+
+	reg.register(name, hv)
+
+	return hv
+}
+
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	names := append([]string{}, reg.names...)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, reg.collectors[name])
+	}
+	reg.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, c := range collectors {
+		c.write(bw)
+	}
+
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	reg.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type family struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func newFamily(name, help string, labels []string) family {
+	return family{
+		name:   name,
+		help:   help,
+		labels: labels,
+	}
+}
+
+func (f family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expected %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+func (f family) writeHeader(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, kind)
+}
+
+// labelPairs formats `{a="1",b="2"}`, extra is appended last (used for the histogram `le` label)
+func (f family) labelPairs(values []string, extra ...string) string {
+	if len(f.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(f.labels)+1)
+	for i, label := range f.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type CounterVec struct {
+	family
+	series sync.Map // [key]*Counter
+}
+
+func (cv *CounterVec) With(values ...string) *Counter {
+	key := cv.key(values)
+
+	if c, ok := cv.series.Load(key); ok {
+		return c.(*Counter)
+	}
+
+	c, _ := cv.series.LoadOrStore(key, &Counter{
+		values: append([]string{}, values...),
+	})
+
+	return c.(*Counter)
+}
+
+func (cv *CounterVec) write(w *bufio.Writer) {
+	cv.writeHeader(w, "counter")
+
+	for _, c := range sortedCounters(&cv.series) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.name, cv.labelPairs(c.values), formatFloat(c.Value()))
+	}
+}
+
+type Counter struct {
+	mu     sync.Mutex
+	values []string
+	value  float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can only increase")
+	}
+
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.value
+}
+
+type HistogramVec struct {
+	family
+	buckets []float64
+	series  sync.Map // [key]*Histogram
+}
+
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	key := hv.key(values)
+
+	if h, ok := hv.series.Load(key); ok {
+		return h.(*Histogram)
+	}
+
+	h, _ := hv.series.LoadOrStore(key, &Histogram{
+		values:  append([]string{}, values...),
+		buckets: hv.buckets,
+		counts:  make([]uint64, len(hv.buckets)),
+	})
+
+	return h.(*Histogram)
+}
+
+func (hv *HistogramVec) write(w *bufio.Writer) {
+	hv.writeHeader(w, "histogram")
+
+	for _, h := range sortedHistograms(&hv.series) {
+		counts, count, sum := h.snapshot()
+
+		var cumulative uint64
+		for i, upper := range hv.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, hv.labelPairs(h.values, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, hv.labelPairs(h.values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, hv.labelPairs(h.values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, hv.labelPairs(h.values), count)
+	}
+}
+
+type Histogram struct {
+	mu      sync.Mutex
+	values  []string
+	buckets []float64
+	// counts are per bucket, not cumulative, the last bucket (+Inf) is derived from count
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]uint64{}, h.counts...), h.count, h.sum
+}
+
+func sortedCounters(m *sync.Map) []*Counter {
+	cs := []*Counter{}
+	m.Range(func(_, v interface{}) bool {
+		cs = append(cs, v.(*Counter))
+		return true
+	})
+	sort.Slice(cs, func(i, j int) bool {
+		return lessValues(cs[i].values, cs[j].values)
+	})
+
+	return cs
+}
+
+func sortedHistograms(m *sync.Map) []*Histogram {
+	hs := []*Histogram{}
+	m.Range(func(_, v interface{}) bool {
+		hs = append(hs, v.(*Histogram))
+		return true
+	})
+	sort.Slice(hs, func(i, j int) bool {
+		return lessValues(hs[i].values, hs[j].values)
+	})
+
+	return hs
+}
+
+func lessValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+
+	return false
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
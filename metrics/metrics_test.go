@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	reg := NewRegistry()
+
+	cv := reg.NewCounterVec("test_total", "A test counter.", "result")
+	cv.With("success").Inc()
+	cv.With("success").Inc()
+	cv.With("failure").Add(3)
+
+	buf := &bytes.Buffer{}
+	if _, err := reg.WriteTo(buf); err != nil {
+		t.Errorf("failed to write registry: %s", err)
+	}
+
+	expected := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{result="failure"} 3
+test_total{result="success"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("unknown output: `%s`", buf.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	reg := NewRegistry()
+
+	hv := reg.NewHistogramVec("test_seconds", "A test histogram.", []float64{1, 0.1}, "route")
+	hv.With("/a").Observe(0.05)
+	hv.With("/a").Observe(0.5)
+	hv.With("/a").Observe(5)
+
+	buf := &bytes.Buffer{}
+	if _, err := reg.WriteTo(buf); err != nil {
+		t.Errorf("failed to write registry: %s", err)
+	}
+
+	expected := `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/a",le="0.1"} 1
+test_seconds_bucket{route="/a",le="1"} 2
+test_seconds_bucket{route="/a",le="+Inf"} 3
+test_seconds_sum{route="/a"} 5.55
+test_seconds_count{route="/a"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unknown output: `%s`", buf.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	reg := NewRegistry()
+
+	reg.NewCounterVec("test_total", "Escaped \\ help\nline.", "path").With("a\"b\\c\nd").Inc()
+
+	buf := &bytes.Buffer{}
+	reg.WriteTo(buf)
+
+	if !strings.Contains(buf.String(), `# HELP test_total Escaped \\ help\nline.`) {
+		t.Errorf("help not escaped: `%s`", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `test_total{path="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped: `%s`", buf.String())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("test_total", "A test counter.")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registered a duplicate metric?")
+		}
+	}()
+
+	reg.NewCounterVec("test_total", "A test counter.")
+}
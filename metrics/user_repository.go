@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// NewUserRepository wraps a ddd.UserRepository and records call counts and latency per method
+func NewUserRepository(
+	userRepo ddd.UserRepository,
+	reg *Registry,
+) *UserRepository {
+	return &UserRepository{
+		userRepo: userRepo,
+		calls: reg.NewCounterVec(
+			"ddd_repository_calls_total",
+			"Total number of UserRepository calls by method and result.",
+			"method", "result",
+		),
+		duration: reg.NewHistogramVec(
+			"ddd_repository_call_duration_seconds",
+			"UserRepository call latency in seconds by method.",
+			DefBuckets,
+			"method",
+		),
+	}
+}
+
+type UserRepository struct {
+	userRepo ddd.UserRepository
+	calls    *CounterVec
+	duration *HistogramVec
+}
+
+func (ur *UserRepository) observe(method string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	ur.calls.With(method, result).Inc()
+	ur.duration.With(method).Observe(time.Since(start).Seconds())
+}
+
+func (ur *UserRepository) Create(
+	ctx context.Context,
+	opts ddd.UserCreate,
+) (
+	*ddd.User,
+	error,
+) {
+	start := time.Now()
+	user, err := ur.userRepo.Create(ctx, opts)
+	ur.observe("Create", start, err)
+
+	return user, err
+}
+
+func (ur *UserRepository) Login(
+	ctx context.Context,
+	opts ddd.UserLogin,
+) (
+	*ddd.User,
+	error,
+) {
+	start := time.Now()
+	user, err := ur.userRepo.Login(ctx, opts)
+	ur.observe("Login", start, err)
+
+	return user, err
+}
+
+func (ur *UserRepository) Get(
+	ctx context.Context,
+	email string,
+) (
+	*ddd.User,
+	error,
+) {
+	start := time.Now()
+	user, err := ur.userRepo.Get(ctx, email)
+	ur.observe("Get", start, err)
+
+	return user, err
+}
+
+func (ur *UserRepository) List(
+	ctx context.Context,
+	opts ddd.UserList,
+) (
+	[]*ddd.User,
+	error,
+) {
+	start := time.Now()
+	users, err := ur.userRepo.List(ctx, opts)
+	ur.observe("List", start, err)
+
+	return users, err
+}
+
+func (ur *UserRepository) Update(
+	ctx context.Context,
+	opts ddd.UserUpdate,
+) (
+	*ddd.User,
+	error,
+) {
+	start := time.Now()
+	user, err := ur.userRepo.Update(ctx, opts)
+	ur.observe("Update", start, err)
+
+	return user, err
+}
+
+func (ur *UserRepository) Delete(
+	ctx context.Context,
+	email string,
+) error {
+	start := time.Now()
+	err := ur.userRepo.Delete(ctx, email)
+	ur.observe("Delete", start, err)
+
+	return err
+}
+
+func (ur *UserRepository) Import(
+	ctx context.Context,
+	opts ddd.UserImport,
+) (
+	[]ddd.ImportResult,
+	error,
+) {
+	start := time.Now()
+	results, err := ur.userRepo.Import(ctx, opts)
+	ur.observe("Import", start, err)
+
+	return results, err
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/conformance"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestUserRepository_Conformance(t *testing.T) {
+	conformance.RunUserRepository(t, func(t *testing.T) ddd.UserRepository {
+		return NewUserRepository(mock.NewUserRepository(), NewRegistry())
+	})
+}
+
+func TestUserRepository(t *testing.T) {
+	reg := NewRegistry()
+
+	userRepo := NewUserRepository(mock.NewUserRepository(), reg)
+
+	_, err := userRepo.Create(context.Background(), ddd.UserCreate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	if err != nil {
+		t.Errorf("failed to create user: %s", err)
+	}
+
+	_, err = userRepo.Login(context.Background(), ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: "invalid",
+	})
+	if err == nil {
+		t.Errorf("logged in with an invalid password?")
+	}
+
+	buf := &bytes.Buffer{}
+	reg.WriteTo(buf)
+
+	if !strings.Contains(buf.String(), `ddd_repository_calls_total{method="Create",result="success"} 1`) {
+		t.Errorf("create wasn't recorded: `%s`", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `ddd_repository_calls_total{method="Login",result="error"} 1`) {
+		t.Errorf("login wasn't recorded: `%s`", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `ddd_repository_call_duration_seconds_count{method="Create"} 1`) {
+		t.Errorf("create latency wasn't recorded: `%s`", buf.String())
+	}
+}
@@ -0,0 +1,201 @@
+package mock
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// storedAPIKey keeps the key's hash and the insertion order, keys created in the same instant are still listed in order
+type storedAPIKey struct {
+	ddd.APIKey
+	keyHash string
+	seq     int
+}
+
+// copyAPIKey doesn't share the scopes or times with the stored key
+func copyAPIKey(stored *storedAPIKey) *ddd.APIKey {
+	key := stored.APIKey
+	key.Scopes = append([]string{}, key.Scopes...)
+
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		key.ExpiresAt = &expiresAt
+	}
+
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		key.LastUsedAt = &lastUsedAt
+	}
+
+	return &key
+}
+
+func (ur *UserRepository) CreateAPIKey(
+	ctx context.Context,
+	opts ddd.APIKeyCreate,
+) (
+	key *ddd.APIKey,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateAPIKey, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateAPIKey); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	key, err = opts.NewAPIKey(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.accounts[accountKey{opts.Organization, opts.Email}]; !ok {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	ur.apiKeySeq++
+	stored := &storedAPIKey{
+		APIKey:  *key,
+		keyHash: ddd.HashToken(key.Key),
+		seq:     ur.apiKeySeq,
+	}
+	stored.Key = ""
+	ur.apiKeys[key.ID] = stored
+
+	created := copyAPIKey(stored)
+	created.Key = key.Key
+
+	return created, nil
+}
+
+func (ur *UserRepository) GetAPIKey(
+	ctx context.Context,
+	key string,
+) (
+	got *ddd.APIKey,
+	err error,
+) {
+	defer func() { ur.record(MethodGetAPIKey, nil, err) }()
+
+	if err := ur.begin(ctx, MethodGetAPIKey); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.apiKeys[ddd.APIKeyID(key)]
+	if !ok || stored.keyHash != ddd.HashToken(key) || (stored.ExpiresAt != nil && !stored.ExpiresAt.After(time.Now())) {
+		return nil, ddd.ErrAPIKeyNotFound
+	}
+
+	return copyAPIKey(stored), nil
+}
+
+func (ur *UserRepository) ListAPIKeys(
+	ctx context.Context,
+	email string,
+) (
+	keys []*ddd.APIKey,
+	err error,
+) {
+	defer func() { ur.record(MethodListAPIKeys, email, err) }()
+
+	if err := ur.begin(ctx, MethodListAPIKeys); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	stored := []*storedAPIKey{}
+	for _, key := range ur.apiKeys {
+		if key.Organization == tenant && key.Email == email {
+			stored = append(stored, key)
+		}
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].seq < stored[j].seq
+	})
+
+	keys = []*ddd.APIKey{}
+	for _, key := range stored {
+		keys = append(keys, copyAPIKey(key))
+	}
+
+	return keys, nil
+}
+
+func (ur *UserRepository) DeleteAPIKey(
+	ctx context.Context,
+	email string,
+	id string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodDeleteAPIKey, id, err) }()
+
+	if err := ur.begin(ctx, MethodDeleteAPIKey); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.apiKeys[id]
+	if !ok || stored.Organization != ddd.TenantFrom(ctx) || stored.Email != email {
+		return ddd.ErrAPIKeyNotFound
+	}
+
+	delete(ur.apiKeys, id)
+
+	return nil
+}
+
+func (ur *UserRepository) TouchAPIKey(
+	ctx context.Context,
+	id string,
+	ip string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodTouchAPIKey, id, err) }()
+
+	if err := ur.begin(ctx, MethodTouchAPIKey); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.apiKeys[id]
+	if !ok {
+		return ddd.ErrAPIKeyNotFound
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	stored.LastUsedAt = &now
+	stored.LastUsedIP = ip
+
+	return nil
+}
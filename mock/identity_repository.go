@@ -0,0 +1,139 @@
+package mock
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+// storedIdentity keeps the insertion order, identities linked in the same instant are still listed in order
+type storedIdentity struct {
+	ddd.Identity
+	seq int
+}
+
+func (ur *UserRepository) CreateIdentity(
+	ctx context.Context,
+	opts ddd.IdentityCreate,
+) (
+	identity *ddd.Identity,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateIdentity, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateIdentity); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.accounts[accountKey{opts.Organization, opts.Email}]; !ok {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	key := identityKey{opts.Provider, opts.Subject}
+
+	if _, ok := ur.identities[key]; ok {
+		return nil, ddd.ErrIdentityExists
+	}
+
+	ur.identitySeq++
+	stored := &storedIdentity{
+		Identity: ddd.Identity{
+			Provider:     opts.Provider,
+			Subject:      opts.Subject,
+			Organization: opts.Organization,
+			Email:        opts.Email,
+			CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+		},
+		seq: ur.identitySeq,
+	}
+	ur.identities[key] = stored
+
+	identity = &ddd.Identity{}
+	*identity = stored.Identity
+
+	return identity, nil
+}
+
+func (ur *UserRepository) GetIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (
+	identity *ddd.Identity,
+	err error,
+) {
+	defer func() { ur.record(MethodGetIdentity, provider, err) }()
+
+	if err := ur.begin(ctx, MethodGetIdentity); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.identities[identityKey{provider, subject}]
+	if !ok {
+		return nil, ddd.ErrIdentityNotFound
+	}
+
+	identity = &ddd.Identity{}
+	*identity = stored.Identity
+
+	return identity, nil
+}
+
+func (ur *UserRepository) ListIdentities(
+	ctx context.Context,
+	email string,
+) (
+	identities []*ddd.Identity,
+	err error,
+) {
+	defer func() { ur.record(MethodListIdentities, email, err) }()
+
+	if err := ur.begin(ctx, MethodListIdentities); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	stored := []*storedIdentity{}
+	for _, identity := range ur.identities {
+		if identity.Organization == tenant && identity.Email == email {
+			stored = append(stored, identity)
+		}
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].seq < stored[j].seq
+	})
+
+	identities = []*ddd.Identity{}
+	for _, identity := range stored {
+		copied := identity.Identity
+		identities = append(identities, &copied)
+	}
+
+	return identities, nil
+}
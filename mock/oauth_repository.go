@@ -0,0 +1,327 @@
+package mock
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// storedOAuthClient keeps the insertion order, clients created in the same instant are still listed in order
+type storedOAuthClient struct {
+	ddd.OAuthClient
+	seq int
+}
+
+// copyOAuthClient doesn't share the slices, callers can't change what's stored
+func copyOAuthClient(client ddd.OAuthClient) *ddd.OAuthClient {
+	client.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	client.Scopes = append([]string{}, client.Scopes...)
+
+	return &client
+}
+
+func (ur *UserRepository) CreateOAuthClient(
+	ctx context.Context,
+	opts ddd.OAuthClientCreate,
+) (
+	client *ddd.OAuthClient,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateOAuthClient, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateOAuthClient); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err = opts.NewOAuthClient(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.oauthClients[client.ID]; ok {
+		return nil, ddd.ErrOAuthClientExists
+	}
+
+	stored := *copyOAuthClient(*client)
+	stored.Secret = ""
+
+	ur.oauthClientSeq++
+	ur.oauthClients[client.ID] = &storedOAuthClient{
+		OAuthClient: stored,
+		seq:         ur.oauthClientSeq,
+	}
+
+	return client, nil
+}
+
+func (ur *UserRepository) GetOAuthClient(
+	ctx context.Context,
+	id string,
+) (
+	client *ddd.OAuthClient,
+	err error,
+) {
+	defer func() { ur.record(MethodGetOAuthClient, id, err) }()
+
+	if err := ur.begin(ctx, MethodGetOAuthClient); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.oauthClients[id]
+	if !ok {
+		return nil, ddd.ErrOAuthClientNotFound
+	}
+
+	return copyOAuthClient(stored.OAuthClient), nil
+}
+
+func (ur *UserRepository) ListOAuthClients(
+	ctx context.Context,
+) (
+	clients []*ddd.OAuthClient,
+	err error,
+) {
+	defer func() { ur.record(MethodListOAuthClients, nil, err) }()
+
+	if err := ur.begin(ctx, MethodListOAuthClients); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored := make([]*storedOAuthClient, 0, len(ur.oauthClients))
+	for _, client := range ur.oauthClients {
+		stored = append(stored, client)
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].seq < stored[j].seq
+	})
+
+	clients = make([]*ddd.OAuthClient, 0, len(stored))
+	for _, client := range stored {
+		clients = append(clients, copyOAuthClient(client.OAuthClient))
+	}
+
+	return clients, nil
+}
+
+func (ur *UserRepository) DeleteOAuthClient(
+	ctx context.Context,
+	id string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodDeleteOAuthClient, id, err) }()
+
+	if err := ur.begin(ctx, MethodDeleteOAuthClient); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.oauthClients[id]; !ok {
+		return ddd.ErrOAuthClientNotFound
+	}
+
+	delete(ur.oauthClients, id)
+	for hash, code := range ur.oauthCodes {
+		if code.ClientID == id {
+			delete(ur.oauthCodes, hash)
+		}
+	}
+	for hash, token := range ur.oauthTokens {
+		if token.ClientID == id {
+			delete(ur.oauthTokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (ur *UserRepository) CreateOAuthCode(
+	ctx context.Context,
+	opts ddd.OAuthCodeCreate,
+) (
+	code *ddd.OAuthCode,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateOAuthCode, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateOAuthCode); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	code, err = opts.NewOAuthCode(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.oauthClients[opts.ClientID]; !ok {
+		return nil, ddd.ErrOAuthClientNotFound
+	}
+
+	if _, ok := ur.accounts[accountKey{opts.Organization, opts.Email}]; !ok {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	stored := *code
+	stored.Scopes = append([]string(nil), code.Scopes...)
+	stored.Code = ""
+	ur.oauthCodes[ddd.HashToken(code.Code)] = stored
+
+	return code, nil
+}
+
+func (ur *UserRepository) ConsumeOAuthCode(
+	ctx context.Context,
+	code string,
+) (
+	consumed *ddd.OAuthCode,
+	err error,
+) {
+	defer func() { ur.record(MethodConsumeOAuthCode, nil, err) }()
+
+	if err := ur.begin(ctx, MethodConsumeOAuthCode); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	hash := ddd.HashToken(code)
+
+	stored, ok := ur.oauthCodes[hash]
+	if !ok {
+		return nil, ddd.ErrOAuthCodeNotFound
+	}
+
+	// an expired code is deleted too, it can't be exchanged anymore
+	delete(ur.oauthCodes, hash)
+
+	if !stored.ExpiresAt.After(time.Now()) {
+		return nil, ddd.ErrOAuthCodeNotFound
+	}
+
+	stored.Scopes = append([]string(nil), stored.Scopes...)
+
+	return &stored, nil
+}
+
+func (ur *UserRepository) CreateOAuthToken(
+	ctx context.Context,
+	opts ddd.OAuthTokenCreate,
+) (
+	token *ddd.OAuthToken,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateOAuthToken, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateOAuthToken); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	token, err = opts.NewOAuthToken(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.oauthClients[opts.ClientID]; !ok {
+		return nil, ddd.ErrOAuthClientNotFound
+	}
+
+	if opts.Email != "" {
+		if _, ok := ur.accounts[accountKey{opts.Organization, opts.Email}]; !ok {
+			return nil, ddd.ErrUserNotFound
+		}
+	}
+
+	stored := *token
+	stored.Scopes = append([]string(nil), token.Scopes...)
+	stored.Token = ""
+	ur.oauthTokens[ddd.HashToken(token.Token)] = stored
+
+	return token, nil
+}
+
+func (ur *UserRepository) GetOAuthToken(
+	ctx context.Context,
+	token string,
+) (
+	got *ddd.OAuthToken,
+	err error,
+) {
+	defer func() { ur.record(MethodGetOAuthToken, nil, err) }()
+
+	if err := ur.begin(ctx, MethodGetOAuthToken); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.oauthTokens[ddd.HashToken(token)]
+	if !ok || !stored.ExpiresAt.After(time.Now()) {
+		return nil, ddd.ErrOAuthTokenNotFound
+	}
+
+	stored.Scopes = append([]string(nil), stored.Scopes...)
+
+	return &stored, nil
+}
+
+func (ur *UserRepository) RevokeOAuthToken(
+	ctx context.Context,
+	clientID string,
+	token string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodRevokeOAuthToken, clientID, err) }()
+
+	if err := ur.begin(ctx, MethodRevokeOAuthToken); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	hash := ddd.HashToken(token)
+
+	stored, ok := ur.oauthTokens[hash]
+	if !ok || stored.ClientID != clientID {
+		return ddd.ErrOAuthTokenNotFound
+	}
+
+	delete(ur.oauthTokens, hash)
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package mock
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+func (ur *UserRepository) CreateOrganization(
+	ctx context.Context,
+	opts ddd.OrganizationCreate,
+) (
+	organization *ddd.Organization,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateOrganization, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateOrganization); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.organizations[opts.ID]; ok {
+		return nil, ddd.ErrOrganizationExists
+	}
+
+	o := ddd.Organization{
+		ID:        opts.ID,
+		Name:      opts.Name,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	ur.organizations[opts.ID] = o
+
+	return &o, nil
+}
+
+func (ur *UserRepository) GetOrganization(
+	ctx context.Context,
+	id string,
+) (
+	organization *ddd.Organization,
+	err error,
+) {
+	defer func() { ur.record(MethodGetOrganization, id, err) }()
+
+	if err := ur.begin(ctx, MethodGetOrganization); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateOrganizationID(id); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	o, ok := ur.organizations[id]
+	if !ok {
+		return nil, ddd.ErrOrganizationNotFound
+	}
+
+	return &o, nil
+}
+
+func (ur *UserRepository) ListOrganizations(
+	ctx context.Context,
+) (
+	organizations []*ddd.Organization,
+	err error,
+) {
+	defer func() { ur.record(MethodListOrganizations, nil, err) }()
+
+	if err := ur.begin(ctx, MethodListOrganizations); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	organizations = make([]*ddd.Organization, 0, len(ur.organizations))
+	for _, o := range ur.organizations {
+		o := o
+		organizations = append(organizations, &o)
+	}
+
+	// ORDER BY id ASC
+	sort.Slice(organizations, func(i, j int) bool {
+		return organizations[i].ID < organizations[j].ID
+	})
+
+	return organizations, nil
+}
+
+func (ur *UserRepository) CreateInvitation(
+	ctx context.Context,
+	opts ddd.InvitationCreate,
+) (
+	invitation *ddd.Invitation,
+	err error,
+) {
+	defer func() { ur.record(MethodCreateInvitation, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreateInvitation); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	invitation, err = opts.NewInvitation(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	if _, ok := ur.organizations[opts.Organization]; !ok {
+		return nil, ddd.ErrOrganizationNotFound
+	}
+
+	stored := *invitation
+	stored.Token = ""
+	ur.invitations[ddd.HashToken(invitation.Token)] = stored
+
+	return invitation, nil
+}
+
+func (ur *UserRepository) AcceptInvitation(
+	ctx context.Context,
+	token string,
+	opts ddd.UserCreate,
+) (
+	user *ddd.User,
+	err error,
+) {
+	defer func() { ur.record(MethodAcceptInvitation, opts, err) }()
+
+	if err := ur.begin(ctx, MethodAcceptInvitation); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	hash := ddd.HashToken(token)
+
+	invitation, ok := ur.invitations[hash]
+	if !ok || !invitation.ExpiresAt.After(time.Now()) || invitation.Email != opts.Email {
+		return nil, ddd.ErrInvitationNotFound
+	}
+
+	opts.Admin = invitation.Admin
+
+	user, err = ur.create(accountKey{invitation.Organization, opts.Email}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(ur.invitations, hash)
+
+	return user, nil
+}
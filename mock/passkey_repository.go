@@ -0,0 +1,255 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+type passkeyKey struct {
+	tenant string
+	id     string
+}
+
+// storedPasskey keeps the insertion order, passkeys created in the same instant are still listed in order
+type storedPasskey struct {
+	ddd.Passkey
+	seq int
+}
+
+// copyPasskey doesn't share the slices, callers can't change what's stored
+func copyPasskey(passkey ddd.Passkey) *ddd.Passkey {
+	passkey.ID = append([]byte(nil), passkey.ID...)
+	passkey.UserHandle = append([]byte(nil), passkey.UserHandle...)
+	passkey.PublicKey = append([]byte(nil), passkey.PublicKey...)
+	passkey.AAGUID = append([]byte(nil), passkey.AAGUID...)
+	passkey.Transports = append([]string(nil), passkey.Transports...)
+	if passkey.LastUsedAt != nil {
+		lastUsedAt := *passkey.LastUsedAt
+		passkey.LastUsedAt = &lastUsedAt
+	}
+
+	return &passkey
+}
+
+func (ur *UserRepository) CreatePasskey(
+	ctx context.Context,
+	opts ddd.PasskeyCreate,
+) (
+	p *ddd.Passkey,
+	err error,
+) {
+	defer func() { ur.record(MethodCreatePasskey, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreatePasskey); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	if _, ok := ur.accounts[accountKey{tenant, opts.Email}]; !ok {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	key := passkeyKey{tenant, string(opts.ID)}
+
+	if _, ok := ur.passkeys[key]; ok {
+		return nil, ddd.ErrPasskeyExists
+	}
+
+	ur.passkeySeq++
+	stored := &storedPasskey{
+		Passkey: *copyPasskey(ddd.Passkey{
+			ID:              opts.ID,
+			Email:           opts.Email,
+			UserHandle:      opts.UserHandle,
+			Name:            opts.Name,
+			PublicKey:       opts.PublicKey,
+			AttestationType: opts.AttestationType,
+			AAGUID:          opts.AAGUID,
+			SignCount:       opts.SignCount,
+			Transports:      opts.Transports,
+			BackupEligible:  opts.BackupEligible,
+			CreatedAt:       time.Now().UTC(),
+		}),
+		seq: ur.passkeySeq,
+	}
+	ur.passkeys[key] = stored
+
+	return copyPasskey(stored.Passkey), nil
+}
+
+func (ur *UserRepository) GetPasskey(
+	ctx context.Context,
+	id []byte,
+) (
+	p *ddd.Passkey,
+	err error,
+) {
+	defer func() { ur.record(MethodGetPasskey, id, err) }()
+
+	if err := ur.begin(ctx, MethodGetPasskey); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.passkeys[passkeyKey{ddd.TenantFrom(ctx), string(id)}]
+	if !ok {
+		return nil, ddd.ErrPasskeyNotFound
+	}
+
+	return copyPasskey(stored.Passkey), nil
+}
+
+func (ur *UserRepository) ListPasskeys(
+	ctx context.Context,
+	email string,
+) (
+	passkeys []*ddd.Passkey,
+	err error,
+) {
+	defer func() { ur.record(MethodListPasskeys, email, err) }()
+
+	if err := ur.begin(ctx, MethodListPasskeys); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	stored := []*storedPasskey{}
+	for key, passkey := range ur.passkeys {
+		if key.tenant == tenant && passkey.Email == email {
+			stored = append(stored, passkey)
+		}
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].seq < stored[j].seq
+	})
+
+	passkeys = []*ddd.Passkey{}
+	for _, passkey := range stored {
+		passkeys = append(passkeys, copyPasskey(passkey.Passkey))
+	}
+
+	return passkeys, nil
+}
+
+func (ur *UserRepository) UsePasskey(
+	ctx context.Context,
+	id []byte,
+	signCount uint32,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodUsePasskey, id, err) }()
+
+	if err := ur.begin(ctx, MethodUsePasskey); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	stored, ok := ur.passkeys[passkeyKey{ddd.TenantFrom(ctx), string(id)}]
+	if !ok {
+		return ddd.ErrPasskeyNotFound
+	}
+
+	if signCount <= stored.SignCount && (signCount != 0 || stored.SignCount != 0) {
+		return ddd.ErrPasskeyCloned
+	}
+
+	now := time.Now().UTC()
+	stored.SignCount = signCount
+	stored.LastUsedAt = &now
+
+	return nil
+}
+
+// UsePasskeyChallenge forgets expired challenges as it records one
+func (ur *UserRepository) UsePasskeyChallenge(
+	ctx context.Context,
+	challenge string,
+	expiresAt time.Time,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodUsePasskeyChallenge, challenge, err) }()
+
+	if err := ur.begin(ctx, MethodUsePasskeyChallenge); err != nil {
+		return err
+	}
+
+	if challenge == "" {
+		return errors.New("challenge was empty")
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	now := time.Now()
+	for used, usedExpiresAt := range ur.passkeyChallenges {
+		if !usedExpiresAt.After(now) {
+			delete(ur.passkeyChallenges, used)
+		}
+	}
+
+	if _, ok := ur.passkeyChallenges[challenge]; ok {
+		return ddd.ErrPasskeyChallengeUsed
+	}
+
+	ur.passkeyChallenges[challenge] = expiresAt
+
+	return nil
+}
+
+func (ur *UserRepository) DeletePasskey(
+	ctx context.Context,
+	email string,
+	id []byte,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodDeletePasskey, id, err) }()
+
+	if err := ur.begin(ctx, MethodDeletePasskey); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	key := passkeyKey{ddd.TenantFrom(ctx), string(id)}
+
+	if stored, ok := ur.passkeys[key]; !ok || stored.Email != email {
+		return ddd.ErrPasskeyNotFound
+	}
+
+	delete(ur.passkeys, key)
+
+	return nil
+}
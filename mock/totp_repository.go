@@ -0,0 +1,329 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+type totpEnrollment struct {
+	secret      []byte
+	enabled     bool
+	lastStep    int64
+	failures    int
+	lockedUntil time.Time
+	// [HashToken(Code)]
+	recoveryCodes map[string]bool
+}
+
+func (ur *UserRepository) GetTOTP(
+	ctx context.Context,
+	email string,
+) (
+	t *ddd.TOTP,
+	err error,
+) {
+	defer func() { ur.record(MethodGetTOTP, email, err) }()
+
+	if err := ur.begin(ctx, MethodGetTOTP); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	enrollment, ok := ur.totps[accountKey{ddd.TenantFrom(ctx), email}]
+	if !ok {
+		return nil, ddd.ErrTOTPNotFound
+	}
+
+	return &ddd.TOTP{
+		Email:         email,
+		Secret:        append([]byte(nil), enrollment.secret...),
+		Enabled:       enrollment.enabled,
+		LastStep:      enrollment.lastStep,
+		RecoveryCodes: len(enrollment.recoveryCodes),
+		Failures:      enrollment.failures,
+		LockedUntil:   enrollment.lockedUntil,
+	}, nil
+}
+
+func (ur *UserRepository) EnrollTOTP(
+	ctx context.Context,
+	email string,
+	secret []byte,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodEnrollTOTP, email, err) }()
+
+	if err := ur.begin(ctx, MethodEnrollTOTP); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	key := accountKey{ddd.TenantFrom(ctx), email}
+
+	if _, ok := ur.accounts[key]; !ok {
+		return ddd.ErrUserNotFound
+	}
+
+	if enrollment, ok := ur.totps[key]; ok && enrollment.enabled {
+		return ddd.ErrTOTPEnabled
+	}
+
+	ur.totps[key] = &totpEnrollment{
+		secret:        append([]byte(nil), secret...),
+		recoveryCodes: map[string]bool{},
+	}
+
+	return nil
+}
+
+func (ur *UserRepository) EnableTOTP(
+	ctx context.Context,
+	opts ddd.TOTPEnable,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodEnableTOTP, opts, err) }()
+
+	if err := ur.begin(ctx, MethodEnableTOTP); err != nil {
+		return err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	enrollment, ok := ur.totps[accountKey{ddd.TenantFrom(ctx), opts.Email}]
+	if !ok {
+		return ddd.ErrTOTPNotFound
+	}
+
+	if enrollment.enabled {
+		return ddd.ErrTOTPEnabled
+	}
+
+	enrollment.enabled = true
+	enrollment.lastStep = opts.Step
+	enrollment.recoveryCodes = map[string]bool{}
+	for _, hash := range opts.RecoveryCodes {
+		enrollment.recoveryCodes[hash] = true
+	}
+
+	return nil
+}
+
+func (ur *UserRepository) UseTOTPStep(
+	ctx context.Context,
+	email string,
+	step int64,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodUseTOTPStep, email, err) }()
+
+	if err := ur.begin(ctx, MethodUseTOTPStep); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	enrollment, ok := ur.totps[accountKey{ddd.TenantFrom(ctx), email}]
+	if !ok || !enrollment.enabled {
+		return ddd.ErrTOTPNotFound
+	}
+
+	if step <= enrollment.lastStep {
+		return ddd.ErrInvalidTOTPCode
+	}
+
+	enrollment.lastStep = step
+	enrollment.failures = 0
+
+	return nil
+}
+
+func (ur *UserRepository) UseRecoveryCode(
+	ctx context.Context,
+	email string,
+	hash string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodUseRecoveryCode, email, err) }()
+
+	if err := ur.begin(ctx, MethodUseRecoveryCode); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	enrollment, ok := ur.totps[accountKey{ddd.TenantFrom(ctx), email}]
+	if !ok || !enrollment.enabled {
+		return ddd.ErrTOTPNotFound
+	}
+
+	if !enrollment.recoveryCodes[hash] {
+		return ddd.ErrInvalidTOTPCode
+	}
+
+	delete(enrollment.recoveryCodes, hash)
+	enrollment.failures = 0
+
+	return nil
+}
+
+func (ur *UserRepository) FailTOTP(
+	ctx context.Context,
+	email string,
+) (
+	failures int,
+	err error,
+) {
+	defer func() { ur.record(MethodFailTOTP, email, err) }()
+
+	if err := ur.begin(ctx, MethodFailTOTP); err != nil {
+		return 0, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return 0, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	enrollment, ok := ur.totps[accountKey{ddd.TenantFrom(ctx), email}]
+	if !ok || !enrollment.enabled {
+		return 0, ddd.ErrTOTPNotFound
+	}
+
+	enrollment.failures++
+
+	return enrollment.failures, nil
+}
+
+func (ur *UserRepository) LockTOTP(
+	ctx context.Context,
+	email string,
+	until time.Time,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodLockTOTP, email, err) }()
+
+	if err := ur.begin(ctx, MethodLockTOTP); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	enrollment, ok := ur.totps[accountKey{ddd.TenantFrom(ctx), email}]
+	if !ok || !enrollment.enabled {
+		return ddd.ErrTOTPNotFound
+	}
+
+	enrollment.failures = 0
+	enrollment.lockedUntil = until.UTC().Truncate(time.Microsecond)
+
+	return nil
+}
+
+// UseMFAChallenge forgets expired challenges as it records one
+func (ur *UserRepository) UseMFAChallenge(
+	ctx context.Context,
+	id string,
+	expiresAt time.Time,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodUseMFAChallenge, id, err) }()
+
+	if err := ur.begin(ctx, MethodUseMFAChallenge); err != nil {
+		return err
+	}
+
+	if id == "" {
+		return errors.New("id was empty")
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	now := time.Now()
+	for used, usedExpiresAt := range ur.mfaChallenges {
+		if !usedExpiresAt.After(now) {
+			delete(ur.mfaChallenges, used)
+		}
+	}
+
+	if _, ok := ur.mfaChallenges[id]; ok {
+		return ddd.ErrMFAChallengeUsed
+	}
+
+	ur.mfaChallenges[id] = expiresAt
+
+	return nil
+}
+
+func (ur *UserRepository) DeleteTOTP(
+	ctx context.Context,
+	email string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodDeleteTOTP, email, err) }()
+
+	if err := ur.begin(ctx, MethodDeleteTOTP); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	key := accountKey{ddd.TenantFrom(ctx), email}
+
+	if _, ok := ur.totps[key]; !ok {
+		return ddd.ErrTOTPNotFound
+	}
+
+	delete(ur.totps, key)
+
+	return nil
+}
@@ -1,114 +1,683 @@
 package mock
 
 import (
-	"errors"
+	"context"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/sabey/ddd"
 )
 
+// the method names faults are injected into, and calls are recorded under
+const (
+	MethodCreate = "Create"
+	MethodLogin  = "Login"
+	MethodGet    = "Get"
+	MethodList   = "List"
+	MethodUpdate = "Update"
+	MethodDelete = "Delete"
+	MethodImport = "Import"
+
+	MethodCreateOrganization = "CreateOrganization"
+	MethodGetOrganization    = "GetOrganization"
+	MethodListOrganizations  = "ListOrganizations"
+	MethodCreateInvitation   = "CreateInvitation"
+	MethodAcceptInvitation   = "AcceptInvitation"
+
+	MethodGetTOTP         = "GetTOTP"
+	MethodEnrollTOTP      = "EnrollTOTP"
+	MethodEnableTOTP      = "EnableTOTP"
+	MethodUseTOTPStep     = "UseTOTPStep"
+	MethodUseRecoveryCode = "UseRecoveryCode"
+	MethodFailTOTP        = "FailTOTP"
+	MethodLockTOTP        = "LockTOTP"
+	MethodUseMFAChallenge = "UseMFAChallenge"
+	MethodDeleteTOTP      = "DeleteTOTP"
+
+	MethodCreatePasskey       = "CreatePasskey"
+	MethodGetPasskey          = "GetPasskey"
+	MethodListPasskeys        = "ListPasskeys"
+	MethodUsePasskey          = "UsePasskey"
+	MethodUsePasskeyChallenge = "UsePasskeyChallenge"
+	MethodDeletePasskey       = "DeletePasskey"
+
+	MethodCreateOAuthClient = "CreateOAuthClient"
+	MethodGetOAuthClient    = "GetOAuthClient"
+	MethodListOAuthClients  = "ListOAuthClients"
+	MethodDeleteOAuthClient = "DeleteOAuthClient"
+	MethodCreateOAuthCode   = "CreateOAuthCode"
+	MethodConsumeOAuthCode  = "ConsumeOAuthCode"
+	MethodCreateOAuthToken  = "CreateOAuthToken"
+	MethodGetOAuthToken     = "GetOAuthToken"
+	MethodRevokeOAuthToken  = "RevokeOAuthToken"
+
+	MethodCreateIdentity = "CreateIdentity"
+	MethodGetIdentity    = "GetIdentity"
+	MethodListIdentities = "ListIdentities"
+
+	MethodCreateAPIKey = "CreateAPIKey"
+	MethodGetAPIKey    = "GetAPIKey"
+	MethodListAPIKeys  = "ListAPIKeys"
+	MethodDeleteAPIKey = "DeleteAPIKey"
+	MethodTouchAPIKey  = "TouchAPIKey"
+)
+
 func NewUserRepository() *UserRepository {
 	return &UserRepository{
-		Accounts: make(map[string]ddd.User),
+		accounts: make(map[accountKey]ddd.User),
+		organizations: map[string]ddd.Organization{
+			ddd.DefaultOrganization: {
+				ID:        ddd.DefaultOrganization,
+				Name:      "Default",
+				CreatedAt: time.Now().UTC(),
+			},
+		},
+		invitations:       make(map[string]ddd.Invitation),
+		totps:             make(map[accountKey]*totpEnrollment),
+		mfaChallenges:     make(map[string]time.Time),
+		passkeys:          make(map[passkeyKey]*storedPasskey),
+		passkeyChallenges: make(map[string]time.Time),
+		oauthClients:      make(map[string]*storedOAuthClient),
+		oauthCodes:        make(map[string]ddd.OAuthCode),
+		oauthTokens:       make(map[string]ddd.OAuthToken),
+		identities:        make(map[identityKey]*storedIdentity),
+		apiKeys:           make(map[string]*storedAPIKey),
+		faults:            make(map[string]*Fault),
 	}
 }
 
+// UserRepository is an in-memory ddd.UserRepository, ddd.OrganizationRepository, ddd.TOTPRepository, ddd.PasskeyRepository, ddd.OAuthRepository, ddd.IdentityRepository and ddd.APIKeyRepository
+// it's safe for concurrent use
 type UserRepository struct {
-	// [Email]User
-	Accounts map[string]ddd.User
+	mu sync.Mutex
+	// [Tenant, Email]User
+	accounts map[accountKey]ddd.User
+	// [ID]Organization
+	organizations map[string]ddd.Organization
+	// [HashToken(Token)]Invitation
+	invitations map[string]ddd.Invitation
+	// [Tenant, Email]Enrollment
+	totps map[accountKey]*totpEnrollment
+	// [ID]ExpiresAt
+	mfaChallenges map[string]time.Time
+	// [Tenant, ID]Passkey
+	passkeys   map[passkeyKey]*storedPasskey
+	passkeySeq int
+	// [Challenge]ExpiresAt
+	passkeyChallenges map[string]time.Time
+	// [ID]Client
+	oauthClients   map[string]*storedOAuthClient
+	oauthClientSeq int
+	// [HashToken(Code)]Code
+	oauthCodes map[string]ddd.OAuthCode
+	// [HashToken(Token)]Token
+	oauthTokens map[string]ddd.OAuthToken
+	// [Provider, Subject]Identity
+	identities  map[identityKey]*storedIdentity
+	identitySeq int
+	// [ID]Key
+	apiKeys   map[string]*storedAPIKey
+	apiKeySeq int
+	// [Method]Fault
+	faults map[string]*Fault
+	calls  []Call
+}
+
+// Fault is injected into calls of a method, the latency is applied first and honors the context
+type Fault struct {
+	Err     error
+	Latency time.Duration
+	// Times limits the fault to the next n calls, zero is every call until ClearFaults
+	Times int
+}
+
+// accountKey is an account's tenant and email, the same email can be an account in several organizations
+type accountKey struct {
+	tenant string
+	email  string
+}
+
+// Call is a recorded call, Opts is the method's options (ddd.UserCreate etc.), the email for Get and Delete, or nil for List
+type Call struct {
+	Method string
+	Opts   interface{}
+	Err    error
+}
+
+// Seed stores users as is, the password should already be hashed
+// a zero version is stored as 1, the version of a created account, and an empty organization is ddd.DefaultOrganization
+func (ur *UserRepository) Seed(users ...ddd.User) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	for _, user := range users {
+		if user.Version == 0 {
+			user.Version = 1
+		}
+
+		if user.Organization == "" {
+			user.Organization = ddd.DefaultOrganization
+		}
+
+		if user.Attributes == nil {
+			user.Attributes = map[string]interface{}{}
+		}
+
+		ur.accounts[accountKey{user.Organization, user.Email}] = user.Clone()
+	}
+}
+
+// SeedOrganizations stores organizations as they are
+func (ur *UserRepository) SeedOrganizations(organizations ...ddd.Organization) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	for _, organization := range organizations {
+		ur.organizations[organization.ID] = organization
+	}
+}
+
+// User returns a stored account of ddd.DefaultOrganization for assertions, it isn't recorded as a call
+func (ur *UserRepository) User(email string) (ddd.User, bool) {
+	return ur.TenantUser(ddd.DefaultOrganization, email)
+}
+
+// TenantUser returns a stored account of the tenant's organization for assertions, it isn't recorded as a call
+func (ur *UserRepository) TenantUser(tenant string, email string) (ddd.User, bool) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	user, ok := ur.accounts[accountKey{tenant, email}]
+
+	return user.Clone(), ok
+}
+
+// Accounts returns a copy of ddd.DefaultOrganization's accounts by email, it replaces the Accounts field
+// writes to the copy aren't stored
+//
+// Deprecated: use Seed to store accounts and User or TenantUser to read them.
+func (ur *UserRepository) Accounts() map[string]ddd.User {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	accounts := map[string]ddd.User{}
+	for key, user := range ur.accounts {
+		if key.tenant == ddd.DefaultOrganization {
+			accounts[key.email] = user.Clone()
+		}
+	}
+
+	return accounts
+}
+
+func (ur *UserRepository) Inject(method string, fault Fault) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.faults[method] = &fault
+}
+
+func (ur *UserRepository) ClearFaults() {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.faults = make(map[string]*Fault)
+}
+
+// Calls returns every recorded call in order, filtered to methods when any are given
+func (ur *UserRepository) Calls(methods ...string) []Call {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	calls := []Call{}
+	for _, call := range ur.calls {
+		if len(methods) == 0 || contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
 }
 
-func (ur UserRepository) Create(
+func (ur *UserRepository) ClearCalls() {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.calls = nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fault returns the method's injected fault and consumes one of its times
+func (ur *UserRepository) fault(method string) Fault {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	fault, ok := ur.faults[method]
+	if !ok {
+		return Fault{}
+	}
+
+	if fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			delete(ur.faults, method)
+		}
+	}
+
+	return *fault
+}
+
+// begin applies the method's fault, a non nil error ends the call
+func (ur *UserRepository) begin(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fault := ur.fault(method)
+
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return fault.Err
+}
+
+func (ur *UserRepository) record(method string, opts interface{}, err error) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	ur.calls = append(ur.calls, Call{
+		Method: method,
+		Opts:   opts,
+		Err:    err,
+	})
+}
+
+func (ur *UserRepository) Create(
+	ctx context.Context,
 	opts ddd.UserCreate,
 ) (
-	*ddd.User,
-	error,
+	user *ddd.User,
+	err error,
 ) {
+	defer func() { ur.record(MethodCreate, opts, err) }()
+
+	if err := ur.begin(ctx, MethodCreate); err != nil {
+		return nil, err
+	}
+
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
 
-	if _, ok := ur.Accounts[opts.Email]; ok {
-		return nil, errors.New("user account already exists")
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	return ur.create(accountKey{ddd.TenantFrom(ctx), opts.Email}, opts)
+}
+
+// create stores a new account, ur.mu is held
+func (ur *UserRepository) create(key accountKey, opts ddd.UserCreate) (*ddd.User, error) {
+	if _, ok := ur.accounts[key]; ok {
+		return nil, ddd.ErrUserExists
 	}
 
 	// account created
-	user := ddd.User{
-		Email:     opts.Email,
-		FirstName: opts.FirstName,
-		LastName:  opts.LastName,
-		Password:  opts.Password,
+	u := ddd.User{
+		Organization: key.tenant,
+		Email:        opts.Email,
+		FirstName:    opts.FirstName,
+		LastName:     opts.LastName,
+		Password:     opts.Password,
+		DisplayName:  opts.DisplayName,
+		Locale:       opts.Locale,
+		Timezone:     opts.Timezone,
+		Attributes:   ddd.MergeAttributes(nil, opts.Attributes),
+		Admin:        opts.Admin,
+		Version:      1,
 	}
 
-	ur.Accounts[opts.Email] = user
+	ur.accounts[key] = u
+
+	u = u.Clone()
 
-	return &user, nil
+	return &u, nil
 }
 
-func (ur UserRepository) Login(
+func (ur *UserRepository) Login(
+	ctx context.Context,
 	opts ddd.UserLogin,
 ) (
-	*ddd.User,
-	error,
+	user *ddd.User,
+	err error,
 ) {
+	defer func() { ur.record(MethodLogin, opts, err) }()
+
+	if err := ur.begin(ctx, MethodLogin); err != nil {
+		return nil, err
+	}
+
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
 
-	user, ok := ur.Accounts[opts.Email]
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	u, ok := ur.accounts[accountKey{ddd.TenantFrom(ctx), opts.Email}]
 
 	if !ok {
-		return nil, errors.New("user account doesn't exist")
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if u.Password != opts.Password {
+		return nil, ddd.ErrInvalidPassword
+	}
+
+	if u.Disabled {
+		return nil, ddd.ErrUserDisabled
+	}
+
+	u = u.Clone()
+
+	return &u, nil
+}
+
+func (ur *UserRepository) Get(
+	ctx context.Context,
+	email string,
+) (
+	user *ddd.User,
+	err error,
+) {
+	defer func() { ur.record(MethodGet, email, err) }()
+
+	if err := ur.begin(ctx, MethodGet); err != nil {
+		return nil, err
 	}
 
-	if user.Password != opts.Password {
-		return nil, errors.New("password is invalid")
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	u, ok := ur.accounts[accountKey{ddd.TenantFrom(ctx), email}]
+
+	if !ok {
+		return nil, ddd.ErrUserNotFound
 	}
 
-	return &user, nil
+	u = u.Clone()
+
+	return &u, nil
 }
 
-func (ur UserRepository) List() (
-	[]*ddd.User,
-	error,
+func (ur *UserRepository) List(
+	ctx context.Context,
+	opts ddd.UserList,
+) (
+	users []*ddd.User,
+	err error,
 ) {
-	keys := make([]string, 0, len(ur.Accounts))
-	for k := range ur.Accounts {
-		keys = append(keys, k)
+	defer func() { ur.record(MethodList, opts, err) }()
+
+	if err := ur.begin(ctx, MethodList); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	// WHERE organization = tenant
+	emails := make([]string, 0, len(ur.accounts))
+	for k := range ur.accounts {
+		if k.tenant == tenant {
+			emails = append(emails, k.email)
+		}
 	}
 	// ORDER BY email ASC
-	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	sort.Strings(emails)
 
-	users := []*ddd.User{}
-	for _, key := range keys {
-		user := ur.Accounts[key]
-		users = append(users, &user)
+	users = []*ddd.User{}
+	for _, email := range emails {
+		// WHERE email > after LIMIT limit
+		if email <= opts.After {
+			continue
+		}
+
+		if opts.Limit > 0 && len(users) == opts.Limit {
+			break
+		}
+
+		u := ur.accounts[accountKey{tenant, email}].Clone()
+		if !opts.Matches(&u) {
+			continue
+		}
+
+		users = append(users, &u)
 	}
 
 	return users, nil
 }
 
-func (ur UserRepository) Update(
+func (ur *UserRepository) Update(
+	ctx context.Context,
 	opts ddd.UserUpdate,
 ) (
-	*ddd.User,
-	error,
+	user *ddd.User,
+	err error,
 ) {
+	defer func() { ur.record(MethodUpdate, opts, err) }()
+
+	if err := ur.begin(ctx, MethodUpdate); err != nil {
+		return nil, err
+	}
+
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
 
-	user, ok := ur.Accounts[opts.Email]
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	key := accountKey{ddd.TenantFrom(ctx), opts.Email}
+
+	u, ok := ur.accounts[key]
 
 	if !ok {
-		return nil, errors.New("user account doesn't exist")
+		return nil, ddd.ErrUserNotFound
 	}
 
-	user.FirstName = opts.FirstName
-	user.LastName = opts.LastName
+	if opts.Version > 0 && u.Version != opts.Version {
+		return nil, ddd.ErrVersionMismatch
+	}
+
+	if opts.FirstName != nil {
+		u.FirstName = *opts.FirstName
+	}
+
+	if opts.LastName != nil {
+		u.LastName = *opts.LastName
+	}
+
+	if opts.DisplayName != nil {
+		u.DisplayName = *opts.DisplayName
+	}
+
+	if opts.Locale != nil {
+		u.Locale = *opts.Locale
+	}
+
+	if opts.Timezone != nil {
+		u.Timezone = *opts.Timezone
+	}
+
+	if opts.Avatar != nil {
+		u.Avatar = *opts.Avatar
+	}
+
+	if opts.Password != nil {
+		u.Password = *opts.Password
+	}
+
+	if opts.Disabled != nil {
+		u.Disabled = *opts.Disabled
+	}
+
+	u.Attributes = ddd.MergeAttributes(u.Attributes, opts.Attributes)
+	u.Version++
 
 	// update account
-	ur.Accounts[opts.Email] = user
+	ur.accounts[key] = u
+
+	u = u.Clone()
+
+	return &u, nil
+}
+
+func (ur *UserRepository) Delete(
+	ctx context.Context,
+	email string,
+) (
+	err error,
+) {
+	defer func() { ur.record(MethodDelete, email, err) }()
+
+	if err := ur.begin(ctx, MethodDelete); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	key := accountKey{ddd.TenantFrom(ctx), email}
+
+	if _, ok := ur.accounts[key]; !ok {
+		return ddd.ErrUserNotFound
+	}
+
+	delete(ur.accounts, key)
+	delete(ur.totps, key)
+	for k, passkey := range ur.passkeys {
+		if k.tenant == key.tenant && passkey.Email == email {
+			delete(ur.passkeys, k)
+		}
+	}
+	for hash, code := range ur.oauthCodes {
+		if code.Organization == key.tenant && code.Email == email {
+			delete(ur.oauthCodes, hash)
+		}
+	}
+	for hash, token := range ur.oauthTokens {
+		if token.Organization == key.tenant && token.Email == email {
+			delete(ur.oauthTokens, hash)
+		}
+	}
+	for k, identity := range ur.identities {
+		if identity.Organization == key.tenant && identity.Email == email {
+			delete(ur.identities, k)
+		}
+	}
+	for id, apiKey := range ur.apiKeys {
+		if apiKey.Organization == key.tenant && apiKey.Email == email {
+			delete(ur.apiKeys, id)
+		}
+	}
+
+	return nil
+}
+
+func (ur *UserRepository) Import(
+	ctx context.Context,
+	opts ddd.UserImport,
+) (
+	results []ddd.ImportResult,
+	err error,
+) {
+	defer func() { ur.record(MethodImport, opts, err) }()
+
+	if err := ur.begin(ctx, MethodImport); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	results = opts.Results()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
+
+	for i, user := range opts.Users {
+		if results[i].Status != "" {
+			continue
+		}
+
+		key := accountKey{tenant, user.Email}
+
+		u, ok := ur.accounts[key]
+		switch {
+		case ok && opts.Mode != ddd.ImportUpsert:
+			results[i] = opts.Conflict(user.Email)
+
+			continue
+		case ok:
+			results[i].Status = ddd.ImportUpdated
+			u.Version++
+		default:
+			results[i].Status = ddd.ImportCreated
+			u = ddd.User{
+				Organization: tenant,
+				Email:        user.Email,
+				Version:      1,
+				Admin:        user.Admin,
+			}
+		}
+
+		u.FirstName = user.FirstName
+		u.LastName = user.LastName
+		u.Password = user.Password
+		u.DisplayName = user.DisplayName
+		u.Locale = user.Locale
+		u.Timezone = user.Timezone
+		u.Attributes = ddd.MergeAttributes(nil, user.Attributes)
+
+		if !opts.DryRun {
+			ur.accounts[key] = u
+		}
+	}
 
-	return &user, nil
+	return results, nil
 }
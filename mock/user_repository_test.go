@@ -0,0 +1,206 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/conformance"
+)
+
+func TestUserRepository_Conformance(t *testing.T) {
+	conformance.RunUserRepository(t, func(t *testing.T) ddd.UserRepository {
+		return NewUserRepository()
+	})
+}
+
+func TestOrganizationRepository_Conformance(t *testing.T) {
+	conformance.RunOrganizationRepository(t, func(t *testing.T) (ddd.OrganizationRepository, ddd.UserRepository) {
+		ur := NewUserRepository()
+
+		return ur, ur
+	})
+}
+
+func TestTOTPRepository_Conformance(t *testing.T) {
+	conformance.RunTOTPRepository(t, func(t *testing.T) (ddd.TOTPRepository, ddd.UserRepository) {
+		ur := NewUserRepository()
+
+		return ur, ur
+	})
+}
+
+func TestPasskeyRepository_Conformance(t *testing.T) {
+	conformance.RunPasskeyRepository(t, func(t *testing.T) (ddd.PasskeyRepository, ddd.UserRepository) {
+		ur := NewUserRepository()
+
+		return ur, ur
+	})
+}
+
+func TestOAuthRepository_Conformance(t *testing.T) {
+	conformance.RunOAuthRepository(t, func(t *testing.T) (ddd.OAuthRepository, ddd.UserRepository) {
+		ur := NewUserRepository()
+
+		return ur, ur
+	})
+}
+
+func TestIdentityRepository_Conformance(t *testing.T) {
+	conformance.RunIdentityRepository(t, func(t *testing.T) (ddd.IdentityRepository, ddd.UserRepository) {
+		ur := NewUserRepository()
+
+		return ur, ur
+	})
+}
+
+func TestAPIKeyRepository_Conformance(t *testing.T) {
+	conformance.RunAPIKeyRepository(t, func(t *testing.T) (ddd.APIKeyRepository, ddd.UserRepository) {
+		ur := NewUserRepository()
+
+		return ur, ur
+	})
+}
+
+func TestUserRepository_List(t *testing.T) {
+	ur := NewUserRepository()
+	ur.Seed(
+		ddd.User{Email: "jackson@sabey.co"},
+		ddd.User{Email: "jackson@juandefu.ca"},
+		ddd.User{Email: "a@sabey.co"},
+	)
+
+	users, err := ur.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Errorf("failed to list: %s", err)
+	}
+
+	// ORDER BY email ASC
+	for i, email := range []string{"a@sabey.co", "jackson@juandefu.ca", "jackson@sabey.co"} {
+		if users[i].Email != email {
+			t.Errorf("%d. unknown email / user sort order: %s", i, users[i].Email)
+		}
+	}
+}
+
+func TestUserRepository_Concurrent(t *testing.T) {
+	ur := NewUserRepository()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			email := fmt.Sprintf("jackson+%d@juandefu.ca", i)
+
+			_, err := ur.Create(context.Background(), ddd.UserCreate{
+				Email:     email,
+				FirstName: "Jackson",
+				LastName:  "Sabey",
+				Password:  "pass",
+			})
+			if err != nil {
+				t.Errorf("failed to create user: %s", err)
+			}
+
+			_, err = ur.Update(context.Background(), ddd.UserUpdate{
+				Email:     email,
+				FirstName: ddd.String("JACKSON"),
+				LastName:  ddd.String("SABEY"),
+			})
+			if err != nil {
+				t.Errorf("failed to update user: %s", err)
+			}
+
+			ur.List(context.Background(), ddd.UserList{})
+		}(i)
+	}
+	wg.Wait()
+
+	users, _ := ur.List(context.Background(), ddd.UserList{})
+	if len(users) != 50 {
+		t.Errorf("invalid amount of users: %d", len(users))
+	}
+
+	if len(ur.Calls(MethodCreate, MethodUpdate)) != 100 {
+		t.Errorf("invalid amount of calls: %d", len(ur.Calls(MethodCreate, MethodUpdate)))
+	}
+}
+
+func TestUserRepository_InjectError(t *testing.T) {
+	ur := NewUserRepository()
+
+	injected := errors.New("connection refused")
+	ur.Inject(MethodLogin, Fault{
+		Err:   injected,
+		Times: 1,
+	})
+
+	login := ddd.UserLogin{
+		Email:    "jackson@juandefu.ca",
+		Password: "pass",
+	}
+
+	if _, err := ur.Login(context.Background(), login); err != injected {
+		t.Errorf("error wasn't injected: %v", err)
+	}
+
+	// the fault was limited to one call
+	if _, err := ur.Login(context.Background(), login); err == nil || err == injected {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	calls := ur.Calls(MethodLogin)
+	if len(calls) != 2 {
+		t.Errorf("invalid amount of calls: %d", len(calls))
+	}
+
+	if calls[0].Err != injected || calls[0].Opts.(ddd.UserLogin).Email != "jackson@juandefu.ca" {
+		t.Errorf("unknown call recorded: %v", calls[0])
+	}
+}
+
+func TestUserRepository_InjectLatency(t *testing.T) {
+	ur := NewUserRepository()
+	ur.Inject(MethodList, Fault{
+		Latency: time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := ur.List(ctx, ddd.UserList{}); err != context.DeadlineExceeded {
+		t.Errorf("latency didn't honor the context: %v", err)
+	}
+
+	ur.ClearFaults()
+
+	if _, err := ur.List(context.Background(), ddd.UserList{}); err != nil {
+		t.Errorf("fault wasn't cleared: %s", err)
+	}
+}
+
+func TestUserRepository_Accounts(t *testing.T) {
+	ur := NewUserRepository()
+	ur.Seed(
+		ddd.User{Email: "jackson@juandefu.ca", FirstName: "Jackson"},
+		ddd.User{Email: "jackson@sabey.co", Organization: "sabey"},
+	)
+
+	accounts := ur.Accounts()
+	if len(accounts) != 1 || accounts["jackson@juandefu.ca"].FirstName != "Jackson" {
+		t.Errorf("unknown accounts: %v", accounts)
+	}
+
+	// the copy isn't stored
+	delete(accounts, "jackson@juandefu.ca")
+
+	if _, ok := ur.User("jackson@juandefu.ca"); !ok {
+		t.Errorf("deleting from the copy deleted the account")
+	}
+}
@@ -0,0 +1,333 @@
+package ddd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// every OAuthRepository returns these, callers can match them with errors.Is
+var (
+	ErrOAuthClientExists   = errors.New("oauth client already exists")
+	ErrOAuthClientNotFound = errors.New("oauth client doesn't exist")
+	// ErrOAuthCodeNotFound is returned for an unknown, expired or already exchanged authorization code
+	ErrOAuthCodeNotFound = errors.New("authorization code doesn't exist or expired")
+	// ErrOAuthTokenNotFound is returned for an unknown, expired or revoked access token, or another client's
+	ErrOAuthTokenNotFound = errors.New("access token doesn't exist or expired")
+)
+
+const (
+	// DefaultOAuthCodeTTL is how long an authorization code can be exchanged when OAuthCodeCreate's TTL is zero
+	DefaultOAuthCodeTTL = time.Minute
+	// DefaultOAuthTokenTTL is how long an access token is accepted when OAuthTokenCreate's TTL is zero
+	DefaultOAuthTokenTTL = time.Hour
+)
+
+// OAuthClient is an application that asks accounts for access, or acts on its own with the client credentials grant
+type OAuthClient struct {
+	ID   string
+	Name string
+	// Public clients can't keep a secret, single page and native apps, they can only use the authorization code grant with PKCE
+	Public bool
+	// RedirectURIs are compared exactly, a client without any can't use the authorization code grant
+	RedirectURIs []string
+	// Scopes are the scopes the client may ask for
+	Scopes    []string
+	CreatedAt time.Time
+	// SecretHash is HashToken(Secret), it's empty for a public client
+	SecretHash string
+	// Secret is only set on the confidential client CreateOAuthClient returns, the repository keeps its hash
+	Secret string
+}
+
+// OAuthCode is an authorization code, an account's consent to a client that the client exchanges for an access token
+type OAuthCode struct {
+	ClientID string
+	// Organization and Email are the account that consented
+	Organization string
+	Email        string
+	RedirectURI  string
+	Scopes       []string
+	// CodeChallenge is the PKCE S256 challenge, the exchange has to send its verifier
+	CodeChallenge string
+	// Nonce is echoed in the id token
+	Nonce     string
+	ExpiresAt time.Time
+	// Code is only set on the code CreateOAuthCode returns, the repository keeps its hash
+	Code string
+}
+
+// OAuthToken is an access token issued to a client
+type OAuthToken struct {
+	ClientID string
+	// Organization and Email are empty for the client credentials grant, the client acts on its own
+	Organization string
+	Email        string
+	Scopes       []string
+	ExpiresAt    time.Time
+	// Token is only set on the token CreateOAuthToken returns, the repository keeps its hash
+	Token string
+}
+
+// every method must give up and return ctx.Err() once the context is cancelled or past its deadline
+// like OrganizationRepository it isn't scoped to the context's tenant, a client is registered for every organization
+// deleting a client deletes its codes and tokens, deleting an account deletes the codes and tokens it consented to
+type OAuthRepository interface {
+	CreateOAuthClient(context.Context, OAuthClientCreate) (*OAuthClient, error)
+	GetOAuthClient(ctx context.Context, id string) (*OAuthClient, error)
+	// ListOAuthClients returns every client ordered by creation
+	ListOAuthClients(context.Context) ([]*OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, id string) error
+	// CreateOAuthCode returns ErrOAuthClientNotFound without the client and ErrUserNotFound without the account
+	CreateOAuthCode(context.Context, OAuthCodeCreate) (*OAuthCode, error)
+	// ConsumeOAuthCode returns the code and deletes it, a code is only exchanged once
+	ConsumeOAuthCode(ctx context.Context, code string) (*OAuthCode, error)
+	// CreateOAuthToken returns ErrOAuthClientNotFound without the client and ErrUserNotFound without the account
+	CreateOAuthToken(context.Context, OAuthTokenCreate) (*OAuthToken, error)
+	GetOAuthToken(ctx context.Context, token string) (*OAuthToken, error)
+	// RevokeOAuthToken deletes one of the client's tokens, it returns ErrOAuthTokenNotFound for another client's
+	RevokeOAuthToken(ctx context.Context, clientID string, token string) error
+}
+
+const maxOAuthClientName = 255
+
+// scopeRegexp is RFC 6749's scope-token
+var scopeRegexp = regexp.MustCompile(`^[\x21\x23-\x5b\x5d-\x7e]{1,64}$`)
+
+// ValidateOAuthScopes checks that every scope is an RFC 6749 scope-token and that there are no duplicates
+func ValidateOAuthScopes(scopes []string) error {
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		if !scopeRegexp.MatchString(scope) {
+			return fmt.Errorf("invalid scope: %q", scope)
+		}
+
+		if seen[scope] {
+			return fmt.Errorf("duplicate scope: %s", scope)
+		}
+		seen[scope] = true
+	}
+
+	return nil
+}
+
+// ValidateRedirectURI checks that uri is absolute without a fragment, https unless it's a loopback address
+func ValidateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect uri isn't an absolute url: %s", uri)
+	}
+
+	if u.Fragment != "" || u.RawFragment != "" || u.User != nil {
+		return fmt.Errorf("redirect uri can't have a fragment or credentials: %s", uri)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+			return nil
+		}
+	}
+
+	return fmt.Errorf("redirect uri must be https unless it's a loopback address: %s", uri)
+}
+
+type OAuthClientCreate struct {
+	Name         string
+	Public       bool
+	RedirectURIs []string
+	Scopes       []string
+}
+
+func (oc OAuthClientCreate) Validate() error {
+	if oc.Name == "" {
+		return errors.New("name was empty")
+	}
+
+	if !utf8.ValidString(oc.Name) || utf8.RuneCountInString(oc.Name) > maxOAuthClientName {
+		return fmt.Errorf("name isn't utf-8 of at most %d characters", maxOAuthClientName)
+	}
+
+	if oc.Public && len(oc.RedirectURIs) == 0 {
+		return errors.New("a public client needs a redirect uri")
+	}
+
+	for _, uri := range oc.RedirectURIs {
+		if err := ValidateRedirectURI(uri); err != nil {
+			return err
+		}
+	}
+
+	if len(oc.Scopes) == 0 {
+		return errors.New("scopes was empty")
+	}
+
+	return ValidateOAuthScopes(oc.Scopes)
+}
+
+// NewOAuthClient generates the client's id, and its secret unless it's public
+func (oc OAuthClientCreate) NewOAuthClient(now time.Time) (*OAuthClient, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	client := &OAuthClient{
+		ID:           hex.EncodeToString(id),
+		Name:         oc.Name,
+		Public:       oc.Public,
+		RedirectURIs: append([]string{}, oc.RedirectURIs...),
+		Scopes:       append([]string{}, oc.Scopes...),
+		CreatedAt:    now.UTC().Truncate(time.Microsecond),
+	}
+
+	if !oc.Public {
+		var err error
+		if client.Secret, err = newOAuthSecret(); err != nil {
+			return nil, err
+		}
+
+		client.SecretHash = HashToken(client.Secret)
+	}
+
+	return client, nil
+}
+
+type OAuthCodeCreate struct {
+	ClientID      string
+	Organization  string
+	Email         string
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Nonce         string
+	// TTL is how long the code can be exchanged, DefaultOAuthCodeTTL when zero
+	TTL time.Duration
+}
+
+func (cc OAuthCodeCreate) Validate() error {
+	if cc.ClientID == "" {
+		return errors.New("clientId was empty")
+	}
+
+	if err := ValidateOrganizationID(cc.Organization); err != nil {
+		return err
+	}
+
+	if err := ValidateEmail(cc.Email); err != nil {
+		return err
+	}
+
+	if cc.RedirectURI == "" {
+		return errors.New("redirectUri was empty")
+	}
+
+	if cc.CodeChallenge == "" {
+		return errors.New("codeChallenge was empty")
+	}
+
+	if cc.TTL < 0 {
+		return errors.New("ttl was invalid")
+	}
+
+	return ValidateOAuthScopes(cc.Scopes)
+}
+
+// NewOAuthCode generates the code, it expires TTL after now
+func (cc OAuthCodeCreate) NewOAuthCode(now time.Time) (*OAuthCode, error) {
+	code, err := newOAuthSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := cc.TTL
+	if ttl == 0 {
+		ttl = DefaultOAuthCodeTTL
+	}
+
+	return &OAuthCode{
+		ClientID:      cc.ClientID,
+		Organization:  cc.Organization,
+		Email:         cc.Email,
+		RedirectURI:   cc.RedirectURI,
+		Scopes:        append([]string(nil), cc.Scopes...),
+		CodeChallenge: cc.CodeChallenge,
+		Nonce:         cc.Nonce,
+		ExpiresAt:     now.Add(ttl).UTC().Truncate(time.Microsecond),
+		Code:          code,
+	}, nil
+}
+
+type OAuthTokenCreate struct {
+	ClientID string
+	// Organization and Email are both empty for the client credentials grant
+	Organization string
+	Email        string
+	Scopes       []string
+	// TTL is how long the token is accepted, DefaultOAuthTokenTTL when zero
+	TTL time.Duration
+}
+
+func (tc OAuthTokenCreate) Validate() error {
+	if tc.ClientID == "" {
+		return errors.New("clientId was empty")
+	}
+
+	if tc.Organization != "" || tc.Email != "" {
+		if err := ValidateOrganizationID(tc.Organization); err != nil {
+			return err
+		}
+
+		if err := ValidateEmail(tc.Email); err != nil {
+			return err
+		}
+	}
+
+	if tc.TTL < 0 {
+		return errors.New("ttl was invalid")
+	}
+
+	return ValidateOAuthScopes(tc.Scopes)
+}
+
+// NewOAuthToken generates the token, it expires TTL after now
+func (tc OAuthTokenCreate) NewOAuthToken(now time.Time) (*OAuthToken, error) {
+	token, err := newOAuthSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := tc.TTL
+	if ttl == 0 {
+		ttl = DefaultOAuthTokenTTL
+	}
+
+	return &OAuthToken{
+		ClientID:     tc.ClientID,
+		Organization: tc.Organization,
+		Email:        tc.Email,
+		Scopes:       append([]string(nil), tc.Scopes...),
+		ExpiresAt:    now.Add(ttl).UTC().Truncate(time.Microsecond),
+		Token:        token,
+	}, nil
+}
+
+// newOAuthSecret is 256 random bits, base64url
+func newOAuthSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,34 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// verifierRegexp is RFC 7636's code_verifier, 43 to 128 unreserved characters
+var verifierRegexp = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// S256Challenge is the code_challenge of a code_verifier with the S256 method
+func S256Challenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// validChallenge is true for a base64url sha-256 hash
+func validChallenge(challenge string) bool {
+	hash, err := base64.RawURLEncoding.DecodeString(challenge)
+
+	return err == nil && len(hash) == sha256.Size
+}
+
+// verifyChallenge is true when the verifier hashes to the challenge
+func verifyChallenge(challenge string, verifier string) bool {
+	if !verifierRegexp.MatchString(verifier) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(S256Challenge(verifier)), []byte(challenge)) == 1
+}
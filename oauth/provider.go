@@ -0,0 +1,346 @@
+// Package oauth is an OAuth 2.1 authorization server and OpenID Connect provider for the service's accounts
+// it has the authorization code grant with PKCE and the client credentials grant, implicit and password grants were dropped by OAuth 2.1
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// DefaultIssuer is the development server's
+const DefaultIssuer = "http://localhost:8080"
+
+// the OpenID Connect scopes, they're about an account so the client credentials grant can't have them
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// maxNonceLength limits what's stored with a code and echoed in the id token
+const maxNonceLength = 255
+
+var (
+	// ErrInvalidRedirectURI is returned by Authorize when the redirect uri isn't one of the client's
+	// like ddd.ErrOAuthClientNotFound it can't be sent back to the client, the authorization endpoint shows it instead
+	ErrInvalidRedirectURI = errors.New("redirect uri isn't registered for the client")
+	// ErrInvalidToken is returned for an unknown, expired or revoked access token, or one of a disabled account
+	ErrInvalidToken = errors.New("access token is invalid or expired")
+	// ErrInsufficientScope is returned by UserInfo for a token without the openid scope
+	ErrInsufficientScope = errors.New("access token doesn't have the openid scope")
+)
+
+// Error is an OAuth error response, its Code is one of RFC 6749's, "invalid_request" or "invalid_grant"
+type Error struct {
+	Code        string
+	Description string
+	// Status is the token endpoint's status, 401 for a client that failed to authenticate and 400 otherwise
+	Status int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func newError(code string, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:        code,
+		Description: fmt.Sprintf(format, args...),
+		Status:      http.StatusBadRequest,
+	}
+}
+
+type ProviderOpts struct {
+	Repository ddd.OAuthRepository
+	// Users looks up the accounts the id tokens and userinfo describe
+	Users ddd.UserRepository
+	// Issuer is the provider's url, "https://example.com", it's DefaultIssuer when empty
+	Issuer string
+	// CodeTTL and AccessTokenTTL are ddd.DefaultOAuthCodeTTL and ddd.DefaultOAuthTokenTTL when zero
+	CodeTTL        time.Duration
+	AccessTokenTTL time.Duration
+}
+
+// Provider registers clients, asks accounts for their consent and issues tokens
+// id tokens are signed with the key of every other token (ddd.SignIDToken), access tokens are opaque and stored hashed
+type Provider struct {
+	repo           ddd.OAuthRepository
+	users          ddd.UserRepository
+	issuer         string
+	codeTTL        time.Duration
+	accessTokenTTL time.Duration
+}
+
+func NewProvider(
+	opts ProviderOpts,
+) (
+	*Provider,
+	error,
+) {
+	if opts.Repository == nil {
+		return nil, errors.New("repository was nil")
+	}
+
+	if opts.Users == nil {
+		return nil, errors.New("users was nil")
+	}
+
+	if opts.Issuer == "" {
+		opts.Issuer = DefaultIssuer
+	}
+
+	// OpenID Connect's issuer is https without a query or fragment, http is allowed for development
+	issuer, err := url.Parse(opts.Issuer)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return nil, fmt.Errorf("issuer isn't an http or https url: %s", opts.Issuer)
+	}
+
+	if opts.CodeTTL < 0 || opts.AccessTokenTTL < 0 {
+		return nil, errors.New("ttl was invalid")
+	}
+
+	return &Provider{
+		repo:           opts.Repository,
+		users:          opts.Users,
+		issuer:         strings.TrimSuffix(opts.Issuer, "/"),
+		codeTTL:        opts.CodeTTL,
+		accessTokenTTL: opts.AccessTokenTTL,
+	}, nil
+}
+
+// Issuer is the url the endpoints are under, it's the iss of the id tokens
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// RegisterClient creates a client, a confidential client's secret is only returned this once
+func (p *Provider) RegisterClient(
+	ctx context.Context,
+	opts ddd.OAuthClientCreate,
+) (
+	*ddd.OAuthClient,
+	error,
+) {
+	return p.repo.CreateOAuthClient(ctx, opts)
+}
+
+// Clients returns every client ordered by registration
+func (p *Provider) Clients(
+	ctx context.Context,
+) (
+	[]*ddd.OAuthClient,
+	error,
+) {
+	return p.repo.ListOAuthClients(ctx)
+}
+
+// DeleteClient deletes the client with its codes and tokens
+func (p *Provider) DeleteClient(
+	ctx context.Context,
+	id string,
+) error {
+	return p.repo.DeleteOAuthClient(ctx, id)
+}
+
+// AuthorizationRequest is the query of the authorization endpoint, the consent screen posts it back
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// Authorization is a valid authorization request, it's what the consent screen asks the account for
+type Authorization struct {
+	Client      *ddd.OAuthClient
+	RedirectURI string
+	Scopes      []string
+	State       string
+	// CodeChallenge is S256, it's the only method OAuth 2.1 servers have to support and plain doesn't protect the code
+	CodeChallenge string
+	Nonce         string
+}
+
+// Authorize validates an authorization request
+// an unknown client (ddd.ErrOAuthClientNotFound) or ErrInvalidRedirectURI can't be redirected
+// the other failures are an *Error returned with the authorization, ErrorRedirect sends them back to the client
+func (p *Provider) Authorize(
+	ctx context.Context,
+	request AuthorizationRequest,
+) (
+	*Authorization,
+	error,
+) {
+	if request.ClientID == "" {
+		return nil, ddd.ErrOAuthClientNotFound
+	}
+
+	client, err := p.repo.GetOAuthClient(ctx, request.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURI := request.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		// a client with a single redirect uri can leave it out
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	if !contains(client.RedirectURIs, redirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	a := &Authorization{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         request.State,
+		CodeChallenge: request.CodeChallenge,
+		Nonce:         request.Nonce,
+	}
+
+	if request.ResponseType != "code" {
+		return a, newError("unsupported_response_type", "response_type must be code")
+	}
+
+	if request.CodeChallenge == "" {
+		return a, newError("invalid_request", "code_challenge is required")
+	}
+
+	if request.CodeChallengeMethod != "S256" {
+		return a, newError("invalid_request", "code_challenge_method must be S256")
+	}
+
+	if !validChallenge(request.CodeChallenge) {
+		return a, newError("invalid_request", "code_challenge isn't a base64url sha-256 hash")
+	}
+
+	if len(request.Nonce) > maxNonceLength {
+		return a, newError("invalid_request", "nonce can't be longer than %d characters", maxNonceLength)
+	}
+
+	a.Scopes, err = requestedScopes(client, request.Scope)
+	if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// Approve records the account's consent, it returns the redirect with the authorization code
+// it returns ddd.ErrUserDisabled for a disabled account
+func (p *Provider) Approve(
+	ctx context.Context,
+	a *Authorization,
+	tenant string,
+	email string,
+) (
+	string,
+	error,
+) {
+	user, err := p.users.Get(ddd.WithTenant(ctx, tenant), email)
+	if err != nil {
+		return "", err
+	}
+
+	if user.Disabled {
+		return "", ddd.ErrUserDisabled
+	}
+
+	code, err := p.repo.CreateOAuthCode(ctx, ddd.OAuthCodeCreate{
+		ClientID:      a.Client.ID,
+		Organization:  tenant,
+		Email:         email,
+		RedirectURI:   a.RedirectURI,
+		Scopes:        a.Scopes,
+		CodeChallenge: a.CodeChallenge,
+		Nonce:         a.Nonce,
+		TTL:           p.codeTTL,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return p.redirect(a, url.Values{"code": {code.Code}}), nil
+}
+
+// Deny returns the redirect that tells the client the account didn't consent
+func (p *Provider) Deny(a *Authorization) string {
+	return p.ErrorRedirect(a, newError("access_denied", "the account didn't consent"))
+}
+
+// ErrorRedirect returns the redirect that sends the error back to the client
+func (p *Provider) ErrorRedirect(a *Authorization, e *Error) string {
+	return p.redirect(a, url.Values{
+		"error":             {e.Code},
+		"error_description": {e.Description},
+	})
+}
+
+// redirect adds the state and the issuer (RFC 9207) to the redirect uri's query
+func (p *Provider) redirect(a *Authorization, params url.Values) string {
+	// a registered redirect uri was validated, it parses
+	u, _ := url.Parse(a.RedirectURI)
+
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+
+	if a.State != "" {
+		query.Set("state", a.State)
+	}
+
+	query.Set("iss", p.issuer)
+
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// requestedScopes splits the scope parameter, every scope has to be one of the client's
+func requestedScopes(client *ddd.OAuthClient, scope string) ([]string, error) {
+	scopes := []string{}
+	for _, s := range strings.Split(scope, " ") {
+		if s == "" || contains(scopes, s) {
+			continue
+		}
+
+		if !contains(client.Scopes, s) {
+			return nil, newError("invalid_scope", "scope isn't allowed for the client: %s", s)
+		}
+
+		scopes = append(scopes, s)
+	}
+
+	if len(scopes) == 0 {
+		return nil, newError("invalid_scope", "scope was empty")
+	}
+
+	return scopes, nil
+}
+
+// isAccountScope is true for the OpenID Connect scopes
+func isAccountScope(scope string) bool {
+	return scope == ScopeOpenID || scope == ScopeProfile || scope == ScopeEmail
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,429 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func newTestProvider(t *testing.T) (*Provider, *mock.UserRepository) {
+	t.Helper()
+
+	repo := mock.NewUserRepository()
+	repo.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+		Locale:    "en-CA",
+	})
+
+	p, err := NewProvider(ProviderOpts{
+		Repository: repo,
+		Users:      repo,
+		Issuer:     "https://ddd.example/",
+	})
+	if err != nil {
+		t.Fatalf("failed to create provider: %s", err)
+	}
+
+	return p, repo
+}
+
+func registerClient(t *testing.T, p *Provider, public bool, scopes ...string) *ddd.OAuthClient {
+	t.Helper()
+
+	client, err := p.RegisterClient(context.Background(), ddd.OAuthClientCreate{
+		Name:         "Example",
+		Public:       public,
+		RedirectURIs: []string{"https://app.example/callback"},
+		Scopes:       scopes,
+	})
+	if err != nil {
+		t.Fatalf("failed to register client: %s", err)
+	}
+
+	return client
+}
+
+func newAuthorizationRequest(client *ddd.OAuthClient, scope string) AuthorizationRequest {
+	return AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            client.ID,
+		RedirectURI:         "https://app.example/callback",
+		Scope:               scope,
+		State:               "state",
+		CodeChallenge:       S256Challenge(testVerifier),
+		CodeChallengeMethod: "S256",
+		Nonce:               "nonce",
+	}
+}
+
+// authorize approves the request for the account and returns the code
+func authorize(t *testing.T, p *Provider, request AuthorizationRequest) string {
+	t.Helper()
+
+	a, err := p.Authorize(context.Background(), request)
+	if err != nil {
+		t.Fatalf("failed to authorize: %s", err)
+	}
+
+	redirect, err := p.Approve(context.Background(), a, ddd.DefaultOrganization, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to approve: %s", err)
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", redirect, err)
+	}
+
+	if !strings.HasPrefix(redirect, "https://app.example/callback?") || u.Query().Get("state") != request.State || u.Query().Get("iss") != "https://ddd.example" {
+		t.Errorf("unknown redirect: %s", redirect)
+	}
+
+	return u.Query().Get("code")
+}
+
+// oauthErrorCode returns the code of an *Error, empty for any other error
+func oauthErrorCode(err error) string {
+	e := &Error{}
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return ""
+}
+
+func TestProvider(t *testing.T) {
+	p, _ := newTestProvider(t)
+	ctx := context.Background()
+
+	client := registerClient(t, p, false, ScopeOpenID, ScopeEmail, ScopeProfile)
+
+	code := authorize(t, p, newAuthorizationRequest(client, "openid email profile"))
+
+	token, err := p.Token(ctx, TokenRequest{
+		GrantType:    "authorization_code",
+		ClientID:     client.ID,
+		ClientSecret: client.Secret,
+		Code:         code,
+		RedirectURI:  "https://app.example/callback",
+		CodeVerifier: testVerifier,
+	})
+	if err != nil {
+		t.Fatalf("failed to exchange code: %s", err)
+	}
+
+	if token.AccessToken == "" || !reflect.DeepEqual(token.Scopes, []string{"openid", "email", "profile"}) {
+		t.Errorf("unknown token: %+v", token)
+	}
+
+	idToken := ddd.ParseIDToken(token.IDToken, client.ID)
+	if idToken == nil {
+		t.Fatalf("invalid id token: %s", token.IDToken)
+	}
+
+	expected := map[string]interface{}{
+		"email":       "jackson@juandefu.ca",
+		"name":        "Jackson Sabey",
+		"given_name":  "Jackson",
+		"family_name": "Sabey",
+		"locale":      "en-CA",
+	}
+
+	if idToken.Issuer != "https://ddd.example" || idToken.Subject != "default/jackson@juandefu.ca" || idToken.Nonce != "nonce" || !reflect.DeepEqual(idToken.Claims, expected) {
+		t.Errorf("unknown id token: %+v", idToken)
+	}
+
+	claims, err := p.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("failed to get userinfo: %s", err)
+	}
+
+	expected["sub"] = "default/jackson@juandefu.ca"
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("unknown claims: %v", claims)
+	}
+
+	// a code is exchanged once
+	if _, err := p.Token(ctx, TokenRequest{
+		GrantType:    "authorization_code",
+		ClientID:     client.ID,
+		ClientSecret: client.Secret,
+		Code:         code,
+		CodeVerifier: testVerifier,
+	}); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	if err := p.Revoke(ctx, client.ID, client.Secret, token.AccessToken); err != nil {
+		t.Fatalf("failed to revoke: %s", err)
+	}
+
+	if _, err := p.UserInfo(ctx, token.AccessToken); err != ErrInvalidToken {
+		t.Errorf("a revoked token was accepted: %v", err)
+	}
+
+	// revoking twice isn't an error
+	if err := p.Revoke(ctx, client.ID, client.Secret, token.AccessToken); err != nil {
+		t.Errorf("unknown error: %v", err)
+	}
+}
+
+func TestProvider_Scopes(t *testing.T) {
+	p, _ := newTestProvider(t)
+	ctx := context.Background()
+
+	client := registerClient(t, p, true, ScopeOpenID, ScopeEmail, "listings:read")
+
+	// without profile there's no name, without openid there's no id token
+	code := authorize(t, p, newAuthorizationRequest(client, "listings:read email"))
+
+	token, err := p.Token(ctx, TokenRequest{
+		GrantType:    "authorization_code",
+		ClientID:     client.ID,
+		Code:         code,
+		CodeVerifier: testVerifier,
+	})
+	if err != nil {
+		t.Fatalf("failed to exchange code: %s", err)
+	}
+
+	if token.IDToken != "" {
+		t.Errorf("an id token was issued without the openid scope")
+	}
+
+	if _, err := p.UserInfo(ctx, token.AccessToken); err != ErrInsufficientScope {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	code = authorize(t, p, newAuthorizationRequest(client, "openid email"))
+
+	token, err = p.Token(ctx, TokenRequest{
+		GrantType:    "authorization_code",
+		ClientID:     client.ID,
+		Code:         code,
+		CodeVerifier: testVerifier,
+	})
+	if err != nil {
+		t.Fatalf("failed to exchange code: %s", err)
+	}
+
+	claims, err := p.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("failed to get userinfo: %s", err)
+	}
+
+	if !reflect.DeepEqual(claims, map[string]interface{}{"sub": "default/jackson@juandefu.ca", "email": "jackson@juandefu.ca"}) {
+		t.Errorf("unknown claims: %v", claims)
+	}
+}
+
+func TestProvider_Authorize(t *testing.T) {
+	p, _ := newTestProvider(t)
+	ctx := context.Background()
+
+	client := registerClient(t, p, false, ScopeOpenID, ScopeEmail)
+
+	if _, err := p.Authorize(ctx, AuthorizationRequest{ClientID: "unknown"}); err != ddd.ErrOAuthClientNotFound {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	request := newAuthorizationRequest(client, "openid")
+	request.RedirectURI = "https://phishing.example/callback"
+	if _, err := p.Authorize(ctx, request); err != ErrInvalidRedirectURI {
+		t.Errorf("another redirect uri was accepted: %v", err)
+	}
+
+	// the only redirect uri can be left out
+	request.RedirectURI = ""
+	if a, err := p.Authorize(ctx, request); err != nil || a.RedirectURI != "https://app.example/callback" {
+		t.Errorf("unknown authorization: %+v %v", a, err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*AuthorizationRequest)
+		code   string
+	}{
+		{"token", func(r *AuthorizationRequest) { r.ResponseType = "token" }, "unsupported_response_type"},
+		{"no challenge", func(r *AuthorizationRequest) { r.CodeChallenge = "" }, "invalid_request"},
+		{"plain", func(r *AuthorizationRequest) { r.CodeChallengeMethod = "plain" }, "invalid_request"},
+		{"invalid challenge", func(r *AuthorizationRequest) { r.CodeChallenge = "challenge" }, "invalid_request"},
+		{"no scope", func(r *AuthorizationRequest) { r.Scope = "" }, "invalid_scope"},
+		{"unknown scope", func(r *AuthorizationRequest) { r.Scope = "openid admin" }, "invalid_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newAuthorizationRequest(client, "openid")
+			tt.change(&request)
+
+			a, err := p.Authorize(ctx, request)
+			if oauthErrorCode(err) != tt.code {
+				t.Fatalf("unknown error: %v", err)
+			}
+
+			// the error goes back to the client
+			redirect, _ := url.Parse(p.ErrorRedirect(a, err.(*Error)))
+			if redirect.Host != "app.example" || redirect.Query().Get("error") != tt.code || redirect.Query().Get("state") != "state" {
+				t.Errorf("unknown redirect: %s", redirect)
+			}
+		})
+	}
+
+	a, err := p.Authorize(ctx, newAuthorizationRequest(client, "openid"))
+	if err != nil {
+		t.Fatalf("failed to authorize: %s", err)
+	}
+
+	if redirect, _ := url.Parse(p.Deny(a)); redirect.Query().Get("error") != "access_denied" {
+		t.Errorf("unknown redirect: %s", redirect)
+	}
+
+	if _, err := p.Approve(ctx, a, ddd.DefaultOrganization, "unknown@juandefu.ca"); err != ddd.ErrUserNotFound {
+		t.Errorf("unknown error: %v", err)
+	}
+}
+
+func TestProvider_Token(t *testing.T) {
+	p, repo := newTestProvider(t)
+	ctx := context.Background()
+
+	client := registerClient(t, p, false, ScopeOpenID)
+	public := registerClient(t, p, true, ScopeOpenID)
+
+	code := authorize(t, p, newAuthorizationRequest(client, "openid"))
+
+	tests := []struct {
+		name    string
+		request TokenRequest
+		code    string
+	}{
+		{"no grant", TokenRequest{ClientID: client.ID, ClientSecret: client.Secret}, "invalid_request"},
+		{"password", TokenRequest{GrantType: "password", ClientID: client.ID, ClientSecret: client.Secret}, "unsupported_grant_type"},
+		{"no secret", TokenRequest{GrantType: "authorization_code", ClientID: client.ID, Code: code, CodeVerifier: testVerifier}, "invalid_client"},
+		{"wrong secret", TokenRequest{GrantType: "authorization_code", ClientID: client.ID, ClientSecret: "secret", Code: code, CodeVerifier: testVerifier}, "invalid_client"},
+		{"unknown client", TokenRequest{GrantType: "authorization_code", ClientID: "unknown", Code: code, CodeVerifier: testVerifier}, "invalid_client"},
+		{"public secret", TokenRequest{GrantType: "authorization_code", ClientID: public.ID, ClientSecret: "secret", Code: code, CodeVerifier: testVerifier}, "invalid_client"},
+		{"no verifier", TokenRequest{GrantType: "authorization_code", ClientID: client.ID, ClientSecret: client.Secret, Code: code}, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Token(ctx, tt.request); oauthErrorCode(err) != tt.code {
+				t.Errorf("unknown error: %v", err)
+			}
+		})
+	}
+
+	// another client's code
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "authorization_code", ClientID: public.ID, Code: code, CodeVerifier: testVerifier}); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("another client's code was exchanged: %v", err)
+	}
+
+	// the code was consumed by the failed exchange
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "authorization_code", ClientID: client.ID, ClientSecret: client.Secret, Code: code, CodeVerifier: testVerifier}); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	code = authorize(t, p, newAuthorizationRequest(client, "openid"))
+
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "authorization_code", ClientID: client.ID, ClientSecret: client.Secret, Code: code, CodeVerifier: strings.Repeat("a", 43)}); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("a wrong verifier was accepted: %v", err)
+	}
+
+	code = authorize(t, p, newAuthorizationRequest(client, "openid"))
+
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "authorization_code", ClientID: client.ID, ClientSecret: client.Secret, Code: code, RedirectURI: "https://app.example/other", CodeVerifier: testVerifier}); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("another redirect uri was accepted: %v", err)
+	}
+
+	// the account was disabled after it consented
+	code = authorize(t, p, newAuthorizationRequest(client, "openid"))
+
+	repo.Seed(ddd.User{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  ddd.HashPassword("pass"),
+		Disabled:  true,
+	})
+
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "authorization_code", ClientID: client.ID, ClientSecret: client.Secret, Code: code, CodeVerifier: testVerifier}); oauthErrorCode(err) != "invalid_grant" {
+		t.Errorf("a disabled account's code was exchanged: %v", err)
+	}
+}
+
+func TestProvider_ClientCredentials(t *testing.T) {
+	p, _ := newTestProvider(t)
+	ctx := context.Background()
+
+	client := registerClient(t, p, false, ScopeOpenID, "listings:read", "listings:write")
+
+	token, err := p.Token(ctx, TokenRequest{GrantType: "client_credentials", ClientID: client.ID, ClientSecret: client.Secret})
+	if err != nil {
+		t.Fatalf("failed to get token: %s", err)
+	}
+
+	// the account scopes are left out
+	if token.IDToken != "" || !reflect.DeepEqual(token.Scopes, []string{"listings:read", "listings:write"}) {
+		t.Errorf("unknown token: %+v", token)
+	}
+
+	if _, err := p.UserInfo(ctx, token.AccessToken); err != ErrInsufficientScope {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	token, err = p.Token(ctx, TokenRequest{GrantType: "client_credentials", ClientID: client.ID, ClientSecret: client.Secret, Scope: "listings:read"})
+	if err != nil {
+		t.Fatalf("failed to get token: %s", err)
+	}
+
+	if !reflect.DeepEqual(token.Scopes, []string{"listings:read"}) {
+		t.Errorf("unknown token: %+v", token)
+	}
+
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "client_credentials", ClientID: client.ID, ClientSecret: client.Secret, Scope: "openid"}); oauthErrorCode(err) != "invalid_scope" {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	public := registerClient(t, p, true, "listings:read")
+	if _, err := p.Token(ctx, TokenRequest{GrantType: "client_credentials", ClientID: public.ID}); oauthErrorCode(err) != "unauthorized_client" {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	// a client can't revoke another client's token
+	if err := p.Revoke(ctx, public.ID, "", token.AccessToken); err != nil {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	if _, err := p.UserInfo(ctx, token.AccessToken); err != ErrInsufficientScope {
+		t.Errorf("another client revoked the token: %v", err)
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	if challenge := S256Challenge(testVerifier); challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("unknown challenge: %s", challenge)
+	}
+
+	if !verifyChallenge("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", testVerifier) {
+		t.Errorf("the verifier wasn't accepted")
+	}
+
+	// too short to be a verifier
+	if verifyChallenge(S256Challenge("short"), "short") {
+		t.Errorf("a short verifier was accepted")
+	}
+}
@@ -0,0 +1,343 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// TokenRequest is the form of the token endpoint, the client's credentials may come from basic authentication instead
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	// Code, RedirectURI and CodeVerifier are the authorization code grant's
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	// Scope is the client credentials grant's, the client's non OpenID Connect scopes when empty
+	Scope string
+}
+
+// Token is an issued access token, IDToken is set when the openid scope was consented to
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+	Scopes      []string
+	IDToken     string
+}
+
+// Token runs the request's grant, the failures of the request are an *Error
+func (p *Provider) Token(
+	ctx context.Context,
+	request TokenRequest,
+) (
+	*Token,
+	error,
+) {
+	if request.GrantType == "" {
+		return nil, newError("invalid_request", "grant_type is required")
+	}
+
+	client, err := p.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.GrantType {
+	case "authorization_code":
+		return p.exchangeCode(ctx, client, request)
+	case "client_credentials":
+		return p.clientCredentials(ctx, client, request)
+	}
+
+	return nil, newError("unsupported_grant_type", "grant_type must be authorization_code or client_credentials")
+}
+
+// authenticateClient checks a confidential client's secret, a public client can't have one
+func (p *Provider) authenticateClient(
+	ctx context.Context,
+	id string,
+	secret string,
+) (
+	*ddd.OAuthClient,
+	error,
+) {
+	failed := &Error{
+		Code:        "invalid_client",
+		Description: "client authentication failed",
+		Status:      http.StatusUnauthorized,
+	}
+
+	if id == "" {
+		return nil, failed
+	}
+
+	client, err := p.repo.GetOAuthClient(ctx, id)
+	if errors.Is(err, ddd.ErrOAuthClientNotFound) {
+		return nil, failed
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, failed
+		}
+
+		return client, nil
+	}
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(ddd.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, failed
+	}
+
+	return client, nil
+}
+
+func (p *Provider) exchangeCode(
+	ctx context.Context,
+	client *ddd.OAuthClient,
+	request TokenRequest,
+) (
+	*Token,
+	error,
+) {
+	if request.Code == "" {
+		return nil, newError("invalid_request", "code is required")
+	}
+
+	if request.CodeVerifier == "" {
+		return nil, newError("invalid_request", "code_verifier is required")
+	}
+
+	// the code is consumed before it's checked, a code that was sent with a wrong verifier can't be tried again
+	code, err := p.repo.ConsumeOAuthCode(ctx, request.Code)
+	if errors.Is(err, ddd.ErrOAuthCodeNotFound) {
+		return nil, newError("invalid_grant", "code is invalid or expired")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if code.ClientID != client.ID {
+		return nil, newError("invalid_grant", "code is invalid or expired")
+	}
+
+	if request.RedirectURI != "" && request.RedirectURI != code.RedirectURI {
+		return nil, newError("invalid_grant", "redirect_uri isn't the authorization request's")
+	}
+
+	if !verifyChallenge(code.CodeChallenge, request.CodeVerifier) {
+		return nil, newError("invalid_grant", "code_verifier doesn't match the code_challenge")
+	}
+
+	// the account may have been disabled since it consented
+	user, err := p.users.Get(ddd.WithTenant(ctx, code.Organization), code.Email)
+	if errors.Is(err, ddd.ErrUserNotFound) {
+		return nil, newError("invalid_grant", "code is invalid or expired")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, newError("invalid_grant", "%s", ddd.ErrUserDisabled)
+	}
+
+	accessToken, err := p.repo.CreateOAuthToken(ctx, ddd.OAuthTokenCreate{
+		ClientID:     client.ID,
+		Organization: code.Organization,
+		Email:        code.Email,
+		Scopes:       code.Scopes,
+		TTL:          p.accessTokenTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		AccessToken: accessToken.Token,
+		ExpiresAt:   accessToken.ExpiresAt,
+		Scopes:      accessToken.Scopes,
+	}
+
+	if contains(code.Scopes, ScopeOpenID) {
+		claims := accountClaims(user, code.Scopes)
+		delete(claims, "sub")
+
+		token.IDToken = ddd.SignIDToken(ddd.IDToken{
+			Issuer:   p.issuer,
+			Subject:  subject(user),
+			Audience: client.ID,
+			Nonce:    code.Nonce,
+			Claims:   claims,
+		}, time.Now())
+	}
+
+	return token, nil
+}
+
+func (p *Provider) clientCredentials(
+	ctx context.Context,
+	client *ddd.OAuthClient,
+	request TokenRequest,
+) (
+	*Token,
+	error,
+) {
+	if client.Public {
+		return nil, newError("unauthorized_client", "a public client can't use the client_credentials grant")
+	}
+
+	scopes := []string{}
+	if request.Scope == "" {
+		for _, scope := range client.Scopes {
+			if !isAccountScope(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		if len(scopes) == 0 {
+			return nil, newError("invalid_scope", "the client doesn't have a scope for the client_credentials grant")
+		}
+	} else {
+		var err error
+		if scopes, err = requestedScopes(client, request.Scope); err != nil {
+			return nil, err
+		}
+
+		for _, scope := range scopes {
+			if isAccountScope(scope) {
+				return nil, newError("invalid_scope", "scope is about an account, the client_credentials grant doesn't have one: %s", scope)
+			}
+		}
+	}
+
+	accessToken, err := p.repo.CreateOAuthToken(ctx, ddd.OAuthTokenCreate{
+		ClientID: client.ID,
+		Scopes:   scopes,
+		TTL:      p.accessTokenTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		AccessToken: accessToken.Token,
+		ExpiresAt:   accessToken.ExpiresAt,
+		Scopes:      accessToken.Scopes,
+	}, nil
+}
+
+// UserInfo returns the claims of the token's account, the token has to have the openid scope
+func (p *Provider) UserInfo(
+	ctx context.Context,
+	accessToken string,
+) (
+	map[string]interface{},
+	error,
+) {
+	if accessToken == "" {
+		return nil, ErrInvalidToken
+	}
+
+	token, err := p.repo.GetOAuthToken(ctx, accessToken)
+	if errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		return nil, ErrInvalidToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Email == "" || !contains(token.Scopes, ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+
+	user, err := p.users.Get(ddd.WithTenant(ctx, token.Organization), token.Email)
+	if errors.Is(err, ddd.ErrUserNotFound) {
+		return nil, ErrInvalidToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, ErrInvalidToken
+	}
+
+	return accountClaims(user, token.Scopes), nil
+}
+
+// Revoke revokes one of the client's access tokens
+// an unknown token isn't an error (RFC 7009), the client can't tell whether another client's token exists
+func (p *Provider) Revoke(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	token string,
+) error {
+	client, err := p.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		return newError("invalid_request", "token is required")
+	}
+
+	err = p.repo.RevokeOAuthToken(ctx, client.ID, token)
+	if errors.Is(err, ddd.ErrOAuthTokenNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// subject is the account's sub claim, an email is only unique within its organization
+func subject(user *ddd.User) string {
+	return user.Organization + "/" + user.Email
+}
+
+// accountClaims are the sub claim and the claims of the profile and email scopes
+func accountClaims(user *ddd.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": subject(user),
+	}
+
+	if contains(scopes, ScopeEmail) {
+		claims["email"] = user.Email
+	}
+
+	if contains(scopes, ScopeProfile) {
+		name := user.DisplayName
+		if name == "" {
+			name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		}
+
+		claims["name"] = name
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+
+		if user.Locale != "" {
+			claims["locale"] = user.Locale
+		}
+
+		if user.Timezone != "" {
+			claims["zoneinfo"] = user.Timezone
+		}
+	}
+
+	return claims
+}
@@ -0,0 +1,164 @@
+package ddd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// every OrganizationRepository returns these, callers can match them with errors.Is
+var (
+	ErrOrganizationExists   = errors.New("organization already exists")
+	ErrOrganizationNotFound = errors.New("organization doesn't exist")
+	// ErrInvitationNotFound is returned by AcceptInvitation for an unknown or expired token, or another email
+	ErrInvitationNotFound = errors.New("invitation doesn't exist or expired")
+)
+
+// DefaultOrganization holds the accounts created before organizations, and every account of a context without a tenant
+// its admins manage the organizations
+const DefaultOrganization = "default"
+
+// DefaultInvitationTTL is how long an invitation can be accepted when InvitationCreate's TTL is zero
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+// Organization is a tenant, its accounts are kept apart from every other organization's
+// an email is unique within an organization, the same email can have an account in several
+type Organization struct {
+	// ID is the tenant, it's in the tokens of the organization's accounts
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// Invitation lets an email sign up to an organization, it's deleted once it's accepted
+type Invitation struct {
+	Organization string
+	Email        string
+	// Admin is given to the account created by accepting it
+	Admin     bool
+	ExpiresAt time.Time
+	// Token is only set on the invitation CreateInvitation returns, the repository keeps its hash
+	Token string
+}
+
+// every method must give up and return ctx.Err() once the context is cancelled or past its deadline
+// unlike UserRepository it isn't scoped to the context's tenant, the callers check who can manage an organization
+type OrganizationRepository interface {
+	CreateOrganization(context.Context, OrganizationCreate) (*Organization, error)
+	GetOrganization(ctx context.Context, id string) (*Organization, error)
+	// ListOrganizations returns every organization ordered by ID
+	ListOrganizations(context.Context) ([]*Organization, error)
+	CreateInvitation(context.Context, InvitationCreate) (*Invitation, error)
+	// AcceptInvitation creates the invited account in the invitation's organization, with the invitation's admin
+	// the user's email has to be the invited email
+	AcceptInvitation(ctx context.Context, token string, user UserCreate) (*User, error)
+}
+
+type tenantKey struct{}
+
+// WithTenant scopes every UserRepository call made with the returned context to an organization's accounts
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the context's tenant, DefaultOrganization when it doesn't have one
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+
+	return DefaultOrganization
+}
+
+var organizationIDRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidateOrganizationID checks that id is lowercase letters, digits and dashes, it's used in urls and tokens
+func ValidateOrganizationID(id string) error {
+	if id == "" {
+		return errors.New("id was empty")
+	}
+
+	if !organizationIDRegexp.MatchString(id) {
+		return fmt.Errorf("id isn't lowercase letters, digits and dashes: %s", id)
+	}
+
+	return nil
+}
+
+const maxOrganizationName = 255
+
+type OrganizationCreate struct {
+	ID   string
+	Name string
+}
+
+func (oc OrganizationCreate) Validate() error {
+	if err := ValidateOrganizationID(oc.ID); err != nil {
+		return err
+	}
+
+	if oc.Name == "" {
+		return errors.New("name was empty")
+	}
+
+	if !utf8.ValidString(oc.Name) || utf8.RuneCountInString(oc.Name) > maxOrganizationName {
+		return fmt.Errorf("name isn't utf-8 of at most %d characters", maxOrganizationName)
+	}
+
+	return nil
+}
+
+type InvitationCreate struct {
+	Organization string
+	Email        string
+	Admin        bool
+	// TTL is how long the invitation can be accepted, DefaultInvitationTTL when zero
+	TTL time.Duration
+}
+
+func (ic InvitationCreate) Validate() error {
+	if err := ValidateOrganizationID(ic.Organization); err != nil {
+		return err
+	}
+
+	if ic.Email == "" {
+		return errors.New("email was empty")
+	}
+
+	if ic.TTL < 0 {
+		return errors.New("ttl was invalid")
+	}
+
+	return nil
+}
+
+// NewInvitation generates the invitation's token, it expires TTL after now
+func (ic InvitationCreate) NewInvitation(now time.Time) (*Invitation, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	ttl := ic.TTL
+	if ttl == 0 {
+		ttl = DefaultInvitationTTL
+	}
+
+	return &Invitation{
+		Organization: ic.Organization,
+		Email:        ic.Email,
+		Admin:        ic.Admin,
+		ExpiresAt:    now.Add(ttl).UTC().Truncate(time.Microsecond),
+		Token:        base64.RawURLEncoding.EncodeToString(b),
+	}, nil
+}
+
+// HashToken is what the repositories store instead of an invitation's token
+func HashToken(token string) string {
+	return HashPassword(token)
+}
@@ -0,0 +1,114 @@
+package ddd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// every PasskeyRepository returns these, callers can match them with errors.Is
+var (
+	ErrPasskeyExists   = errors.New("passkey already exists")
+	ErrPasskeyNotFound = errors.New("passkey doesn't exist")
+	// ErrPasskeyCloned is returned for a sign count that didn't move forward, another copy of the credential's key was used
+	ErrPasskeyCloned = errors.New("passkey's sign count went backwards, it may be cloned")
+	// ErrPasskeyChallengeUsed is returned for a ceremony's challenge that already logged an account in
+	ErrPasskeyChallengeUsed = errors.New("passkey challenge was already used")
+)
+
+const (
+	// MaxPasskeyIDSize is WebAuthn's limit on credential ids
+	MaxPasskeyIDSize = 1023
+	// UserHandleSize is the size of the random user handles, WebAuthn allows up to 64 bytes
+	UserHandleSize = 32
+	// MaxPasskeyNameLength is how long a passkey's label can be, in characters
+	MaxPasskeyNameLength = 64
+)
+
+// Passkey is a WebAuthn credential an account logs in with instead of a password
+type Passkey struct {
+	// ID is the authenticator's credential id
+	ID    []byte
+	Email string
+	// UserHandle is random, the authenticator returns it with every assertion so it mustn't reveal the email
+	// every passkey of an account has the same one
+	UserHandle []byte
+	// Name is the account's label for it, "YubiKey" or "Laptop"
+	Name string
+	// PublicKey is COSE encoded
+	PublicKey []byte
+	// AttestationType is the attestation format it was registered with, "none" or "packed"
+	AttestationType string
+	AAGUID          []byte
+	// SignCount is the authenticator's counter of the last assertion, zero when the authenticator doesn't count
+	SignCount uint32
+	// Transports are hints for the browser, "usb", "nfc", "ble", "internal" or "hybrid"
+	Transports []string
+	// BackupEligible passkeys are synced between devices
+	BackupEligible bool
+	CreatedAt      time.Time
+	// LastUsedAt is nil until it's used to log in
+	LastUsedAt *time.Time
+}
+
+// every method is scoped to the context's tenant (WithTenant) like UserRepository's
+// deleting the account deletes its passkeys
+type PasskeyRepository interface {
+	// CreatePasskey returns ErrPasskeyExists when the organization has the credential id, and ErrUserNotFound without an account
+	CreatePasskey(context.Context, PasskeyCreate) (*Passkey, error)
+	// GetPasskey looks a passkey up by its credential id, which is all an assertion without an email has
+	GetPasskey(ctx context.Context, id []byte) (*Passkey, error)
+	// ListPasskeys returns the account's passkeys ordered by creation
+	ListPasskeys(ctx context.Context, email string) ([]*Passkey, error)
+	// UsePasskey records an assertion's sign count, it returns ErrPasskeyCloned unless the count moved forward
+	// an authenticator that doesn't count always sends zero, which is accepted while the stored count is zero
+	UsePasskey(ctx context.Context, id []byte, signCount uint32) error
+	// UsePasskeyChallenge records a login ceremony's challenge until it expires, it returns ErrPasskeyChallengeUsed when it was already recorded
+	// challenges are random, they aren't scoped to the tenant
+	UsePasskeyChallenge(ctx context.Context, challenge string, expiresAt time.Time) error
+	// DeletePasskey returns ErrPasskeyNotFound when the account doesn't have it
+	DeletePasskey(ctx context.Context, email string, id []byte) error
+}
+
+type PasskeyCreate struct {
+	ID              []byte
+	Email           string
+	UserHandle      []byte
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	SignCount       uint32
+	Transports      []string
+	BackupEligible  bool
+}
+
+func (pc PasskeyCreate) Validate() error {
+	if len(pc.ID) == 0 {
+		return errors.New("id was empty")
+	}
+
+	if len(pc.ID) > MaxPasskeyIDSize {
+		return fmt.Errorf("id can't be longer than %d bytes", MaxPasskeyIDSize)
+	}
+
+	if err := ValidateEmail(pc.Email); err != nil {
+		return err
+	}
+
+	if len(pc.UserHandle) == 0 || len(pc.UserHandle) > 64 {
+		return errors.New("userHandle must be 1 to 64 bytes")
+	}
+
+	if utf8.RuneCountInString(pc.Name) > MaxPasskeyNameLength {
+		return fmt.Errorf("name can't be longer than %d characters", MaxPasskeyNameLength)
+	}
+
+	if len(pc.PublicKey) == 0 {
+		return errors.New("publicKey was empty")
+	}
+
+	return nil
+}
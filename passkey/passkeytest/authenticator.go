@@ -0,0 +1,282 @@
+// Package passkeytest is a software authenticator, it answers WebAuthn ceremonies the way a browser with a passkey would
+package passkeytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// the authenticator data flags
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagAttestedData   = 0x40
+)
+
+// Authenticator creates ECDSA P-256 passkeys and signs assertions with them
+type Authenticator struct {
+	// Origin is the origin the "browser" puts in the client data
+	Origin string
+	// Format is the attestation format of new passkeys, "none" or "packed" for self-attestation
+	Format string
+	// SkipUserVerification leaves the UV flag unset, as an authenticator without a PIN or biometrics would
+	SkipUserVerification bool
+	// BackupEligible sets the BE flag, as a synced passkey would
+	BackupEligible bool
+	// AAGUID identifies the authenticator model, it's all zeros when nil
+	AAGUID []byte
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{
+		Origin: origin,
+		Format: "none",
+	}
+}
+
+// Clone returns an authenticator with copies of the passkeys, their sign counts move apart from the original's
+func (a *Authenticator) Clone() *Authenticator {
+	clone := *a
+	clone.credentials = nil
+
+	for _, c := range a.credentials {
+		copied := *c
+		clone.credentials = append(clone.credentials, &copied)
+	}
+
+	return &clone
+}
+
+// Create answers navigator.credentials.create(), it returns the JSON the browser would send to the relying party
+func (a *Authenticator) Create(
+	options protocol.PublicKeyCredentialCreationOptions,
+) (
+	[]byte,
+	error,
+) {
+	userHandle, err := decodeUserID(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, excluded := range options.CredentialExcludeList {
+		if a.find(options.RelyingParty.ID, excluded.CredentialID) != nil {
+			return nil, errors.New("authenticator has an excluded credential")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &credential{
+		id:         make([]byte, 16),
+		rpID:       options.RelyingParty.ID,
+		userHandle: userHandle,
+		key:        key,
+	}
+	if _, err := rand.Read(c.id); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	aaguid := a.AAGUID
+	if aaguid == nil {
+		aaguid = make([]byte, 16)
+	}
+
+	authData := a.authData(c, flagAttestedData)
+	authData = append(authData, aaguid...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(c.id)))
+	authData = append(authData, c.id...)
+	authData = append(authData, publicKey...)
+
+	clientData, err := a.clientData(protocol.CreateCeremony, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := map[string]interface{}{}
+	if a.Format == "packed" {
+		sig, err := sign(key, authData, clientData)
+		if err != nil {
+			return nil, err
+		}
+
+		statement["alg"] = int64(webauthncose.AlgES256)
+		statement["sig"] = sig
+	}
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      a.Format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials = append(a.credentials, c)
+
+	return json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(c.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(c.id),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Get answers navigator.credentials.get() with the relying party's first passkey, or the first allowed one
+func (a *Authenticator) Get(
+	options protocol.PublicKeyCredentialRequestOptions,
+) (
+	[]byte,
+	error,
+) {
+	var c *credential
+	for _, candidate := range a.credentials {
+		if candidate.rpID != options.RelyingPartyID {
+			continue
+		}
+
+		if len(options.AllowedCredentials) == 0 {
+			c = candidate
+
+			break
+		}
+
+		for _, allowed := range options.AllowedCredentials {
+			if string(allowed.CredentialID) == string(candidate.id) {
+				c = candidate
+			}
+		}
+	}
+
+	if c == nil {
+		return nil, errors.New("authenticator doesn't have a passkey for the relying party")
+	}
+
+	c.signCount++
+
+	authData := a.authData(c, 0)
+
+	clientData, err := a.clientData(protocol.AssertCeremony, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := sign(c.key, authData, clientData)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(c.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(c.id),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(sig),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(c.userHandle),
+		},
+	})
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && string(c.id) == string(id) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// authData is the rp id hash, the flags and the sign count, the attested credential data is appended by Create
+func (a *Authenticator) authData(c *credential, flags byte) []byte {
+	flags |= flagUserPresent
+	if !a.SkipUserVerification {
+		flags |= flagUserVerified
+	}
+
+	if a.BackupEligible {
+		flags |= flagBackupEligible
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+
+	data := append(rpIDHash[:], flags)
+
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
+
+func (a *Authenticator) clientData(ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) ([]byte, error) {
+	return json.Marshal(protocol.CollectedClientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	})
+}
+
+// sign signs the authenticator data followed by the client data's hash, both attestations and assertions sign this
+func sign(key *ecdsa.PrivateKey, authData []byte, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	return ecdsa.SignASN1(rand.Reader, key, digest[:])
+}
+
+// decodeUserID accepts the user id as go-webauthn returns it, or as a string once the options went through JSON
+func decodeUserID(id interface{}) ([]byte, error) {
+	switch id := id.(type) {
+	case protocol.URLEncodedBase64:
+		return id, nil
+	case []byte:
+		return id, nil
+	case string:
+		return base64.RawURLEncoding.DecodeString(id)
+	}
+
+	return nil, fmt.Errorf("unknown user id: %v", id)
+}
@@ -0,0 +1,380 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sabey/ddd"
+)
+
+const (
+	// DefaultName is the relying party's name browsers show when a passkey is created
+	DefaultName = "ddd"
+	// DefaultID and DefaultOrigin are the development server's
+	DefaultID     = "localhost"
+	DefaultOrigin = "http://localhost:8080"
+)
+
+var (
+	// ErrInvalidCeremony is returned for a ceremony token that's invalid, expired, of another account or was already answered
+	ErrInvalidCeremony = errors.New("passkey ceremony is invalid or expired")
+	// ErrUnsupportedAttestation is returned for attestation formats other than "none" and packed self-attestation
+	ErrUnsupportedAttestation = errors.New("attestation format isn't supported")
+	// ErrInvalidCredential wraps why the browser's response wasn't accepted
+	ErrInvalidCredential = errors.New("passkey credential is invalid")
+)
+
+type RelyingPartyOpts struct {
+	Repository ddd.PasskeyRepository
+	// ID is the domain passkeys are bound to, "example.com", a passkey can't be used on another domain
+	// it's DefaultID when empty
+	ID string
+	// Name is DefaultName when empty
+	Name string
+	// Origins are where the browser may run the ceremonies, "https://example.com", DefaultOrigin when empty
+	Origins []string
+}
+
+// RelyingParty registers passkeys and logs accounts in with them
+// the state of a ceremony is a signed token (ddd.SignPasskeyCeremony) the browser sends back with its response
+type RelyingParty struct {
+	repo     ddd.PasskeyRepository
+	webauthn *webauthn.WebAuthn
+}
+
+func NewRelyingParty(
+	opts RelyingPartyOpts,
+) (
+	*RelyingParty,
+	error,
+) {
+	if opts.Repository == nil {
+		return nil, errors.New("repository was nil")
+	}
+
+	if opts.ID == "" {
+		opts.ID = DefaultID
+	}
+
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+
+	if len(opts.Origins) == 0 {
+		opts.Origins = []string{DefaultOrigin}
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          opts.ID,
+		RPDisplayName: opts.Name,
+		RPOrigins:     opts.Origins,
+		// browsers replace most attestations with "none", self-attestation is kept
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Timeout: ddd.PasskeyCeremonyTTL, TimeoutUVD: ddd.PasskeyCeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Timeout: ddd.PasskeyCeremonyTTL, TimeoutUVD: ddd.PasskeyCeremonyTTL},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelyingParty{
+		repo:     opts.Repository,
+		webauthn: w,
+	}, nil
+}
+
+// user is an account as go-webauthn sees it
+type user struct {
+	email       string
+	handle      []byte
+	credentials []webauthn.Credential
+}
+
+func (u user) WebAuthnID() []byte                         { return u.handle }
+func (u user) WebAuthnName() string                       { return u.email }
+func (u user) WebAuthnDisplayName() string                { return u.email }
+func (u user) WebAuthnIcon() string                       { return "" }
+func (u user) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// BeginRegistration returns the ceremony token and the options for navigator.credentials.create()
+// the account's passkeys are excluded, an authenticator can't register twice
+func (rp *RelyingParty) BeginRegistration(
+	ctx context.Context,
+	email string,
+) (
+	string,
+	*protocol.PublicKeyCredentialCreationOptions,
+	error,
+) {
+	passkeys, err := rp.repo.ListPasskeys(ctx, email)
+	if err != nil {
+		return "", nil, err
+	}
+
+	handle, exclusions := []byte(nil), []protocol.CredentialDescriptor{}
+	for _, passkey := range passkeys {
+		handle = passkey.UserHandle
+		exclusions = append(exclusions, protocol.CredentialDescriptor{
+			Type:         protocol.PublicKeyCredentialType,
+			CredentialID: passkey.ID,
+		})
+	}
+
+	// the first passkey's handle is random, the next ones reuse it
+	if handle == nil {
+		handle = make([]byte, ddd.UserHandleSize)
+		if _, err := rand.Read(handle); err != nil {
+			return "", nil, err
+		}
+	}
+
+	creation, session, err := rp.webauthn.BeginRegistration(
+		user{email: email, handle: handle},
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := ddd.SignPasskeyCeremony(ddd.PasskeyCeremony{
+		Tenant:     ddd.TenantFrom(ctx),
+		Email:      email,
+		Challenge:  session.Challenge,
+		UserHandle: handle,
+	})
+
+	return token, &creation.Response, nil
+}
+
+// FinishRegistration verifies the response of navigator.credentials.create() and stores the passkey
+func (rp *RelyingParty) FinishRegistration(
+	ctx context.Context,
+	email string,
+	ceremony string,
+	name string,
+	credential []byte,
+) (
+	*ddd.Passkey,
+	error,
+) {
+	c := ddd.ParsePasskeyCeremony(ceremony)
+	if c == nil || c.Email != email || c.Tenant != ddd.TenantFrom(ctx) || c.UserHandle == nil {
+		return nil, ErrInvalidCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+	}
+
+	if err := checkAttestation(parsed.Response.AttestationObject); err != nil {
+		return nil, err
+	}
+
+	cred, err := rp.webauthn.CreateCredential(
+		user{email: email, handle: c.UserHandle},
+		webauthn.SessionData{
+			Challenge:        c.Challenge,
+			UserID:           c.UserHandle,
+			UserVerification: protocol.VerificationRequired,
+		},
+		parsed,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+	}
+
+	transports := []string{}
+	for _, transport := range cred.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return rp.repo.CreatePasskey(ctx, ddd.PasskeyCreate{
+		ID:              cred.ID,
+		Email:           email,
+		UserHandle:      c.UserHandle,
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  cred.Flags.BackupEligible,
+	})
+}
+
+// checkAttestation only accepts "none" and packed self-attestation, there are no attestation roots to check certificates against
+func checkAttestation(attestation protocol.AttestationObject) error {
+	switch attestation.Format {
+	case "none":
+		return nil
+	case "packed":
+		_, x5c := attestation.AttStatement["x5c"]
+		_, ecdaa := attestation.AttStatement["ecdaaKeyId"]
+		if !x5c && !ecdaa {
+			return nil
+		}
+	}
+
+	return ErrUnsupportedAttestation
+}
+
+// BeginLogin returns the ceremony token and the options for navigator.credentials.get()
+// no credentials are listed, the browser offers every passkey it has for the relying party
+func (rp *RelyingParty) BeginLogin(
+	ctx context.Context,
+) (
+	string,
+	*protocol.PublicKeyCredentialRequestOptions,
+	error,
+) {
+	assertion, session, err := rp.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := ddd.SignPasskeyCeremony(ddd.PasskeyCeremony{
+		Tenant:    ddd.TenantFrom(ctx),
+		Challenge: session.Challenge,
+	})
+
+	return token, &assertion.Response, nil
+}
+
+// Login is an accepted assertion, the tenant is the one the ceremony began in
+type Login struct {
+	Tenant  string
+	Passkey *ddd.Passkey
+}
+
+// FinishLogin verifies the response of navigator.credentials.get() and records the passkey's sign count
+// a sign count that didn't move forward returns ddd.ErrPasskeyCloned
+func (rp *RelyingParty) FinishLogin(
+	ctx context.Context,
+	ceremony string,
+	credential []byte,
+) (
+	*Login,
+	error,
+) {
+	c := ddd.ParsePasskeyCeremony(ceremony)
+	if c == nil || c.Email != "" {
+		return nil, ErrInvalidCeremony
+	}
+
+	ctx = ddd.WithTenant(ctx, c.Tenant)
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+	}
+
+	var (
+		passkey *ddd.Passkey
+		// lookupErr isn't lost in go-webauthn's error, a timed out repository isn't an invalid credential
+		lookupErr error
+	)
+
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		passkey, lookupErr = rp.repo.GetPasskey(ctx, rawID)
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+
+		if !bytes.Equal(passkey.UserHandle, userHandle) {
+			return nil, errors.New("user handle doesn't match the passkey's")
+		}
+
+		return user{
+			email:  passkey.Email,
+			handle: passkey.UserHandle,
+			credentials: []webauthn.Credential{{
+				ID:              passkey.ID,
+				PublicKey:       passkey.PublicKey,
+				AttestationType: passkey.AttestationType,
+				Authenticator: webauthn.Authenticator{
+					AAGUID:    passkey.AAGUID,
+					SignCount: passkey.SignCount,
+				},
+			}},
+		}, nil
+	}
+
+	cred, err := rp.webauthn.ValidateDiscoverableLogin(
+		handler,
+		webauthn.SessionData{
+			Challenge:        c.Challenge,
+			UserVerification: protocol.VerificationRequired,
+		},
+		parsed,
+	)
+	if lookupErr != nil && !errors.Is(lookupErr, ddd.ErrPasskeyNotFound) {
+		return nil, lookupErr
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+	}
+
+	if cred.Authenticator.CloneWarning {
+		return nil, ddd.ErrPasskeyCloned
+	}
+
+	// a ceremony is only good for one login, the challenge is kept for as long as its token is valid
+	err = rp.repo.UsePasskeyChallenge(ctx, c.Challenge, time.Now().Add(ddd.PasskeyCeremonyTTL))
+	if errors.Is(err, ddd.ErrPasskeyChallengeUsed) {
+		return nil, ErrInvalidCeremony
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.repo.UsePasskey(ctx, passkey.ID, cred.Authenticator.SignCount); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	passkey.SignCount = cred.Authenticator.SignCount
+	passkey.LastUsedAt = &now
+
+	return &Login{
+		Tenant:  c.Tenant,
+		Passkey: passkey,
+	}, nil
+}
+
+// List returns the account's passkeys ordered by creation
+func (rp *RelyingParty) List(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.Passkey,
+	error,
+) {
+	return rp.repo.ListPasskeys(ctx, email)
+}
+
+// Delete removes one of the account's passkeys, it returns ddd.ErrPasskeyNotFound when the account doesn't have it
+func (rp *RelyingParty) Delete(
+	ctx context.Context,
+	email string,
+	id []byte,
+) error {
+	return rp.repo.DeletePasskey(ctx, email, id)
+}
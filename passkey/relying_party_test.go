@@ -0,0 +1,273 @@
+package passkey
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+	"github.com/sabey/ddd/passkey/passkeytest"
+)
+
+const testOrigin = "https://ddd.example"
+
+func newTestRelyingParty(t *testing.T) (*RelyingParty, *mock.UserRepository) {
+	t.Helper()
+
+	repo := mock.NewUserRepository()
+	for _, email := range []string{"jackson@juandefu.ca", "admin@sabey.co"} {
+		if _, err := repo.Create(context.Background(), ddd.UserCreate{
+			Email:     email,
+			FirstName: "Jackson",
+			LastName:  "Sabey",
+			Password:  "pass",
+		}); err != nil {
+			t.Fatalf("failed to create user: %s", err)
+		}
+	}
+
+	rp, err := NewRelyingParty(RelyingPartyOpts{
+		Repository: repo,
+		ID:         "ddd.example",
+		Origins:    []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("failed to create relying party: %s", err)
+	}
+
+	return rp, repo
+}
+
+// register runs a registration ceremony with the authenticator
+func register(t *testing.T, rp *RelyingParty, a *passkeytest.Authenticator, email string) (*ddd.Passkey, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	ceremony, options, err := rp.BeginRegistration(ctx, email)
+	if err != nil {
+		t.Fatalf("failed to begin registration: %s", err)
+	}
+
+	credential, err := a.Create(*options)
+	if err != nil {
+		t.Fatalf("authenticator failed to create: %s", err)
+	}
+
+	return rp.FinishRegistration(ctx, email, ceremony, "Laptop", credential)
+}
+
+// login runs a login ceremony with the authenticator
+func login(t *testing.T, rp *RelyingParty, a *passkeytest.Authenticator) (*Login, error) {
+	t.Helper()
+
+	ceremony, options, err := rp.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin login: %s", err)
+	}
+
+	credential, err := a.Get(*options)
+	if err != nil {
+		t.Fatalf("authenticator failed to get: %s", err)
+	}
+
+	return rp.FinishLogin(context.Background(), ceremony, credential)
+}
+
+func TestRelyingParty(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		t.Run(format, func(t *testing.T) {
+			rp, _ := newTestRelyingParty(t)
+
+			a := passkeytest.NewAuthenticator(testOrigin)
+			a.Format = format
+			a.BackupEligible = true
+
+			passkey, err := register(t, rp, a, "jackson@juandefu.ca")
+			if err != nil {
+				t.Fatalf("failed to register: %s", err)
+			}
+
+			if passkey.AttestationType != format || passkey.Name != "Laptop" || !passkey.BackupEligible || len(passkey.UserHandle) != ddd.UserHandleSize {
+				t.Errorf("unknown passkey: %+v", passkey)
+			}
+
+			// a second passkey has the same user handle
+			second, err := register(t, rp, passkeytest.NewAuthenticator(testOrigin), "jackson@juandefu.ca")
+			if err != nil {
+				t.Fatalf("failed to register: %s", err)
+			}
+
+			if string(second.UserHandle) != string(passkey.UserHandle) {
+				t.Errorf("user handles differ")
+			}
+
+			l, err := login(t, rp, a)
+			if err != nil {
+				t.Fatalf("failed to login: %s", err)
+			}
+
+			if l.Tenant != ddd.DefaultOrganization || l.Passkey.Email != "jackson@juandefu.ca" || l.Passkey.SignCount != 1 {
+				t.Errorf("unknown login: %s %+v", l.Tenant, l.Passkey)
+			}
+
+			if passkeys, _ := rp.List(context.Background(), "jackson@juandefu.ca"); len(passkeys) != 2 || passkeys[0].LastUsedAt == nil {
+				t.Errorf("unknown passkeys: %v", passkeys)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_Registration(t *testing.T) {
+	rp, _ := newTestRelyingParty(t)
+	ctx := context.Background()
+
+	a := passkeytest.NewAuthenticator(testOrigin)
+	a.SkipUserVerification = true
+
+	if _, err := register(t, rp, a, "jackson@juandefu.ca"); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("an unverified user registered: %v", err)
+	}
+
+	a = passkeytest.NewAuthenticator("https://phishing.example")
+	if _, err := register(t, rp, a, "jackson@juandefu.ca"); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("another origin registered: %v", err)
+	}
+
+	a = passkeytest.NewAuthenticator(testOrigin)
+	a.Format = "tpm"
+	if _, err := register(t, rp, a, "jackson@juandefu.ca"); !errors.Is(err, ErrUnsupportedAttestation) {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	// a ceremony only finishes for the account that began it
+	ceremony, options, err := rp.BeginRegistration(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to begin registration: %s", err)
+	}
+
+	credential, err := passkeytest.NewAuthenticator(testOrigin).Create(*options)
+	if err != nil {
+		t.Fatalf("authenticator failed to create: %s", err)
+	}
+
+	if _, err := rp.FinishRegistration(ctx, "admin@sabey.co", ceremony, "", credential); err != ErrInvalidCeremony {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	if _, err := rp.FinishRegistration(ddd.WithTenant(ctx, "acme"), "jackson@juandefu.ca", ceremony, "", credential); err != ErrInvalidCeremony {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	if _, err := rp.FinishRegistration(ctx, "jackson@juandefu.ca", ddd.SignJWTClaims("jackson@juandefu.ca"), "", credential); err != ErrInvalidCeremony {
+		t.Errorf("unknown error: %v", err)
+	}
+
+	if _, err := rp.FinishRegistration(ctx, "jackson@juandefu.ca", ceremony, "", credential); err != nil {
+		t.Errorf("failed to register: %s", err)
+	}
+
+	// replaying the registration
+	if _, err := rp.FinishRegistration(ctx, "jackson@juandefu.ca", ceremony, "", credential); err != ddd.ErrPasskeyExists {
+		t.Errorf("unknown error: %v", err)
+	}
+}
+
+func TestRelyingParty_Login(t *testing.T) {
+	rp, repo := newTestRelyingParty(t)
+	ctx := context.Background()
+
+	a := passkeytest.NewAuthenticator(testOrigin)
+	if _, err := register(t, rp, a, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to register: %s", err)
+	}
+
+	// a ceremony is answered once
+	ceremony, options, err := rp.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("failed to begin login: %s", err)
+	}
+
+	for i, expected := range []error{nil, ErrInvalidCeremony} {
+		credential, err := a.Get(*options)
+		if err != nil {
+			t.Fatalf("authenticator failed to get: %s", err)
+		}
+
+		if _, err := rp.FinishLogin(ctx, ceremony, credential); err != expected {
+			t.Errorf("%d: unknown error: %v", i, err)
+		}
+	}
+
+	// the answered challenge is in the repository, another replica or a restart doesn't accept it again
+	replica, err := NewRelyingParty(RelyingPartyOpts{
+		Repository: repo,
+		ID:         "ddd.example",
+		Origins:    []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("failed to create relying party: %s", err)
+	}
+
+	credential, err := a.Get(*options)
+	if err != nil {
+		t.Fatalf("authenticator failed to get: %s", err)
+	}
+
+	if _, err := replica.FinishLogin(ctx, ceremony, credential); err != ErrInvalidCeremony {
+		t.Errorf("a replica accepted an answered ceremony: %v", err)
+	}
+
+	if calls := repo.Calls(mock.MethodUsePasskeyChallenge); len(calls) != 3 {
+		t.Errorf("expected three challenges, got: %v", calls)
+	}
+
+	// the copy's sign count falls behind
+	clone := a.Clone()
+
+	if _, err := login(t, rp, a); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	if _, err := login(t, rp, clone); err != ddd.ErrPasskeyCloned {
+		t.Errorf("a cloned passkey logged in: %v", err)
+	}
+
+	unverified := a.Clone()
+	unverified.SkipUserVerification = true
+
+	if _, err := login(t, rp, unverified); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("an unverified user logged in: %v", err)
+	}
+
+	// an unknown passkey
+	stranger := passkeytest.NewAuthenticator(testOrigin)
+	if _, err := register(t, rp, stranger, "admin@sabey.co"); err != nil {
+		t.Fatalf("failed to register: %s", err)
+	}
+
+	passkeys, _ := repo.ListPasskeys(ctx, "admin@sabey.co")
+	if err := rp.Delete(ctx, "admin@sabey.co", passkeys[0].ID); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	if _, err := login(t, rp, stranger); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("a deleted passkey logged in: %v", err)
+	}
+
+	// a registration's ceremony can't log in
+	registration, _, err := rp.BeginRegistration(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to begin registration: %s", err)
+	}
+
+	credential, err = a.Get(*options)
+	if err != nil {
+		t.Fatalf("authenticator failed to get: %s", err)
+	}
+
+	if _, err := rp.FinishLogin(ctx, registration, credential); err != ErrInvalidCeremony {
+		t.Errorf("unknown error: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package ddd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	// timezones are validated against the embedded database, not whatever the host has installed
+	_ "time/tzdata"
+)
+
+const maxDisplayName = 255
+
+func ValidateDisplayName(displayName string) error {
+	if !utf8.ValidString(displayName) {
+		return errors.New("displayName isn't valid utf-8")
+	}
+
+	if utf8.RuneCountInString(displayName) > maxDisplayName {
+		return fmt.Errorf("displayName is longer than %d characters", maxDisplayName)
+	}
+
+	for _, r := range displayName {
+		if unicode.IsControl(r) {
+			return errors.New("displayName contains a control character")
+		}
+	}
+
+	return nil
+}
+
+// language[-script][-region][-variant]*[-extension]*[-privateuse]
+var localeRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-([a-zA-Z]{2}|[0-9]{3}))?(-([a-zA-Z0-9]{5,8}|[0-9][a-zA-Z0-9]{3}))*(-[0-9a-wyzA-WYZ](-[a-zA-Z0-9]{2,8})+)*(-[xX](-[a-zA-Z0-9]{1,8})+)?$`)
+
+// ValidateLocale checks that locale is a well-formed BCP 47 language tag, `en`, `en-CA` or `zh-Hant-TW`
+func ValidateLocale(locale string) error {
+	if len(locale) > 35 || !localeRegexp.MatchString(locale) {
+		return fmt.Errorf("locale isn't a BCP 47 language tag: %s", locale)
+	}
+
+	return nil
+}
+
+// ValidateTimezone checks that timezone is an IANA time zone name, `America/Vancouver`
+func ValidateTimezone(timezone string) error {
+	// LoadLocation accepts these, they aren't zone names
+	if timezone == "" || timezone == "Local" {
+		return fmt.Errorf("timezone isn't an IANA time zone: %s", timezone)
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("timezone isn't an IANA time zone: %s", timezone)
+	}
+
+	return nil
+}
+
+// AttributeType is the JSON type of a custom attribute's value
+type AttributeType string
+
+const (
+	AttributeString  AttributeType = "string"
+	AttributeNumber  AttributeType = "number"
+	AttributeBoolean AttributeType = "boolean"
+)
+
+// Attributes is the schema every UserCreate and UserUpdate is validated against
+// applications register their keys on start, keys that aren't registered are rejected
+var Attributes = NewAttributeRegistry()
+
+var attributeKeyRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+
+const maxAttributeString = 1024
+
+func NewAttributeRegistry() *AttributeRegistry {
+	return &AttributeRegistry{
+		types: make(map[string]AttributeType),
+	}
+}
+
+// AttributeRegistry declares the custom attribute keys and their types, it's safe for concurrent use
+type AttributeRegistry struct {
+	mu    sync.RWMutex
+	types map[string]AttributeType
+}
+
+// Register declares key, registering a key twice with the same type is a no-op
+func (ar *AttributeRegistry) Register(key string, typ AttributeType) error {
+	if !attributeKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid attribute key: %s", key)
+	}
+
+	switch typ {
+	case AttributeString, AttributeNumber, AttributeBoolean:
+	default:
+		return fmt.Errorf("unknown attribute type: %s", typ)
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if registered, ok := ar.types[key]; ok && registered != typ {
+		return fmt.Errorf("attribute %s is already registered as a %s", key, registered)
+	}
+
+	ar.types[key] = typ
+
+	return nil
+}
+
+// Keys returns the registered keys and their types
+func (ar *AttributeRegistry) Keys() map[string]AttributeType {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	types := make(map[string]AttributeType, len(ar.types))
+	for key, typ := range ar.types {
+		types[key] = typ
+	}
+
+	return types
+}
+
+// Validate checks every key is registered and every value has the key's type
+// nil values are only valid in an update, where they remove the key
+func (ar *AttributeRegistry) Validate(attrs map[string]interface{}) error {
+	return ar.validate(attrs, false)
+}
+
+func (ar *AttributeRegistry) validate(attrs map[string]interface{}, allowNil bool) error {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	// the first invalid key is reported, the order must not depend on the map
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		typ, ok := ar.types[key]
+		if !ok {
+			return fmt.Errorf("unknown attribute: %s", key)
+		}
+
+		value := attrs[key]
+		if value == nil {
+			if allowNil {
+				continue
+			}
+
+			return fmt.Errorf("attribute %s was null", key)
+		}
+
+		if attributeType(value) != typ {
+			return fmt.Errorf("attribute %s must be a %s", key, typ)
+		}
+
+		if s, ok := value.(string); ok && utf8.RuneCountInString(s) > maxAttributeString {
+			return fmt.Errorf("attribute %s is longer than %d characters", key, maxAttributeString)
+		}
+	}
+
+	return nil
+}
+
+// attributeType is the JSON type of a decoded value, or "" when it isn't a supported scalar
+func attributeType(value interface{}) AttributeType {
+	switch value.(type) {
+	case string:
+		return AttributeString
+	case float64, float32, int, int32, int64:
+		return AttributeNumber
+	case bool:
+		return AttributeBoolean
+	}
+
+	return ""
+}
+
+// validateProfile validates the optional profile fields that are set
+func validateProfile(displayName, locale, timezone *string) error {
+	if displayName != nil {
+		if err := ValidateDisplayName(*displayName); err != nil {
+			return err
+		}
+	}
+
+	// an empty locale or timezone clears it
+	if locale != nil && *locale != "" {
+		if err := ValidateLocale(*locale); err != nil {
+			return err
+		}
+	}
+
+	if timezone != nil && *timezone != "" {
+		if err := ValidateTimezone(*timezone); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyAttributes is a shallow copy, values are scalars
+func copyAttributes(attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(attrs))
+	for key, value := range attrs {
+		c[key] = value
+	}
+
+	return c
+}
+
+// MergeAttributes applies an update's attributes to attrs, a nil value removes the key
+// attrs isn't modified, backends that store attributes in go use it to match postgres' `jsonb_strip_nulls(attributes || patch)`
+func MergeAttributes(attrs, patch map[string]interface{}) map[string]interface{} {
+	merged := copyAttributes(attrs)
+	if merged == nil {
+		merged = map[string]interface{}{}
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
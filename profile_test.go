@@ -0,0 +1,117 @@
+package ddd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLocale(t *testing.T) {
+	for _, locale := range []string{"en", "en-CA", "fr-CA", "zh-Hant-TW", "es-419", "de-CH-1996", "en-US-x-twain"} {
+		if err := ValidateLocale(locale); err != nil {
+			t.Errorf("%s: %s", locale, err)
+		}
+	}
+
+	for _, locale := range []string{"", "e", "english", "en_CA", "en-", "-CA", "en-CA-", "not a locale"} {
+		if err := ValidateLocale(locale); err == nil {
+			t.Errorf("%s: invalid locale was accepted", locale)
+		}
+	}
+}
+
+func TestValidateTimezone(t *testing.T) {
+	for _, timezone := range []string{"UTC", "America/Vancouver", "Europe/Paris", "Asia/Kolkata"} {
+		if err := ValidateTimezone(timezone); err != nil {
+			t.Errorf("%s: %s", timezone, err)
+		}
+	}
+
+	for _, timezone := range []string{"", "Local", "Mars/Olympus_Mons", "../etc/passwd"} {
+		if err := ValidateTimezone(timezone); err == nil {
+			t.Errorf("%s: invalid timezone was accepted", timezone)
+		}
+	}
+}
+
+func TestValidateDisplayName(t *testing.T) {
+	if err := ValidateDisplayName("Jackson 🦊"); err != nil {
+		t.Errorf("valid display name was rejected: %s", err)
+	}
+
+	for _, displayName := range []string{"Jack\nson", "\xff", strings.Repeat("j", maxDisplayName+1)} {
+		if err := ValidateDisplayName(displayName); err == nil {
+			t.Errorf("%q: invalid display name was accepted", displayName)
+		}
+	}
+}
+
+func TestAttributeRegistry(t *testing.T) {
+	ar := NewAttributeRegistry()
+
+	if err := ar.Register("team", AttributeString); err != nil {
+		t.Fatalf("failed to register: %s", err)
+	}
+
+	if err := ar.Register("seats", AttributeNumber); err != nil {
+		t.Fatalf("failed to register: %s", err)
+	}
+
+	// registering the same type again is fine, a different type isn't
+	if err := ar.Register("team", AttributeString); err != nil {
+		t.Errorf("registering twice failed: %s", err)
+	}
+
+	if err := ar.Register("team", AttributeBoolean); err == nil {
+		t.Errorf("re-registered with another type?")
+	}
+
+	if err := ar.Register("0team", AttributeString); err == nil {
+		t.Errorf("registered an invalid key?")
+	}
+
+	if err := ar.Register("colour", AttributeType("colour")); err == nil {
+		t.Errorf("registered an unknown type?")
+	}
+
+	if err := ar.Validate(map[string]interface{}{"team": "core", "seats": float64(3)}); err != nil {
+		t.Errorf("valid attributes were rejected: %s", err)
+	}
+
+	for _, tt := range []struct {
+		attrs    map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"unknown": "value"}, "unknown attribute: unknown"},
+		{map[string]interface{}{"team": 1}, "attribute team must be a string"},
+		{map[string]interface{}{"seats": "3"}, "attribute seats must be a number"},
+		{map[string]interface{}{"team": nil}, "attribute team was null"},
+		{map[string]interface{}{"team": []interface{}{"core"}}, "attribute team must be a string"},
+	} {
+		if err := ar.Validate(tt.attrs); err == nil || err.Error() != tt.expected {
+			t.Errorf("%v: expected `%s`, got: %v", tt.attrs, tt.expected, err)
+		}
+	}
+
+	// updates remove keys with nil
+	if err := ar.validate(map[string]interface{}{"team": nil}, true); err != nil {
+		t.Errorf("nil was rejected in an update: %s", err)
+	}
+}
+
+func TestMergeAttributes(t *testing.T) {
+	attrs := map[string]interface{}{"team": "core", "seats": float64(3)}
+
+	merged := MergeAttributes(attrs, map[string]interface{}{"team": nil, "newsletter": true})
+
+	if len(merged) != 2 || merged["seats"] != float64(3) || merged["newsletter"] != true {
+		t.Errorf("unknown merge: %v", merged)
+	}
+
+	if len(attrs) != 2 || attrs["team"] != "core" {
+		t.Errorf("attrs were modified: %v", attrs)
+	}
+
+	if merged := MergeAttributes(nil, nil); merged == nil || len(merged) != 0 {
+		t.Errorf("expected an empty map: %v", merged)
+	}
+}
@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/repo/models"
+)
+
+func (r *Repository) CreateAPIKey(
+	ctx context.Context,
+	opts ddd.APIKeyCreate,
+) (
+	*ddd.APIKey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := opts.NewAPIKey(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err = r.db.WithContext(ctx).Model(&models.ApiKey{
+		Id:           key.ID,
+		KeyHash:      ddd.HashToken(key.Key),
+		Organization: key.Organization,
+		Email:        key.Email,
+		Name:         key.Name,
+		Scopes:       strings.Join(key.Scopes, " "),
+		CreatedAt:    key.CreatedAt,
+		ExpiresAt:    key.ExpiresAt,
+	}).Insert()
+	if isForeignKeyViolation(err) {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return key, nil
+}
+
+func (r *Repository) GetAPIKey(
+	ctx context.Context,
+	key string,
+) (
+	*ddd.APIKey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	stored := &models.ApiKey{}
+
+	err := r.db.WithContext(ctx).Model(stored).
+		Where("id = ? AND key_hash = ?", ddd.APIKeyID(key), ddd.HashToken(key)).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrAPIKeyNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newAPIKey(stored), nil
+}
+
+func (r *Repository) ListAPIKeys(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.APIKey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	keys := []*models.ApiKey{}
+
+	err := r.db.WithContext(ctx).Model(&keys).
+		Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), email).
+		Order("created_at ASC", "id ASC").
+		Select()
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	list := make([]*ddd.APIKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, newAPIKey(key))
+	}
+
+	return list, nil
+}
+
+func (r *Repository) DeleteAPIKey(
+	ctx context.Context,
+	email string,
+	id string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.WithContext(ctx).Exec("DELETE FROM api_keys WHERE organization = ? AND email = ? AND id = ?;", ddd.TenantFrom(ctx), email, id)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ddd.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *Repository) TouchAPIKey(
+	ctx context.Context,
+	id string,
+	ip string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	res, err := r.db.WithContext(ctx).Exec("UPDATE api_keys SET last_used_at = now(), last_used_ip = ? WHERE id = ?;", ip, id)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ddd.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func newAPIKey(stored *models.ApiKey) *ddd.APIKey {
+	key := &ddd.APIKey{
+		ID:           stored.Id,
+		Organization: stored.Organization,
+		Email:        stored.Email,
+		Name:         stored.Name,
+		Scopes:       splitSpaces(stored.Scopes),
+		CreatedAt:    stored.CreatedAt.UTC(),
+		LastUsedIP:   stored.LastUsedIp,
+	}
+
+	if stored.ExpiresAt != nil {
+		expiresAt := stored.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+
+	if stored.LastUsedAt != nil {
+		lastUsedAt := stored.LastUsedAt.UTC()
+		key.LastUsedAt = &lastUsedAt
+	}
+
+	return key
+}
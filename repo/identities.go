@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/repo/models"
+)
+
+func (r *Repository) CreateIdentity(
+	ctx context.Context,
+	opts ddd.IdentityCreate,
+) (
+	*ddd.Identity,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	identity := &models.Identity{
+		Provider:     opts.Provider,
+		Subject:      opts.Subject,
+		Organization: opts.Organization,
+		Email:        opts.Email,
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	_, err := r.db.WithContext(ctx).Model(identity).Insert()
+	if isForeignKeyViolation(err) {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if e, ok := err.(pg.Error); ok && e.IntegrityViolation() {
+		return nil, ddd.ErrIdentityExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newIdentity(identity), nil
+}
+
+func (r *Repository) GetIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (
+	*ddd.Identity,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	identity := &models.Identity{}
+
+	err := r.db.WithContext(ctx).Model(identity).Where("provider = ? AND subject = ?", provider, subject).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrIdentityNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newIdentity(identity), nil
+}
+
+func (r *Repository) ListIdentities(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.Identity,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	identities := []*models.Identity{}
+
+	err := r.db.WithContext(ctx).Model(&identities).
+		Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), email).
+		Order("created_at ASC", "provider ASC", "subject ASC").
+		Select()
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	list := make([]*ddd.Identity, 0, len(identities))
+	for _, identity := range identities {
+		list = append(list, newIdentity(identity))
+	}
+
+	return list, nil
+}
+
+func newIdentity(identity *models.Identity) *ddd.Identity {
+	return &ddd.Identity{
+		Provider:     identity.Provider,
+		Subject:      identity.Subject,
+		Organization: identity.Organization,
+		Email:        identity.Email,
+		CreatedAt:    identity.CreatedAt.UTC(),
+	}
+}
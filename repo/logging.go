@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+type startKey struct{}
+
+// queryLogger logs every SQL statement at debug, and failed statements at warn
+// statements are logged unformatted so parameters (passwords, emails) never reach the log
+type queryLogger struct {
+	logger *slog.Logger
+}
+
+func (ql queryLogger) BeforeQuery(event *pg.QueryEvent) {
+	event.Data[startKey{}] = time.Now()
+}
+
+func (ql queryLogger) AfterQuery(event *pg.QueryEvent) {
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		query = ""
+	}
+
+	attrs := []slog.Attr{
+		slog.String("statement", query),
+	}
+
+	if start, ok := event.Data[startKey{}].(time.Time); ok {
+		attrs = append(attrs, slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000))
+	}
+
+	if event.Error != nil && event.Error != pg.ErrNoRows {
+		attrs = append(attrs, slog.String("error", event.Error.Error()))
+		ql.logger.LogAttrs(event.Ctx, slog.LevelWarn, "postgres query failed", attrs...)
+
+		return
+	}
+
+	ql.logger.LogAttrs(event.Ctx, slog.LevelDebug, "postgres query", attrs...)
+}
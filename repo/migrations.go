@@ -0,0 +1,233 @@
+package repo
+
+import (
+	"github.com/go-pg/pg"
+)
+
+// migrations are applied in order and recorded in schema_migrations, a released migration must never change
+// repo/sqlite keeps the same list in its own dialect, a migration has to be added to both
+var migrations = []string{
+	// 1
+	`CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		email VARCHAR(255) UNIQUE,
+		firstname VARCHAR(255),
+		lastname VARCHAR(255),
+		password VARCHAR(255)
+	);`,
+	// 2, every write is announced on UserChangesChannel when its transaction commits
+	`CREATE OR REPLACE FUNCTION ddd_notify_user_change() RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM pg_notify('ddd_user_changes', json_build_object('op', 'delete', 'email', OLD.email)::text);
+			RETURN OLD;
+		END IF;
+
+		PERFORM pg_notify('ddd_user_changes', json_build_object('op', lower(TG_OP), 'email', NEW.email)::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	CREATE TRIGGER users_notify_change
+		AFTER INSERT OR UPDATE OR DELETE ON users
+		FOR EACH ROW EXECUTE PROCEDURE ddd_notify_user_change();`,
+	// 3, optimistic concurrency, existing accounts start at 1 like new ones
+	`ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;`,
+	// 4
+	`ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;`,
+	// 5, profile fields
+	`ALTER TABLE users
+		ADD COLUMN display_name VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '',
+		ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '',
+		ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';`,
+	// 6, the hash of the avatar's images in the blob store
+	`ALTER TABLE users ADD COLUMN avatar VARCHAR(64) NOT NULL DEFAULT '';`,
+	// 7, disabled accounts can't log in
+	`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;`,
+	// 8, organizations, an email is unique within its organization and the existing accounts are the default organization's
+	// the notifications carry the organization, a cache keys accounts by it
+	`CREATE TABLE organizations (
+		id VARCHAR(63) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	INSERT INTO organizations (id, name) VALUES ('default', 'Default');
+
+	ALTER TABLE users
+		ADD COLUMN organization VARCHAR(63) NOT NULL DEFAULT 'default',
+		DROP CONSTRAINT users_email_key,
+		ADD CONSTRAINT users_organization_email_key UNIQUE (organization, email);
+
+	CREATE TABLE invitations (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		organization VARCHAR(63) NOT NULL REFERENCES organizations (id),
+		email VARCHAR(255) NOT NULL,
+		admin BOOLEAN NOT NULL DEFAULT FALSE,
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE OR REPLACE FUNCTION ddd_notify_user_change() RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM pg_notify('ddd_user_changes', json_build_object('op', 'delete', 'organization', OLD.organization, 'email', OLD.email)::text);
+			RETURN OLD;
+		END IF;
+
+		PERFORM pg_notify('ddd_user_changes', json_build_object('op', lower(TG_OP), 'organization', NEW.organization, 'email', NEW.email)::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`,
+	// 9, two-factor authentication, the secrets are encrypted before they reach the database
+	`CREATE TABLE user_totp (
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		secret BYTEA NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		last_step BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (organization, email),
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE TABLE recovery_codes (
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		code_hash VARCHAR(64) NOT NULL,
+		PRIMARY KEY (organization, email, code_hash),
+		FOREIGN KEY (organization, email) REFERENCES user_totp (organization, email) ON DELETE CASCADE
+	);`,
+	// 10, passkeys, a credential id is unique within its organization since assertions are looked up by it
+	`CREATE TABLE passkeys (
+		organization VARCHAR(63) NOT NULL,
+		id BYTEA NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		user_handle BYTEA NOT NULL,
+		name VARCHAR(64) NOT NULL DEFAULT '',
+		public_key BYTEA NOT NULL,
+		attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+		aaguid BYTEA,
+		sign_count BIGINT NOT NULL DEFAULT 0,
+		transports TEXT NOT NULL DEFAULT '',
+		backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_used_at TIMESTAMPTZ,
+		PRIMARY KEY (organization, id),
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX passkeys_account ON passkeys (organization, email);`,
+	// 11, oauth clients aren't an organization's, codes and tokens are stored hashed
+	// redirect uris and scopes are space separated, neither can have a space
+	`CREATE TABLE oauth_clients (
+		id VARCHAR(64) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		public BOOLEAN NOT NULL DEFAULT FALSE,
+		secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+		redirect_uris TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE oauth_codes (
+		code_hash VARCHAR(64) PRIMARY KEY,
+		client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		code_challenge VARCHAR(128) NOT NULL,
+		nonce TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMPTZ NOT NULL,
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX oauth_codes_client ON oauth_codes (client_id);
+	CREATE INDEX oauth_codes_account ON oauth_codes (organization, email);
+
+	CREATE TABLE oauth_tokens (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+		organization VARCHAR(63),
+		email VARCHAR(255),
+		scopes TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMPTZ NOT NULL,
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX oauth_tokens_client ON oauth_tokens (client_id);
+	CREATE INDEX oauth_tokens_account ON oauth_tokens (organization, email);`,
+	// 12, identities link the subjects of external identity providers to accounts, a login looks them up before it knows the organization
+	`CREATE TABLE identities (
+		provider VARCHAR(63) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (provider, subject),
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX identities_account ON identities (organization, email);`,
+	// 13, api keys are looked up by their public id, only the hash of the whole key is stored
+	`CREATE TABLE api_keys (
+		id VARCHAR(20) PRIMARY KEY,
+		key_hash VARCHAR(64) NOT NULL,
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at TIMESTAMPTZ,
+		last_used_at TIMESTAMPTZ,
+		last_used_ip VARCHAR(45) NOT NULL DEFAULT '',
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX api_keys_account ON api_keys (organization, email);`,
+	// 14, rejected two-factor codes lock an account for a while, a login's challenge is only checked once
+	`ALTER TABLE user_totp ADD COLUMN failures INTEGER NOT NULL DEFAULT 0, ADD COLUMN locked_until TIMESTAMPTZ;
+
+	CREATE TABLE mfa_challenges (
+		id VARCHAR(64) PRIMARY KEY,
+		expires_at TIMESTAMPTZ NOT NULL
+	);`,
+	// 15, a passkey login's challenge is only answered once
+	`CREATE TABLE passkey_challenges (
+		challenge VARCHAR(128) PRIMARY KEY,
+		expires_at TIMESTAMPTZ NOT NULL
+	);`,
+}
+
+func migrate(db *pg.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY
+	);`)
+	if err != nil {
+		return err
+	}
+
+	return db.RunInTransaction(func(tx *pg.Tx) error {
+		// replicas starting at the same time wait for each other
+		if _, err := tx.Exec("LOCK TABLE schema_migrations IN EXCLUSIVE MODE;"); err != nil {
+			return err
+		}
+
+		var version int
+		if _, err := tx.QueryOne(pg.Scan(&version), "SELECT COALESCE(MAX(version), 0) FROM schema_migrations;"); err != nil {
+			return err
+		}
+
+		for i := version; i < len(migrations); i++ {
+			if _, err := tx.Exec(migrations[i]); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?);", i+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
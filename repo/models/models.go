@@ -1,9 +1,109 @@
 package models
 
+import (
+	"time"
+)
+
 type User struct {
-	Id        int64
-	Email     string
-	Firstname string
-	Lastname  string
-	Password  string
+	Id           int64
+	Organization string
+	Email        string
+	Firstname    string
+	Lastname     string
+	Password     string
+	// the profile fields are NOT NULL, empty values have to be written as is
+	DisplayName string                 `sql:",notnull"`
+	Locale      string                 `sql:",notnull"`
+	Timezone    string                 `sql:",notnull"`
+	Attributes  map[string]interface{} `sql:",notnull"`
+	Avatar      string                 `sql:",notnull"`
+	Admin       bool                   `sql:",notnull"`
+	Disabled    bool                   `sql:",notnull"`
+	Version     int64
+}
+
+type Organization struct {
+	Id        string
+	Name      string
+	CreatedAt time.Time
+}
+
+type Invitation struct {
+	TokenHash    string `sql:",pk"`
+	Organization string
+	Email        string
+	Admin        bool `sql:",notnull"`
+	ExpiresAt    time.Time
+}
+
+type Passkey struct {
+	Organization    string `sql:",pk"`
+	Id              []byte `sql:",pk"`
+	Email           string
+	UserHandle      []byte
+	Name            string `sql:",notnull"`
+	PublicKey       []byte
+	AttestationType string `sql:",notnull"`
+	Aaguid          []byte
+	SignCount       uint32 `sql:",notnull"`
+	// Transports are comma separated, sqlite stores them the same way
+	Transports     string `sql:",notnull"`
+	BackupEligible bool   `sql:",notnull"`
+	CreatedAt      time.Time
+	LastUsedAt     *time.Time
+}
+
+// OauthClient's redirect uris and scopes are space separated, sqlite stores them the same way
+type OauthClient struct {
+	Id           string
+	Name         string
+	Public       bool   `sql:",notnull"`
+	SecretHash   string `sql:",notnull"`
+	RedirectUris string `sql:",notnull"`
+	Scopes       string `sql:",notnull"`
+	CreatedAt    time.Time
+}
+
+type OauthCode struct {
+	CodeHash      string `sql:",pk"`
+	ClientId      string
+	Organization  string
+	Email         string
+	RedirectUri   string
+	Scopes        string `sql:",notnull"`
+	CodeChallenge string
+	Nonce         string `sql:",notnull"`
+	ExpiresAt     time.Time
+}
+
+// OauthToken's organization and email are NULL for a client's own token
+type OauthToken struct {
+	TokenHash    string `sql:",pk"`
+	ClientId     string
+	Organization string
+	Email        string
+	Scopes       string `sql:",notnull"`
+	ExpiresAt    time.Time
+}
+
+type Identity struct {
+	Provider     string `sql:",pk"`
+	Subject      string `sql:",pk"`
+	Organization string
+	Email        string
+	CreatedAt    time.Time
+}
+
+// ApiKey's scopes are space separated, sqlite stores them the same way
+type ApiKey struct {
+	Id           string
+	KeyHash      string
+	Organization string
+	Email        string
+	Name         string
+	Scopes       string `sql:",notnull"`
+	CreatedAt    time.Time
+	ExpiresAt    *time.Time
+	LastUsedAt   *time.Time
+	LastUsedIp   string `sql:",notnull"`
 }
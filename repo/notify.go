@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd"
+)
+
+// UserChangesChannel is the postgres channel the users table trigger (migration 2) notifies on
+const UserChangesChannel = "ddd_user_changes"
+
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+	// ChangeReset is sent whenever the listener (re)connects, notifications may have been missed before it
+	// consumers should drop everything they hold, not just one user
+	ChangeReset = "reset"
+)
+
+// UserChange is a committed write to a user, made by any instance sharing the database
+type UserChange struct {
+	Op string `json:"op"`
+	// Organization is the account's tenant, the trigger of migrations before 8 didn't send it
+	Organization string `json:"organization"`
+	Email        string `json:"email"`
+}
+
+const (
+	// listenPing is how long the listener waits for a notification before it checks the connection
+	listenPing = 15 * time.Second
+	// listenBackoff doubles after every failed connection, up to listenMaxBackoff
+	listenBackoff    = 100 * time.Millisecond
+	listenMaxBackoff = 5 * time.Second
+)
+
+// Subscribe calls handler for every UserChange until the returned func is called or the repository is closed
+// handlers are called one at a time from the listener's goroutine, they have to return quickly
+// the listener is started by the first subscription, and reconnects on its own
+func (r *Repository) Subscribe(handler func(UserChange)) func() {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	id := r.nextHandler
+	r.nextHandler++
+	r.handlers[id] = handler
+
+	if !r.listening {
+		r.listening = true
+		r.listenDone.Add(1)
+		go r.listen()
+	}
+
+	return func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+
+		delete(r.handlers, id)
+	}
+}
+
+func (r *Repository) publish(change UserChange) {
+	r.subMu.Lock()
+	handlers := make([]func(UserChange), 0, len(r.handlers))
+	for _, handler := range r.handlers {
+		handlers = append(handlers, handler)
+	}
+	r.subMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(change)
+	}
+}
+
+// listen holds a dedicated LISTEN connection until the repository is closed
+func (r *Repository) listen() {
+	defer r.listenDone.Done()
+
+	backoff := listenBackoff
+
+	for {
+		ln := r.db.Listen()
+		if err := ln.Listen(UserChangesChannel); err != nil {
+			ln.Close()
+			r.logger.Warn("postgres listen failed", "channel", UserChangesChannel, "error", err)
+		} else {
+			// whatever was written while there was no connection is lost
+			r.publish(UserChange{Op: ChangeReset})
+			backoff = listenBackoff
+
+			err := r.receive(ln)
+			ln.Close()
+			if err == nil {
+				return
+			}
+
+			r.logger.Warn("postgres listener disconnected", "channel", UserChangesChannel, "error", err)
+		}
+
+		select {
+		case <-r.closed:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > listenMaxBackoff {
+			backoff = listenMaxBackoff
+		}
+	}
+}
+
+// receive publishes notifications until the connection fails, or returns nil once the repository is closed
+// a silent connection is pinged through the channel, a second silent period means it's dead
+func (r *Repository) receive(ln *pg.Listener) error {
+	// Close can't interrupt a blocked receive, closing the listener does
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-r.closed:
+			ln.Close()
+		case <-stop:
+		}
+	}()
+
+	pinged := false
+
+	for {
+		_, payload, err := ln.ReceiveTimeout(listenPing)
+
+		select {
+		case <-r.closed:
+			return nil
+		default:
+		}
+
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			if pinged {
+				return err
+			}
+
+			pinged = true
+			if _, err := r.db.Exec("SELECT pg_notify(?, '');", UserChangesChannel); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		pinged = false
+
+		// the ping's empty payload
+		if payload == "" {
+			continue
+		}
+
+		change, err := parseUserChange(payload)
+		if err != nil {
+			r.logger.Warn("unknown user change notification", "error", err)
+			continue
+		}
+
+		r.publish(change)
+	}
+}
+
+func parseUserChange(payload string) (UserChange, error) {
+	change := UserChange{}
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return change, err
+	}
+
+	if change.Organization == "" {
+		change.Organization = ddd.DefaultOrganization
+	}
+
+	return change, nil
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+func TestParseUserChange(t *testing.T) {
+	change, err := parseUserChange(`{"op":"update","email":"jackson@juandefu.ca"}`)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+
+	// the trigger before migration 8 didn't send the organization
+	if change.Op != ChangeUpdate || change.Organization != ddd.DefaultOrganization || change.Email != "jackson@juandefu.ca" {
+		t.Errorf("unknown change: %+v", change)
+	}
+
+	change, err = parseUserChange(`{"op":"delete","organization":"acme","email":"jackson@juandefu.ca"}`)
+	if err != nil || change.Organization != "acme" {
+		t.Errorf("unknown change: %+v %v", change, err)
+	}
+
+	if _, err := parseUserChange("not json"); err == nil {
+		t.Errorf("parsed an invalid payload?")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	writer, err := NewRepository(
+		repoOpts,
+	)
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %s", err)
+	}
+
+	defer writer.Close()
+
+	// another instance sharing the database
+	opts := repoOpts
+	opts.Drop = false
+
+	reader, err := NewRepository(
+		opts,
+	)
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %s", err)
+	}
+
+	defer reader.Close()
+
+	changes := make(chan UserChange, 10)
+	unsubscribe := reader.Subscribe(func(change UserChange) {
+		changes <- change
+	})
+	defer unsubscribe()
+
+	next := func() UserChange {
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification received")
+			return UserChange{}
+		}
+	}
+
+	// every connection starts with a reset
+	if change := next(); change.Op != ChangeReset {
+		t.Fatalf("expected a reset: %+v", change)
+	}
+
+	_, err = writer.Create(context.Background(), ddd.UserCreate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	if err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	_, err = writer.Update(context.Background(), ddd.UserUpdate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: ddd.String("JACKSON"),
+		LastName:  ddd.String("SABEY"),
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %s", err)
+	}
+
+	if err := writer.Delete(context.Background(), "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to delete user: %s", err)
+	}
+
+	for _, op := range []string{ChangeInsert, ChangeUpdate, ChangeDelete} {
+		if change := next(); change.Op != op || change.Organization != ddd.DefaultOrganization || change.Email != "jackson@juandefu.ca" {
+			t.Errorf("expected %s: %+v", op, change)
+		}
+	}
+}
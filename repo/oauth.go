@@ -0,0 +1,357 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/repo/models"
+)
+
+func (r *Repository) CreateOAuthClient(
+	ctx context.Context,
+	opts ddd.OAuthClientCreate,
+) (
+	*ddd.OAuthClient,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := opts.NewOAuthClient(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err = r.db.WithContext(ctx).Model(&models.OauthClient{
+		Id:           client.ID,
+		Name:         client.Name,
+		Public:       client.Public,
+		SecretHash:   client.SecretHash,
+		RedirectUris: strings.Join(client.RedirectURIs, " "),
+		Scopes:       strings.Join(client.Scopes, " "),
+		CreatedAt:    client.CreatedAt,
+	}).Insert()
+	if e, ok := err.(pg.Error); ok && e.IntegrityViolation() {
+		return nil, ddd.ErrOAuthClientExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return client, nil
+}
+
+func (r *Repository) GetOAuthClient(
+	ctx context.Context,
+	id string,
+) (
+	*ddd.OAuthClient,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	client := &models.OauthClient{}
+
+	err := r.db.WithContext(ctx).Model(client).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrOAuthClientNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newOAuthClient(client), nil
+}
+
+func (r *Repository) ListOAuthClients(
+	ctx context.Context,
+) (
+	[]*ddd.OAuthClient,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	clients := []*models.OauthClient{}
+
+	err := r.db.WithContext(ctx).Model(&clients).Order("created_at ASC", "id ASC").Select()
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	list := make([]*ddd.OAuthClient, 0, len(clients))
+	for _, client := range clients {
+		list = append(list, newOAuthClient(client))
+	}
+
+	return list, nil
+}
+
+func (r *Repository) DeleteOAuthClient(
+	ctx context.Context,
+	id string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	// the codes and tokens are deleted by ON DELETE CASCADE
+	res, err := r.db.WithContext(ctx).Exec("DELETE FROM oauth_clients WHERE id = ?;", id)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ddd.ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+// oauthReferenceError tells a missing client from a missing account after a foreign key violation
+func (r *Repository) oauthReferenceError(ctx context.Context, clientID string) error {
+	exists, err := r.db.WithContext(ctx).Model((*models.OauthClient)(nil)).Where("id = ?", clientID).Exists()
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if !exists {
+		return ddd.ErrOAuthClientNotFound
+	}
+
+	return ddd.ErrUserNotFound
+}
+
+func (r *Repository) CreateOAuthCode(
+	ctx context.Context,
+	opts ddd.OAuthCodeCreate,
+) (
+	*ddd.OAuthCode,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	code, err := opts.NewOAuthCode(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err = r.db.WithContext(ctx).Model(&models.OauthCode{
+		CodeHash:      ddd.HashToken(code.Code),
+		ClientId:      code.ClientID,
+		Organization:  code.Organization,
+		Email:         code.Email,
+		RedirectUri:   code.RedirectURI,
+		Scopes:        strings.Join(code.Scopes, " "),
+		CodeChallenge: code.CodeChallenge,
+		Nonce:         code.Nonce,
+		ExpiresAt:     code.ExpiresAt,
+	}).Insert()
+	if isForeignKeyViolation(err) {
+		return nil, r.oauthReferenceError(ctx, opts.ClientID)
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return code, nil
+}
+
+func (r *Repository) ConsumeOAuthCode(
+	ctx context.Context,
+	code string,
+) (
+	*ddd.OAuthCode,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	consumed := &models.OauthCode{}
+
+	// an expired code is deleted too, it can't be exchanged anymore
+	_, err := r.db.WithContext(ctx).QueryOne(consumed, "DELETE FROM oauth_codes WHERE code_hash = ? RETURNING *;", ddd.HashToken(code))
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrOAuthCodeNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	if !consumed.ExpiresAt.After(time.Now()) {
+		return nil, ddd.ErrOAuthCodeNotFound
+	}
+
+	return &ddd.OAuthCode{
+		ClientID:      consumed.ClientId,
+		Organization:  consumed.Organization,
+		Email:         consumed.Email,
+		RedirectURI:   consumed.RedirectUri,
+		Scopes:        splitSpaces(consumed.Scopes),
+		CodeChallenge: consumed.CodeChallenge,
+		Nonce:         consumed.Nonce,
+		ExpiresAt:     consumed.ExpiresAt.UTC(),
+	}, nil
+}
+
+func (r *Repository) CreateOAuthToken(
+	ctx context.Context,
+	opts ddd.OAuthTokenCreate,
+) (
+	*ddd.OAuthToken,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	token, err := opts.NewOAuthToken(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	// an empty organization and email are written as NULL
+	_, err = r.db.WithContext(ctx).Model(&models.OauthToken{
+		TokenHash:    ddd.HashToken(token.Token),
+		ClientId:     token.ClientID,
+		Organization: token.Organization,
+		Email:        token.Email,
+		Scopes:       strings.Join(token.Scopes, " "),
+		ExpiresAt:    token.ExpiresAt,
+	}).Insert()
+	if isForeignKeyViolation(err) {
+		return nil, r.oauthReferenceError(ctx, opts.ClientID)
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return token, nil
+}
+
+func (r *Repository) GetOAuthToken(
+	ctx context.Context,
+	token string,
+) (
+	*ddd.OAuthToken,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	stored := &models.OauthToken{}
+
+	err := r.db.WithContext(ctx).Model(stored).Where("token_hash = ? AND expires_at > ?", ddd.HashToken(token), time.Now()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrOAuthTokenNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return &ddd.OAuthToken{
+		ClientID:     stored.ClientId,
+		Organization: stored.Organization,
+		Email:        stored.Email,
+		Scopes:       splitSpaces(stored.Scopes),
+		ExpiresAt:    stored.ExpiresAt.UTC(),
+	}, nil
+}
+
+func (r *Repository) RevokeOAuthToken(
+	ctx context.Context,
+	clientID string,
+	token string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.WithContext(ctx).Exec("DELETE FROM oauth_tokens WHERE token_hash = ? AND client_id = ?;", ddd.HashToken(token), clientID)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ddd.ErrOAuthTokenNotFound
+	}
+
+	return nil
+}
+
+// splitSpaces undoes strings.Join(s, " "), an empty string is an empty list
+func splitSpaces(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(s, " ")
+}
+
+func newOAuthClient(client *models.OauthClient) *ddd.OAuthClient {
+	return &ddd.OAuthClient{
+		ID:           client.Id,
+		Name:         client.Name,
+		Public:       client.Public,
+		SecretHash:   client.SecretHash,
+		RedirectURIs: splitSpaces(client.RedirectUris),
+		Scopes:       splitSpaces(client.Scopes),
+		CreatedAt:    client.CreatedAt.UTC(),
+	}
+}
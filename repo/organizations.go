@@ -0,0 +1,199 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/repo/models"
+)
+
+func (r *Repository) CreateOrganization(
+	ctx context.Context,
+	opts ddd.OrganizationCreate,
+) (
+	*ddd.Organization,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	organization := &models.Organization{
+		Id:        opts.ID,
+		Name:      opts.Name,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	_, err := r.db.WithContext(ctx).Model(organization).Insert()
+	if e, ok := err.(pg.Error); ok && e.IntegrityViolation() {
+		return nil, ddd.ErrOrganizationExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newOrganization(organization), nil
+}
+
+func (r *Repository) GetOrganization(
+	ctx context.Context,
+	id string,
+) (
+	*ddd.Organization,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateOrganizationID(id); err != nil {
+		return nil, err
+	}
+
+	organization := &models.Organization{}
+
+	err := r.db.WithContext(ctx).Model(organization).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrOrganizationNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newOrganization(organization), nil
+}
+
+func (r *Repository) ListOrganizations(
+	ctx context.Context,
+) (
+	[]*ddd.Organization,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	organizations := []*models.Organization{}
+
+	err := r.db.WithContext(ctx).Model(&organizations).Order("id ASC").Select()
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	list := make([]*ddd.Organization, 0, len(organizations))
+	for _, organization := range organizations {
+		list = append(list, newOrganization(organization))
+	}
+
+	return list, nil
+}
+
+func newOrganization(organization *models.Organization) *ddd.Organization {
+	return &ddd.Organization{
+		ID:        organization.Id,
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt.UTC(),
+	}
+}
+
+func (r *Repository) CreateInvitation(
+	ctx context.Context,
+	opts ddd.InvitationCreate,
+) (
+	*ddd.Invitation,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	invitation, err := opts.NewInvitation(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// the select inserts nothing for an unknown organization
+	res, err := r.db.WithContext(ctx).Exec(
+		"INSERT INTO invitations (token_hash, organization, email, admin, expires_at) SELECT ?, id, ?, ?, ? FROM organizations WHERE id = ?;",
+		ddd.HashToken(invitation.Token), invitation.Email, invitation.Admin, invitation.ExpiresAt, invitation.Organization,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return nil, ddd.ErrOrganizationNotFound
+	}
+
+	return invitation, nil
+}
+
+// AcceptInvitation deletes the invitation and creates the account in one transaction, a failed create keeps the invitation
+func (r *Repository) AcceptInvitation(
+	ctx context.Context,
+	token string,
+	opts ddd.UserCreate,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	var user *models.User
+
+	err := r.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		invitation := &models.Invitation{}
+
+		_, err := tx.QueryOneContext(ctx, invitation,
+			"DELETE FROM invitations WHERE token_hash = ? AND email = ? AND expires_at > ? RETURNING organization, admin;",
+			ddd.HashToken(token), opts.Email, time.Now(),
+		)
+		if err == pg.ErrNoRows {
+			return ddd.ErrInvitationNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		opts.Admin = invitation.Admin
+		user = newUserModel(invitation.Organization, opts)
+
+		_, err = tx.ModelContext(ctx, user).Insert()
+		if e, ok := err.(pg.Error); ok && e.IntegrityViolation() {
+			return ddd.ErrUserExists
+		}
+
+		return err
+	})
+	if err == ddd.ErrInvitationNotFound || err == ddd.ErrUserExists {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newUser(user), nil
+}
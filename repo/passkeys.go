@@ -0,0 +1,260 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/repo/models"
+)
+
+func (r *Repository) CreatePasskey(
+	ctx context.Context,
+	opts ddd.PasskeyCreate,
+) (
+	*ddd.Passkey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	passkey := &models.Passkey{
+		Organization:    ddd.TenantFrom(ctx),
+		Id:              opts.ID,
+		Email:           opts.Email,
+		UserHandle:      opts.UserHandle,
+		Name:            opts.Name,
+		PublicKey:       opts.PublicKey,
+		AttestationType: opts.AttestationType,
+		Aaguid:          opts.AAGUID,
+		SignCount:       opts.SignCount,
+		Transports:      strings.Join(opts.Transports, ","),
+		BackupEligible:  opts.BackupEligible,
+		CreatedAt:       time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	_, err := r.db.WithContext(ctx).Model(passkey).Insert()
+	if isForeignKeyViolation(err) {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if e, ok := err.(pg.Error); ok && e.IntegrityViolation() {
+		return nil, ddd.ErrPasskeyExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newPasskey(passkey), nil
+}
+
+func (r *Repository) GetPasskey(
+	ctx context.Context,
+	id []byte,
+) (
+	*ddd.Passkey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	passkey := &models.Passkey{}
+
+	err := r.db.WithContext(ctx).Model(passkey).Where("organization = ? AND id = ?", ddd.TenantFrom(ctx), id).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrPasskeyNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newPasskey(passkey), nil
+}
+
+func (r *Repository) ListPasskeys(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.Passkey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	passkeys := []*models.Passkey{}
+
+	err := r.db.WithContext(ctx).Model(&passkeys).
+		Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), email).
+		Order("created_at ASC", "id ASC").
+		Select()
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	list := make([]*ddd.Passkey, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		list = append(list, newPasskey(passkey))
+	}
+
+	return list, nil
+}
+
+// UsePasskey only moves sign_count forward, two logins racing with the same assertion can't both succeed
+func (r *Repository) UsePasskey(
+	ctx context.Context,
+	id []byte,
+	signCount uint32,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	res, err := r.db.WithContext(ctx).Exec(
+		"UPDATE passkeys SET sign_count = ?0, last_used_at = now() WHERE organization = ?1 AND id = ?2 AND (sign_count < ?0 OR (sign_count = 0 AND ?0 = 0));",
+		signCount, tenant, id,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		var exists bool
+
+		_, err := r.db.WithContext(ctx).QueryOne(pg.Scan(&exists), "SELECT EXISTS (SELECT 1 FROM passkeys WHERE organization = ? AND id = ?);", tenant, id)
+		if err != nil {
+			return ctxError(ctx, err)
+		}
+
+		if !exists {
+			return ddd.ErrPasskeyNotFound
+		}
+
+		return ddd.ErrPasskeyCloned
+	}
+
+	return nil
+}
+
+// UsePasskeyChallenge deletes the expired challenges in the same transaction, the primary key rejects a used one
+func (r *Repository) UsePasskeyChallenge(
+	ctx context.Context,
+	challenge string,
+	expiresAt time.Time,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if challenge == "" {
+		return errors.New("challenge was empty")
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	err := r.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM passkey_challenges WHERE expires_at <= ?;", time.Now()); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, "INSERT INTO passkey_challenges (challenge, expires_at) VALUES (?, ?);", challenge, expiresAt)
+		if isUniqueViolation(err) {
+			return ddd.ErrPasskeyChallengeUsed
+		}
+
+		return err
+	})
+	if err == ddd.ErrPasskeyChallengeUsed {
+		return err
+	}
+
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeletePasskey(
+	ctx context.Context,
+	email string,
+	id []byte,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.WithContext(ctx).Exec("DELETE FROM passkeys WHERE organization = ? AND email = ? AND id = ?;", ddd.TenantFrom(ctx), email, id)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ddd.ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+func newPasskey(passkey *models.Passkey) *ddd.Passkey {
+	p := &ddd.Passkey{
+		ID:              passkey.Id,
+		Email:           passkey.Email,
+		UserHandle:      passkey.UserHandle,
+		Name:            passkey.Name,
+		PublicKey:       passkey.PublicKey,
+		AttestationType: passkey.AttestationType,
+		AAGUID:          passkey.Aaguid,
+		SignCount:       passkey.SignCount,
+		BackupEligible:  passkey.BackupEligible,
+		CreatedAt:       passkey.CreatedAt.UTC(),
+	}
+
+	if passkey.Transports != "" {
+		p.Transports = strings.Split(passkey.Transports, ",")
+	}
+
+	if passkey.LastUsedAt != nil {
+		lastUsedAt := passkey.LastUsedAt.UTC()
+		p.LastUsedAt = &lastUsedAt
+	}
+
+	return p
+}
@@ -1,11 +1,18 @@
 package repo
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/go-pg/pg"
 	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/logging"
 	"github.com/sabey/ddd/repo/models"
+	"github.com/sabey/ddd/tracing"
 )
 
 type RepositoryOpts struct {
@@ -14,6 +21,23 @@ type RepositoryOpts struct {
 	Password string
 	Database string
 	Drop     bool
+	// Tracer is optional, every SQL statement is traced when set
+	Tracer *tracing.Tracer
+	// Logger is optional, statements are logged at debug when set
+	Logger *slog.Logger
+	// Timeouts bound every query on top of the caller's context
+	Timeouts Timeouts
+}
+
+// Timeouts are per operation, zero means the operation only honors the caller's deadline
+type Timeouts struct {
+	Create time.Duration
+	Login  time.Duration
+	Get    time.Duration
+	List   time.Duration
+	Update time.Duration
+	Delete time.Duration
+	Import time.Duration
 }
 
 func NewRepository(
@@ -26,154 +50,509 @@ func NewRepository(
 		Database: opts.Database,
 	})
 
+	if opts.Tracer != nil {
+		db.AddQueryHook(queryTracer{tracer: opts.Tracer})
+	}
+
+	if opts.Logger != nil {
+		db.AddQueryHook(queryLogger{logger: opts.Logger})
+	}
+
 	if opts.Drop {
-		_, err := db.Exec("DROP TABLE users;")
+		_, err := db.Exec("DROP TABLE IF EXISTS passkey_challenges, mfa_challenges, api_keys, identities, oauth_tokens, oauth_codes, oauth_clients, passkeys, recovery_codes, user_totp, invitations, users, organizations, schema_migrations;")
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		email VARCHAR(255) UNIQUE,
-		firstname VARCHAR(255),
-		lastname VARCHAR(255),
-		password VARCHAR(255)
-	);`)
-	if err != nil {
+	if err := migrate(db); err != nil {
 		return nil, err
 	}
 
+	logger := opts.Logger
+	if logger == nil {
+		logger = logging.Discard()
+	}
+
 	return &Repository{
-		db: db,
+		db:       db,
+		timeouts: opts.Timeouts,
+		logger:   logger,
+		handlers: make(map[int]func(UserChange)),
+		closed:   make(chan struct{}),
 	}, nil
 }
 
 type Repository struct {
-	db *pg.DB
+	db       *pg.DB
+	timeouts Timeouts
+	logger   *slog.Logger
+
+	// Subscribe's handlers and listener
+	subMu       sync.Mutex
+	handlers    map[int]func(UserChange)
+	nextHandler int
+	listening   bool
+	listenDone  sync.WaitGroup
+	closeOnce   sync.Once
+	closed      chan struct{}
+}
+
+// withTimeout applies the operation's timeout to ctx
+func withTimeout(
+	ctx context.Context,
+	timeout time.Duration,
+) (
+	context.Context,
+	context.CancelFunc,
+) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ctxError prefers the context's error, go-pg reports a cancelled query as a postgres error
+func ctxError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
 }
 
 func (r *Repository) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+	r.listenDone.Wait()
+
 	return r.db.Close()
 }
 
 func (r *Repository) Create(
+	ctx context.Context,
 	opts ddd.UserCreate,
 ) (
 	*ddd.User,
 	error,
 ) {
-	if err := opts.Validate(); err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	user := &models.User{
-		Email:     opts.Email,
-		Firstname: opts.FirstName,
-		Lastname:  opts.LastName,
-		Password:  opts.Password,
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
 
-	_, err := r.db.Model(user).Insert()
-	if e, ok := err.(pg.Error); ok && e.IntegrityViolation() {
-		return nil, errors.New("user already exists")
+	user := newUserModel(ddd.TenantFrom(ctx), opts)
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err := r.db.WithContext(ctx).Model(user).Insert()
+	if isUniqueViolation(err) {
+		return nil, ddd.ErrUserExists
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, ctxError(ctx, err)
 	}
 
-	return &ddd.User{
-		Email:     user.Email,
-		FirstName: user.Firstname,
-		LastName:  user.Lastname,
-		Password:  user.Password,
-	}, nil
+	return newUser(user), nil
 }
 
 func (r *Repository) Login(
+	ctx context.Context,
 	opts ddd.UserLogin,
 ) (
 	*ddd.User,
 	error,
 ) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
 
 	user := &models.User{}
 
-	err := r.db.Model(user).Where("email = ?", opts.Email).Select()
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	err := r.db.WithContext(ctx).Model(user).Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), opts.Email).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrUserNotFound
+	}
+
 	if err != nil {
-		return nil, err
+		return nil, ctxError(ctx, err)
 	}
 
 	if user.Password != opts.Password {
-		return nil, errors.New("password is invalid")
+		return nil, ddd.ErrInvalidPassword
 	}
 
-	return &ddd.User{
-		Email:     user.Email,
-		FirstName: user.Firstname,
-		LastName:  user.Lastname,
-		Password:  user.Password,
-	}, nil
+	if user.Disabled {
+		return nil, ddd.ErrUserDisabled
+	}
+
+	return newUser(user), nil
 }
 
-func (r *Repository) List() (
+func (r *Repository) Get(
+	ctx context.Context,
+	email string,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	user := &models.User{}
+
+	err := r.db.WithContext(ctx).Model(user).Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), email).Select()
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newUser(user), nil
+}
+
+func (r *Repository) List(
+	ctx context.Context,
+	opts ddd.UserList,
+) (
 	[]*ddd.User,
 	error,
 ) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
 	users := &[]*models.User{}
 
-	err := r.db.Model(&models.User{}).Order("email ASC").Select(users)
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	// keyset pagination, the unique (organization, email) index serves every page without an OFFSET scan
+	q := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("organization = ?", ddd.TenantFrom(ctx)).
+		Order("email ASC")
+	if opts.After != "" {
+		q = q.Where("email > ?", opts.After)
+	}
+	if len(opts.Emails) > 0 {
+		q = q.WhereIn("email IN (?)", opts.Emails)
+	}
+	if opts.Search != "" {
+		q = q.Where("(email ILIKE ?0 OR firstname ILIKE ?0 OR lastname ILIKE ?0 OR display_name ILIKE ?0)", opts.SearchPattern())
+	}
+	if opts.Admin != nil {
+		q = q.Where("admin = ?", *opts.Admin)
+	}
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit)
+	}
+
+	err := q.Select(users)
 	if err != nil {
-		return nil, err
+		return nil, ctxError(ctx, err)
 	}
 
 	return newUserList(*users), nil
 }
 
+// newUserModel is a validated account to insert into the tenant's organization
+func newUserModel(tenant string, opts ddd.UserCreate) *models.User {
+	return &models.User{
+		Organization: tenant,
+		Email:        opts.Email,
+		Firstname:    opts.FirstName,
+		Lastname:     opts.LastName,
+		Password:     opts.Password,
+		DisplayName:  opts.DisplayName,
+		Locale:       opts.Locale,
+		Timezone:     opts.Timezone,
+		// written as `{}`, never as json null
+		Attributes: ddd.MergeAttributes(nil, opts.Attributes),
+		Admin:      opts.Admin,
+		Version:    1,
+	}
+}
+
+func newUser(user *models.User) *ddd.User {
+	return &ddd.User{
+		Organization: user.Organization,
+		Email:        user.Email,
+		FirstName:    user.Firstname,
+		LastName:     user.Lastname,
+		Password:     user.Password,
+		DisplayName:  user.DisplayName,
+		Locale:       user.Locale,
+		Timezone:     user.Timezone,
+		Attributes:   ddd.MergeAttributes(nil, user.Attributes),
+		Avatar:       user.Avatar,
+		Admin:        user.Admin,
+		Disabled:     user.Disabled,
+		Version:      user.Version,
+	}
+}
+
 func newUserList(users []*models.User) []*ddd.User {
 	ur := []*ddd.User{}
 
 	for _, user := range users {
-		ur = append(ur, &ddd.User{
-			Email:     user.Email,
-			FirstName: user.Firstname,
-			LastName:  user.Lastname,
-			Password:  user.Password,
-		})
+		ur = append(ur, newUser(user))
 	}
 
 	return ur
 }
 
 func (r *Repository) Update(
+	ctx context.Context,
 	opts ddd.UserUpdate,
 ) (
 	*ddd.User,
 	error,
 ) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
 
 	user := &models.User{}
 
-	_, err := r.db.Model(user).
-		Set("firstname = ?", opts.FirstName).
-		Set("lastname = ?", opts.LastName).
-		Where("email = ?", opts.Email).Returning("*").
-		Update()
+	ctx, cancel := withTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	q := r.db.WithContext(ctx).Model(user).
+		Set("version = version + 1").
+		Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), opts.Email)
+	if opts.FirstName != nil {
+		q = q.Set("firstname = ?", *opts.FirstName)
+	}
+	if opts.LastName != nil {
+		q = q.Set("lastname = ?", *opts.LastName)
+	}
+	if opts.DisplayName != nil {
+		q = q.Set("display_name = ?", *opts.DisplayName)
+	}
+	if opts.Locale != nil {
+		q = q.Set("locale = ?", *opts.Locale)
+	}
+	if opts.Timezone != nil {
+		q = q.Set("timezone = ?", *opts.Timezone)
+	}
+	if opts.Avatar != nil {
+		q = q.Set("avatar = ?", *opts.Avatar)
+	}
+	if opts.Password != nil {
+		q = q.Set("password = ?", *opts.Password)
+	}
+	if opts.Disabled != nil {
+		q = q.Set("disabled = ?", *opts.Disabled)
+	}
+	if len(opts.Attributes) > 0 {
+		patch, err := json.Marshal(opts.Attributes)
+		if err != nil {
+			return nil, err
+		}
+
+		// merged in the statement, a concurrent update of other keys isn't lost
+		q = q.Set("attributes = jsonb_strip_nulls(attributes || ?::jsonb)", string(patch))
+	}
+	if opts.Version > 0 {
+		// the check and the write are one statement, a concurrent update can't slip in between
+		q = q.Where("version = ?", opts.Version)
+	}
+
+	_, err := q.Returning("*").Update()
+	if err == pg.ErrNoRows && opts.Version > 0 {
+		exists, err := r.db.WithContext(ctx).Model(&models.User{}).Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), opts.Email).Exists()
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		if exists {
+			return nil, ddd.ErrVersionMismatch
+		}
+	}
+
+	if err == pg.ErrNoRows {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return newUser(user), nil
+}
+
+func (r *Repository) Delete(
+	ctx context.Context,
+	email string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.WithContext(ctx).Model(&models.User{}).Where("organization = ? AND email = ?", ddd.TenantFrom(ctx), email).Delete()
 	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ddd.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// importedUser is a row returned by Import's insert
+type importedUser struct {
+	Email string
+	// Inserted is false for a row the upsert updated, postgres sets xmax for an updated row
+	Inserted bool
+}
+
+// Import is one multi-row insert, in a transaction that a dry run rolls back
+func (r *Repository) Import(
+	ctx context.Context,
+	opts ddd.UserImport,
+) (
+	[]ddd.ImportResult,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	return &ddd.User{
-		Email:     user.Email,
-		FirstName: user.Firstname,
-		LastName:  user.Lastname,
-		Password:  user.Password,
-	}, nil
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	results := opts.Results()
+	tenant := ddd.TenantFrom(ctx)
+
+	rows := []interface{}{}
+	for i, user := range opts.Users {
+		if results[i].Status != "" {
+			continue
+		}
+
+		attributes, err := json.Marshal(ddd.MergeAttributes(nil, user.Attributes))
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, []interface{}{
+			tenant, user.Email, user.FirstName, user.LastName, user.Password,
+			user.DisplayName, user.Locale, user.Timezone, string(attributes), user.Admin, 1,
+		})
+	}
+
+	if len(rows) == 0 {
+		return results, nil
+	}
+
+	conflict := "DO NOTHING"
+	if opts.Mode == ddd.ImportUpsert {
+		conflict = `DO UPDATE SET
+			firstname = EXCLUDED.firstname,
+			lastname = EXCLUDED.lastname,
+			password = EXCLUDED.password,
+			display_name = EXCLUDED.display_name,
+			locale = EXCLUDED.locale,
+			timezone = EXCLUDED.timezone,
+			attributes = EXCLUDED.attributes,
+			version = users.version + 1`
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Import)
+	defer cancel()
+
+	imported := []importedUser{}
+
+	err := r.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.QueryContext(ctx, &imported,
+			`INSERT INTO users (organization, email, firstname, lastname, password, display_name, locale, timezone, attributes, admin, version)
+			VALUES ?
+			ON CONFLICT (organization, email) `+conflict+`
+			RETURNING email, xmax = 0 AS inserted;`,
+			pg.InMulti(rows...),
+		)
+		if err != nil {
+			return err
+		}
+
+		if opts.DryRun {
+			return errDryRun
+		}
+
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, ctxError(ctx, err)
+	}
+
+	statuses := make(map[string]ddd.ImportStatus, len(imported))
+	for _, user := range imported {
+		statuses[user.Email] = ddd.ImportUpdated
+		if user.Inserted {
+			statuses[user.Email] = ddd.ImportCreated
+		}
+	}
+
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+
+		// DO NOTHING doesn't return the accounts that already exist
+		status, ok := statuses[results[i].Email]
+		if !ok {
+			results[i] = opts.Conflict(results[i].Email)
+
+			continue
+		}
+
+		results[i].Status = status
+	}
+
+	return results, nil
 }
+
+// errDryRun rolls back a dry run's transaction
+var errDryRun = errors.New("dry run")
@@ -1,9 +1,12 @@
 package repo
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/conformance"
 )
 
 var (
@@ -37,7 +40,7 @@ func TestCreate(t *testing.T) {
 
 	defer repo.Close()
 
-	user, err := repo.Create(ddd.UserCreate{
+	user, err := repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
@@ -62,7 +65,7 @@ func TestCreate_AlreadyExists(t *testing.T) {
 
 	defer repo.Close()
 
-	user, err := repo.Create(ddd.UserCreate{
+	user, err := repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@sabey.co",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
@@ -76,14 +79,14 @@ func TestCreate_AlreadyExists(t *testing.T) {
 		t.Errorf("unknown user found: %s", user.Email)
 	}
 
-	_, err = repo.Create(ddd.UserCreate{
+	_, err = repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@sabey.co",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
 		Password:  "pass",
 	})
-	if err == nil {
-		t.Errorf("created a duplicate user?")
+	if err != ddd.ErrUserExists {
+		t.Errorf("expected ErrUserExists, got: %v", err)
 	}
 }
 
@@ -98,6 +101,7 @@ func TestLogin_NotFound(t *testing.T) {
 	defer repo.Close()
 
 	_, err = repo.Login(
+		context.Background(),
 		ddd.UserLogin{
 			Email:    "jackson+notfound@juandefu.ca",
 			Password: "pass",
@@ -118,7 +122,7 @@ func TestLogin(t *testing.T) {
 
 	defer repo.Close()
 
-	_, err = repo.Create(ddd.UserCreate{
+	_, err = repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
@@ -129,6 +133,7 @@ func TestLogin(t *testing.T) {
 	}
 
 	user, err := repo.Login(
+		context.Background(),
 		ddd.UserLogin{
 			Email:    "jackson@juandefu.ca",
 			Password: "pass",
@@ -153,7 +158,7 @@ func TestList(t *testing.T) {
 
 	defer repo.Close()
 
-	_, err = repo.Create(ddd.UserCreate{
+	_, err = repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
@@ -163,7 +168,7 @@ func TestList(t *testing.T) {
 		t.Errorf("failed to create user: %s", err)
 	}
 
-	_, err = repo.Create(ddd.UserCreate{
+	_, err = repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@sabey.co",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
@@ -173,7 +178,7 @@ func TestList(t *testing.T) {
 		t.Errorf("failed to create user: %s", err)
 	}
 
-	users, err := repo.List()
+	users, err := repo.List(context.Background(), ddd.UserList{})
 	if err != nil {
 		t.Errorf("failed to login: %s", err)
 	}
@@ -201,7 +206,7 @@ func TestUpdate(t *testing.T) {
 
 	defer repo.Close()
 
-	_, err = repo.Create(ddd.UserCreate{
+	_, err = repo.Create(context.Background(), ddd.UserCreate{
 		Email:     "jackson@juandefu.ca",
 		FirstName: "Jackson",
 		LastName:  "Sabey",
@@ -212,10 +217,11 @@ func TestUpdate(t *testing.T) {
 	}
 
 	user, err := repo.Update(
+		context.Background(),
 		ddd.UserUpdate{
 			Email:     "jackson@juandefu.ca",
-			FirstName: "JACKSON",
-			LastName:  "SABEY",
+			FirstName: ddd.String("JACKSON"),
+			LastName:  ddd.String("SABEY"),
 		},
 	)
 	if err != nil {
@@ -230,3 +236,169 @@ func TestUpdate(t *testing.T) {
 		t.Errorf("lastname not updated: %s", user.LastName)
 	}
 }
+
+func TestList_Cancelled(t *testing.T) {
+	repo, err := NewRepository(
+		repoOpts,
+	)
+	if err != nil {
+		t.Errorf("failed to connect to postgres: %s", err)
+	}
+
+	defer repo.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = repo.List(ctx, ddd.UserList{})
+	if err != context.Canceled {
+		t.Errorf("list wasn't cancelled: %v", err)
+	}
+}
+
+func TestLogin_Timeout(t *testing.T) {
+	opts := repoOpts
+	opts.Drop = false
+	opts.Timeouts = Timeouts{
+		Login: time.Nanosecond,
+	}
+
+	repo, err := NewRepository(
+		opts,
+	)
+	if err != nil {
+		t.Errorf("failed to connect to postgres: %s", err)
+	}
+
+	defer repo.Close()
+
+	_, err = repo.Login(
+		context.Background(),
+		ddd.UserLogin{
+			Email:    "jackson@juandefu.ca",
+			Password: "pass",
+		},
+	)
+	if err != context.DeadlineExceeded {
+		t.Errorf("login didn't time out: %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	conformance.RunUserRepository(t, func(t *testing.T) ddd.UserRepository {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo
+	})
+}
+
+func TestOrganizationConformance(t *testing.T) {
+	conformance.RunOrganizationRepository(t, func(t *testing.T) (ddd.OrganizationRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestTOTPConformance(t *testing.T) {
+	conformance.RunTOTPRepository(t, func(t *testing.T) (ddd.TOTPRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestPasskeyConformance(t *testing.T) {
+	conformance.RunPasskeyRepository(t, func(t *testing.T) (ddd.PasskeyRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestOAuthConformance(t *testing.T) {
+	conformance.RunOAuthRepository(t, func(t *testing.T) (ddd.OAuthRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestIdentityConformance(t *testing.T) {
+	conformance.RunIdentityRepository(t, func(t *testing.T) (ddd.IdentityRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestAPIKeyConformance(t *testing.T) {
+	conformance.RunAPIKeyRepository(t, func(t *testing.T) (ddd.APIKeyRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			repoOpts,
+		)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
@@ -0,0 +1,221 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// apiKeyColumns are selected by every query, in the order scanAPIKey scans them
+const apiKeyColumns = "id, organization, email, name, scopes, created_at, expires_at, last_used_at, last_used_ip"
+
+func scanAPIKey(row scanner) (*ddd.APIKey, error) {
+	key := &ddd.APIKey{}
+
+	var (
+		scopes     string
+		createdAt  int64
+		expiresAt  sql.NullInt64
+		lastUsedAt sql.NullInt64
+	)
+
+	if err := row.Scan(&key.ID, &key.Organization, &key.Email, &key.Name, &scopes, &createdAt, &expiresAt, &lastUsedAt, &key.LastUsedIP); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = splitSpaces(scopes)
+	key.CreatedAt = time.UnixMicro(createdAt).UTC()
+
+	if expiresAt.Valid {
+		t := time.UnixMicro(expiresAt.Int64).UTC()
+		key.ExpiresAt = &t
+	}
+
+	if lastUsedAt.Valid {
+		t := time.UnixMicro(lastUsedAt.Int64).UTC()
+		key.LastUsedAt = &t
+	}
+
+	return key, nil
+}
+
+func (r *Repository) CreateAPIKey(
+	ctx context.Context,
+	opts ddd.APIKeyCreate,
+) (
+	*ddd.APIKey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := opts.NewAPIKey(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	var expiresAt sql.NullInt64
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullInt64{Int64: key.ExpiresAt.UnixMicro(), Valid: true}
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		"INSERT INTO api_keys (id, key_hash, organization, email, name, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+		key.ID, ddd.HashToken(key.Key), key.Organization, key.Email, key.Name, strings.Join(key.Scopes, " "), key.CreatedAt.UnixMicro(), expiresAt,
+	)
+	if isForeignKeyViolation(err) {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return key, nil
+}
+
+func (r *Repository) GetAPIKey(
+	ctx context.Context,
+	key string,
+) (
+	*ddd.APIKey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	got, err := scanAPIKey(r.db.QueryRowContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ? AND key_hash = ? AND (expires_at IS NULL OR expires_at > ?);",
+		ddd.APIKeyID(key), ddd.HashToken(key), time.Now().UnixMicro(),
+	))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrAPIKeyNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return got, nil
+}
+
+func (r *Repository) ListAPIKeys(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.APIKey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE organization = ? AND email = ? ORDER BY created_at ASC, rowid ASC;",
+		ddd.TenantFrom(ctx), email,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer rows.Close()
+
+	keys := []*ddd.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+func (r *Repository) DeleteAPIKey(
+	ctx context.Context,
+	email string,
+	id string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"DELETE FROM api_keys WHERE id = ? AND organization = ? AND email = ?;",
+		id, ddd.TenantFrom(ctx), email,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *Repository) TouchAPIKey(
+	ctx context.Context,
+	id string,
+	ip string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET last_used_at = ?, last_used_ip = ? WHERE id = ?;",
+		time.Now().UnixMicro(), ip, id,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// identityColumns are selected by every query, in the order scanIdentity scans them
+const identityColumns = "provider, subject, organization, email, created_at"
+
+func scanIdentity(row scanner) (*ddd.Identity, error) {
+	identity := &ddd.Identity{}
+
+	var createdAt int64
+
+	if err := row.Scan(&identity.Provider, &identity.Subject, &identity.Organization, &identity.Email, &createdAt); err != nil {
+		return nil, err
+	}
+
+	identity.CreatedAt = time.UnixMicro(createdAt).UTC()
+
+	return identity, nil
+}
+
+func (r *Repository) CreateIdentity(
+	ctx context.Context,
+	opts ddd.IdentityCreate,
+) (
+	*ddd.Identity,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO identities (provider, subject, organization, email, created_at) VALUES (?, ?, ?, ?, ?);",
+		opts.Provider, opts.Subject, opts.Organization, opts.Email, createdAt.UnixMicro(),
+	)
+	if isUniqueViolation(err) {
+		return nil, ddd.ErrIdentityExists
+	}
+
+	if isForeignKeyViolation(err) {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return &ddd.Identity{
+		Provider:     opts.Provider,
+		Subject:      opts.Subject,
+		Organization: opts.Organization,
+		Email:        opts.Email,
+		CreatedAt:    createdAt,
+	}, nil
+}
+
+func (r *Repository) GetIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (
+	*ddd.Identity,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	identity, err := scanIdentity(r.db.QueryRowContext(ctx,
+		"SELECT "+identityColumns+" FROM identities WHERE provider = ? AND subject = ?;",
+		provider, subject,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrIdentityNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return identity, nil
+}
+
+func (r *Repository) ListIdentities(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.Identity,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+identityColumns+" FROM identities WHERE organization = ? AND email = ? ORDER BY created_at ASC, rowid ASC;",
+		ddd.TenantFrom(ctx), email,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer rows.Close()
+
+	identities := []*ddd.Identity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return identities, nil
+}
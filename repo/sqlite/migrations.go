@@ -0,0 +1,226 @@
+package sqlite
+
+import (
+	"database/sql"
+)
+
+// migrations mirror repo's postgres migrations version for version, only the dialect differs
+var migrations = []string{
+	// 1
+	`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email VARCHAR(255) UNIQUE,
+		firstname VARCHAR(255),
+		lastname VARCHAR(255),
+		password VARCHAR(255)
+	);`,
+	// 2, postgres' change notification trigger, sqlite has no LISTEN/NOTIFY
+	`SELECT 1;`,
+	// 3
+	`ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+	// 4
+	`ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;`,
+	// 5, attributes are JSON text
+	`ALTER TABLE users ADD COLUMN display_name VARCHAR(255) NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}';`,
+	// 6
+	`ALTER TABLE users ADD COLUMN avatar VARCHAR(64) NOT NULL DEFAULT '';`,
+	// 7
+	`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;`,
+	// 8, sqlite can't drop a unique constraint, the users table is rebuilt with the email unique per organization
+	// times are unix microseconds
+	`CREATE TABLE organizations (
+		id VARCHAR(63) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	INSERT INTO organizations (id, name, created_at) VALUES ('default', 'Default', CAST(strftime('%s', 'now') AS INTEGER) * 1000000);
+
+	CREATE TABLE users_by_organization (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		organization VARCHAR(63) NOT NULL DEFAULT 'default',
+		email VARCHAR(255),
+		firstname VARCHAR(255),
+		lastname VARCHAR(255),
+		password VARCHAR(255),
+		version INTEGER NOT NULL DEFAULT 1,
+		admin BOOLEAN NOT NULL DEFAULT FALSE,
+		display_name VARCHAR(255) NOT NULL DEFAULT '',
+		locale VARCHAR(35) NOT NULL DEFAULT '',
+		timezone VARCHAR(64) NOT NULL DEFAULT '',
+		attributes TEXT NOT NULL DEFAULT '{}',
+		avatar VARCHAR(64) NOT NULL DEFAULT '',
+		disabled BOOLEAN NOT NULL DEFAULT FALSE,
+		UNIQUE (organization, email)
+	);
+
+	INSERT INTO users_by_organization (id, email, firstname, lastname, password, version, admin, display_name, locale, timezone, attributes, avatar, disabled)
+		SELECT id, email, firstname, lastname, password, version, admin, display_name, locale, timezone, attributes, avatar, disabled FROM users;
+
+	DROP TABLE users;
+
+	ALTER TABLE users_by_organization RENAME TO users;
+
+	CREATE TABLE invitations (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		organization VARCHAR(63) NOT NULL REFERENCES organizations (id),
+		email VARCHAR(255) NOT NULL,
+		admin BOOLEAN NOT NULL DEFAULT FALSE,
+		expires_at INTEGER NOT NULL
+	);`,
+	// 9
+	`CREATE TABLE user_totp (
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		secret BLOB NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (organization, email),
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE TABLE recovery_codes (
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		code_hash VARCHAR(64) NOT NULL,
+		PRIMARY KEY (organization, email, code_hash),
+		FOREIGN KEY (organization, email) REFERENCES user_totp (organization, email) ON DELETE CASCADE
+	);`,
+	// 10, passkeys, a credential id is unique within its organization since assertions are looked up by it
+	`CREATE TABLE passkeys (
+		organization VARCHAR(63) NOT NULL,
+		id BLOB NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		user_handle BLOB NOT NULL,
+		name VARCHAR(64) NOT NULL DEFAULT '',
+		public_key BLOB NOT NULL,
+		attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+		aaguid BLOB,
+		sign_count INTEGER NOT NULL DEFAULT 0,
+		transports TEXT NOT NULL DEFAULT '',
+		backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		PRIMARY KEY (organization, id),
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX passkeys_account ON passkeys (organization, email);`,
+	// 11, oauth clients aren't an organization's, codes and tokens are stored hashed
+	// redirect uris and scopes are space separated, neither can have a space
+	`CREATE TABLE oauth_clients (
+		id VARCHAR(64) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		public BOOLEAN NOT NULL DEFAULT FALSE,
+		secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+		redirect_uris TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE oauth_codes (
+		code_hash VARCHAR(64) PRIMARY KEY,
+		client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		code_challenge VARCHAR(128) NOT NULL,
+		nonce TEXT NOT NULL DEFAULT '',
+		expires_at INTEGER NOT NULL,
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX oauth_codes_client ON oauth_codes (client_id);
+	CREATE INDEX oauth_codes_account ON oauth_codes (organization, email);
+
+	CREATE TABLE oauth_tokens (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+		organization VARCHAR(63),
+		email VARCHAR(255),
+		scopes TEXT NOT NULL DEFAULT '',
+		expires_at INTEGER NOT NULL,
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX oauth_tokens_client ON oauth_tokens (client_id);
+	CREATE INDEX oauth_tokens_account ON oauth_tokens (organization, email);`,
+	// 12, identities link the subjects of external identity providers to accounts, a login looks them up before it knows the organization
+	`CREATE TABLE identities (
+		provider VARCHAR(63) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (provider, subject),
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX identities_account ON identities (organization, email);`,
+	// 13, api keys are looked up by their public id, only the hash of the whole key is stored
+	`CREATE TABLE api_keys (
+		id VARCHAR(20) PRIMARY KEY,
+		key_hash VARCHAR(64) NOT NULL,
+		organization VARCHAR(63) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		last_used_at INTEGER,
+		last_used_ip VARCHAR(45) NOT NULL DEFAULT '',
+		FOREIGN KEY (organization, email) REFERENCES users (organization, email) ON DELETE CASCADE
+	);
+
+	CREATE INDEX api_keys_account ON api_keys (organization, email);`,
+	// 14, rejected two-factor codes lock an account for a while, a login's challenge is only checked once
+	`ALTER TABLE user_totp ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE user_totp ADD COLUMN locked_until INTEGER;
+
+	CREATE TABLE mfa_challenges (
+		id VARCHAR(64) PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);`,
+	// 15, a passkey login's challenge is only answered once
+	`CREATE TABLE passkey_challenges (
+		challenge VARCHAR(128) PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);`,
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY
+	);`)
+	if err != nil {
+		return err
+	}
+
+	// transactions are opened with BEGIN IMMEDIATE (_txlock), which serializes processes migrating the same file
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?);", i+1); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
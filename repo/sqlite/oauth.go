@@ -0,0 +1,383 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// splitSpaces undoes strings.Join(s, " "), an empty string is an empty list
+func splitSpaces(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(s, " ")
+}
+
+// nullString stores an empty string as NULL, a client's own token doesn't reference an account
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// oauthClientColumns are selected by every query, in the order scanOAuthClient scans them
+const oauthClientColumns = "id, name, public, secret_hash, redirect_uris, scopes, created_at"
+
+func scanOAuthClient(row scanner) (*ddd.OAuthClient, error) {
+	client := &ddd.OAuthClient{}
+
+	var (
+		redirectURIs string
+		scopes       string
+		createdAt    int64
+	)
+
+	if err := row.Scan(&client.ID, &client.Name, &client.Public, &client.SecretHash, &redirectURIs, &scopes, &createdAt); err != nil {
+		return nil, err
+	}
+
+	client.RedirectURIs = splitSpaces(redirectURIs)
+	client.Scopes = splitSpaces(scopes)
+	client.CreatedAt = time.UnixMicro(createdAt).UTC()
+
+	return client, nil
+}
+
+func (r *Repository) CreateOAuthClient(
+	ctx context.Context,
+	opts ddd.OAuthClientCreate,
+) (
+	*ddd.OAuthClient,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := opts.NewOAuthClient(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err = r.db.ExecContext(ctx,
+		"INSERT INTO oauth_clients (id, name, public, secret_hash, redirect_uris, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?);",
+		client.ID, client.Name, client.Public, client.SecretHash, strings.Join(client.RedirectURIs, " "), strings.Join(client.Scopes, " "), client.CreatedAt.UnixMicro(),
+	)
+	if isUniqueViolation(err) {
+		return nil, ddd.ErrOAuthClientExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return client, nil
+}
+
+func (r *Repository) GetOAuthClient(
+	ctx context.Context,
+	id string,
+) (
+	*ddd.OAuthClient,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	client, err := scanOAuthClient(r.db.QueryRowContext(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE id = ?;", id))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrOAuthClientNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return client, nil
+}
+
+func (r *Repository) ListOAuthClients(
+	ctx context.Context,
+) (
+	[]*ddd.OAuthClient,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients ORDER BY created_at ASC, rowid ASC;")
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer rows.Close()
+
+	clients := []*ddd.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		clients = append(clients, client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return clients, nil
+}
+
+func (r *Repository) DeleteOAuthClient(
+	ctx context.Context,
+	id string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	// the codes and tokens are deleted by ON DELETE CASCADE
+	res, err := r.db.ExecContext(ctx, "DELETE FROM oauth_clients WHERE id = ?;", id)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+// oauthReferenceError tells a missing client from a missing account after a foreign key violation
+func (r *Repository) oauthReferenceError(ctx context.Context, clientID string) error {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM oauth_clients WHERE id = ?);", clientID).Scan(&exists); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if !exists {
+		return ddd.ErrOAuthClientNotFound
+	}
+
+	return ddd.ErrUserNotFound
+}
+
+func (r *Repository) CreateOAuthCode(
+	ctx context.Context,
+	opts ddd.OAuthCodeCreate,
+) (
+	*ddd.OAuthCode,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	code, err := opts.NewOAuthCode(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err = r.db.ExecContext(ctx,
+		`INSERT INTO oauth_codes (code_hash, client_id, organization, email, redirect_uri, scopes, code_challenge, nonce, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		ddd.HashToken(code.Code), code.ClientID, code.Organization, code.Email, code.RedirectURI,
+		strings.Join(code.Scopes, " "), code.CodeChallenge, code.Nonce, code.ExpiresAt.UnixMicro(),
+	)
+	if isForeignKeyViolation(err) {
+		return nil, r.oauthReferenceError(ctx, opts.ClientID)
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return code, nil
+}
+
+func (r *Repository) ConsumeOAuthCode(
+	ctx context.Context,
+	code string,
+) (
+	*ddd.OAuthCode,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	consumed := &ddd.OAuthCode{}
+
+	var (
+		scopes    string
+		expiresAt int64
+	)
+
+	// an expired code is deleted too, it can't be exchanged anymore
+	err := r.db.QueryRowContext(ctx,
+		`DELETE FROM oauth_codes WHERE code_hash = ?
+		RETURNING client_id, organization, email, redirect_uri, scopes, code_challenge, nonce, expires_at;`,
+		ddd.HashToken(code),
+	).Scan(
+		&consumed.ClientID, &consumed.Organization, &consumed.Email, &consumed.RedirectURI,
+		&scopes, &consumed.CodeChallenge, &consumed.Nonce, &expiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrOAuthCodeNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	consumed.Scopes = splitSpaces(scopes)
+	consumed.ExpiresAt = time.UnixMicro(expiresAt).UTC()
+
+	if !consumed.ExpiresAt.After(time.Now()) {
+		return nil, ddd.ErrOAuthCodeNotFound
+	}
+
+	return consumed, nil
+}
+
+func (r *Repository) CreateOAuthToken(
+	ctx context.Context,
+	opts ddd.OAuthTokenCreate,
+) (
+	*ddd.OAuthToken,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	token, err := opts.NewOAuthToken(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	_, err = r.db.ExecContext(ctx,
+		"INSERT INTO oauth_tokens (token_hash, client_id, organization, email, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?);",
+		ddd.HashToken(token.Token), token.ClientID, nullString(token.Organization), nullString(token.Email),
+		strings.Join(token.Scopes, " "), token.ExpiresAt.UnixMicro(),
+	)
+	if isForeignKeyViolation(err) {
+		return nil, r.oauthReferenceError(ctx, opts.ClientID)
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return token, nil
+}
+
+func (r *Repository) GetOAuthToken(
+	ctx context.Context,
+	token string,
+) (
+	*ddd.OAuthToken,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	got := &ddd.OAuthToken{}
+
+	var (
+		organization sql.NullString
+		email        sql.NullString
+		scopes       string
+		expiresAt    int64
+	)
+
+	err := r.db.QueryRowContext(ctx,
+		"SELECT client_id, organization, email, scopes, expires_at FROM oauth_tokens WHERE token_hash = ? AND expires_at > ?;",
+		ddd.HashToken(token), time.Now().UnixMicro(),
+	).Scan(&got.ClientID, &organization, &email, &scopes, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrOAuthTokenNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	got.Organization = organization.String
+	got.Email = email.String
+	got.Scopes = splitSpaces(scopes)
+	got.ExpiresAt = time.UnixMicro(expiresAt).UTC()
+
+	return got, nil
+}
+
+func (r *Repository) RevokeOAuthToken(
+	ctx context.Context,
+	clientID string,
+	token string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM oauth_tokens WHERE token_hash = ? AND client_id = ?;", ddd.HashToken(token), clientID)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrOAuthTokenNotFound
+	}
+
+	return nil
+}
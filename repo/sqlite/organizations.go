@@ -0,0 +1,212 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+func (r *Repository) CreateOrganization(
+	ctx context.Context,
+	opts ddd.OrganizationCreate,
+) (
+	*ddd.Organization,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	organization := &ddd.Organization{
+		ID:        opts.ID,
+		Name:      opts.Name,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO organizations (id, name, created_at) VALUES (?, ?, ?);",
+		organization.ID, organization.Name, organization.CreatedAt.UnixMicro(),
+	)
+	if isUniqueViolation(err) {
+		return nil, ddd.ErrOrganizationExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return organization, nil
+}
+
+// scanOrganization scans id, name and created_at
+func scanOrganization(row scanner) (*ddd.Organization, error) {
+	organization := &ddd.Organization{}
+
+	var createdAt int64
+	if err := row.Scan(&organization.ID, &organization.Name, &createdAt); err != nil {
+		return nil, err
+	}
+
+	organization.CreatedAt = time.UnixMicro(createdAt).UTC()
+
+	return organization, nil
+}
+
+func (r *Repository) GetOrganization(
+	ctx context.Context,
+	id string,
+) (
+	*ddd.Organization,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateOrganizationID(id); err != nil {
+		return nil, err
+	}
+
+	organization, err := scanOrganization(r.db.QueryRowContext(ctx, "SELECT id, name, created_at FROM organizations WHERE id = ?;", id))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrOrganizationNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return organization, nil
+}
+
+func (r *Repository) ListOrganizations(
+	ctx context.Context,
+) (
+	[]*ddd.Organization,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, created_at FROM organizations ORDER BY id ASC;")
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer rows.Close()
+
+	organizations := []*ddd.Organization{}
+
+	for rows.Next() {
+		organization, err := scanOrganization(rows)
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		organizations = append(organizations, organization)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return organizations, nil
+}
+
+func (r *Repository) CreateInvitation(
+	ctx context.Context,
+	opts ddd.InvitationCreate,
+) (
+	*ddd.Invitation,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	invitation, err := opts.NewInvitation(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// the select inserts nothing for an unknown organization
+	res, err := r.db.ExecContext(ctx,
+		"INSERT INTO invitations (token_hash, organization, email, admin, expires_at) SELECT ?, id, ?, ?, ? FROM organizations WHERE id = ?;",
+		ddd.HashToken(invitation.Token), invitation.Email, invitation.Admin, invitation.ExpiresAt.UnixMicro(), invitation.Organization,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ddd.ErrOrganizationNotFound
+	}
+
+	return invitation, nil
+}
+
+// AcceptInvitation deletes the invitation and creates the account in one transaction, a failed create keeps the invitation
+func (r *Repository) AcceptInvitation(
+	ctx context.Context,
+	token string,
+	opts ddd.UserCreate,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	attributes, err := json.Marshal(ddd.MergeAttributes(nil, opts.Attributes))
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var tenant string
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM invitations WHERE token_hash = ? AND email = ? AND expires_at > ? RETURNING organization, admin;",
+		ddd.HashToken(token), opts.Email, time.Now().UnixMicro(),
+	).Scan(&tenant, &opts.Admin)
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrInvitationNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	user, err := create(ctx, tx, tenant, opts, string(attributes))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return user, nil
+}
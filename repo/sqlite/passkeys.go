@@ -0,0 +1,286 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// passkeyColumns are selected by every query, in the order scanPasskey scans them
+const passkeyColumns = "id, email, user_handle, name, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, created_at, last_used_at"
+
+func scanPasskey(row scanner) (*ddd.Passkey, error) {
+	passkey := &ddd.Passkey{}
+
+	var (
+		transports string
+		createdAt  int64
+		lastUsedAt sql.NullInt64
+	)
+
+	if err := row.Scan(
+		&passkey.ID, &passkey.Email, &passkey.UserHandle, &passkey.Name, &passkey.PublicKey, &passkey.AttestationType,
+		&passkey.AAGUID, &passkey.SignCount, &transports, &passkey.BackupEligible, &createdAt, &lastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if transports != "" {
+		passkey.Transports = strings.Split(transports, ",")
+	}
+
+	passkey.CreatedAt = time.UnixMicro(createdAt).UTC()
+
+	if lastUsedAt.Valid {
+		t := time.UnixMicro(lastUsedAt.Int64).UTC()
+		passkey.LastUsedAt = &t
+	}
+
+	return passkey, nil
+}
+
+func (r *Repository) CreatePasskey(
+	ctx context.Context,
+	opts ddd.PasskeyCreate,
+) (
+	*ddd.Passkey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO passkeys (organization, id, email, user_handle, name, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		ddd.TenantFrom(ctx), opts.ID, opts.Email, opts.UserHandle, opts.Name, opts.PublicKey, opts.AttestationType,
+		opts.AAGUID, opts.SignCount, strings.Join(opts.Transports, ","), opts.BackupEligible, createdAt.UnixMicro(),
+	)
+	if isUniqueViolation(err) {
+		return nil, ddd.ErrPasskeyExists
+	}
+
+	if isForeignKeyViolation(err) {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return &ddd.Passkey{
+		ID:              opts.ID,
+		Email:           opts.Email,
+		UserHandle:      opts.UserHandle,
+		Name:            opts.Name,
+		PublicKey:       opts.PublicKey,
+		AttestationType: opts.AttestationType,
+		AAGUID:          opts.AAGUID,
+		SignCount:       opts.SignCount,
+		Transports:      opts.Transports,
+		BackupEligible:  opts.BackupEligible,
+		CreatedAt:       createdAt,
+	}, nil
+}
+
+func (r *Repository) GetPasskey(
+	ctx context.Context,
+	id []byte,
+) (
+	*ddd.Passkey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	passkey, err := scanPasskey(r.db.QueryRowContext(ctx,
+		"SELECT "+passkeyColumns+" FROM passkeys WHERE organization = ? AND id = ?;",
+		ddd.TenantFrom(ctx), id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrPasskeyNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return passkey, nil
+}
+
+func (r *Repository) ListPasskeys(
+	ctx context.Context,
+	email string,
+) (
+	[]*ddd.Passkey,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+passkeyColumns+" FROM passkeys WHERE organization = ? AND email = ? ORDER BY created_at ASC, rowid ASC;",
+		ddd.TenantFrom(ctx), email,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer rows.Close()
+
+	passkeys := []*ddd.Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		passkeys = append(passkeys, passkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return passkeys, nil
+}
+
+// UsePasskey only moves sign_count forward, two logins racing with the same assertion can't both succeed
+func (r *Repository) UsePasskey(
+	ctx context.Context,
+	id []byte,
+	signCount uint32,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE passkeys SET sign_count = ?, last_used_at = ? WHERE organization = ? AND id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0));",
+		signCount, time.Now().UTC().UnixMicro(), tenant, id, signCount, signCount,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var exists bool
+
+		err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM passkeys WHERE organization = ? AND id = ?);", tenant, id).Scan(&exists)
+		if err != nil {
+			return ctxError(ctx, err)
+		}
+
+		if !exists {
+			return ddd.ErrPasskeyNotFound
+		}
+
+		return ddd.ErrPasskeyCloned
+	}
+
+	return nil
+}
+
+// UsePasskeyChallenge deletes the expired challenges in the same transaction, the primary key rejects a used one
+func (r *Repository) UsePasskeyChallenge(
+	ctx context.Context,
+	challenge string,
+	expiresAt time.Time,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if challenge == "" {
+		return errors.New("challenge was empty")
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM passkey_challenges WHERE expires_at <= ?;", time.Now().UnixMicro()); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO passkey_challenges (challenge, expires_at) VALUES (?, ?);", challenge, expiresAt.UnixMicro())
+	if isUniqueViolation(err) {
+		return ddd.ErrPasskeyChallengeUsed
+	}
+
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeletePasskey(
+	ctx context.Context,
+	email string,
+	id []byte,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM passkeys WHERE organization = ? AND email = ? AND id = ?;", ddd.TenantFrom(ctx), email, id)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrPasskeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,534 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/repo"
+	sqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type RepositoryOpts struct {
+	// Path is the database file, ":memory:" is a private in-memory database
+	Path string
+	Drop bool
+	// Timeouts bound every query on top of the caller's context, the same as repo.Repository
+	Timeouts repo.Timeouts
+}
+
+// NewRepository opens a pure go sqlite database with the same schema and error mapping as repo.Repository
+func NewRepository(
+	opts RepositoryOpts,
+) (*Repository, error) {
+	if opts.Path == "" {
+		return nil, errors.New("path was empty")
+	}
+
+	db, err := sql.Open("sqlite", opts.Path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite has a single writer, one connection serializes writes instead of failing them with SQLITE_BUSY
+	// it's also required for ":memory:", every connection would have its own database
+	db.SetMaxOpenConns(1)
+
+	if opts.Drop {
+		_, err := db.Exec("DROP TABLE IF EXISTS passkey_challenges; DROP TABLE IF EXISTS mfa_challenges; DROP TABLE IF EXISTS api_keys; DROP TABLE IF EXISTS identities; DROP TABLE IF EXISTS oauth_tokens; DROP TABLE IF EXISTS oauth_codes; DROP TABLE IF EXISTS oauth_clients; DROP TABLE IF EXISTS passkeys; DROP TABLE IF EXISTS recovery_codes; DROP TABLE IF EXISTS user_totp; DROP TABLE IF EXISTS invitations; DROP TABLE IF EXISTS users; DROP TABLE IF EXISTS organizations; DROP TABLE IF EXISTS schema_migrations;")
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Repository{
+		db:       db,
+		timeouts: opts.Timeouts,
+	}, nil
+}
+
+type Repository struct {
+	db       *sql.DB
+	timeouts repo.Timeouts
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// withTimeout applies the operation's timeout to ctx
+func withTimeout(
+	ctx context.Context,
+	timeout time.Duration,
+) (
+	context.Context,
+	context.CancelFunc,
+) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ctxError prefers the context's error, the driver reports an interrupted statement as a sqlite error
+func ctxError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var e *sqlite.Error
+
+	return errors.As(err, &e) && (e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || e.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
+
+func isForeignKeyViolation(err error) bool {
+	var e *sqlite.Error
+
+	return errors.As(err, &e) && e.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// userColumns are selected by every query, in the order scanUser scans them
+const userColumns = "organization, email, firstname, lastname, password, display_name, locale, timezone, attributes, avatar, admin, disabled, version"
+
+// scanUser scans userColumns
+func scanUser(row scanner) (*ddd.User, error) {
+	user := &ddd.User{}
+
+	var attributes string
+
+	err := row.Scan(
+		&user.Organization, &user.Email, &user.FirstName, &user.LastName, &user.Password,
+		&user.DisplayName, &user.Locale, &user.Timezone, &attributes,
+		&user.Avatar, &user.Admin, &user.Disabled, &user.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(attributes), &user.Attributes); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *Repository) Create(
+	ctx context.Context,
+	opts ddd.UserCreate,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	attributes, err := json.Marshal(ddd.MergeAttributes(nil, opts.Attributes))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	return create(ctx, r.db, ddd.TenantFrom(ctx), opts, string(attributes))
+}
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer is a *sql.DB or *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// create inserts a validated account into the tenant's organization
+func create(ctx context.Context, db execer, tenant string, opts ddd.UserCreate, attributes string) (*ddd.User, error) {
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO users (organization, email, firstname, lastname, password, display_name, locale, timezone, attributes, admin) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		tenant, opts.Email, opts.FirstName, opts.LastName, opts.Password, opts.DisplayName, opts.Locale, opts.Timezone, attributes, opts.Admin,
+	)
+	if isUniqueViolation(err) {
+		return nil, ddd.ErrUserExists
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return &ddd.User{
+		Organization: tenant,
+		Email:        opts.Email,
+		FirstName:    opts.FirstName,
+		LastName:     opts.LastName,
+		Password:     opts.Password,
+		DisplayName:  opts.DisplayName,
+		Locale:       opts.Locale,
+		Timezone:     opts.Timezone,
+		Attributes:   ddd.MergeAttributes(nil, opts.Attributes),
+		Admin:        opts.Admin,
+		Version:      1,
+	}, nil
+}
+
+func (r *Repository) Login(
+	ctx context.Context,
+	opts ddd.UserLogin,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	user, err := scanUser(r.db.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE organization = ? AND email = ?;",
+		ddd.TenantFrom(ctx), opts.Email,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	if user.Password != opts.Password {
+		return nil, ddd.ErrInvalidPassword
+	}
+
+	if user.Disabled {
+		return nil, ddd.ErrUserDisabled
+	}
+
+	return user, nil
+}
+
+func (r *Repository) Get(
+	ctx context.Context,
+	email string,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	user, err := scanUser(r.db.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE organization = ? AND email = ?;",
+		ddd.TenantFrom(ctx), email,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return user, nil
+}
+
+func (r *Repository) List(
+	ctx context.Context,
+	opts ddd.UserList,
+) (
+	[]*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.List)
+	defer cancel()
+
+	// a negative LIMIT is no limit
+	limit := opts.Limit
+	if limit == 0 {
+		limit = -1
+	}
+
+	where := "organization = ? AND email > ?"
+	args := []interface{}{ddd.TenantFrom(ctx), opts.After}
+
+	if len(opts.Emails) > 0 {
+		where += " AND email IN (?" + strings.Repeat(", ?", len(opts.Emails)-1) + ")"
+		for _, email := range opts.Emails {
+			args = append(args, email)
+		}
+	}
+
+	// LIKE ignores the case of ascii letters only, postgres' ILIKE ignores every letter's
+	if opts.Search != "" {
+		where += ` AND (email LIKE ? ESCAPE '\' OR firstname LIKE ? ESCAPE '\' OR lastname LIKE ? ESCAPE '\' OR display_name LIKE ? ESCAPE '\')`
+		pattern := opts.SearchPattern()
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+
+	if opts.Admin != nil {
+		where += " AND admin = ?"
+		args = append(args, *opts.Admin)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE "+where+" ORDER BY email ASC LIMIT ?;",
+		append(args, limit)...,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer rows.Close()
+
+	users := []*ddd.User{}
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return users, nil
+}
+
+func (r *Repository) Update(
+	ctx context.Context,
+	opts ddd.UserUpdate,
+) (
+	*ddd.User,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	// a nil attributes patch is bound as NULL too, json_patch() is RFC 7396 so a null value removes the key
+	var patch *string
+	if len(opts.Attributes) > 0 {
+		bs, err := json.Marshal(opts.Attributes)
+		if err != nil {
+			return nil, err
+		}
+
+		patch = ddd.String(string(bs))
+	}
+
+	// the version check and the write are one statement, zero matches every version
+	// a nil field is bound as NULL and keeps the stored value
+	user, err := scanUser(r.db.QueryRowContext(ctx,
+		`UPDATE users SET
+			firstname = COALESCE(?, firstname),
+			lastname = COALESCE(?, lastname),
+			display_name = COALESCE(?, display_name),
+			locale = COALESCE(?, locale),
+			timezone = COALESCE(?, timezone),
+			attributes = COALESCE(json_patch(attributes, ?), attributes),
+			avatar = COALESCE(?, avatar),
+			password = COALESCE(?, password),
+			disabled = COALESCE(?, disabled),
+			version = version + 1
+		WHERE organization = ? AND email = ? AND (? = 0 OR version = ?)
+		RETURNING `+userColumns+`;`,
+		opts.FirstName, opts.LastName, opts.DisplayName, opts.Locale, opts.Timezone, patch, opts.Avatar, opts.Password, opts.Disabled, ddd.TenantFrom(ctx), opts.Email, opts.Version, opts.Version,
+	))
+	if err == sql.ErrNoRows && opts.Version > 0 {
+		var exists bool
+		if err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE organization = ? AND email = ?);", ddd.TenantFrom(ctx), opts.Email).Scan(&exists); err != nil {
+			return nil, ctxError(ctx, err)
+		}
+
+		if exists {
+			return nil, ddd.ErrVersionMismatch
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return user, nil
+}
+
+func (r *Repository) Delete(
+	ctx context.Context,
+	email string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE organization = ? AND email = ?;", ddd.TenantFrom(ctx), email)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Import inserts every user with one prepared statement, in a transaction that a dry run rolls back
+func (r *Repository) Import(
+	ctx context.Context,
+	opts ddd.UserImport,
+) (
+	[]ddd.ImportResult,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	results := opts.Results()
+	tenant := ddd.TenantFrom(ctx)
+
+	conflict := "DO NOTHING"
+	if opts.Mode == ddd.ImportUpsert {
+		conflict = `DO UPDATE SET
+			firstname = excluded.firstname,
+			lastname = excluded.lastname,
+			password = excluded.password,
+			display_name = excluded.display_name,
+			locale = excluded.locale,
+			timezone = excluded.timezone,
+			attributes = excluded.attributes,
+			version = users.version + 1`
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Import)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	// a created account is at version 1, an updated one is past it
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO users (organization, email, firstname, lastname, password, display_name, locale, timezone, attributes, admin) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (organization, email) `+conflict+`
+		RETURNING version;`,
+	)
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+	defer stmt.Close()
+
+	for i, user := range opts.Users {
+		if results[i].Status != "" {
+			continue
+		}
+
+		attributes, err := json.Marshal(ddd.MergeAttributes(nil, user.Attributes))
+		if err != nil {
+			return nil, err
+		}
+
+		var version int64
+		err = stmt.QueryRowContext(ctx,
+			tenant, user.Email, user.FirstName, user.LastName, user.Password, user.DisplayName, user.Locale, user.Timezone, string(attributes), user.Admin,
+		).Scan(&version)
+
+		switch {
+		case err == sql.ErrNoRows:
+			// DO NOTHING doesn't return the accounts that already exist
+			results[i] = opts.Conflict(user.Email)
+		case err != nil:
+			return nil, ctxError(ctx, err)
+		case version == 1:
+			results[i].Status = ddd.ImportCreated
+		default:
+			results[i].Status = ddd.ImportUpdated
+		}
+	}
+
+	if opts.DryRun {
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,260 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.RunUserRepository(t, func(t *testing.T) ddd.UserRepository {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo
+	})
+}
+
+func TestOrganizationConformance(t *testing.T) {
+	conformance.RunOrganizationRepository(t, func(t *testing.T) (ddd.OrganizationRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestTOTPConformance(t *testing.T) {
+	conformance.RunTOTPRepository(t, func(t *testing.T) (ddd.TOTPRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestPasskeyConformance(t *testing.T) {
+	conformance.RunPasskeyRepository(t, func(t *testing.T) (ddd.PasskeyRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestOAuthConformance(t *testing.T) {
+	conformance.RunOAuthRepository(t, func(t *testing.T) (ddd.OAuthRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestIdentityConformance(t *testing.T) {
+	conformance.RunIdentityRepository(t, func(t *testing.T) (ddd.IdentityRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestAPIKeyConformance(t *testing.T) {
+	conformance.RunAPIKeyRepository(t, func(t *testing.T) (ddd.APIKeyRepository, ddd.UserRepository) {
+		repo, err := NewRepository(
+			RepositoryOpts{
+				Path: filepath.Join(t.TempDir(), "ddd.db"),
+			},
+		)
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %s", err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo, repo
+	})
+}
+
+func TestMigrate_Organizations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ddd.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %s", err)
+	}
+
+	// a database from before organizations
+	for i, migration := range migrations[:7] {
+		if _, err := db.Exec(migration); err != nil {
+			t.Fatalf("failed to apply migration %d: %s", i+1, err)
+		}
+	}
+
+	if _, err := db.Exec("CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY); INSERT INTO schema_migrations (version) VALUES (1), (2), (3), (4), (5), (6), (7);"); err != nil {
+		t.Fatalf("failed to record migrations: %s", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (email, firstname, lastname, password, admin) VALUES ('jackson@juandefu.ca', 'Jackson', 'Sabey', 'pass', TRUE);"); err != nil {
+		t.Fatalf("failed to insert user: %s", err)
+	}
+
+	db.Close()
+
+	repo, err := NewRepository(
+		RepositoryOpts{
+			Path: path,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate sqlite: %s", err)
+	}
+	defer repo.Close()
+
+	// the existing accounts are the default organization's
+	user, err := repo.Get(context.Background(), "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to get user: %s", err)
+	}
+
+	if user.Organization != ddd.DefaultOrganization || !user.Admin || user.Version != 1 {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	if _, err := repo.GetOrganization(context.Background(), ddd.DefaultOrganization); err != nil {
+		t.Errorf("failed to get the default organization: %s", err)
+	}
+}
+
+func TestNewRepository_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ddd.db")
+
+	repo, err := NewRepository(
+		RepositoryOpts{
+			Path: path,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %s", err)
+	}
+
+	_, err = repo.Create(context.Background(), ddd.UserCreate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	})
+	if err != nil {
+		t.Errorf("failed to create user: %s", err)
+	}
+
+	repo.Close()
+
+	// migrations aren't reapplied, and the data survives
+	repo, err = NewRepository(
+		RepositoryOpts{
+			Path: path,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to reopen sqlite: %s", err)
+	}
+	defer repo.Close()
+
+	users, err := repo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Errorf("failed to list: %s", err)
+	}
+
+	if len(users) != 1 {
+		t.Errorf("invalid amount of users: %d", len(users))
+	}
+}
+
+func TestNewRepository_Memory(t *testing.T) {
+	repo, err := NewRepository(
+		RepositoryOpts{
+			Path: ":memory:",
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %s", err)
+	}
+	defer repo.Close()
+
+	users, err := repo.List(context.Background(), ddd.UserList{})
+	if err != nil {
+		t.Errorf("failed to list: %s", err)
+	}
+
+	if len(users) != 0 {
+		t.Errorf("invalid amount of users: %d", len(users))
+	}
+}
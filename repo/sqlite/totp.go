@@ -0,0 +1,401 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+func (r *Repository) GetTOTP(
+	ctx context.Context,
+	email string,
+) (
+	*ddd.TOTP,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	t := &ddd.TOTP{
+		Email: email,
+	}
+
+	var lockedUntil sql.NullInt64
+
+	err := r.db.QueryRowContext(ctx,
+		`SELECT secret, enabled, last_step, failures, locked_until,
+			(SELECT COUNT(*) FROM recovery_codes c WHERE c.organization = t.organization AND c.email = t.email)
+		FROM user_totp t WHERE organization = ? AND email = ?;`,
+		ddd.TenantFrom(ctx), email,
+	).Scan(&t.Secret, &t.Enabled, &t.LastStep, &t.Failures, &lockedUntil, &t.RecoveryCodes)
+	if err == sql.ErrNoRows {
+		return nil, ddd.ErrTOTPNotFound
+	}
+
+	if err != nil {
+		return nil, ctxError(ctx, err)
+	}
+
+	if lockedUntil.Valid {
+		t.LockedUntil = time.UnixMicro(lockedUntil.Int64).UTC()
+	}
+
+	return t, nil
+}
+
+// EnrollTOTP only replaces an unconfirmed secret, the account's foreign key fails without an account
+func (r *Repository) EnrollTOTP(
+	ctx context.Context,
+	email string,
+	secret []byte,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO user_totp (organization, email, secret) VALUES (?, ?, ?)
+		ON CONFLICT (organization, email) DO UPDATE SET secret = excluded.secret, last_step = 0 WHERE user_totp.enabled = FALSE;`,
+		ddd.TenantFrom(ctx), email, secret,
+	)
+	if isForeignKeyViolation(err) {
+		return ddd.ErrUserNotFound
+	}
+
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrTOTPEnabled
+	}
+
+	return nil
+}
+
+// enabledTOTP tells why a statement on an enabled enrollment changed nothing, ErrTOTPNotFound or nil when it's enabled
+func enabledTOTP(ctx context.Context, q queryer, email string) error {
+	var enabled bool
+
+	err := q.QueryRowContext(ctx, "SELECT enabled FROM user_totp WHERE organization = ? AND email = ?;", ddd.TenantFrom(ctx), email).Scan(&enabled)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return ddd.ErrTOTPNotFound
+	}
+
+	return ctxError(ctx, err)
+}
+
+// EnableTOTP confirms the secret and replaces the recovery codes in one transaction
+func (r *Repository) EnableTOTP(
+	ctx context.Context,
+	opts ddd.TOTPEnable,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET enabled = TRUE, last_step = ? WHERE organization = ? AND email = ? AND enabled = FALSE;",
+		opts.Step, tenant, opts.Email,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if err := enabledTOTP(ctx, tx, opts.Email); err != nil {
+			return err
+		}
+
+		return ddd.ErrTOTPEnabled
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE organization = ? AND email = ?;", tenant, opts.Email); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	for _, hash := range opts.RecoveryCodes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (organization, email, code_hash) VALUES (?, ?, ?);",
+			tenant, opts.Email, hash,
+		); err != nil {
+			return ctxError(ctx, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep only moves last_step forward, two logins racing with the same code can't both succeed
+func (r *Repository) UseTOTPStep(
+	ctx context.Context,
+	email string,
+	step int64,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE user_totp SET last_step = ?, failures = 0 WHERE organization = ? AND email = ? AND enabled = TRUE AND last_step < ?;",
+		step, ddd.TenantFrom(ctx), email, step,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if err := enabledTOTP(ctx, r.db, email); err != nil {
+			return err
+		}
+
+		return ddd.ErrInvalidTOTPCode
+	}
+
+	return nil
+}
+
+func (r *Repository) UseRecoveryCode(
+	ctx context.Context,
+	email string,
+	hash string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	tenant := ddd.TenantFrom(ctx)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM recovery_codes WHERE organization = ? AND email = ? AND code_hash = ?;",
+		tenant, email, hash,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if err := enabledTOTP(ctx, tx, email); err != nil {
+			return err
+		}
+
+		return ddd.ErrInvalidTOTPCode
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE user_totp SET failures = 0 WHERE organization = ? AND email = ?;", tenant, email); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	return nil
+}
+
+// FailTOTP increments the count in the statement that returns it, logins racing with wrong codes are each counted
+func (r *Repository) FailTOTP(
+	ctx context.Context,
+	email string,
+) (
+	int,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	var failures int
+
+	err := r.db.QueryRowContext(ctx,
+		"UPDATE user_totp SET failures = failures + 1 WHERE organization = ? AND email = ? AND enabled = TRUE RETURNING failures;",
+		ddd.TenantFrom(ctx), email,
+	).Scan(&failures)
+	if err == sql.ErrNoRows {
+		return 0, ddd.ErrTOTPNotFound
+	}
+
+	if err != nil {
+		return 0, ctxError(ctx, err)
+	}
+
+	return failures, nil
+}
+
+func (r *Repository) LockTOTP(
+	ctx context.Context,
+	email string,
+	until time.Time,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE user_totp SET failures = 0, locked_until = ? WHERE organization = ? AND email = ? AND enabled = TRUE;",
+		until.UnixMicro(), ddd.TenantFrom(ctx), email,
+	)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrTOTPNotFound
+	}
+
+	return nil
+}
+
+// UseMFAChallenge deletes the expired challenges in the same transaction, the primary key rejects a used one
+func (r *Repository) UseMFAChallenge(
+	ctx context.Context,
+	id string,
+	expiresAt time.Time,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == "" {
+		return errors.New("id was empty")
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Login)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at <= ?;", time.Now().UnixMicro()); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO mfa_challenges (id, expires_at) VALUES (?, ?);", id, expiresAt.UnixMicro())
+	if isUniqueViolation(err) {
+		return ddd.ErrMFAChallengeUsed
+	}
+
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ctxError(ctx, err)
+	}
+
+	return nil
+}
+
+// DeleteTOTP deletes the recovery codes through their foreign key
+func (r *Repository) DeleteTOTP(
+	ctx context.Context,
+	email string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ddd.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM user_totp WHERE organization = ? AND email = ?;", ddd.TenantFrom(ctx), email)
+	if err != nil {
+		return ctxError(ctx, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ddd.ErrTOTPNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/go-pg/pg"
+	"github.com/sabey/ddd/tracing"
+)
+
+type spanKey struct{}
+
+// queryTracer creates a client span for every SQL statement, parented by the span in the query's context
+type queryTracer struct {
+	tracer *tracing.Tracer
+}
+
+func (qt queryTracer) BeforeQuery(event *pg.QueryEvent) {
+	ctx := event.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		query = ""
+	}
+
+	_, span := qt.tracer.Start(ctx, "pg.query", tracing.SpanKindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement", query)
+
+	event.Data[spanKey{}] = span
+}
+
+func (qt queryTracer) AfterQuery(event *pg.QueryEvent) {
+	span, ok := event.Data[spanKey{}].(*tracing.Span)
+	if !ok {
+		return
+	}
+
+	span.SetError(event.Error)
+	span.End()
+}
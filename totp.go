@@ -0,0 +1,92 @@
+package ddd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// every TOTPRepository returns these, callers can match them with errors.Is
+var (
+	// ErrTOTPNotFound is returned for an account that never enrolled, or whose enrollment was reset
+	ErrTOTPNotFound = errors.New("two-factor authentication isn't enrolled")
+	ErrTOTPEnabled  = errors.New("two-factor authentication is already enabled")
+	// ErrInvalidTOTPCode is returned for a code that was already used, including a used recovery code
+	ErrInvalidTOTPCode = errors.New("two-factor code is invalid")
+	// ErrTOTPLocked is returned while an account's codes are refused after too many were rejected
+	ErrTOTPLocked = errors.New("too many invalid two-factor codes, try again later")
+	// ErrMFAChallengeUsed is returned for a login's challenge that already had its second factor checked
+	ErrMFAChallengeUsed = errors.New("mfa challenge was already used")
+)
+
+// RecoveryCodeCount is how many recovery codes an account gets when it enables two-factor authentication
+const RecoveryCodeCount = 10
+
+// TOTP is an account's RFC 6238 enrollment
+type TOTP struct {
+	Email string
+	// Secret is encrypted by the caller, the repositories never see it in the clear
+	Secret []byte
+	// Enabled is false until a code of the secret was confirmed, logins only ask for a code once it's true
+	Enabled bool
+	// LastStep is the time step of the last accepted code, a code of it or an earlier step isn't accepted again
+	LastStep int64
+	// RecoveryCodes is how many unused recovery codes are left
+	RecoveryCodes int
+	// Failures is how many codes were rejected since the last accepted one, or since the account was locked
+	Failures int
+	// LockedUntil is when codes are checked again after too many were rejected, zero when they're checked
+	LockedUntil time.Time
+}
+
+// every method is scoped to the context's tenant (WithTenant) like UserRepository's
+// deleting the account deletes its enrollment and recovery codes
+type TOTPRepository interface {
+	// GetTOTP returns ErrTOTPNotFound when the account has no secret, confirmed or not
+	GetTOTP(ctx context.Context, email string) (*TOTP, error)
+	// EnrollTOTP stores an unconfirmed secret, replacing an unconfirmed one
+	// it returns ErrTOTPEnabled once the secret was confirmed, and ErrUserNotFound without an account
+	EnrollTOTP(ctx context.Context, email string, secret []byte) error
+	// EnableTOTP confirms the secret and replaces the recovery codes
+	// it returns ErrTOTPEnabled when it's already confirmed
+	EnableTOTP(context.Context, TOTPEnable) error
+	// UseTOTPStep records an accepted code's step, it returns ErrInvalidTOTPCode when a code of the step or a later one was accepted
+	// an accepted code starts the count of rejected codes over
+	UseTOTPStep(ctx context.Context, email string, step int64) error
+	// UseRecoveryCode deletes a recovery code by its hash, it returns ErrInvalidTOTPCode when the account doesn't have it
+	// an accepted recovery code starts the count of rejected codes over
+	UseRecoveryCode(ctx context.Context, email string, hash string) error
+	// FailTOTP counts a rejected code or recovery code and returns the count, it returns ErrTOTPNotFound when it isn't enabled
+	FailTOTP(ctx context.Context, email string) (int, error)
+	// LockTOTP refuses codes until the time and starts the count of rejected codes over, it returns ErrTOTPNotFound when it isn't enabled
+	LockTOTP(ctx context.Context, email string, until time.Time) error
+	// UseMFAChallenge records a login's challenge until it expires, it returns ErrMFAChallengeUsed when it was already recorded
+	// challenge ids are random, they aren't scoped to the tenant
+	UseMFAChallenge(ctx context.Context, id string, expiresAt time.Time) error
+	// DeleteTOTP disables two-factor authentication and deletes the recovery codes, it returns ErrTOTPNotFound when there's nothing to delete
+	DeleteTOTP(ctx context.Context, email string) error
+}
+
+type TOTPEnable struct {
+	Email string
+	// Step is the confirming code's time step
+	Step int64
+	// RecoveryCodes are HashToken hashes, the codes are only shown once
+	RecoveryCodes []string
+}
+
+func (te TOTPEnable) Validate() error {
+	if err := ValidateEmail(te.Email); err != nil {
+		return err
+	}
+
+	if te.Step <= 0 {
+		return errors.New("step must be positive")
+	}
+
+	if len(te.RecoveryCodes) == 0 {
+		return errors.New("recoveryCodes was empty")
+	}
+
+	return nil
+}
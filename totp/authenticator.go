@@ -0,0 +1,292 @@
+package totp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sabey/ddd"
+)
+
+// DefaultIssuer names the account in authenticator apps
+const DefaultIssuer = "ddd"
+
+const (
+	// MaxFailures is how many codes in a row can be rejected before the account's codes are refused for Lockout
+	MaxFailures = 5
+	Lockout     = 15 * time.Minute
+)
+
+type AuthenticatorOpts struct {
+	Repository ddd.TOTPRepository
+	// Key seals the secrets, a random key is generated when nil and every secret is lost on restart
+	Key []byte
+	// Issuer is DefaultIssuer when empty
+	Issuer string
+	// Now is time.Now when nil
+	Now func() time.Time
+}
+
+// Authenticator enrolls accounts and checks the second factor of their logins
+// the secrets are sealed before they reach the repository
+type Authenticator struct {
+	repo   ddd.TOTPRepository
+	sealer *Sealer
+	issuer string
+	now    func() time.Time
+}
+
+func NewAuthenticator(
+	opts AuthenticatorOpts,
+) (
+	*Authenticator,
+	error,
+) {
+	if opts.Repository == nil {
+		return nil, errors.New("repository was nil")
+	}
+
+	if opts.Key == nil {
+		key, err := NewKey()
+		if err != nil {
+			return nil, err
+		}
+		opts.Key = key
+	}
+
+	sealer, err := NewSealer(opts.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Issuer == "" {
+		opts.Issuer = DefaultIssuer
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &Authenticator{
+		repo:   opts.Repository,
+		sealer: sealer,
+		issuer: opts.Issuer,
+		now:    opts.Now,
+	}, nil
+}
+
+// Enrollment is shown once, it's what an authenticator app is set up with
+type Enrollment struct {
+	// Secret is base32, for typing into the app
+	Secret string
+	URI    string
+	// QRCode is a PNG of URI
+	QRCode []byte
+}
+
+// Enroll stores a new unconfirmed secret for the context's account, replacing an unconfirmed one
+// it returns ddd.ErrTOTPEnabled once a secret was confirmed
+func (a *Authenticator) Enroll(
+	ctx context.Context,
+	email string,
+) (
+	*Enrollment,
+	error,
+) {
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := a.sealer.Seal(secret, sealedAccount(ctx, email))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.repo.EnrollTOTP(ctx, email, sealed); err != nil {
+		return nil, err
+	}
+
+	uri := URI(a.issuer, email, secret)
+
+	png, err := QRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enrollment{
+		Secret: EncodeSecret(secret),
+		URI:    uri,
+		QRCode: png,
+	}, nil
+}
+
+// Confirm enables two-factor authentication with a code of the enrolled secret, the returned recovery codes are only shown once
+func (a *Authenticator) Confirm(
+	ctx context.Context,
+	email string,
+	code string,
+) (
+	[]string,
+	error,
+) {
+	t, err := a.repo.GetTOTP(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Enabled {
+		return nil, ddd.ErrTOTPEnabled
+	}
+
+	step, err := a.validate(ctx, t, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = HashRecoveryCode(c)
+	}
+
+	if err := a.repo.EnableTOTP(ctx, ddd.TOTPEnable{
+		Email:         email,
+		Step:          step,
+		RecoveryCodes: hashes,
+	}); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Required is true when the account's logins need a second factor
+func (a *Authenticator) Required(
+	ctx context.Context,
+	email string,
+) (
+	bool,
+	error,
+) {
+	t, err := a.repo.GetTOTP(ctx, email)
+	if errors.Is(err, ddd.ErrTOTPNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return t.Enabled, nil
+}
+
+// Verify accepts a code, or a recovery code when code is empty, each is only accepted once
+// it returns ddd.ErrInvalidTOTPCode for a wrong or reused code, and ddd.ErrTOTPLocked once MaxFailures were rejected in a row
+func (a *Authenticator) Verify(
+	ctx context.Context,
+	email string,
+	code string,
+	recoveryCode string,
+) error {
+	t, err := a.repo.GetTOTP(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if !t.Enabled {
+		return ddd.ErrTOTPNotFound
+	}
+
+	if a.now().Before(t.LockedUntil) {
+		return ddd.ErrTOTPLocked
+	}
+
+	switch {
+	case code != "":
+		var step int64
+		if step, err = a.validate(ctx, t, code); err == nil {
+			// the step moves forward atomically, a replayed code loses the race
+			err = a.repo.UseTOTPStep(ctx, email, step)
+		}
+	case recoveryCode != "":
+		err = a.repo.UseRecoveryCode(ctx, email, HashRecoveryCode(recoveryCode))
+	default:
+		err = ddd.ErrInvalidTOTPCode
+	}
+
+	if errors.Is(err, ddd.ErrInvalidTOTPCode) {
+		return a.fail(ctx, email)
+	}
+
+	return err
+}
+
+// fail counts a rejected code and locks the account's codes once MaxFailures were rejected in a row
+// it returns ddd.ErrTOTPLocked when this code locked them, ddd.ErrInvalidTOTPCode otherwise
+func (a *Authenticator) fail(
+	ctx context.Context,
+	email string,
+) error {
+	failures, err := a.repo.FailTOTP(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if failures < MaxFailures {
+		return ddd.ErrInvalidTOTPCode
+	}
+
+	if err := a.repo.LockTOTP(ctx, email, a.now().Add(Lockout)); err != nil {
+		return err
+	}
+
+	return ddd.ErrTOTPLocked
+}
+
+// UseChallenge records a login's challenge, its second factor is only checked once
+// it returns ddd.ErrMFAChallengeUsed when it already was
+func (a *Authenticator) UseChallenge(
+	ctx context.Context,
+	challenge *ddd.MFAChallenge,
+) error {
+	return a.repo.UseMFAChallenge(ctx, challenge.ID, challenge.ExpiresAt)
+}
+
+// Reset disables the account's two-factor authentication, it's for admins of accounts that lost their app and codes
+func (a *Authenticator) Reset(
+	ctx context.Context,
+	email string,
+) error {
+	return a.repo.DeleteTOTP(ctx, email)
+}
+
+func (a *Authenticator) validate(
+	ctx context.Context,
+	t *ddd.TOTP,
+	code string,
+) (
+	int64,
+	error,
+) {
+	secret, err := a.sealer.Open(t.Secret, sealedAccount(ctx, t.Email))
+	if err != nil {
+		return 0, err
+	}
+
+	step, ok := Validate(secret, code, a.now())
+	if !ok || step <= t.LastStep {
+		return 0, ddd.ErrInvalidTOTPCode
+	}
+
+	return step, nil
+}
+
+// sealedAccount binds a sealed secret to its organization and email
+func sealedAccount(ctx context.Context, email string) string {
+	return ddd.TenantFrom(ctx) + "/" + email
+}
@@ -0,0 +1,45 @@
+package totp
+
+import (
+	"crypto/rand"
+	"strings"
+
+	"github.com/sabey/ddd"
+)
+
+// recoveryAlphabet leaves out the letters and digits that are easily confused (0, o, 1, l, i)
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCodes returns ddd.RecoveryCodeCount codes formatted `xxxxx-xxxxx`, about 49 bits each
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, ddd.RecoveryCodeCount)
+
+	b := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := make([]byte, 0, 11)
+		for j, c := range b {
+			if j == 5 {
+				code = append(code, '-')
+			}
+
+			// the modulo bias is below 1%, it doesn't matter for codes that are used once
+			code = append(code, recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+		}
+
+		codes[i] = string(code)
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode is the stored form of a code, it ignores case, spaces and the dash so a code can be typed loosely
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+
+	return ddd.HashToken(code)
+}
@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// KeySize is AES-256's
+const KeySize = 32
+
+var ErrInvalidSecret = errors.New("secret can't be decrypted")
+
+// Sealer encrypts the secrets at rest with AES-GCM, a database dump alone can't generate codes
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{
+		aead: aead,
+	}, nil
+}
+
+// NewKey is a random key, secrets sealed with it can't be opened once it's lost
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ParseKey decodes a hex key, `openssl rand -hex 32` generates one
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("key isn't hex: %w", err)
+	}
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// Seal encrypts the secret for the account, it's the nonce followed by the ciphertext
+// the account is authenticated, a secret copied to another account's row can't be opened
+func (s *Sealer) Seal(secret []byte, account string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, secret, []byte(account)), nil
+}
+
+func (s *Sealer) Open(sealed []byte, account string) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, ErrInvalidSecret
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+
+	secret, err := s.aead.Open(nil, nonce, ciphertext, []byte(account))
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+
+	return secret, nil
+}
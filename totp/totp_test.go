@@ -0,0 +1,367 @@
+package totp
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/mock"
+)
+
+// the SHA-1 vectors of RFC 6238's appendix B
+func TestCode_RFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	for _, tt := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		if c := code(secret, Step(time.Unix(tt.unix, 0)), 8); c != tt.code {
+			t.Errorf("%d: expected %s, got %s", tt.unix, tt.code, c)
+		}
+
+		if c := Code(secret, Step(time.Unix(tt.unix, 0))); c != tt.code[2:] {
+			t.Errorf("%d: expected %s, got %s", tt.unix, tt.code[2:], c)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to create secret: %s", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	step := Step(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		if s, ok := Validate(secret, Code(secret, step+offset), now); !ok || s != step+offset {
+			t.Errorf("%d: code wasn't accepted", offset)
+		}
+	}
+
+	for _, offset := range []int64{-2, 2} {
+		if _, ok := Validate(secret, Code(secret, step+offset), now); ok {
+			t.Errorf("%d: code was accepted", offset)
+		}
+	}
+
+	c := Code(secret, step)
+	if _, ok := Validate(secret, c[:3]+" "+c[3:], now); !ok {
+		t.Errorf("a spaced code wasn't accepted")
+	}
+
+	for _, c := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(secret, c, now); ok {
+			t.Errorf("%q was accepted", c)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("ddd", "jackson@juandefu.ca", []byte("12345678901234567890"))
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", uri, err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/ddd:jackson@juandefu.ca" {
+		t.Errorf("unknown uri: %s", uri)
+	}
+
+	if q := u.Query(); q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "ddd" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unknown query: %s", u.RawQuery)
+	}
+
+	bs, err := QRCode(uri)
+	if err != nil {
+		t.Fatalf("failed to encode qr code: %s", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(bs))
+	if err != nil {
+		t.Fatalf("failed to decode png: %s", err)
+	}
+
+	if b := img.Bounds(); b.Dx() != QRCodeSize || b.Dy() != QRCodeSize {
+		t.Errorf("unexpected size: %v", b)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("failed to create recovery codes: %s", err)
+	}
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' || seen[c] {
+			t.Errorf("unexpected code: %s", c)
+		}
+		seen[c] = true
+	}
+
+	if len(codes) != 10 {
+		t.Errorf("expected 10 codes, got %d", len(codes))
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(strings.Replace(codes[0], "-", "", 1))) {
+		t.Errorf("the hash depends on the code's formatting")
+	}
+}
+
+func TestSealer(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+
+	s, err := NewSealer(key)
+	if err != nil {
+		t.Fatalf("failed to create sealer: %s", err)
+	}
+
+	sealed, err := s.Seal([]byte("secret"), "default/jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to seal: %s", err)
+	}
+
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("the secret isn't encrypted")
+	}
+
+	if secret, err := s.Open(sealed, "default/jackson@juandefu.ca"); err != nil || string(secret) != "secret" {
+		t.Errorf("failed to open: %q %v", secret, err)
+	}
+
+	if _, err := s.Open(sealed, "default/jackson@sabey.co"); err != ErrInvalidSecret {
+		t.Errorf("opened another account's secret: %v", err)
+	}
+
+	other, _ := NewKey()
+	o, _ := NewSealer(other)
+	if _, err := o.Open(sealed, "default/jackson@juandefu.ca"); err != ErrInvalidSecret {
+		t.Errorf("opened with another key: %v", err)
+	}
+
+	if _, err := NewSealer(key[:16]); err == nil {
+		t.Errorf("accepted a short key")
+	}
+
+	if _, err := ParseKey(strings.Repeat("ab", 32)); err != nil {
+		t.Errorf("failed to parse key: %s", err)
+	}
+
+	for _, k := range []string{"", "zz", strings.Repeat("ab", 16)} {
+		if _, err := ParseKey(k); err == nil {
+			t.Errorf("parsed %q", k)
+		}
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	ctx := context.Background()
+
+	repo := mock.NewUserRepository()
+	if _, err := repo.Create(ctx, ddd.UserCreate{
+		Email:     "jackson@juandefu.ca",
+		FirstName: "Jackson",
+		LastName:  "Sabey",
+		Password:  "pass",
+	}); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+
+	a, err := NewAuthenticator(AuthenticatorOpts{
+		Repository: repo,
+		Now:        func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %s", err)
+	}
+
+	enrollment, err := a.Enroll(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	secret, err := encoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %s", err)
+	}
+
+	// the repository only has the sealed secret
+	if stored, _ := repo.GetTOTP(ctx, "jackson@juandefu.ca"); bytes.Contains(stored.Secret, secret) {
+		t.Errorf("the secret isn't sealed")
+	}
+
+	if required, err := a.Required(ctx, "jackson@juandefu.ca"); err != nil || required {
+		t.Errorf("an unconfirmed secret is required: %v %v", required, err)
+	}
+
+	if _, err := a.Confirm(ctx, "jackson@juandefu.ca", Code(secret, Step(now)-5)); err != ddd.ErrInvalidTOTPCode {
+		t.Errorf("a wrong code was confirmed: %v", err)
+	}
+
+	codes, err := a.Confirm(ctx, "jackson@juandefu.ca", Code(secret, Step(now)))
+	if err != nil {
+		t.Fatalf("failed to confirm: %s", err)
+	}
+
+	if required, err := a.Required(ctx, "jackson@juandefu.ca"); err != nil || !required {
+		t.Errorf("a confirmed secret isn't required: %v %v", required, err)
+	}
+
+	// the confirming code was used
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != ddd.ErrInvalidTOTPCode {
+		t.Errorf("the confirming code was accepted: %v", err)
+	}
+
+	now = now.Add(Period)
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != nil {
+		t.Errorf("failed to verify: %s", err)
+	}
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != ddd.ErrInvalidTOTPCode {
+		t.Errorf("a code was replayed: %v", err)
+	}
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", "", strings.ToUpper(codes[0])); err != nil {
+		t.Errorf("failed to verify recovery code: %s", err)
+	}
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", "", codes[0]); err != ddd.ErrInvalidTOTPCode {
+		t.Errorf("a recovery code was reused: %v", err)
+	}
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", "", ""); err != ddd.ErrInvalidTOTPCode {
+		t.Errorf("an empty code was accepted: %v", err)
+	}
+
+	if err := a.Reset(ctx, "jackson@juandefu.ca"); err != nil {
+		t.Fatalf("failed to reset: %s", err)
+	}
+
+	if required, err := a.Required(ctx, "jackson@juandefu.ca"); err != nil || required {
+		t.Errorf("a reset secret is required: %v %v", required, err)
+	}
+}
+
+func TestAuthenticator_Lockout(t *testing.T) {
+	ctx := context.Background()
+
+	repo := mock.NewUserRepository()
+	repo.Seed(ddd.User{Email: "jackson@juandefu.ca", Password: ddd.HashPassword("pass")})
+
+	now := time.Unix(1700000000, 0)
+
+	a, err := NewAuthenticator(AuthenticatorOpts{
+		Repository: repo,
+		Now:        func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %s", err)
+	}
+
+	enrollment, err := a.Enroll(ctx, "jackson@juandefu.ca")
+	if err != nil {
+		t.Fatalf("failed to enroll: %s", err)
+	}
+
+	secret, err := encoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %s", err)
+	}
+
+	if _, err := a.Confirm(ctx, "jackson@juandefu.ca", Code(secret, Step(now))); err != nil {
+		t.Fatalf("failed to confirm: %s", err)
+	}
+
+	wrong := Code(secret, Step(now)-5)
+
+	// an accepted code starts the count again
+	for i := 0; i < MaxFailures-1; i++ {
+		if err := a.Verify(ctx, "jackson@juandefu.ca", wrong, ""); err != ddd.ErrInvalidTOTPCode {
+			t.Fatalf("a wrong code wasn't rejected: %v", err)
+		}
+	}
+
+	now = now.Add(Period)
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+
+	for i := 0; i < MaxFailures-1; i++ {
+		if err := a.Verify(ctx, "jackson@juandefu.ca", wrong, ""); err != ddd.ErrInvalidTOTPCode {
+			t.Fatalf("a wrong code wasn't rejected: %v", err)
+		}
+	}
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", wrong, ""); err != ddd.ErrTOTPLocked {
+		t.Fatalf("expected ErrTOTPLocked, got: %v", err)
+	}
+
+	// a right code is refused until the lockout is over
+	now = now.Add(Period)
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != ddd.ErrTOTPLocked {
+		t.Errorf("a locked account was verified: %v", err)
+	}
+
+	now = now.Add(Lockout)
+
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != nil {
+		t.Errorf("failed to verify after the lockout: %s", err)
+	}
+
+	// the time-step can't be used again
+	if err := a.Verify(ctx, "jackson@juandefu.ca", Code(secret, Step(now)), ""); err != ddd.ErrInvalidTOTPCode {
+		t.Errorf("a time-step was reused: %v", err)
+	}
+}
+
+func TestAuthenticator_UseChallenge(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := NewAuthenticator(AuthenticatorOpts{Repository: mock.NewUserRepository()})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %s", err)
+	}
+
+	challenge := ddd.ParseMFAChallenge(ddd.SignMFAChallenge(ddd.DefaultOrganization, "jackson@juandefu.ca"))
+	if challenge == nil {
+		t.Fatalf("failed to parse challenge")
+	}
+
+	if err := a.UseChallenge(ctx, challenge); err != nil {
+		t.Fatalf("failed to use challenge: %s", err)
+	}
+
+	if err := a.UseChallenge(ctx, challenge); err != ddd.ErrMFAChallengeUsed {
+		t.Errorf("expected ErrMFAChallengeUsed, got: %v", err)
+	}
+
+	// every challenge is different
+	if err := a.UseChallenge(ctx, ddd.ParseMFAChallenge(ddd.SignMFAChallenge(ddd.DefaultOrganization, "jackson@juandefu.ca"))); err != nil {
+		t.Errorf("failed to use another challenge: %s", err)
+	}
+}
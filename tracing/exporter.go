@@ -0,0 +1,287 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Exporter interface {
+	ExportSpans(serviceName string, spans []*SpanData) error
+	Shutdown() error
+}
+
+// NewExporter builds an exporter from a configuration string:
+// `stdout` writes OTLP JSON lines to stdout,
+// `file:/var/log/ddd/traces.jsonl` appends OTLP JSON lines to a file,
+// `otlp:http://localhost:4318` posts OTLP over HTTP (JSON encoding) to a collector
+func NewExporter(
+	config string,
+) (
+	Exporter,
+	error,
+) {
+	switch {
+	case config == "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	case strings.HasPrefix(config, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(config, "file:"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewWriterExporter(f), nil
+	case strings.HasPrefix(config, "otlp:"):
+		return NewOTLPExporter(
+			OTLPExporterOpts{
+				Endpoint: strings.TrimPrefix(config, "otlp:"),
+			},
+		), nil
+	}
+
+	return nil, fmt.Errorf("unknown trace exporter: %s", config)
+}
+
+// NewWriterExporter writes every batch as one line of OTLP JSON, the w is closed on shutdown when it's an io.Closer
+func NewWriterExporter(
+	w io.Writer,
+) *WriterExporter {
+	return &WriterExporter{
+		w: w,
+	}
+}
+
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (we *WriterExporter) ExportSpans(serviceName string, spans []*SpanData) error {
+	bs, err := json.Marshal(newOTLPRequest(serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	_, err = fmt.Fprintf(we.w, "%s\n", bs)
+
+	return err
+}
+
+func (we *WriterExporter) Shutdown() error {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	// don't close stdout
+	if we.w == os.Stdout || we.w == os.Stderr {
+		return nil
+	}
+
+	if c, ok := we.w.(io.Closer); ok {
+		err := c.Close()
+		we.w = ioutil.Discard
+
+		return err
+	}
+
+	return nil
+}
+
+type OTLPExporterOpts struct {
+	// Endpoint is the collector's base url, spans are posted to Endpoint + "/v1/traces"
+	Endpoint string
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+func NewOTLPExporter(
+	opts OTLPExporterOpts,
+) *OTLPExporter {
+	if opts.Client == nil {
+		opts.Client = &http.Client{
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	return &OTLPExporter{
+		opts: opts,
+		url:  strings.TrimSuffix(opts.Endpoint, "/") + "/v1/traces",
+	}
+}
+
+type OTLPExporter struct {
+	opts OTLPExporterOpts
+	url  string
+}
+
+func (oe *OTLPExporter) ExportSpans(serviceName string, spans []*SpanData) error {
+	bs, err := json.Marshal(newOTLPRequest(serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", oe.url, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range oe.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := oe.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("otlp collector responded with " + resp.Status)
+	}
+
+	return nil
+}
+
+func (oe *OTLPExporter) Shutdown() error {
+	return nil
+}
+
+// the otlp types below are the JSON mapping of opentelemetry/proto/collector/trace/v1 ExportTraceServiceRequest
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/docs/specification.md#json-protobuf-encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPRequest(serviceName string, spans []*SpanData) otlpRequest {
+	otlpSpans := []otlpSpan{}
+
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        newOTLPAttributes(span.Attributes),
+			Status: otlpStatus{
+				Code:    span.Status,
+				Message: span.StatusMessage,
+			},
+		}
+
+		if span.Parent.IsValid() {
+			s.ParentSpanID = span.Parent.String()
+		}
+
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: newOTLPAttributes([]Attribute{
+						{Key: "service.name", Value: serviceName},
+					}),
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{
+							Name: "github.com/sabey/ddd/tracing",
+						},
+						Spans: otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func newOTLPAttributes(attributes []Attribute) []otlpKeyValue {
+	kvs := []otlpKeyValue{}
+
+	for _, attribute := range attributes {
+		kv := otlpKeyValue{
+			Key: attribute.Key,
+		}
+
+		switch v := attribute.Value.(type) {
+		case string:
+			kv.Value.StringValue = &v
+		case bool:
+			kv.Value.BoolValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			kv.Value.IntValue = &s
+		case float64:
+			kv.Value.DoubleValue = &v
+		}
+
+		kvs = append(kvs, kv)
+	}
+
+	return kvs
+}
@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unknown path: %s", r.URL.Path)
+		}
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unknown content type: %s", r.Header.Get("Content-Type"))
+		}
+
+		request := otlpRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode otlp json: %s", err)
+		}
+
+		requests <- request
+	}))
+	defer ts.Close()
+
+	exporter, err := NewExporter("otlp:" + ts.URL)
+	if err != nil {
+		t.Errorf("failed to build exporter: %s", err)
+	}
+
+	tracer := NewTracer(
+		TracerOpts{
+			Exporter: exporter,
+		},
+	)
+
+	_, span := tracer.Start(context.Background(), "span", SpanKindInternal)
+	span.End()
+
+	tracer.Close()
+
+	request := <-requests
+	if request.ResourceSpans[0].ScopeSpans[0].Spans[0].Name != "span" {
+		t.Errorf("unknown span exported: %v", request)
+	}
+}
+
+func TestNewExporter_Unknown(t *testing.T) {
+	if _, err := NewExporter("jaeger"); err == nil {
+		t.Errorf("built an unknown exporter?")
+	}
+}
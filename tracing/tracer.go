@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type TracerOpts struct {
+	// ServiceName is exported as the `service.name` resource attribute
+	ServiceName string
+	Exporter    Exporter
+	// BatchSize is the amount of spans exported at once, defaults to 512
+	BatchSize int
+	// FlushInterval is how often a partial batch is exported, defaults to 5 seconds
+	FlushInterval time.Duration
+}
+
+func NewTracer(
+	opts TracerOpts,
+) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "ddd"
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+
+	t := &Tracer{
+		opts:    opts,
+		queue:   make(chan *SpanData, opts.BatchSize*4),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Tracer starts spans and batches finished spans to its Exporter
+// a nil *Tracer is valid and starts nil spans, which record nothing
+type Tracer struct {
+	opts  TracerOpts
+	queue chan *SpanData
+	flush chan chan struct{}
+	done  chan struct{}
+	// stopped is closed once the last batch has been exported
+	stopped chan struct{}
+
+	closeOnce sync.Once
+}
+
+// Start creates a span as a child of the span (or remote span context) in ctx
+// the returned context carries the new span
+func (t *Tracer) Start(
+	ctx context.Context,
+	name string,
+	kind SpanKind,
+) (
+	context.Context,
+	*Span,
+) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{
+		SpanID:  newSpanID(),
+		Sampled: true,
+	}
+
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		// an unsampled remote parent isn't exported, this honors the caller's sampling decision
+		sc.Sampled = parent.Sampled || !parent.Remote
+	} else {
+		sc.TraceID = newTraceID()
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Name:        name,
+			Kind:        kind,
+			Start:       time.Now(),
+		},
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) export(span *SpanData) {
+	select {
+	case t.queue <- span:
+	case <-t.done:
+	default:
+		// never block the request path on a slow exporter
+		log.Printf("tracing: queue is full, dropping span %s\n", span.Name)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, t.opts.BatchSize)
+
+	exportBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := t.opts.Exporter.ExportSpans(t.opts.ServiceName, batch); err != nil {
+			log.Printf("tracing: failed to export %d spans: %s\n", len(batch), err)
+		}
+
+		batch = make([]*SpanData, 0, t.opts.BatchSize)
+	}
+
+	drain := func() {
+		for {
+			select {
+			case span := <-t.queue:
+				batch = append(batch, span)
+				if len(batch) >= t.opts.BatchSize {
+					exportBatch()
+				}
+			default:
+				exportBatch()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.opts.BatchSize {
+				exportBatch()
+			}
+		case <-ticker.C:
+			exportBatch()
+		case flushed := <-t.flush:
+			drain()
+			close(flushed)
+		case <-t.done:
+			drain()
+			return
+		}
+	}
+}
+
+// Flush exports every finished span, this blocks until the exporter returns
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+
+	flushed := make(chan struct{})
+
+	select {
+	case t.flush <- flushed:
+		<-flushed
+	case <-t.done:
+	}
+}
+
+// Close flushes the remaining spans and shuts down the exporter
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.Flush()
+
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+	<-t.stopped
+
+	return t.opts.Exporter.Shutdown()
+}
@@ -0,0 +1,268 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanID [8]byte
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	// Remote is true when the span context was propagated from another process
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C trace context `traceparent` header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C trace context `traceparent` header
+// https://www.w3.org/TR/trace-context/#traceparent-header
+func ParseTraceparent(
+	traceparent string,
+) (
+	SpanContext,
+	error,
+) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, errors.New("traceparent is malformed")
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff {
+		return SpanContext{}, errors.New("traceparent version is invalid")
+	}
+
+	// version 00 has exactly 4 fields, future versions may append more
+	if version[0] == 0 && len(parts) != 4 {
+		return SpanContext{}, errors.New("traceparent is malformed")
+	}
+
+	sc := SpanContext{
+		Remote: true,
+	}
+
+	if len(parts[1]) != 32 || strings.ToLower(parts[1]) != parts[1] {
+		return SpanContext{}, errors.New("traceparent trace-id is invalid")
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, errors.New("traceparent trace-id is invalid")
+	}
+
+	if len(parts[2]) != 16 || strings.ToLower(parts[2]) != parts[2] {
+		return SpanContext{}, errors.New("traceparent parent-id is invalid")
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, errors.New("traceparent parent-id is invalid")
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, errors.New("traceparent trace-flags are invalid")
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("traceparent ids are all zeroes")
+	}
+
+	return sc, nil
+}
+
+type SpanKind int
+
+// these match the OTLP SpanKind enum
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// these match the OTLP Status.StatusCode enum
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+type Attribute struct {
+	Key string
+	// Value is a string, bool, int64 or float64
+	Value interface{}
+}
+
+// SpanData is a finished span, handed to an Exporter
+type SpanData struct {
+	SpanContext   SpanContext
+	Parent        SpanID
+	Name          string
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// every Span method is safe to call on a nil span, which is what a nil Tracer hands out
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	switch v := value.(type) {
+	case int:
+		value = int64(v)
+	case string, bool, int64, float64:
+	default:
+		value = fmt.Sprint(v)
+	}
+
+	s.mu.Lock()
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed, a nil error is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Status = StatusError
+	s.data.StatusMessage = err.Error()
+	s.mu.Unlock()
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.export(&data)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext sets the parent for spans started from ctx, this is used for remote parents
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+// SpanContextFromContext returns the span context of the current span, or the remote parent
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+
+	return sc
+}
+
+func newTraceID() TraceID {
+	id := TraceID{}
+	rand.Read(id[:])
+
+	return id
+}
+
+func newSpanID() SpanID {
+	id := SpanID{}
+	rand.Read(id[:])
+
+	return id
+}
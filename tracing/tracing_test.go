@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Errorf("failed to parse traceparent: %s", err)
+	}
+
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unknown trace id: %s", sc.TraceID)
+	}
+
+	if sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("unknown span id: %s", sc.SpanID)
+	}
+
+	if !sc.Sampled || !sc.Remote {
+		t.Errorf("span context should be sampled and remote")
+	}
+
+	if sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("unknown traceparent: %s", sc.Traceparent())
+	}
+}
+
+func TestParseTraceparent_Invalid(t *testing.T) {
+	for _, traceparent := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(traceparent); err == nil {
+			t.Errorf("parsed an invalid traceparent: `%s`", traceparent)
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	tracer := NewTracer(
+		TracerOpts{
+			ServiceName: "test",
+			Exporter:    NewWriterExporter(buf),
+		},
+	)
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.SetAttribute("attempt", 1)
+	child.SetError(errors.New("failed"))
+	child.End()
+	parent.End()
+
+	if err := tracer.Close(); err != nil {
+		t.Errorf("failed to close tracer: %s", err)
+	}
+
+	request := otlpRequest{}
+	if err := json.Unmarshal(buf.Bytes(), &request); err != nil {
+		t.Errorf("failed to decode otlp json: %s", err)
+	}
+
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Errorf("invalid amount of spans: %d", len(spans))
+	}
+
+	if spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Errorf("unknown span order: %s %s", spans[0].Name, spans[1].Name)
+	}
+
+	if spans[0].TraceID != spans[1].TraceID {
+		t.Errorf("child wasn't in the parent's trace")
+	}
+
+	if spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("child wasn't parented: %s", spans[0].ParentSpanID)
+	}
+
+	if spans[0].Status.Code != StatusError || spans[0].Status.Message != "failed" {
+		t.Errorf("unknown child status: %v", spans[0].Status)
+	}
+
+	if *spans[0].Attributes[0].Value.IntValue != "1" {
+		t.Errorf("unknown attribute: %v", spans[0].Attributes[0])
+	}
+
+	if !strings.Contains(buf.String(), `{"key":"service.name","value":{"stringValue":"test"}}`) {
+		t.Errorf("service.name wasn't exported: `%s`", buf.String())
+	}
+}
+
+func TestTracer_RemoteParent(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	tracer := NewTracer(
+		TracerOpts{
+			Exporter: NewWriterExporter(buf),
+		},
+	)
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tracer.Start(ContextWithSpanContext(context.Background(), sc), "sampled", SpanKindServer)
+	span.End()
+
+	sc, _ = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4737-00f067aa0ba902b7-00")
+	_, span = tracer.Start(ContextWithSpanContext(context.Background(), sc), "unsampled", SpanKindServer)
+	span.End()
+
+	tracer.Close()
+
+	if !strings.Contains(buf.String(), `"traceId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"`) {
+		t.Errorf("remote trace wasn't continued: `%s`", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `"parentSpanId":"00f067aa0ba902b7"`) {
+		t.Errorf("remote parent wasn't set: `%s`", buf.String())
+	}
+
+	if strings.Contains(buf.String(), "unsampled") {
+		t.Errorf("unsampled span was exported: `%s`", buf.String())
+	}
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+
+	ctx, span := tracer.Start(context.Background(), "nil", SpanKindInternal)
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+
+	if SpanFromContext(ctx) != nil {
+		t.Errorf("nil tracer created a span?")
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Errorf("failed to close nil tracer: %s", err)
+	}
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/sabey/ddd"
+)
+
+// NewUserRepository wraps a ddd.UserRepository and creates a span for every call
+// backends that trace their own statements (repo's go-pg hook) nest them under these spans
+func NewUserRepository(
+	userRepo ddd.UserRepository,
+	tracer *Tracer,
+) *UserRepository {
+	return &UserRepository{
+		userRepo: userRepo,
+		tracer:   tracer,
+	}
+}
+
+type UserRepository struct {
+	userRepo ddd.UserRepository
+	tracer   *Tracer
+}
+
+func (ur *UserRepository) Create(
+	ctx context.Context,
+	opts ddd.UserCreate,
+) (
+	*ddd.User,
+	error,
+) {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.Create", SpanKindInternal)
+	defer span.End()
+
+	user, err := ur.userRepo.Create(ctx, opts)
+	span.SetError(err)
+
+	return user, err
+}
+
+func (ur *UserRepository) Login(
+	ctx context.Context,
+	opts ddd.UserLogin,
+) (
+	*ddd.User,
+	error,
+) {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.Login", SpanKindInternal)
+	defer span.End()
+
+	user, err := ur.userRepo.Login(ctx, opts)
+	span.SetError(err)
+
+	return user, err
+}
+
+func (ur *UserRepository) Get(
+	ctx context.Context,
+	email string,
+) (
+	*ddd.User,
+	error,
+) {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.Get", SpanKindInternal)
+	defer span.End()
+
+	user, err := ur.userRepo.Get(ctx, email)
+	span.SetError(err)
+
+	return user, err
+}
+
+func (ur *UserRepository) List(
+	ctx context.Context,
+	opts ddd.UserList,
+) (
+	[]*ddd.User,
+	error,
+) {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.List", SpanKindInternal)
+	defer span.End()
+
+	users, err := ur.userRepo.List(ctx, opts)
+	span.SetError(err)
+
+	return users, err
+}
+
+func (ur *UserRepository) Update(
+	ctx context.Context,
+	opts ddd.UserUpdate,
+) (
+	*ddd.User,
+	error,
+) {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.Update", SpanKindInternal)
+	defer span.End()
+
+	user, err := ur.userRepo.Update(ctx, opts)
+	span.SetError(err)
+
+	return user, err
+}
+
+func (ur *UserRepository) Delete(
+	ctx context.Context,
+	email string,
+) error {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.Delete", SpanKindInternal)
+	defer span.End()
+
+	err := ur.userRepo.Delete(ctx, email)
+	span.SetError(err)
+
+	return err
+}
+
+func (ur *UserRepository) Import(
+	ctx context.Context,
+	opts ddd.UserImport,
+) (
+	[]ddd.ImportResult,
+	error,
+) {
+	ctx, span := ur.tracer.Start(ctx, "UserRepository.Import", SpanKindInternal)
+	defer span.End()
+
+	results, err := ur.userRepo.Import(ctx, opts)
+	span.SetError(err)
+
+	return results, err
+}
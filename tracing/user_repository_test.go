@@ -0,0 +1,26 @@
+package tracing
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/sabey/ddd"
+	"github.com/sabey/ddd/conformance"
+	"github.com/sabey/ddd/mock"
+)
+
+func TestUserRepository_Conformance(t *testing.T) {
+	conformance.RunUserRepository(t, func(t *testing.T) ddd.UserRepository {
+		tracer := NewTracer(
+			TracerOpts{
+				Exporter: NewWriterExporter(ioutil.Discard),
+			},
+		)
+
+		t.Cleanup(func() {
+			tracer.Close()
+		})
+
+		return NewUserRepository(mock.NewUserRepository(), tracer)
+	})
+}
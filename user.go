@@ -1,9 +1,23 @@
 package ddd
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"regexp"
+	"strings"
+)
+
+// every UserRepository returns these, callers can match them with errors.Is
+var (
+	ErrUserExists      = errors.New("user account already exists")
+	ErrUserNotFound    = errors.New("user account doesn't exist")
+	ErrInvalidPassword = errors.New("password is invalid")
+	// ErrVersionMismatch is returned by Update when the account changed since the expected version was read
+	ErrVersionMismatch = errors.New("user account was modified")
+	// ErrUserDisabled is returned by Login for a disabled account, after the password was checked
+	ErrUserDisabled = errors.New("user account is disabled")
 )
 
 func HashPassword(password string) string {
@@ -14,26 +28,151 @@ func HashPassword(password string) string {
 }
 
 type User struct {
-	Email     string
-	FirstName string
-	LastName  string
+	// Organization is the ID of the organization the account belongs to, its tenant
+	Organization string
+	Email        string
+	FirstName    string
+	LastName     string
 	// this password field is not necessary, but we're using it as storage in the mock
 	// this would be necessary if we verified the pw outside of the repos
 	Password string
+	// the profile fields are optional
+	DisplayName string
+	// Locale is a BCP 47 language tag
+	Locale string
+	// Timezone is an IANA time zone name
+	Timezone string
+	// Attributes are application specific, their keys and types are declared in the Attributes registry
+	Attributes map[string]interface{}
+	// Avatar identifies the uploaded avatar's images in the BlobStore, empty when there isn't one
+	Avatar string
+	// Admin can manage every account
+	Admin bool
+	// Disabled accounts can't log in
+	Disabled bool
+	// Version starts at 1 and is incremented by every update
+	Version int64
+}
+
+// Clone copies the user including its attributes, so a stored user can't be changed through a returned one
+func (u User) Clone() User {
+	u.Attributes = copyAttributes(u.Attributes)
+
+	return u
 }
 
+// every method must give up and return ctx.Err() once the context is cancelled or past its deadline
+// every method is scoped to the context's tenant (WithTenant), an account of another organization doesn't exist
+// the tenant's organization isn't checked, accounts are only created in other organizations through invitations
 type UserRepository interface {
-	Create(UserCreate) (*User, error)
-	Login(UserLogin) (*User, error)
-	List() ([]*User, error)
-	Update(UserUpdate) (*User, error)
+	Create(context.Context, UserCreate) (*User, error)
+	Login(context.Context, UserLogin) (*User, error)
+	// Get looks up an account by email, it doesn't check credentials
+	Get(ctx context.Context, email string) (*User, error)
+	// List returns accounts ordered by email
+	List(context.Context, UserList) ([]*User, error)
+	Update(context.Context, UserUpdate) (*User, error)
+	Delete(ctx context.Context, email string) error
+	// Import creates a batch of accounts, a user that fails doesn't fail the others
+	// the error is for the batch, the results are only valid without one
+	Import(context.Context, UserImport) ([]ImportResult, error)
+}
+
+// ValidateEmail is used by the repository methods that take a bare email
+func ValidateEmail(email string) error {
+	if email == "" {
+		return errors.New("email was empty")
+	}
+
+	return nil
+}
+
+// UserList pages through the accounts by email, the zero value lists every account
+type UserList struct {
+	// After is the last email of the previous page, only accounts after it are listed
+	After string
+	// Limit is the page size, zero means no limit
+	Limit int
+	// Emails only lists these accounts, a batch of lookups is one query
+	Emails []string
+	// Search only lists accounts whose email, names or display name contain it, ignoring case
+	Search string
+	// Admin only lists admins when it's true, or the other accounts when it's false
+	Admin *bool
+}
+
+// maxSearchLength bounds the LIKE pattern
+const maxSearchLength = 100
+
+func (ul UserList) Validate() error {
+	if ul.Limit < 0 {
+		return errors.New("limit was invalid")
+	}
+
+	if len(ul.Search) > maxSearchLength {
+		return errors.New("search was too long")
+	}
+
+	return nil
+}
+
+// Matches applies Emails, Search and Admin to a user, it's how the repositories that don't query a database filter
+func (ul UserList) Matches(user *User) bool {
+	if len(ul.Emails) > 0 {
+		found := false
+		for _, email := range ul.Emails {
+			if email == user.Email {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if ul.Admin != nil && *ul.Admin != user.Admin {
+		return false
+	}
+
+	if ul.Search != "" {
+		search := strings.ToLower(ul.Search)
+
+		for _, field := range []string{user.Email, user.FirstName, user.LastName, user.DisplayName} {
+			if strings.Contains(strings.ToLower(field), search) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// SearchPattern is Search as a LIKE pattern, its wildcards are escaped with a backslash
+func (ul UserList) SearchPattern() string {
+	return "%" + likeEscaper.Replace(ul.Search) + "%"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Bool returns a pointer to v, for UserList's Admin
+func Bool(v bool) *bool {
+	return &v
 }
 
 type UserCreate struct {
-	Email     string
-	FirstName string
-	LastName  string
-	Password  string
+	Email       string
+	FirstName   string
+	LastName    string
+	Password    string
+	DisplayName string
+	Locale      string
+	Timezone    string
+	Attributes  map[string]interface{}
+	Admin       bool
 }
 
 func (uc UserCreate) Validate() error {
@@ -53,7 +192,11 @@ func (uc UserCreate) Validate() error {
 		return errors.New("password was empty")
 	}
 
-	return nil
+	if err := validateProfile(&uc.DisplayName, &uc.Locale, &uc.Timezone); err != nil {
+		return err
+	}
+
+	return Attributes.Validate(uc.Attributes)
 }
 
 type UserLogin struct {
@@ -75,9 +218,24 @@ func (ul UserLogin) Validate() error {
 
 type UserUpdate struct {
 	// email is required, or an ID to update the correct account
-	Email     string
-	FirstName string
-	LastName  string
+	Email string
+	// nil fields are left as they are, at least one has to be set
+	FirstName *string
+	LastName  *string
+	// an empty display name, locale or timezone clears it
+	DisplayName *string
+	Locale      *string
+	Timezone    *string
+	// Attributes are merged into the stored attributes, a nil value removes the key
+	Attributes map[string]interface{}
+	// an empty avatar removes it
+	Avatar *string
+	// Password is already hashed, like UserCreate's
+	Password *string
+	Disabled *bool
+	// Version is the version the caller expects to overwrite, the update fails with ErrVersionMismatch when it changed
+	// zero updates whatever version is stored
+	Version int64
 }
 
 func (uu UserUpdate) Validate() error {
@@ -85,13 +243,48 @@ func (uu UserUpdate) Validate() error {
 		return errors.New("email was empty")
 	}
 
-	if uu.FirstName == "" {
+	if uu.Empty() {
+		return errors.New("nothing to update")
+	}
+
+	if uu.FirstName != nil && *uu.FirstName == "" {
 		return errors.New("firstName was empty")
 	}
 
-	if uu.LastName == "" {
+	if uu.LastName != nil && *uu.LastName == "" {
 		return errors.New("lastName was empty")
 	}
 
-	return nil
+	if uu.Password != nil && *uu.Password == "" {
+		return errors.New("password was empty")
+	}
+
+	if uu.Avatar != nil && !avatarRegexp.MatchString(*uu.Avatar) {
+		return errors.New("avatar was invalid")
+	}
+
+	if uu.Version < 0 {
+		return errors.New("version was invalid")
+	}
+
+	if err := validateProfile(uu.DisplayName, uu.Locale, uu.Timezone); err != nil {
+		return err
+	}
+
+	return Attributes.validate(uu.Attributes, true)
+}
+
+// Empty is true when the update doesn't set any field
+func (uu UserUpdate) Empty() bool {
+	return uu.FirstName == nil && uu.LastName == nil &&
+		uu.DisplayName == nil && uu.Locale == nil && uu.Timezone == nil &&
+		len(uu.Attributes) == 0 && uu.Avatar == nil &&
+		uu.Password == nil && uu.Disabled == nil
+}
+
+var avatarRegexp = regexp.MustCompile(`^[a-zA-Z0-9]{0,64}$`)
+
+// String returns a pointer to v, for UserUpdate's optional fields
+func String(v string) *string {
+	return &v
 }